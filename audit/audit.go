@@ -0,0 +1,271 @@
+// Package audit provides a structured audit log separate from the application log.
+// Each audit event is written as a single JSON object with a stable, versioned
+// schema to a file with rotation, to a syslog server or to stdout
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+const (
+	logSender = "audit"
+	// SchemaVersion is the version of the audit events schema.
+	// It is incremented only for incompatible changes, new fields can be added
+	// without a version change
+	SchemaVersion = 1
+)
+
+// Supported outputs
+const (
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+	OutputStdout = "stdout"
+)
+
+// Supported event types
+const (
+	EventLogin       = "login"
+	EventLogout      = "logout"
+	EventUpload      = "upload"
+	EventDownload    = "download"
+	EventFsCommand   = "fs_command"
+	EventAdminAction = "admin_action"
+	EventShareAccess = "share_access"
+)
+
+// Supported event statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+var (
+	supportedOutputs = []string{OutputFile, OutputSyslog, OutputStdout}
+	auditLogger      = &sink{}
+)
+
+// SyslogConfig defines the configuration to send the audit events to a syslog server
+type SyslogConfig struct {
+	// Network to use to connect to the syslog server: "udp", "tcp", "tls" or "unix"
+	Network string `json:"network" mapstructure:"network"`
+	// Address of the syslog server, for example "127.0.0.1:514" or "/dev/log"
+	Address string `json:"address" mapstructure:"address"`
+	// Syslog facility, for example "local0"
+	Facility string `json:"facility" mapstructure:"facility"`
+	// Application name to use in the syslog messages
+	AppName string `json:"app_name" mapstructure:"app_name"`
+}
+
+// Config defines the audit log configuration
+type Config struct {
+	// Where to write the audit events: "file", "syslog" or "stdout".
+	// Empty means disabled
+	Output string `json:"output" mapstructure:"output"`
+	// Absolute path to the audit log file, used if the output is "file"
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size in megabytes of the audit log file before it gets rotated
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of old audit log files to retain
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain old audit log files
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Set to true to compress the rotated audit log files
+	Compress bool `json:"compress" mapstructure:"compress"`
+	// Syslog configuration, used if the output is "syslog"
+	Syslog SyslogConfig `json:"syslog" mapstructure:"syslog"`
+	// Maximum number of audit events to buffer while they are written.
+	// New events are dropped if the buffer is full. 0 means the default
+	BufferSize int `json:"buffer_size" mapstructure:"buffer_size"`
+}
+
+// IsEnabled returns true if the audit log is enabled
+func (c *Config) IsEnabled() bool {
+	return c.Output != ""
+}
+
+func (c *Config) validate() error {
+	if !util.IsStringInSlice(c.Output, supportedOutputs) {
+		return fmt.Errorf("unsupported audit log output %#v", c.Output)
+	}
+	if c.Output == OutputFile {
+		if !filepath.IsAbs(c.FilePath) {
+			return fmt.Errorf("invalid audit log file path %#v, it must be an absolute path", c.FilePath)
+		}
+		if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+			return errors.New("invalid audit log file rotation settings, negative values are not allowed")
+		}
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("invalid audit log buffer size %v", c.BufferSize)
+	}
+	return nil
+}
+
+func (c *Config) getWriter() (io.WriteCloser, error) {
+	switch c.Output {
+	case OutputFile:
+		if err := os.MkdirAll(filepath.Dir(c.FilePath), 0700); err != nil {
+			return nil, fmt.Errorf("unable to create the audit log dir: %w", err)
+		}
+		return &lumberjack.Logger{
+			Filename:   c.FilePath,
+			MaxSize:    c.MaxSize,
+			MaxBackups: c.MaxBackups,
+			MaxAge:     c.MaxAge,
+			Compress:   c.Compress,
+		}, nil
+	case OutputSyslog:
+		return logger.NewSyslogWriter(c.Syslog.Network, c.Syslog.Address, c.Syslog.Facility, c.Syslog.AppName, nil)
+	default:
+		return nopCloser{os.Stdout}, nil
+	}
+}
+
+// Event defines an audit event. Empty fields are omitted.
+// Paths are virtual paths, as seen by the users
+type Event struct {
+	SchemaVersion int    `json:"schema_version"`
+	Timestamp     string `json:"timestamp"`
+	Event         string `json:"event"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	// User or admin that performed the action
+	Username     string `json:"username,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	ConnectionID string `json:"connection_id,omitempty"`
+	LoginMethod  string `json:"login_method,omitempty"`
+	// Command for fs_command events, operation (add, update, delete) for
+	// admin_action events, requested scope (read, write) for share_access events
+	Action     string `json:"action,omitempty"`
+	Path       string `json:"path,omitempty"`
+	TargetPath string `json:"target_path,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	ElapsedMs  int64  `json:"elapsed_ms,omitempty"`
+	ObjectType string `json:"object_type,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+	ShareID    string `json:"share_id,omitempty"`
+}
+
+// SetError sets the status and the error based on the given error
+func (e *Event) SetError(err error) {
+	if err == nil {
+		e.Status = StatusSuccess
+		e.Error = ""
+		return
+	}
+	e.Status = StatusFailure
+	e.Error = err.Error()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// sink holds the current audit log writer. The events are written
+// asynchronously so logging an event never blocks on the output
+type sink struct {
+	sync.RWMutex
+	writer io.WriteCloser
+}
+
+func (s *sink) isEnabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.writer != nil
+}
+
+func (s *sink) setWriter(w io.WriteCloser) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var err error
+	if s.writer != nil {
+		err = s.writer.Close()
+	}
+	s.writer = w
+	return err
+}
+
+func (s *sink) write(data []byte) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.writer == nil {
+		return nil
+	}
+	_, err := s.writer.Write(data)
+	return err
+}
+
+// Initialize configures the audit log, the previous configuration, if any,
+// is replaced
+func Initialize(c Config) error {
+	if !c.IsEnabled() {
+		return auditLogger.setWriter(nil)
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	w, err := c.getWriter()
+	if err != nil {
+		return err
+	}
+	if err := auditLogger.setWriter(logger.NewBufferedWriter(w, c.BufferSize, "audit log")); err != nil {
+		logger.Warn(logSender, "", "unable to close the previous audit log writer: %v", err)
+	}
+	logger.Debug(logSender, "", "audit log initialized, output: %#v", c.Output)
+	return nil
+}
+
+// Close flushes and closes the audit log, the queued events are written
+// waiting up to 5 seconds
+func Close() error {
+	return auditLogger.setWriter(nil)
+}
+
+// IsEnabled returns true if the audit log is enabled.
+// It allows to avoid building events that will be discarded
+func IsEnabled() bool {
+	return auditLogger.isEnabled()
+}
+
+// Log writes the given event to the audit log, if enabled.
+// The schema version and the timestamp are automatically set.
+// If the status is empty it is set to success
+func Log(ev Event) {
+	if !IsEnabled() {
+		return
+	}
+	ev.SchemaVersion = SchemaVersion
+	ev.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	if ev.Status == "" {
+		ev.Status = StatusSuccess
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		logger.Error(logSender, ev.ConnectionID, "unable to marshal audit event %+v: %v", ev, err)
+		return
+	}
+	data = append(data, '\n')
+	if err := auditLogger.write(data); err != nil {
+		logger.Error(logSender, ev.ConnectionID, "unable to write audit event %#v: %v", ev.Event, err)
+	}
+}
@@ -0,0 +1,259 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	assert.False(t, c.IsEnabled())
+	assert.NoError(t, Initialize(c))
+	assert.False(t, IsEnabled())
+
+	c.Output = "invalid"
+	assert.Error(t, Initialize(c))
+	c.Output = OutputFile
+	c.FilePath = "relative.log"
+	assert.Error(t, Initialize(c))
+	c.FilePath = filepath.Join(os.TempDir(), "audit.log")
+	c.MaxSize = -1
+	assert.Error(t, Initialize(c))
+	c.MaxSize = 0
+	c.BufferSize = -1
+	assert.Error(t, Initialize(c))
+	c.BufferSize = 0
+	c.Output = OutputSyslog
+	c.Syslog.Network = "invalid"
+	assert.Error(t, Initialize(c))
+	c.Syslog.Network = "udp"
+	assert.Error(t, Initialize(c))
+	c.Syslog.Address = "127.0.0.1:514"
+	c.Syslog.Facility = "invalid"
+	assert.Error(t, Initialize(c))
+	assert.False(t, IsEnabled())
+
+	c.Output = OutputStdout
+	assert.NoError(t, Initialize(c))
+	assert.True(t, IsEnabled())
+	assert.NoError(t, Close())
+	assert.False(t, IsEnabled())
+}
+
+func TestFileOutput(t *testing.T) {
+	logFilePath := filepath.Join(os.TempDir(), "audit", "audit.log")
+	defer os.RemoveAll(filepath.Dir(logFilePath))
+
+	err := Initialize(Config{
+		Output:   OutputFile,
+		FilePath: logFilePath,
+		MaxSize:  10,
+	})
+	require.NoError(t, err)
+	Log(Event{
+		Event:        EventUpload,
+		Username:     "user",
+		IP:           "127.0.0.1",
+		Protocol:     "SFTP",
+		ConnectionID: "id",
+		Path:         "/file",
+		Size:         100,
+		ElapsedMs:    10,
+	})
+	ev := Event{
+		Event:       EventLogin,
+		Username:    "user",
+		LoginMethod: "password",
+	}
+	ev.SetError(errors.New("invalid credentials"))
+	Log(ev)
+	assert.NoError(t, Close())
+	// the events are discarded if the audit log is disabled
+	Log(Event{Event: EventLogout})
+
+	f, err := os.Open(logFilePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		assert.NoError(t, err)
+		events = append(events, e)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, SchemaVersion, events[0].SchemaVersion)
+	assert.Equal(t, EventUpload, events[0].Event)
+	assert.Equal(t, StatusSuccess, events[0].Status)
+	assert.Empty(t, events[0].Error)
+	assert.Equal(t, "/file", events[0].Path)
+	assert.Equal(t, int64(100), events[0].Size)
+	_, err = time.Parse(time.RFC3339Nano, events[0].Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, EventLogin, events[1].Event)
+	assert.Equal(t, StatusFailure, events[1].Status)
+	assert.Equal(t, "invalid credentials", events[1].Error)
+	assert.Equal(t, "password", events[1].LoginMethod)
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+	mu      sync.Mutex
+	events  int
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.events++
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func TestAsyncWrite(t *testing.T) {
+	w := &blockingWriter{
+		unblock: make(chan struct{}),
+	}
+	err := auditLogger.setWriter(logger.NewBufferedWriter(w, 10, "audit log"))
+	require.NoError(t, err)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 5; i++ {
+			Log(Event{Event: EventLogin, Username: "user"})
+		}
+		close(done)
+	}()
+	// a blocked output does not block the callers
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "audit events blocked by a slow output")
+	}
+	close(w.unblock)
+	assert.NoError(t, Close())
+	w.mu.Lock()
+	assert.Equal(t, 5, w.events)
+	w.mu.Unlock()
+}
+
+func TestSyslogUDPOutput(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = Initialize(Config{
+		Output: OutputSyslog,
+		Syslog: SyslogConfig{
+			Network:  "udp",
+			Address:  conn.LocalAddr().String(),
+			Facility: "local0",
+			AppName:  "sftpgo_test",
+		},
+	})
+	require.NoError(t, err)
+	defer Close() //nolint:errcheck
+
+	Log(Event{
+		Event:      EventAdminAction,
+		Username:   "admin",
+		Action:     "add",
+		ObjectType: "user",
+		ObjectName: "user1",
+	})
+	buf := make([]byte, 4096)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// local0 and informational severity
+	assert.True(t, strings.HasPrefix(msg, "<134>1 "), msg)
+	assert.Contains(t, msg, " sftpgo_test ")
+	idx := strings.Index(msg, "{")
+	require.Greater(t, idx, 0)
+	var ev Event
+	err = json.Unmarshal([]byte(msg[idx:]), &ev)
+	assert.NoError(t, err)
+	assert.Equal(t, EventAdminAction, ev.Event)
+	assert.Equal(t, "user1", ev.ObjectName)
+}
+
+func TestSyslogTCPOutput(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return
+		}
+		messages <- string(buf)
+	}()
+
+	err = Initialize(Config{
+		Output: OutputSyslog,
+		Syslog: SyslogConfig{
+			Network:  "tcp",
+			Address:  listener.Addr().String(),
+			Facility: "auth",
+		},
+	})
+	require.NoError(t, err)
+	defer Close() //nolint:errcheck
+
+	Log(Event{
+		Event:    EventShareAccess,
+		Username: "user",
+		Action:   "read",
+		ShareID:  "shareid",
+	})
+	select {
+	case msg := <-messages:
+		assert.True(t, strings.HasPrefix(msg, "<38>1 "), msg)
+		assert.Contains(t, msg, " sftpgo ")
+		assert.True(t, strings.HasSuffix(msg, "}"), msg)
+		assert.Contains(t, msg, `"share_id":"shareid"`)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "syslog message not received")
+	}
+}
@@ -0,0 +1,12 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Validate and inspect the configuration",
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+}
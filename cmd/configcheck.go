@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/v2/config"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/kms"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+var (
+	configCheckSkipProvider bool
+	configCheckCmd          = &cobra.Command{
+		Use:   "check",
+		Short: "Validate the configuration",
+		Long: `This command loads the configuration from the configuration file and the
+environment variables and validates it without starting any service.
+
+The following checks are performed:
+
+- the configuration file can be parsed and no setting is replaced with its
+  default because it is invalid
+- the bindings have valid ports and don't conflict with each other
+- the TLS certificates, private keys, CA certificates and revocation lists can
+  be loaded
+- the hooks are HTTP URLs or absolute paths to executable files
+- the plugins have a valid configuration and their executables exist and match
+  the configured checksum, if any
+- the KMS master key can be used to encrypt and decrypt secrets
+- the data provider is reachable and its schema version is the required one.
+  The data provider is opened read-only, it is not initialized or updated by
+  this command, use "sftpgo initprovider" for that
+
+The exit code is 0 if no problem is detected and 1 otherwise, the detected
+problems are printed to stdout.
+
+To validate the configuration from the configuration directory simply use:
+
+$ sftpgo config check
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			configDir = util.CleanDirInput(configDir)
+			if err := config.LoadConfig(configDir, configFile); err != nil {
+				fmt.Printf("Unable to load the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Checking configuration, config file: %#v\n", viper.ConfigFileUsed())
+			problems := config.CheckConfig(configDir)
+			if err := checkKMSConfig(); err != nil {
+				problems = append(problems, fmt.Errorf("kms: %w", err))
+			}
+			if !configCheckSkipProvider {
+				if err := checkProviderConfig(); err != nil {
+					problems = append(problems, fmt.Errorf("data_provider: %w", err))
+				}
+			}
+			if len(problems) == 0 {
+				fmt.Println("No problems detected")
+				return
+			}
+			for _, p := range problems {
+				fmt.Printf("- %v\n", p)
+			}
+			fmt.Printf("%d problem(s) detected\n", len(problems))
+			os.Exit(1)
+		},
+	}
+)
+
+func checkKMSConfig() error {
+	kmsConfig := config.GetKMSConfig()
+	if err := kmsConfig.Initialize(); err != nil {
+		return err
+	}
+	secret := kms.NewPlainSecret("sftpgo config check")
+	if err := secret.Encrypt(); err != nil {
+		return fmt.Errorf("unable to encrypt a test secret: %w", err)
+	}
+	if err := secret.Decrypt(); err != nil {
+		return fmt.Errorf("unable to decrypt a test secret: %w", err)
+	}
+	return nil
+}
+
+func checkProviderConfig() error {
+	// the database is opened read-only, it is not initialized or migrated
+	return dataprovider.CheckSchemaVersion(config.GetProviderConf(), configDir)
+}
+
+func init() {
+	addConfigFlags(configCheckCmd)
+	configCheckCmd.Flags().BoolVar(&configCheckSkipProvider, "skip-provider", false,
+		`Don't check the data provider, useful if the
+database is not reachable from where the check
+runs`)
+
+	configCmd.AddCommand(configCheckCmd)
+}
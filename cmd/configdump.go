@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/config"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+var (
+	configDumpFormat string
+	configDumpCmd    = &cobra.Command{
+		Use:   "dump",
+		Short: "Print the effective configuration",
+		Long: `This command prints the effective configuration, the result of merging the
+defaults, the configuration file and the environment variables, to stdout.
+
+Passwords, keys, header values and credentials embedded in URLs are redacted,
+so the output can be safely stored or compared, for example to review the
+changes before a rollout.
+
+The supported formats are "json", "yaml" and "env". The "env" format prints
+the environment variables needed to reproduce the configuration.
+
+To print the effective configuration as YAML use:
+
+$ sftpgo config dump --format yaml
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			configDir = util.CleanDirInput(configDir)
+			if err := config.LoadConfig(configDir, configFile); err != nil {
+				logger.EnableConsoleLogger(zerolog.DebugLevel)
+				logger.ErrorToConsole("Unable to load the configuration: %v", err)
+				os.Exit(1)
+			}
+			if err := config.DumpConfig(os.Stdout, configDumpFormat); err != nil {
+				logger.EnableConsoleLogger(zerolog.DebugLevel)
+				logger.ErrorToConsole("Unable to dump the configuration: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(configDumpCmd)
+	configDumpCmd.Flags().StringVar(&configDumpFormat, "format", config.DumpFormatJSON,
+		`Output format. Supported values: "json",
+"yaml", "env"`)
+
+	configCmd.AddCommand(configDumpCmd)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/config"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+var (
+	dataTypes []string
+	dataNames []string
+	dataCmd   = &cobra.Command{
+		Use:   "data",
+		Short: "Export and import users, folders, admins, API keys and shares",
+	}
+)
+
+// initDataProvider loads the configuration and initializes the KMS, the
+// plugins and the configured data provider, no service is started
+func initDataProvider() error {
+	configDir = util.CleanDirInput(configDir)
+	if err := config.LoadConfig(configDir, configFile); err != nil {
+		return fmt.Errorf("unable to load the configuration: %w", err)
+	}
+	kmsConfig := config.GetKMSConfig()
+	if err := kmsConfig.Initialize(); err != nil {
+		return fmt.Errorf("unable to initialize KMS: %w", err)
+	}
+	if err := plugin.Initialize(config.GetPluginsConfig(), false); err != nil {
+		return fmt.Errorf("unable to initialize plugin system: %w", err)
+	}
+	if err := dataprovider.Initialize(config.GetProviderConf(), configDir, false); err != nil {
+		return fmt.Errorf("unable to initialize the data provider: %w", err)
+	}
+	return nil
+}
+
+func closeDataProvider() {
+	dataprovider.Close() //nolint:errcheck
+	plugin.Handler.Cleanup()
+}
+
+func getDataFilter() (dataprovider.BackupFilter, error) {
+	filter := dataprovider.BackupFilter{
+		Types: util.RemoveDuplicates(dataTypes),
+		Names: util.RemoveDuplicates(dataNames),
+	}
+	return filter, filter.Validate()
+}
+
+func addDataFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&dataTypes, "types", nil,
+		fmt.Sprintf(`Comma separated object types to include.
+Supported values: %v.
+Empty means all the object types`, strings.Join(dataprovider.BackupObjectTypes, ", ")))
+	cmd.Flags().StringSliceVar(&dataNames, "names", nil,
+		`Comma separated shell patterns, for example
+"user*", the object names must match. Users
+and admins are matched by username, folders
+by name, API keys and shares by name or ID.
+Empty means all the objects`)
+}
+
+func init() {
+	rootCmd.AddCommand(dataCmd)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+var (
+	dataExportOutputFile string
+	dataExportIndent     bool
+	dataExportCmd        = &cobra.Command{
+		Use:   "export",
+		Short: "Export data from the configured data provider",
+		Long: `This command reads the users, folders, admins, API keys and shares directly
+from the configured data provider and writes them to stdout or to the specified
+file. The SFTPGo service does not need to be running.
+
+The output has the same format as the "dumpdata" REST API and can be restored
+using "sftpgo data import", the "loaddata" REST API or the "--loaddata-from"
+flag of the "serve" command.
+
+Object types and names can be filtered, for example to export the users whose
+username starts with "test" use:
+
+$ sftpgo data export --types users --names "test*" --output-file backup.json
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			if err := exportData(); err != nil {
+				logger.EnableConsoleLogger(zerolog.DebugLevel)
+				logger.ErrorToConsole("Unable to export data: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func exportData() error {
+	filter, err := getDataFilter()
+	if err != nil {
+		return err
+	}
+	if dataExportOutputFile != "" && !util.IsFileInputValid(dataExportOutputFile) {
+		return fmt.Errorf("invalid output file %#v", dataExportOutputFile)
+	}
+	if err := initDataProvider(); err != nil {
+		return err
+	}
+	defer closeDataProvider()
+
+	backup, err := dataprovider.DumpData()
+	if err != nil {
+		return err
+	}
+	filter.Apply(&backup)
+	var dump []byte
+	if dataExportIndent {
+		dump, err = json.MarshalIndent(backup, "", "  ")
+	} else {
+		dump, err = json.Marshal(backup)
+	}
+	if err != nil {
+		return err
+	}
+	if dataExportOutputFile == "" {
+		_, err = fmt.Println(string(dump))
+		return err
+	}
+	return os.WriteFile(dataExportOutputFile, dump, 0600)
+}
+
+func init() {
+	addConfigFlags(dataExportCmd)
+	addDataFilterFlags(dataExportCmd)
+	dataExportCmd.Flags().StringVarP(&dataExportOutputFile, "output-file", "o", "",
+		`Path to the file to write, it contains
+passwords hashes and encrypted secrets and it
+is created with 0600 permissions. Leave empty
+to write to stdout`)
+	dataExportCmd.Flags().BoolVar(&dataExportIndent, "indent", false, "Indent the JSON output")
+
+	dataCmd.AddCommand(dataExportCmd)
+}
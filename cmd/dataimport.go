@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/httpd"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+var (
+	dataImportInputFile string
+	dataImportMode      int
+	dataImportDelete    bool
+	dataImportDryRun    bool
+	dataImportCmd       = &cobra.Command{
+		Use:   "import",
+		Short: "Import data into the configured data provider",
+		Long: `This command restores the users, folders, admins, API keys and shares from
+the specified backup file directly into the configured data provider. The
+SFTPGo service does not need to be running. The backup file can be generated
+using "sftpgo data export" or the "dumpdata" REST API.
+
+The changes to apply are printed before applying them:
+
+- "+" the object will be created
+- "~" the existing object will be updated
+- "=" the existing object is different but it will not be updated, mode 1
+- "-" the existing object will be deleted, "--delete" flag
+
+Unchanged objects are not updated. Use the "--dry-run" flag to only print the
+changes, this way you can review them, for example in CI, before applying.
+
+The same object types and names filters are applied to the backup file and to
+the existing objects, so the "--delete" flag only removes matching objects.
+The "--delete" flag requires the "--types" flag, this way the object types to
+delete are always explicit. The import is refused if it would delete all the
+admins.
+
+To migrate from an SQLite to a PostgreSQL data provider you can export the
+data using the SQLite configuration and then import them using the PostgreSQL
+configuration, for example:
+
+$ sftpgo data export --config-dir /etc/sftpgo-sqlite --output-file backup.json
+$ sftpgo data import --config-dir /etc/sftpgo-pgsql --input-file backup.json
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.DebugLevel)
+			if err := importData(); err != nil {
+				logger.ErrorToConsole("Unable to import data: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func importData() error {
+	filter, err := getDataFilter()
+	if err != nil {
+		return err
+	}
+	if dataImportMode < 0 || dataImportMode > 2 {
+		return fmt.Errorf("invalid mode %v", dataImportMode)
+	}
+	if dataImportDelete && len(filter.Types) == 0 {
+		return errors.New("the \"--delete\" flag requires the object types to delete, use the \"--types\" flag")
+	}
+	dump, err := readDataImportFile()
+	if err != nil {
+		return err
+	}
+	if err := initDataProvider(); err != nil {
+		return err
+	}
+	defer closeDataProvider()
+
+	diff, err := dataprovider.GetBackupDiff(&dump, &filter, dataImportMode, dataImportDelete)
+	if err != nil {
+		return err
+	}
+	printDataImportDiff(diff)
+	if dataImportDryRun || len(diff) == 0 {
+		return nil
+	}
+	changes := dataprovider.GetBackupDiffObjects(&dump, diff, dataprovider.BackupDiffCreate,
+		dataprovider.BackupDiffUpdate)
+	if err := restoreDataImportChanges(&changes, dataImportMode); err != nil {
+		return err
+	}
+	if err := deleteDataImportObjects(diff); err != nil {
+		return err
+	}
+	logger.InfoToConsole("Data successfully imported from file %#v", dataImportInputFile)
+	return nil
+}
+
+func readDataImportFile() (dataprovider.BackupData, error) {
+	if dataImportInputFile == "" || !util.IsFileInputValid(dataImportInputFile) {
+		return dataprovider.BackupData{}, fmt.Errorf("invalid input file %#v", dataImportInputFile)
+	}
+	info, err := os.Stat(dataImportInputFile)
+	if err != nil {
+		return dataprovider.BackupData{}, err
+	}
+	if info.Size() > httpd.MaxRestoreSize {
+		return dataprovider.BackupData{}, fmt.Errorf("unable to restore input file %#v size too big: %v/%v bytes",
+			dataImportInputFile, info.Size(), httpd.MaxRestoreSize)
+	}
+	content, err := os.ReadFile(dataImportInputFile)
+	if err != nil {
+		return dataprovider.BackupData{}, fmt.Errorf("unable to read input file %#v: %w", dataImportInputFile, err)
+	}
+	dump, err := dataprovider.ParseDumpData(content)
+	if err != nil {
+		return dump, fmt.Errorf("unable to parse input file %#v: %w", dataImportInputFile, err)
+	}
+	return dump, nil
+}
+
+func printDataImportDiff(diff []dataprovider.BackupDiffEntry) {
+	if len(diff) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	counters := make(map[string]int)
+	for _, entry := range diff {
+		var symbol string
+		switch entry.Action {
+		case dataprovider.BackupDiffCreate:
+			symbol = "+"
+		case dataprovider.BackupDiffUpdate:
+			symbol = "~"
+		case dataprovider.BackupDiffSkip:
+			symbol = "="
+		default:
+			symbol = "-"
+		}
+		fmt.Printf("%v %v %#v\n", symbol, entry.Type, entry.Name)
+		counters[entry.Action]++
+	}
+	fmt.Printf("%d to create, %d to update, %d to delete, %d existing not updated\n",
+		counters[dataprovider.BackupDiffCreate], counters[dataprovider.BackupDiffUpdate],
+		counters[dataprovider.BackupDiffDelete], counters[dataprovider.BackupDiffSkip])
+}
+
+func restoreDataImportChanges(changes *dataprovider.BackupData, mode int) error {
+	executor := dataprovider.ActionExecutorSystem
+	// the changes only include objects to create or update, mode 1 is already
+	// handled computing the diff
+	if mode == 1 {
+		mode = 0
+	}
+	err := httpd.RestoreFolders(changes.Folders, dataImportInputFile, mode, 0, executor, "")
+	if err != nil {
+		return err
+	}
+	err = httpd.RestoreUsers(changes.Users, dataImportInputFile, mode, 0, executor, "")
+	if err != nil {
+		return err
+	}
+	err = httpd.RestoreAdmins(changes.Admins, dataImportInputFile, mode, executor, "")
+	if err != nil {
+		return err
+	}
+	err = httpd.RestoreAPIKeys(changes.APIKeys, dataImportInputFile, mode, executor, "")
+	if err != nil {
+		return err
+	}
+	return httpd.RestoreShares(changes.Shares, dataImportInputFile, mode, executor, "")
+}
+
+// deleteDataImportObjects removes the objects not included in the backup file,
+// dependent objects are removed first
+func deleteDataImportObjects(diff []dataprovider.BackupDiffEntry) error {
+	executor := dataprovider.ActionExecutorSystem
+	deleteFuncs := []struct {
+		objectType string
+		delete     func(string, string, string) error
+	}{
+		{dataprovider.BackupObjectShares, dataprovider.DeleteShare},
+		{dataprovider.BackupObjectAPIKeys, dataprovider.DeleteAPIKey},
+		{dataprovider.BackupObjectUsers, dataprovider.DeleteUser},
+		{dataprovider.BackupObjectAdmins, dataprovider.DeleteAdmin},
+		{dataprovider.BackupObjectFolders, dataprovider.DeleteFolder},
+	}
+	for _, d := range deleteFuncs {
+		for _, entry := range diff {
+			if entry.Type != d.objectType || entry.Action != dataprovider.BackupDiffDelete {
+				continue
+			}
+			err := d.delete(entry.Name, executor, "")
+			// shares and API keys could be already removed with the related user
+			if err != nil {
+				if _, ok := err.(*util.RecordNotFoundError); ok {
+					continue
+				}
+				return fmt.Errorf("unable to delete %v %#v: %w", entry.Type, entry.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	addConfigFlags(dataImportCmd)
+	addDataFilterFlags(dataImportCmd)
+	dataImportCmd.Flags().StringVarP(&dataImportInputFile, "input-file", "i", "", "Path to the backup file to import")
+	dataImportCmd.Flags().IntVar(&dataImportMode, "mode", 0,
+		`Restore mode:
+0 - new objects are added, existing ones are
+    updated
+1 - new objects are added, existing ones are
+    not modified
+2 - new objects are added, existing ones are
+    updated and the updated users are
+    disconnected. This command runs outside
+    the SFTPGo service, so users connected to
+    a running service are not disconnected`)
+	dataImportCmd.Flags().BoolVar(&dataImportDelete, "delete", false,
+		`Delete the existing objects matching the
+filters and not included in the backup file.
+Requires the "--types" flag`)
+	dataImportCmd.Flags().BoolVar(&dataImportDryRun, "dry-run", false,
+		`Print the changes without applying them`)
+
+	dataCmd.AddCommand(dataImportCmd)
+}
@@ -32,6 +32,7 @@ Please take a look at the usage below to customize the startup options`,
 				LogCompress:   logCompress,
 				LogVerbose:    logVerbose,
 				LogUTCTime:    logUTCTime,
+				LogRemote:     getLogRemoteConfig(),
 				Shutdown:      make(chan bool),
 			}
 			winService := service.WindowsService{
@@ -94,5 +95,20 @@ func getCustomServeFlags() []string {
 	if logCompress != defaultLogCompress {
 		result = append(result, "--"+logCompressFlag+"=true")
 	}
+	if logRemoteType != defaultLogRemoteType {
+		result = append(result, "--"+logRemoteTypeFlag, logRemoteType)
+	}
+	if logRemoteNetwork != defaultLogRemoteNetwork {
+		result = append(result, "--"+logRemoteNetworkFlag, logRemoteNetwork)
+	}
+	if logRemoteAddress != defaultLogRemoteAddress {
+		result = append(result, "--"+logRemoteAddressFlag, logRemoteAddress)
+	}
+	if logRemoteCAFile != defaultLogRemoteCAFile {
+		result = append(result, "--"+logRemoteCAFileFlag, logRemoteCAFile)
+	}
+	if logRemoteBufSize != defaultLogRemoteBufSize {
+		result = append(result, "--"+logRemoteBufferSizeFlag, strconv.Itoa(logRemoteBufSize))
+	}
 	return result
 }
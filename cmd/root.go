@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/version"
 )
 
@@ -30,6 +31,16 @@ const (
 	logVerboseKey            = "log_verbose"
 	logUTCTimeFlag           = "log-utc-time"
 	logUTCTimeKey            = "log_utc_time"
+	logRemoteTypeFlag        = "log-remote-type"
+	logRemoteTypeKey         = "log_remote_type"
+	logRemoteNetworkFlag     = "log-remote-network"
+	logRemoteNetworkKey      = "log_remote_network"
+	logRemoteAddressFlag     = "log-remote-address"
+	logRemoteAddressKey      = "log_remote_address"
+	logRemoteCAFileFlag      = "log-remote-ca-file"
+	logRemoteCAFileKey       = "log_remote_ca_file"
+	logRemoteBufferSizeFlag  = "log-remote-buffer-size"
+	logRemoteBufferSizeKey   = "log_remote_buffer_size"
 	loadDataFromFlag         = "loaddata-from"
 	loadDataFromKey          = "loaddata_from"
 	loadDataModeFlag         = "loaddata-mode"
@@ -47,6 +58,11 @@ const (
 	defaultLogCompress       = false
 	defaultLogVerbose        = true
 	defaultLogUTCTime        = false
+	defaultLogRemoteType     = ""
+	defaultLogRemoteNetwork  = "udp"
+	defaultLogRemoteAddress  = ""
+	defaultLogRemoteCAFile   = ""
+	defaultLogRemoteBufSize  = 1000
 	defaultLoadDataFrom      = ""
 	defaultLoadDataMode      = 1
 	defaultLoadDataQuotaScan = 0
@@ -63,6 +79,11 @@ var (
 	logCompress       bool
 	logVerbose        bool
 	logUTCTime        bool
+	logRemoteType     string
+	logRemoteNetwork  string
+	logRemoteAddress  string
+	logRemoteCAFile   string
+	logRemoteBufSize  int
 	loadDataFrom      string
 	loadDataMode      int
 	loadDataQuotaScan int
@@ -192,6 +213,8 @@ using SFTPGO_LOG_UTC_TIME env var too.
 `)
 	viper.BindPFlag(logUTCTimeKey, cmd.Flags().Lookup(logUTCTimeFlag)) //nolint:errcheck
 
+	addLogRemoteFlags(cmd)
+
 	viper.SetDefault(loadDataFromKey, defaultLoadDataFrom)
 	viper.BindEnv(loadDataFromKey, "SFTPGO_LOADDATA_FROM") //nolint:errcheck
 	cmd.Flags().StringVar(&loadDataFrom, loadDataFromFlag, viper.GetString(loadDataFromKey),
@@ -239,3 +262,72 @@ too. (default "false")
 `)
 	viper.BindPFlag(logCompressKey, cmd.Flags().Lookup(logCompressFlag)) //nolint:errcheck
 }
+
+func addLogRemoteFlags(cmd *cobra.Command) {
+	viper.SetDefault(logRemoteTypeKey, defaultLogRemoteType)
+	viper.BindEnv(logRemoteTypeKey, "SFTPGO_LOG_REMOTE_TYPE") //nolint:errcheck
+	cmd.Flags().StringVar(&logRemoteType, logRemoteTypeFlag, viper.GetString(logRemoteTypeKey),
+		`Send the logs to a remote endpoint too.
+Supported values: "syslog", "gelf". Leave
+empty to disable. The logs are sent
+asynchronously using the same level as the
+local logs. This flag can be set using
+SFTPGO_LOG_REMOTE_TYPE env var too.
+`)
+	viper.BindPFlag(logRemoteTypeKey, cmd.Flags().Lookup(logRemoteTypeFlag)) //nolint:errcheck
+
+	viper.SetDefault(logRemoteNetworkKey, defaultLogRemoteNetwork)
+	viper.BindEnv(logRemoteNetworkKey, "SFTPGO_LOG_REMOTE_NETWORK") //nolint:errcheck
+	cmd.Flags().StringVar(&logRemoteNetwork, logRemoteNetworkFlag, viper.GetString(logRemoteNetworkKey),
+		`Network to use to connect to the remote
+log endpoint: "udp", "tcp" or "tls". "unix"
+is supported for syslog only. This flag can
+be set using SFTPGO_LOG_REMOTE_NETWORK env
+var too. It is unused if log-remote-type is
+empty.`)
+	viper.BindPFlag(logRemoteNetworkKey, cmd.Flags().Lookup(logRemoteNetworkFlag)) //nolint:errcheck
+
+	viper.SetDefault(logRemoteAddressKey, defaultLogRemoteAddress)
+	viper.BindEnv(logRemoteAddressKey, "SFTPGO_LOG_REMOTE_ADDRESS") //nolint:errcheck
+	cmd.Flags().StringVar(&logRemoteAddress, logRemoteAddressFlag, viper.GetString(logRemoteAddressKey),
+		`Address of the remote log endpoint, for
+example "logs.example.com:514". This flag
+can be set using SFTPGO_LOG_REMOTE_ADDRESS
+env var too. It is unused if log-remote-type
+is empty.
+`)
+	viper.BindPFlag(logRemoteAddressKey, cmd.Flags().Lookup(logRemoteAddressFlag)) //nolint:errcheck
+
+	viper.SetDefault(logRemoteCAFileKey, defaultLogRemoteCAFile)
+	viper.BindEnv(logRemoteCAFileKey, "SFTPGO_LOG_REMOTE_CA_FILE") //nolint:errcheck
+	cmd.Flags().StringVar(&logRemoteCAFile, logRemoteCAFileFlag, viper.GetString(logRemoteCAFileKey),
+		`Path to a PEM file with the CA certificates
+used to verify the remote log endpoint
+certificate if the network is "tls". Leave
+empty to use the system root CAs. This flag
+can be set using SFTPGO_LOG_REMOTE_CA_FILE
+env var too.
+`)
+	viper.BindPFlag(logRemoteCAFileKey, cmd.Flags().Lookup(logRemoteCAFileFlag)) //nolint:errcheck
+
+	viper.SetDefault(logRemoteBufferSizeKey, defaultLogRemoteBufSize)
+	viper.BindEnv(logRemoteBufferSizeKey, "SFTPGO_LOG_REMOTE_BUFFER_SIZE") //nolint:errcheck
+	cmd.Flags().IntVar(&logRemoteBufSize, logRemoteBufferSizeFlag, viper.GetInt(logRemoteBufferSizeKey),
+		`Maximum number of log messages to buffer
+while the remote log endpoint is not
+reachable. New messages are dropped if the
+buffer is full. This flag can be set using
+SFTPGO_LOG_REMOTE_BUFFER_SIZE env var too.
+It is unused if log-remote-type is empty.`)
+	viper.BindPFlag(logRemoteBufferSizeKey, cmd.Flags().Lookup(logRemoteBufferSizeFlag)) //nolint:errcheck
+}
+
+func getLogRemoteConfig() logger.RemoteConfig {
+	return logger.RemoteConfig{
+		Type:       logRemoteType,
+		Network:    logRemoteNetwork,
+		Address:    logRemoteAddress,
+		TLSCAFile:  logRemoteCAFile,
+		BufferSize: logRemoteBufSize,
+	}
+}
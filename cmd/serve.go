@@ -30,6 +30,7 @@ Please take a look at the usage below to customize the startup options`,
 				LogCompress:       logCompress,
 				LogVerbose:        logVerbose,
 				LogUTCTime:        logUTCTime,
+				LogRemote:         getLogRemoteConfig(),
 				LoadDataFrom:      loadDataFrom,
 				LoadDataMode:      loadDataMode,
 				LoadDataQuotaScan: loadDataQuotaScan,
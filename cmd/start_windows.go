@@ -30,6 +30,7 @@ var (
 				LogCompress:   logCompress,
 				LogVerbose:    logVerbose,
 				LogUTCTime:    logUTCTime,
+				LogRemote:     getLogRemoteConfig(),
 				Shutdown:      make(chan bool),
 			}
 			winService := service.WindowsService{
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/config"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+// exit codes for the status command
+const (
+	healthExitCodeOK        = 0
+	healthExitCodeUnhealthy = 1
+	healthExitCodeDraining  = 2
+	healthExitCodeUnknown   = 3
+)
+
+var (
+	healthStatusURL           string
+	healthStatusTimeout       int
+	healthStatusUsername      string
+	healthStatusPassword      string
+	healthStatusSkipTLSVerify bool
+	healthStatusJSON          bool
+	healthStatusCmd           = &cobra.Command{
+		Use:     "status",
+		Aliases: []string{"ping"},
+		Short:   "Check the health of a running SFTPGo instance",
+		Long: `This command queries the health endpoint exposed by the telemetry server of a
+running SFTPGo instance and reports the status of the data provider, the protocol
+bindings, the plugins, the KMS, the SMTP configuration and the defender.
+
+By default the telemetry server address is read from the configuration file, use
+the "--url" flag to query a different SFTPGo instance.
+
+Exit codes:
+
+0 - SFTPGo is healthy, some warnings may be reported
+1 - SFTPGo is running but at least one subsystem has errors
+2 - SFTPGo is shutting down, new logins are refused while the active
+    transfers complete
+3 - unable to determine the SFTPGo status, for example SFTPGo is not running
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(checkHealthStatus())
+		},
+	}
+)
+
+func checkHealthStatus() int {
+	client, url, err := getHealthzClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to determine the health endpoint: %v\n", err)
+		return healthExitCodeUnknown
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(healthStatusTimeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"?verbose=1", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create the request: %v\n", err)
+		return healthExitCodeUnknown
+	}
+	if healthStatusUsername != "" {
+		req.SetBasicAuth(healthStatusUsername, healthStatusPassword)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to query %#v: %v\n", url, err)
+		return healthExitCodeUnknown
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1048576))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read the response from %#v: %v\n", url, err)
+		return healthExitCodeUnknown
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		fmt.Fprintf(os.Stderr, "Unexpected response from %#v: %v\n", url, resp.Status)
+		return healthExitCodeUnknown
+	}
+	var status common.HealthStatus
+	if err := json.Unmarshal(body, &status); err != nil || status.Status == "" {
+		fmt.Fprintf(os.Stderr, "Invalid response from %#v, is this an SFTPGo health endpoint?\n", url)
+		return healthExitCodeUnknown
+	}
+	if healthStatusJSON {
+		fmt.Println(strings.TrimSpace(string(body)))
+	} else {
+		printHealthStatus(&status)
+	}
+	if status.Status == common.HealthStatusDraining {
+		return healthExitCodeDraining
+	}
+	if !status.IsHealthy() {
+		return healthExitCodeUnhealthy
+	}
+	return healthExitCodeOK
+}
+
+func getHealthzClient() (*http.Client, string, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: healthStatusSkipTLSVerify, //nolint:gosec
+	}
+	client := &http.Client{
+		Transport: transport,
+	}
+	if healthStatusURL != "" {
+		return client, healthStatusURL, nil
+	}
+
+	configDir = util.CleanDirInput(configDir)
+	if err := config.LoadConfig(configDir, configFile); err != nil {
+		return nil, "", err
+	}
+	telemetryConf := config.GetTelemetryConfig()
+	if !telemetryConf.ShouldBind() {
+		return nil, "", errors.New("the telemetry server is disabled, please enable it or use the \"--url\" flag")
+	}
+	scheme := "http"
+	if telemetryConf.CertificateFile != "" && telemetryConf.CertificateKeyFile != "" {
+		scheme = "https"
+	}
+	address := telemetryConf.BindAddress
+	if filepath.IsAbs(address) && runtime.GOOS != "windows" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", address)
+		}
+		return client, fmt.Sprintf("%v://unix/healthz", scheme), nil
+	}
+	if address == "" || address == "0.0.0.0" {
+		address = "127.0.0.1"
+	} else if address == "::" {
+		address = "::1"
+	}
+	return client, fmt.Sprintf("%v://%v/healthz", scheme, net.JoinHostPort(address, strconv.Itoa(telemetryConf.BindPort))), nil
+}
+
+func printHealthStatus(status *common.HealthStatus) {
+	fmt.Printf("Status: %v\n", status.Status)
+	printHealthCheck("Data provider", status.DataProvider.Driver, status.DataProvider.HealthCheck)
+	for _, b := range status.Bindings {
+		printHealthCheck("Binding", fmt.Sprintf("%v %v", b.Protocol, b.Address), b.HealthCheck)
+	}
+	for _, p := range status.Plugins {
+		printHealthCheck("Plugin", fmt.Sprintf("%v %v", p.Type, p.Cmd), p.HealthCheck)
+	}
+	printHealthCheck("KMS", status.KMS.Scheme, status.KMS.HealthCheck)
+	smtpInfo := ""
+	if status.SMTP.Host != "" {
+		smtpInfo = net.JoinHostPort(status.SMTP.Host, strconv.Itoa(status.SMTP.Port))
+	}
+	printHealthCheck("SMTP", smtpInfo, status.SMTP.HealthCheck)
+	printHealthCheck("Defender", status.Defender.Driver, status.Defender.HealthCheck)
+}
+
+func printHealthCheck(name, info string, check common.HealthCheck) {
+	line := fmt.Sprintf("  %v", name)
+	if info != "" {
+		line += fmt.Sprintf(" (%v)", info)
+	}
+	line += fmt.Sprintf(": %v", check.Status)
+	if check.Error != "" {
+		line += fmt.Sprintf(", %v", check.Error)
+	}
+	fmt.Println(line)
+}
+
+func init() {
+	addConfigFlags(healthStatusCmd)
+	healthStatusCmd.Flags().StringVar(&healthStatusURL, "url", "", `URL for the health endpoint, for example
+"http://127.0.0.1:10000/healthz". If empty the
+telemetry server configuration is used`)
+	healthStatusCmd.Flags().IntVar(&healthStatusTimeout, "timeout", 10, `Timeout in seconds`)
+	healthStatusCmd.Flags().StringVar(&healthStatusUsername, "username", "", `Username for the telemetry server
+basic authentication`)
+	healthStatusCmd.Flags().StringVar(&healthStatusPassword, "password", "", `Password for the telemetry server
+basic authentication`)
+	healthStatusCmd.Flags().BoolVar(&healthStatusSkipTLSVerify, "skip-tls-verify", false, `Skip the TLS certificate verification`)
+	healthStatusCmd.Flags().BoolVar(&healthStatusJSON, "json", false, `Print the health status as JSON`)
+
+	rootCmd.AddCommand(healthStatusCmd)
+}
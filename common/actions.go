@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -66,7 +67,7 @@ func handleUnconfiguredPreAction(operation string) error {
 func ExecutePreAction(conn *BaseConnection, operation, filePath, virtualPath string, fileSize int64, openFlags int) error {
 	var event *notifier.FsEvent
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
-	hasHook := util.IsStringInSlice(operation, Config.Actions.ExecuteOn)
+	hasHook := util.IsStringInSlice(operation, GetConfig().Actions.ExecuteOn)
 	if !hasHook && !hasNotifiersPlugin {
 		return handleUnconfiguredPreAction(operation)
 	}
@@ -86,7 +87,7 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 	fileSize int64, err error,
 ) {
 	hasNotifiersPlugin := plugin.Handler.HasNotifiers()
-	hasHook := util.IsStringInSlice(operation, Config.Actions.ExecuteOn)
+	hasHook := util.IsStringInSlice(operation, GetConfig().Actions.ExecuteOn)
 	if !hasHook && !hasNotifiersPlugin {
 		return
 	}
@@ -97,7 +98,7 @@ func ExecuteActionNotification(conn *BaseConnection, operation, filePath, virtua
 	}
 
 	if hasHook {
-		if util.IsStringInSlice(operation, Config.Actions.ExecuteSync) {
+		if util.IsStringInSlice(operation, GetConfig().Actions.ExecuteSync) {
 			actionHandler.Handle(notification) //nolint:errcheck
 			return
 		}
@@ -168,28 +169,29 @@ func newActionNotification(
 type defaultActionHandler struct{}
 
 func (h *defaultActionHandler) Handle(event *notifier.FsEvent) error {
-	if !util.IsStringInSlice(event.Action, Config.Actions.ExecuteOn) {
+	actions := GetConfig().Actions
+	if !util.IsStringInSlice(event.Action, actions.ExecuteOn) {
 		return errUnconfiguredAction
 	}
 
-	if Config.Actions.Hook == "" {
+	if actions.Hook == "" {
 		logger.Warn(event.Protocol, "", "Unable to send notification, no hook is defined")
 
 		return errNoHook
 	}
 
-	if strings.HasPrefix(Config.Actions.Hook, "http") {
-		return h.handleHTTP(event)
+	if strings.HasPrefix(actions.Hook, "http") {
+		return h.handleHTTP(actions.Hook, event)
 	}
 
-	return h.handleCommand(event)
+	return h.handleCommand(actions.Hook, event)
 }
 
-func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
-	u, err := url.Parse(Config.Actions.Hook)
+func (h *defaultActionHandler) handleHTTP(hook string, event *notifier.FsEvent) error {
+	u, err := url.Parse(hook)
 	if err != nil {
 		logger.Error(event.Protocol, "", "Invalid hook %#v for operation %#v: %v",
-			Config.Actions.Hook, event.Action, err)
+			hook, event.Action, err)
 		return err
 	}
 
@@ -199,7 +201,7 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 	var b bytes.Buffer
 	_ = json.NewEncoder(&b).Encode(event)
 
-	resp, err := httpclient.RetryablePost(Config.Actions.Hook, "application/json", &b)
+	resp, err := httpclient.RetryablePost(hook, "application/json", &b)
 	if err == nil {
 		respCode = resp.StatusCode
 		resp.Body.Close()
@@ -215,9 +217,9 @@ func (h *defaultActionHandler) handleHTTP(event *notifier.FsEvent) error {
 	return err
 }
 
-func (h *defaultActionHandler) handleCommand(event *notifier.FsEvent) error {
-	if !filepath.IsAbs(Config.Actions.Hook) {
-		err := fmt.Errorf("invalid notification command %#v", Config.Actions.Hook)
+func (h *defaultActionHandler) handleCommand(hook string, event *notifier.FsEvent) error {
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid notification command %#v", hook)
 		logger.Warn(event.Protocol, "", "unable to execute notification command: %v", err)
 
 		return err
@@ -226,14 +228,14 @@ func (h *defaultActionHandler) handleCommand(event *notifier.FsEvent) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, Config.Actions.Hook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(), notificationAsEnvVars(event)...)
 
 	startTime := time.Now()
 	err := cmd.Run()
 
 	logger.Debug(event.Protocol, "", "executed command %#v, elapsed: %v, error: %v",
-		Config.Actions.Hook, time.Since(startTime), err)
+		hook, time.Since(startTime), err)
 
 	return err
 }
@@ -259,3 +261,104 @@ func notificationAsEnvVars(event *notifier.FsEvent) []string {
 		fmt.Sprintf("SFTPGO_ACTION_TIMESTAMP=%v", event.Timestamp),
 	}
 }
+
+// maxSiteCommandOutputSize is the maximum size of the output returned by a
+// SITE command hook, the exceeding output is discarded
+const maxSiteCommandOutputSize = 16384
+
+// SiteCommandEvent defines the context sent to the hook of a custom FTP SITE subcommand
+type SiteCommandEvent struct {
+	Command     string `json:"command"`
+	Args        string `json:"args,omitempty"`
+	Username    string `json:"username"`
+	VirtualPath string `json:"virtual_path"`
+	Path        string `json:"path,omitempty"`
+	IP          string `json:"ip"`
+	Protocol    string `json:"protocol"`
+	SessionID   string `json:"session_id"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// ExecuteSiteCommandHook executes the hook defined for a custom FTP SITE subcommand
+// and returns its output. virtualPath is the current directory for the connection
+func ExecuteSiteCommandHook(conn *BaseConnection, hook, command, args, virtualPath string) (string, error) {
+	event := &SiteCommandEvent{
+		Command:     command,
+		Args:        args,
+		Username:    conn.User.Username,
+		VirtualPath: virtualPath,
+		IP:          conn.GetRemoteIP(),
+		Protocol:    conn.protocol,
+		SessionID:   conn.GetID(),
+		Timestamp:   time.Now().UnixNano(),
+	}
+	if _, fsPath, err := conn.GetFsAndResolvedPath(virtualPath); err == nil {
+		event.Path = fsPath
+	}
+
+	var output string
+	var err error
+	startTime := time.Now()
+
+	if strings.HasPrefix(hook, "http") {
+		output, err = executeSiteCommandHTTPHook(hook, event)
+	} else {
+		output, err = executeSiteCommandCmdHook(hook, event)
+	}
+
+	conn.Log(logger.LevelDebug, "executed hook for SITE %v, elapsed: %v, error: %v", command,
+		time.Since(startTime), err)
+	if err != nil {
+		conn.Log(logger.LevelWarn, "unable to execute hook for SITE %v: %v", command, err)
+		return "", ErrGenericFailure
+	}
+	return output, nil
+}
+
+func executeSiteCommandHTTPHook(hook string, event *SiteCommandEvent) (string, error) {
+	if _, err := url.Parse(hook); err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	_ = json.NewEncoder(&b).Encode(event)
+
+	resp, err := httpclient.Post(hook, "application/json", &b)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %v", errUnexpectedHTTResponse, resp.StatusCode)
+	}
+	output, err := io.ReadAll(io.LimitReader(resp.Body, maxSiteCommandOutputSize))
+	return string(output), err
+}
+
+func executeSiteCommandCmdHook(hook string, event *SiteCommandEvent) (string, error) {
+	if !filepath.IsAbs(hook) {
+		return "", fmt.Errorf("invalid SITE command hook %#v", hook)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("SFTPGO_SITE_COMMAND=%v", event.Command),
+		fmt.Sprintf("SFTPGO_SITE_ARGS=%v", event.Args),
+		fmt.Sprintf("SFTPGO_SITE_USERNAME=%v", event.Username),
+		fmt.Sprintf("SFTPGO_SITE_VIRTUAL_PATH=%v", event.VirtualPath),
+		fmt.Sprintf("SFTPGO_SITE_PATH=%v", event.Path),
+		fmt.Sprintf("SFTPGO_SITE_IP=%v", event.IP),
+		fmt.Sprintf("SFTPGO_SITE_PROTOCOL=%v", event.Protocol),
+		fmt.Sprintf("SFTPGO_SITE_SESSION_ID=%v", event.SessionID),
+		fmt.Sprintf("SFTPGO_SITE_TIMESTAMP=%v", event.Timestamp))
+
+	output, err := cmd.Output()
+	if len(output) > maxSiteCommandOutputSize {
+		output = output[:maxSiteCommandOutputSize]
+	}
+	return string(output), err
+}
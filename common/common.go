@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,10 +20,12 @@ import (
 
 	"github.com/pires/go-proxyproto"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/httpclient"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/metric"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
@@ -36,6 +39,7 @@ const (
 	rmdirLogSender    = "Rmdir"
 	mkdirLogSender    = "Mkdir"
 	symlinkLogSender  = "Symlink"
+	hardlinkLogSender = "Hardlink"
 	removeLogSender   = "Remove"
 	chownLogSender    = "Chown"
 	chmodLogSender    = "Chmod"
@@ -52,6 +56,12 @@ const (
 	operationRename    = "rename"
 	operationMkdir     = "mkdir"
 	operationRmdir     = "rmdir"
+	operationSymlink   = "symlink"
+	operationHardlink  = "hardlink"
+	operationChmod     = "chmod"
+	operationChown     = "chown"
+	operationChtimes   = "chtimes"
+	operationTruncate  = "truncate"
 	// SSH command action name
 	OperationSSHCmd          = "ssh_cmd"
 	chtimesFormat            = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
@@ -110,13 +120,18 @@ var (
 	ErrCrtRevoked           = errors.New("your certificate has been revoked")
 	ErrNoCredentials        = errors.New("no credential provided")
 	ErrInternalFailure      = errors.New("internal failure")
+	ErrTransferAborted      = errors.New("transfer aborted")
 	errNoTransfer           = errors.New("requested transfer not found")
 	errTransferMismatch     = errors.New("transfer mismatch")
 )
 
 var (
-	// Config is the configuration for the supported protocols
+	// Config is the configuration set using Initialize. Use GetConfig to read
+	// the current configuration, it could be replaced by a configuration reload
 	Config Configuration
+	// currentConfig is the configuration in use, it is never modified once stored
+	currentConfig atomic.Value
+	configMu      sync.Mutex
 	// Connections is the list of active connections
 	Connections ActiveConnections
 	// QuotaScans is the list of active quota scans
@@ -126,28 +141,130 @@ var (
 	supportedProtocols    = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV,
 		ProtocolHTTP, ProtocolHTTPShare}
 	disconnHookProtocols = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP}
-	// the map key is the protocol, for each protocol we can have multiple rate limiters
-	rateLimiters map[string][]*rateLimiter
 )
 
 // Initialize sets the common configuration
 func Initialize(c Configuration) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
 	Config = c
-	Config.idleLoginTimeout = 2 * time.Minute
-	Config.idleTimeoutAsDuration = time.Duration(Config.IdleTimeout) * time.Minute
+	defer currentConfig.Store(&Config)
+
+	Config.setIdleTimeouts()
 	if Config.IdleTimeout > 0 {
 		startIdleTimeoutTicker(idleTimeoutCheckInterval)
+	} else {
+		stopIdleTimeoutTicker()
 	}
 	Config.defender = nil
-	rateLimiters = make(map[string][]*rateLimiter)
+	Config.rateLimiters = nil
+	rateLimiters, err := c.getRateLimiters()
+	if err != nil {
+		return err
+	}
+	Config.rateLimiters = rateLimiters
+	defender, err := c.getDefender()
+	if err != nil {
+		return err
+	}
+	Config.defender = defender
+	vfs.SetTempPath(c.TempPath)
+	dataprovider.SetTempPath(c.TempPath)
+	if err := c.DedupConfig.initialize(); err != nil {
+		return fmt.Errorf("deduplication initialization error: %v", err)
+	}
+	if err := audit.Initialize(c.AuditLog); err != nil {
+		return fmt.Errorf("audit log initialization error: %v", err)
+	}
+	return nil
+}
+
+// Reload applies a new common configuration while the services are running.
+// The new configuration is fully built and then it replaces the current one,
+// the defender, including the banned hosts, and the rate limiters are preserved
+// if their configuration is unchanged. If an error is returned the current
+// configuration is not changed
+func Reload(c Configuration) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	current := GetConfig()
+	newConfig := c
+	newConfig.setIdleTimeouts()
+	if reflect.DeepEqual(current.RateLimitersConfig, c.RateLimitersConfig) {
+		newConfig.rateLimiters = current.rateLimiters
+	} else {
+		rateLimiters, err := c.getRateLimiters()
+		if err != nil {
+			return err
+		}
+		newConfig.rateLimiters = rateLimiters
+	}
+	if reflect.DeepEqual(current.DefenderConfig, c.DefenderConfig) {
+		newConfig.defender = current.defender
+	} else {
+		defender, err := c.getDefender()
+		if err != nil {
+			return err
+		}
+		newConfig.defender = defender
+	}
+	dedupChanged := !reflect.DeepEqual(current.DedupConfig, c.DedupConfig)
+	if dedupChanged {
+		if err := c.DedupConfig.validate(); err != nil {
+			return fmt.Errorf("deduplication initialization error: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(current.AuditLog, c.AuditLog) {
+		if err := audit.Initialize(c.AuditLog); err != nil {
+			return fmt.Errorf("audit log initialization error: %v", err)
+		}
+	}
+	if dedupChanged {
+		if err := c.DedupConfig.initialize(); err != nil {
+			logger.Warn(logSender, "", "unable to apply the new deduplication configuration: %v", err)
+		}
+	}
+	vfs.SetTempPath(c.TempPath)
+	dataprovider.SetTempPath(c.TempPath)
+	if newConfig.IdleTimeout != current.IdleTimeout {
+		if newConfig.IdleTimeout > 0 {
+			startIdleTimeoutTicker(idleTimeoutCheckInterval)
+		} else {
+			stopIdleTimeoutTicker()
+		}
+	}
+	currentConfig.Store(&newConfig)
+	return nil
+}
+
+// GetConfig returns the current common configuration, it must not be modified.
+// The returned configuration could be replaced by a configuration reload
+func GetConfig() *Configuration {
+	if c, ok := currentConfig.Load().(*Configuration); ok {
+		return c
+	}
+	return &Config
+}
+
+func (c *Configuration) setIdleTimeouts() {
+	c.idleLoginTimeout = 2 * time.Minute
+	c.idleTimeoutAsDuration = time.Duration(c.IdleTimeout) * time.Minute
+}
+
+// getRateLimiters returns the configured rate limiters, the map key is
+// the protocol, for each protocol we can have multiple rate limiters
+func (c *Configuration) getRateLimiters() (map[string][]*rateLimiter, error) {
+	rateLimiters := make(map[string][]*rateLimiter)
 	for _, rlCfg := range c.RateLimitersConfig {
 		if rlCfg.isEnabled() {
 			if err := rlCfg.validate(); err != nil {
-				return fmt.Errorf("rate limiters initialization error: %v", err)
+				return nil, fmt.Errorf("rate limiters initialization error: %v", err)
 			}
 			allowList, err := util.ParseAllowedIPAndRanges(rlCfg.AllowList)
 			if err != nil {
-				return fmt.Errorf("unable to parse rate limiter allow list %v: %v", rlCfg.AllowList, err)
+				return nil, fmt.Errorf("unable to parse rate limiter allow list %v: %v", rlCfg.AllowList, err)
 			}
 			rateLimiter := rlCfg.getLimiter()
 			rateLimiter.allowList = allowList
@@ -156,27 +273,29 @@ func Initialize(c Configuration) error {
 			}
 		}
 	}
-	if c.DefenderConfig.Enabled {
-		if !util.IsStringInSlice(c.DefenderConfig.Driver, supportedDefenderDrivers) {
-			return fmt.Errorf("unsupported defender driver %#v", c.DefenderConfig.Driver)
-		}
-		var defender Defender
-		var err error
-		switch c.DefenderConfig.Driver {
-		case DefenderDriverProvider:
-			defender, err = newDBDefender(&c.DefenderConfig)
-		default:
-			defender, err = newInMemoryDefender(&c.DefenderConfig)
-		}
-		if err != nil {
-			return fmt.Errorf("defender initialization error: %v", err)
-		}
-		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
-		Config.defender = defender
+	return rateLimiters, nil
+}
+
+func (c *Configuration) getDefender() (Defender, error) {
+	if !c.DefenderConfig.Enabled {
+		return nil, nil
 	}
-	vfs.SetTempPath(c.TempPath)
-	dataprovider.SetTempPath(c.TempPath)
-	return nil
+	if !util.IsStringInSlice(c.DefenderConfig.Driver, supportedDefenderDrivers) {
+		return nil, fmt.Errorf("unsupported defender driver %#v", c.DefenderConfig.Driver)
+	}
+	var defender Defender
+	var err error
+	switch c.DefenderConfig.Driver {
+	case DefenderDriverProvider:
+		defender, err = newDBDefender(&c.DefenderConfig)
+	default:
+		defender, err = newInMemoryDefender(&c.DefenderConfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("defender initialization error: %v", err)
+	}
+	logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
+	return defender, nil
 }
 
 // LimitRate blocks until all the configured rate limiters
@@ -184,7 +303,7 @@ func Initialize(c Configuration) error {
 // It returns an error if the time to wait exceeds the max
 // allowed delay
 func LimitRate(protocol, ip string) (time.Duration, error) {
-	for _, limiter := range rateLimiters[protocol] {
+	for _, limiter := range GetConfig().rateLimiters[protocol] {
 		if delay, err := limiter.Wait(ip); err != nil {
 			logger.Debug(logSender, "", "protocol %v ip %v: %v", protocol, ip, err)
 			return delay, err
@@ -195,75 +314,83 @@ func LimitRate(protocol, ip string) (time.Duration, error) {
 
 // ReloadDefender reloads the defender's block and safe lists
 func ReloadDefender() error {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return nil
 	}
 
-	return Config.defender.Reload()
+	return defender.Reload()
 }
 
 // IsBanned returns true if the specified IP address is banned
 func IsBanned(ip string) bool {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return false
 	}
 
-	return Config.defender.IsBanned(ip)
+	return defender.IsBanned(ip)
 }
 
 // GetDefenderBanTime returns the ban time for the given IP
 // or nil if the IP is not banned or the defender is disabled
 func GetDefenderBanTime(ip string) (*time.Time, error) {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return nil, nil
 	}
 
-	return Config.defender.GetBanTime(ip)
+	return defender.GetBanTime(ip)
 }
 
 // GetDefenderHosts returns hosts that are banned or for which some violations have been detected
 func GetDefenderHosts() ([]*dataprovider.DefenderEntry, error) {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return nil, nil
 	}
 
-	return Config.defender.GetHosts()
+	return defender.GetHosts()
 }
 
 // GetDefenderHost returns a defender host by ip, if any
 func GetDefenderHost(ip string) (*dataprovider.DefenderEntry, error) {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return nil, errors.New("defender is disabled")
 	}
 
-	return Config.defender.GetHost(ip)
+	return defender.GetHost(ip)
 }
 
 // DeleteDefenderHost removes the specified IP address from the defender lists
 func DeleteDefenderHost(ip string) bool {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return false
 	}
 
-	return Config.defender.DeleteHost(ip)
+	return defender.DeleteHost(ip)
 }
 
 // GetDefenderScore returns the score for the given IP
 func GetDefenderScore(ip string) (int, error) {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return 0, nil
 	}
 
-	return Config.defender.GetScore(ip)
+	return defender.GetScore(ip)
 }
 
 // AddDefenderEvent adds the specified defender event for the given IP
 func AddDefenderEvent(ip string, event HostEvent) {
-	if Config.defender == nil {
+	defender := GetConfig().defender
+	if defender == nil {
 		return
 	}
 
-	Config.defender.AddEvent(ip, event)
+	defender.AddEvent(ip, event)
 }
 
 // the ticker cannot be started/stopped from multiple goroutines
@@ -308,6 +435,9 @@ type ActiveTransfer interface {
 type ActiveConnection interface {
 	GetID() string
 	GetUsername() string
+	GetMetricsLabel() string
+	GetMetricsUserLabel() string
+	SetMetricsUserLabel(label string)
 	GetLocalAddress() string
 	GetRemoteAddress() string
 	GetClientVersion() string
@@ -319,6 +449,10 @@ type ActiveConnection interface {
 	AddTransfer(t ActiveTransfer)
 	RemoveTransfer(t ActiveTransfer)
 	GetTransfers() []ConnectionTransfer
+	SignalTransferAbort(transferID uint64) error
+	SignalTransfersAbort() error
+	GetBandwidth() (int64, int64)
+	SetBandwidth(upload, download int64)
 	CloseFS() error
 }
 
@@ -335,7 +469,7 @@ type StatAttributes struct {
 
 // ConnectionTransfer defines the trasfer details to expose
 type ConnectionTransfer struct {
-	ID            uint64 `json:"-"`
+	ID            uint64 `json:"id"`
 	OperationType string `json:"operation_type"`
 	StartTime     int64  `json:"start_time"`
 	Size          int64  `json:"size"`
@@ -425,13 +559,23 @@ type Configuration struct {
 	MaxTotalConnections int `json:"max_total_connections" mapstructure:"max_total_connections"`
 	// Maximum number of concurrent client connections from the same host (IP). 0 means unlimited
 	MaxPerHostConnections int `json:"max_per_host_connections" mapstructure:"max_per_host_connections"`
+	// Grace period, as seconds, for the active transfers to complete when the service is stopped.
+	// New logins are refused while waiting, the transfers still active after this time are aborted.
+	// 0 means that the active transfers are aborted immediately
+	GracefulShutdownTimeout int `json:"graceful_shutdown_timeout" mapstructure:"graceful_shutdown_timeout"`
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
 	// Rate limiter configurations
-	RateLimitersConfig    []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	RateLimitersConfig []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	// Content deduplication configuration
+	DedupConfig DedupConfig `json:"deduplication" mapstructure:"deduplication"`
+	// Audit log configuration
+	AuditLog              audit.Config `json:"audit_log" mapstructure:"audit_log"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
+	// the map key is the protocol, for each protocol we can have multiple rate limiters
+	rateLimiters map[string][]*rateLimiter
 }
 
 // IsAtomicUploadEnabled returns true if atomic upload is enabled
@@ -681,6 +825,8 @@ func (conns *ActiveConnections) Add(c ActiveConnection) {
 
 	conns.connections = append(conns.connections, c)
 	metric.UpdateActiveConnectionsSize(len(conns.connections))
+	userSessionStarted(c)
+	tracing.StartConnectionSpan(c.GetID(), "connection "+c.GetProtocol(), getConnectionSpanAttributes(c)...)
 	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, local address %#v, remote address %#v, num open connections: %v",
 		c.GetLocalAddress(), c.GetRemoteAddress(), len(conns.connections))
 }
@@ -697,6 +843,9 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 		if conn.GetID() == c.GetID() {
 			err := conn.CloseFS()
 			conns.connections[idx] = c
+			userSessionEnded(conn)
+			userSessionStarted(c)
+			tracing.SetConnectionAttributes(c.GetID(), getConnectionSpanAttributes(c)...)
 			logger.Debug(logSender, c.GetID(), "connection swapped, close fs error: %v", err)
 			conn = nil
 			return nil
@@ -718,9 +867,12 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 			conns.connections[lastIdx] = nil
 			conns.connections = conns.connections[:lastIdx]
 			metric.UpdateActiveConnectionsSize(lastIdx)
+			userSessionEnded(conn)
+			auditLogout(conn)
+			tracing.EndConnectionSpan(conn.GetID(), nil)
 			logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, local address %#v, remote address %#v close fs error: %v, num open connections: %v",
 				conn.GetLocalAddress(), conn.GetRemoteAddress(), err, lastIdx)
-			Config.checkPostDisconnectHook(conn.GetRemoteAddress(), conn.GetProtocol(), conn.GetUsername(),
+			GetConfig().checkPostDisconnectHook(conn.GetRemoteAddress(), conn.GetProtocol(), conn.GetUsername(),
 				conn.GetID(), conn.GetConnectionTime())
 			return
 		}
@@ -728,6 +880,48 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 	logger.Warn(logSender, "", "connection id %#v to remove not found!", connectionID)
 }
 
+// userSessionStarted updates the active sessions by user, connections
+// are counted only after authentication. The user label is resolved once
+// and reused until the session ends
+func userSessionStarted(c ActiveConnection) {
+	if c.GetUsername() != "" {
+		c.SetMetricsUserLabel(metric.UserSessionStarted(c.GetProtocol(), c.GetUsername(), c.GetMetricsLabel()))
+	}
+}
+
+func userSessionEnded(c ActiveConnection) {
+	metric.UserSessionEnded(c.GetProtocol(), c.GetMetricsUserLabel())
+	c.SetMetricsUserLabel("")
+}
+
+// auditLogout adds a logout event to the audit log for the protocols with
+// persistent sessions, HTTP and WebDAV connections last for a single request
+func auditLogout(c ActiveConnection) {
+	if c.GetUsername() == "" || !audit.IsEnabled() {
+		return
+	}
+	switch c.GetProtocol() {
+	case ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP:
+		audit.Log(audit.Event{
+			Event:        audit.EventLogout,
+			Username:     c.GetUsername(),
+			IP:           util.GetIPFromRemoteAddress(c.GetRemoteAddress()),
+			Protocol:     c.GetProtocol(),
+			ConnectionID: c.GetID(),
+			ElapsedMs:    time.Since(c.GetConnectionTime()).Milliseconds(),
+		})
+	}
+}
+
+func getConnectionSpanAttributes(c ActiveConnection) []tracing.Attribute {
+	return []tracing.Attribute{
+		tracing.String("sftpgo.protocol", c.GetProtocol()),
+		tracing.String("sftpgo.username", c.GetUsername()),
+		tracing.String("sftpgo.ip", util.GetIPFromRemoteAddress(c.GetRemoteAddress())),
+		tracing.String("sftpgo.connection_id", c.GetID()),
+	}
+}
+
 // Close closes an active connection.
 // It returns true on success
 func (conns *ActiveConnections) Close(connectionID string) bool {
@@ -749,6 +943,45 @@ func (conns *ActiveConnections) Close(connectionID string) bool {
 	return result
 }
 
+// SignalTransferAbort signals the specified transfer, for the given connection,
+// to exit as soon as possible. The connection remains open
+func (conns *ActiveConnections) SignalTransferAbort(connectionID string, transferID uint64) error {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	for _, c := range conns.connections {
+		if c.GetID() == connectionID {
+			if err := c.SignalTransferAbort(transferID); err != nil {
+				return util.NewRecordNotFoundError(err.Error())
+			}
+			logger.Info(c.GetProtocol(), c.GetID(), "abort requested for transfer id %v", transferID)
+			return nil
+		}
+	}
+	return util.NewRecordNotFoundError(fmt.Sprintf("connection %#v not found", connectionID))
+}
+
+// SetBandwidth sets the upload and download bandwidth limits, as KB/s, for
+// the specified connection. The new limits apply to the active transfers too.
+// 0 means unlimited
+func (conns *ActiveConnections) SetBandwidth(connectionID string, upload, download int64) error {
+	if upload < 0 || download < 0 {
+		return util.NewValidationError("invalid bandwidth limits, negative values are not allowed")
+	}
+	conns.RLock()
+	defer conns.RUnlock()
+
+	for _, c := range conns.connections {
+		if c.GetID() == connectionID {
+			c.SetBandwidth(upload, download)
+			logger.Info(c.GetProtocol(), c.GetID(), "bandwidth limits changed, upload: %v KB/s, download: %v KB/s",
+				upload, download)
+			return nil
+		}
+	}
+	return util.NewRecordNotFoundError(fmt.Sprintf("connection %#v not found", connectionID))
+}
+
 // AddSSHConnection adds a new ssh connection to the active ones
 func (conns *ActiveConnections) AddSSHConnection(c *SSHConnection) {
 	conns.Lock()
@@ -777,11 +1010,13 @@ func (conns *ActiveConnections) RemoveSSHConnection(connectionID string) {
 }
 
 func (conns *ActiveConnections) checkIdles() {
+	config := GetConfig()
+
 	conns.RLock()
 
 	for _, sshConn := range conns.sshConnections {
 		idleTime := time.Since(sshConn.GetLastActivity())
-		if idleTime > Config.idleTimeoutAsDuration {
+		if idleTime > config.idleTimeoutAsDuration {
 			// we close the an ssh connection if it has no active connections associated
 			idToMatch := fmt.Sprintf("_%v_", sshConn.GetID())
 			toClose := true
@@ -805,7 +1040,7 @@ func (conns *ActiveConnections) checkIdles() {
 		idleTime := time.Since(c.GetLastActivity())
 		isUnauthenticatedFTPUser := (c.GetProtocol() == ProtocolFTP && c.GetUsername() == "")
 
-		if idleTime > Config.idleTimeoutAsDuration || (isUnauthenticatedFTPUser && idleTime > Config.idleLoginTimeout) {
+		if idleTime > config.idleTimeoutAsDuration || (isUnauthenticatedFTPUser && idleTime > config.idleLoginTimeout) {
 			defer func(conn ActiveConnection, isFTPNoAuth bool) {
 				err := conn.Disconnect()
 				logger.Debug(conn.GetProtocol(), conn.GetID(), "close idle connection, idle time: %v, username: %#v close err: %v",
@@ -842,21 +1077,22 @@ func (conns *ActiveConnections) GetClientConnections() int32 {
 
 // IsNewConnectionAllowed returns false if the maximum number of concurrent allowed connections is exceeded
 func (conns *ActiveConnections) IsNewConnectionAllowed(ipAddr string) bool {
-	if Config.MaxTotalConnections == 0 && Config.MaxPerHostConnections == 0 {
+	config := GetConfig()
+	if config.MaxTotalConnections == 0 && config.MaxPerHostConnections == 0 {
 		return true
 	}
 
-	if Config.MaxPerHostConnections > 0 {
-		if total := conns.clients.getTotalFrom(ipAddr); total > Config.MaxPerHostConnections {
-			logger.Debug(logSender, "", "active connections from %v %v/%v", ipAddr, total, Config.MaxPerHostConnections)
+	if config.MaxPerHostConnections > 0 {
+		if total := conns.clients.getTotalFrom(ipAddr); total > config.MaxPerHostConnections {
+			logger.Debug(logSender, "", "active connections from %v %v/%v", ipAddr, total, config.MaxPerHostConnections)
 			AddDefenderEvent(ipAddr, HostEventLimitExceeded)
 			return false
 		}
 	}
 
-	if Config.MaxTotalConnections > 0 {
-		if total := conns.clients.getTotal(); total > int32(Config.MaxTotalConnections) {
-			logger.Debug(logSender, "", "active client connections %v/%v", total, Config.MaxTotalConnections)
+	if config.MaxTotalConnections > 0 {
+		if total := conns.clients.getTotal(); total > int32(config.MaxTotalConnections) {
+			logger.Debug(logSender, "", "active client connections %v/%v", total, config.MaxTotalConnections)
 			return false
 		}
 
@@ -866,7 +1102,7 @@ func (conns *ActiveConnections) IsNewConnectionAllowed(ipAddr string) bool {
 		conns.RLock()
 		defer conns.RUnlock()
 
-		return len(conns.connections) < Config.MaxTotalConnections
+		return len(conns.connections) < config.MaxTotalConnections
 	}
 
 	return true
@@ -879,16 +1115,19 @@ func (conns *ActiveConnections) GetStats() []*ConnectionStatus {
 
 	stats := make([]*ConnectionStatus, 0, len(conns.connections))
 	for _, c := range conns.connections {
+		uploadBandwidth, downloadBandwidth := c.GetBandwidth()
 		stat := &ConnectionStatus{
-			Username:       c.GetUsername(),
-			ConnectionID:   c.GetID(),
-			ClientVersion:  c.GetClientVersion(),
-			RemoteAddress:  c.GetRemoteAddress(),
-			ConnectionTime: util.GetTimeAsMsSinceEpoch(c.GetConnectionTime()),
-			LastActivity:   util.GetTimeAsMsSinceEpoch(c.GetLastActivity()),
-			Protocol:       c.GetProtocol(),
-			Command:        c.GetCommand(),
-			Transfers:      c.GetTransfers(),
+			Username:          c.GetUsername(),
+			ConnectionID:      c.GetID(),
+			ClientVersion:     c.GetClientVersion(),
+			RemoteAddress:     c.GetRemoteAddress(),
+			ConnectionTime:    util.GetTimeAsMsSinceEpoch(c.GetConnectionTime()),
+			LastActivity:      util.GetTimeAsMsSinceEpoch(c.GetLastActivity()),
+			Protocol:          c.GetProtocol(),
+			Command:           c.GetCommand(),
+			Transfers:         c.GetTransfers(),
+			UploadBandwidth:   uploadBandwidth,
+			DownloadBandwidth: downloadBandwidth,
 		}
 		stats = append(stats, stat)
 	}
@@ -915,6 +1154,10 @@ type ConnectionStatus struct {
 	Transfers []ConnectionTransfer `json:"active_transfers,omitempty"`
 	// SSH command or WebDAV method
 	Command string `json:"command,omitempty"`
+	// Upload bandwidth limit as KB/s, 0 means unlimited
+	UploadBandwidth int64 `json:"upload_bandwidth,omitempty"`
+	// Download bandwidth limit as KB/s, 0 means unlimited
+	DownloadBandwidth int64 `json:"download_bandwidth,omitempty"`
 }
 
 // GetConnectionDuration returns the connection duration as string
@@ -19,8 +19,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/kms"
+	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
@@ -255,11 +257,11 @@ func TestRateLimitersIntegration(t *testing.T) {
 	err = Initialize(Config)
 	assert.NoError(t, err)
 
-	assert.Len(t, rateLimiters, 4)
-	assert.Len(t, rateLimiters[ProtocolSSH], 1)
-	assert.Len(t, rateLimiters[ProtocolFTP], 2)
-	assert.Len(t, rateLimiters[ProtocolWebDAV], 2)
-	assert.Len(t, rateLimiters[ProtocolHTTP], 1)
+	assert.Len(t, Config.rateLimiters, 4)
+	assert.Len(t, Config.rateLimiters[ProtocolSSH], 1)
+	assert.Len(t, Config.rateLimiters[ProtocolFTP], 2)
+	assert.Len(t, Config.rateLimiters[ProtocolWebDAV], 2)
+	assert.Len(t, Config.rateLimiters[ProtocolHTTP], 1)
 
 	source1 := "127.1.1.1"
 	source2 := "127.1.1.2"
@@ -290,6 +292,91 @@ func TestRateLimitersIntegration(t *testing.T) {
 	Config = configCopy
 }
 
+func TestReloadConfig(t *testing.T) {
+	configCopy := Config
+
+	c := Config
+	c.DefenderConfig = DefenderConfig{
+		Enabled:          true,
+		Driver:           DefenderDriverMemory,
+		BanTime:          10,
+		BanTimeIncrement: 50,
+		Threshold:        3,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 100,
+		EntriesHardLimit: 150,
+	}
+	c.RateLimitersConfig = []RateLimiterConfig{
+		{
+			Average:   100,
+			Period:    1000,
+			Burst:     1,
+			Type:      int(rateLimiterTypeGlobal),
+			Protocols: []string{ProtocolFTP},
+		},
+	}
+	err := Initialize(c)
+	require.NoError(t, err)
+
+	ip := "127.1.1.2"
+	AddDefenderEvent(ip, HostEventNoLoginTried)
+	AddDefenderEvent(ip, HostEventNoLoginTried)
+	assert.True(t, IsBanned(ip))
+	defender := GetConfig().defender
+	rateLimiters := GetConfig().rateLimiters
+	// the defender, and so the banned hosts, and the rate limiters are preserved
+	// if their configuration is unchanged
+	c.MaxTotalConnections = 10
+	err = Reload(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, GetConfig().MaxTotalConnections)
+	assert.Equal(t, 0, Config.MaxTotalConnections)
+	assert.True(t, GetConfig().defender == defender)
+	assert.Equal(t, fmt.Sprintf("%p", rateLimiters), fmt.Sprintf("%p", GetConfig().rateLimiters))
+	assert.True(t, IsBanned(ip))
+	// invalid configurations are not applied
+	invalidConf := c
+	invalidConf.MaxTotalConnections = 20
+	invalidConf.DefenderConfig.Driver = "unsupported"
+	err = Reload(invalidConf)
+	assert.Error(t, err)
+	invalidConf = c
+	invalidConf.MaxTotalConnections = 20
+	invalidConf.RateLimitersConfig = []RateLimiterConfig{
+		{
+			Average:   100,
+			Period:    1000,
+			Burst:     1,
+			Type:      int(rateLimiterTypeGlobal),
+			Protocols: []string{"unsupported"},
+		},
+	}
+	err = Reload(invalidConf)
+	assert.Error(t, err)
+	invalidConf = c
+	invalidConf.MaxTotalConnections = 20
+	invalidConf.DedupConfig.Enabled = true
+	invalidConf.DedupConfig.StorePath = "relative"
+	err = Reload(invalidConf)
+	assert.Error(t, err)
+	assert.Equal(t, 10, GetConfig().MaxTotalConnections)
+	assert.True(t, GetConfig().defender == defender)
+	// a changed defender configuration creates a new defender
+	c.DefenderConfig.Threshold = 5
+	c.RateLimitersConfig = nil
+	err = Reload(c)
+	assert.NoError(t, err)
+	assert.False(t, GetConfig().defender == defender)
+	assert.False(t, IsBanned(ip))
+	assert.Len(t, GetConfig().rateLimiters, 0)
+
+	err = Initialize(configCopy)
+	assert.NoError(t, err)
+	assert.True(t, GetConfig() == &Config)
+}
+
 func TestMaxConnections(t *testing.T) {
 	oldValue := Config.MaxTotalConnections
 	perHost := Config.MaxPerHostConnections
@@ -480,6 +567,38 @@ func TestSwapConnection(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestConnectionMetricsUserLabel(t *testing.T) {
+	err := metric.Initialize(metric.Config{
+		LabeledMetrics:     true,
+		UserLabel:          metric.UserLabelUsername,
+		MaxUserLabelValues: 10,
+	})
+	require.NoError(t, err)
+	defer metric.Initialize(metric.Config{}) //nolint:errcheck
+
+	c := NewBaseConnection("id", ProtocolSFTP, "", "", dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: userTestUsername,
+		},
+	})
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	Connections.Add(fakeConn)
+	assert.Equal(t, userTestUsername, fakeConn.GetMetricsUserLabel())
+	// the label resolved when the session started is kept after a reload
+	err = metric.Initialize(metric.Config{
+		LabeledMetrics:     true,
+		UserLabel:          metric.UserLabelMetricsLabel,
+		MaxUserLabelValues: 10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, userTestUsername, fakeConn.GetMetricsUserLabel())
+	Connections.Remove(fakeConn.GetID())
+	assert.Empty(t, fakeConn.GetMetricsUserLabel())
+	assert.Len(t, Connections.GetStats(), 0)
+}
+
 func TestAtomicUpload(t *testing.T) {
 	configCopy := Config
 
@@ -548,7 +667,33 @@ func TestConnectionStatus(t *testing.T) {
 		}
 	}
 
-	err := t1.Close()
+	err := Connections.SignalTransferAbort(fakeConn1.GetID(), t2.GetID())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&t1.AbortTransfer))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&t2.AbortTransfer))
+	err = Connections.SignalTransferAbort(fakeConn1.GetID(), 1000)
+	assert.Error(t, err)
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	err = Connections.SignalTransferAbort("missing", t2.GetID())
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+
+	err = Connections.SetBandwidth(fakeConn2.GetID(), 100, 200)
+	assert.NoError(t, err)
+	err = Connections.SetBandwidth(fakeConn2.GetID(), -1, 200)
+	assert.IsType(t, &util.ValidationError{}, err)
+	err = Connections.SetBandwidth("missing", 100, 200)
+	assert.IsType(t, &util.RecordNotFoundError{}, err)
+	for _, stat := range Connections.GetStats() {
+		if stat.ConnectionID == fakeConn2.GetID() {
+			assert.Equal(t, int64(100), stat.UploadBandwidth)
+			assert.Equal(t, int64(200), stat.DownloadBandwidth)
+		} else {
+			assert.Equal(t, int64(0), stat.UploadBandwidth)
+			assert.Equal(t, int64(0), stat.DownloadBandwidth)
+		}
+	}
+
+	err = t1.Close()
 	assert.NoError(t, err)
 	err = t2.Close()
 	assert.NoError(t, err)
@@ -754,6 +899,7 @@ func TestFolderCopy(t *testing.T) {
 		UsedQuotaFiles:  2,
 		LastQuotaUpdate: util.GetTimeAsMsSinceEpoch(time.Now()),
 		Users:           []string{"user1", "user2"},
+		LowerFolder:     "lower",
 	}
 	folderCopy := folder.GetACopy()
 	folder.ID = 2
@@ -767,6 +913,7 @@ func TestFolderCopy(t *testing.T) {
 	require.Equal(t, folder.UsedQuotaSize, folderCopy.UsedQuotaSize)
 	require.Equal(t, folder.UsedQuotaFiles, folderCopy.UsedQuotaFiles)
 	require.Equal(t, folder.LastQuotaUpdate, folderCopy.LastQuotaUpdate)
+	require.Equal(t, folder.LowerFolder, folderCopy.LowerFolder)
 
 	folder.FsConfig = vfs.Filesystem{
 		CryptConfig: vfs.CryptFsConfig{
@@ -908,3 +1055,61 @@ func BenchmarkCompareArgon2Password(b *testing.B) {
 		}
 	}
 }
+
+func TestAuditEvents(t *testing.T) {
+	auditLogPath := filepath.Join(os.TempDir(), "audit_common.log")
+	err := audit.Initialize(audit.Config{
+		Output:   audit.OutputFile,
+		FilePath: auditLogPath,
+	})
+	require.NoError(t, err)
+	defer os.Remove(auditLogPath)
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "audit_user",
+		},
+	}
+	c := NewBaseConnection("auditid", ProtocolSFTP, "", "192.168.1.5:1234", user)
+	Connections.Add(&fakeConnection{BaseConnection: c})
+	c.AuditFsCommand(operationRename, "/src", "/dst", 0, nil)
+	c.AuditFsCommand("md5sum", "/file", "", 0, os.ErrNotExist)
+	// failed commands are reported too
+	err = c.CreateDir("/adir")
+	assert.Error(t, err)
+	Connections.Remove(c.GetID())
+	// HTTP connections have no persistent session
+	cHTTP := NewBaseConnection("audithttpid", ProtocolHTTP, "", "192.168.1.5:1234", user)
+	Connections.Add(&fakeConnection{BaseConnection: cHTTP})
+	Connections.Remove(cHTTP.GetID())
+	err = audit.Close()
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(auditLogPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	var events []audit.Event
+	for _, line := range lines {
+		var ev audit.Event
+		err = json.Unmarshal([]byte(line), &ev)
+		require.NoError(t, err)
+		events = append(events, ev)
+	}
+	assert.Equal(t, audit.EventFsCommand, events[0].Event)
+	assert.Equal(t, operationRename, events[0].Action)
+	assert.Equal(t, "/src", events[0].Path)
+	assert.Equal(t, "/dst", events[0].TargetPath)
+	assert.Equal(t, "192.168.1.5", events[0].IP)
+	assert.Equal(t, ProtocolSFTP, events[0].Protocol)
+	assert.Equal(t, audit.StatusSuccess, events[0].Status)
+	assert.Equal(t, audit.StatusFailure, events[1].Status)
+	assert.Equal(t, "md5sum", events[1].Action)
+	assert.Equal(t, operationMkdir, events[2].Action)
+	assert.Equal(t, "/adir", events[2].Path)
+	assert.Equal(t, audit.StatusFailure, events[2].Status)
+	assert.Equal(t, ErrPermissionDenied.Error(), events[2].Error)
+	assert.Equal(t, audit.EventLogout, events[3].Event)
+	assert.Equal(t, "audit_user", events[3].Username)
+	assert.Equal(t, c.GetID(), events[3].ConnectionID)
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+func TestCompressedFs(t *testing.T) {
+	rootDir := filepath.Join(os.TempDir(), "compressed_root")
+	err := os.MkdirAll(rootDir, os.ModePerm)
+	require.NoError(t, err)
+	_, err = vfs.NewCompressedFs(vfs.NewOsFs("", rootDir, ""), "", vfs.CompressionConfig{Enabled: true, Level: 5})
+	assert.Error(t, err)
+	fs, err := vfs.NewCompressedFs(vfs.NewOsFs("", rootDir, ""), "", vfs.CompressionConfig{Enabled: true})
+	require.NoError(t, err)
+	assert.True(t, vfs.IsCompressedFs(fs))
+	assert.False(t, vfs.IsLocalOsFs(fs))
+	assert.False(t, fs.IsUploadResumeSupported())
+
+	content := bytes.Repeat([]byte("compressible log line\n"), 150000)
+	fsPath := filepath.Join(rootDir, "file.log")
+	_, w, _, err := fs.Create(fsPath, 0)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+
+	physicalInfo, err := os.Stat(fsPath)
+	require.NoError(t, err)
+	assert.Less(t, physicalInfo.Size(), int64(len(content)))
+	info, err := fs.Stat(fsPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	assert.Equal(t, info.Size(), vfs.GetQuotaSize(info))
+	contents, err := fs.ReadDir(rootDir)
+	require.NoError(t, err)
+	require.Len(t, contents, 1)
+	assert.Equal(t, int64(len(content)), contents[0].Size())
+	assert.Equal(t, int64(len(content)), vfs.GetQuotaSize(contents[0]))
+	err = fs.Walk(rootDir, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && walkedPath == fsPath {
+			assert.Equal(t, int64(len(content)), info.Size())
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	// the cached size is not used after a change
+	_, w, _, err = fs.Create(fsPath, 0)
+	require.NoError(t, err)
+	_, err = w.Write(content[:100])
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+	contents, err = fs.ReadDir(rootDir)
+	require.NoError(t, err)
+	require.Len(t, contents, 1)
+	assert.Equal(t, int64(100), contents[0].Size())
+	_, w, _, err = fs.Create(fsPath, 0)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+	physicalInfo, err = os.Stat(fsPath)
+	require.NoError(t, err)
+	numFiles, size, err := fs.ScanRootDirContents()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(len(content)), size)
+	mimeType, err := fs.GetMimeType(fsPath)
+	assert.NoError(t, err)
+	assert.Contains(t, mimeType, "text/plain")
+
+	for _, offset := range []int64{0, 100, 1048576, 1048576 + 10, int64(len(content)) - 1, int64(len(content))} {
+		_, r, _, err := fs.Open(fsPath, offset)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, content[offset:], data, "offset %v", offset)
+		err = r.Close()
+		assert.NoError(t, err)
+	}
+	_, _, _, err = fs.Create(fsPath, os.O_WRONLY|os.O_APPEND)
+	assert.ErrorIs(t, err, vfs.ErrVfsUnsupported)
+	err = fs.Truncate(fsPath, 0)
+	assert.ErrorIs(t, err, vfs.ErrVfsUnsupported)
+	// empty files
+	emptyPath := filepath.Join(rootDir, "empty")
+	_, w, _, err = fs.Create(emptyPath, 0)
+	require.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+	info, err = fs.Stat(emptyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+	_, r, _, err := fs.Open(emptyPath, 0)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Len(t, data, 0)
+	err = r.Close()
+	assert.NoError(t, err)
+	// uncompressed files are served as is
+	plainPath := filepath.Join(rootDir, "plain.txt")
+	err = os.WriteFile(plainPath, []byte("plain content"), os.ModePerm)
+	require.NoError(t, err)
+	info, err = fs.Stat(plainPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(13), info.Size())
+	f, _, _, err := fs.Open(plainPath, 6)
+	require.NoError(t, err)
+	data, err = io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	err = f.Close()
+	assert.NoError(t, err)
+	err = fs.Close()
+	assert.NoError(t, err)
+	// quota on physical size
+	fs, err = vfs.NewCompressedFs(vfs.NewOsFs("", rootDir, ""), "", vfs.CompressionConfig{
+		Enabled:       true,
+		PhysicalQuota: true,
+	})
+	require.NoError(t, err)
+	info, err = fs.Stat(fsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	assert.Equal(t, physicalInfo.Size(), vfs.GetQuotaSize(info))
+	contents, err = fs.ReadDir(rootDir)
+	require.NoError(t, err)
+	for _, fi := range contents {
+		if fi.Name() == info.Name() {
+			assert.Equal(t, physicalInfo.Size(), vfs.GetQuotaSize(fi))
+		}
+	}
+
+	err = os.RemoveAll(rootDir)
+	assert.NoError(t, err)
+}
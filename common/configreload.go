@@ -0,0 +1,113 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+// Supported configuration reload statuses
+const (
+	ConfigReloadStatusInProgress = "in_progress"
+	ConfigReloadStatusSuccess    = "success"
+	ConfigReloadStatusFailure    = "failure"
+)
+
+var (
+	// ErrConfigReloadInProgress defines the error returned if a configuration reload is already in progress
+	ErrConfigReloadInProgress = errors.New("a configuration reload is already in progress")
+	configReloader            = configReloadManager{}
+)
+
+// ConfigReloadResult defines the result of a configuration reload
+type ConfigReloadResult struct {
+	// in_progress, success or failure
+	Status string `json:"status"`
+	// start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// end time as unix timestamp in milliseconds, 0 if the reload is in progress
+	EndTime int64 `json:"end_time"`
+	// configuration sections applied without restarting any service
+	Reloaded []string `json:"reloaded,omitempty"`
+	// services restarted because their configuration changed
+	Restarted []string `json:"restarted,omitempty"`
+	// configuration sections changed that require a full restart to be applied
+	RestartRequired []string `json:"restart_required,omitempty"`
+	// validation and initialization errors, the previous configuration is
+	// preserved for the affected sections
+	Errors []string `json:"errors,omitempty"`
+}
+
+// AddError adds an error to the configuration reload result
+func (r *ConfigReloadResult) AddError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+type configReloadManager struct {
+	sync.RWMutex
+	handler func() ConfigReloadResult
+	result  ConfigReloadResult
+}
+
+func (m *configReloadManager) start() (func() ConfigReloadResult, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.handler == nil {
+		return nil, util.NewMethodDisabledError("configuration reload is not supported")
+	}
+	if m.result.Status == ConfigReloadStatusInProgress {
+		return nil, ErrConfigReloadInProgress
+	}
+	m.result = ConfigReloadResult{
+		Status:    ConfigReloadStatusInProgress,
+		StartTime: util.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	return m.handler, nil
+}
+
+func (m *configReloadManager) end(result ConfigReloadResult) {
+	m.Lock()
+	defer m.Unlock()
+
+	result.StartTime = m.result.StartTime
+	result.EndTime = util.GetTimeAsMsSinceEpoch(time.Now())
+	if len(result.Errors) > 0 {
+		result.Status = ConfigReloadStatusFailure
+	} else {
+		result.Status = ConfigReloadStatusSuccess
+	}
+	m.result = result
+}
+
+// SetConfigReloadHandler sets the function that reloads the configuration
+func SetConfigReloadHandler(fn func() ConfigReloadResult) {
+	configReloader.Lock()
+	defer configReloader.Unlock()
+
+	configReloader.handler = fn
+}
+
+// RequestConfigReload starts a configuration reload in the background.
+// The result can be checked using GetConfigReloadResult
+func RequestConfigReload() error {
+	handler, err := configReloader.start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		configReloader.end(handler())
+	}()
+	return nil
+}
+
+// GetConfigReloadResult returns the result of the last configuration reload.
+// The status is empty if the configuration was never reloaded
+func GetConfigReloadResult() ConfigReloadResult {
+	configReloader.RLock()
+	defer configReloader.RUnlock()
+
+	return configReloader.result
+}
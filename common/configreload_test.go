@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigReload(t *testing.T) {
+	SetConfigReloadHandler(nil)
+	err := RequestConfigReload()
+	assert.Error(t, err)
+
+	unblock := make(chan struct{})
+	SetConfigReloadHandler(func() ConfigReloadResult {
+		<-unblock
+		var result ConfigReloadResult
+		result.Restarted = []string{"sftpd"}
+		return result
+	})
+	err = RequestConfigReload()
+	assert.NoError(t, err)
+	result := GetConfigReloadResult()
+	assert.Equal(t, ConfigReloadStatusInProgress, result.Status)
+	assert.Greater(t, result.StartTime, int64(0))
+	assert.Equal(t, int64(0), result.EndTime)
+	err = RequestConfigReload()
+	assert.ErrorIs(t, err, ErrConfigReloadInProgress)
+	close(unblock)
+	assert.Eventually(t, func() bool {
+		return GetConfigReloadResult().Status == ConfigReloadStatusSuccess
+	}, 1*time.Second, 50*time.Millisecond)
+	result = GetConfigReloadResult()
+	assert.Equal(t, []string{"sftpd"}, result.Restarted)
+	assert.GreaterOrEqual(t, result.EndTime, result.StartTime)
+
+	SetConfigReloadHandler(func() ConfigReloadResult {
+		var result ConfigReloadResult
+		result.AddError(errors.New("reload error"))
+		return result
+	})
+	err = RequestConfigReload()
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return GetConfigReloadResult().Status == ConfigReloadStatusFailure
+	}, 1*time.Second, 50*time.Millisecond)
+	assert.Equal(t, []string{"reload error"}, GetConfigReloadResult().Errors)
+
+	SetConfigReloadHandler(nil)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/pkg/sftp"
 	"github.com/sftpgo/sdk"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/util"
@@ -39,6 +40,15 @@ type BaseConnection struct {
 	localAddr  string
 	sync.RWMutex
 	activeTransfers []ActiveTransfer
+	// bandwidth limits set at runtime, if any, they override the user ones
+	bandwidth atomic.Value
+	// user label for the metrics, resolved when the session starts
+	metricsUserLabel string
+}
+
+type bandwidthLimits struct {
+	upload   int64
+	download int64
 }
 
 // NewBaseConnection returns a new BaseConnection
@@ -80,6 +90,47 @@ func (c *BaseConnection) GetUsername() string {
 	return c.User.Username
 }
 
+// GetMetricsLabel returns the metrics label for the user associated with this connection if any
+func (c *BaseConnection) GetMetricsLabel() string {
+	return c.User.Filters.MetricsLabel
+}
+
+// GetMetricsUserLabel returns the value used for the user label in the metrics for this connection
+func (c *BaseConnection) GetMetricsUserLabel() string {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.metricsUserLabel
+}
+
+// SetMetricsUserLabel sets the value to use for the user label in the metrics for this connection
+func (c *BaseConnection) SetMetricsUserLabel(label string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.metricsUserLabel = label
+}
+
+// AuditFsCommand adds an fs_command event to the audit log, if enabled
+func (c *BaseConnection) AuditFsCommand(command, virtualPath, virtualTargetPath string, size int64, err error) {
+	if !audit.IsEnabled() {
+		return
+	}
+	ev := audit.Event{
+		Event:        audit.EventFsCommand,
+		Username:     c.User.Username,
+		IP:           c.GetRemoteIP(),
+		Protocol:     c.protocol,
+		ConnectionID: c.ID,
+		Action:       command,
+		Path:         virtualPath,
+		TargetPath:   virtualTargetPath,
+		Size:         size,
+	}
+	ev.SetError(err)
+	audit.Log(ev)
+}
+
 // GetProtocol returns the protocol for the connection
 func (c *BaseConnection) GetProtocol() string {
 	return c.protocol
@@ -190,6 +241,38 @@ func (c *BaseConnection) SignalTransfersAbort() error {
 	return nil
 }
 
+// SignalTransferAbort signals the transfer with the given ID to exit as soon as possible
+func (c *BaseConnection) SignalTransferAbort(transferID uint64) error {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, t := range c.activeTransfers {
+		if t.GetID() == transferID {
+			t.SignalClose()
+			return nil
+		}
+	}
+	return fmt.Errorf("transfer id %v not found", transferID)
+}
+
+// GetBandwidth returns the upload and download bandwidth limits, as KB/s,
+// for this connection
+func (c *BaseConnection) GetBandwidth() (int64, int64) {
+	if limits, ok := c.bandwidth.Load().(bandwidthLimits); ok {
+		return limits.upload, limits.download
+	}
+	return c.User.UploadBandwidth, c.User.DownloadBandwidth
+}
+
+// SetBandwidth overrides the upload and download bandwidth limits, as KB/s,
+// for this connection. 0 means unlimited
+func (c *BaseConnection) SetBandwidth(upload, download int64) {
+	c.bandwidth.Store(bandwidthLimits{
+		upload:   upload,
+		download: download,
+	})
+}
+
 func (c *BaseConnection) getRealFsPath(fsPath string) string {
 	c.RLock()
 	defer c.RUnlock()
@@ -275,7 +358,11 @@ func (c *BaseConnection) CheckParentDirs(virtualPath string) error {
 }
 
 // CreateDir creates a new directory at the specified fsPath
-func (c *BaseConnection) CreateDir(virtualPath string) error {
+func (c *BaseConnection) CreateDir(virtualPath string) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationMkdir, virtualPath, "", 0, err)
+	}()
+
 	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(virtualPath)) {
 		return c.GetPermissionDeniedError()
 	}
@@ -312,12 +399,17 @@ func (c *BaseConnection) IsRemoveFileAllowed(virtualPath string) error {
 }
 
 // RemoveFile removes a file at the specified fsPath
-func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo) error {
+func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationDelete, virtualPath, "", info.Size(), err)
+	}()
+
 	if err := c.IsRemoveFileAllowed(virtualPath); err != nil {
 		return err
 	}
 
 	size := info.Size()
+	quotaSize := vfs.GetQuotaSize(info)
 	actionErr := ExecutePreAction(c, operationPreDelete, fsPath, virtualPath, size, 0)
 	if actionErr == nil {
 		c.Log(logger.LevelDebug, "remove for path %#v handled by pre-delete action", fsPath)
@@ -330,15 +422,15 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1,
 		c.localAddr, c.remoteAddr)
-	if info.Mode()&os.ModeSymlink == 0 {
+	if info.Mode()&os.ModeSymlink == 0 && vfs.IsQuotaTracked(info) {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
-			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, -1, -size, false) //nolint:errcheck
+			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, -1, -quotaSize, false) //nolint:errcheck
 			if vfolder.IsIncludedInUserQuota() {
-				dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
+				dataprovider.UpdateUserQuota(&c.User, -1, -quotaSize, false) //nolint:errcheck
 			}
 		} else {
-			dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
+			dataprovider.UpdateUserQuota(&c.User, -1, -quotaSize, false) //nolint:errcheck
 		}
 	}
 	if actionErr != nil {
@@ -372,7 +464,11 @@ func (c *BaseConnection) IsRemoveDirAllowed(fs vfs.Fs, fsPath, virtualPath strin
 }
 
 // RemoveDir removes a directory at the specified fsPath
-func (c *BaseConnection) RemoveDir(virtualPath string) error {
+func (c *BaseConnection) RemoveDir(virtualPath string) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationRmdir, virtualPath, "", 0, err)
+	}()
+
 	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return err
@@ -407,7 +503,11 @@ func (c *BaseConnection) RemoveDir(virtualPath string) error {
 }
 
 // Rename renames (moves) virtualSourcePath to virtualTargetPath
-func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) error {
+func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationRename, virtualSourcePath, virtualTargetPath, 0, err)
+	}()
+
 	if virtualSourcePath == virtualTargetPath {
 		return fmt.Errorf("the rename source and target cannot be the same: %w", c.GetOpUnsupportedError())
 	}
@@ -433,9 +533,9 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 				fsSourcePath, fsTargetPath)
 			return c.GetOpUnsupportedError()
 		}
-		// we are overwriting an existing file/symlink
-		if dstInfo.Mode().IsRegular() {
-			initialSize = dstInfo.Size()
+		// we are overwriting an existing file/symlink, a file not included in the quota is handled as a new one
+		if dstInfo.Mode().IsRegular() && vfs.IsQuotaTracked(dstInfo) {
+			initialSize = vfs.GetQuotaSize(dstInfo)
 		}
 		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualTargetPath)) {
 			c.Log(logger.LevelDebug, "renaming %#v -> %#v is not allowed. Target exists but the user %#v"+
@@ -458,12 +558,18 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 		c.Log(logger.LevelInfo, "denying cross rename due to space limit")
 		return c.GetGenericError(ErrQuotaExceeded)
 	}
+	if vfs.IsOverlayFs(fsDst) && !c.hasSpaceForCopyUp(srcInfo, virtualTargetPath) {
+		c.Log(logger.LevelInfo, "denying rename of %#v due to quota limits", virtualSourcePath)
+		return c.GetQuotaExceededError()
+	}
 	if err := fsSrc.Rename(fsSourcePath, fsTargetPath); err != nil {
 		c.Log(logger.LevelError, "failed to rename %#v -> %#v: %+v", fsSourcePath, fsTargetPath, err)
 		return c.GetFsError(fsSrc, err)
 	}
 	vfs.SetPathPermissions(fsDst, fsTargetPath, c.User.GetUID(), c.User.GetGID())
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize) //nolint:errcheck
+	// a renamed file not included in the quota is now inside the writable layer
+	c.updateQuotaAfterCopyUp(fsDst, fsTargetPath, virtualSourcePath, srcInfo)
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 		"", "", "", -1, c.localAddr, c.remoteAddr)
 	ExecuteActionNotification(c, operationRename, fsSourcePath, virtualSourcePath, fsTargetPath, virtualTargetPath,
@@ -473,7 +579,11 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 }
 
 // CreateSymlink creates fsTargetPath as a symbolic link to fsSourcePath
-func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath string) error {
+func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath string) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationSymlink, virtualSourcePath, virtualTargetPath, 0, err)
+	}()
+
 	if c.isCrossFoldersRequest(virtualSourcePath, virtualTargetPath) {
 		c.Log(logger.LevelWarn, "cross folder symlink is not supported, src: %v dst: %v", virtualSourcePath, virtualTargetPath)
 		return c.GetOpUnsupportedError()
@@ -507,6 +617,85 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 	return nil
 }
 
+// CreateHardlink creates virtualTargetPath as a hard link to the virtualSourcePath file
+func (c *BaseConnection) CreateHardlink(virtualSourcePath, virtualTargetPath string) (err error) {
+	defer func() {
+		c.AuditFsCommand(operationHardlink, virtualSourcePath, virtualTargetPath, 0, err)
+	}()
+
+	if c.isCrossFoldersRequest(virtualSourcePath, virtualTargetPath) {
+		c.Log(logger.LevelWarn, "cross folder hard link is not supported, src: %v dst: %v", virtualSourcePath, virtualTargetPath)
+		return c.GetOpUnsupportedError()
+	}
+	// we cannot have a cross folder request here so only one fs is enough
+	fs, fsSourcePath, err := c.GetFsAndResolvedPath(virtualSourcePath)
+	if err != nil {
+		return err
+	}
+	linker, ok := fs.(vfs.FsLinker)
+	if !ok {
+		c.Log(logger.LevelDebug, "hard links are not supported for fs %#v", fs.Name())
+		return c.GetOpUnsupportedError()
+	}
+	fsTargetPath, err := fs.ResolvePath(virtualTargetPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !c.hasHardlinkPermissions(virtualSourcePath, virtualTargetPath) {
+		return c.GetPermissionDeniedError()
+	}
+	if !c.User.IsFileAllowed(virtualSourcePath) || !c.User.IsFileAllowed(virtualTargetPath) {
+		c.Log(logger.LevelDebug, "hard link %#v -> %#v is not allowed, denied by file patterns",
+			virtualSourcePath, virtualTargetPath)
+		return c.GetPermissionDeniedError()
+	}
+	info, err := fs.Lstat(fsSourcePath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		c.Log(logger.LevelWarn, "hard links are only supported for regular files, source: %#v", virtualSourcePath)
+		return c.GetOpUnsupportedError()
+	}
+	size := vfs.GetQuotaSize(info)
+	quotaResult := c.HasSpace(true, false, virtualTargetPath)
+	if !quotaResult.HasSpace || (quotaResult.QuotaSize > 0 && size > quotaResult.AllowedSize) {
+		c.Log(logger.LevelInfo, "denying hard link %#v -> %#v due to quota limits", virtualSourcePath, virtualTargetPath)
+		return c.GetQuotaExceededError()
+	}
+	if err := linker.Link(fsSourcePath, fsTargetPath); err != nil {
+		c.Log(logger.LevelError, "failed to create hard link %#v -> %#v: %+v", fsSourcePath, fsTargetPath, err)
+		return c.GetFsError(fs, err)
+	}
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualTargetPath))
+	if err == nil {
+		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 1, size, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+		}
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+	}
+	logger.CommandLog(hardlinkLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "",
+		"", "", -1, c.localAddr, c.remoteAddr)
+	ExecuteActionNotification(c, operationHardlink, fsSourcePath, virtualSourcePath, fsTargetPath, virtualTargetPath,
+		"", size, nil)
+	return nil
+}
+
+// hasHardlinkPermissions returns true if the user can create the specified hard link.
+// A hard link exposes the source file contents at the target path, so the same
+// permissions required to copy the source file to the target are required too
+func (c *BaseConnection) hasHardlinkPermissions(virtualSourcePath, virtualTargetPath string) bool {
+	if !c.User.HasPerm(dataprovider.PermCreateSymlinks, path.Dir(virtualTargetPath)) {
+		return false
+	}
+	if !c.User.HasPerms([]string{dataprovider.PermListItems, dataprovider.PermDownload}, path.Dir(virtualSourcePath)) {
+		return false
+	}
+	return c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualTargetPath))
+}
+
 func (c *BaseConnection) getPathForSetStatPerms(fs vfs.Fs, fsPath, virtualPath string) string {
 	pathForPerms := virtualPath
 	if fi, err := fs.Lstat(fsPath); err == nil {
@@ -557,10 +746,11 @@ func (c *BaseConnection) createDirIfMissing(name string) error {
 }
 
 func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
-	if Config.SetstatMode == 1 {
+	setstatMode := GetConfig().SetstatMode
+	if setstatMode == 1 {
 		return true
 	}
-	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) {
+	if setstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) {
 		return true
 	}
 	return false
@@ -603,13 +793,14 @@ func (c *BaseConnection) handleChtimes(fs vfs.Fs, fsPath, pathForPerms string, a
 	if !c.User.HasPerm(dataprovider.PermChtimes, pathForPerms) {
 		return c.GetPermissionDeniedError()
 	}
-	if Config.SetstatMode == 1 {
+	setstatMode := GetConfig().SetstatMode
+	if setstatMode == 1 {
 		return nil
 	}
 	isUploading := c.setTimes(fsPath, attributes.Atime, attributes.Mtime)
 	if err := fs.Chtimes(c.getRealFsPath(fsPath), attributes.Atime, attributes.Mtime, isUploading); err != nil {
 		c.setTimes(fsPath, time.Time{}, time.Time{})
-		if errors.Is(err, vfs.ErrVfsUnsupported) && Config.SetstatMode == 2 {
+		if errors.Is(err, vfs.ErrVfsUnsupported) && setstatMode == 2 {
 			return nil
 		}
 		c.Log(logger.LevelError, "failed to chtimes for path %#v, access time: %v, modification time: %v, err: %+v",
@@ -630,36 +821,58 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 		return err
 	}
 	pathForPerms := c.getPathForSetStatPerms(fs, fsPath, virtualPath)
+	if vfs.IsOverlayFs(fs) {
+		if info, err := fs.Lstat(fsPath); err == nil {
+			// with setstat mode 1 only truncate can change the file
+			if (attributes.Flags&StatAttrSize != 0 || GetConfig().SetstatMode != 1) &&
+				!c.hasSpaceForCopyUp(info, virtualPath) {
+				c.Log(logger.LevelInfo, "denying setstat for %#v due to quota limits", virtualPath)
+				return c.GetQuotaExceededError()
+			}
+			defer c.updateQuotaAfterCopyUp(fs, fsPath, virtualPath, info)
+		}
+	}
 
 	if attributes.Flags&StatAttrTimes != 0 {
-		if err = c.handleChtimes(fs, fsPath, pathForPerms, attributes); err != nil {
+		err = c.handleChtimes(fs, fsPath, pathForPerms, attributes)
+		c.AuditFsCommand(operationChtimes, virtualPath, "", 0, err)
+		if err != nil {
 			return err
 		}
 	}
 
 	if attributes.Flags&StatAttrPerms != 0 {
-		if err = c.handleChmod(fs, fsPath, pathForPerms, attributes); err != nil {
+		err = c.handleChmod(fs, fsPath, pathForPerms, attributes)
+		c.AuditFsCommand(operationChmod, virtualPath, "", 0, err)
+		if err != nil {
 			return err
 		}
 	}
 
 	if attributes.Flags&StatAttrUIDGID != 0 {
-		if err = c.handleChown(fs, fsPath, pathForPerms, attributes); err != nil {
+		err = c.handleChown(fs, fsPath, pathForPerms, attributes)
+		c.AuditFsCommand(operationChown, virtualPath, "", 0, err)
+		if err != nil {
 			return err
 		}
 	}
 
 	if attributes.Flags&StatAttrSize != 0 {
 		if !c.User.HasPerm(dataprovider.PermOverwrite, pathForPerms) {
-			return c.GetPermissionDeniedError()
+			err = c.GetPermissionDeniedError()
+			c.AuditFsCommand(operationTruncate, virtualPath, "", attributes.Size, err)
+			return err
 		}
 
 		if err = c.truncateFile(fs, fsPath, virtualPath, attributes.Size); err != nil {
 			c.Log(logger.LevelError, "failed to truncate path %#v, size: %v, err: %+v", fsPath, attributes.Size, err)
-			return c.GetFsError(fs, err)
+			err = c.GetFsError(fs, err)
+			c.AuditFsCommand(operationTruncate, virtualPath, "", attributes.Size, err)
+			return err
 		}
 		logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "",
 			"", attributes.Size, c.localAddr, c.remoteAddr)
+		c.AuditFsCommand(operationTruncate, virtualPath, "", attributes.Size, nil)
 	}
 
 	return nil
@@ -696,6 +909,60 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 	return err
 }
 
+// updateQuotaAfterCopyUp adds a file not included in the quota, see vfs.IsQuotaTracked,
+// to the quota of virtualPath after a change that copied it to the writable layer
+func (c *BaseConnection) updateQuotaAfterCopyUp(fs vfs.Fs, fsPath, virtualPath string, info os.FileInfo) {
+	if vfs.IsQuotaTracked(info) || !info.Mode().IsRegular() {
+		return
+	}
+	fi, err := fs.Lstat(fsPath)
+	if err != nil || !vfs.IsQuotaTracked(fi) {
+		// the file was not changed
+		return
+	}
+	size := vfs.GetQuotaSize(fi)
+	c.Log(logger.LevelDebug, "file %#v copied to the writable layer, add its size %v to the quota", fsPath, size)
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err == nil {
+		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 1, size, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+		}
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, 1, size, false) //nolint:errcheck
+	}
+}
+
+// HasSpaceForCopyUp returns false if fsPath is a file not included in the quota,
+// see vfs.IsQuotaTracked, and the quota for virtualPath has no room to copy it
+// to the writable layer
+func (c *BaseConnection) HasSpaceForCopyUp(fs vfs.Fs, fsPath, virtualPath string) bool {
+	if !vfs.IsOverlayFs(fs) {
+		return true
+	}
+	info, err := fs.Lstat(fsPath)
+	if err != nil {
+		return true
+	}
+	return c.hasSpaceForCopyUp(info, virtualPath)
+}
+
+func (c *BaseConnection) hasSpaceForCopyUp(info os.FileInfo, virtualPath string) bool {
+	if vfs.IsQuotaTracked(info) || !info.Mode().IsRegular() {
+		return true
+	}
+	quotaResult := c.HasSpace(true, false, virtualPath)
+	if !quotaResult.HasSpace {
+		return false
+	}
+	if quotaResult.QuotaSize > 0 && quotaResult.GetRemainingSize() < info.Size() {
+		c.Log(logger.LevelDebug, "no space to copy %#v to the writable layer, remaining size %v, file size %v",
+			virtualPath, quotaResult.GetRemainingSize(), info.Size())
+		return false
+	}
+	return true
+}
+
 func (c *BaseConnection) checkRecursiveRenameDirPermissions(fsSrc, fsDst vfs.Fs, sourcePath, targetPath string) error {
 	dstPerms := []string{
 		dataprovider.PermCreateDirs,
@@ -849,7 +1116,7 @@ func (c *BaseConnection) hasSpaceForCrossRename(fs vfs.Fs, quotaResult vfs.Quota
 	var sizeDiff int64
 	var filesDiff int
 	if fi.Mode().IsRegular() {
-		sizeDiff = fi.Size()
+		sizeDiff = vfs.GetQuotaSize(fi)
 		filesDiff = 1
 		if initialSize != -1 {
 			sizeDiff -= initialSize
@@ -1111,7 +1378,7 @@ func (c *BaseConnection) updateQuotaAfterRename(fs vfs.Fs, virtualSourcePath, vi
 				return err
 			}
 		} else {
-			filesSize = fi.Size()
+			filesSize = vfs.GetQuotaSize(fi)
 		}
 	} else {
 		c.Log(logger.LevelError, "failed to update quota after rename, file %#v stat error: %+v", targetPath, err)
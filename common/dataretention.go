@@ -197,7 +197,7 @@ func (c *RetentionCheck) Validate() error {
 				return util.NewValidationError("in order to notify results via email you must add a valid email address to your profile")
 			}
 		case RetentionCheckNotificationHook:
-			if Config.DataRetentionHook == "" {
+			if GetConfig().DataRetentionHook == "" {
 				return util.NewValidationError("in order to notify results via hook you must define a data_retention_hook")
 			}
 		default:
@@ -420,12 +420,13 @@ func (c *RetentionCheck) sendHookNotification(elapsed time.Duration, errCheck er
 	jsonData, _ := json.Marshal(data)
 
 	startTime := time.Now()
+	hook := GetConfig().DataRetentionHook
 
-	if strings.HasPrefix(Config.DataRetentionHook, "http") {
+	if strings.HasPrefix(hook, "http") {
 		var url *url.URL
-		url, err := url.Parse(Config.DataRetentionHook)
+		url, err := url.Parse(hook)
 		if err != nil {
-			c.conn.Log(logger.LevelError, "invalid data retention hook %#v: %v", Config.DataRetentionHook, err)
+			c.conn.Log(logger.LevelError, "invalid data retention hook %#v: %v", hook, err)
 			return err
 		}
 		respCode := 0
@@ -445,20 +446,20 @@ func (c *RetentionCheck) sendHookNotification(elapsed time.Duration, errCheck er
 
 		return err
 	}
-	if !filepath.IsAbs(Config.DataRetentionHook) {
-		err := fmt.Errorf("invalid data retention hook %#v", Config.DataRetentionHook)
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid data retention hook %#v", hook)
 		c.conn.Log(logger.LevelError, "%v", err)
 		return err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, Config.DataRetentionHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_DATA_RETENTION_RESULT=%v", string(jsonData)))
 	err := cmd.Run()
 
 	c.conn.Log(logger.LevelDebug, "notified result using command: %v, elapsed: %v err: %v",
-		Config.DataRetentionHook, time.Since(startTime), err)
+		hook, time.Since(startTime), err)
 	return err
 }
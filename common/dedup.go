@@ -0,0 +1,166 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+var (
+	dedupSyncTicker     *time.Ticker
+	dedupSyncTickerDone chan bool
+	dedupSyncMutex      sync.Mutex
+)
+
+// DedupConfig defines the configuration for the content deduplication.
+// Deduplication is supported for the local filesystem only, uploaded files
+// with the same content are replaced with hard links to a single copy stored
+// inside the configured content store. Quotas are not affected, they are
+// always computed using the logical size
+type DedupConfig struct {
+	// Set to true to enable content deduplication
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Absolute path to the content store. It must be on the same filesystem
+	// as the users home directories, hard links cannot cross filesystems
+	StorePath string `json:"store_path" mapstructure:"store_path"`
+	// Files smaller than this size, as bytes, are not deduplicated
+	MinSize int64 `json:"min_size" mapstructure:"min_size"`
+	// Interval, as minutes, to synchronize the reference counts stored inside
+	// the data provider with the content store and to remove the contents no
+	// longer referenced. 0 means disabled
+	SyncInterval int `json:"sync_interval" mapstructure:"sync_interval"`
+}
+
+func (c *DedupConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !filepath.IsAbs(c.StorePath) {
+		return fmt.Errorf("invalid deduplication store path %#v, it must be an absolute path", c.StorePath)
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("invalid deduplication min size: %v", c.MinSize)
+	}
+	if c.SyncInterval < 0 {
+		return fmt.Errorf("invalid deduplication sync interval: %v", c.SyncInterval)
+	}
+	return nil
+}
+
+func (c *DedupConfig) initialize() error {
+	stopDedupSyncTicker()
+	if !c.Enabled {
+		return vfs.SetDedupConfig("", 0)
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if err := vfs.SetDedupConfig(c.StorePath, c.MinSize); err != nil {
+		return err
+	}
+	if c.SyncInterval > 0 {
+		startDedupSyncTicker(time.Duration(c.SyncInterval) * time.Minute)
+	}
+	logger.Info(logSender, "", "content deduplication enabled, config: %+v", *c)
+	return nil
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startDedupSyncTicker(duration time.Duration) {
+	stopDedupSyncTicker()
+	dedupSyncTicker = time.NewTicker(duration)
+	dedupSyncTickerDone = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-dedupSyncTickerDone:
+				return
+			case <-dedupSyncTicker.C:
+				SyncDedupContents() //nolint:errcheck
+			}
+		}
+	}()
+}
+
+func stopDedupSyncTicker() {
+	if dedupSyncTicker != nil {
+		dedupSyncTicker.Stop()
+		dedupSyncTickerDone <- true
+		dedupSyncTicker = nil
+	}
+}
+
+// SyncDedupContents updates the reference counts stored inside the data
+// provider using the hard links count for each stored content. The contents
+// no longer referenced are removed from the content store
+func SyncDedupContents() error {
+	if !vfs.IsDedupEnabled() {
+		return nil
+	}
+	dedupSyncMutex.Lock()
+	defer dedupSyncMutex.Unlock()
+
+	startTime := time.Now()
+	var contents, removed int
+	err := vfs.WalkDedupContents(func(hash string, size int64, links uint64) error {
+		if links <= 1 {
+			ok, err := vfs.RemoveDedupContent(hash)
+			if err != nil {
+				logger.Warn(logSender, "", "unable to remove unreferenced content %#v: %v", hash, err)
+				return nil
+			}
+			if ok {
+				removed++
+				return dataprovider.DeleteDedupContent(hash)
+			}
+			return nil
+		}
+		contents++
+		return dataprovider.UpdateDedupContent(hash, size, int(links-1))
+	})
+	if err != nil {
+		logger.Warn(logSender, "", "unable to sync deduplicated contents: %v", err)
+		return err
+	}
+	if err := dataprovider.CleanupDedupContents(util.GetTimeAsMsSinceEpoch(startTime)); err != nil {
+		logger.Warn(logSender, "", "unable to cleanup deduplicated contents: %v", err)
+		return err
+	}
+	logger.Debug(logSender, "", "deduplicated contents synced, referenced: %v, removed: %v, elapsed: %v",
+		contents, removed, time.Since(startTime))
+	return nil
+}
+
+// deduplicate adds an uploaded file to the content store. preserveTimes must
+// be true if the client explicitly set the modification time
+func deduplicate(fs vfs.Fs, user *dataprovider.User, fsPath, connectionID string, preserveTimes bool) {
+	if !vfs.IsDedupEnabled() {
+		return
+	}
+	osFs, ok := fs.(*vfs.OsFs)
+	if !ok {
+		return
+	}
+	// deduplicated files share the owner too
+	if user.GetUID() != -1 || user.GetGID() != -1 {
+		return
+	}
+	result, err := osFs.Deduplicate(fsPath, preserveTimes)
+	if err != nil {
+		if !errors.Is(err, vfs.ErrDedupSkipped) {
+			logger.Warn(logSender, connectionID, "unable to deduplicate file %#v: %v", fsPath, err)
+		}
+		return
+	}
+	if err := dataprovider.UpdateDedupContent(result.Hash, result.Size, result.References); err != nil {
+		logger.Warn(logSender, connectionID, "unable to update references for deduplicated content %#v: %v",
+			result.Hash, err)
+	}
+}
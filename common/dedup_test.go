@@ -0,0 +1,184 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+func TestDedupConfig(t *testing.T) {
+	c := DedupConfig{
+		Enabled:   true,
+		StorePath: "relative",
+	}
+	err := c.initialize()
+	assert.Error(t, err)
+	c.StorePath = filepath.Join(os.TempDir(), "dedup_store")
+	c.MinSize = -1
+	err = c.initialize()
+	assert.Error(t, err)
+	c.MinSize = 0
+	c.SyncInterval = -1
+	err = c.initialize()
+	assert.Error(t, err)
+	c.Enabled = false
+	err = c.initialize()
+	assert.NoError(t, err)
+	assert.False(t, vfs.IsDedupEnabled())
+}
+
+func TestDeduplication(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	storePath := filepath.Join(os.TempDir(), "dedup_store")
+	homeDir := filepath.Join(os.TempDir(), "dedup_home")
+	err := os.MkdirAll(homeDir, os.ModePerm)
+	require.NoError(t, err)
+	c := DedupConfig{
+		Enabled:      true,
+		StorePath:    storePath,
+		MinSize:      10,
+		SyncInterval: 10,
+	}
+	err = c.initialize()
+	require.NoError(t, err)
+	assert.True(t, vfs.IsDedupEnabled())
+
+	statsBefore, err := dataprovider.GetDedupStats()
+	require.NoError(t, err)
+
+	user := dataprovider.User{}
+	user.UID = -1
+	user.GID = -1
+	fs := vfs.NewOsFs("", homeDir, "")
+	content := []byte("content to deduplicate")
+	file1 := filepath.Join(homeDir, "file1")
+	file2 := filepath.Join(homeDir, "file2")
+	file3 := filepath.Join(homeDir, "file3")
+	err = os.WriteFile(file1, content, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(file2, content, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(file3, []byte("small"), os.ModePerm)
+	assert.NoError(t, err)
+
+	deduplicate(fs, &user, file1, "", false)
+	deduplicate(fs, &user, file2, "", false)
+	deduplicate(fs, &user, file3, "", false)
+	info1, err := os.Stat(file1)
+	assert.NoError(t, err)
+	info2, err := os.Stat(file2)
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(info1, info2))
+
+	stats, err := dataprovider.GetDedupStats()
+	assert.NoError(t, err)
+	assert.Equal(t, statsBefore.Contents+1, stats.Contents)
+	assert.Equal(t, statsBefore.PhysicalSize+int64(len(content)), stats.PhysicalSize)
+	assert.Equal(t, statsBefore.LogicalSize+2*int64(len(content)), stats.LogicalSize)
+	// files with different permissions or preserved times are not linked
+	file4 := filepath.Join(homeDir, "file4")
+	err = os.WriteFile(file4, content, 0600)
+	assert.NoError(t, err)
+	deduplicate(fs, &user, file4, "", false)
+	info4, err := os.Stat(file4)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(info1, info4))
+	mtime := time.Now().Add(-1 * time.Hour).Truncate(time.Second)
+	err = os.Chmod(file4, info1.Mode())
+	assert.NoError(t, err)
+	err = os.Chtimes(file4, mtime, mtime)
+	assert.NoError(t, err)
+	deduplicate(fs, &user, file4, "", true)
+	info4, err = os.Stat(file4)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(info1, info4))
+	assert.Equal(t, mtime, info4.ModTime())
+	err = os.Remove(file4)
+	assert.NoError(t, err)
+	// changing the times must not affect the other links
+	err = fs.Chtimes(file2, mtime, mtime, false)
+	assert.NoError(t, err)
+	info2, err = os.Stat(file2)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(info1, info2))
+	assert.Equal(t, mtime, info2.ModTime())
+	info1, err = os.Stat(file1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, mtime, info1.ModTime())
+	// link the file again
+	err = os.Remove(file2)
+	assert.NoError(t, err)
+	err = os.Link(file1, file2)
+	assert.NoError(t, err)
+	// changing the permissions must not affect the other links
+	err = fs.Chmod(file1, 0600)
+	assert.NoError(t, err)
+	info1, err = os.Stat(file1)
+	assert.NoError(t, err)
+	info2, err = os.Stat(file2)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(info1, info2))
+	assert.NotEqual(t, info1.Mode(), info2.Mode())
+	data, err := os.ReadFile(file1)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	// overwrite must not modify the shared content
+	err = os.Remove(file1)
+	assert.NoError(t, err)
+	err = os.Link(file2, file1)
+	assert.NoError(t, err)
+	f, _, _, err := fs.Create(file1, 0)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("new content"))
+	assert.NoError(t, err)
+	err = f.Close()
+	assert.NoError(t, err)
+	data, err = os.ReadFile(file2)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	// hard links not in the store are preserved
+	file5 := filepath.Join(homeDir, "file5")
+	file6 := filepath.Join(homeDir, "file6")
+	err = os.WriteFile(file5, []byte("user content"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.Link(file5, file6)
+	assert.NoError(t, err)
+	err = fs.Chmod(file5, 0600)
+	assert.NoError(t, err)
+	err = fs.Truncate(file6, 4)
+	assert.NoError(t, err)
+	info5, err := os.Stat(file5)
+	assert.NoError(t, err)
+	info6, err := os.Stat(file6)
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(info5, info6))
+	assert.Equal(t, int64(4), info5.Size())
+	assert.Equal(t, os.FileMode(0600), info6.Mode().Perm())
+
+	err = os.Remove(file2)
+	assert.NoError(t, err)
+	err = SyncDedupContents()
+	assert.NoError(t, err)
+	stats, err = dataprovider.GetDedupStats()
+	assert.NoError(t, err)
+	assert.Equal(t, statsBefore.Contents, stats.Contents)
+	assert.Equal(t, statsBefore.PhysicalSize, stats.PhysicalSize)
+
+	c.Enabled = false
+	err = c.initialize()
+	assert.NoError(t, err)
+	err = os.RemoveAll(storePath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(homeDir)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+const (
+	drainCheckInterval = 250 * time.Millisecond
+	// DrainAbortTimeout is the time to wait for the aborted transfers to be closed
+	DrainAbortTimeout = 10 * time.Second
+	// DrainRetryAfter is the Retry-After value, in seconds, sent to the HTTP
+	// clients refused while the service is shutting down
+	DrainRetryAfter = "60"
+)
+
+var (
+	// ErrShuttingDown defines the error returned to new logins while the service is shutting down
+	ErrShuttingDown = errors.New("the server is shutting down, please try again later")
+	draining        int32
+)
+
+// StartDraining marks the service as shutting down, new logins will be refused.
+// It returns false if the service is already draining
+func StartDraining() bool {
+	return atomic.CompareAndSwapInt32(&draining, 0, 1)
+}
+
+// IsDraining returns true if the service is shutting down and new logins
+// must be refused
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// GetActiveTransfers returns the number of active transfers for all the connections
+func (conns *ActiveConnections) GetActiveTransfers() int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	numTransfers := 0
+	for _, c := range conns.connections {
+		numTransfers += len(c.GetTransfers())
+	}
+	return numTransfers
+}
+
+// SignalTransfersAbort signals the active transfers, for all the connections,
+// to exit as soon as possible. It returns the number of affected connections
+func (conns *ActiveConnections) SignalTransfersAbort() int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	numConns := 0
+	for _, c := range conns.connections {
+		if err := c.SignalTransfersAbort(); err == nil {
+			logger.Info(c.GetProtocol(), c.GetID(), "active transfers aborted, the server is shutting down")
+			numConns++
+		}
+	}
+	return numConns
+}
+
+// DrainConnections refuses new logins and waits for the active transfers to
+// complete up to the specified grace period. The transfers still active after
+// the grace period are aborted and the related connections are closed.
+// Aborted transfers update the quota for the data actually transferred, so
+// this method waits for them to be closed before returning
+func DrainConnections(gracePeriod time.Duration) {
+	StartDraining()
+	logger.Info(logSender, "", "draining connections, active transfers: %v, grace period: %v",
+		Connections.GetActiveTransfers(), gracePeriod)
+
+	if waitForTransfers(gracePeriod) {
+		logger.Info(logSender, "", "all the active transfers are completed")
+		return
+	}
+	numConns := Connections.SignalTransfersAbort()
+	logger.Info(logSender, "", "grace period expired, transfers aborted for %v connections", numConns)
+	if waitForTransfers(DrainAbortTimeout) {
+		return
+	}
+	// the aborted transfers are closed on the next read or write, a stalled
+	// client could never do that, closing the connection closes the transfers
+	for _, stat := range Connections.GetStats() {
+		if len(stat.Transfers) > 0 {
+			Connections.Close(stat.ConnectionID)
+		}
+	}
+	if !waitForTransfers(DrainAbortTimeout) {
+		logger.Warn(logSender, "", "unable to close all the active transfers, remaining: %v",
+			Connections.GetActiveTransfers())
+	}
+}
+
+func waitForTransfers(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if Connections.GetActiveTransfers() == 0 {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainCheckInterval)
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+func TestDrainConnections(t *testing.T) {
+	defer atomic.StoreInt32(&draining, 0)
+
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "drain_user",
+		},
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	c := NewBaseConnection("drain_id", ProtocolSFTP, "", "", u)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	Connections.Add(fakeConn)
+	defer Connections.Remove(fakeConn.GetID())
+
+	assert.Equal(t, 0, Connections.GetActiveTransfers())
+	assert.Equal(t, 0, Connections.SignalTransfersAbort())
+
+	transfer := NewBaseTransfer(nil, c, nil, "", "", "/file", TransferDownload, 0, 0, 0, false, fs)
+	assert.Equal(t, 1, Connections.GetActiveTransfers())
+	assert.Equal(t, 1, Connections.SignalTransfersAbort())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transfer.AbortTransfer))
+
+	assert.False(t, IsDraining())
+	assert.NotEqual(t, HealthStatusDraining, GetHealthStatus().Status)
+	assert.True(t, StartDraining())
+	assert.False(t, StartDraining())
+	assert.True(t, IsDraining())
+	status := GetHealthStatus()
+	assert.Equal(t, HealthStatusDraining, status.Status)
+	assert.False(t, status.IsHealthy())
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		err := transfer.Close()
+		assert.NoError(t, err)
+	}()
+	startTime := time.Now()
+	DrainConnections(5 * time.Second)
+	assert.Less(t, time.Since(startTime), 5*time.Second)
+	require.Equal(t, 0, Connections.GetActiveTransfers())
+	assert.True(t, IsDraining())
+	// no active transfers, this must return immediately
+	startTime = time.Now()
+	DrainConnections(5 * time.Second)
+	assert.Less(t, time.Since(startTime), time.Second)
+}
@@ -0,0 +1,295 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/kms"
+	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/smtp"
+)
+
+// Supported health statuses
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusWarning  = "warning"
+	HealthStatusError    = "error"
+	HealthStatusDisabled = "disabled"
+	// the service is shutting down and new logins are refused
+	HealthStatusDraining = "draining"
+)
+
+var bindingsHealth = bindingsStatus{
+	bindings: make(map[string]BindingHealth),
+}
+
+// HealthCheck defines the result of a single subsystem health check
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (c *HealthCheck) setError(err string) {
+	c.Status = HealthStatusError
+	c.Error = err
+}
+
+// ProviderHealth defines the data provider health
+type ProviderHealth struct {
+	HealthCheck
+	Driver string `json:"driver"`
+}
+
+// BindingHealth defines the health of a protocol binding
+type BindingHealth struct {
+	HealthCheck
+	Protocol string `json:"protocol"`
+	Address  string `json:"address,omitempty"`
+}
+
+// PluginHealth defines the health of a configured plugin
+type PluginHealth struct {
+	HealthCheck
+	Type string `json:"type"`
+	Cmd  string `json:"cmd,omitempty"`
+}
+
+// KMSHealth defines the health of the configured secret provider
+type KMSHealth struct {
+	HealthCheck
+	Scheme string `json:"scheme"`
+}
+
+// SMTPHealth defines the health of the SMTP configuration
+type SMTPHealth struct {
+	HealthCheck
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// DefenderHealth defines the health of the defender
+type DefenderHealth struct {
+	HealthCheck
+	Driver string `json:"driver,omitempty"`
+}
+
+// HealthStatus defines the health of SFTPGo and its subsystems.
+// The overall status is "error" if any subsystem has an error,
+// warnings do not affect the overall status. While the service
+// is shutting down the overall status is "draining"
+type HealthStatus struct {
+	Status       string          `json:"status"`
+	DataProvider ProviderHealth  `json:"data_provider"`
+	Bindings     []BindingHealth `json:"bindings"`
+	Plugins      []PluginHealth  `json:"plugins"`
+	KMS          KMSHealth       `json:"kms"`
+	SMTP         SMTPHealth      `json:"smtp"`
+	Defender     DefenderHealth  `json:"defender"`
+}
+
+// IsHealthy returns true if no subsystem has an error and the service
+// is not shutting down
+func (h *HealthStatus) IsHealthy() bool {
+	return h.Status != HealthStatusError && h.Status != HealthStatusDraining
+}
+
+// HideDetails removes error messages, addresses and commands from the health status.
+// This is useful if the health status is exposed without authentication
+func (h *HealthStatus) HideDetails() {
+	h.DataProvider.Error = ""
+	for idx := range h.Bindings {
+		h.Bindings[idx].Error = ""
+		h.Bindings[idx].Address = ""
+	}
+	for idx := range h.Plugins {
+		h.Plugins[idx].Error = ""
+		h.Plugins[idx].Cmd = ""
+	}
+	h.KMS.Error = ""
+	h.SMTP.Error = ""
+	h.SMTP.Host = ""
+	h.SMTP.Port = 0
+	h.Defender.Error = ""
+}
+
+func (h *HealthStatus) updateStatus() {
+	checks := []HealthCheck{h.DataProvider.HealthCheck, h.KMS.HealthCheck, h.SMTP.HealthCheck, h.Defender.HealthCheck}
+	for _, b := range h.Bindings {
+		checks = append(checks, b.HealthCheck)
+	}
+	for _, p := range h.Plugins {
+		checks = append(checks, p.HealthCheck)
+	}
+	if IsDraining() {
+		h.Status = HealthStatusDraining
+		return
+	}
+	h.Status = HealthStatusOK
+	for _, c := range checks {
+		if c.Status == HealthStatusError {
+			h.Status = HealthStatusError
+			return
+		}
+	}
+}
+
+type bindingsStatus struct {
+	sync.RWMutex
+	bindings map[string]BindingHealth
+}
+
+func (b *bindingsStatus) set(protocol, address string, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	status := BindingHealth{
+		HealthCheck: HealthCheck{Status: HealthStatusOK},
+		Protocol:    protocol,
+		Address:     address,
+	}
+	if err != nil {
+		status.setError(err.Error())
+	}
+	b.bindings[fmt.Sprintf("%v_%v", protocol, address)] = status
+}
+
+func (b *bindingsStatus) remove(protocol, address string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.bindings, fmt.Sprintf("%v_%v", protocol, address))
+}
+
+func (b *bindingsStatus) removeProtocol(protocol string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for k, status := range b.bindings {
+		if status.Protocol == protocol {
+			delete(b.bindings, k)
+		}
+	}
+}
+
+func (b *bindingsStatus) get() []BindingHealth {
+	b.RLock()
+	defer b.RUnlock()
+
+	result := make([]BindingHealth, 0, len(b.bindings))
+	for _, status := range b.bindings {
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Protocol == result[j].Protocol {
+			return result[i].Address < result[j].Address
+		}
+		return result[i].Protocol < result[j].Protocol
+	})
+	return result
+}
+
+// SetBindingStatus sets the status for the binding with the specified protocol and address.
+// A nil error means that the binding is serving requests
+func SetBindingStatus(protocol, address string, err error) {
+	bindingsHealth.set(protocol, address, err)
+}
+
+// RemoveBindingStatus removes the status for the binding with the specified protocol and address
+func RemoveBindingStatus(protocol, address string) {
+	bindingsHealth.remove(protocol, address)
+}
+
+// RemoveBindingsStatus removes the status for all the bindings with the specified protocol
+func RemoveBindingsStatus(protocol string) {
+	bindingsHealth.removeProtocol(protocol)
+}
+
+// GetHealthStatus returns the health status for the data provider, the configured
+// bindings, the plugins, the KMS, the SMTP configuration and the defender
+func GetHealthStatus() HealthStatus {
+	providerStatus := dataprovider.GetProviderStatus()
+	status := HealthStatus{
+		DataProvider: ProviderHealth{
+			HealthCheck: HealthCheck{Status: HealthStatusOK},
+			Driver:      providerStatus.Driver,
+		},
+		Bindings: bindingsHealth.get(),
+		Plugins:  getPluginsHealth(),
+		KMS:      getKMSHealth(),
+		SMTP:     getSMTPHealth(),
+		Defender: getDefenderHealth(providerStatus),
+	}
+	if !providerStatus.IsActive {
+		status.DataProvider.setError(providerStatus.Error)
+	}
+	status.updateStatus()
+
+	return status
+}
+
+func getPluginsHealth() []PluginHealth {
+	result := make([]PluginHealth, 0)
+	for _, p := range plugin.Handler.GetStatus() {
+		status := PluginHealth{
+			HealthCheck: HealthCheck{Status: HealthStatusOK},
+			Type:        p.Type,
+			Cmd:         p.Cmd,
+		}
+		if !p.IsActive {
+			status.setError("plugin exited")
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+func getKMSHealth() KMSHealth {
+	kmsStatus := kms.GetStatus()
+	status := KMSHealth{
+		HealthCheck: HealthCheck{Status: HealthStatusOK},
+		Scheme:      kmsStatus.Scheme,
+	}
+	if !kmsStatus.IsActive {
+		status.setError(kmsStatus.Error)
+	}
+	return status
+}
+
+func getSMTPHealth() SMTPHealth {
+	smtpStatus := smtp.GetStatus()
+	if !smtpStatus.IsActive {
+		return SMTPHealth{
+			HealthCheck: HealthCheck{Status: HealthStatusDisabled},
+		}
+	}
+	status := SMTPHealth{
+		HealthCheck: HealthCheck{Status: HealthStatusOK},
+		Host:        smtpStatus.Host,
+		Port:        smtpStatus.Port,
+	}
+	if smtpStatus.LastSendError != "" {
+		// the SMTP server could be temporarily unavailable, this is not a fatal error
+		status.Status = HealthStatusWarning
+		status.Error = smtpStatus.LastSendError
+	}
+	return status
+}
+
+func getDefenderHealth(providerStatus dataprovider.ProviderStatus) DefenderHealth {
+	config := GetConfig()
+	if config.defender == nil {
+		return DefenderHealth{
+			HealthCheck: HealthCheck{Status: HealthStatusDisabled},
+		}
+	}
+	status := DefenderHealth{
+		HealthCheck: HealthCheck{Status: HealthStatusOK},
+		Driver:      config.DefenderConfig.Driver,
+	}
+	if status.Driver == DefenderDriverProvider && !providerStatus.IsActive {
+		status.setError("the data provider is not available")
+	}
+	return status
+}
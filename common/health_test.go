@@ -0,0 +1,112 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/smtp"
+)
+
+func TestBindingsHealth(t *testing.T) {
+	SetBindingStatus(ProtocolFTP, "127.0.0.1:2121", nil)
+	SetBindingStatus(ProtocolFTP, "127.0.0.1:2021", nil)
+	SetBindingStatus(ProtocolWebDAV, "127.0.0.1:8080", errors.New("address already in use"))
+
+	status := GetHealthStatus()
+	assert.Equal(t, HealthStatusError, status.Status)
+	assert.False(t, status.IsHealthy())
+	assert.Equal(t, HealthStatusOK, status.DataProvider.Status)
+	assert.Equal(t, HealthStatusOK, status.KMS.Status)
+	var bindings []BindingHealth
+	for _, b := range status.Bindings {
+		if b.Protocol == ProtocolFTP || b.Protocol == ProtocolWebDAV {
+			bindings = append(bindings, b)
+		}
+	}
+	require.Len(t, bindings, 3)
+	// bindings are sorted by protocol and address
+	assert.Equal(t, ProtocolWebDAV, bindings[0].Protocol)
+	assert.Equal(t, HealthStatusError, bindings[0].Status)
+	assert.Equal(t, "address already in use", bindings[0].Error)
+	assert.Equal(t, "127.0.0.1:2021", bindings[1].Address)
+	assert.Equal(t, "127.0.0.1:2121", bindings[2].Address)
+	assert.Equal(t, HealthStatusOK, bindings[2].Status)
+
+	status.HideDetails()
+	for _, b := range status.Bindings {
+		assert.Empty(t, b.Address)
+		assert.Empty(t, b.Error)
+	}
+
+	RemoveBindingStatus(ProtocolWebDAV, "127.0.0.1:8080")
+	RemoveBindingStatus(ProtocolFTP, "127.0.0.1:2121")
+	RemoveBindingStatus(ProtocolFTP, "127.0.0.1:2021")
+	status = GetHealthStatus()
+	assert.True(t, status.IsHealthy())
+	for _, b := range status.Bindings {
+		assert.NotEqual(t, ProtocolWebDAV, b.Protocol)
+		assert.NotEqual(t, ProtocolFTP, b.Protocol)
+	}
+}
+
+func TestSMTPAndDefenderHealth(t *testing.T) {
+	status := GetHealthStatus()
+	assert.Equal(t, HealthStatusDisabled, status.SMTP.Status)
+
+	smtpCfg := smtp.Config{
+		Host:          "127.0.0.1",
+		Port:          3525,
+		TemplatesPath: "templates",
+	}
+	err := smtpCfg.Initialize("..")
+	require.NoError(t, err)
+
+	status = GetHealthStatus()
+	assert.Equal(t, HealthStatusOK, status.SMTP.Status)
+	assert.Equal(t, "127.0.0.1", status.SMTP.Host)
+	assert.Equal(t, 3525, status.SMTP.Port)
+
+	err = smtp.SendEmail("admin@example.com", "subject", "body", smtp.EmailContentTypeTextPlain)
+	assert.Error(t, err)
+	status = GetHealthStatus()
+	assert.Equal(t, HealthStatusWarning, status.SMTP.Status)
+	assert.NotEmpty(t, status.SMTP.Error)
+	// warnings do not affect the overall status
+	assert.True(t, status.IsHealthy())
+
+	status.HideDetails()
+	assert.Empty(t, status.SMTP.Error)
+	assert.Empty(t, status.SMTP.Host)
+	assert.Equal(t, 0, status.SMTP.Port)
+
+	smtpCfg = smtp.Config{}
+	err = smtpCfg.Initialize("..")
+	require.NoError(t, err)
+
+	oldConfig := Config
+	Config.DefenderConfig = DefenderConfig{
+		Enabled:          true,
+		Driver:           DefenderDriverMemory,
+		BanTime:          10,
+		BanTimeIncrement: 50,
+		Threshold:        10,
+		ScoreInvalid:     2,
+		ScoreValid:       1,
+		ObservationTime:  15,
+		EntriesSoftLimit: 100,
+		EntriesHardLimit: 150,
+	}
+	err = Initialize(Config)
+	require.NoError(t, err)
+
+	status = GetHealthStatus()
+	assert.Equal(t, HealthStatusOK, status.Defender.Status)
+	assert.Equal(t, DefenderDriverMemory, status.Defender.Driver)
+
+	Config = oldConfig
+	status = GetHealthStatus()
+	assert.Equal(t, HealthStatusDisabled, status.Defender.Status)
+}
@@ -0,0 +1,114 @@
+package common
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+func TestOverlayFs(t *testing.T) {
+	mountPath := "/vdir"
+	lowerDir := filepath.Join(os.TempDir(), "overlay_lower")
+	upperDir := filepath.Join(os.TempDir(), "overlay_upper")
+	err := os.MkdirAll(filepath.Join(lowerDir, "sub"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.MkdirAll(upperDir, os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(lowerDir, "lower.txt"), []byte("lower"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(lowerDir, "sub", "file.txt"), []byte("sub"), os.ModePerm)
+	require.NoError(t, err)
+
+	fs := vfs.NewOverlayFs(vfs.NewOsFs("", upperDir, mountPath), vfs.NewOsFs("", lowerDir, mountPath), mountPath)
+	resolve := func(virtualPath string) string {
+		p, err := fs.ResolvePath(virtualPath)
+		require.NoError(t, err)
+		return p
+	}
+	readDirNames := func(virtualPath string) []string {
+		contents, err := fs.ReadDir(resolve(virtualPath))
+		require.NoError(t, err)
+		var names []string
+		for _, info := range contents {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"lower.txt", "sub"}, readDirNames(mountPath))
+	info, err := fs.Stat(resolve(path.Join(mountPath, "lower.txt")))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size())
+	assert.False(t, vfs.IsQuotaTracked(info))
+	assert.Equal(t, int64(0), vfs.GetQuotaSize(info))
+	// append to a lower file, it must be copied to the upper layer
+	f, _, _, err := fs.Create(resolve(path.Join(mountPath, "lower.txt")), os.O_WRONLY|os.O_APPEND)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" upper"))
+	assert.NoError(t, err)
+	err = f.Close()
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(upperDir, "lower.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("lower upper"), data)
+	data, err = os.ReadFile(filepath.Join(lowerDir, "lower.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("lower"), data)
+	// remove a lower file, a whiteout is created
+	err = fs.Remove(resolve(path.Join(mountPath, "sub", "file.txt")), false)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(lowerDir, "sub", "file.txt"))
+	assert.Len(t, readDirNames(path.Join(mountPath, "sub")), 0)
+	_, err = fs.Stat(resolve(path.Join(mountPath, "sub", "file.txt")))
+	assert.True(t, fs.IsNotExist(err))
+	// whiteouts are not included in the quota
+	info, err = fs.Stat(resolve(path.Join(mountPath, "lower.txt")))
+	assert.NoError(t, err)
+	assert.True(t, vfs.IsQuotaTracked(info))
+	numFiles, size, err := fs.ScanRootDirContents()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(11), size)
+	// markers cannot be created
+	_, _, _, err = fs.Create(resolve(path.Join(mountPath, "sub", ".wh.file.txt")), 0)
+	assert.True(t, fs.IsPermission(err))
+	// remove the lower directory and create a new one with the same name
+	err = fs.Remove(resolve(path.Join(mountPath, "sub")), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lower.txt"}, readDirNames(mountPath))
+	err = fs.Mkdir(resolve(path.Join(mountPath, "sub")))
+	assert.NoError(t, err)
+	assert.Len(t, readDirNames(path.Join(mountPath, "sub")), 0)
+	// rename a lower file
+	err = os.WriteFile(filepath.Join(lowerDir, "torename.txt"), []byte("data"), os.ModePerm)
+	require.NoError(t, err)
+	err = fs.Rename(resolve(path.Join(mountPath, "torename.txt")), resolve(path.Join(mountPath, "sub", "renamed.txt")))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"lower.txt", "sub"}, readDirNames(mountPath))
+	assert.Equal(t, []string{"renamed.txt"}, readDirNames(path.Join(mountPath, "sub")))
+	assert.FileExists(t, filepath.Join(lowerDir, "torename.txt"))
+	// renaming lower directories is not supported
+	err = os.MkdirAll(filepath.Join(lowerDir, "lowerdir"), os.ModePerm)
+	require.NoError(t, err)
+	err = fs.Rename(resolve(path.Join(mountPath, "lowerdir")), resolve(path.Join(mountPath, "newdir")))
+	assert.True(t, fs.IsNotSupported(err))
+
+	var walked []string
+	err = fs.Walk(resolve(mountPath), func(walkedPath string, info os.FileInfo, err error) error {
+		walked = append(walked, fs.GetRelativePath(walkedPath))
+		return err
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{mountPath, path.Join(mountPath, "lower.txt"), path.Join(mountPath, "sub"),
+		path.Join(mountPath, "sub", "renamed.txt"), path.Join(mountPath, "lowerdir")}, walked)
+
+	err = os.RemoveAll(lowerDir)
+	assert.NoError(t, err)
+	err = os.RemoveAll(upperDir)
+	assert.NoError(t, err)
+}
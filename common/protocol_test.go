@@ -1019,6 +1019,177 @@ func TestVirtualFoldersQuotaValues(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestOverlayFolderQuota(t *testing.T) {
+	lowerPath := filepath.Join(os.TempDir(), "overlay_quota_lower")
+	lowerFolder := vfs.BaseVirtualFolder{
+		Name:       filepath.Base(lowerPath),
+		MappedPath: lowerPath,
+	}
+	_, _, err := httpdtest.AddFolder(lowerFolder, http.StatusCreated)
+	assert.NoError(t, err)
+	upperPath := filepath.Join(os.TempDir(), "overlay_quota_upper")
+	folderName := filepath.Base(upperPath)
+	vdirPath := "/vdir"
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:        folderName,
+			MappedPath:  upperPath,
+			LowerFolder: lowerFolder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	lowerFileSize := int64(100)
+	err = os.MkdirAll(lowerPath, os.ModePerm)
+	assert.NoError(t, err)
+	for _, name := range []string{"file1", "file2", "file3", "file4", "file5"} {
+		err = os.WriteFile(filepath.Join(lowerPath, name), make([]byte, lowerFileSize), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	checkQuota := func(expectedFiles int, expectedSize int64) {
+		f, _, err := httpdtest.GetFolderByName(folderName, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedFiles, f.UsedQuotaFiles)
+		assert.Equal(t, expectedSize, f.UsedQuotaSize)
+		user, _, err := httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedFiles, user.UsedQuotaFiles)
+		assert.Equal(t, expectedSize, user.UsedQuotaSize)
+	}
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		// removing a lower file does not change the quota
+		err = client.Remove(path.Join(vdirPath, "file1"))
+		assert.NoError(t, err)
+		checkQuota(0, 0)
+		// a changed lower file is copied to the upper layer and included in the quota
+		err = client.Chmod(path.Join(vdirPath, "file2"), 0644)
+		assert.NoError(t, err)
+		checkQuota(1, lowerFileSize)
+		err = client.Truncate(path.Join(vdirPath, "file3"), 10)
+		assert.NoError(t, err)
+		checkQuota(2, lowerFileSize+10)
+		err = client.Rename(path.Join(vdirPath, "file4"), path.Join(vdirPath, "file4_renamed"))
+		assert.NoError(t, err)
+		checkQuota(3, 2*lowerFileSize+10)
+		f, err := client.OpenFile(path.Join(vdirPath, "file5"), os.O_WRONLY|os.O_APPEND)
+		if assert.NoError(t, err) {
+			_, err = f.WriteAt(make([]byte, 10), lowerFileSize)
+			assert.NoError(t, err)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		checkQuota(4, 3*lowerFileSize+20)
+		// removing a copied up file restores the previous quota
+		err = client.Remove(path.Join(vdirPath, "file5"))
+		assert.NoError(t, err)
+		checkQuota(3, 2*lowerFileSize+10)
+		// whiteouts are not included in the scanned quota
+		folder := vfs.VirtualFolder{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name:        folderName,
+				MappedPath:  upperPath,
+				LowerFolder: lowerFolder.Name,
+			},
+		}
+		numFiles, size, err := folder.ScanQuota()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, numFiles)
+		assert.Equal(t, 2*lowerFileSize+10, size)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(lowerFolder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(upperPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(lowerPath)
+	assert.NoError(t, err)
+}
+
+func TestOverlayFolderCopyUpQuota(t *testing.T) {
+	lowerPath := filepath.Join(os.TempDir(), "overlay_copyup_lower")
+	lowerFolder := vfs.BaseVirtualFolder{
+		Name:       filepath.Base(lowerPath),
+		MappedPath: lowerPath,
+	}
+	_, _, err := httpdtest.AddFolder(lowerFolder, http.StatusCreated)
+	assert.NoError(t, err)
+	upperPath := filepath.Join(os.TempDir(), "overlay_copyup_upper")
+	folderName := filepath.Base(upperPath)
+	vdirPath := "/vdir"
+	lowerFileSize := int64(100)
+	u := getTestUser()
+	u.QuotaSize = lowerFileSize + 50
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:        folderName,
+			MappedPath:  upperPath,
+			LowerFolder: lowerFolder.Name,
+		},
+		VirtualPath: vdirPath,
+		QuotaFiles:  -1,
+		QuotaSize:   -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.MkdirAll(lowerPath, os.ModePerm)
+	assert.NoError(t, err)
+	for _, name := range []string{"file1", "file2"} {
+		err = os.WriteFile(filepath.Join(lowerPath, name), make([]byte, lowerFileSize), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = client.Chmod(path.Join(vdirPath, "file1"), 0644)
+		assert.NoError(t, err)
+		// there is no room to copy file2 to the upper layer
+		err = client.Chmod(path.Join(vdirPath, "file2"), 0644)
+		assert.Error(t, err)
+		err = client.Chtimes(path.Join(vdirPath, "file2"), time.Now(), time.Now())
+		assert.Error(t, err)
+		err = client.Truncate(path.Join(vdirPath, "file2"), 10)
+		assert.Error(t, err)
+		err = client.Rename(path.Join(vdirPath, "file2"), path.Join(vdirPath, "file2_renamed"))
+		assert.Error(t, err)
+		_, err = client.OpenFile(path.Join(vdirPath, "file2"), os.O_WRONLY|os.O_APPEND)
+		assert.Error(t, err)
+		_, err = os.Stat(filepath.Join(upperPath, "file2"))
+		assert.True(t, os.IsNotExist(err))
+
+		user, _, err := httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, lowerFileSize, user.UsedQuotaSize)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(lowerFolder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(upperPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(lowerPath)
+	assert.NoError(t, err)
+}
+
 func TestQuotaRenameInsideSameVirtualFolder(t *testing.T) {
 	u := getTestUser()
 	u.QuotaFiles = 100
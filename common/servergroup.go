@@ -0,0 +1,368 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// time to wait for a restarted binding to fail, a binding still running
+// after this time is considered successfully started
+const bindingStartCheckTime = 2 * time.Second
+
+var (
+	errServerGroupStopped = errors.New("the server group is stopped")
+	errBindingStopped     = errors.New("the binding is stopped")
+)
+
+// ServerGroup tracks the listeners and the HTTP servers started by a service,
+// this way the service can be stopped and started again without terminating
+// the process. Stopping a server group does not close the established
+// connections: the SSH and FTP connections are not affected and the HTTP
+// servers complete the in-flight requests before closing the connections.
+// The bindings started using GoBinding can also be replaced individually
+type ServerGroup struct {
+	sync.Mutex
+	wg       sync.WaitGroup
+	stopped  bool
+	stopFns  []func() error
+	bindings []*ServerBinding
+	exit     chan error
+}
+
+// ServerBinding tracks the listeners and the HTTP servers started for a
+// single binding of a server group
+type ServerBinding struct {
+	group   *ServerGroup
+	key     string
+	stopped bool
+	// the exit of a binding that is being checked is handled by ReplaceBindings
+	checking bool
+	exited   bool
+	err      error
+	stopFns  []func() error
+	done     chan struct{}
+}
+
+// BindingStarter defines a binding to start in a server group
+type BindingStarter struct {
+	// Key identifies the binding, usually it is the binding address
+	Key string
+	// Start starts the binding and returns when the binding is stopped
+	Start func(b *ServerBinding) error
+}
+
+// Reset prepares the group for a new start. It must be called when the
+// service is initialized, before adding listeners
+func (g *ServerGroup) Reset() {
+	g.Lock()
+	defer g.Unlock()
+
+	g.stopped = false
+	g.stopFns = nil
+	g.bindings = nil
+	g.exit = make(chan error, 1)
+}
+
+// Go runs the specified function in a new goroutine tracked by the group
+func (g *ServerGroup) Go(fn func()) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		fn()
+	}()
+}
+
+// GoBinding starts the specified binding in a new goroutine tracked by the
+// group. The error returned by the binding is reported by Wait, unless the
+// binding was stopped using ReplaceBindings
+func (g *ServerGroup) GoBinding(starter BindingStarter) *ServerBinding {
+	return g.startBinding(starter, false)
+}
+
+func (g *ServerGroup) startBinding(starter BindingStarter, checking bool) *ServerBinding {
+	b := &ServerBinding{
+		group:    g,
+		key:      starter.Key,
+		checking: checking,
+		done:     make(chan struct{}),
+	}
+	g.Lock()
+	g.bindings = append(g.bindings, b)
+	exit := g.exit
+	g.Unlock()
+
+	g.Go(func() {
+		err := starter.Start(b)
+
+		g.Lock()
+		b.err = err
+		b.exited = true
+		handledByReplace := !g.stopped && (b.stopped || b.checking)
+		g.Unlock()
+		close(b.done)
+
+		if handledByReplace {
+			return
+		}
+		select {
+		case exit <- err:
+		default:
+		}
+	})
+	return b
+}
+
+// Wait blocks until a binding started using GoBinding exits and returns its error
+func (g *ServerGroup) Wait() error {
+	g.Lock()
+	exit := g.exit
+	g.Unlock()
+
+	return <-exit
+}
+
+// ReplaceBindings stops the removed bindings and starts the added ones, the
+// other bindings are not affected. If an added binding fails to start, for
+// example because the port is in use, the added bindings are stopped, the
+// removed ones are started again and an error is returned
+func (g *ServerGroup) ReplaceBindings(ctx context.Context, removed, added []BindingStarter) error {
+	if g.IsStopped() {
+		return errServerGroupStopped
+	}
+	for _, starter := range removed {
+		if err := g.stopBindings(ctx, starter.Key); err != nil {
+			return err
+		}
+	}
+	err := g.startCheckedBindings(added)
+	if err == nil {
+		return nil
+	}
+	for _, starter := range added {
+		if stopErr := g.stopBindings(ctx, starter.Key); stopErr != nil {
+			return fmt.Errorf("%w, unable to stop the new bindings: %v", err, stopErr)
+		}
+	}
+	if restoreErr := g.startCheckedBindings(removed); restoreErr != nil {
+		return fmt.Errorf("%w, unable to restore the previous bindings: %v", err, restoreErr)
+	}
+	return err
+}
+
+func (g *ServerGroup) startCheckedBindings(starters []BindingStarter) error {
+	var bindings []*ServerBinding
+	for _, starter := range starters {
+		bindings = append(bindings, g.startBinding(starter, true))
+	}
+	timer := time.NewTimer(bindingStartCheckTime)
+	defer timer.Stop()
+
+waitLoop:
+	for _, b := range bindings {
+		select {
+		case <-b.done:
+			// a binding failed, there is no need to wait for the other ones
+			break waitLoop
+		case <-timer.C:
+			break waitLoop
+		}
+	}
+
+	var err error
+	g.Lock()
+	defer g.Unlock()
+
+	for _, b := range bindings {
+		b.checking = false
+		if b.exited && err == nil {
+			err = b.err
+			if err == nil {
+				err = fmt.Errorf("the binding %v exited", b.key)
+			}
+		}
+	}
+	return err
+}
+
+func (g *ServerGroup) stopBindings(ctx context.Context, key string) error {
+	var stopped []*ServerBinding
+
+	g.Lock()
+	bindings := g.bindings[:0]
+	for _, b := range g.bindings {
+		if b.key == key {
+			stopped = append(stopped, b)
+		} else {
+			bindings = append(bindings, b)
+		}
+	}
+	g.bindings = bindings
+	g.Unlock()
+
+	for _, b := range stopped {
+		b.stop()
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return fmt.Errorf("unable to stop the binding %v: %w", key, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// AddListener adds a listener to the group. If the group is already
+// stopped the listener is closed and an error is returned
+func (g *ServerGroup) AddListener(listener net.Listener) error {
+	return g.add(listener.Close)
+}
+
+// AddHTTPServer adds an HTTP server to the group. If the group is already
+// stopped an error is returned
+func (g *ServerGroup) AddHTTPServer(srv *http.Server) error {
+	return g.add(getHTTPServerStopFunc(srv))
+}
+
+// AddStopFunc adds a function to call when the group is stopped.
+// If the group is already stopped the function is called and an error is returned
+func (g *ServerGroup) AddStopFunc(fn func() error) error {
+	return g.add(fn)
+}
+
+func (g *ServerGroup) add(fn func() error) error {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.stopped {
+		fn() //nolint:errcheck
+		return errServerGroupStopped
+	}
+	g.stopFns = append(g.stopFns, fn)
+	return nil
+}
+
+// IsStopped returns true if the group was stopped
+func (g *ServerGroup) IsStopped() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.stopped
+}
+
+// Stop closes all the listeners and waits for the goroutines started using
+// the Go method to return or for the context to be done
+func (g *ServerGroup) Stop(ctx context.Context) error {
+	g.Lock()
+	g.stopped = true
+	stopFns := g.stopFns
+	g.stopFns = nil
+	for _, b := range g.bindings {
+		stopFns = append(stopFns, b.stopFns...)
+		b.stopFns = nil
+	}
+	g.Unlock()
+
+	for _, fn := range stopFns {
+		fn() //nolint:errcheck
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddListener adds a listener to the binding. If the binding or the group
+// are already stopped the listener is closed and an error is returned
+func (b *ServerBinding) AddListener(listener net.Listener) error {
+	return b.add(listener.Close)
+}
+
+// AddHTTPServer adds an HTTP server to the binding. If the binding or the
+// group are already stopped an error is returned
+func (b *ServerBinding) AddHTTPServer(srv *http.Server) error {
+	return b.add(getHTTPServerStopFunc(srv))
+}
+
+// AddStopFunc adds a function to call when the binding is stopped. If the
+// binding or the group are already stopped the function is called and an
+// error is returned
+func (b *ServerBinding) AddStopFunc(fn func() error) error {
+	return b.add(fn)
+}
+
+func (b *ServerBinding) add(fn func() error) error {
+	b.group.Lock()
+	defer b.group.Unlock()
+
+	if b.group.stopped {
+		fn() //nolint:errcheck
+		return errServerGroupStopped
+	}
+	if b.stopped {
+		fn() //nolint:errcheck
+		return errBindingStopped
+	}
+	b.stopFns = append(b.stopFns, fn)
+	return nil
+}
+
+// IsStopped returns true if the binding or the group were stopped
+func (b *ServerBinding) IsStopped() bool {
+	b.group.Lock()
+	defer b.group.Unlock()
+
+	return b.stopped || b.group.stopped
+}
+
+func (b *ServerBinding) stop() {
+	b.group.Lock()
+	b.stopped = true
+	stopFns := b.stopFns
+	b.stopFns = nil
+	b.group.Unlock()
+
+	for _, fn := range stopFns {
+		fn() //nolint:errcheck
+	}
+}
+
+// IsSameBinding returns true if the specified bindings have the same exported
+// fields, the unexported ones are populated while starting the servers
+func IsSameBinding(binding1, binding2 interface{}) bool {
+	data1, err := json.Marshal(binding1)
+	if err != nil {
+		return false
+	}
+	data2, err := json.Marshal(binding2)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data1, data2)
+}
+
+func getHTTPServerStopFunc(srv *http.Server) func() error {
+	return func() error {
+		go func() {
+			// the listeners are closed immediately, then Shutdown waits for the active
+			// connections to become idle, we don't need to wait for it
+			srv.Shutdown(context.Background()) //nolint:errcheck
+		}()
+		return nil
+	}
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerGroup(t *testing.T) {
+	var group ServerGroup
+	group.Reset()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	err = group.AddListener(listener)
+	assert.NoError(t, err)
+	acceptErr := make(chan error, 1)
+	group.Go(func() {
+		_, err := listener.Accept()
+		acceptErr <- err
+	})
+	httpServer := &http.Server{}
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	err = group.AddHTTPServer(httpServer)
+	assert.NoError(t, err)
+	group.Go(func() {
+		httpServer.Serve(httpListener) //nolint:errcheck
+	})
+	stopCalled := false
+	err = group.AddStopFunc(func() error {
+		stopCalled = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, group.IsStopped())
+
+	err = group.Stop(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, group.IsStopped())
+	assert.True(t, stopCalled)
+	assert.Error(t, <-acceptErr)
+	// adding to a stopped group closes the listener
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	err = group.AddListener(listener)
+	assert.ErrorIs(t, err, errServerGroupStopped)
+	_, err = listener.Accept()
+	assert.Error(t, err)
+	// a goroutine not returning must respect the context
+	unblock := make(chan struct{})
+	group.Go(func() {
+		<-unblock
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = group.Stop(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	close(unblock)
+
+	group.Reset()
+	assert.False(t, group.IsStopped())
+	err = group.Stop(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestServerGroupBindings(t *testing.T) {
+	getFreeAddress := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := listener.Addr().String()
+		err = listener.Close()
+		require.NoError(t, err)
+		return addr
+	}
+	getStarter := func(addr string) BindingStarter {
+		return BindingStarter{
+			Key: addr,
+			Start: func(b *ServerBinding) error {
+				listener, err := net.Listen("tcp", addr)
+				if err != nil {
+					return err
+				}
+				if err := b.AddListener(listener); err != nil {
+					return nil
+				}
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						if b.IsStopped() {
+							return nil
+						}
+						return err
+					}
+					conn.Close()
+				}
+			},
+		}
+	}
+	isListening := func(addr string) bool {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	var group ServerGroup
+	group.Reset()
+	addr1 := getFreeAddress()
+	addr2 := getFreeAddress()
+	group.GoBinding(getStarter(addr1))
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- group.Wait()
+	}()
+	assert.Eventually(t, func() bool { return isListening(addr1) }, 2*time.Second, 50*time.Millisecond)
+	// replace the binding, the removed binding exit is not reported
+	err := group.ReplaceBindings(context.Background(), []BindingStarter{getStarter(addr1)},
+		[]BindingStarter{getStarter(addr2)})
+	assert.NoError(t, err)
+	assert.False(t, isListening(addr1))
+	assert.True(t, isListening(addr2))
+	// a binding that cannot be started restores the removed ones
+	listener, err := net.Listen("tcp", addr1)
+	require.NoError(t, err)
+	err = group.ReplaceBindings(context.Background(), []BindingStarter{getStarter(addr2)},
+		[]BindingStarter{getStarter(addr1)})
+	assert.Error(t, err)
+	err = listener.Close()
+	assert.NoError(t, err)
+	assert.False(t, isListening(addr1))
+	assert.True(t, isListening(addr2))
+	select {
+	case err := <-waitErr:
+		t.Fatalf("unexpected group exit: %v", err)
+	default:
+	}
+
+	err = group.Stop(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, <-waitErr)
+	assert.False(t, isListening(addr2))
+	err = group.ReplaceBindings(context.Background(), nil, []BindingStarter{getStarter(addr1)})
+	assert.ErrorIs(t, err, errServerGroupStopped)
+	// a failed binding is reported
+	group.Reset()
+	listener, err = net.Listen("tcp", addr1)
+	require.NoError(t, err)
+	group.GoBinding(getStarter(addr1))
+	assert.Error(t, group.Wait())
+	err = listener.Close()
+	assert.NoError(t, err)
+	err = group.Stop(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestIsSameBinding(t *testing.T) {
+	type testBinding struct {
+		Address string
+		Port    int
+		parsed  []func() bool
+	}
+
+	b1 := testBinding{Address: "127.0.0.1", Port: 2022}
+	b2 := testBinding{Address: "127.0.0.1", Port: 2022, parsed: []func() bool{func() bool { return true }}}
+	assert.True(t, IsSameBinding(b1, b2))
+	b2.Port = 2023
+	assert.False(t, IsSameBinding(b1, b2))
+	assert.False(t, IsSameBinding(b1, make(chan int)))
+}
@@ -7,9 +7,11 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/metric"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
 
@@ -40,6 +42,12 @@ type BaseTransfer struct { //nolint:maligned
 	AbortTransfer   int32
 	aTime           time.Time
 	mTime           time.Time
+	span            *tracing.Span
+	// bandwidth limit, start time and transferred bytes for the current
+	// throttling window, they are reset if the bandwidth limit changes
+	throttleBandwidth int64
+	throttleStart     time.Time
+	throttleBytes     int64
 	sync.Mutex
 	ErrTransfer error
 }
@@ -66,11 +74,47 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 		AbortTransfer:   0,
 		Fs:              fs,
 	}
+	t.throttleStart = t.start
+	if transferType == TransferDownload {
+		_, t.throttleBandwidth = conn.GetBandwidth()
+	} else {
+		t.throttleBandwidth, _ = conn.GetBandwidth()
+	}
+	t.startSpan()
 
 	conn.AddTransfer(t)
 	return t
 }
 
+func (t *BaseTransfer) startSpan() {
+	operation := operationUpload
+	if t.transferType == TransferDownload {
+		operation = operationDownload
+	}
+	_, t.span = tracing.StartSpan(tracing.GetConnectionContext(t.Connection.ID), "transfer "+operation,
+		tracing.String("sftpgo.username", t.Connection.User.Username),
+		tracing.String("sftpgo.protocol", t.Connection.protocol),
+		tracing.String("sftpgo.path", t.requestPath),
+		tracing.Int64("sftpgo.initial_size", t.InitialSize),
+		tracing.Bool("sftpgo.new_file", t.isNewFile))
+}
+
+func (t *BaseTransfer) endSpan(err error) {
+	t.span.SetAttributes(
+		tracing.Int64("sftpgo.bytes_sent", atomic.LoadInt64(&t.BytesSent)),
+		tracing.Int64("sftpgo.bytes_received", atomic.LoadInt64(&t.BytesReceived)))
+	t.span.End(err)
+}
+
+// UpdateMetrics updates the transfer metrics using the current transferred bytes and error
+func (t *BaseTransfer) UpdateMetrics() {
+	bytesSent := atomic.LoadInt64(&t.BytesSent)
+	bytesReceived := atomic.LoadInt64(&t.BytesReceived)
+	metric.TransferCompleted(bytesSent, bytesReceived, t.transferType, t.ErrTransfer)
+	metric.UserTransferCompleted(t.Connection.protocol, t.Connection.GetMetricsUserLabel(), bytesSent, bytesReceived,
+		t.transferType, t.ErrTransfer)
+}
+
 // SetFtpMode sets the FTP mode for the current transfer
 func (t *BaseTransfer) SetFtpMode(mode string) {
 	t.ftpMode = mode
@@ -157,7 +201,7 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 				if t.MaxWriteSize > 0 {
 					sizeDiff := initialSize - size
 					t.MaxWriteSize += sizeDiff
-					metric.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+					t.UpdateMetrics()
 					atomic.StoreInt64(&t.BytesReceived, 0)
 				}
 				t.Unlock()
@@ -196,19 +240,20 @@ func (t *BaseTransfer) TransferError(err error) {
 		atomic.LoadInt64(&t.BytesReceived), elapsed)
 }
 
-func (t *BaseTransfer) getUploadFileSize() (int64, error) {
-	var fileSize int64
+func (t *BaseTransfer) getUploadFileSize() (int64, int64, error) {
+	var fileSize, quotaSize int64
 	info, err := t.Fs.Stat(t.fsPath)
 	if err == nil {
 		fileSize = info.Size()
+		quotaSize = vfs.GetQuotaSize(info)
 	}
-	if vfs.IsCryptOsFs(t.Fs) && t.ErrTransfer != nil {
+	if (vfs.IsCryptOsFs(t.Fs) || (vfs.IsCompressedFs(t.Fs) && err == nil)) && t.ErrTransfer != nil {
 		errDelete := t.Fs.Remove(t.fsPath, false)
 		if errDelete != nil {
-			t.Connection.Log(logger.LevelWarn, "error removing partial crypto file %#v: %v", t.fsPath, errDelete)
+			t.Connection.Log(logger.LevelWarn, "error removing partial file %#v: %v", t.fsPath, errDelete)
 		}
 	}
-	return fileSize, err
+	return fileSize, quotaSize, err
 }
 
 // Close it is called when the transfer is completed.
@@ -224,7 +269,7 @@ func (t *BaseTransfer) Close() error {
 	if t.isNewFile {
 		numFiles = 1
 	}
-	metric.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+	t.UpdateMetrics()
 	if t.File != nil && t.Connection.IsQuotaExceededError(t.ErrTransfer) {
 		// if quota is exceeded we try to remove the partial file for uploads to local filesystem
 		err = t.Fs.Remove(t.File.Name(), false)
@@ -236,7 +281,7 @@ func (t *BaseTransfer) Close() error {
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %#v, deletion error: %v",
 			t.File.Name(), err)
 	} else if t.transferType == TransferUpload && t.effectiveFsPath != t.fsPath {
-		if t.ErrTransfer == nil || Config.UploadMode == UploadModeAtomicWithResume {
+		if t.ErrTransfer == nil || GetConfig().UploadMode == UploadModeAtomicWithResume {
 			err = t.Fs.Rename(t.effectiveFsPath, t.fsPath)
 			t.Connection.Log(logger.LevelDebug, "atomic upload completed, rename: %#v -> %#v, error: %v",
 				t.effectiveFsPath, t.fsPath, err)
@@ -252,6 +297,7 @@ func (t *BaseTransfer) Close() error {
 		}
 	}
 	elapsed := time.Since(t.start).Nanoseconds() / 1000000
+	t.auditTransfer(elapsed)
 	if t.transferType == TransferDownload {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesSent), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
@@ -259,12 +305,17 @@ func (t *BaseTransfer) Close() error {
 			atomic.LoadInt64(&t.BytesSent), t.ErrTransfer)
 	} else {
 		fileSize := atomic.LoadInt64(&t.BytesReceived) + t.MinWriteOffset
-		if statSize, err := t.getUploadFileSize(); err == nil {
+		quotaSize := fileSize
+		if statSize, statQuotaSize, err := t.getUploadFileSize(); err == nil {
 			fileSize = statSize
+			quotaSize = statQuotaSize
 		}
 		t.Connection.Log(logger.LevelDebug, "uploaded file size %v", fileSize)
-		t.updateQuota(numFiles, fileSize)
+		t.updateQuota(numFiles, quotaSize)
 		t.updateTimes()
+		if t.ErrTransfer == nil && err == nil {
+			deduplicate(t.Fs, &t.Connection.User, t.fsPath, t.Connection.ID, !t.mTime.IsZero())
+		}
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesReceived), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol, t.Connection.localAddr, t.Connection.remoteAddr, t.ftpMode)
 		ExecuteActionNotification(t.Connection, operationUpload, t.fsPath, t.requestPath, "", "", "", fileSize, t.ErrTransfer)
@@ -275,6 +326,7 @@ func (t *BaseTransfer) Close() error {
 			err = t.ErrTransfer
 		}
 	}
+	t.endSpan(err)
 	return err
 }
 
@@ -308,25 +360,65 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	return false
 }
 
-// HandleThrottle manage bandwidth throttling
+// HandleThrottle manage bandwidth throttling.
+// If the bandwidth limits change while the transfer is in progress,
+// throttling restarts from the current position using the new limits
 func (t *BaseTransfer) HandleThrottle() {
 	var wantedBandwidth int64
 	var trasferredBytes int64
+	uploadBandwidth, downloadBandwidth := t.Connection.GetBandwidth()
 	if t.transferType == TransferDownload {
-		wantedBandwidth = t.Connection.User.DownloadBandwidth
+		wantedBandwidth = downloadBandwidth
 		trasferredBytes = atomic.LoadInt64(&t.BytesSent)
 	} else {
-		wantedBandwidth = t.Connection.User.UploadBandwidth
+		wantedBandwidth = uploadBandwidth
 		trasferredBytes = atomic.LoadInt64(&t.BytesReceived)
 	}
-	if wantedBandwidth > 0 {
-		// real and wanted elapsed as milliseconds, bytes as kilobytes
-		realElapsed := time.Since(t.start).Nanoseconds() / 1000000
-		// trasferredBytes / 1024 = KB/s, we multiply for 1000 to get milliseconds
-		wantedElapsed := 1000 * (trasferredBytes / 1024) / wantedBandwidth
-		if wantedElapsed > realElapsed {
-			toSleep := time.Duration(wantedElapsed - realElapsed)
-			time.Sleep(toSleep * time.Millisecond)
-		}
+	if toSleep := t.getThrottleDelay(wantedBandwidth, trasferredBytes); toSleep > 0 {
+		time.Sleep(toSleep)
+	}
+}
+
+func (t *BaseTransfer) getThrottleDelay(wantedBandwidth, trasferredBytes int64) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	if wantedBandwidth != t.throttleBandwidth {
+		t.throttleBandwidth = wantedBandwidth
+		t.throttleStart = time.Now()
+		t.throttleBytes = trasferredBytes
+	}
+	if wantedBandwidth <= 0 {
+		return 0
+	}
+	// real and wanted elapsed as milliseconds, bytes as kilobytes
+	realElapsed := time.Since(t.throttleStart).Nanoseconds() / 1000000
+	// trasferredBytes / 1024 = KB/s, we multiply for 1000 to get milliseconds
+	wantedElapsed := 1000 * ((trasferredBytes - t.throttleBytes) / 1024) / wantedBandwidth
+	if wantedElapsed > realElapsed {
+		return time.Duration(wantedElapsed-realElapsed) * time.Millisecond
+	}
+	return 0
+}
+
+func (t *BaseTransfer) auditTransfer(elapsed int64) {
+	if !audit.IsEnabled() {
+		return
+	}
+	ev := audit.Event{
+		Event:        audit.EventUpload,
+		Username:     t.Connection.User.Username,
+		IP:           t.Connection.GetRemoteIP(),
+		Protocol:     t.Connection.protocol,
+		ConnectionID: t.Connection.ID,
+		Path:         t.requestPath,
+		Size:         atomic.LoadInt64(&t.BytesReceived),
+		ElapsedMs:    elapsed,
+	}
+	if t.transferType == TransferDownload {
+		ev.Event = audit.EventDownload
+		ev.Size = atomic.LoadInt64(&t.BytesSent)
 	}
+	ev.SetError(t.ErrTransfer)
+	audit.Log(ev)
 }
@@ -87,6 +87,36 @@ func TestTransferThrottling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTransferThrottlingChange(t *testing.T) {
+	u := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "test",
+		},
+	}
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	conn := NewBaseConnection("id", ProtocolSFTP, "", "", u)
+	uploadBandwidth, downloadBandwidth := conn.GetBandwidth()
+	assert.Equal(t, int64(0), uploadBandwidth)
+	assert.Equal(t, int64(0), downloadBandwidth)
+	transfer := NewBaseTransfer(nil, conn, nil, "", "", "", TransferUpload, 0, 0, 0, true, fs)
+	// the bytes transferred before the limit change must not be throttled
+	transfer.BytesReceived = 10485760
+	startTime := time.Now()
+	transfer.HandleThrottle()
+	conn.SetBandwidth(50, 0)
+	uploadBandwidth, downloadBandwidth = conn.GetBandwidth()
+	assert.Equal(t, int64(50), uploadBandwidth)
+	assert.Equal(t, int64(0), downloadBandwidth)
+	transfer.HandleThrottle()
+	assert.Less(t, time.Since(startTime), 1*time.Second)
+	transfer.BytesReceived += 25600
+	transfer.HandleThrottle()
+	elapsed := time.Since(startTime).Nanoseconds() / 1000000
+	assert.GreaterOrEqual(t, elapsed, int64(450), "upload bandwidth throttling not respected")
+	err := transfer.Close()
+	assert.NoError(t, err)
+}
+
 func TestRealPath(t *testing.T) {
 	testFile := filepath.Join(os.TempDir(), "afile.txt")
 	fs := vfs.NewOsFs("123", os.TempDir(), "")
@@ -275,13 +305,14 @@ func TestRemovePartialCryptoFile(t *testing.T) {
 	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, "", "", u)
 	transfer := NewBaseTransfer(nil, conn, nil, testFile, testFile, "/transfer_test_file", TransferUpload, 0, 0, 0, true, fs)
 	transfer.ErrTransfer = errors.New("test error")
-	_, err = transfer.getUploadFileSize()
+	_, _, err = transfer.getUploadFileSize()
 	assert.Error(t, err)
 	err = os.WriteFile(testFile, []byte("test data"), os.ModePerm)
 	assert.NoError(t, err)
-	size, err := transfer.getUploadFileSize()
+	size, quotaSize, err := transfer.getUploadFileSize()
 	assert.NoError(t, err)
 	assert.Equal(t, int64(9), size)
+	assert.Equal(t, int64(9), quotaSize)
 	assert.NoFileExists(t, testFile)
 }
 
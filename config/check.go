@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+type listenAddress struct {
+	service string
+	address string
+	port    int
+}
+
+func (a *listenAddress) isWildcard() bool {
+	switch a.address {
+	case "", "0.0.0.0", "::", "[::]":
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *listenAddress) conflictsWith(other listenAddress) bool {
+	if a.port != other.port {
+		return false
+	}
+	if a.port == 0 {
+		// unix domain sockets
+		return a.address == other.address
+	}
+	return a.address == other.address || a.isWildcard() || other.isWildcard()
+}
+
+func (a *listenAddress) String() string {
+	if a.port == 0 {
+		return a.address
+	}
+	return fmt.Sprintf("%v:%v", a.address, a.port)
+}
+
+// CheckConfig validates the loaded configuration without starting any service.
+// The bindings, TLS certificates, hooks and plugins are checked and the non-fatal
+// errors detected while loading the configuration are reported too.
+// The data provider and the KMS are not initialized, the caller must check them
+func CheckConfig(configDir string) []error {
+	var errs []error
+	for _, warn := range loadWarnings {
+		errs = append(errs, errors.New(warn))
+	}
+	errs = append(errs, checkBindings()...)
+	errs = append(errs, checkTLSConfigs(configDir)...)
+	errs = append(errs, checkHooks()...)
+	if err := plugin.ValidateConfigs(globalConf.PluginsConfig); err != nil {
+		errs = append(errs, fmt.Errorf("plugins: %w", err))
+	}
+	return errs
+}
+
+func getListenAddresses() []listenAddress {
+	var addresses []listenAddress
+	for _, b := range globalConf.SFTPD.Bindings {
+		if b.IsValid() {
+			addresses = append(addresses, listenAddress{service: "sftpd", address: b.Address, port: b.Port})
+		}
+	}
+	for _, b := range globalConf.FTPD.Bindings {
+		if b.IsValid() {
+			addresses = append(addresses, listenAddress{service: "ftpd", address: b.Address, port: b.Port})
+		}
+	}
+	for _, b := range globalConf.WebDAVD.Bindings {
+		if b.IsValid() {
+			addresses = append(addresses, listenAddress{service: "webdavd", address: b.Address, port: b.Port})
+		}
+	}
+	for _, b := range globalConf.HTTPDConfig.Bindings {
+		if b.IsValid() {
+			addresses = append(addresses, listenAddress{service: "httpd", address: b.Address, port: b.Port})
+		}
+	}
+	if globalConf.TelemetryConfig.ShouldBind() {
+		addresses = append(addresses, listenAddress{
+			service: "telemetry",
+			address: globalConf.TelemetryConfig.BindAddress,
+			port:    globalConf.TelemetryConfig.BindPort,
+		})
+	}
+	return addresses
+}
+
+func checkBindings() []error {
+	var errs []error
+	addresses := getListenAddresses()
+	for idx, a := range addresses {
+		if a.port > 65535 {
+			errs = append(errs, fmt.Errorf("%v: invalid port %v for address %#v", a.service, a.port, a.address))
+			continue
+		}
+		for _, other := range addresses[idx+1:] {
+			if a.conflictsWith(other) {
+				errs = append(errs, fmt.Errorf("%v: address %#v conflicts with the %v address %#v",
+					other.service, other.String(), a.service, a.String()))
+			}
+		}
+	}
+	if globalConf.FTPD.ShouldBind() {
+		portRange := globalConf.FTPD.PassivePortRange
+		if portRange.Start > 0 || portRange.End > 0 {
+			if portRange.Start < 1 || portRange.End > 65535 || portRange.Start > portRange.End {
+				errs = append(errs, fmt.Errorf("ftpd: invalid passive port range %v-%v", portRange.Start, portRange.End))
+			}
+		}
+	}
+	return errs
+}
+
+func checkTLSConfigs(configDir string) []error {
+	var errs []error
+	ftpdTLS := globalConf.FTPD.CertificateFile != "" || globalConf.FTPD.CertificateKeyFile != ""
+	for idx, b := range globalConf.FTPD.Bindings {
+		if b.IsValid() && b.TLSMode > 0 && !ftpdTLS {
+			errs = append(errs, fmt.Errorf("ftpd: binding %v requires TLS but no certificate is configured", idx))
+		}
+	}
+	if err := checkTLSFiles(globalConf.FTPD.CertificateFile, globalConf.FTPD.CertificateKeyFile,
+		globalConf.FTPD.CACertificates, globalConf.FTPD.CARevocationLists, configDir); err != nil {
+		errs = append(errs, fmt.Errorf("ftpd: %w", err))
+	}
+	webDAVTLS := globalConf.WebDAVD.CertificateFile != "" || globalConf.WebDAVD.CertificateKeyFile != ""
+	for idx, b := range globalConf.WebDAVD.Bindings {
+		if b.IsValid() && b.EnableHTTPS && !webDAVTLS {
+			errs = append(errs, fmt.Errorf("webdavd: binding %v requires HTTPS but no certificate is configured", idx))
+		}
+	}
+	if err := checkTLSFiles(globalConf.WebDAVD.CertificateFile, globalConf.WebDAVD.CertificateKeyFile,
+		globalConf.WebDAVD.CACertificates, globalConf.WebDAVD.CARevocationLists, configDir); err != nil {
+		errs = append(errs, fmt.Errorf("webdavd: %w", err))
+	}
+	httpdTLS := globalConf.HTTPDConfig.CertificateFile != "" || globalConf.HTTPDConfig.CertificateKeyFile != ""
+	for idx, b := range globalConf.HTTPDConfig.Bindings {
+		if b.IsValid() && b.EnableHTTPS && !httpdTLS {
+			errs = append(errs, fmt.Errorf("httpd: binding %v requires HTTPS but no certificate is configured", idx))
+		}
+	}
+	if err := checkTLSFiles(globalConf.HTTPDConfig.CertificateFile, globalConf.HTTPDConfig.CertificateKeyFile,
+		globalConf.HTTPDConfig.CACertificates, globalConf.HTTPDConfig.CARevocationLists, configDir); err != nil {
+		errs = append(errs, fmt.Errorf("httpd: %w", err))
+	}
+	if err := checkTLSFiles(globalConf.TelemetryConfig.CertificateFile, globalConf.TelemetryConfig.CertificateKeyFile,
+		nil, nil, configDir); err != nil {
+		errs = append(errs, fmt.Errorf("telemetry: %w", err))
+	}
+	return errs
+}
+
+func checkTLSFiles(certificateFile, certificateKeyFile string, caCertificates, caRevocationLists []string,
+	configDir string,
+) error {
+	if certificateFile == "" && certificateKeyFile == "" {
+		return nil
+	}
+	if certificateFile == "" || certificateKeyFile == "" {
+		return errors.New("both the certificate and the private key must be provided")
+	}
+	mgr, err := common.NewCertManager(getConfigPath(certificateFile, configDir),
+		getConfigPath(certificateKeyFile, configDir), configDir, logSender)
+	if err != nil {
+		return fmt.Errorf("unable to load the certificate: %w", err)
+	}
+	mgr.SetCACertificates(caCertificates)
+	if err := mgr.LoadRootCAs(); err != nil {
+		return fmt.Errorf("unable to load the CA certificates: %w", err)
+	}
+	mgr.SetCARevocationLists(caRevocationLists)
+	if err := mgr.LoadCRLs(); err != nil {
+		return fmt.Errorf("unable to load the CA revocation lists: %w", err)
+	}
+	return nil
+}
+
+func getConfigPath(name, configDir string) string {
+	if !util.IsFileInputValid(name) {
+		return ""
+	}
+	if name != "" && !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name)
+	}
+	return name
+}
+
+func checkHooks() []error {
+	hooks := []struct {
+		name string
+		hook string
+	}{
+		{"common.actions.hook", globalConf.Common.Actions.Hook},
+		{"common.startup_hook", globalConf.Common.StartupHook},
+		{"common.post_connect_hook", globalConf.Common.PostConnectHook},
+		{"common.post_disconnect_hook", globalConf.Common.PostDisconnectHook},
+		{"common.data_retention_hook", globalConf.Common.DataRetentionHook},
+		{"sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook},
+		{"data_provider.actions.hook", globalConf.ProviderConf.Actions.Hook},
+		{"data_provider.external_auth_hook", globalConf.ProviderConf.ExternalAuthHook},
+		{"data_provider.pre_login_hook", globalConf.ProviderConf.PreLoginHook},
+		{"data_provider.post_login_hook", globalConf.ProviderConf.PostLoginHook},
+		{"data_provider.check_password_hook", globalConf.ProviderConf.CheckPasswordHook},
+	}
+	var errs []error
+	for _, h := range hooks {
+		if err := checkHook(h.hook); err != nil {
+			errs = append(errs, fmt.Errorf("%v: %w", h.name, err))
+		}
+	}
+	return errs
+}
+
+// checkHook validates a hook, it can be an HTTP URL or the absolute path to an executable
+func checkHook(hook string) error {
+	if hook == "" {
+		return nil
+	}
+	if strings.HasPrefix(hook, "http") {
+		u, err := url.Parse(hook)
+		if err != nil {
+			return fmt.Errorf("invalid URL %#v: %w", util.GetRedactedURL(hook), err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid URL %#v", util.GetRedactedURL(hook))
+		}
+		return nil
+	}
+	if !filepath.IsAbs(hook) {
+		return fmt.Errorf("invalid hook %#v, it must be an absolute path or an HTTP URL", hook)
+	}
+	info, err := os.Stat(hook)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hook %#v is not a regular file", hook)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("hook %#v is not executable, mode: %v", hook, info.Mode())
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/spf13/viper"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/ftpd"
@@ -18,11 +19,13 @@ import (
 	"github.com/drakkan/sftpgo/v2/httpd"
 	"github.com/drakkan/sftpgo/v2/kms"
 	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/mfa"
 	"github.com/drakkan/sftpgo/v2/plugin"
 	"github.com/drakkan/sftpgo/v2/sftpd"
 	"github.com/drakkan/sftpgo/v2/smtp"
 	"github.com/drakkan/sftpgo/v2/telemetry"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/version"
 	"github.com/drakkan/sftpgo/v2/webdavd"
@@ -40,12 +43,15 @@ const (
 
 var (
 	globalConf          globalConfig
+	loadWarnings        []string
 	defaultSFTPDBanner  = fmt.Sprintf("SFTPGo_%v", version.Get().Version)
 	defaultFTPDBanner   = fmt.Sprintf("SFTPGo %v ready", version.Get().Version)
 	defaultSFTPDBinding = sftpd.Binding{
 		Address:          "",
 		Port:             2022,
 		ApplyProxyConfig: true,
+		HostKeys:         []string{},
+		HostCertificates: []string{},
 	}
 	defaultFTPDBinding = ftpd.Binding{
 		Address:                    "",
@@ -58,6 +64,7 @@ var (
 		TLSCipherSuites:            nil,
 		PassiveConnectionsSecurity: 0,
 		ActiveConnectionsSecurity:  0,
+		TLSSessionReuse:            0,
 		Debug:                      false,
 	}
 	defaultWebDAVDBinding = webdavd.Binding{
@@ -68,6 +75,7 @@ var (
 		TLSCipherSuites: nil,
 		Prefix:          "",
 		ProxyAllowed:    nil,
+		TLSUsername:     "",
 	}
 	defaultHTTPDBinding = httpd.Binding{
 		Address:               "127.0.0.1",
@@ -81,6 +89,7 @@ var (
 		HideLoginURL:          0,
 		RenderOpenAPI:         true,
 		WebClientIntegrations: nil,
+		TLSUsername:           "",
 	}
 	defaultRateLimiter = common.RateLimiterConfig{
 		Average:                0,
@@ -133,15 +142,16 @@ func Init() {
 				ExecuteSync: []string{},
 				Hook:        "",
 			},
-			SetstatMode:           0,
-			TempPath:              "",
-			ProxyProtocol:         0,
-			ProxyAllowed:          []string{},
-			PostConnectHook:       "",
-			PostDisconnectHook:    "",
-			DataRetentionHook:     "",
-			MaxTotalConnections:   0,
-			MaxPerHostConnections: 20,
+			SetstatMode:             0,
+			TempPath:                "",
+			ProxyProtocol:           0,
+			ProxyAllowed:            []string{},
+			PostConnectHook:         "",
+			PostDisconnectHook:      "",
+			DataRetentionHook:       "",
+			MaxTotalConnections:     0,
+			MaxPerHostConnections:   20,
+			GracefulShutdownTimeout: 0,
 			DefenderConfig: common.DefenderConfig{
 				Enabled:            false,
 				Driver:             common.DefenderDriverMemory,
@@ -158,16 +168,39 @@ func Init() {
 				BlockListFile:      "",
 			},
 			RateLimitersConfig: []common.RateLimiterConfig{defaultRateLimiter},
+			DedupConfig: common.DedupConfig{
+				Enabled:      false,
+				StorePath:    "",
+				MinSize:      4096,
+				SyncInterval: 60,
+			},
+			AuditLog: audit.Config{
+				Output:     "",
+				FilePath:   "",
+				MaxSize:    10,
+				MaxBackups: 5,
+				MaxAge:     28,
+				Compress:   false,
+				Syslog: audit.SyslogConfig{
+					Network:  "udp",
+					Address:  "",
+					Facility: "local0",
+					AppName:  "sftpgo",
+				},
+				BufferSize: 1000,
+			},
 		},
 		SFTPD: sftpd.Configuration{
 			Banner:                            defaultSFTPDBanner,
 			Bindings:                          []sftpd.Binding{defaultSFTPDBinding},
 			MaxAuthTries:                      0,
 			HostKeys:                          []string{},
+			HostCertificates:                  []string{},
 			KexAlgorithms:                     []string{},
 			Ciphers:                           []string{},
 			MACs:                              []string{},
 			TrustedUserCAKeys:                 []string{},
+			RevokedUserCertsFile:              "",
 			LoginBannerFile:                   "",
 			EnabledSSHCommands:                []string{},
 			KeyboardInteractiveAuthentication: false,
@@ -186,8 +219,10 @@ func Init() {
 			},
 			DisableActiveMode:  false,
 			EnableSite:         false,
+			SiteCommands:       []ftpd.SiteCommand{},
 			HASHSupport:        0,
 			CombineSupport:     0,
+			MLSxFacts:          []string{},
 			CertificateFile:    "",
 			CertificateKeyFile: "",
 			CACertificates:     []string{},
@@ -218,6 +253,10 @@ func Init() {
 					MaxSize: 1000,
 				},
 			},
+			DeadProperties: webdavd.DeadPropertiesConfig{
+				MaxCount: 100,
+				MaxSize:  65536,
+			},
 		},
 		ProviderConf: dataprovider.Config{
 			Driver:           "sqlite",
@@ -323,6 +362,20 @@ func Init() {
 			CertificateFile:    "",
 			CertificateKeyFile: "",
 			TLSCipherSuites:    nil,
+			Metrics: metric.Config{
+				LabeledMetrics:         false,
+				UserLabel:              metric.UserLabelUsername,
+				MaxUserLabelValues:     100,
+				AllowedUserLabelValues: nil,
+			},
+			Tracing: tracing.Config{
+				Endpoint:      "",
+				URLPath:       tracing.DefaultURLPath,
+				Insecure:      false,
+				Headers:       nil,
+				ServiceName:   tracing.DefaultServiceName,
+				SamplingRatio: 1,
+			},
 		},
 		PluginsConfig: nil,
 		SMTPConfig: smtp.Config{
@@ -479,14 +532,39 @@ func getRedactedGlobalConf() globalConfig {
 	conf.Common.PostDisconnectHook = util.GetRedactedURL(conf.Common.PostDisconnectHook)
 	conf.Common.DataRetentionHook = util.GetRedactedURL(conf.Common.DataRetentionHook)
 	conf.SFTPD.KeyboardInteractiveHook = util.GetRedactedURL(conf.SFTPD.KeyboardInteractiveHook)
-	conf.HTTPDConfig.SigningPassphrase = getRedactedPassword()
-	conf.ProviderConf.Password = getRedactedPassword()
+	if conf.HTTPDConfig.SigningPassphrase != "" {
+		conf.HTTPDConfig.SigningPassphrase = getRedactedPassword()
+	}
+	if conf.ProviderConf.Password != "" {
+		conf.ProviderConf.Password = getRedactedPassword()
+	}
 	conf.ProviderConf.Actions.Hook = util.GetRedactedURL(conf.ProviderConf.Actions.Hook)
 	conf.ProviderConf.ExternalAuthHook = util.GetRedactedURL(conf.ProviderConf.ExternalAuthHook)
 	conf.ProviderConf.PreLoginHook = util.GetRedactedURL(conf.ProviderConf.PreLoginHook)
 	conf.ProviderConf.PostLoginHook = util.GetRedactedURL(conf.ProviderConf.PostLoginHook)
 	conf.ProviderConf.CheckPasswordHook = util.GetRedactedURL(conf.ProviderConf.CheckPasswordHook)
-	conf.SMTPConfig.Password = getRedactedPassword()
+	if conf.ProviderConf.ConnectionString != "" {
+		conf.ProviderConf.ConnectionString = getRedactedPassword()
+	}
+	if conf.SMTPConfig.Password != "" {
+		conf.SMTPConfig.Password = getRedactedPassword()
+	}
+	if conf.KMSConfig.Secrets.MasterKeyString != "" {
+		conf.KMSConfig.Secrets.MasterKeyString = getRedactedPassword()
+	}
+	// header values could contain credentials, slices and maps are copied
+	// to avoid changing the global configuration
+	conf.HTTPConfig.Headers = nil
+	for _, h := range globalConf.HTTPConfig.Headers {
+		h.Value = getRedactedPassword()
+		conf.HTTPConfig.Headers = append(conf.HTTPConfig.Headers, h)
+	}
+	if len(globalConf.TelemetryConfig.Tracing.Headers) > 0 {
+		conf.TelemetryConfig.Tracing.Headers = make(map[string]string)
+		for k := range globalConf.TelemetryConfig.Tracing.Headers {
+			conf.TelemetryConfig.Tracing.Headers[k] = getRedactedPassword()
+		}
+	}
 	return conf
 }
 
@@ -506,7 +584,27 @@ func setConfigFile(configDir, configFile string) {
 // $HOME/.config/sftpgo and /etc/sftpgo too.
 // configFile is an absolute or relative path (to the config dir) to the configuration file.
 func LoadConfig(configDir, configFile string) error {
+	return loadConfig(configDir, configFile, false)
+}
+
+// ReloadConfig loads the configuration again from the configuration file and
+// the environment variables. The configuration is rebuilt starting from the
+// defaults, so removed bindings and settings are not retained. If the
+// configuration file cannot be read or parsed the current configuration is
+// preserved and an error is returned
+func ReloadConfig(configDir, configFile string) error {
+	currentConf := globalConf
+	Init()
+	if err := loadConfig(configDir, configFile, true); err != nil {
+		globalConf = currentConf
+		return err
+	}
+	return nil
+}
+
+func loadConfig(configDir, configFile string, failOnReadError bool) error {
 	var err error
+	loadWarnings = nil
 	viper.AddConfigPath(configDir)
 	setViperAdditionalConfigPaths()
 	viper.AddConfigPath(".")
@@ -518,9 +616,14 @@ func LoadConfig(configDir, configFile string) error {
 		if errors.As(err, &viper.ConfigFileNotFoundError{}) {
 			logger.Debug(logSender, "", "no configuration file found")
 		} else {
+			if failOnReadError {
+				logger.Warn(logSender, "", "error loading configuration file: %v", err)
+				return fmt.Errorf("error loading configuration file: %w", err)
+			}
 			// should we return the error and not start here?
 			logger.Warn(logSender, "", "error loading configuration file: %v", err)
 			logger.WarnToConsole("error loading configuration file: %v", err)
+			loadWarnings = append(loadWarnings, fmt.Sprintf("error loading configuration file: %v", err))
 		}
 	}
 	err = viper.Unmarshal(&globalConf)
@@ -546,34 +649,29 @@ func resetInvalidConfigs() {
 	if globalConf.ProviderConf.UsersBaseDir != "" && !util.IsFileInputValid(globalConf.ProviderConf.UsersBaseDir) {
 		warn := fmt.Sprintf("invalid users base dir %#v will be ignored", globalConf.ProviderConf.UsersBaseDir)
 		globalConf.ProviderConf.UsersBaseDir = ""
-		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		addLoadWarning(warn)
 	}
 	if globalConf.Common.UploadMode < 0 || globalConf.Common.UploadMode > 2 {
 		warn := fmt.Sprintf("invalid upload_mode 0, 1 and 2 are supported, configured: %v reset upload_mode to 0",
 			globalConf.Common.UploadMode)
 		globalConf.Common.UploadMode = 0
-		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		addLoadWarning(warn)
 	}
 	if globalConf.Common.ProxyProtocol < 0 || globalConf.Common.ProxyProtocol > 2 {
 		warn := fmt.Sprintf("invalid proxy_protocol 0, 1 and 2 are supported, configured: %v reset proxy_protocol to 0",
 			globalConf.Common.ProxyProtocol)
 		globalConf.Common.ProxyProtocol = 0
-		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		addLoadWarning(warn)
 	}
 	if globalConf.ProviderConf.ExternalAuthScope < 0 || globalConf.ProviderConf.ExternalAuthScope > 15 {
 		warn := fmt.Sprintf("invalid external_auth_scope: %v reset to 0", globalConf.ProviderConf.ExternalAuthScope)
 		globalConf.ProviderConf.ExternalAuthScope = 0
-		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		addLoadWarning(warn)
 	}
 	if globalConf.ProviderConf.CredentialsPath == "" {
 		warn := "invalid credentials path, reset to \"credentials\""
 		globalConf.ProviderConf.CredentialsPath = "credentials"
-		logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-		logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+		addLoadWarning(warn)
 	}
 	if globalConf.Common.DefenderConfig.Enabled && globalConf.Common.DefenderConfig.Driver == common.DefenderDriverProvider {
 		if !globalConf.ProviderConf.IsDefenderSupported() {
@@ -582,12 +680,23 @@ func resetInvalidConfigs() {
 				"implementation please switch to a shared/distributed data provider",
 				globalConf.ProviderConf.Driver)
 			globalConf.Common.DefenderConfig.Driver = common.DefenderDriverMemory
-			logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
-			logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+			addLoadWarning(warn)
 		}
 	}
 }
 
+func addLoadWarning(warn string) {
+	logger.Warn(logSender, "", "Non-fatal configuration error: %v", warn)
+	logger.WarnToConsole("Non-fatal configuration error: %v", warn)
+	loadWarnings = append(loadWarnings, warn)
+}
+
+// GetLoadWarnings returns the non-fatal errors detected while loading the
+// configuration. The invalid settings are replaced with the defaults
+func GetLoadWarnings() []string {
+	return loadWarnings
+}
+
 func loadBindingsFromEnv() {
 	for idx := 0; idx < 10; idx++ {
 		getTOTPFromEnv(idx)
@@ -861,6 +970,18 @@ func getSFTPDBindindFromEnv(idx int) {
 		isSet = true
 	}
 
+	hostKeys, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__HOST_KEYS", idx))
+	if ok {
+		binding.HostKeys = hostKeys
+		isSet = true
+	}
+
+	hostCertificates, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__HOST_CERTIFICATES", idx))
+	if ok {
+		binding.HostCertificates = hostCertificates
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.SFTPD.Bindings) > idx {
 			globalConf.SFTPD.Bindings[idx] = binding
@@ -965,6 +1086,12 @@ func getFTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tlsSessionReuse, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__TLS_SESSION_REUSE", idx))
+	if ok {
+		binding.TLSSessionReuse = int(tlsSessionReuse)
+		isSet = true
+	}
+
 	debug, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_FTPD__BINDINGS__%v__DEBUG", idx))
 	if ok {
 		binding.Debug = debug
@@ -1030,6 +1157,12 @@ func getWebDAVDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tlsUsername, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_WEBDAVD__BINDINGS__%v__TLS_USERNAME", idx))
+	if ok {
+		binding.TLSUsername = tlsUsername
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.WebDAVD.Bindings) > idx {
 			globalConf.WebDAVD.Bindings[idx] = binding
@@ -1142,6 +1275,12 @@ func getHTTPDBindingFromEnv(idx int) {
 		isSet = true
 	}
 
+	tlsUsername, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTPD__BINDINGS__%v__TLS_USERNAME", idx))
+	if ok {
+		binding.TLSUsername = tlsUsername
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.HTTPDConfig.Bindings) > idx {
 			globalConf.HTTPDConfig.Bindings[idx] = binding
@@ -1215,6 +1354,7 @@ func setViperDefaults() {
 	viper.SetDefault("common.data_retention_hook", globalConf.Common.DataRetentionHook)
 	viper.SetDefault("common.max_total_connections", globalConf.Common.MaxTotalConnections)
 	viper.SetDefault("common.max_per_host_connections", globalConf.Common.MaxPerHostConnections)
+	viper.SetDefault("common.graceful_shutdown_timeout", globalConf.Common.GracefulShutdownTimeout)
 	viper.SetDefault("common.defender.enabled", globalConf.Common.DefenderConfig.Enabled)
 	viper.SetDefault("common.defender.driver", globalConf.Common.DefenderConfig.Driver)
 	viper.SetDefault("common.defender.ban_time", globalConf.Common.DefenderConfig.BanTime)
@@ -1228,13 +1368,30 @@ func setViperDefaults() {
 	viper.SetDefault("common.defender.entries_hard_limit", globalConf.Common.DefenderConfig.EntriesHardLimit)
 	viper.SetDefault("common.defender.safelist_file", globalConf.Common.DefenderConfig.SafeListFile)
 	viper.SetDefault("common.defender.blocklist_file", globalConf.Common.DefenderConfig.BlockListFile)
+	viper.SetDefault("common.deduplication.enabled", globalConf.Common.DedupConfig.Enabled)
+	viper.SetDefault("common.deduplication.store_path", globalConf.Common.DedupConfig.StorePath)
+	viper.SetDefault("common.deduplication.min_size", globalConf.Common.DedupConfig.MinSize)
+	viper.SetDefault("common.deduplication.sync_interval", globalConf.Common.DedupConfig.SyncInterval)
+	viper.SetDefault("common.audit_log.output", globalConf.Common.AuditLog.Output)
+	viper.SetDefault("common.audit_log.file_path", globalConf.Common.AuditLog.FilePath)
+	viper.SetDefault("common.audit_log.max_size", globalConf.Common.AuditLog.MaxSize)
+	viper.SetDefault("common.audit_log.max_backups", globalConf.Common.AuditLog.MaxBackups)
+	viper.SetDefault("common.audit_log.max_age", globalConf.Common.AuditLog.MaxAge)
+	viper.SetDefault("common.audit_log.compress", globalConf.Common.AuditLog.Compress)
+	viper.SetDefault("common.audit_log.syslog.network", globalConf.Common.AuditLog.Syslog.Network)
+	viper.SetDefault("common.audit_log.syslog.address", globalConf.Common.AuditLog.Syslog.Address)
+	viper.SetDefault("common.audit_log.syslog.facility", globalConf.Common.AuditLog.Syslog.Facility)
+	viper.SetDefault("common.audit_log.syslog.app_name", globalConf.Common.AuditLog.Syslog.AppName)
+	viper.SetDefault("common.audit_log.buffer_size", globalConf.Common.AuditLog.BufferSize)
 	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
 	viper.SetDefault("sftpd.banner", globalConf.SFTPD.Banner)
 	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
+	viper.SetDefault("sftpd.host_certificates", globalConf.SFTPD.HostCertificates)
 	viper.SetDefault("sftpd.kex_algorithms", globalConf.SFTPD.KexAlgorithms)
 	viper.SetDefault("sftpd.ciphers", globalConf.SFTPD.Ciphers)
 	viper.SetDefault("sftpd.macs", globalConf.SFTPD.MACs)
 	viper.SetDefault("sftpd.trusted_user_ca_keys", globalConf.SFTPD.TrustedUserCAKeys)
+	viper.SetDefault("sftpd.revoked_user_certs_file", globalConf.SFTPD.RevokedUserCertsFile)
 	viper.SetDefault("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
 	viper.SetDefault("sftpd.enabled_ssh_commands", sftpd.GetDefaultSSHCommands())
 	viper.SetDefault("sftpd.keyboard_interactive_authentication", globalConf.SFTPD.KeyboardInteractiveAuthentication)
@@ -1250,6 +1407,7 @@ func setViperDefaults() {
 	viper.SetDefault("ftpd.enable_site", globalConf.FTPD.EnableSite)
 	viper.SetDefault("ftpd.hash_support", globalConf.FTPD.HASHSupport)
 	viper.SetDefault("ftpd.combine_support", globalConf.FTPD.CombineSupport)
+	viper.SetDefault("ftpd.mlsx_facts", globalConf.FTPD.MLSxFacts)
 	viper.SetDefault("ftpd.certificate_file", globalConf.FTPD.CertificateFile)
 	viper.SetDefault("ftpd.certificate_key_file", globalConf.FTPD.CertificateKeyFile)
 	viper.SetDefault("ftpd.ca_certificates", globalConf.FTPD.CACertificates)
@@ -1269,6 +1427,8 @@ func setViperDefaults() {
 	viper.SetDefault("webdavd.cache.users.max_size", globalConf.WebDAVD.Cache.Users.MaxSize)
 	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
 	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
+	viper.SetDefault("webdavd.dead_properties.max_count", globalConf.WebDAVD.DeadProperties.MaxCount)
+	viper.SetDefault("webdavd.dead_properties.max_size", globalConf.WebDAVD.DeadProperties.MaxSize)
 	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
 	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
 	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
@@ -1340,6 +1500,15 @@ func setViperDefaults() {
 	viper.SetDefault("telemetry.certificate_file", globalConf.TelemetryConfig.CertificateFile)
 	viper.SetDefault("telemetry.certificate_key_file", globalConf.TelemetryConfig.CertificateKeyFile)
 	viper.SetDefault("telemetry.tls_cipher_suites", globalConf.TelemetryConfig.TLSCipherSuites)
+	viper.SetDefault("telemetry.metrics.labeled_metrics", globalConf.TelemetryConfig.Metrics.LabeledMetrics)
+	viper.SetDefault("telemetry.metrics.user_label", globalConf.TelemetryConfig.Metrics.UserLabel)
+	viper.SetDefault("telemetry.metrics.max_user_label_values", globalConf.TelemetryConfig.Metrics.MaxUserLabelValues)
+	viper.SetDefault("telemetry.metrics.allowed_user_label_values", globalConf.TelemetryConfig.Metrics.AllowedUserLabelValues)
+	viper.SetDefault("telemetry.tracing.endpoint", globalConf.TelemetryConfig.Tracing.Endpoint)
+	viper.SetDefault("telemetry.tracing.url_path", globalConf.TelemetryConfig.Tracing.URLPath)
+	viper.SetDefault("telemetry.tracing.insecure", globalConf.TelemetryConfig.Tracing.Insecure)
+	viper.SetDefault("telemetry.tracing.service_name", globalConf.TelemetryConfig.Tracing.ServiceName)
+	viper.SetDefault("telemetry.tracing.sampling_ratio", globalConf.TelemetryConfig.Tracing.SamplingRatio)
 	viper.SetDefault("smtp.host", globalConf.SMTPConfig.Host)
 	viper.SetDefault("smtp.port", globalConf.SMTPConfig.Port)
 	viper.SetDefault("smtp.from", globalConf.SMTPConfig.From)
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported formats for the effective configuration dump
+const (
+	DumpFormatJSON = "json"
+	DumpFormatYAML = "yaml"
+	DumpFormatEnv  = "env"
+)
+
+// DumpConfig writes the effective configuration, merged from defaults,
+// configuration file and environment variables, using the specified format.
+// Passwords, keys and credentials embedded in URLs are redacted
+func DumpConfig(w io.Writer, format string) error {
+	data, err := json.MarshalIndent(getRedactedGlobalConf(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == DumpFormatJSON {
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	// JSON is valid YAML, decoding into a node preserves the keys order
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	switch format {
+	case DumpFormatYAML:
+		resetNodeStyle(root.Content[0])
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(root.Content[0]); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case DumpFormatEnv:
+		for _, line := range getEnvLines(strings.ToUpper(configEnvPrefix), root.Content[0]) {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported dump format %#v", format)
+	}
+}
+
+// resetNodeStyle removes the JSON flow style and quoting so the output looks
+// like an handwritten YAML configuration file
+func resetNodeStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		resetNodeStyle(n)
+	}
+}
+
+// getEnvLines returns the environment variables needed to reproduce the
+// configuration. Lists of values are comma separated, lists of objects are
+// traversed using the element index
+func getEnvLines(prefix string, node *yaml.Node) []string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		var lines []string
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key := prefix + "__" + strings.ToUpper(node.Content[idx].Value)
+			if prefix == strings.ToUpper(configEnvPrefix) {
+				key = prefix + "_" + strings.ToUpper(node.Content[idx].Value)
+			}
+			lines = append(lines, getEnvLines(key, node.Content[idx+1])...)
+		}
+		return lines
+	case yaml.SequenceNode:
+		var values []string
+		var lines []string
+		for idx, n := range node.Content {
+			if n.Kind == yaml.ScalarNode {
+				values = append(values, n.Value)
+				continue
+			}
+			lines = append(lines, getEnvLines(prefix+"__"+strconv.Itoa(idx), n)...)
+		}
+		if len(lines) > 0 {
+			return lines
+		}
+		return []string{fmt.Sprintf("%v=%v", prefix, strings.Join(values, ","))}
+	default:
+		if node.Tag == "!!null" {
+			return []string{fmt.Sprintf("%v=", prefix)}
+		}
+		return []string{fmt.Sprintf("%v=%v", prefix, node.Value)}
+	}
+}
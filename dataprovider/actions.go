@@ -13,6 +13,7 @@ import (
 
 	"github.com/sftpgo/sdk/plugin/notifier"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/httpclient"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/plugin"
@@ -35,6 +36,14 @@ const (
 )
 
 func executeAction(operation, executor, ip, objectType, objectName string, object plugin.Renderer) {
+	audit.Log(audit.Event{
+		Event:      audit.EventAdminAction,
+		Username:   executor,
+		IP:         ip,
+		Action:     operation,
+		ObjectType: objectType,
+		ObjectName: objectName,
+	})
 	if plugin.Handler.HasNotifiers() {
 		plugin.Handler.NotifyProviderEvent(&notifier.ProviderEvent{
 			Action:     operation,
@@ -90,6 +99,21 @@ func executeAction(operation, executor, ip, objectType, objectName string, objec
 	}()
 }
 
+func auditLoginEvent(username, loginMethod, ip, protocol string, err error) {
+	if !audit.IsEnabled() {
+		return
+	}
+	ev := audit.Event{
+		Event:       audit.EventLogin,
+		Username:    username,
+		IP:          ip,
+		Protocol:    protocol,
+		LoginMethod: loginMethod,
+	}
+	ev.SetError(err)
+	audit.Log(ev)
+}
+
 func executeNotificationCommand(operation, executor, ip, objectType, objectName string, objectAsJSON []byte) error {
 	if !filepath.IsAbs(config.Actions.Hook) {
 		err := fmt.Errorf("invalid notification command %#v", config.Actions.Hook)
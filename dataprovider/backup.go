@@ -0,0 +1,371 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+// Supported object types for selective dumps and restores
+const (
+	BackupObjectUsers   = "users"
+	BackupObjectFolders = "folders"
+	BackupObjectAdmins  = "admins"
+	BackupObjectAPIKeys = "api_keys"
+	BackupObjectShares  = "shares"
+)
+
+// Supported actions for a backup diff entry
+const (
+	// the object does not exist and will be created
+	BackupDiffCreate = "create"
+	// the object exists, is different and will be updated
+	BackupDiffUpdate = "update"
+	// the object exists and is different but existing objects are not updated
+	BackupDiffSkip = "skip"
+	// the object is not included in the backup and will be deleted
+	BackupDiffDelete = "delete"
+)
+
+var (
+	// BackupObjectTypes defines all the supported object types in restore order
+	BackupObjectTypes = []string{BackupObjectFolders, BackupObjectUsers, BackupObjectAdmins, BackupObjectAPIKeys,
+		BackupObjectShares}
+	// fields that change without an explicit update, they are ignored while comparing objects
+	backupVolatileFields = []string{"id", "users", "used_quota_size", "used_quota_files", "last_quota_update",
+		"last_login", "created_at", "updated_at", "last_use_at", "used_tokens"}
+	// user specific fields for virtual folders
+	backupUserFolderFields = []string{"name", "virtual_path", "quota_size", "quota_files"}
+)
+
+// BackupFilter defines the objects to include in a dump or in a restore
+type BackupFilter struct {
+	// Object types to include, empty means all the supported types
+	Types []string
+	// Shell patterns, as supported by path.Match, the object names must match
+	// at least one of them. Users and admins are matched by username, folders by
+	// name, API keys and shares by name or ID. Empty means all the objects
+	Names []string
+}
+
+// Validate returns an error if the filter is not valid
+func (f *BackupFilter) Validate() error {
+	for _, t := range f.Types {
+		if !util.IsStringInSlice(t, BackupObjectTypes) {
+			return util.NewValidationError(fmt.Sprintf("invalid object type %#v, supported types: %v", t, BackupObjectTypes))
+		}
+	}
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid name pattern %#v: %v", pattern, err))
+		}
+	}
+	return nil
+}
+
+// HasType returns true if the specified object type is included
+func (f *BackupFilter) HasType(objectType string) bool {
+	return len(f.Types) == 0 || util.IsStringInSlice(objectType, f.Types)
+}
+
+func (f *BackupFilter) matchName(names ...string) bool {
+	if len(f.Names) == 0 {
+		return true
+	}
+	for _, pattern := range f.Names {
+		for _, name := range names {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply removes the objects excluded by the filter from the specified backup
+func (f *BackupFilter) Apply(data *BackupData) {
+	users := make([]User, 0)
+	if f.HasType(BackupObjectUsers) {
+		for _, user := range data.Users {
+			if f.matchName(user.Username) {
+				users = append(users, user)
+			}
+		}
+	}
+	folders := make([]vfs.BaseVirtualFolder, 0)
+	if f.HasType(BackupObjectFolders) {
+		for _, folder := range data.Folders {
+			if f.matchName(folder.Name) {
+				folders = append(folders, folder)
+			}
+		}
+	}
+	admins := make([]Admin, 0)
+	if f.HasType(BackupObjectAdmins) {
+		for _, admin := range data.Admins {
+			if f.matchName(admin.Username) {
+				admins = append(admins, admin)
+			}
+		}
+	}
+	apiKeys := make([]APIKey, 0)
+	if f.HasType(BackupObjectAPIKeys) {
+		for _, apiKey := range data.APIKeys {
+			if f.matchName(apiKey.Name, apiKey.KeyID) {
+				apiKeys = append(apiKeys, apiKey)
+			}
+		}
+	}
+	shares := make([]Share, 0)
+	if f.HasType(BackupObjectShares) {
+		for _, share := range data.Shares {
+			if f.matchName(share.Name, share.ShareID) {
+				shares = append(shares, share)
+			}
+		}
+	}
+	data.Users = users
+	data.Folders = folders
+	data.Admins = admins
+	data.APIKeys = apiKeys
+	data.Shares = shares
+}
+
+// BackupDiffEntry defines an object that a restore will change
+type BackupDiffEntry struct {
+	// Object type, one of the supported backup object types
+	Type string `json:"type"`
+	// Username, folder name, API key ID or share ID
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+type backupObject struct {
+	name   string
+	object interface{}
+}
+
+func getBackupObjects(data *BackupData, objectType string) []backupObject {
+	var objects []backupObject
+	switch objectType {
+	case BackupObjectUsers:
+		for _, user := range data.Users {
+			objects = append(objects, backupObject{name: user.Username, object: user})
+		}
+	case BackupObjectFolders:
+		for _, folder := range data.Folders {
+			objects = append(objects, backupObject{name: folder.Name, object: folder})
+		}
+	case BackupObjectAdmins:
+		for _, admin := range data.Admins {
+			objects = append(objects, backupObject{name: admin.Username, object: admin})
+		}
+	case BackupObjectAPIKeys:
+		for _, apiKey := range data.APIKeys {
+			objects = append(objects, backupObject{name: apiKey.KeyID, object: apiKey})
+		}
+	case BackupObjectShares:
+		for _, share := range data.Shares {
+			objects = append(objects, backupObject{name: share.ShareID, object: share})
+		}
+	}
+	return objects
+}
+
+// GetBackupDiff compares the specified backup with the objects stored in the
+// data provider and returns the changes that a restore will make.
+// The filter is applied to both the backup and the stored objects, restore mode
+// 1 does not update existing objects. If deleteMissing is true the stored
+// objects not included in the backup will be deleted, an error is returned
+// if no admin would be left.
+// Unchanged objects are not included in the returned diff
+func GetBackupDiff(data *BackupData, filter *BackupFilter, mode int, deleteMissing bool) ([]BackupDiffEntry, error) {
+	backup := *data
+	filter.Apply(&backup)
+	current, err := DumpData()
+	if err != nil {
+		return nil, err
+	}
+	numAdmins := len(current.Admins)
+	filter.Apply(&current)
+
+	var diff []BackupDiffEntry
+	for _, objectType := range BackupObjectTypes {
+		existing := make(map[string]interface{})
+		for _, o := range getBackupObjects(&current, objectType) {
+			existing[o.name] = o.object
+		}
+		included := make(map[string]bool)
+		for _, o := range getBackupObjects(&backup, objectType) {
+			included[o.name] = true
+			stored, ok := existing[o.name]
+			if !ok {
+				diff = append(diff, BackupDiffEntry{Type: objectType, Name: o.name, Action: BackupDiffCreate})
+				continue
+			}
+			equal, err := areBackupObjectsEqual(o.object, stored)
+			if err != nil {
+				return nil, err
+			}
+			if equal {
+				continue
+			}
+			action := BackupDiffUpdate
+			if mode == 1 {
+				action = BackupDiffSkip
+			}
+			diff = append(diff, BackupDiffEntry{Type: objectType, Name: o.name, Action: action})
+		}
+		if !deleteMissing {
+			continue
+		}
+		for _, o := range getBackupObjects(&current, objectType) {
+			if !included[o.name] {
+				diff = append(diff, BackupDiffEntry{Type: objectType, Name: o.name, Action: BackupDiffDelete})
+			}
+		}
+	}
+	if err := checkBackupDiffAdmins(diff, numAdmins); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// checkBackupDiffAdmins returns an error if the diff deletes all the stored admins
+func checkBackupDiffAdmins(diff []BackupDiffEntry, numAdmins int) error {
+	deleted := 0
+	for _, entry := range diff {
+		if entry.Type != BackupObjectAdmins {
+			continue
+		}
+		switch entry.Action {
+		case BackupDiffCreate:
+			numAdmins++
+		case BackupDiffDelete:
+			deleted++
+		}
+	}
+	if deleted > 0 && numAdmins-deleted <= 0 {
+		return util.NewValidationError("the restore would delete all the admins, at least an admin is required")
+	}
+	return nil
+}
+
+// GetBackupDiffObjects returns the objects included in the specified backup
+// that have one of the specified actions in the given diff
+func GetBackupDiffObjects(data *BackupData, diff []BackupDiffEntry, actions ...string) BackupData {
+	selected := make(map[string]map[string]bool)
+	for _, entry := range diff {
+		if !util.IsStringInSlice(entry.Action, actions) {
+			continue
+		}
+		if _, ok := selected[entry.Type]; !ok {
+			selected[entry.Type] = make(map[string]bool)
+		}
+		selected[entry.Type][entry.Name] = true
+	}
+	result := BackupData{
+		Users:   make([]User, 0),
+		Folders: make([]vfs.BaseVirtualFolder, 0),
+		Admins:  make([]Admin, 0),
+		APIKeys: make([]APIKey, 0),
+		Shares:  make([]Share, 0),
+		Version: data.Version,
+	}
+	for _, user := range data.Users {
+		if selected[BackupObjectUsers][user.Username] {
+			result.Users = append(result.Users, user)
+		}
+	}
+	for _, folder := range data.Folders {
+		if selected[BackupObjectFolders][folder.Name] {
+			result.Folders = append(result.Folders, folder)
+		}
+	}
+	for _, admin := range data.Admins {
+		if selected[BackupObjectAdmins][admin.Username] {
+			result.Admins = append(result.Admins, admin)
+		}
+	}
+	for _, apiKey := range data.APIKeys {
+		if selected[BackupObjectAPIKeys][apiKey.KeyID] {
+			result.APIKeys = append(result.APIKeys, apiKey)
+		}
+	}
+	for _, share := range data.Shares {
+		if selected[BackupObjectShares][share.ShareID] {
+			result.Shares = append(result.Shares, share)
+		}
+	}
+	return result
+}
+
+func areBackupObjectsEqual(obj1, obj2 interface{}) (bool, error) {
+	m1, err := getComparableBackupObject(obj1)
+	if err != nil {
+		return false, err
+	}
+	m2, err := getComparableBackupObject(obj2)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(m1, m2), nil
+}
+
+func getComparableBackupObject(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	removeBackupVolatileFields(result)
+	if _, ok := obj.(User); ok {
+		removeBackupFolderDetails(result)
+	}
+	return result, nil
+}
+
+// removeBackupFolderDetails keeps only the user specific fields for the virtual
+// folders, the folder details are compared within the folders
+func removeBackupFolderDetails(user interface{}) {
+	u, ok := user.(map[string]interface{})
+	if !ok {
+		return
+	}
+	folders, ok := u["virtual_folders"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, folder := range folders {
+		if f, ok := folder.(map[string]interface{}); ok {
+			for k := range f {
+				if !util.IsStringInSlice(k, backupUserFolderFields) {
+					delete(f, k)
+				}
+			}
+		}
+	}
+}
+
+func removeBackupVolatileFields(obj interface{}) {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		for _, field := range backupVolatileFields {
+			delete(v, field)
+		}
+		for _, val := range v {
+			removeBackupVolatileFields(val)
+		}
+	case []interface{}:
+		for _, val := range v {
+			removeBackupVolatileFields(val)
+		}
+	}
+}
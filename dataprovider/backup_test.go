@@ -0,0 +1,209 @@
+package dataprovider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+func TestMain(m *testing.M) {
+	cnf := Config{
+		Driver: MemoryDataProviderName,
+		PasswordHashing: PasswordHashing{
+			Algo: HashingAlgoBcrypt,
+			BcryptOptions: BcryptOptions{
+				Cost: 4,
+			},
+		},
+	}
+	if err := Initialize(cnf, os.TempDir(), false); err != nil {
+		os.Exit(1)
+	}
+	exitCode := m.Run()
+	Close() //nolint:errcheck
+	os.Exit(exitCode)
+}
+
+func getTestBackupAdmin(username string) Admin {
+	return Admin{
+		Username:    username,
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{PermAdminAny},
+	}
+}
+
+func getTestBackupUser(username string) User {
+	return User{
+		BaseUser: sdk.BaseUser{
+			Username: username,
+			Password: "password",
+			HomeDir:  filepath.Join(os.TempDir(), username),
+			Status:   1,
+			Permissions: map[string][]string{
+				"/": {PermAny},
+			},
+		},
+	}
+}
+
+func getTestBackupFolder(name string) vfs.BaseVirtualFolder {
+	return vfs.BaseVirtualFolder{
+		Name:       name,
+		MappedPath: filepath.Join(os.TempDir(), name),
+	}
+}
+
+func getBackupDiffAction(diff []BackupDiffEntry, objectType, name string) string {
+	for _, entry := range diff {
+		if entry.Type == objectType && entry.Name == name {
+			return entry.Action
+		}
+	}
+	return ""
+}
+
+func TestBackupFilter(t *testing.T) {
+	filter := BackupFilter{
+		Types: []string{"unknown"},
+	}
+	err := filter.Validate()
+	assert.Error(t, err)
+	filter = BackupFilter{
+		Names: []string{"[a"},
+	}
+	err = filter.Validate()
+	assert.Error(t, err)
+
+	data := BackupData{
+		Users:   []User{getTestBackupUser("user1"), getTestBackupUser("user2")},
+		Folders: []vfs.BaseVirtualFolder{getTestBackupFolder("user1folder")},
+		Admins:  []Admin{getTestBackupAdmin("admin1")},
+	}
+	filter = BackupFilter{
+		Types: []string{BackupObjectUsers, BackupObjectFolders},
+		Names: []string{"user1*"},
+	}
+	err = filter.Validate()
+	assert.NoError(t, err)
+	assert.True(t, filter.HasType(BackupObjectUsers))
+	assert.False(t, filter.HasType(BackupObjectAdmins))
+	filter.Apply(&data)
+	if assert.Len(t, data.Users, 1) {
+		assert.Equal(t, "user1", data.Users[0].Username)
+	}
+	assert.Len(t, data.Folders, 1)
+	assert.Len(t, data.Admins, 0)
+	assert.Len(t, data.APIKeys, 0)
+	assert.Len(t, data.Shares, 0)
+}
+
+func TestBackupDiff(t *testing.T) {
+	admin := getTestBackupAdmin("backupadmin")
+	err := AddAdmin(&admin, ActionExecutorSystem, "")
+	require.NoError(t, err)
+	user1 := getTestBackupUser("backupuser1")
+	err = AddUser(&user1, ActionExecutorSystem, "")
+	require.NoError(t, err)
+	user2 := getTestBackupUser("backupuser2")
+	err = AddUser(&user2, ActionExecutorSystem, "")
+	require.NoError(t, err)
+	folder := getTestBackupFolder("backupfolder")
+	err = AddFolder(&folder)
+	require.NoError(t, err)
+
+	dump, err := DumpData()
+	require.NoError(t, err)
+	// a restore of the current data changes nothing
+	diff, err := GetBackupDiff(&dump, &BackupFilter{}, 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 0)
+
+	user1.MaxSessions = 10
+	dump.Users = []User{user1, getTestBackupUser("backupuser3")}
+	dump.Folders = nil
+	diff, err = GetBackupDiff(&dump, &BackupFilter{}, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 2)
+	assert.Equal(t, BackupDiffUpdate, getBackupDiffAction(diff, BackupObjectUsers, user1.Username))
+	assert.Equal(t, BackupDiffCreate, getBackupDiffAction(diff, BackupObjectUsers, "backupuser3"))
+	// existing objects are not updated in mode 1
+	diff, err = GetBackupDiff(&dump, &BackupFilter{}, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, BackupDiffSkip, getBackupDiffAction(diff, BackupObjectUsers, user1.Username))
+	// the stored objects excluded by the filter are not deleted
+	filter := BackupFilter{
+		Types: []string{BackupObjectUsers},
+	}
+	diff, err = GetBackupDiff(&dump, &filter, 2, true)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 3)
+	assert.Equal(t, BackupDiffUpdate, getBackupDiffAction(diff, BackupObjectUsers, user1.Username))
+	assert.Equal(t, BackupDiffDelete, getBackupDiffAction(diff, BackupObjectUsers, user2.Username))
+	assert.Equal(t, "", getBackupDiffAction(diff, BackupObjectFolders, folder.Name))
+
+	changes := GetBackupDiffObjects(&dump, diff, BackupDiffCreate, BackupDiffUpdate)
+	if assert.Len(t, changes.Users, 2) {
+		assert.Equal(t, user1.Username, changes.Users[0].Username)
+		assert.Equal(t, 10, changes.Users[0].MaxSessions)
+	}
+	assert.Len(t, changes.Folders, 0)
+	assert.Len(t, changes.Admins, 0)
+
+	err = DeleteUser(user1.Username, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+	err = DeleteUser(user2.Username, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+	err = DeleteFolder(folder.Name, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+	err = DeleteAdmin(admin.Username, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+}
+
+func TestBackupDiffLastAdmin(t *testing.T) {
+	admin1 := getTestBackupAdmin("backupadmin1")
+	err := AddAdmin(&admin1, ActionExecutorSystem, "")
+	require.NoError(t, err)
+	admin2 := getTestBackupAdmin("backupadmin2")
+	err = AddAdmin(&admin2, ActionExecutorSystem, "")
+	require.NoError(t, err)
+
+	filter := BackupFilter{
+		Types: []string{BackupObjectAdmins},
+	}
+	dump := BackupData{}
+	_, err = GetBackupDiff(&dump, &filter, 0, true)
+	if assert.Error(t, err) {
+		_, ok := err.(*util.ValidationError)
+		assert.True(t, ok)
+	}
+	// without the delete flag nothing is deleted
+	diff, err := GetBackupDiff(&dump, &filter, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 0)
+	// an admin is created
+	dump.Admins = []Admin{getTestBackupAdmin("backupadmin3")}
+	diff, err = GetBackupDiff(&dump, &filter, 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 3)
+	assert.Equal(t, BackupDiffCreate, getBackupDiffAction(diff, BackupObjectAdmins, "backupadmin3"))
+	// an admin is not matched by the filter
+	dump.Admins = nil
+	filter.Names = []string{admin1.Username}
+	diff, err = GetBackupDiff(&dump, &filter, 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 1)
+	assert.Equal(t, BackupDiffDelete, getBackupDiffAction(diff, BackupObjectAdmins, admin1.Username))
+
+	err = DeleteAdmin(admin1.Username, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+	err = DeleteAdmin(admin2.Username, ActionExecutorSystem, "")
+	assert.NoError(t, err)
+}
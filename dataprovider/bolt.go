@@ -4,11 +4,14 @@
 package dataprovider
 
 import (
+	"bytes"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -20,19 +23,22 @@ import (
 )
 
 const (
-	boltDatabaseVersion = 15
+	boltDatabaseVersion = 19
 )
 
 var (
-	usersBucket     = []byte("users")
-	foldersBucket   = []byte("folders")
-	adminsBucket    = []byte("admins")
-	apiKeysBucket   = []byte("api_keys")
-	sharesBucket    = []byte("shares")
-	dbVersionBucket = []byte("db_version")
-	dbVersionKey    = []byte("version")
-	boltBuckets     = [][]byte{usersBucket, foldersBucket, adminsBucket, apiKeysBucket,
-		sharesBucket, dbVersionBucket}
+	usersBucket       = []byte("users")
+	foldersBucket     = []byte("folders")
+	adminsBucket      = []byte("admins")
+	apiKeysBucket     = []byte("api_keys")
+	sharesBucket      = []byte("shares")
+	dedupBucket       = []byte("dedup_contents")
+	webDAVPropsBucket = []byte("webdav_properties")
+	webDAVLocksBucket = []byte("webdav_locks")
+	dbVersionBucket   = []byte("db_version")
+	dbVersionKey      = []byte("version")
+	boltBuckets       = [][]byte{usersBucket, foldersBucket, adminsBucket, apiKeysBucket,
+		sharesBucket, dedupBucket, webDAVPropsBucket, webDAVLocksBucket, dbVersionBucket}
 )
 
 // BoltProvider auth provider for bolt key/value store
@@ -45,14 +51,9 @@ func init() {
 }
 
 func initializeBoltProvider(basePath string) error {
-	var err error
-
-	dbPath := config.Name
-	if !util.IsFileInputValid(dbPath) {
-		return fmt.Errorf("invalid database path: %#v", dbPath)
-	}
-	if !filepath.IsAbs(dbPath) {
-		dbPath = filepath.Join(basePath, dbPath)
+	dbPath, err := getBoltDatabasePath(basePath)
+	if err != nil {
+		return err
 	}
 	dbHandle, err := bolt.Open(dbPath, 0600, &bolt.Options{
 		NoGrowSync:   false,
@@ -77,6 +78,43 @@ func initializeBoltProvider(basePath string) error {
 	return err
 }
 
+func getBoltDatabasePath(basePath string) (string, error) {
+	dbPath := config.Name
+	if !util.IsFileInputValid(dbPath) {
+		return "", fmt.Errorf("invalid database path: %#v", dbPath)
+	}
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(basePath, dbPath)
+	}
+	return dbPath, nil
+}
+
+// checkBoltSchemaVersion opens the database in read-only mode, the buckets
+// are not created
+func checkBoltSchemaVersion(basePath string) error {
+	dbPath, err := getBoltDatabasePath(basePath)
+	if err != nil {
+		return err
+	}
+	// bolt creates the database file if it does not exist, even in read-only mode
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("unable to open the database, did you run the \"initprovider\" command? %w", err)
+	}
+	dbHandle, err := bolt.Open(dbPath, 0600, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer dbHandle.Close()
+
+	dbVersion, err := getBoltDatabaseVersion(dbHandle)
+	if err != nil {
+		return fmt.Errorf("unable to get the database version, did you run the \"initprovider\" command? %w", err)
+	}
+	return checkSchemaVersion(dbVersion, boltDatabaseVersion)
+}
+
 func (p *BoltProvider) checkAvailability() error {
 	_, err := getBoltDatabaseVersion(p.dbHandle)
 	return err
@@ -118,7 +156,7 @@ func (p *BoltProvider) validateAdminAndPass(username, password, ip string) (Admi
 	return admin, err
 }
 
-func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
 	var user User
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
@@ -128,7 +166,7 @@ func (p *BoltProvider) validateUserAndPubKey(username string, pubKey []byte) (Us
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, "", err
 	}
-	return checkUserAndPubKey(&user, pubKey)
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
 }
 
 func (p *BoltProvider) updateAPIKeyLastUse(keyID string) error {
@@ -1397,6 +1435,346 @@ func (p *BoltProvider) cleanupDefender(from int64) error {
 	return ErrNotImplemented
 }
 
+func (p *BoltProvider) updateDedupContent(hash string, size int64, references int) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDedupBucket(tx)
+		if err != nil {
+			return err
+		}
+		now := util.GetTimeAsMsSinceEpoch(time.Now())
+		content := DedupContent{
+			Hash:      hash,
+			CreatedAt: now,
+		}
+		if c := bucket.Get([]byte(hash)); c != nil {
+			if err := json.Unmarshal(c, &content); err != nil {
+				return err
+			}
+		}
+		content.Size = size
+		content.References = references
+		content.UpdatedAt = now
+		buf, err := json.Marshal(content)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(hash), buf)
+	})
+}
+
+func (p *BoltProvider) deleteDedupContent(hash string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDedupBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(hash))
+	})
+}
+
+func (p *BoltProvider) cleanupDedupContents(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDedupBucket(tx)
+		if err != nil {
+			return err
+		}
+		var toRemove [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var content DedupContent
+			if err := json.Unmarshal(v, &content); err != nil {
+				return err
+			}
+			if content.UpdatedAt < before {
+				toRemove = append(toRemove, k)
+			}
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) getDedupStats() (DedupStats, error) {
+	var stats DedupStats
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDedupBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var content DedupContent
+			if err := json.Unmarshal(v, &content); err != nil {
+				return err
+			}
+			stats.Contents++
+			stats.References += int64(content.References)
+			stats.PhysicalSize += content.Size
+			stats.LogicalSize += content.Size * int64(content.References)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func (p *BoltProvider) getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	var props WebDAVProperties
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVPropertiesBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(getWebDAVPropertiesKey(storage, fsPath)))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("no WebDAV properties for path %#v", fsPath))
+		}
+		return json.Unmarshal(v, &props)
+	})
+	return props, err
+}
+
+func (p *BoltProvider) setWebDAVProperties(props *WebDAVProperties) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVPropertiesBucket(tx)
+		if err != nil {
+			return err
+		}
+		props.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(props)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(props.getKey()), buf)
+	})
+}
+
+func (p *BoltProvider) renameWebDAVProperties(storage, source, target string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVPropertiesBucket(tx)
+		if err != nil {
+			return err
+		}
+		toMove, err := deleteBoltWebDAVProperties(bucket, storage, source)
+		if err != nil {
+			return err
+		}
+		if _, err := deleteBoltWebDAVProperties(bucket, storage, target); err != nil {
+			return err
+		}
+		for _, v := range toMove {
+			var props WebDAVProperties
+			if err := json.Unmarshal(v, &props); err != nil {
+				return err
+			}
+			props.Path = target + strings.TrimPrefix(props.Path, source)
+			buf, err := json.Marshal(props)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(props.getKey()), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) deleteWebDAVProperties(storage, fsPath string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVPropertiesBucket(tx)
+		if err != nil {
+			return err
+		}
+		_, err = deleteBoltWebDAVProperties(bucket, storage, fsPath)
+		return err
+	})
+}
+
+// deleteBoltWebDAVProperties removes the properties for the specified path and
+// for any path inside it and returns the removed values.
+// Keys are sorted, so the paths inside fsPath are after the fsPath key
+func deleteBoltWebDAVProperties(bucket *bolt.Bucket, storage, fsPath string) ([][]byte, error) {
+	var keys [][]byte
+	var values [][]byte
+	prefix := []byte(getWebDAVPropertiesKey(storage, fsPath))
+	cursor := bucket.Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		var props WebDAVProperties
+		if err := json.Unmarshal(v, &props); err != nil {
+			return nil, err
+		}
+		if props.Storage == storage && isWebDAVPropertiesPathMatch(props.Path, fsPath) {
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), v...))
+		}
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (p *BoltProvider) addWebDAVLock(lock *WebDAVLock) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		if v := bucket.Get([]byte(lock.Token)); v != nil {
+			return fmt.Errorf("WebDAV lock %#v already exists", lock.Token)
+		}
+		now := time.Now()
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var l WebDAVLock
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			if l.getKey() == lock.getKey() {
+				if !l.IsExpired(now) {
+					return fmt.Errorf("path %#v is already locked", lock.Path)
+				}
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		buf, err := json.Marshal(lock)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(lock.Token), buf)
+	})
+}
+
+func (p *BoltProvider) getWebDAVLock(token string) (WebDAVLock, error) {
+	var lock WebDAVLock
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(token))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+		}
+		return json.Unmarshal(v, &lock)
+	})
+	return lock, err
+}
+
+func (p *BoltProvider) getWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	var locks []WebDAVLock
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var lock WebDAVLock
+			if err := json.Unmarshal(v, &lock); err != nil {
+				return err
+			}
+			if lock.Storage == storage {
+				locks = append(locks, lock)
+			}
+			return nil
+		})
+	})
+	return locks, err
+}
+
+func (p *BoltProvider) updateWebDAVLockExpiration(token string, expiresAt int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(token))
+		if v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+		}
+		var lock WebDAVLock
+		if err := json.Unmarshal(v, &lock); err != nil {
+			return err
+		}
+		lock.ExpiresAt = expiresAt
+		buf, err := json.Marshal(lock)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(token), buf)
+	})
+}
+
+func (p *BoltProvider) deleteWebDAVLock(token string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		if v := bucket.Get([]byte(token)); v == nil {
+			return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+		}
+		return bucket.Delete([]byte(token))
+	})
+}
+
+func (p *BoltProvider) deleteWebDAVLocks(storage, fsPath string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		return deleteBoltWebDAVLocks(bucket, func(lock *WebDAVLock) bool {
+			return lock.Storage == storage && isWebDAVPropertiesPathMatch(lock.Path, fsPath)
+		})
+	})
+}
+
+func (p *BoltProvider) cleanupWebDAVLocks(before int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getWebDAVLocksBucket(tx)
+		if err != nil {
+			return err
+		}
+		return deleteBoltWebDAVLocks(bucket, func(lock *WebDAVLock) bool {
+			return lock.ExpiresAt > 0 && lock.ExpiresAt < before
+		})
+	})
+}
+
+// deleteBoltWebDAVLocks removes the locks matching the specified function
+func deleteBoltWebDAVLocks(bucket *bolt.Bucket, match func(lock *WebDAVLock) bool) error {
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		var lock WebDAVLock
+		if err := json.Unmarshal(v, &lock); err != nil {
+			return err
+		}
+		if match(&lock) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *BoltProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -1425,15 +1803,23 @@ func (p *BoltProvider) migrateDatabase() error {
 		logger.ErrorToConsole("%v", err)
 		return err
 	case version == 10:
-		return updateBoltDatabaseVersion(p.dbHandle, 15)
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
 	case version == 11:
-		return updateBoltDatabaseVersion(p.dbHandle, 15)
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
 	case version == 12:
-		return updateBoltDatabaseVersion(p.dbHandle, 15)
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
 	case version == 13:
-		return updateBoltDatabaseVersion(p.dbHandle, 15)
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
 	case version == 14:
-		return updateBoltDatabaseVersion(p.dbHandle, 15)
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
+	case version == 15:
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
+	case version == 16:
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
+	case version == 17:
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
+	case version == 18:
+		return updateBoltDatabaseVersion(p.dbHandle, 19)
 	default:
 		if version > boltDatabaseVersion {
 			providerLog(logger.LevelError, "database version %v is newer than the supported one: %v", version,
@@ -1455,7 +1841,7 @@ func (p *BoltProvider) revertDatabase(targetVersion int) error {
 		return errors.New("current version match target version, nothing to do")
 	}
 	switch dbVersion.Version {
-	case 15, 14, 13, 12, 11:
+	case 19, 18, 17, 16, 15, 14, 13, 12, 11:
 		return updateBoltDatabaseVersion(p.dbHandle, 10)
 	default:
 		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
@@ -1673,6 +2059,36 @@ func getSharesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	return bucket, err
 }
 
+func getDedupBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(dedupBucket)
+	if bucket == nil {
+		err = errors.New("unable to find dedup contents bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getWebDAVPropertiesBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(webDAVPropsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find WebDAV properties bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getWebDAVLocksBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+
+	bucket := tx.Bucket(webDAVLocksBucket)
+	if bucket == nil {
+		err = errors.New("unable to find WebDAV locks bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func getAPIKeysBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 
@@ -16,3 +16,7 @@ func init() {
 func initializeBoltProvider(basePath string) error {
 	return errors.New("bolt disabled at build time")
 }
+
+func checkBoltSchemaVersion(basePath string) error {
+	return errors.New("bolt disabled at build time")
+}
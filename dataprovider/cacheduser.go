@@ -4,8 +4,6 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/net/webdav"
-
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/util"
 )
@@ -33,7 +31,6 @@ type CachedUser struct {
 	User       User
 	Expiration time.Time
 	Password   string
-	LockSystem webdav.LockSystem
 }
 
 // IsExpired returns true if the cached user is expired
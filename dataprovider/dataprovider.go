@@ -52,6 +52,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/mfa"
 	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
@@ -117,7 +118,7 @@ var (
 	// ValidPerms defines all the valid permissions for a user
 	ValidPerms = []string{PermAny, PermListItems, PermDownload, PermUpload, PermOverwrite, PermCreateDirs, PermRename,
 		PermRenameFiles, PermRenameDirs, PermDelete, PermDeleteFiles, PermDeleteDirs, PermCreateSymlinks, PermChmod,
-		PermChown, PermChtimes}
+		PermChown, PermChtimes, PermGitRead, PermGitPush}
 	// ValidLoginMethods defines all the valid login methods
 	ValidLoginMethods = []string{SSHLoginMethodPublicKey, LoginMethodPassword, SSHLoginMethodKeyboardInteractive,
 		SSHLoginMethodKeyAndPassword, SSHLoginMethodKeyAndKeyboardInt, LoginMethodTLSCertificate,
@@ -139,37 +140,42 @@ var (
 	// ErrLoginNotAllowedFromIP defines the error to return if login is denied from the current IP
 	ErrLoginNotAllowedFromIP = errors.New("login is not allowed from this IP")
 	isAdminCreated           = int32(0)
-	validTLSUsernames        = []string{string(sdk.TLSUsernameNone), string(sdk.TLSUsernameCN)}
-	config                   Config
-	provider                 Provider
-	sqlPlaceholders          []string
-	internalHashPwdPrefixes  = []string{argonPwdPrefix, bcryptPwdPrefix}
-	hashPwdPrefixes          = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
+	validTLSUsernames        = []string{string(sdk.TLSUsernameNone), string(sdk.TLSUsernameCN),
+		string(TLSUsernameSANEmail), string(TLSUsernameSANURI), string(TLSUsernameSerialNumber),
+		string(TLSUsernameFingerprint)}
+	config                  Config
+	provider                Provider
+	sqlPlaceholders         []string
+	internalHashPwdPrefixes = []string{argonPwdPrefix, bcryptPwdPrefix}
+	hashPwdPrefixes         = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
 		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
-	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
-	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
-	unixPwdPrefixes         = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
-	sharedProviders         = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
-	logSender               = "dataProvider"
-	availabilityTicker      *time.Ticker
-	availabilityTickerDone  chan bool
-	updateCachesTicker      *time.Ticker
-	updateCachesTickerDone  chan bool
-	lastCachesUpdate        int64
-	credentialsDirPath      string
-	sqlTableUsers           = "users"
-	sqlTableFolders         = "folders"
-	sqlTableFoldersMapping  = "folders_mapping"
-	sqlTableAdmins          = "admins"
-	sqlTableAPIKeys         = "api_keys"
-	sqlTableShares          = "shares"
-	sqlTableDefenderHosts   = "defender_hosts"
-	sqlTableDefenderEvents  = "defender_events"
-	sqlTableSchemaVersion   = "schema_version"
-	argon2Params            *argon2id.Params
-	lastLoginMinDelay       = 10 * time.Minute
-	usernameRegex           = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
-	tempPath                string
+	pbkdfPwdPrefixes         = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
+	pbkdfPwdB64SaltPrefixes  = []string{pbkdf2SHA256B64SaltPrefix}
+	unixPwdPrefixes          = []string{md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
+	sharedProviders          = []string{PGSQLDataProviderName, MySQLDataProviderName, CockroachDataProviderName}
+	logSender                = "dataProvider"
+	availabilityTicker       *time.Ticker
+	availabilityTickerDone   chan bool
+	updateCachesTicker       *time.Ticker
+	updateCachesTickerDone   chan bool
+	lastCachesUpdate         int64
+	credentialsDirPath       string
+	sqlTableUsers            = "users"
+	sqlTableFolders          = "folders"
+	sqlTableFoldersMapping   = "folders_mapping"
+	sqlTableAdmins           = "admins"
+	sqlTableAPIKeys          = "api_keys"
+	sqlTableShares           = "shares"
+	sqlTableDefenderHosts    = "defender_hosts"
+	sqlTableDefenderEvents   = "defender_events"
+	sqlTableDedupContents    = "dedup_contents"
+	sqlTableWebDAVProperties = "webdav_properties"
+	sqlTableWebDAVLocks      = "webdav_locks"
+	sqlTableSchemaVersion    = "schema_version"
+	argon2Params             *argon2id.Params
+	lastLoginMinDelay        = 10 * time.Minute
+	usernameRegex            = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
+	tempPath                 string
 )
 
 type schemaVersion struct {
@@ -465,7 +471,7 @@ func HasUsersBaseDir() bool {
 // Provider defines the interface that data providers must implement.
 type Provider interface {
 	validateUserAndPass(username, password, ip, protocol string) (User, error)
-	validateUserAndPubKey(username string, pubKey []byte) (User, string, error)
+	validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error)
 	validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error)
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
 	getUsedQuota(username string) (int, int64, error)
@@ -516,6 +522,21 @@ type Provider interface {
 	addDefenderEvent(ip string, score int) error
 	setDefenderBanTime(ip string, banTime int64) error
 	cleanupDefender(from int64) error
+	updateDedupContent(hash string, size int64, references int) error
+	deleteDedupContent(hash string) error
+	cleanupDedupContents(before int64) error
+	getDedupStats() (DedupStats, error)
+	getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error)
+	setWebDAVProperties(props *WebDAVProperties) error
+	renameWebDAVProperties(storage, source, target string) error
+	deleteWebDAVProperties(storage, fsPath string) error
+	addWebDAVLock(lock *WebDAVLock) error
+	getWebDAVLock(token string) (WebDAVLock, error)
+	getWebDAVLocks(storage string) ([]WebDAVLock, error)
+	updateWebDAVLockExpiration(token string, expiresAt int64) error
+	deleteWebDAVLock(token string) error
+	deleteWebDAVLocks(storage, fsPath string) error
+	cleanupWebDAVLocks(before int64) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -654,11 +675,16 @@ func validateSQLTablesPrefix() error {
 		sqlTableShares = config.SQLTablesPrefix + sqlTableShares
 		sqlTableDefenderEvents = config.SQLTablesPrefix + sqlTableDefenderEvents
 		sqlTableDefenderHosts = config.SQLTablesPrefix + sqlTableDefenderHosts
+		sqlTableDedupContents = config.SQLTablesPrefix + sqlTableDedupContents
+		sqlTableWebDAVProperties = config.SQLTablesPrefix + sqlTableWebDAVProperties
+		sqlTableWebDAVLocks = config.SQLTablesPrefix + sqlTableWebDAVLocks
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
 		providerLog(logger.LevelDebug, "sql table for users %#v, folders %#v folders mapping %#v admins %#v "+
-			"api keys %#v shares %#v defender hosts %#v defender events %#v schema version %#v",
+			"api keys %#v shares %#v defender hosts %#v defender events %#v dedup contents %#v webdav properties %#v webdav locks %#v "+
+			"schema version %#v",
 			sqlTableUsers, sqlTableFolders, sqlTableFoldersMapping, sqlTableAdmins, sqlTableAPIKeys,
-			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableSchemaVersion)
+			sqlTableShares, sqlTableDefenderHosts, sqlTableDefenderEvents, sqlTableDedupContents, sqlTableWebDAVProperties,
+			sqlTableWebDAVLocks, sqlTableSchemaVersion)
 	}
 	return nil
 }
@@ -701,6 +727,44 @@ func InitializeDatabase(cnf Config, basePath string) error {
 	return provider.migrateDatabase()
 }
 
+// CheckSchemaVersion opens the configured data provider in read-only mode and
+// checks that the database schema version is the one required by this SFTPGo
+// version. The database is never initialized or migrated
+func CheckSchemaVersion(cnf Config, basePath string) error {
+	config = cnf
+	sqlPlaceholders = getSQLPlaceholders()
+	if err := validateSQLTablesPrefix(); err != nil {
+		return err
+	}
+
+	switch config.Driver {
+	case SQLiteDataProviderName:
+		return checkSQLiteSchemaVersion(basePath)
+	case PGSQLDataProviderName, CockroachDataProviderName:
+		return checkPGSQLSchemaVersion()
+	case MySQLDataProviderName:
+		return checkMySQLSchemaVersion()
+	case BoltDataProviderName:
+		return checkBoltSchemaVersion(basePath)
+	case MemoryDataProviderName:
+		return nil
+	default:
+		return fmt.Errorf("unsupported data provider: %v", config.Driver)
+	}
+}
+
+func checkSchemaVersion(dbVersion schemaVersion, requiredVersion int) error {
+	switch {
+	case dbVersion.Version < requiredVersion:
+		return fmt.Errorf("database version %v is older than the required one: %v, please run the \"initprovider\" command",
+			dbVersion.Version, requiredVersion)
+	case dbVersion.Version > requiredVersion:
+		return fmt.Errorf("database version %v is newer than the supported one: %v", dbVersion.Version, requiredVersion)
+	default:
+		return nil
+	}
+}
+
 // RevertDatabase restores schema and/or data to a previous version
 func RevertDatabase(cnf Config, basePath string, targetVersion int) error {
 	config = cnf
@@ -778,18 +842,27 @@ func CheckCachedUserCredentials(user *CachedUser, password, loginMethod, protoco
 // CheckCompositeCredentials checks multiple credentials.
 // WebDAV users can send both a password and a TLS certificate within the same request
 func CheckCompositeCredentials(username, password, ip, loginMethod, protocol string, tlsCert *x509.Certificate) (User, string, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, loginMethod)
+	user, method, err := checkCompositeCredentials(ctx, username, password, ip, loginMethod, protocol, tlsCert)
+	span.End(err)
+	return user, method, err
+}
+
+func checkCompositeCredentials(ctx context.Context, username, password, ip, loginMethod, protocol string,
+	tlsCert *x509.Certificate,
+) (User, string, error) {
 	if loginMethod == LoginMethodPassword {
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := checkUserAndPassWithHooks(ctx, username, password, ip, protocol)
 		return user, loginMethod, err
 	}
-	user, err := CheckUserBeforeTLSAuth(username, ip, protocol, tlsCert)
+	user, err := checkUserBeforeTLSAuth(ctx, username, ip, protocol, tlsCert)
 	if err != nil {
 		return user, loginMethod, err
 	}
 	if !user.IsTLSUsernameVerificationEnabled() {
 		// for backward compatibility with 2.0.x we only check the password and change the login method here
 		// in future updates we have to return an error
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := checkUserAndPassWithHooks(ctx, username, password, ip, protocol)
 		return user, LoginMethodPassword, err
 	}
 	user, err = checkUserAndTLSCertificate(&user, protocol, tlsCert)
@@ -801,11 +874,11 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 	}
 	if loginMethod == LoginMethodTLSCertificateAndPwd {
 		if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
-			user, err = doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
+			user, err = doPluginAuth(ctx, username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
-			user, err = doExternalAuth(username, password, nil, "", ip, protocol, nil)
+			user, err = doExternalAuth(ctx, username, password, nil, "", ip, protocol, nil)
 		} else if config.PreLoginHook != "" {
-			user, err = executePreLoginHook(username, LoginMethodPassword, ip, protocol)
+			user, err = executePreLoginHook(ctx, username, LoginMethodPassword, ip, protocol)
 		}
 		if err != nil {
 			return user, loginMethod, err
@@ -817,115 +890,164 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 
 // CheckUserBeforeTLSAuth checks if a user exits before trying mutual TLS
 func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, LoginMethodTLSCertificate)
+	user, err := checkUserBeforeTLSAuth(ctx, username, ip, protocol, tlsCert)
+	span.End(err)
+	return user, err
+}
+
+func checkUserBeforeTLSAuth(ctx context.Context, username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeTLSCertificate) {
-		return doPluginAuth(username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
+		return doPluginAuth(ctx, username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
-		return doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
+		return doExternalAuth(ctx, username, "", nil, "", ip, protocol, tlsCert)
 	}
 	if config.PreLoginHook != "" {
-		return executePreLoginHook(username, LoginMethodTLSCertificate, ip, protocol)
+		return executePreLoginHook(ctx, username, LoginMethodTLSCertificate, ip, protocol)
 	}
-	return UserExists(username)
+	return userExistsWithContext(ctx, username)
 }
 
 // CheckUserAndTLSCert returns the SFTPGo user with the given username and check if the
 // given TLS certificate allow authentication without password
 func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, LoginMethodTLSCertificate)
+	user, err := checkUserAndTLSCertWithHooks(ctx, username, ip, protocol, tlsCert)
+	span.End(err)
+	return user, err
+}
+
+func checkUserAndTLSCertWithHooks(ctx context.Context, username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeTLSCertificate) {
-		user, err := doPluginAuth(username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
+		user, err := doPluginAuth(ctx, username, "", nil, ip, protocol, tlsCert, plugin.AuthScopeTLSCertificate)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
-		user, err := doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
+		user, err := doExternalAuth(ctx, username, "", nil, "", ip, protocol, tlsCert)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, LoginMethodTLSCertificate, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, LoginMethodTLSCertificate, ip, protocol)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
-	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	span := startProviderSpan(ctx, "validate_user_and_tls_cert", username)
+	user, err := provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	endProviderSpan(span, err)
+	return user, err
 }
 
 // CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
 func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, LoginMethodPassword)
+	user, err := checkUserAndPassWithHooks(ctx, username, password, ip, protocol)
+	span.End(err)
+	return user, err
+}
+
+func checkUserAndPassWithHooks(ctx context.Context, username, password, ip, protocol string) (User, error) {
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePassword) {
-		user, err := doPluginAuth(username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
+		user, err := doPluginAuth(ctx, username, password, nil, ip, protocol, nil, plugin.AuthScopePassword)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
-		user, err := doExternalAuth(username, password, nil, "", ip, protocol, nil)
+		user, err := doExternalAuth(ctx, username, password, nil, "", ip, protocol, nil)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, LoginMethodPassword, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, LoginMethodPassword, ip, protocol)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndPass(&user, password, ip, protocol)
 	}
-	return provider.validateUserAndPass(username, password, ip, protocol)
+	span := startProviderSpan(ctx, "validate_user_and_pass", username)
+	user, err := provider.validateUserAndPass(username, password, ip, protocol)
+	endProviderSpan(span, err)
+	return user, err
+}
+
+// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error.
+// isSSHCert must be true only if pubKey is an SSH certificate already validated against the trusted CAs
+func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string, isSSHCert bool) (User, string, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, SSHLoginMethodPublicKey)
+	user, keyID, err := checkUserAndPubKeyWithHooks(ctx, username, pubKey, ip, protocol, isSSHCert)
+	span.End(err)
+	return user, keyID, err
 }
 
-// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
-func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string) (User, string, error) {
+func checkUserAndPubKeyWithHooks(ctx context.Context, username string, pubKey []byte, ip, protocol string,
+	isSSHCert bool,
+) (User, string, error) {
 	if plugin.Handler.HasAuthScope(plugin.AuthScopePublicKey) {
-		user, err := doPluginAuth(username, "", pubKey, ip, protocol, nil, plugin.AuthScopePublicKey)
+		user, err := doPluginAuth(ctx, username, "", pubKey, ip, protocol, nil, plugin.AuthScopePublicKey)
 		if err != nil {
 			return user, "", err
 		}
-		return checkUserAndPubKey(&user, pubKey)
+		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
 	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
-		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol, nil)
+		user, err := doExternalAuth(ctx, username, "", pubKey, "", ip, protocol, nil)
 		if err != nil {
 			return user, "", err
 		}
-		return checkUserAndPubKey(&user, pubKey)
+		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, SSHLoginMethodPublicKey, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, SSHLoginMethodPublicKey, ip, protocol)
 		if err != nil {
 			return user, "", err
 		}
-		return checkUserAndPubKey(&user, pubKey)
+		return checkUserAndPubKey(&user, pubKey, isSSHCert)
 	}
-	return provider.validateUserAndPubKey(username, pubKey)
+	span := startProviderSpan(ctx, "validate_user_and_pubkey", username)
+	user, keyID, err := provider.validateUserAndPubKey(username, pubKey, isSSHCert)
+	endProviderSpan(span, err)
+	return user, keyID, err
 }
 
 // CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
 // the authenticated user or an error
 func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+	ctx, span := startLoginSpan(username, ip, protocol, SSHLoginMethodKeyboardInteractive)
+	user, err := checkKeyboardInteractiveAuth(ctx, username, authHook, client, ip, protocol)
+	span.End(err)
+	return user, err
+}
+
+func checkKeyboardInteractiveAuth(ctx context.Context, username, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (User, error) {
 	var user User
 	var err error
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
-		user, err = doPluginAuth(username, "", nil, ip, protocol, nil, plugin.AuthScopeKeyboardInteractive)
+		user, err = doPluginAuth(ctx, username, "", nil, ip, protocol, nil, plugin.AuthScopeKeyboardInteractive)
 	} else if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
-		user, err = doExternalAuth(username, "", nil, "1", ip, protocol, nil)
+		user, err = doExternalAuth(ctx, username, "", nil, "1", ip, protocol, nil)
 	} else if config.PreLoginHook != "" {
-		user, err = executePreLoginHook(username, SSHLoginMethodKeyboardInteractive, ip, protocol)
+		user, err = executePreLoginHook(ctx, username, SSHLoginMethodKeyboardInteractive, ip, protocol)
 	} else {
-		user, err = provider.userExists(username)
+		user, err = userExistsWithContext(ctx, username)
 	}
 	if err != nil {
 		return user, err
 	}
-	return doKeyboardInteractiveAuth(&user, authHook, client, ip, protocol)
+	return doKeyboardInteractiveAuth(ctx, &user, authHook, client, ip, protocol)
 }
 
 // GetDefenderHosts returns hosts that are banned or for which some violations have been detected
@@ -1779,6 +1901,42 @@ func validateBandwidthLimitFilters(user *User) error {
 	return nil
 }
 
+func validateTLSCertFingerprints(user *User) error {
+	var fingerprints []string
+	for _, fp := range user.Filters.TLSCertFingerprints {
+		fp = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+		if fp == "" {
+			continue
+		}
+		decoded, err := hex.DecodeString(fp)
+		if err != nil || len(decoded) != sha256.Size {
+			return util.NewValidationError(fmt.Sprintf("invalid TLS certificate fingerprint %#v, a SHA-256 fingerprint is required", fp))
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	user.Filters.TLSCertFingerprints = util.RemoveDuplicates(fingerprints)
+	if user.Filters.TLSUsername == TLSUsernameFingerprint && len(user.Filters.TLSCertFingerprints) == 0 {
+		return util.NewValidationError("at least a TLS certificate fingerprint is required for TLS username \"Fingerprint\"")
+	}
+	return nil
+}
+
+func validateSSHCertPrincipals(user *User) error {
+	var patterns []string
+	for _, pattern := range user.Filters.SSHCertPrincipals {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return util.NewValidationError(fmt.Sprintf("invalid SSH certificate principal pattern %#v: %v", pattern, err))
+		}
+		patterns = append(patterns, pattern)
+	}
+	user.Filters.SSHCertPrincipals = util.RemoveDuplicates(patterns)
+	return nil
+}
+
 func validateFilters(user *User) error {
 	checkEmptyFiltersStruct(user)
 	if err := validateIPFilters(user); err != nil {
@@ -1810,6 +1968,16 @@ func validateFilters(user *User) error {
 			return util.NewValidationError(fmt.Sprintf("invalid TLS username: %#v", user.Filters.TLSUsername))
 		}
 	}
+	if err := validateTLSCertFingerprints(user); err != nil {
+		return err
+	}
+	if err := validateSSHCertPrincipals(user); err != nil {
+		return err
+	}
+	user.Filters.MetricsLabel = strings.TrimSpace(user.Filters.MetricsLabel)
+	if len(user.Filters.MetricsLabel) > 255 {
+		return util.NewValidationError("metrics label is too long, the maximum allowed length is 255")
+	}
 	user.Filters.WebClient = util.RemoveDuplicates(user.Filters.WebClient)
 	for _, opts := range user.Filters.WebClient {
 		if !util.IsStringInSlice(opts, sdk.WebClientOptions) {
@@ -1933,6 +2101,14 @@ func ValidateFolder(folder *vfs.BaseVirtualFolder) error {
 		}
 		folder.MappedPath = cleanedMPath
 	}
+	if folder.LowerFolder != "" {
+		if folder.LowerFolder == folder.Name {
+			return util.NewValidationError("a folder cannot be its own lower folder")
+		}
+		if !config.SkipNaturalKeysValidation && !usernameRegex.MatchString(folder.LowerFolder) {
+			return util.NewValidationError(fmt.Sprintf("lower folder name %#v is not valid", folder.LowerFolder))
+		}
+	}
 	if folder.HasRedactedSecret() {
 		return errors.New("cannot save a folder with a redacted secret")
 	}
@@ -2030,14 +2206,11 @@ func checkUserAndTLSCertificate(user *User, protocol string, tlsCert *x509.Certi
 		return *user, err
 	}
 	switch protocol {
-	case protocolFTP, protocolWebDAV:
-		if user.Filters.TLSUsername == sdk.TLSUsernameCN {
-			if user.Username == tlsCert.Subject.CommonName {
-				return *user, nil
-			}
-			return *user, fmt.Errorf("CN %#v does not match username %#v", tlsCert.Subject.CommonName, user.Username)
+	case protocolFTP, protocolWebDAV, protocolHTTP:
+		if tlsCert == nil {
+			return *user, errors.New("TLS certificate cannot be null or empty")
 		}
-		return *user, errors.New("TLS certificate is not valid")
+		return *user, user.CheckTLSCertificate(tlsCert)
 	default:
 		return *user, fmt.Errorf("certificate authentication is not supported for protocol %v", protocol)
 	}
@@ -2121,11 +2294,32 @@ func checkUserPasscode(user *User, password, protocol string) (string, error) {
 	return password, nil
 }
 
-func checkUserAndPubKey(user *User, pubKey []byte) (User, string, error) {
+func checkUserAndPubKey(user *User, pubKey []byte, isSSHCert bool) (User, string, error) {
 	err := user.CheckLoginConditions()
 	if err != nil {
 		return *user, "", err
 	}
+	if isSSHCert {
+		parsedKey, err := ssh.ParsePublicKey(pubKey)
+		if err != nil {
+			return *user, "", err
+		}
+		cert, ok := parsedKey.(*ssh.Certificate)
+		if !ok {
+			return *user, "", ErrInvalidCredentials
+		}
+		if user.IsSSHCertPrincipalMappingEnabled() {
+			if err := user.CheckSSHCertPrincipals(cert); err == nil {
+				return *user, getPubKeyInfo(cert, ""), nil
+			}
+		}
+		// without a principal mapping the certificate must be listed within the user's public keys
+		if len(cert.ValidPrincipals) > 0 && !util.IsStringInSlice(user.Username, cert.ValidPrincipals) {
+			providerLog(logger.LevelDebug, "certificate %#v principals %v are not allowed for user %#v",
+				cert.KeyId, cert.ValidPrincipals, user.Username)
+			return *user, "", ErrInvalidCredentials
+		}
+	}
 	if len(user.PublicKeys) == 0 {
 		return *user, "", ErrInvalidCredentials
 	}
@@ -2136,18 +2330,22 @@ func checkUserAndPubKey(user *User, pubKey []byte) (User, string, error) {
 			return *user, "", err
 		}
 		if bytes.Equal(storedPubKey.Marshal(), pubKey) {
-			certInfo := ""
-			cert, ok := storedPubKey.(*ssh.Certificate)
-			if ok {
-				certInfo = fmt.Sprintf(" %v ID: %v Serial: %v CA: %v", cert.Type(), cert.KeyId, cert.Serial,
-					ssh.FingerprintSHA256(cert.SignatureKey))
-			}
-			return *user, fmt.Sprintf("%v:%v%v", ssh.FingerprintSHA256(storedPubKey), comment, certInfo), nil
+			return *user, getPubKeyInfo(storedPubKey, comment), nil
 		}
 	}
 	return *user, "", ErrInvalidCredentials
 }
 
+func getPubKeyInfo(pubKey ssh.PublicKey, comment string) string {
+	certInfo := ""
+	cert, ok := pubKey.(*ssh.Certificate)
+	if ok {
+		certInfo = fmt.Sprintf(" %v ID: %v Serial: %v CA: %v", cert.Type(), cert.KeyId, cert.Serial,
+			ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+	return fmt.Sprintf("%v:%v%v", ssh.FingerprintSHA256(pubKey), comment, certInfo)
+}
+
 func compareUnixPasswordAndHash(user *User, password string) (bool, error) {
 	var crypter crypt.Crypter
 	if strings.HasPrefix(user.Password, sha512cryptPwdPrefix) {
@@ -2352,13 +2550,13 @@ func terminateInteractiveAuthProgram(cmd *exec.Cmd, isFinished bool) {
 	}
 }
 
-func sendKeyboardAuthHTTPReq(url string, request *plugin.KeyboardAuthRequest) (*plugin.KeyboardAuthResponse, error) {
+func sendKeyboardAuthHTTPReq(ctx context.Context, url string, request *plugin.KeyboardAuthRequest) (*plugin.KeyboardAuthResponse, error) {
 	reqAsJSON, err := json.Marshal(request)
 	if err != nil {
 		providerLog(logger.LevelError, "error serializing keyboard interactive auth request: %v", err)
 		return nil, err
 	}
-	resp, err := httpclient.Post(url, "application/json", bytes.NewBuffer(reqAsJSON))
+	resp, err := httpclient.PostWithContext(ctx, url, "application/json", bytes.NewBuffer(reqAsJSON))
 	if err != nil {
 		providerLog(logger.LevelError, "error getting keyboard interactive auth hook HTTP response: %v", err)
 		return nil, err
@@ -2451,7 +2649,9 @@ func executeKeyboardInteractivePlugin(user *User, client ssh.KeyboardInteractive
 	}
 }
 
-func executeKeyboardInteractiveHTTPHook(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+func executeKeyboardInteractiveHTTPHook(ctx context.Context, user *User, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (int, error) {
 	authResult := 0
 	requestID := xid.New().String()
 	authStep := 1
@@ -2465,7 +2665,7 @@ func executeKeyboardInteractiveHTTPHook(user *User, authHook string, client ssh.
 	var response *plugin.KeyboardAuthResponse
 	var err error
 	for {
-		response, err = sendKeyboardAuthHTTPReq(authHook, req)
+		response, err = sendKeyboardAuthHTTPReq(ctx, authHook, req)
 		if err != nil {
 			return authResult, err
 		}
@@ -2619,17 +2819,24 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 	return authResult, err
 }
 
-func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+func doKeyboardInteractiveAuth(ctx context.Context, user *User, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (User, error) {
 	var authResult int
 	var err error
 	if plugin.Handler.HasAuthScope(plugin.AuthScopeKeyboardInteractive) {
+		_, span := startHookSpan(ctx, "keyboard_interactive", "")
 		authResult, err = executeKeyboardInteractivePlugin(user, client, ip, protocol)
+		span.End(err)
 	} else if authHook != "" {
+		var span *tracing.Span
+		ctx, span = startHookSpan(ctx, "keyboard_interactive", authHook)
 		if strings.HasPrefix(authHook, "http") {
-			authResult, err = executeKeyboardInteractiveHTTPHook(user, authHook, client, ip, protocol)
+			authResult, err = executeKeyboardInteractiveHTTPHook(ctx, user, authHook, client, ip, protocol)
 		} else {
 			authResult, err = executeKeyboardInteractiveProgram(user, authHook, client, ip, protocol)
 		}
+		span.End(err)
 	} else {
 		authResult, err = doBuiltinKeyboardInteractiveAuth(user, client, ip, protocol)
 	}
@@ -2719,7 +2926,7 @@ func executeCheckPasswordHook(username, password, ip, protocol string) (checkPas
 	return response, err
 }
 
-func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte) ([]byte, error) {
+func getPreLoginHookResponse(ctx context.Context, loginMethod, ip, protocol string, userAsJSON []byte) ([]byte, error) {
 	if strings.HasPrefix(config.PreLoginHook, "http") {
 		var url *url.URL
 		var result []byte
@@ -2734,7 +2941,7 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		q.Add("protocol", protocol)
 		url.RawQuery = q.Encode()
 
-		resp, err := httpclient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
+		resp, err := httpclient.PostWithContext(ctx, url.String(), "application/json", bytes.NewBuffer(userAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting pre-login hook response: %v", err)
 			return result, err
@@ -2748,7 +2955,7 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		}
 		return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, config.PreLoginHook)
 	cmd.Env = append(os.Environ(),
@@ -2760,16 +2967,18 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 	return cmd.Output()
 }
 
-func executePreLoginHook(username, loginMethod, ip, protocol string) (User, error) {
-	u, userAsJSON, err := getUserAndJSONForHook(username)
+func executePreLoginHook(ctx context.Context, username, loginMethod, ip, protocol string) (User, error) {
+	u, userAsJSON, err := getUserAndJSONForHook(ctx, username)
 	if err != nil {
 		return u, err
 	}
 	if u.Filters.Hooks.PreLoginDisabled {
 		return u, nil
 	}
+	hookCtx, span := startHookSpan(ctx, "pre_login", config.PreLoginHook)
 	startTime := time.Now()
-	out, err := getPreLoginHookResponse(loginMethod, ip, protocol, userAsJSON)
+	out, err := getPreLoginHookResponse(hookCtx, loginMethod, ip, protocol, userAsJSON)
+	span.End(err)
 	if err != nil {
 		return u, fmt.Errorf("pre-login hook error: %v, username %#v, ip %v, protocol %v elapsed %v",
 			err, username, ip, protocol, time.Since(startTime))
@@ -2830,6 +3039,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 
 // ExecutePostLoginHook executes the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	auditLoginEvent(user.Username, loginMethod, ip, protocol, err)
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -2893,7 +3103,9 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 	}()
 }
 
-func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol string, cert *x509.Certificate, userAsJSON []byte) ([]byte, error) {
+func getExternalAuthResponse(ctx context.Context, username, password, pkey, keyboardInteractive, ip, protocol string,
+	cert *x509.Certificate, userAsJSON []byte,
+) ([]byte, error) {
 	var tlsCert string
 	if cert != nil {
 		var err error
@@ -2920,7 +3132,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 			providerLog(logger.LevelError, "error serializing external auth request: %v", err)
 			return result, err
 		}
-		resp, err := httpclient.Post(config.ExternalAuthHook, "application/json", bytes.NewBuffer(authRequestAsJSON))
+		resp, err := httpclient.PostWithContext(ctx, config.ExternalAuthHook, "application/json", bytes.NewBuffer(authRequestAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting external auth hook HTTP response: %v", err)
 			return result, err
@@ -2933,7 +3145,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 
 		return io.ReadAll(io.LimitReader(resp.Body, maxHookResponseSize))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, config.ExternalAuthHook)
 	cmd.Env = append(os.Environ(),
@@ -2957,10 +3169,12 @@ func updateUserFromExtAuthResponse(user *User, password, pkey string) {
 	}
 }
 
-func doExternalAuth(username, password string, pubKey []byte, keyboardInteractive, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+func doExternalAuth(ctx context.Context, username, password string, pubKey []byte, keyboardInteractive, ip, protocol string,
+	tlsCert *x509.Certificate,
+) (User, error) {
 	var user User
 
-	u, userAsJSON, err := getUserAndJSONForHook(username)
+	u, userAsJSON, err := getUserAndJSONForHook(ctx, username)
 	if err != nil {
 		return user, err
 	}
@@ -2974,8 +3188,10 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		return user, err
 	}
 
+	hookCtx, span := startHookSpan(ctx, "external_auth", config.ExternalAuthHook)
 	startTime := time.Now()
-	out, err := getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol, tlsCert, userAsJSON)
+	out, err := getExternalAuthResponse(hookCtx, username, password, pkey, keyboardInteractive, ip, protocol, tlsCert, userAsJSON)
+	span.End(err)
 	if err != nil {
 		return user, fmt.Errorf("external auth error for user %#v: %v, elapsed: %v", username, err, time.Since(startTime))
 	}
@@ -3029,12 +3245,12 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 	return provider.userExists(user.Username)
 }
 
-func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
+func doPluginAuth(ctx context.Context, username, password string, pubKey []byte, ip, protocol string,
 	tlsCert *x509.Certificate, authScope int,
 ) (User, error) {
 	var user User
 
-	u, userAsJSON, err := getUserAndJSONForHook(username)
+	u, userAsJSON, err := getUserAndJSONForHook(ctx, username)
 	if err != nil {
 		return user, err
 	}
@@ -3048,9 +3264,11 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 		return user, err
 	}
 
+	_, span := startHookSpan(ctx, "plugin_auth", "")
 	startTime := time.Now()
 
 	out, err := plugin.Handler.Authenticate(username, password, ip, protocol, pkey, tlsCert, authScope, userAsJSON)
+	span.End(err)
 	if err != nil {
 		return user, fmt.Errorf("plugin auth error for user %#v: %v, elapsed: %v, auth scope: %v",
 			username, err, time.Since(startTime), authScope)
@@ -3093,9 +3311,9 @@ func doPluginAuth(username, password string, pubKey []byte, ip, protocol string,
 	return provider.userExists(user.Username)
 }
 
-func getUserAndJSONForHook(username string) (User, []byte, error) {
+func getUserAndJSONForHook(ctx context.Context, username string) (User, []byte, error) {
 	var userAsJSON []byte
-	u, err := provider.userExists(username)
+	u, err := userExistsWithContext(ctx, username)
 	if err != nil {
 		if _, ok := err.(*util.RecordNotFoundError); !ok {
 			return u, userAsJSON, err
@@ -0,0 +1,47 @@
+package dataprovider
+
+// DedupContent defines a deduplicated content
+type DedupContent struct {
+	// SHA256 hash of the content
+	Hash string `json:"hash"`
+	// Content size as bytes
+	Size int64 `json:"size"`
+	// Number of files referencing this content
+	References int   `json:"references"`
+	CreatedAt  int64 `json:"created_at"`
+	UpdatedAt  int64 `json:"updated_at"`
+}
+
+// DedupStats defines the content deduplication usage
+type DedupStats struct {
+	// Number of stored contents
+	Contents int64 `json:"contents"`
+	// Number of files referencing the stored contents
+	References int64 `json:"references"`
+	// Disk space used by the stored contents
+	PhysicalSize int64 `json:"physical_size"`
+	// Disk space that would be used without deduplication,
+	// quotas are always computed using this size
+	LogicalSize int64 `json:"logical_size"`
+}
+
+// UpdateDedupContent adds or updates the specified deduplicated content
+func UpdateDedupContent(hash string, size int64, references int) error {
+	return provider.updateDedupContent(hash, size, references)
+}
+
+// DeleteDedupContent removes the content with the specified hash
+func DeleteDedupContent(hash string) error {
+	return provider.deleteDedupContent(hash)
+}
+
+// CleanupDedupContents removes the contents not updated after the specified
+// time, as unix timestamp in milliseconds
+func CleanupDedupContents(before int64) error {
+	return provider.cleanupDedupContents(before)
+}
+
+// GetDedupStats returns the content deduplication usage
+func GetDedupStats() (DedupStats, error) {
+	return provider.getDedupStats()
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,6 +45,12 @@ type memoryProviderHandle struct {
 	shares map[string]Share
 	// slice with ordered shares shareID
 	sharesIDs []string
+	// map for deduplicated contents, hash is the key
+	dedupContents map[string]DedupContent
+	// map for WebDAV dead properties, storage and path are the key
+	webDAVProperties map[string]WebDAVProperties
+	// map for WebDAV locks, token is the key
+	webDAVLocks map[string]WebDAVLock
 }
 
 // MemoryProvider auth provider for a memory store
@@ -61,18 +68,21 @@ func initializeMemoryProvider(basePath string) {
 	}
 	provider = &MemoryProvider{
 		dbHandle: &memoryProviderHandle{
-			isClosed:        false,
-			usernames:       []string{},
-			users:           make(map[string]User),
-			vfolders:        make(map[string]vfs.BaseVirtualFolder),
-			vfoldersNames:   []string{},
-			admins:          make(map[string]Admin),
-			adminsUsernames: []string{},
-			apiKeys:         make(map[string]APIKey),
-			apiKeysIDs:      []string{},
-			shares:          make(map[string]Share),
-			sharesIDs:       []string{},
-			configFile:      configFile,
+			isClosed:         false,
+			usernames:        []string{},
+			users:            make(map[string]User),
+			vfolders:         make(map[string]vfs.BaseVirtualFolder),
+			vfoldersNames:    []string{},
+			admins:           make(map[string]Admin),
+			adminsUsernames:  []string{},
+			apiKeys:          make(map[string]APIKey),
+			apiKeysIDs:       []string{},
+			shares:           make(map[string]Share),
+			sharesIDs:        []string{},
+			dedupContents:    make(map[string]DedupContent),
+			webDAVProperties: make(map[string]WebDAVProperties),
+			webDAVLocks:      make(map[string]WebDAVLock),
+			configFile:       configFile,
 		},
 	}
 	if err := provider.reloadConfig(); err != nil {
@@ -126,7 +136,7 @@ func (p *MemoryProvider) validateUserAndPass(username, password, ip, protocol st
 	return checkUserAndPass(&user, password, ip, protocol)
 }
 
-func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
+func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte, isSSHCert bool) (User, string, error) {
 	var user User
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
@@ -136,7 +146,7 @@ func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte) (
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, "", err
 	}
-	return checkUserAndPubKey(&user, pubKey)
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
 }
 
 func (p *MemoryProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
@@ -662,6 +672,7 @@ func (p *MemoryProvider) addOrUpdateFolderInternal(baseFolder *vfs.BaseVirtualFo
 		folder.MappedPath = baseFolder.MappedPath
 		folder.Description = baseFolder.Description
 		folder.FsConfig = baseFolder.FsConfig.GetACopy()
+		folder.LowerFolder = baseFolder.LowerFolder
 		if !util.IsStringInSlice(username, folder.Users) {
 			folder.Users = append(folder.Users, username)
 		}
@@ -1288,6 +1299,239 @@ func (p *MemoryProvider) cleanupDefender(from int64) error {
 	return ErrNotImplemented
 }
 
+func (p *MemoryProvider) updateDedupContent(hash string, size int64, references int) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	content, ok := p.dbHandle.dedupContents[hash]
+	if !ok {
+		content = DedupContent{
+			Hash:      hash,
+			CreatedAt: now,
+		}
+	}
+	content.Size = size
+	content.References = references
+	content.UpdatedAt = now
+	p.dbHandle.dedupContents[hash] = content
+	return nil
+}
+
+func (p *MemoryProvider) deleteDedupContent(hash string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	delete(p.dbHandle.dedupContents, hash)
+	return nil
+}
+
+func (p *MemoryProvider) cleanupDedupContents(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for hash, content := range p.dbHandle.dedupContents {
+		if content.UpdatedAt < before {
+			delete(p.dbHandle.dedupContents, hash)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) getDedupStats() (DedupStats, error) {
+	var stats DedupStats
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return stats, errMemoryProviderClosed
+	}
+	for _, content := range p.dbHandle.dedupContents {
+		stats.Contents++
+		stats.References += int64(content.References)
+		stats.PhysicalSize += content.Size
+		stats.LogicalSize += content.Size * int64(content.References)
+	}
+	return stats, nil
+}
+
+func (p *MemoryProvider) getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return WebDAVProperties{}, errMemoryProviderClosed
+	}
+	props, ok := p.dbHandle.webDAVProperties[getWebDAVPropertiesKey(storage, fsPath)]
+	if !ok {
+		return props, util.NewRecordNotFoundError(fmt.Sprintf("no WebDAV properties for path %#v", fsPath))
+	}
+	props.Properties = append([]WebDAVProperty(nil), props.Properties...)
+	return props, nil
+}
+
+func (p *MemoryProvider) setWebDAVProperties(props *WebDAVProperties) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	stored := *props
+	stored.Properties = append([]WebDAVProperty(nil), props.Properties...)
+	stored.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.webDAVProperties[stored.getKey()] = stored
+	return nil
+}
+
+func (p *MemoryProvider) renameWebDAVProperties(storage, source, target string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	var toMove []WebDAVProperties
+	for key, props := range p.dbHandle.webDAVProperties {
+		if props.Storage == storage && isWebDAVPropertiesPathMatch(props.Path, source) {
+			toMove = append(toMove, props)
+			delete(p.dbHandle.webDAVProperties, key)
+		}
+	}
+	p.deleteWebDAVPropertiesLocked(storage, target)
+	for _, props := range toMove {
+		props.Path = target + strings.TrimPrefix(props.Path, source)
+		p.dbHandle.webDAVProperties[props.getKey()] = props
+	}
+	return nil
+}
+
+func (p *MemoryProvider) deleteWebDAVProperties(storage, fsPath string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.deleteWebDAVPropertiesLocked(storage, fsPath)
+	return nil
+}
+
+func (p *MemoryProvider) deleteWebDAVPropertiesLocked(storage, fsPath string) {
+	for key, props := range p.dbHandle.webDAVProperties {
+		if props.Storage == storage && isWebDAVPropertiesPathMatch(props.Path, fsPath) {
+			delete(p.dbHandle.webDAVProperties, key)
+		}
+	}
+}
+
+func (p *MemoryProvider) addWebDAVLock(lock *WebDAVLock) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.webDAVLocks[lock.Token]; ok {
+		return fmt.Errorf("WebDAV lock %#v already exists", lock.Token)
+	}
+	now := time.Now()
+	for token, l := range p.dbHandle.webDAVLocks {
+		if l.getKey() == lock.getKey() {
+			if !l.IsExpired(now) {
+				return fmt.Errorf("path %#v is already locked", lock.Path)
+			}
+			delete(p.dbHandle.webDAVLocks, token)
+		}
+	}
+	p.dbHandle.webDAVLocks[lock.Token] = *lock
+	return nil
+}
+
+func (p *MemoryProvider) getWebDAVLock(token string) (WebDAVLock, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return WebDAVLock{}, errMemoryProviderClosed
+	}
+	lock, ok := p.dbHandle.webDAVLocks[token]
+	if !ok {
+		return lock, util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+	}
+	return lock, nil
+}
+
+func (p *MemoryProvider) getWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	var locks []WebDAVLock
+	for _, lock := range p.dbHandle.webDAVLocks {
+		if lock.Storage == storage {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (p *MemoryProvider) updateWebDAVLockExpiration(token string, expiresAt int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	lock, ok := p.dbHandle.webDAVLocks[token]
+	if !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+	}
+	lock.ExpiresAt = expiresAt
+	p.dbHandle.webDAVLocks[token] = lock
+	return nil
+}
+
+func (p *MemoryProvider) deleteWebDAVLock(token string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.webDAVLocks[token]; !ok {
+		return util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+	}
+	delete(p.dbHandle.webDAVLocks, token)
+	return nil
+}
+
+func (p *MemoryProvider) deleteWebDAVLocks(storage, fsPath string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for token, lock := range p.dbHandle.webDAVLocks {
+		if lock.Storage == storage && isWebDAVPropertiesPathMatch(lock.Path, fsPath) {
+			delete(p.dbHandle.webDAVLocks, token)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) cleanupWebDAVLocks(before int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for token, lock := range p.dbHandle.webDAVLocks {
+		if lock.ExpiresAt > 0 && lock.ExpiresAt < before {
+			delete(p.dbHandle.webDAVLocks, token)
+		}
+	}
+	return nil
+}
+
 func (p *MemoryProvider) getNextID() int64 {
 	nextID := int64(1)
 	for _, v := range p.dbHandle.users {
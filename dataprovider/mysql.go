@@ -29,6 +29,9 @@ const (
 		"DROP TABLE IF EXISTS `{{users}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{defender_events}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{defender_hosts}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{dedup_contents}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{webdav_properties}}` CASCADE;" +
+		"DROP TABLE IF EXISTS `{{webdav_locks}}` CASCADE;" +
 		"DROP TABLE IF EXISTS `{{schema_version}}` CASCADE;"
 	mysqlInitialSQL = "CREATE TABLE `{{schema_version}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `version` integer NOT NULL);" +
 		"CREATE TABLE `{{admins}}` (`id` integer AUTO_INCREMENT NOT NULL PRIMARY KEY, `username` varchar(255) NOT NULL UNIQUE, " +
@@ -95,6 +98,26 @@ const (
 		"CREATE INDEX `{{prefix}}defender_events_date_time_idx` ON `{{defender_events}}` (`date_time`);"
 	mysqlV15DownSQL = "DROP TABLE `{{defender_events}}` CASCADE;" +
 		"DROP TABLE `{{defender_hosts}}` CASCADE;"
+	mysqlV16SQL = "CREATE TABLE `{{dedup_contents}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`hash` varchar(64) NOT NULL UNIQUE, `size` bigint NOT NULL, `ref_count` integer NOT NULL, " +
+		"`created_at` bigint NOT NULL, `updated_at` bigint NOT NULL);" +
+		"CREATE INDEX `{{prefix}}dedup_contents_updated_at_idx` ON `{{dedup_contents}}` (`updated_at`);"
+	mysqlV16DownSQL = "DROP TABLE `{{dedup_contents}}` CASCADE;"
+	mysqlV17SQL     = "ALTER TABLE `{{folders}}` ADD COLUMN `lower_folder` varchar(255) NULL;"
+	mysqlV17DownSQL = "ALTER TABLE `{{folders}}` DROP COLUMN `lower_folder`;"
+	mysqlV18SQL     = "CREATE TABLE `{{webdav_properties}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`path_hash` varchar(64) NOT NULL UNIQUE, `storage` varchar(512) NOT NULL, `path` longtext NOT NULL, " +
+		"`properties` longtext NOT NULL, `updated_at` bigint NOT NULL);" +
+		"CREATE INDEX `{{prefix}}webdav_properties_storage_idx` ON `{{webdav_properties}}` (`storage`);"
+	mysqlV18DownSQL = "DROP TABLE `{{webdav_properties}}` CASCADE;"
+	mysqlV19SQL     = "CREATE TABLE `{{webdav_locks}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`token` varchar(255) NOT NULL UNIQUE, `path_hash` varchar(64) NOT NULL UNIQUE, `storage` varchar(512) NOT NULL, " +
+		"`path` longtext NOT NULL, `root` longtext NOT NULL, `username` varchar(255) NOT NULL, `owner` longtext NOT NULL, " +
+		"`zero_depth` integer NOT NULL, `expires_at` bigint NOT NULL, " +
+		"`created_at` bigint NOT NULL);" +
+		"CREATE INDEX `{{prefix}}webdav_locks_storage_idx` ON `{{webdav_locks}}` (`storage`);" +
+		"CREATE INDEX `{{prefix}}webdav_locks_expires_at_idx` ON `{{webdav_locks}}` (`expires_at`);"
+	mysqlV19DownSQL = "DROP TABLE `{{webdav_locks}}` CASCADE;"
 )
 
 // MySQLProvider auth provider for MySQL/MariaDB database
@@ -142,6 +165,10 @@ func getMySQLConnectionString(redactedPwd bool) string {
 	return connectionString
 }
 
+func checkMySQLSchemaVersion() error {
+	return sqlCommonCheckSchemaVersion("mysql", getMySQLConnectionString(false))
+}
+
 func (p *MySQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -154,8 +181,8 @@ func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCer
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, p.dbHandle)
+func (p *MySQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
 }
 
 func (p *MySQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
@@ -356,6 +383,66 @@ func (p *MySQLProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *MySQLProvider) updateDedupContent(hash string, size int64, references int) error {
+	return sqlCommonUpdateDedupContent(hash, size, references, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteDedupContent(hash string) error {
+	return sqlCommonDeleteDedupContent(hash, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupDedupContents(before int64) error {
+	return sqlCommonCleanupDedupContents(before, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDedupStats() (DedupStats, error) {
+	return sqlCommonGetDedupStats(p.dbHandle)
+}
+
+func (p *MySQLProvider) getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	return sqlCommonGetWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *MySQLProvider) setWebDAVProperties(props *WebDAVProperties) error {
+	return sqlCommonSetWebDAVProperties(props, p.dbHandle)
+}
+
+func (p *MySQLProvider) renameWebDAVProperties(storage, source, target string) error {
+	return sqlCommonRenameWebDAVProperties(storage, source, target, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteWebDAVProperties(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *MySQLProvider) addWebDAVLock(lock *WebDAVLock) error {
+	return sqlCommonAddWebDAVLock(lock, p.dbHandle)
+}
+
+func (p *MySQLProvider) getWebDAVLock(token string) (WebDAVLock, error) {
+	return sqlCommonGetWebDAVLock(token, p.dbHandle)
+}
+
+func (p *MySQLProvider) getWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	return sqlCommonGetWebDAVLocks(storage, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateWebDAVLockExpiration(token string, expiresAt int64) error {
+	return sqlCommonUpdateWebDAVLockExpiration(token, expiresAt, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteWebDAVLock(token string) error {
+	return sqlCommonDeleteWebDAVLock(token, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteWebDAVLocks(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVLocks(storage, fsPath, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupWebDAVLocks(before int64) error {
+	return sqlCommonCleanupWebDAVLocks(before, p.dbHandle)
+}
+
 func (p *MySQLProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -409,6 +496,14 @@ func (p *MySQLProvider) migrateDatabase() error {
 		return updateMySQLDatabaseFromV13(p.dbHandle)
 	case version == 14:
 		return updateMySQLDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updateMySQLDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updateMySQLDatabaseFromV16(p.dbHandle)
+	case version == 17:
+		return updateMySQLDatabaseFromV17(p.dbHandle)
+	case version == 18:
+		return updateMySQLDatabaseFromV18(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database version %v is newer than the supported one: %v", version,
@@ -431,6 +526,14 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 19:
+		return downgradeMySQLDatabaseFromV19(p.dbHandle)
+	case 18:
+		return downgradeMySQLDatabaseFromV18(p.dbHandle)
+	case 17:
+		return downgradeMySQLDatabaseFromV17(p.dbHandle)
+	case 16:
+		return downgradeMySQLDatabaseFromV16(p.dbHandle)
 	case 15:
 		return downgradeMySQLDatabaseFromV15(p.dbHandle)
 	case 14:
@@ -456,6 +559,9 @@ func (p *MySQLProvider) resetDatabase() error {
 	sql = strings.ReplaceAll(sql, "{{shares}}", sqlTableShares)
 	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
 	sql = strings.ReplaceAll(sql, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{webdav_locks}}", sqlTableWebDAVLocks)
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, strings.Split(sql, ";"), 0)
 }
 
@@ -488,7 +594,63 @@ func updateMySQLDatabaseFromV13(dbHandle *sql.DB) error {
 }
 
 func updateMySQLDatabaseFromV14(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom14To15(dbHandle)
+	if err := updateMySQLDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV15(dbHandle)
+}
+
+func updateMySQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV16(dbHandle)
+}
+
+func updateMySQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom16To17(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV17(dbHandle)
+}
+
+func updateMySQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := updateMySQLDatabaseFrom17To18(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV18(dbHandle)
+}
+
+func updateMySQLDatabaseFromV18(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom18To19(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV19(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom19To18(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV18(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV18(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom18To17(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV17(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV16(dbHandle)
+}
+
+func downgradeMySQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV15(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV15(dbHandle *sql.DB) error {
@@ -541,6 +703,66 @@ func updateMySQLDatabaseFrom14To15(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 15)
 }
 
+func updateMySQLDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	sql := strings.ReplaceAll(mysqlV16SQL, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 16)
+}
+
+func updateMySQLDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	sql := strings.ReplaceAll(mysqlV17SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 17)
+}
+
+func updateMySQLDatabaseFrom17To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 17 -> 18")
+	providerLog(logger.LevelInfo, "updating database version: 17 -> 18")
+	sql := strings.ReplaceAll(mysqlV18SQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 18)
+}
+
+func updateMySQLDatabaseFrom18To19(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 18 -> 19")
+	providerLog(logger.LevelInfo, "updating database version: 18 -> 19")
+	sql := strings.ReplaceAll(mysqlV19SQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 19)
+}
+
+func downgradeMySQLDatabaseFrom19To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 19 -> 18")
+	providerLog(logger.LevelInfo, "downgrading database version: 19 -> 18")
+	sql := strings.ReplaceAll(mysqlV19DownSQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 18)
+}
+
+func downgradeMySQLDatabaseFrom18To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 18 -> 17")
+	providerLog(logger.LevelInfo, "downgrading database version: 18 -> 17")
+	sql := strings.ReplaceAll(mysqlV18DownSQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 17)
+}
+
+func downgradeMySQLDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	sql := strings.ReplaceAll(mysqlV17DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 16)
+}
+
+func downgradeMySQLDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	sql := strings.ReplaceAll(mysqlV16DownSQL, "{{dedup_contents}}", sqlTableDedupContents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 15)
+}
+
 func downgradeMySQLDatabaseFrom15To14(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database version: 15 -> 14")
 	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
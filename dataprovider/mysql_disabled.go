@@ -16,3 +16,7 @@ func init() {
 func initializeMySQLProvider() error {
 	return errors.New("MySQL disabled at build time")
 }
+
+func checkMySQLSchemaVersion() error {
+	return errors.New("MySQL disabled at build time")
+}
@@ -29,6 +29,9 @@ DROP TABLE IF EXISTS "{{shares}}" CASCADE;
 DROP TABLE IF EXISTS "{{users}}" CASCADE;
 DROP TABLE IF EXISTS "{{defender_events}}" CASCADE;
 DROP TABLE IF EXISTS "{{defender_hosts}}" CASCADE;
+DROP TABLE IF EXISTS "{{dedup_contents}}" CASCADE;
+DROP TABLE IF EXISTS "{{webdav_properties}}" CASCADE;
+DROP TABLE IF EXISTS "{{webdav_locks}}" CASCADE;
 DROP TABLE IF EXISTS "{{schema_version}}" CASCADE;
 `
 	pgsqlInitial = `CREATE TABLE "{{schema_version}}" ("id" serial NOT NULL PRIMARY KEY, "version" integer NOT NULL);
@@ -113,6 +116,25 @@ CREATE INDEX "{{prefix}}defender_events_host_id_idx" ON "{{defender_events}}" ("
 	pgsqlV15DownSQL = `DROP TABLE "{{defender_events}}" CASCADE;
 DROP TABLE "{{defender_hosts}}" CASCADE;
 `
+	pgsqlV16SQL = `CREATE TABLE "{{dedup_contents}}" ("id" bigserial NOT NULL PRIMARY KEY, "hash" varchar(64) NOT NULL UNIQUE,
+"size" bigint NOT NULL, "ref_count" integer NOT NULL, "created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}dedup_contents_updated_at_idx" ON "{{dedup_contents}}" ("updated_at");
+`
+	pgsqlV16DownSQL = `DROP TABLE "{{dedup_contents}}" CASCADE;`
+	pgsqlV17SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "lower_folder" varchar(255) NULL;`
+	pgsqlV17DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "lower_folder" CASCADE;`
+	pgsqlV18SQL     = `CREATE TABLE "{{webdav_properties}}" ("id" bigserial NOT NULL PRIMARY KEY, "path_hash" varchar(64) NOT NULL UNIQUE,
+"storage" varchar(512) NOT NULL, "path" text NOT NULL, "properties" text NOT NULL, "updated_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}webdav_properties_storage_idx" ON "{{webdav_properties}}" ("storage");
+`
+	pgsqlV18DownSQL = `DROP TABLE "{{webdav_properties}}" CASCADE;`
+	pgsqlV19SQL     = `CREATE TABLE "{{webdav_locks}}" ("id" bigserial NOT NULL PRIMARY KEY, "token" varchar(255) NOT NULL UNIQUE,
+"path_hash" varchar(64) NOT NULL UNIQUE, "storage" varchar(512) NOT NULL, "path" text NOT NULL, "root" text NOT NULL,
+"username" varchar(255) NOT NULL, "owner" text NOT NULL, "zero_depth" integer NOT NULL, "expires_at" bigint NOT NULL, "created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}webdav_locks_storage_idx" ON "{{webdav_locks}}" ("storage");
+CREATE INDEX "{{prefix}}webdav_locks_expires_at_idx" ON "{{webdav_locks}}" ("expires_at");
+`
+	pgsqlV19DownSQL = `DROP TABLE "{{webdav_locks}}" CASCADE;`
 )
 
 // PGSQLProvider auth provider for PostgreSQL database
@@ -160,6 +182,10 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 	return connectionString
 }
 
+func checkPGSQLSchemaVersion() error {
+	return sqlCommonCheckSchemaVersion("postgres", getPGSQLConnectionString(false))
+}
+
 func (p *PGSQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -172,8 +198,8 @@ func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCer
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, p.dbHandle)
+func (p *PGSQLProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
 }
 
 func (p *PGSQLProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
@@ -374,6 +400,66 @@ func (p *PGSQLProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *PGSQLProvider) updateDedupContent(hash string, size int64, references int) error {
+	return sqlCommonUpdateDedupContent(hash, size, references, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteDedupContent(hash string) error {
+	return sqlCommonDeleteDedupContent(hash, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupDedupContents(before int64) error {
+	return sqlCommonCleanupDedupContents(before, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDedupStats() (DedupStats, error) {
+	return sqlCommonGetDedupStats(p.dbHandle)
+}
+
+func (p *PGSQLProvider) getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	return sqlCommonGetWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *PGSQLProvider) setWebDAVProperties(props *WebDAVProperties) error {
+	return sqlCommonSetWebDAVProperties(props, p.dbHandle)
+}
+
+func (p *PGSQLProvider) renameWebDAVProperties(storage, source, target string) error {
+	return sqlCommonRenameWebDAVProperties(storage, source, target, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteWebDAVProperties(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addWebDAVLock(lock *WebDAVLock) error {
+	return sqlCommonAddWebDAVLock(lock, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getWebDAVLock(token string) (WebDAVLock, error) {
+	return sqlCommonGetWebDAVLock(token, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	return sqlCommonGetWebDAVLocks(storage, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateWebDAVLockExpiration(token string, expiresAt int64) error {
+	return sqlCommonUpdateWebDAVLockExpiration(token, expiresAt, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteWebDAVLock(token string) error {
+	return sqlCommonDeleteWebDAVLock(token, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteWebDAVLocks(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVLocks(storage, fsPath, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupWebDAVLocks(before int64) error {
+	return sqlCommonCleanupWebDAVLocks(before, p.dbHandle)
+}
+
 func (p *PGSQLProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -433,6 +519,14 @@ func (p *PGSQLProvider) migrateDatabase() error {
 		return updatePGSQLDatabaseFromV13(p.dbHandle)
 	case version == 14:
 		return updatePGSQLDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updatePGSQLDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updatePGSQLDatabaseFromV16(p.dbHandle)
+	case version == 17:
+		return updatePGSQLDatabaseFromV17(p.dbHandle)
+	case version == 18:
+		return updatePGSQLDatabaseFromV18(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database version %v is newer than the supported one: %v", version,
@@ -455,6 +549,14 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 19:
+		return downgradePGSQLDatabaseFromV19(p.dbHandle)
+	case 18:
+		return downgradePGSQLDatabaseFromV18(p.dbHandle)
+	case 17:
+		return downgradePGSQLDatabaseFromV17(p.dbHandle)
+	case 16:
+		return downgradePGSQLDatabaseFromV16(p.dbHandle)
 	case 15:
 		return downgradePGSQLDatabaseFromV15(p.dbHandle)
 	case 14:
@@ -480,6 +582,9 @@ func (p *PGSQLProvider) resetDatabase() error {
 	sql = strings.ReplaceAll(sql, "{{shares}}", sqlTableShares)
 	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
 	sql = strings.ReplaceAll(sql, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{webdav_locks}}", sqlTableWebDAVLocks)
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, []string{sql}, 0)
 }
 
@@ -512,7 +617,63 @@ func updatePGSQLDatabaseFromV13(dbHandle *sql.DB) error {
 }
 
 func updatePGSQLDatabaseFromV14(dbHandle *sql.DB) error {
-	return updatePGSQLDatabaseFrom14To15(dbHandle)
+	if err := updatePGSQLDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV15(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV16(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom16To17(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV17(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := updatePGSQLDatabaseFrom17To18(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV18(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV18(dbHandle *sql.DB) error {
+	return updatePGSQLDatabaseFrom18To19(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV19(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom19To18(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV18(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV18(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom18To17(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV17(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV16(dbHandle)
+}
+
+func downgradePGSQLDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV15(dbHandle)
 }
 
 func downgradePGSQLDatabaseFromV15(dbHandle *sql.DB) error {
@@ -565,6 +726,66 @@ func updatePGSQLDatabaseFrom14To15(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
 }
 
+func updatePGSQLDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	sql := strings.ReplaceAll(pgsqlV16SQL, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
+}
+
+func updatePGSQLDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	sql := strings.ReplaceAll(pgsqlV17SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 17)
+}
+
+func updatePGSQLDatabaseFrom17To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 17 -> 18")
+	providerLog(logger.LevelInfo, "updating database version: 17 -> 18")
+	sql := strings.ReplaceAll(pgsqlV18SQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 18)
+}
+
+func updatePGSQLDatabaseFrom18To19(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 18 -> 19")
+	providerLog(logger.LevelInfo, "updating database version: 18 -> 19")
+	sql := strings.ReplaceAll(pgsqlV19SQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 19)
+}
+
+func downgradePGSQLDatabaseFrom19To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 19 -> 18")
+	providerLog(logger.LevelInfo, "downgrading database version: 19 -> 18")
+	sql := strings.ReplaceAll(pgsqlV19DownSQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 18)
+}
+
+func downgradePGSQLDatabaseFrom18To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 18 -> 17")
+	providerLog(logger.LevelInfo, "downgrading database version: 18 -> 17")
+	sql := strings.ReplaceAll(pgsqlV18DownSQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 17)
+}
+
+func downgradePGSQLDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	sql := strings.ReplaceAll(pgsqlV17DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
+}
+
+func downgradePGSQLDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	sql := strings.ReplaceAll(pgsqlV16DownSQL, "{{dedup_contents}}", sqlTableDedupContents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
+}
+
 func downgradePGSQLDatabaseFrom15To14(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database version: 15 -> 14")
 	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
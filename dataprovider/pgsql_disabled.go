@@ -16,3 +16,7 @@ func init() {
 func initializePGSQLProvider() error {
 	return errors.New("PostgreSQL disabled at build time")
 }
+
+func checkPGSQLSchemaVersion() error {
+	return errors.New("PostgreSQL disabled at build time")
+}
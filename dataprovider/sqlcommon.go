@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cockroachdb/cockroach-go/v2/crdb"
 
@@ -18,7 +19,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 15
+	sqlDatabaseVersion     = 19
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -620,7 +621,7 @@ func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *
 	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 }
 
-func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, dbHandle *sql.DB) (User, string, error) {
+func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, isSSHCert bool, dbHandle *sql.DB) (User, string, error) {
 	var user User
 	if len(pubKey) == 0 {
 		return user, "", errors.New("credentials cannot be null or empty")
@@ -630,7 +631,7 @@ func sqlCommonValidateUserAndPubKey(username string, pubKey []byte, dbHandle *sq
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, "", err
 	}
-	return checkUserAndPubKey(&user, pubKey)
+	return checkUserAndPubKey(&user, pubKey, isSSHCert)
 }
 
 func sqlCommonCheckAvailability(dbHandle *sql.DB) error {
@@ -1231,6 +1232,377 @@ func sqlCommonCleanupDefenderEvents(from int64, dbHandle *sql.DB) error {
 	return err
 }
 
+func sqlCommonUpdateDedupContent(hash string, size int64, references int, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateDedupContentQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	now := util.GetTimeAsMsSinceEpoch(time.Now())
+	_, err = stmt.ExecContext(ctx, hash, size, references, now, now)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to update dedup content %#v: %v", hash, err)
+	}
+	return err
+}
+
+func sqlCommonDeleteDedupContent(hash string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteDedupContentQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, hash)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to delete dedup content %#v: %v", hash, err)
+	}
+	return err
+}
+
+func sqlCommonCleanupDedupContents(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDedupContentsCleanupQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, before)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to cleanup dedup contents: %v", err)
+	}
+	return err
+}
+
+func sqlCommonGetDedupStats(dbHandle *sql.DB) (DedupStats, error) {
+	var stats DedupStats
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDedupStatsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return stats, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx).Scan(&stats.Contents, &stats.References, &stats.PhysicalSize, &stats.LogicalSize)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to get dedup stats: %v", err)
+	}
+	return stats, err
+}
+
+func sqlCommonGetWebDAVProperties(storage, fsPath string, dbHandle sqlQuerier) (WebDAVProperties, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getWebDAVPropertiesQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return WebDAVProperties{}, err
+	}
+	defer stmt.Close()
+	row := stmt.QueryRowContext(ctx, getWebDAVPropertiesPathHash(storage, fsPath))
+	props, err := getWebDAVPropertiesFromDbRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return props, util.NewRecordNotFoundError(fmt.Sprintf("no WebDAV properties for path %#v", fsPath))
+	}
+	return props, err
+}
+
+func sqlCommonSetWebDAVProperties(props *WebDAVProperties, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	props.UpdatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return sqlCommonSaveWebDAVProperties(ctx, props, dbHandle)
+}
+
+func sqlCommonSaveWebDAVProperties(ctx context.Context, props *WebDAVProperties, dbHandle sqlQuerier) error {
+	q := getUpdateWebDAVPropertiesQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	properties, err := json.Marshal(props.Properties)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.ExecContext(ctx, props.getPathHash(), props.Storage, props.Path, string(properties), props.UpdatedAt)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to save WebDAV properties for path %#v: %v", props.Path, err)
+	}
+	return err
+}
+
+func sqlCommonRenameWebDAVProperties(storage, source, target string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		toMove, err := sqlCommonGetWebDAVPropertiesTree(ctx, storage, source, tx)
+		if err != nil {
+			return err
+		}
+		if err := sqlCommonDeleteWebDAVPropertiesTree(ctx, storage, source, tx); err != nil {
+			return err
+		}
+		if err := sqlCommonDeleteWebDAVPropertiesTree(ctx, storage, target, tx); err != nil {
+			return err
+		}
+		for idx := range toMove {
+			props := &toMove[idx]
+			props.Path = target + strings.TrimPrefix(props.Path, source)
+			if err := sqlCommonSaveWebDAVProperties(ctx, props, tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sqlCommonDeleteWebDAVProperties(storage, fsPath string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonDeleteWebDAVPropertiesTree(ctx, storage, fsPath, dbHandle)
+}
+
+func getWebDAVPropertiesTreeArgs(storage, fsPath string) []interface{} {
+	prefix := strings.TrimSuffix(fsPath, "/") + "/"
+	return []interface{}{storage, fsPath, utf8.RuneCountInString(prefix), prefix}
+}
+
+func sqlCommonGetWebDAVPropertiesTree(ctx context.Context, storage, fsPath string, dbHandle sqlQuerier) ([]WebDAVProperties, error) {
+	q := getWebDAVPropertiesTreeQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, getWebDAVPropertiesTreeArgs(storage, fsPath)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []WebDAVProperties
+	for rows.Next() {
+		props, err := getWebDAVPropertiesFromDbRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, props)
+	}
+	return result, rows.Err()
+}
+
+func sqlCommonDeleteWebDAVPropertiesTree(ctx context.Context, storage, fsPath string, dbHandle sqlQuerier) error {
+	q := getDeleteWebDAVPropertiesTreeQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, getWebDAVPropertiesTreeArgs(storage, fsPath)...)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to delete WebDAV properties for path %#v: %v", fsPath, err)
+	}
+	return err
+}
+
+func getWebDAVPropertiesFromDbRow(row sqlScanner) (WebDAVProperties, error) {
+	var props WebDAVProperties
+	var properties string
+
+	err := row.Scan(&props.Storage, &props.Path, &properties, &props.UpdatedAt)
+	if err != nil {
+		return props, err
+	}
+	err = json.Unmarshal([]byte(properties), &props.Properties)
+	return props, err
+}
+
+func sqlCommonAddWebDAVLock(lock *WebDAVLock, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		q := getDeleteExpiredWebDAVLockQuery()
+		stmt, err := tx.PrepareContext(ctx, q)
+		if err != nil {
+			providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+			return err
+		}
+		defer stmt.Close()
+		_, err = stmt.ExecContext(ctx, lock.getPathHash(), util.GetTimeAsMsSinceEpoch(time.Now()))
+		if err != nil {
+			return err
+		}
+		q = getAddWebDAVLockQuery()
+		insertStmt, err := tx.PrepareContext(ctx, q)
+		if err != nil {
+			providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+			return err
+		}
+		defer insertStmt.Close()
+		zeroDepth := 0
+		if lock.ZeroDepth {
+			zeroDepth = 1
+		}
+		_, err = insertStmt.ExecContext(ctx, lock.Token, lock.getPathHash(), lock.Storage, lock.Path, lock.Root,
+			lock.Username, lock.Owner, zeroDepth, lock.ExpiresAt, lock.CreatedAt)
+		return err
+	})
+}
+
+func sqlCommonGetWebDAVLock(token string, dbHandle sqlQuerier) (WebDAVLock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getWebDAVLockQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return WebDAVLock{}, err
+	}
+	defer stmt.Close()
+	lock, err := getWebDAVLockFromDbRow(stmt.QueryRowContext(ctx, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return lock, util.NewRecordNotFoundError(fmt.Sprintf("WebDAV lock %#v does not exist", token))
+	}
+	return lock, err
+}
+
+func sqlCommonGetWebDAVLocks(storage string, dbHandle sqlQuerier) ([]WebDAVLock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getWebDAVLocksQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []WebDAVLock
+	for rows.Next() {
+		lock, err := getWebDAVLockFromDbRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+func sqlCommonUpdateWebDAVLockExpiration(token string, expiresAt int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getUpdateWebDAVLockExpirationQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, expiresAt, token)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to update expiration for WebDAV lock %#v: %v", token, err)
+	}
+	return err
+}
+
+func sqlCommonDeleteWebDAVLock(token string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteWebDAVLockQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, token)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to delete WebDAV lock %#v: %v", token, err)
+	}
+	return err
+}
+
+func sqlCommonDeleteWebDAVLocks(storage, fsPath string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getDeleteWebDAVLocksTreeQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, getWebDAVPropertiesTreeArgs(storage, fsPath)...)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to delete WebDAV locks for path %#v: %v", fsPath, err)
+	}
+	return err
+}
+
+func sqlCommonCleanupWebDAVLocks(before int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+
+	q := getWebDAVLocksCleanupQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelError, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, before)
+	if err != nil {
+		providerLog(logger.LevelError, "unable to cleanup WebDAV locks: %v", err)
+	}
+	return err
+}
+
+func getWebDAVLockFromDbRow(row sqlScanner) (WebDAVLock, error) {
+	var lock WebDAVLock
+	var zeroDepth int
+
+	err := row.Scan(&lock.Token, &lock.Storage, &lock.Path, &lock.Root, &lock.Username, &lock.Owner, &zeroDepth,
+		&lock.ExpiresAt, &lock.CreatedAt)
+	lock.ZeroDepth = zeroDepth > 0
+	return lock, err
+}
+
 func getShareFromDbRow(row sqlScanner) (Share, error) {
 	var share Share
 	var description, password, allowFrom, paths sql.NullString
@@ -1434,9 +1806,9 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	}
 	defer stmt.Close()
 	row := stmt.QueryRowContext(ctx, name)
-	var mappedPath, description, fsConfig sql.NullString
+	var mappedPath, description, fsConfig, lowerFolder sql.NullString
 	err = row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &lowerFolder)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return folder, util.NewRecordNotFoundError(err.Error())
@@ -1449,6 +1821,9 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	if description.Valid {
 		folder.Description = description.String
 	}
+	if lowerFolder.Valid {
+		folder.LowerFolder = lowerFolder.String
+	}
 	if fsConfig.Valid {
 		var fs vfs.Filesystem
 		err = json.Unmarshal([]byte(fsConfig.String), &fs)
@@ -1519,7 +1894,7 @@ func sqlCommonAddFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) erro
 	}
 	defer stmt.Close()
 	_, err = stmt.ExecContext(ctx, folder.MappedPath, folder.UsedQuotaSize, folder.UsedQuotaFiles,
-		folder.LastQuotaUpdate, folder.Name, folder.Description, string(fsConfig))
+		folder.LastQuotaUpdate, folder.Name, folder.Description, string(fsConfig), folder.LowerFolder)
 	return err
 }
 
@@ -1541,7 +1916,8 @@ func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) e
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.ExecContext(ctx, folder.MappedPath, folder.Description, string(fsConfig), folder.Name)
+	_, err = stmt.ExecContext(ctx, folder.MappedPath, folder.Description, string(fsConfig),
+		folder.LowerFolder, folder.Name)
 	return err
 }
 
@@ -1577,9 +1953,9 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 	defer rows.Close()
 	for rows.Next() {
 		var folder vfs.BaseVirtualFolder
-		var mappedPath, description, fsConfig sql.NullString
+		var mappedPath, description, fsConfig, lowerFolder sql.NullString
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &lowerFolder)
 		if err != nil {
 			return folders, err
 		}
@@ -1589,6 +1965,9 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 		if description.Valid {
 			folder.Description = description.String
 		}
+		if lowerFolder.Valid {
+			folder.LowerFolder = lowerFolder.String
+		}
 		if fsConfig.Valid {
 			var fs vfs.Filesystem
 			err = json.Unmarshal([]byte(fsConfig.String), &fs)
@@ -1624,9 +2003,9 @@ func sqlCommonGetFolders(limit, offset int, order string, dbHandle sqlQuerier) (
 	defer rows.Close()
 	for rows.Next() {
 		var folder vfs.BaseVirtualFolder
-		var mappedPath, description, fsConfig sql.NullString
+		var mappedPath, description, fsConfig, lowerFolder sql.NullString
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &lowerFolder)
 		if err != nil {
 			return folders, err
 		}
@@ -1636,6 +2015,9 @@ func sqlCommonGetFolders(limit, offset int, order string, dbHandle sqlQuerier) (
 		if description.Valid {
 			folder.Description = description.String
 		}
+		if lowerFolder.Valid {
+			folder.LowerFolder = lowerFolder.String
+		}
 		if fsConfig.Valid {
 			var fs vfs.Filesystem
 			err = json.Unmarshal([]byte(fsConfig.String), &fs)
@@ -1786,10 +2168,10 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 	for rows.Next() {
 		var folder vfs.VirtualFolder
 		var userID int64
-		var mappedPath, fsConfig, description sql.NullString
+		var mappedPath, fsConfig, description, lowerFolder sql.NullString
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
 			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &userID, &fsConfig,
-			&description)
+			&description, &lowerFolder)
 		if err != nil {
 			return users, err
 		}
@@ -1799,6 +2181,9 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 		if description.Valid {
 			folder.Description = description.String
 		}
+		if lowerFolder.Valid {
+			folder.LowerFolder = lowerFolder.String
+		}
 		if fsConfig.Valid {
 			var fs vfs.Filesystem
 			err = json.Unmarshal([]byte(fsConfig.String), &fs)
@@ -2014,6 +2399,22 @@ func sqlCommonGetDatabaseVersion(dbHandle *sql.DB, showInitWarn bool) (schemaVer
 	return result, err
 }
 
+// sqlCommonCheckSchemaVersion opens a new database handle, reads the schema
+// version and closes the handle, no write query is executed
+func sqlCommonCheckSchemaVersion(driverName, connectionString string) error {
+	dbHandle, err := sql.Open(driverName, connectionString)
+	if err != nil {
+		return err
+	}
+	defer dbHandle.Close()
+
+	dbVersion, err := sqlCommonGetDatabaseVersion(dbHandle, false)
+	if err != nil {
+		return fmt.Errorf("unable to get the database version, did you run the \"initprovider\" command? %w", err)
+	}
+	return checkSchemaVersion(dbVersion, sqlDatabaseVersion)
+}
+
 func sqlCommonUpdateDatabaseVersion(ctx context.Context, dbHandle sqlQuerier, version int) error {
 	q := getUpdateDBVersionQuery()
 	stmt, err := dbHandle.PrepareContext(ctx, q)
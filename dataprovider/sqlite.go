@@ -30,6 +30,9 @@ DROP TABLE IF EXISTS "{{shares}}";
 DROP TABLE IF EXISTS "{{users}}";
 DROP TABLE IF EXISTS "{{defender_events}}";
 DROP TABLE IF EXISTS "{{defender_hosts}}";
+DROP TABLE IF EXISTS "{{dedup_contents}}";
+DROP TABLE IF EXISTS "{{webdav_properties}}";
+DROP TABLE IF EXISTS "{{webdav_locks}}";
 DROP TABLE IF EXISTS "{{schema_version}}";
 `
 	sqliteInitialSQL = `CREATE TABLE "{{schema_version}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "version" integer NOT NULL);
@@ -101,6 +104,29 @@ CREATE INDEX "{{prefix}}defender_events_host_id_idx" ON "{{defender_events}}" ("
 	sqliteV15DownSQL = `DROP TABLE "{{defender_events}}";
 DROP TABLE "{{defender_hosts}}";
 `
+	sqliteV16SQL = `CREATE TABLE "{{dedup_contents}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"hash" varchar(64) NOT NULL UNIQUE, "size" bigint NOT NULL, "ref_count" integer NOT NULL,
+"created_at" bigint NOT NULL, "updated_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}dedup_contents_updated_at_idx" ON "{{dedup_contents}}" ("updated_at");
+`
+	sqliteV16DownSQL = `DROP TABLE "{{dedup_contents}}";`
+	sqliteV17SQL     = `ALTER TABLE "{{folders}}" ADD COLUMN "lower_folder" varchar(255) NULL;`
+	sqliteV17DownSQL = `ALTER TABLE "{{folders}}" DROP COLUMN "lower_folder";`
+	sqliteV18SQL     = `CREATE TABLE "{{webdav_properties}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"path_hash" varchar(64) NOT NULL UNIQUE, "storage" varchar(512) NOT NULL, "path" text NOT NULL,
+"properties" text NOT NULL, "updated_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}webdav_properties_storage_idx" ON "{{webdav_properties}}" ("storage");
+`
+	sqliteV18DownSQL = `DROP TABLE "{{webdav_properties}}";`
+	sqliteV19SQL     = `CREATE TABLE "{{webdav_locks}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+"token" varchar(255) NOT NULL UNIQUE, "path_hash" varchar(64) NOT NULL UNIQUE, "storage" varchar(512) NOT NULL,
+"path" text NOT NULL, "root" text NOT NULL, "username" varchar(255) NOT NULL, "owner" text NOT NULL,
+"zero_depth" integer NOT NULL, "expires_at" bigint NOT NULL,
+"created_at" bigint NOT NULL);
+CREATE INDEX "{{prefix}}webdav_locks_storage_idx" ON "{{webdav_locks}}" ("storage");
+CREATE INDEX "{{prefix}}webdav_locks_expires_at_idx" ON "{{webdav_locks}}" ("expires_at");
+`
+	sqliteV19DownSQL = `DROP TABLE "{{webdav_locks}}";`
 )
 
 // SQLiteProvider auth provider for SQLite database
@@ -113,20 +139,9 @@ func init() {
 }
 
 func initializeSQLiteProvider(basePath string) error {
-	var err error
-	var connectionString string
-
-	if config.ConnectionString == "" {
-		dbPath := config.Name
-		if !util.IsFileInputValid(dbPath) {
-			return fmt.Errorf("invalid database path: %#v", dbPath)
-		}
-		if !filepath.IsAbs(dbPath) {
-			dbPath = filepath.Join(basePath, dbPath)
-		}
-		connectionString = fmt.Sprintf("file:%v?cache=shared&_foreign_keys=1", dbPath)
-	} else {
-		connectionString = config.ConnectionString
+	connectionString, err := getSQLiteConnectionString(basePath, false)
+	if err != nil {
+		return err
 	}
 	dbHandle, err := sql.Open("sqlite3", connectionString)
 	if err == nil {
@@ -140,6 +155,33 @@ func initializeSQLiteProvider(basePath string) error {
 	return err
 }
 
+// getSQLiteConnectionString returns the connection string for the configured
+// database, a read-only connection does not create the database file
+func getSQLiteConnectionString(basePath string, readOnly bool) (string, error) {
+	if config.ConnectionString != "" {
+		return config.ConnectionString, nil
+	}
+	dbPath := config.Name
+	if !util.IsFileInputValid(dbPath) {
+		return "", fmt.Errorf("invalid database path: %#v", dbPath)
+	}
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(basePath, dbPath)
+	}
+	if readOnly {
+		return fmt.Sprintf("file:%v?mode=ro&_foreign_keys=1", dbPath), nil
+	}
+	return fmt.Sprintf("file:%v?cache=shared&_foreign_keys=1", dbPath), nil
+}
+
+func checkSQLiteSchemaVersion(basePath string) error {
+	connectionString, err := getSQLiteConnectionString(basePath, true)
+	if err != nil {
+		return err
+	}
+	return sqlCommonCheckSchemaVersion("sqlite3", connectionString)
+}
+
 func (p *SQLiteProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
@@ -152,8 +194,8 @@ func (p *SQLiteProvider) validateUserAndTLSCert(username, protocol string, tlsCe
 	return sqlCommonValidateUserAndTLSCertificate(username, protocol, tlsCert, p.dbHandle)
 }
 
-func (p *SQLiteProvider) validateUserAndPubKey(username string, publicKey []byte) (User, string, error) {
-	return sqlCommonValidateUserAndPubKey(username, publicKey, p.dbHandle)
+func (p *SQLiteProvider) validateUserAndPubKey(username string, publicKey []byte, isSSHCert bool) (User, string, error) {
+	return sqlCommonValidateUserAndPubKey(username, publicKey, isSSHCert, p.dbHandle)
 }
 
 func (p *SQLiteProvider) updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error {
@@ -355,6 +397,66 @@ func (p *SQLiteProvider) cleanupDefender(from int64) error {
 	return sqlCommonDefenderCleanup(from, p.dbHandle)
 }
 
+func (p *SQLiteProvider) updateDedupContent(hash string, size int64, references int) error {
+	return sqlCommonUpdateDedupContent(hash, size, references, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteDedupContent(hash string) error {
+	return sqlCommonDeleteDedupContent(hash, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupDedupContents(before int64) error {
+	return sqlCommonCleanupDedupContents(before, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDedupStats() (DedupStats, error) {
+	return sqlCommonGetDedupStats(p.dbHandle)
+}
+
+func (p *SQLiteProvider) getWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	return sqlCommonGetWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *SQLiteProvider) setWebDAVProperties(props *WebDAVProperties) error {
+	return sqlCommonSetWebDAVProperties(props, p.dbHandle)
+}
+
+func (p *SQLiteProvider) renameWebDAVProperties(storage, source, target string) error {
+	return sqlCommonRenameWebDAVProperties(storage, source, target, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteWebDAVProperties(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVProperties(storage, fsPath, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addWebDAVLock(lock *WebDAVLock) error {
+	return sqlCommonAddWebDAVLock(lock, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getWebDAVLock(token string) (WebDAVLock, error) {
+	return sqlCommonGetWebDAVLock(token, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	return sqlCommonGetWebDAVLocks(storage, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateWebDAVLockExpiration(token string, expiresAt int64) error {
+	return sqlCommonUpdateWebDAVLockExpiration(token, expiresAt, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteWebDAVLock(token string) error {
+	return sqlCommonDeleteWebDAVLock(token, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteWebDAVLocks(storage, fsPath string) error {
+	return sqlCommonDeleteWebDAVLocks(storage, fsPath, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupWebDAVLocks(before int64) error {
+	return sqlCommonCleanupWebDAVLocks(before, p.dbHandle)
+}
+
 func (p *SQLiteProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -408,6 +510,14 @@ func (p *SQLiteProvider) migrateDatabase() error {
 		return updateSQLiteDatabaseFromV13(p.dbHandle)
 	case version == 14:
 		return updateSQLiteDatabaseFromV14(p.dbHandle)
+	case version == 15:
+		return updateSQLiteDatabaseFromV15(p.dbHandle)
+	case version == 16:
+		return updateSQLiteDatabaseFromV16(p.dbHandle)
+	case version == 17:
+		return updateSQLiteDatabaseFromV17(p.dbHandle)
+	case version == 18:
+		return updateSQLiteDatabaseFromV18(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelError, "database version %v is newer than the supported one: %v", version,
@@ -430,6 +540,14 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	}
 
 	switch dbVersion.Version {
+	case 19:
+		return downgradeSQLiteDatabaseFromV19(p.dbHandle)
+	case 18:
+		return downgradeSQLiteDatabaseFromV18(p.dbHandle)
+	case 17:
+		return downgradeSQLiteDatabaseFromV17(p.dbHandle)
+	case 16:
+		return downgradeSQLiteDatabaseFromV16(p.dbHandle)
 	case 15:
 		return downgradeSQLiteDatabaseFromV15(p.dbHandle)
 	case 14:
@@ -455,6 +573,9 @@ func (p *SQLiteProvider) resetDatabase() error {
 	sql = strings.ReplaceAll(sql, "{{shares}}", sqlTableShares)
 	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
 	sql = strings.ReplaceAll(sql, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{webdav_locks}}", sqlTableWebDAVLocks)
 	return sqlCommonExecSQLAndUpdateDBVersion(p.dbHandle, []string{sql}, 0)
 }
 
@@ -487,7 +608,63 @@ func updateSQLiteDatabaseFromV13(dbHandle *sql.DB) error {
 }
 
 func updateSQLiteDatabaseFromV14(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom14To15(dbHandle)
+	if err := updateSQLiteDatabaseFrom14To15(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV15(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV15(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom15To16(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV16(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom16To17(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV17(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := updateSQLiteDatabaseFrom17To18(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV18(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV18(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom18To19(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV19(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom19To18(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV18(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV18(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom18To17(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV17(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV17(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom17To16(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV16(dbHandle)
+}
+
+func downgradeSQLiteDatabaseFromV16(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom16To15(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV15(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV15(dbHandle *sql.DB) error {
@@ -540,6 +717,66 @@ func updateSQLiteDatabaseFrom14To15(dbHandle *sql.DB) error {
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
 }
 
+func updateSQLiteDatabaseFrom15To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 15 -> 16")
+	providerLog(logger.LevelInfo, "updating database version: 15 -> 16")
+	sql := strings.ReplaceAll(sqliteV16SQL, "{{dedup_contents}}", sqlTableDedupContents)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
+}
+
+func updateSQLiteDatabaseFrom16To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 16 -> 17")
+	providerLog(logger.LevelInfo, "updating database version: 16 -> 17")
+	sql := strings.ReplaceAll(sqliteV17SQL, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 17)
+}
+
+func updateSQLiteDatabaseFrom17To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 17 -> 18")
+	providerLog(logger.LevelInfo, "updating database version: 17 -> 18")
+	sql := strings.ReplaceAll(sqliteV18SQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 18)
+}
+
+func updateSQLiteDatabaseFrom18To19(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 18 -> 19")
+	providerLog(logger.LevelInfo, "updating database version: 18 -> 19")
+	sql := strings.ReplaceAll(sqliteV19SQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 19)
+}
+
+func downgradeSQLiteDatabaseFrom19To18(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 19 -> 18")
+	providerLog(logger.LevelInfo, "downgrading database version: 19 -> 18")
+	sql := strings.ReplaceAll(sqliteV19DownSQL, "{{webdav_locks}}", sqlTableWebDAVLocks)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 18)
+}
+
+func downgradeSQLiteDatabaseFrom18To17(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 18 -> 17")
+	providerLog(logger.LevelInfo, "downgrading database version: 18 -> 17")
+	sql := strings.ReplaceAll(sqliteV18DownSQL, "{{webdav_properties}}", sqlTableWebDAVProperties)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 17)
+}
+
+func downgradeSQLiteDatabaseFrom17To16(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 17 -> 16")
+	providerLog(logger.LevelInfo, "downgrading database version: 17 -> 16")
+	sql := strings.ReplaceAll(sqliteV17DownSQL, "{{folders}}", sqlTableFolders)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 16)
+}
+
+func downgradeSQLiteDatabaseFrom16To15(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 16 -> 15")
+	providerLog(logger.LevelInfo, "downgrading database version: 16 -> 15")
+	sql := strings.ReplaceAll(sqliteV16DownSQL, "{{dedup_contents}}", sqlTableDedupContents)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 15)
+}
+
 func downgradeSQLiteDatabaseFrom15To14(dbHandle *sql.DB) error {
 	logger.InfoToConsole("downgrading database version: 15 -> 14")
 	providerLog(logger.LevelInfo, "downgrading database version: 15 -> 14")
@@ -16,3 +16,7 @@ func init() {
 func initializeSQLiteProvider(basePath string) error {
 	return errors.New("SQLite disabled at build time")
 }
+
+func checkSQLiteSchemaVersion(basePath string) error {
+	return errors.New("SQLite disabled at build time")
+}
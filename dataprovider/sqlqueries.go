@@ -12,7 +12,7 @@ const (
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,used_quota_size," +
 		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem," +
 		"additional_info,description,email,created_at,updated_at"
-	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
+	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,lower_folder"
 	selectAdminFields  = "id,username,password,status,email,permissions,filters,additional_info,description,created_at,updated_at,last_login"
 	selectAPIKeyFields = "key_id,name,api_key,scope,created_at,updated_at,last_use_at,expires_at,description,user_id,admin_id"
 	selectShareFields  = "s.share_id,s.name,s.description,s.scope,s.paths,u.username,s.created_at,s.updated_at,s.last_use_at," +
@@ -104,6 +104,102 @@ func getDefenderEventsCleanupQuery() string {
 	return fmt.Sprintf(`DELETE FROM %v WHERE date_time < %v`, sqlTableDefenderEvents, sqlPlaceholders[0])
 }
 
+func getUpdateDedupContentQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("INSERT INTO %v (`hash`,`size`,`ref_count`,`created_at`,`updated_at`) VALUES (%v,%v,%v,%v,%v) "+
+			"ON DUPLICATE KEY UPDATE `size`=VALUES(`size`),`ref_count`=VALUES(`ref_count`),`updated_at`=VALUES(`updated_at`)",
+			sqlTableDedupContents, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+	}
+	return fmt.Sprintf(`INSERT INTO %v (hash,size,ref_count,created_at,updated_at) VALUES (%v,%v,%v,%v,%v) ON CONFLICT (hash) `+
+		`DO UPDATE SET size = EXCLUDED.size, ref_count = EXCLUDED.ref_count, updated_at = EXCLUDED.updated_at`,
+		sqlTableDedupContents, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getDeleteDedupContentQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE hash = %v`, sqlTableDedupContents, sqlPlaceholders[0])
+}
+
+func getDedupContentsCleanupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE updated_at < %v`, sqlTableDedupContents, sqlPlaceholders[0])
+}
+
+func getDedupStatsQuery() string {
+	return fmt.Sprintf(`SELECT COUNT(*),COALESCE(SUM(ref_count),0),COALESCE(SUM(size),0),COALESCE(SUM(size*ref_count),0) FROM %v`,
+		sqlTableDedupContents)
+}
+
+func getWebDAVPropertiesQuery() string {
+	return fmt.Sprintf(`SELECT storage,path,properties,updated_at FROM %v WHERE path_hash = %v`,
+		sqlTableWebDAVProperties, sqlPlaceholders[0])
+}
+
+func getUpdateWebDAVPropertiesQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf("INSERT INTO %v (`path_hash`,`storage`,`path`,`properties`,`updated_at`) VALUES (%v,%v,%v,%v,%v) "+
+			"ON DUPLICATE KEY UPDATE `properties`=VALUES(`properties`),`updated_at`=VALUES(`updated_at`)",
+			sqlTableWebDAVProperties, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+			sqlPlaceholders[4])
+	}
+	return fmt.Sprintf(`INSERT INTO %v (path_hash,storage,path,properties,updated_at) VALUES (%v,%v,%v,%v,%v) `+
+		`ON CONFLICT (path_hash) DO UPDATE SET properties = EXCLUDED.properties, updated_at = EXCLUDED.updated_at`,
+		sqlTableWebDAVProperties, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
+		sqlPlaceholders[4])
+}
+
+// the tree queries match the specified path and any path inside it
+func getWebDAVPropertiesTreeQuery() string {
+	return fmt.Sprintf(`SELECT storage,path,properties,updated_at FROM %v WHERE storage = %v AND (path = %v OR SUBSTR(path,1,%v) = %v)`,
+		sqlTableWebDAVProperties, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getDeleteWebDAVPropertiesTreeQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE storage = %v AND (path = %v OR SUBSTR(path,1,%v) = %v)`,
+		sqlTableWebDAVProperties, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+const selectWebDAVLockFields = "token,storage,path,root,username,owner,zero_depth,expires_at,created_at"
+
+func getAddWebDAVLockQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (token,path_hash,storage,path,root,username,owner,zero_depth,expires_at,created_at) `+
+		`VALUES (%v,%v,%v,%v,%v,%v,%v,%v,%v,%v)`, sqlTableWebDAVLocks, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9])
+}
+
+func getDeleteExpiredWebDAVLockQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE path_hash = %v AND expires_at > 0 AND expires_at <= %v`,
+		sqlTableWebDAVLocks, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getWebDAVLockQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE token = %v`, selectWebDAVLockFields, sqlTableWebDAVLocks,
+		sqlPlaceholders[0])
+}
+
+func getWebDAVLocksQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE storage = %v`, selectWebDAVLockFields, sqlTableWebDAVLocks,
+		sqlPlaceholders[0])
+}
+
+func getUpdateWebDAVLockExpirationQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET expires_at = %v WHERE token = %v`, sqlTableWebDAVLocks, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
+func getDeleteWebDAVLockQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE token = %v`, sqlTableWebDAVLocks, sqlPlaceholders[0])
+}
+
+func getDeleteWebDAVLocksTreeQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE storage = %v AND (path = %v OR SUBSTR(path,1,%v) = %v)`,
+		sqlTableWebDAVLocks, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getWebDAVLocksCleanupQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE expires_at > 0 AND expires_at < %v`, sqlTableWebDAVLocks,
+		sqlPlaceholders[0])
+}
+
 func getAdminByUsernameQuery() string {
 	return fmt.Sprintf(`SELECT %v FROM %v WHERE username = %v`, selectAdminFields, sqlTableAdmins, sqlPlaceholders[0])
 }
@@ -343,14 +439,14 @@ func checkFolderNameQuery() string {
 }
 
 func getAddFolderQuery() string {
-	return fmt.Sprintf(`INSERT INTO %v (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem)
-		VALUES (%v,%v,%v,%v,%v,%v,%v)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+	return fmt.Sprintf(`INSERT INTO %v (path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,
+		lower_folder) VALUES (%v,%v,%v,%v,%v,%v,%v,%v)`, sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
 }
 
 func getUpdateFolderQuery() string {
-	return fmt.Sprintf(`UPDATE %v SET path=%v,description=%v,filesystem=%v WHERE name = %v`, sqlTableFolders, sqlPlaceholders[0],
-		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %v SET path=%v,description=%v,filesystem=%v,lower_folder=%v WHERE name = %v`, sqlTableFolders,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
 }
 
 func getDeleteFolderQuery() string {
@@ -401,7 +497,7 @@ func getRelatedFoldersForUsersQuery(users []User) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description FROM %v f INNER JOIN %v fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description,f.lower_folder FROM %v f INNER JOIN %v fm ON f.id = fm.folder_id WHERE
 		fm.user_id IN %v ORDER BY fm.user_id`, sqlTableFolders, sqlTableFoldersMapping, sb.String())
 }
 
@@ -0,0 +1,54 @@
+package dataprovider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/drakkan/sftpgo/v2/tracing"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+// startLoginSpan starts the root span for a login attempt
+func startLoginSpan(username, ip, protocol, loginMethod string) (context.Context, *tracing.Span) {
+	return tracing.StartSpan(context.Background(), "login",
+		tracing.String("sftpgo.username", username),
+		tracing.String("sftpgo.ip", ip),
+		tracing.String("sftpgo.protocol", protocol),
+		tracing.String("sftpgo.login_method", loginMethod))
+}
+
+// startHookSpan starts a span for an authentication hook, hook is the configured
+// command or URL. URLs are not added since they could contain credentials
+func startHookSpan(ctx context.Context, name, hook string) (context.Context, *tracing.Span) {
+	kind := "program"
+	if hook == "" {
+		kind = "plugin"
+	} else if strings.HasPrefix(hook, "http") {
+		kind = "http"
+	}
+	return tracing.StartSpan(ctx, "hook "+name, tracing.String("sftpgo.hook.kind", kind))
+}
+
+// endProviderSpan completes a span started for a data provider query,
+// not found errors are expected and they are not recorded as errors
+func endProviderSpan(span *tracing.Span, err error) {
+	if _, ok := err.(*util.RecordNotFoundError); ok {
+		span.SetAttributes(tracing.Bool("sftpgo.not_found", true))
+		err = nil
+	}
+	span.End(err)
+}
+
+func startProviderSpan(ctx context.Context, operation, username string) *tracing.Span {
+	_, span := tracing.StartSpan(ctx, "dataprovider "+operation,
+		tracing.String("db.system", config.Driver),
+		tracing.String("sftpgo.username", username))
+	return span
+}
+
+func userExistsWithContext(ctx context.Context, username string) (User, error) {
+	span := startProviderSpan(ctx, "user_exists", username)
+	user, err := provider.userExists(username)
+	endProviderSpan(span, err)
+	return user, err
+}
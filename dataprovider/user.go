@@ -2,7 +2,9 @@ package dataprovider
 
 import (
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,6 +18,7 @@ import (
 	"time"
 
 	"github.com/sftpgo/sdk"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/drakkan/sftpgo/v2/kms"
 	"github.com/drakkan/sftpgo/v2/logger"
@@ -59,6 +62,10 @@ const (
 	PermChown = "chown"
 	// changing file or directory access and modification time is allowed
 	PermChtimes = "chtimes"
+	// cloning and fetching the git repositories is allowed
+	PermGitRead = "git_read"
+	// pushing to the git repositories, and creating new ones, is allowed
+	PermGitPush = "git_push"
 )
 
 // Available login methods
@@ -73,6 +80,20 @@ const (
 	LoginMethodTLSCertificateAndPwd   = "TLSCertificate+password"
 )
 
+// TLS certificate attributes that can be used to map a client certificate to
+// a user in addition to the ones defined in the SDK
+const (
+	// one of the email addresses in the subject alternative names must match the username
+	TLSUsernameSANEmail sdk.TLSUsername = "SANEmail"
+	// one of the URIs in the subject alternative names must match the username
+	TLSUsernameSANURI sdk.TLSUsername = "SANURI"
+	// the subject serial number must match the username
+	TLSUsernameSerialNumber sdk.TLSUsername = "SerialNumber"
+	// the certificate SHA-256 fingerprint must be one of the fingerprints
+	// configured for the user, the username is not read from the certificate
+	TLSUsernameFingerprint sdk.TLSUsername = "Fingerprint"
+)
+
 var (
 	errNoMatchingVirtualFolder = errors.New("no matching virtual folder found")
 	permsRenameAny             = []string{PermRename, PermRenameDirs, PermRenameFiles}
@@ -111,6 +132,17 @@ type UserFilters struct {
 	// Each code can only be used once, you should use these codes to login and disable or
 	// reset 2FA for your account
 	RecoveryCodes []RecoveryCode `json:"recovery_codes,omitempty"`
+	// SHA-256 fingerprints, as hex strings, of the TLS client certificates
+	// allowed to login if the TLS username is set to "Fingerprint"
+	TLSCertFingerprints []string `json:"tls_cert_fingerprints,omitempty"`
+	// Shell patterns matched against the principals of SSH certificates signed
+	// by a trusted CA. A certificate with a matching principal allows to login
+	// as this user even if it is not listed within the user's public keys
+	SSHCertPrincipals []string `json:"ssh_cert_principals,omitempty"`
+	// Label used for this user in the metrics labeled by user, if they are
+	// configured to use the metrics label instead of the username.
+	// Multiple users can share the same label, for example to group them by tenant
+	MetricsLabel string `json:"metrics_label,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -138,6 +170,23 @@ func (u *User) GetFilesystem(connectionID string) (fs vfs.Fs, err error) {
 }
 
 func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
+	fs, err = u.getStorageFs(connectionID)
+	if err != nil {
+		return fs, err
+	}
+	if u.FsConfig.Encryption.Enabled {
+		fs, err = vfs.NewEncryptedFs(fs, "", u.FsConfig.Encryption)
+		if err != nil {
+			return fs, err
+		}
+	}
+	if !u.FsConfig.Compression.Enabled {
+		return fs, nil
+	}
+	return vfs.NewCompressedFs(fs, "", u.FsConfig.Compression)
+}
+
+func (u *User) getStorageFs(connectionID string) (fs vfs.Fs, err error) {
 	switch u.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
 		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)
@@ -342,6 +391,92 @@ func (u *User) IsTLSUsernameVerificationEnabled() bool {
 	return false
 }
 
+// CheckTLSCertificate returns an error if the given TLS certificate does not
+// identify this user based on the configured TLS username
+func (u *User) CheckTLSCertificate(tlsCert *x509.Certificate) error {
+	switch u.Filters.TLSUsername {
+	case sdk.TLSUsernameCN:
+		if u.Username == tlsCert.Subject.CommonName {
+			return nil
+		}
+		return fmt.Errorf("CN %#v does not match username %#v", tlsCert.Subject.CommonName, u.Username)
+	case TLSUsernameFingerprint:
+		fingerprint := GetTLSCertificateFingerprint(tlsCert)
+		if util.IsStringInSlice(fingerprint, u.Filters.TLSCertFingerprints) {
+			return nil
+		}
+		return fmt.Errorf("certificate fingerprint %#v is not allowed for user %#v", fingerprint, u.Username)
+	case TLSUsernameSANEmail, TLSUsernameSANURI, TLSUsernameSerialNumber:
+		values := GetTLSCertificateUsernames(tlsCert, u.Filters.TLSUsername)
+		if util.IsStringInSlice(u.Username, values) {
+			return nil
+		}
+		return fmt.Errorf("%v %+v does not match username %#v", u.Filters.TLSUsername, values, u.Username)
+	default:
+		return errors.New("TLS certificate is not valid")
+	}
+}
+
+// IsSSHCertPrincipalMappingEnabled returns true if SSH certificates signed by a
+// trusted CA can be used to login based on their principals
+func (u *User) IsSSHCertPrincipalMappingEnabled() bool {
+	return len(u.Filters.SSHCertPrincipals) > 0
+}
+
+// CheckSSHCertPrincipals returns an error if none of the principals of the given
+// SSH certificate is the username or matches the configured principal patterns
+func (u *User) CheckSSHCertPrincipals(cert *ssh.Certificate) error {
+	if util.IsStringInSlice(u.Username, cert.ValidPrincipals) {
+		return nil
+	}
+	for _, pattern := range u.Filters.SSHCertPrincipals {
+		for _, principal := range cert.ValidPrincipals {
+			if matched, err := path.Match(pattern, principal); err == nil && matched {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate principals %v are not allowed for user %#v", cert.ValidPrincipals, u.Username)
+}
+
+// GetTLSCertificateUsernames returns the values of the specified attribute
+// that can be used as username for the given TLS certificate
+func GetTLSCertificateUsernames(tlsCert *x509.Certificate, attribute sdk.TLSUsername) []string {
+	var result []string
+
+	switch attribute {
+	case sdk.TLSUsernameCN:
+		result = append(result, tlsCert.Subject.CommonName)
+	case TLSUsernameSANEmail:
+		result = append(result, tlsCert.EmailAddresses...)
+	case TLSUsernameSANURI:
+		for _, u := range tlsCert.URIs {
+			result = append(result, u.String())
+		}
+	case TLSUsernameSerialNumber:
+		result = append(result, tlsCert.Subject.SerialNumber)
+	}
+	return util.RemoveDuplicates(result)
+}
+
+// GetUsernameFromTLSCertificate returns the username to use for the given TLS
+// certificate, the first value for the specified attribute is returned
+func GetUsernameFromTLSCertificate(tlsCert *x509.Certificate, attribute sdk.TLSUsername) string {
+	for _, username := range GetTLSCertificateUsernames(tlsCert, attribute) {
+		if username != "" {
+			return username
+		}
+	}
+	return ""
+}
+
+// GetTLSCertificateFingerprint returns the SHA-256 fingerprint, as lowercase
+// hex string, for the given TLS certificate
+func GetTLSCertificateFingerprint(tlsCert *x509.Certificate) string {
+	sum := sha256.Sum256(tlsCert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
 // SetEmptySecrets sets to empty any user secret
 func (u *User) SetEmptySecrets() {
 	u.FsConfig.SetEmptySecrets()
@@ -435,19 +570,18 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 			if fs, ok := u.fsCache[folder.VirtualPath]; ok {
 				return fs, nil
 			}
-			forbiddenSelfUsers := []string{u.Username}
-			if folder.FsConfig.Provider == sdk.SFTPFilesystemProvider {
-				forbiddens, err := u.getForbiddenSFTPSelfUsers(folder.FsConfig.SFTPConfig.Username)
+			fs, err := u.getFolderFilesystem(&folder, connectionID)
+			if err != nil {
+				return nil, err
+			}
+			if folder.LowerFolder != "" {
+				fs, err = u.getOverlayFilesystem(&folder, fs, connectionID)
 				if err != nil {
 					return nil, err
 				}
-				forbiddenSelfUsers = append(forbiddenSelfUsers, forbiddens...)
-			}
-			fs, err := folder.GetFilesystem(connectionID, forbiddenSelfUsers)
-			if err == nil {
-				u.fsCache[folder.VirtualPath] = fs
 			}
-			return fs, err
+			u.fsCache[folder.VirtualPath] = fs
+			return fs, nil
 		}
 	}
 
@@ -458,6 +592,45 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 	return u.GetFilesystem(connectionID)
 }
 
+func (u *User) getFolderFilesystem(folder *vfs.VirtualFolder, connectionID string) (vfs.Fs, error) {
+	forbiddenSelfUsers := []string{u.Username}
+	if folder.FsConfig.Provider == sdk.SFTPFilesystemProvider {
+		forbiddens, err := u.getForbiddenSFTPSelfUsers(folder.FsConfig.SFTPConfig.Username)
+		if err != nil {
+			return nil, err
+		}
+		forbiddenSelfUsers = append(forbiddenSelfUsers, forbiddens...)
+	}
+	return folder.GetFilesystem(connectionID, forbiddenSelfUsers)
+}
+
+// getOverlayFilesystem returns an overlay filesystem using the specified upper
+// filesystem and the configured lower folder, mounted on the same virtual path
+func (u *User) getOverlayFilesystem(folder *vfs.VirtualFolder, upper vfs.Fs, connectionID string) (vfs.Fs, error) {
+	lowerFolder, err := provider.getFolderByName(folder.LowerFolder)
+	if err != nil {
+		upper.Close()
+		providerLog(logger.LevelError, "unable to get lower folder %#v for folder %#v: %v", folder.LowerFolder,
+			folder.Name, err)
+		return nil, fmt.Errorf("unable to get the lower folder for folder %#v: %w", folder.Name, err)
+	}
+	if lowerFolder.LowerFolder != "" {
+		upper.Close()
+		return nil, fmt.Errorf("the lower folder %#v for folder %#v is an overlay folder too, nested overlays are not supported",
+			lowerFolder.Name, folder.Name)
+	}
+	lower := vfs.VirtualFolder{
+		BaseVirtualFolder: lowerFolder,
+		VirtualPath:       folder.VirtualPath,
+	}
+	lowerFs, err := u.getFolderFilesystem(&lower, connectionID)
+	if err != nil {
+		upper.Close()
+		return nil, err
+	}
+	return vfs.NewOverlayFs(upper, lowerFs, folder.VirtualPath), nil
+}
+
 // GetVirtualFolderForPath returns the virtual folder containing the specified virtual path.
 // If the path is not inside a virtual folder an error is returned
 func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, error) {
@@ -1169,6 +1342,18 @@ func (u *User) GetDeniedIPAsString() string {
 	return strings.Join(u.Filters.DeniedIP, ",")
 }
 
+// GetTLSCertFingerprintsAsString returns the allowed TLS certificate fingerprints
+// as comma separated string
+func (u *User) GetTLSCertFingerprintsAsString() string {
+	return strings.Join(u.Filters.TLSCertFingerprints, ",")
+}
+
+// GetSSHCertPrincipalsAsString returns the SSH certificate principal patterns
+// as comma separated string
+func (u *User) GetSSHCertPrincipalsAsString() string {
+	return strings.Join(u.Filters.SSHCertPrincipals, ",")
+}
+
 // CountUnusedRecoveryCodes returns the number of unused recovery codes
 func (u *User) CountUnusedRecoveryCodes() int {
 	unused := 0
@@ -1210,6 +1395,11 @@ func (u *User) getACopy() User {
 	filters := UserFilters{}
 	filters.MaxUploadFileSize = u.Filters.MaxUploadFileSize
 	filters.TLSUsername = u.Filters.TLSUsername
+	filters.TLSCertFingerprints = make([]string, len(u.Filters.TLSCertFingerprints))
+	copy(filters.TLSCertFingerprints, u.Filters.TLSCertFingerprints)
+	filters.SSHCertPrincipals = make([]string, len(u.Filters.SSHCertPrincipals))
+	copy(filters.SSHCertPrincipals, u.Filters.SSHCertPrincipals)
+	filters.MetricsLabel = u.Filters.MetricsLabel
 	filters.UserType = u.Filters.UserType
 	filters.TOTPConfig.Enabled = u.Filters.TOTPConfig.Enabled
 	filters.TOTPConfig.ConfigName = u.Filters.TOTPConfig.ConfigName
@@ -0,0 +1,83 @@
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+// WebDAVLock defines a WebDAV write lock on a path within a storage
+type WebDAVLock struct {
+	// lock token, an absolute URI
+	Token string `json:"token"`
+	// storage identifier, paths are unique within a storage
+	Storage string `json:"storage"`
+	// filesystem path
+	Path string `json:"path"`
+	// virtual path used to create the lock
+	Root string `json:"root"`
+	// username of the lock owner, only the owner can use the lock token
+	Username string `json:"username"`
+	// raw XML for the lock owner, if any
+	Owner string `json:"owner,omitempty"`
+	// true if the lock only applies to Path and not to its descendants
+	ZeroDepth bool `json:"zero_depth"`
+	// expiration as unix timestamp in milliseconds, 0 means no expiration
+	ExpiresAt int64 `json:"expires_at"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+}
+
+// IsExpired returns true if the lock is expired at the specified time
+func (l *WebDAVLock) IsExpired(now time.Time) bool {
+	return l.ExpiresAt > 0 && l.ExpiresAt <= util.GetTimeAsMsSinceEpoch(now)
+}
+
+func (l *WebDAVLock) getPathHash() string {
+	return getWebDAVPropertiesPathHash(l.Storage, l.Path)
+}
+
+func (l *WebDAVLock) getKey() string {
+	return getWebDAVPropertiesKey(l.Storage, l.Path)
+}
+
+// AddWebDAVLock stores a new WebDAV lock. An expired lock for the same path,
+// if any, is replaced. An error is returned if the path is already locked
+func AddWebDAVLock(lock *WebDAVLock) error {
+	lock.CreatedAt = util.GetTimeAsMsSinceEpoch(time.Now())
+	return provider.addWebDAVLock(lock)
+}
+
+// GetWebDAVLock returns the WebDAV lock with the specified token.
+// A RecordNotFoundError is returned if the lock does not exist
+func GetWebDAVLock(token string) (WebDAVLock, error) {
+	return provider.getWebDAVLock(token)
+}
+
+// GetWebDAVLocks returns the WebDAV locks for the specified storage,
+// expired locks not yet removed are included
+func GetWebDAVLocks(storage string) ([]WebDAVLock, error) {
+	return provider.getWebDAVLocks(storage)
+}
+
+// UpdateWebDAVLockExpiration updates the expiration for the WebDAV lock
+// with the specified token
+func UpdateWebDAVLockExpiration(token string, expiresAt int64) error {
+	return provider.updateWebDAVLockExpiration(token, expiresAt)
+}
+
+// DeleteWebDAVLock removes the WebDAV lock with the specified token
+func DeleteWebDAVLock(token string) error {
+	return provider.deleteWebDAVLock(token)
+}
+
+// DeleteWebDAVLocks removes the WebDAV locks for the specified path and
+// for any path inside it
+func DeleteWebDAVLocks(storage, fsPath string) error {
+	return provider.deleteWebDAVLocks(storage, fsPath)
+}
+
+// CleanupWebDAVLocks removes the WebDAV locks expired before the specified time
+func CleanupWebDAVLocks(before time.Time) error {
+	return provider.cleanupWebDAVLocks(util.GetTimeAsMsSinceEpoch(before))
+}
@@ -0,0 +1,79 @@
+package dataprovider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// WebDAVProperty defines a WebDAV dead property
+type WebDAVProperty struct {
+	// XML namespace and local name
+	Space string `json:"space"`
+	Local string `json:"local"`
+	// value of the xml:lang attribute, if any
+	Lang string `json:"lang,omitempty"`
+	// raw XML contents
+	InnerXML string `json:"inner_xml"`
+}
+
+// WebDAVProperties defines the WebDAV dead properties for a path within a storage
+type WebDAVProperties struct {
+	// storage identifier, paths are unique within a storage
+	Storage string `json:"storage"`
+	// filesystem path
+	Path       string           `json:"path"`
+	Properties []WebDAVProperty `json:"properties"`
+	// last update as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+func (p *WebDAVProperties) getKey() string {
+	return getWebDAVPropertiesKey(p.Storage, p.Path)
+}
+
+func (p *WebDAVProperties) getPathHash() string {
+	return getWebDAVPropertiesPathHash(p.Storage, p.Path)
+}
+
+func getWebDAVPropertiesKey(storage, fsPath string) string {
+	return storage + "|" + fsPath
+}
+
+func getWebDAVPropertiesPathHash(storage, fsPath string) string {
+	h := sha256.Sum256([]byte(getWebDAVPropertiesKey(storage, fsPath)))
+	return hex.EncodeToString(h[:])
+}
+
+// isWebDAVPropertiesPathMatch returns true if fsPath is equal to or inside the specified path
+func isWebDAVPropertiesPathMatch(fsPath, basePath string) bool {
+	return fsPath == basePath || strings.HasPrefix(fsPath, strings.TrimSuffix(basePath, "/")+"/")
+}
+
+// GetWebDAVProperties returns the dead properties for the specified storage and path.
+// A RecordNotFoundError is returned if no property is stored
+func GetWebDAVProperties(storage, fsPath string) (WebDAVProperties, error) {
+	return provider.getWebDAVProperties(storage, fsPath)
+}
+
+// SetWebDAVProperties stores the specified dead properties replacing the existing ones.
+// Storing an empty property list removes the stored properties
+func SetWebDAVProperties(props *WebDAVProperties) error {
+	if len(props.Properties) == 0 {
+		return provider.deleteWebDAVProperties(props.Storage, props.Path)
+	}
+	return provider.setWebDAVProperties(props)
+}
+
+// RenameWebDAVProperties moves the dead properties for the source path, and for
+// any path inside it, to the target path. Any existing property for the target
+// paths is replaced
+func RenameWebDAVProperties(storage, source, target string) error {
+	return provider.renameWebDAVProperties(storage, source, target)
+}
+
+// DeleteWebDAVProperties removes the dead properties for the specified path and
+// for any path inside it
+func DeleteWebDAVProperties(storage, fsPath string) error {
+	return provider.deleteWebDAVProperties(storage, fsPath)
+}
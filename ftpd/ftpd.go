@@ -2,11 +2,13 @@
 package ftpd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
@@ -19,11 +21,46 @@ const (
 	logSender = "ftpd"
 )
 
+const (
+	mlsxFactUnixMode  = "unix.mode"
+	mlsxFactUnixOwner = "unix.owner"
+	mlsxFactUnixGroup = "unix.group"
+)
+
 var (
-	certMgr       *common.CertManager
-	serviceStatus ServiceStatus
+	certMgr             *common.CertManager
+	serviceStatus       ServiceStatus
+	servers             common.ServerGroup
+	runningServer       runningServerConfig
+	builtinSiteCommands = []string{"CHMOD", "CHOWN", "SYMLINK", "MKDIR", "RMDIR"}
 )
 
+// runningServerConfig stores the configuration used to start the server, it
+// is required to start the new bindings on configuration reload
+type runningServerConfig struct {
+	sync.RWMutex
+	config    *Configuration
+	configDir string
+	// the ID to assign to the next started binding
+	nextID int
+}
+
+func (r *runningServerConfig) set(config *Configuration, configDir string, nextID int) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+	r.configDir = configDir
+	r.nextID = nextID
+}
+
+func (r *runningServerConfig) get() (*Configuration, string, int) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.config, r.configDir, r.nextID
+}
+
 // PassiveIPOverride defines an exception for the configured passive IP
 type PassiveIPOverride struct {
 	Networks []string `json:"networks" mapstructure:"networks"`
@@ -32,6 +69,16 @@ type PassiveIPOverride struct {
 	parsedNetworks []func(net.IP) bool
 }
 
+// SiteCommand defines a custom SITE subcommand handled by an external hook
+type SiteCommand struct {
+	// Subcommand name, for example "PROCESS" to handle "SITE PROCESS <file>".
+	// The built-in subcommands cannot be redefined
+	Name string `json:"name" mapstructure:"name"`
+	// Absolute path to an external program or an HTTP URL. The hook output is
+	// sent to the client as the SITE reply
+	Hook string `json:"hook" mapstructure:"hook"`
+}
+
 // Binding defines the configuration for a network listener
 type Binding struct {
 	// The address to listen on. A blank value means listen on all available network interfaces.
@@ -74,6 +121,12 @@ type Binding struct {
 	// Please note that disabling the security checks you will make the FTP service vulnerable to bounce attacks
 	// on active data connections, so change the default value only if you are on a trusted/internal network
 	ActiveConnectionsSecurity int `json:"active_connections_security" mapstructure:"active_connections_security"`
+	// TLSSessionReuse defines the TLS session reuse requirements for data connections.
+	// Supported values:
+	// - 0 TLS session reuse is not checked. This is the default
+	// - 1 TLS data connections must resume the TLS session established on the control connection of the same client.
+	// This prevents data connections hijacking, clients must support TLS session resumption
+	TLSSessionReuse int `json:"tls_session_reuse" mapstructure:"tls_session_reuse"`
 	// Debug enables the FTP debug mode. In debug mode, every FTP command will be logged
 	Debug   bool `json:"debug" mapstructure:"debug"`
 	ciphers []uint16
@@ -86,6 +139,10 @@ func (b *Binding) setCiphers() {
 	}
 }
 
+func (b *Binding) isTLSSessionReuseRequired() bool {
+	return b.TLSSessionReuse == 1
+}
+
 func (b *Binding) isMutualTLSEnabled() bool {
 	return b.ClientAuthType == 1 || b.ClientAuthType == 2
 }
@@ -107,6 +164,9 @@ func (b *Binding) checkSecuritySettings() error {
 	if b.ActiveConnectionsSecurity < 0 || b.ActiveConnectionsSecurity > 1 {
 		return fmt.Errorf("invalid active_connections_security: %v", b.ActiveConnectionsSecurity)
 	}
+	if b.TLSSessionReuse < 0 || b.TLSSessionReuse > 1 {
+		return fmt.Errorf("invalid tls_session_reuse: %v", b.TLSSessionReuse)
+	}
 	return nil
 }
 
@@ -169,7 +229,7 @@ func (b *Binding) passiveIPResolver(cc ftpserver.ClientContext) (string, error)
 
 // HasProxy returns true if the proxy protocol is active for this binding
 func (b *Binding) HasProxy() bool {
-	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
+	return b.ApplyProxyConfig && common.GetConfig().ProxyProtocol > 0
 }
 
 // GetTLSDescription returns the TLS mode as string
@@ -229,6 +289,8 @@ type Configuration struct {
 	// Set to true to enable the FTP SITE command.
 	// We support chmod and symlink if SITE support is enabled
 	EnableSite bool `json:"enable_site" mapstructure:"enable_site"`
+	// Custom SITE subcommands handled by external hooks. They require SITE support
+	SiteCommands []SiteCommand `json:"site_commands" mapstructure:"site_commands"`
 	// Set to 1 to enable FTP commands that allow to calculate the hash value of files.
 	// These FTP commands will be enabled: HASH, XCRC, MD5/XMD5, XSHA/XSHA1, XSHA256, XSHA512.
 	// Please keep in mind that to calculate the hash we need to read the whole file, for
@@ -242,6 +304,10 @@ type Configuration struct {
 	CombineSupport int `json:"combine_support" mapstructure:"combine_support"`
 	// Port Range for data connections. Random if not specified
 	PassivePortRange PortRange `json:"passive_port_range" mapstructure:"passive_port_range"`
+	// Additional facts to return in MLSD and MLST responses. Supported facts:
+	// "unix.mode", "unix.owner", "unix.group". Owner and group are only returned
+	// for storage backends that report them, for example the local filesystem
+	MLSxFacts []string `json:"mlsx_facts" mapstructure:"mlsx_facts"`
 }
 
 // ShouldBind returns true if there is at least a valid binding
@@ -255,13 +321,53 @@ func (c *Configuration) ShouldBind() bool {
 	return false
 }
 
+func (c *Configuration) checkMLSxFacts() error {
+	for idx, fact := range c.MLSxFacts {
+		fact = strings.ToLower(strings.TrimSpace(fact))
+		if !util.IsStringInSlice(fact, []string{mlsxFactUnixMode, mlsxFactUnixOwner, mlsxFactUnixGroup}) {
+			return fmt.Errorf("unsupported MLSx fact %#v", c.MLSxFacts[idx])
+		}
+		c.MLSxFacts[idx] = fact
+	}
+	return nil
+}
+
+func (c *Configuration) checkSiteCommands() error {
+	names := make(map[string]bool)
+	for idx := range c.SiteCommands {
+		cmd := &c.SiteCommands[idx]
+		cmd.Name = strings.ToUpper(strings.TrimSpace(cmd.Name))
+		if cmd.Name == "" || strings.Contains(cmd.Name, " ") {
+			return fmt.Errorf("invalid SITE command name %#v", cmd.Name)
+		}
+		if util.IsStringInSlice(cmd.Name, builtinSiteCommands) {
+			return fmt.Errorf("the built-in SITE command %#v cannot be redefined", cmd.Name)
+		}
+		if names[cmd.Name] {
+			return fmt.Errorf("SITE command %#v is duplicated", cmd.Name)
+		}
+		names[cmd.Name] = true
+		if !strings.HasPrefix(cmd.Hook, "http") && !filepath.IsAbs(cmd.Hook) {
+			return fmt.Errorf("invalid hook %#v for SITE command %#v", cmd.Hook, cmd.Name)
+		}
+	}
+	return nil
+}
+
 // Initialize configures and starts the FTP server
 func (c *Configuration) Initialize(configDir string) error {
 	logger.Info(logSender, "", "initializing FTP server with config %+v", *c)
 	if !c.ShouldBind() {
 		return common.ErrNoBinding
 	}
+	if err := c.checkMLSxFacts(); err != nil {
+		return err
+	}
+	if err := c.checkSiteCommands(); err != nil {
+		return err
+	}
 
+	certMgr = nil
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
 	if certificateFile != "" && certificateKeyFile != "" {
@@ -284,30 +390,92 @@ func (c *Configuration) Initialize(configDir string) error {
 		PassivePortRange: c.PassivePortRange,
 	}
 
-	exitChannel := make(chan error, 1)
+	servers.Reset()
+	runningServer.set(c, configDir, len(c.Bindings))
+
+	for idx, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		servers.GoBinding(getBindingStarter(NewServer(c, configDir, binding, idx)))
+		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return servers.Wait()
+}
 
+// ReloadBindings stops the removed bindings and starts the added ones, the
+// other bindings and the established connections are not affected. The
+// other configuration settings must be unchanged
+func ReloadBindings(ctx context.Context, bindings []Binding) error {
+	c, configDir, nextID := runningServer.get()
+	if c == nil {
+		return errors.New("the FTP server is not running")
+	}
+	var removed, added []common.BindingStarter
+	var newBindings []Binding
 	for idx, binding := range c.Bindings {
+		if binding.IsValid() && !isBindingInList(binding, bindings) {
+			removed = append(removed, getBindingStarter(NewServer(c, configDir, binding, idx)))
+		}
+	}
+	for _, binding := range bindings {
 		if !binding.IsValid() {
 			continue
 		}
+		newBindings = append(newBindings, binding)
+		if !isBindingInList(binding, c.Bindings) {
+			added = append(added, getBindingStarter(NewServer(c, configDir, binding, nextID)))
+			nextID++
+		}
+	}
+	if err := servers.ReplaceBindings(ctx, removed, added); err != nil {
+		return err
+	}
+	conf := *c
+	conf.Bindings = bindings
+	runningServer.set(&conf, configDir, nextID)
+	serviceStatus.Bindings = newBindings
+	return nil
+}
 
-		server := NewServer(c, configDir, binding, idx)
+func isBindingInList(binding Binding, bindings []Binding) bool {
+	for _, b := range bindings {
+		if common.IsSameBinding(b, binding) {
+			return true
+		}
+	}
+	return false
+}
 
-		go func(s *Server) {
+func getBindingStarter(s *Server) common.BindingStarter {
+	return common.BindingStarter{
+		Key: s.binding.GetAddress(),
+		Start: func(b *common.ServerBinding) error {
 			ftpLogger := logger.LeveledLogger{Sender: "ftpserverlib"}
 			ftpServer := ftpserver.NewFtpServer(s)
 			ftpServer.Logger = ftpLogger.With("server_id", fmt.Sprintf("FTP_%v", s.ID))
 			logger.Info(logSender, "", "starting FTP serving, binding: %v", s.binding.GetAddress())
 			util.CheckTCP4Port(s.binding.Port)
-			exitChannel <- ftpServer.ListenAndServe()
-		}(server)
-
-		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+			if err := ftpServer.Listen(); err != nil {
+				common.SetBindingStatus(common.ProtocolFTP, s.binding.GetAddress(), err)
+				return err
+			}
+			if err := b.AddStopFunc(ftpServer.Stop); err != nil {
+				return nil
+			}
+			common.SetBindingStatus(common.ProtocolFTP, s.binding.GetAddress(), nil)
+			err := ftpServer.Serve()
+			if b.IsStopped() {
+				common.RemoveBindingStatus(common.ProtocolFTP, s.binding.GetAddress())
+			} else {
+				common.SetBindingStatus(common.ProtocolFTP, s.binding.GetAddress(), err)
+			}
+			return err
+		},
 	}
-
-	serviceStatus.IsActive = true
-
-	return <-exitChannel
 }
 
 // ReloadCertificateMgr reloads the certificate manager
@@ -323,6 +491,12 @@ func GetStatus() ServiceStatus {
 	return serviceStatus
 }
 
+// Stop closes the FTP server listeners, the established connections are not closed.
+// The server can be initialized again after Stop returns
+func Stop(ctx context.Context) error {
+	return servers.Stop(ctx)
+}
+
 func parsePassiveIP(passiveIP string) (string, error) {
 	ip := net.ParseIP(passiveIP)
 	if ip == nil {
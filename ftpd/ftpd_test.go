@@ -1,6 +1,7 @@
 package ftpd_test
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
@@ -46,6 +47,7 @@ const (
 	ftpServerAddr   = "127.0.0.1:2121"
 	sftpServerAddr  = "127.0.0.1:2122"
 	ftpSrvAddrTLS   = "127.0.0.1:2124" // ftp server with implicit tls
+	ftpSrvAddrReuse = "127.0.0.1:2125" // ftp server requiring TLS session reuse
 	defaultUsername = "test_user_ftp"
 	defaultPassword = "test_password"
 	configDir       = ".."
@@ -239,6 +241,7 @@ var (
 	postConnectPath string
 	preDownloadPath string
 	preUploadPath   string
+	siteCmdPath     string
 	logFilePath     string
 	caCrtPath       string
 	caCRLPath       string
@@ -335,6 +338,17 @@ func TestMain(m *testing.M) {
 	ftpdConf.CACertificates = []string{caCrtPath}
 	ftpdConf.CARevocationLists = []string{caCRLPath}
 	ftpdConf.EnableSite = true
+	siteCmdPath = filepath.Join(homeBasePath, "sitecmd.sh")
+	ftpdConf.SiteCommands = []ftpd.SiteCommand{
+		{
+			Name: "process",
+			Hook: siteCmdPath,
+		},
+		{
+			Name: "QUOTA",
+			Hook: "http://127.0.0.1:8079/notfound",
+		},
+	}
 
 	// required to test sftpfs
 	sftpdConf := config.GetSFTPDConfig()
@@ -392,6 +406,11 @@ func TestMain(m *testing.M) {
 			Port:    2124,
 			TLSMode: 2,
 		},
+		{
+			Port:            2125,
+			TLSMode:         1,
+			TLSSessionReuse: 1,
+		},
 	}
 	ftpdConf.CertificateFile = certPath
 	ftpdConf.CertificateKeyFile = keyPath
@@ -411,6 +430,7 @@ func TestMain(m *testing.M) {
 	}()
 
 	waitTCPListening(ftpdConf.Bindings[0].GetAddress())
+	waitTCPListening(ftpdConf.Bindings[1].GetAddress())
 	waitNoConnections()
 
 	exitCode := m.Run()
@@ -421,6 +441,7 @@ func TestMain(m *testing.M) {
 	os.Remove(postConnectPath)
 	os.Remove(preDownloadPath)
 	os.Remove(preUploadPath)
+	os.Remove(siteCmdPath)
 	os.Remove(certPath)
 	os.Remove(keyPath)
 	os.Remove(caCrtPath)
@@ -1230,6 +1251,62 @@ func TestDownloadErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestASCIITransfers(t *testing.T) {
+	u := getTestUser()
+	u.QuotaSize = 6553600
+	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	u = getTestSFTPUser()
+	u.QuotaSize = 6553600
+	sftpUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	for _, user := range []dataprovider.User{localUser, sftpUser} {
+		client, err := getFTPClient(user, true, nil)
+		if assert.NoError(t, err) {
+			code, _, err := client.SendCustomCommand("TYPE A")
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusCommandOK, code)
+			err = client.Stor(testFileName, bytes.NewReader([]byte("line1\r\nline2\r\nline3")))
+			assert.NoError(t, err)
+			content, err := os.ReadFile(filepath.Join(localUser.GetHomeDir(), testFileName))
+			assert.NoError(t, err)
+			assert.Equal(t, "line1\nline2\nline3", string(content))
+			user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, user.UsedQuotaFiles)
+			assert.Equal(t, int64(len(content)), user.UsedQuotaSize)
+			r, err := client.Retr(testFileName)
+			if assert.NoError(t, err) {
+				content, err = io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Equal(t, "line1\r\nline2\r\nline3", string(content))
+				err = r.Close()
+				assert.NoError(t, err)
+			}
+			// SIZE and REST are refused in ASCII mode
+			_, err = client.FileSize(testFileName)
+			assert.Error(t, err)
+			code, _, err = client.SendCustomCommand("TYPE I")
+			assert.NoError(t, err)
+			assert.Equal(t, ftp.StatusCommandOK, code)
+			size, err := client.FileSize(testFileName)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(17), size)
+			err = client.Delete(testFileName)
+			assert.NoError(t, err)
+			err = client.Quit()
+			assert.NoError(t, err)
+		}
+	}
+	_, err = httpdtest.RemoveUser(sftpUser, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(localUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(localUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestUploadErrors(t *testing.T) {
 	u := getTestUser()
 	u.QuotaSize = 65535
@@ -1839,6 +1916,54 @@ func TestRename(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSiteCommandHook(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	err = os.WriteFile(siteCmdPath, getSiteCmdScriptContent(0), os.ModePerm)
+	assert.NoError(t, err)
+
+	siteDir := "sitedir"
+	client, err := getFTPClient(user, false, nil)
+	if assert.NoError(t, err) {
+		err = client.MakeDir(siteDir)
+		assert.NoError(t, err)
+		err = client.ChangeDir(siteDir)
+		assert.NoError(t, err)
+		code, response, err := client.SendCustomCommand(fmt.Sprintf("SITE process %v", testFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusCommandOK, code)
+		assert.Equal(t, fmt.Sprintf("PROCESS %v %v %v 127.0.0.1 FTP\n%v", testFileName, user.Username,
+			path.Join("/", siteDir), filepath.Join(user.GetHomeDir(), siteDir)), response)
+
+		err = os.WriteFile(siteCmdPath, getSiteCmdScriptContent(1), os.ModePerm)
+		assert.NoError(t, err)
+		code, response, err = client.SendCustomCommand("SITE PROCESS")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		assert.Contains(t, response, common.ErrGenericFailure.Error())
+		// unexpected HTTP response code
+		code, _, err = client.SendCustomCommand("SITE QUOTA")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusFileUnavailable, code)
+		code, response, err = client.SendCustomCommand("SITE OTHER")
+		assert.NoError(t, err)
+		assert.Equal(t, ftp.StatusBadCommand, code)
+		assert.Equal(t, "Unknown SITE subcommand: OTHER", response)
+
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestSymlink(t *testing.T) {
 	u := getTestUser()
 	localUser, _, err := httpdtest.AddUser(u, http.StatusCreated)
@@ -2501,6 +2626,74 @@ func TestCombine(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTLSSessionReuse(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+
+	tlsConfig := &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	client, err := getFTPClientWithAddr(ftpSrvAddrReuse, user, tlsConfig)
+	if assert.NoError(t, err) {
+		err = ftpUploadFile(testFilePath, testFileName, testFileSize, client, 0)
+		assert.NoError(t, err)
+		_, err = client.List("/")
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// a TLS session established by another client cannot be resumed on data connections
+	tlsConfig = &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: &pinnedSessionCache{cache: tlsConfig.ClientSessionCache},
+	}
+	client, err = getFTPClientWithAddr(ftpSrvAddrReuse, user, tlsConfig)
+	if assert.NoError(t, err) {
+		_, err = client.List("/")
+		assert.Error(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// without a session cache the TLS session cannot be resumed
+	tlsConfig = &tls.Config{
+		ServerName:         "localhost",
+		InsecureSkipVerify: true, // use this for tests only
+		MinVersion:         tls.VersionTLS12,
+	}
+	client, err = getFTPClientWithAddr(ftpSrvAddrReuse, user, tlsConfig)
+	if assert.NoError(t, err) {
+		_, err = client.List("/")
+		assert.Error(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+	// the session reuse is not required for the default binding
+	client, err = getFTPClient(user, true, tlsConfig)
+	if assert.NoError(t, err) {
+		_, err = client.List("/")
+		assert.NoError(t, err)
+		err = client.Quit()
+		assert.NoError(t, err)
+	}
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestClientCertificateAuthRevokedCert(t *testing.T) {
 	u := getTestUser()
 	u.Username = tlsClient2Username
@@ -2995,6 +3188,19 @@ func getFTPClientImplicitTLS(user dataprovider.User) (*ftp.ServerConn, error) {
 	return client, err
 }
 
+func getFTPClientWithAddr(addr string, user dataprovider.User, tlsConfig *tls.Config) (*ftp.ServerConn, error) {
+	ftpOptions := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second), ftp.DialWithExplicitTLS(tlsConfig)}
+	client, err := ftp.Dial(addr, ftpOptions...)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Login(user.Username, defaultPassword)
+	if err != nil {
+		return nil, err
+	}
+	return client, err
+}
+
 func getFTPClient(user dataprovider.User, useTLS bool, tlsConfig *tls.Config) (*ftp.ServerConn, error) {
 	ftpOptions := []ftp.DialOption{ftp.DialWithTimeout(5 * time.Second)}
 	if useTLS {
@@ -3103,6 +3309,26 @@ func getPreLoginScriptContent(user dataprovider.User, nonJSONResponse bool) []by
 	return content
 }
 
+func getSiteCmdScriptContent(exitCode int) []byte {
+	content := []byte("#!/bin/sh\n\n")
+	content = append(content, []byte("echo \"$SFTPGO_SITE_COMMAND $SFTPGO_SITE_ARGS $SFTPGO_SITE_USERNAME $SFTPGO_SITE_VIRTUAL_PATH $SFTPGO_SITE_IP $SFTPGO_SITE_PROTOCOL\"\n")...)
+	content = append(content, []byte("echo \"$SFTPGO_SITE_PATH\"\n")...)
+	content = append(content, []byte(fmt.Sprintf("exit %v", exitCode))...)
+	return content
+}
+
+// pinnedSessionCache always offers the TLS sessions stored in the wrapped cache
+// and ignores new sessions, it simulates a client reusing the TLS session of another client
+type pinnedSessionCache struct {
+	cache tls.ClientSessionCache
+}
+
+func (c *pinnedSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return c.cache.Get(sessionKey)
+}
+
+func (c *pinnedSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {}
+
 func getExitCodeScriptContent(exitCode int) []byte {
 	content := []byte("#!/bin/sh\n\n")
 	content = append(content, []byte(fmt.Sprintf("exit %v", exitCode))...)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
@@ -28,6 +29,8 @@ var (
 type Connection struct {
 	*common.BaseConnection
 	clientContext ftpserver.ClientContext
+	mlsxFacts     []string
+	siteCommands  []SiteCommand
 }
 
 func (c *Connection) getFTPMode() string {
@@ -280,6 +283,38 @@ func (c *Connection) Symlink(oldname, newname string) error {
 	return c.BaseConnection.CreateSymlink(oldname, newname)
 }
 
+// GetMLSxFacts implements ClientDriverExtensionMLSxFacts interface
+func (c *Connection) GetMLSxFacts(info os.FileInfo) string {
+	var sb strings.Builder
+	for _, fact := range c.mlsxFacts {
+		switch fact {
+		case mlsxFactUnixMode:
+			sb.WriteString(fmt.Sprintf("UNIX.mode=%04o;", info.Mode().Perm()))
+		case mlsxFactUnixOwner:
+			if uid, _, ok := vfs.GetFileOwner(info); ok {
+				sb.WriteString(fmt.Sprintf("UNIX.owner=%d;", uid))
+			}
+		case mlsxFactUnixGroup:
+			if _, gid, ok := vfs.GetFileOwner(info); ok {
+				sb.WriteString(fmt.Sprintf("UNIX.group=%d;", gid))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Site implements ClientDriverExtensionSite interface
+func (c *Connection) Site(cmd, params string) (string, error) {
+	c.UpdateLastActivity()
+
+	for _, siteCmd := range c.siteCommands {
+		if siteCmd.Name == cmd {
+			return common.ExecuteSiteCommandHook(c.BaseConnection, siteCmd.Hook, cmd, params, c.clientContext.Path())
+		}
+	}
+	return "", ftpserver.ErrUnknownSiteCommand
+}
+
 // ReadDir implements ClientDriverExtensionFilelist
 func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 	c.UpdateLastActivity()
@@ -350,7 +385,7 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 	}
 
 	filePath := fsPath
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		filePath = fs.GetAtomicUploadPath(fsPath)
 	}
 
@@ -377,7 +412,8 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 		return nil, fmt.Errorf("%w, no overwrite permission", ftpserver.ErrFileNameNotAllowed)
 	}
 
-	return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, stat.Size(), ftpPath)
+	return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, vfs.GetQuotaSize(stat), !vfs.IsQuotaTracked(stat),
+		ftpPath)
 }
 
 func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, requestPath string) (ftpserver.FileTransfer, error) {
@@ -409,10 +445,12 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, resolvedPath, filePath,
 	return t, nil
 }
 
+// isNewFile is true if the existing file is not included in the quota, for
+// example a file existing only inside the lower layer of an overlay folder
 func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolvedPath, filePath string, fileSize int64,
-	requestPath string) (ftpserver.FileTransfer, error) {
+	isNewFile bool, requestPath string) (ftpserver.FileTransfer, error) {
 	var err error
-	quotaResult := c.HasSpace(false, false, requestPath)
+	quotaResult := c.HasSpace(isNewFile, false, requestPath)
 	if !quotaResult.HasSpace {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, ftpserver.ErrStorageExceeded
@@ -424,6 +462,11 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	// - os.O_WRONLY | os.O_CREATE | os.O_TRUNC if the command is not APPE and REST = 0
 	// so if we don't have O_TRUNC is a resume.
 	isResume := flags&os.O_TRUNC == 0
+	// resuming a file not included in the quota copies it to the writable layer
+	if isNewFile && isResume && !c.HasSpaceForCopyUp(fs, resolvedPath, requestPath) {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, ftpserver.ErrStorageExceeded
+	}
 	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before
 	maxWriteSize, err := c.GetMaxWriteSize(quotaResult, isResume, fileSize, fs.IsUploadResumeSupported())
@@ -436,7 +479,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		return nil, fmt.Errorf("%w, denied by pre-upload action", ftpserver.ErrFileNameNotAllowed)
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -479,7 +522,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
-		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, fs)
+		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, isNewFile, fs)
 	baseTransfer.SetFtpMode(c.getFTPMode())
 	t := newTransfer(baseTransfer, w, nil, 0)
 
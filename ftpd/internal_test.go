@@ -252,6 +252,32 @@ xr5cb9VBRBtB9aOKVfuRhpatAfS2Pzm2Htae9lFn7slGPUmu2hkjDw==
 -----END RSA PRIVATE KEY-----`
 )
 
+type mockControlConn struct {
+	net.Conn
+	remoteIP string
+}
+
+func (c *mockControlConn) RemoteAddr() net.Addr {
+	return &net.IPAddr{IP: net.ParseIP(c.remoteIP)}
+}
+
+func (c *mockControlConn) LocalAddr() net.Addr {
+	return &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+}
+
+func (c *mockControlConn) Close() error {
+	return nil
+}
+
+type mockControlListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func (l *mockControlListener) Accept() (net.Conn, error) {
+	return <-l.conns, nil
+}
+
 type mockFTPClientContext struct {
 	lastDataChannel ftpserver.DataChannel
 	remoteIP        string
@@ -418,6 +444,13 @@ func TestInitialization(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.Contains(t, err.Error(), "invalid active_connections_security")
 	}
+	binding.ActiveConnectionsSecurity = 1
+	binding.TLSSessionReuse = 100
+	server = NewServer(c, configDir, binding, 0)
+	_, err = server.GetSettings()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid tls_session_reuse")
+	}
 	binding = Binding{
 		Port:           2121,
 		ForcePassiveIP: "192.168.1",
@@ -583,6 +616,96 @@ func TestFTPMode(t *testing.T) {
 	assert.Empty(t, connection.getFTPMode())
 }
 
+func TestMLSxFacts(t *testing.T) {
+	c := &Configuration{
+		MLSxFacts: []string{"UNIX.mode", " unix.owner", "unix.group"},
+	}
+	err := c.checkMLSxFacts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{mlsxFactUnixMode, mlsxFactUnixOwner, mlsxFactUnixGroup}, c.MLSxFacts)
+	c.MLSxFacts = append(c.MLSxFacts, "unix.uid")
+	err = c.checkMLSxFacts()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported MLSx fact")
+	}
+
+	testFilePath := filepath.Join(os.TempDir(), "mlsx_facts_file")
+	err = os.WriteFile(testFilePath, []byte("data"), 0640)
+	assert.NoError(t, err)
+	err = os.Chmod(testFilePath, 0640)
+	assert.NoError(t, err)
+	info, err := os.Stat(testFilePath)
+	assert.NoError(t, err)
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolFTP, "", "", dataprovider.User{}),
+	}
+	assert.Empty(t, connection.GetMLSxFacts(info))
+	connection.mlsxFacts = []string{mlsxFactUnixMode}
+	if runtime.GOOS == "windows" {
+		assert.Equal(t, "UNIX.mode=0666;", connection.GetMLSxFacts(info))
+	} else {
+		assert.Equal(t, "UNIX.mode=0640;", connection.GetMLSxFacts(info))
+		connection.mlsxFacts = []string{mlsxFactUnixMode, mlsxFactUnixOwner, mlsxFactUnixGroup}
+		assert.Equal(t, fmt.Sprintf("UNIX.mode=0640;UNIX.owner=%d;UNIX.group=%d;", os.Getuid(), os.Getgid()),
+			connection.GetMLSxFacts(info))
+	}
+	// owner and group are omitted if the storage backend does not report them
+	connection.mlsxFacts = []string{mlsxFactUnixOwner, mlsxFactUnixGroup}
+	assert.Empty(t, connection.GetMLSxFacts(vfs.NewFileInfo("name", false, 10, time.Now(), false)))
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+}
+
+func TestSiteCommandsConfig(t *testing.T) {
+	hookPath := filepath.Join(os.TempDir(), "hook")
+	c := &Configuration{
+		SiteCommands: []SiteCommand{
+			{
+				Name: " process",
+				Hook: hookPath,
+			},
+			{
+				Name: "Quota",
+				Hook: "http://127.0.0.1:8080/quota",
+			},
+		},
+	}
+	err := c.checkSiteCommands()
+	assert.NoError(t, err)
+	assert.Equal(t, "PROCESS", c.SiteCommands[0].Name)
+	assert.Equal(t, "QUOTA", c.SiteCommands[1].Name)
+
+	c.SiteCommands = append(c.SiteCommands, SiteCommand{Name: "quota", Hook: hookPath})
+	err = c.checkSiteCommands()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is duplicated")
+	}
+	c.SiteCommands[2].Name = "chmod"
+	err = c.checkSiteCommands()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot be redefined")
+	}
+	c.SiteCommands[2].Name = "a b"
+	err = c.checkSiteCommands()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid SITE command name")
+	}
+	c.SiteCommands[2].Name = "other"
+	c.SiteCommands[2].Hook = "relative"
+	err = c.checkSiteCommands()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid hook")
+	}
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolFTP, "", "", dataprovider.User{}),
+	}
+	_, err = connection.Site("PROCESS", "")
+	assert.ErrorIs(t, err, ftpserver.ErrUnknownSiteCommand)
+}
+
 func TestClientVersion(t *testing.T) {
 	mockCC := mockFTPClientContext{}
 	connID := fmt.Sprintf("2_%v", mockCC.ID())
@@ -753,7 +876,7 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	}
 	flags := 0
 	flags |= os.O_APPEND
-	_, err := connection.handleFTPUploadToExistingFile(fs, flags, "", "", 0, "")
+	_, err := connection.handleFTPUploadToExistingFile(fs, flags, "", "", 0, false, "")
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, common.ErrOpUnsupported.Error())
 	}
@@ -765,7 +888,7 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	flags = 0
 	flags |= os.O_CREATE
 	flags |= os.O_TRUNC
-	tr, err := connection.handleFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, f.Name())
+	tr, err := connection.handleFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, false, f.Name())
 	if assert.NoError(t, err) {
 		transfer := tr.(*transfer)
 		transfers := connection.GetTransfers()
@@ -781,10 +904,10 @@ func TestUploadOverwriteErrors(t *testing.T) {
 	assert.NoError(t, err)
 
 	_, err = connection.handleFTPUploadToExistingFile(fs, os.O_TRUNC, filepath.Join(os.TempDir(), "sub", "file"),
-		filepath.Join(os.TempDir(), "sub", "file1"), 0, "/sub/file1")
+		filepath.Join(os.TempDir(), "sub", "file1"), 0, false, "/sub/file1")
 	assert.Error(t, err)
 	fs = vfs.NewOsFs(connID, user.GetHomeDir(), "")
-	_, err = connection.handleFTPUploadToExistingFile(fs, 0, "missing1", "missing2", 0, "missing")
+	_, err = connection.handleFTPUploadToExistingFile(fs, 0, "missing1", "missing2", 0, false, "missing")
 	assert.Error(t, err)
 }
 
@@ -858,6 +981,69 @@ func TestTransferErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTLSSessionReuseTracking(t *testing.T) {
+	c := &Configuration{}
+	server := NewServer(c, configDir, Binding{Port: 2121, TLSSessionReuse: 1}, 0)
+	_, err := server.GetDataTLSConfig(mockFTPClientContext{})
+	assert.Error(t, err)
+
+	server.tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	server.dataTLSConfig = server.tlsConfig.Clone()
+	server.tlsConfig.GetConfigForClient = server.getTLSConfigForClient
+
+	mockListener := &mockControlListener{conns: make(chan net.Conn, 2)}
+	mockListener.conns <- &mockControlConn{remoteIP: "127.0.0.1"}
+	mockListener.conns <- &mockControlConn{remoteIP: "172.16.1.1"}
+	listener := &controlListener{Listener: mockListener, server: server}
+	conn1, err := listener.Accept()
+	require.NoError(t, err)
+	conn2, err := listener.Accept()
+	require.NoError(t, err)
+	// no TLS session on the control connection
+	_, err = server.GetDataTLSConfig(mockFTPClientContext{})
+	assert.Error(t, err)
+
+	cfg, err := server.getTLSConfigForClient(&tls.ClientHelloInfo{Conn: conn1})
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg)
+	assert.Nil(t, cfg.GetConfigForClient)
+	cfgAgain, err := server.getTLSConfigForClient(&tls.ClientHelloInfo{Conn: conn1})
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, cfgAgain)
+	_, err = server.getTLSConfigForClient(&tls.ClientHelloInfo{Conn: conn2})
+	assert.NoError(t, err)
+	key1, ok := conn1.(*controlConn).getTicketKey()
+	assert.True(t, ok)
+	key2, ok := conn2.(*controlConn).getTicketKey()
+	assert.True(t, ok)
+	assert.NotEqual(t, key1, key2)
+	cfg, err = server.getTLSConfigForClient(&tls.ClientHelloInfo{Conn: &mockControlConn{}})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	dataCfg, err := server.GetDataTLSConfig(mockFTPClientContext{})
+	assert.NoError(t, err)
+	assert.NotNil(t, dataCfg)
+	key, ok := server.getControlTicketKey(conn1.LocalAddr(), conn1.RemoteAddr())
+	assert.True(t, ok)
+	assert.Equal(t, key1, key)
+	key, ok = server.getControlTicketKey(conn2.LocalAddr(), conn2.RemoteAddr())
+	assert.True(t, ok)
+	assert.Equal(t, key2, key)
+
+	err = conn1.Close()
+	assert.NoError(t, err)
+	_, err = server.GetDataTLSConfig(mockFTPClientContext{})
+	assert.Error(t, err)
+	_, err = server.GetDataTLSConfig(mockFTPClientContext{remoteIP: "172.16.1.1"})
+	assert.NoError(t, err)
+	err = conn2.Close()
+	assert.NoError(t, err)
+	assert.Len(t, server.controlConns, 0)
+}
+
 func TestVerifyTLSConnection(t *testing.T) {
 	oldCertMgr := certMgr
 
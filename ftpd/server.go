@@ -1,6 +1,7 @@
 package ftpd
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -28,8 +29,12 @@ type Server struct {
 	statusBanner     string
 	binding          Binding
 	tlsConfig        *tls.Config
+	dataTLSConfig    *tls.Config
 	mu               sync.RWMutex
 	verifiedTLSConns map[uint32]bool
+	controlConns     map[string]*controlConn
+	mlsxFacts        []string
+	siteCommands     []SiteCommand
 }
 
 // NewServer returns a new FTP server driver
@@ -42,6 +47,9 @@ func NewServer(config *Configuration, configDir string, binding Binding, id int)
 		binding:          binding,
 		ID:               id,
 		verifiedTLSConns: make(map[uint32]bool),
+		controlConns:     make(map[string]*controlConn),
+		mlsxFacts:        config.MLSxFacts,
+		siteCommands:     config.SiteCommands,
 	}
 	if config.BannerFile != "" {
 		bannerFilePath := config.BannerFile
@@ -97,16 +105,22 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 		}
 	}
 	var ftpListener net.Listener
-	if s.binding.HasProxy() {
+	if s.binding.HasProxy() || s.binding.isTLSSessionReuseRequired() {
 		listener, err := net.Listen("tcp", s.binding.GetAddress())
 		if err != nil {
 			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
 			return nil, err
 		}
-		ftpListener, err = common.Config.GetProxyListener(listener)
-		if err != nil {
-			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-			return nil, err
+		ftpListener = listener
+		if s.binding.HasProxy() {
+			ftpListener, err = common.GetConfig().GetProxyListener(listener)
+			if err != nil {
+				logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+				return nil, err
+			}
+		}
+		if s.binding.isTLSSessionReuseRequired() {
+			ftpListener = &controlListener{Listener: ftpListener, server: s}
 		}
 		if s.binding.TLSMode == 2 && s.tlsConfig != nil {
 			ftpListener = tls.NewListener(ftpListener, s.tlsConfig)
@@ -146,6 +160,10 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	cc.SetDebug(s.binding.Debug)
 	ipAddr := util.GetIPFromRemoteAddress(cc.RemoteAddr().String())
 	common.Connections.AddClientConnection(ipAddr)
+	if common.IsDraining() {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, the server is shutting down")
+		return fmt.Sprintf("Service not available, %v", common.ErrShuttingDown), common.ErrShuttingDown
+	}
 	if common.IsBanned(ipAddr) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %#v is banned", ipAddr)
 		return "Access denied: banned client IP", common.ErrConnectionDenied
@@ -158,7 +176,7 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	if err != nil {
 		return fmt.Sprintf("Access denied: %v", err.Error()), err
 	}
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolFTP); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolFTP); err != nil {
 		return "Access denied by post connect hook", err
 	}
 	connID := fmt.Sprintf("%v_%v", s.ID, cc.ID())
@@ -209,7 +227,7 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 // WrapPassiveListener implements the MainDriverExtensionPassiveWrapper interface
 func (s *Server) WrapPassiveListener(listener net.Listener) (net.Listener, error) {
 	if s.binding.HasProxy() {
-		return common.Config.GetProxyListener(listener)
+		return common.GetConfig().GetProxyListener(listener)
 	}
 	return listener, nil
 }
@@ -276,7 +294,144 @@ func (s *Server) buildTLSConfig() {
 				s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
+		if s.binding.isTLSSessionReuseRequired() {
+			s.dataTLSConfig = s.tlsConfig.Clone()
+			s.dataTLSConfig.VerifyConnection = s.verifyTLSDataConnection
+			s.tlsConfig.GetConfigForClient = s.getTLSConfigForClient
+		}
+	}
+}
+
+// getTLSConfigForClient returns the TLS configuration for control connections.
+// Each control connection uses its own session ticket keys, so its TLS session
+// can only be resumed by the data connections of the same client
+func (s *Server) getTLSConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if conn, ok := hello.Conn.(*controlConn); ok {
+		return conn.getTLSConfig(s.tlsConfig)
+	}
+	return nil, nil
+}
+
+// GetDataTLSConfig implements the MainDriverExtensionDataTLSConfig interface
+func (s *Server) GetDataTLSConfig(cc ftpserver.ClientContext) (*tls.Config, error) {
+	if s.dataTLSConfig == nil {
+		return s.GetTLSConfig()
 	}
+	ticketKey, ok := s.getControlTicketKey(cc.LocalAddr(), cc.RemoteAddr())
+	if !ok {
+		logger.Debug(logSender, "", "unable to get a TLS data config, no TLS session for the control connection %v",
+			cc.RemoteAddr())
+		return nil, errors.New("no TLS session established on the control connection")
+	}
+	dataTLSConfig := s.dataTLSConfig.Clone()
+	dataTLSConfig.SetSessionTicketKeys([][32]byte{ticketKey})
+	return dataTLSConfig, nil
+}
+
+func (s *Server) verifyTLSDataConnection(state tls.ConnectionState) error {
+	if !state.DidResume {
+		logger.Debug(logSender, "", "TLS data connection refused, the TLS session was not resumed")
+		return errors.New("TLS session resumption is required for data connections")
+	}
+	if s.binding.isMutualTLSEnabled() {
+		return s.verifyTLSConnection(state)
+	}
+	return nil
+}
+
+func getControlConnKey(localAddr, remoteAddr net.Addr) string {
+	return fmt.Sprintf("%v-%v", localAddr, remoteAddr)
+}
+
+func (s *Server) addControlConn(conn *controlConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.controlConns[getControlConnKey(conn.LocalAddr(), conn.RemoteAddr())] = conn
+}
+
+func (s *Server) removeControlConn(conn *controlConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := getControlConnKey(conn.LocalAddr(), conn.RemoteAddr())
+	if s.controlConns[key] == conn {
+		delete(s.controlConns, key)
+	}
+}
+
+// getControlTicketKey returns the session ticket key used for the TLS session
+// of the control connection with the specified addresses
+func (s *Server) getControlTicketKey(localAddr, remoteAddr net.Addr) ([32]byte, bool) {
+	s.mu.RLock()
+	conn, ok := s.controlConns[getControlConnKey(localAddr, remoteAddr)]
+	s.mu.RUnlock()
+
+	if !ok {
+		return [32]byte{}, false
+	}
+	return conn.getTicketKey()
+}
+
+// controlListener marks the accepted connections as control connections
+// and tracks them until they are closed
+type controlListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept implements net.Listener
+func (l *controlListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	c := &controlConn{Conn: conn, server: l.server}
+	l.server.addControlConn(c)
+	return c, nil
+}
+
+type controlConn struct {
+	net.Conn
+	server    *Server
+	closeOnce sync.Once
+	mu        sync.Mutex
+	tlsConfig *tls.Config
+	ticketKey [32]byte
+}
+
+// getTLSConfig returns a TLS configuration with session ticket keys unique to this connection
+func (c *controlConn) getTLSConfig(baseConfig *tls.Config) (*tls.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tlsConfig == nil {
+		var ticketKey [32]byte
+		if _, err := rand.Read(ticketKey[:]); err != nil {
+			return nil, err
+		}
+		tlsConfig := baseConfig.Clone()
+		tlsConfig.GetConfigForClient = nil
+		tlsConfig.SetSessionTicketKeys([][32]byte{ticketKey})
+		c.ticketKey = ticketKey
+		c.tlsConfig = tlsConfig
+	}
+	return c.tlsConfig, nil
+}
+
+func (c *controlConn) getTicketKey() ([32]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ticketKey, c.tlsConfig != nil
+}
+
+// Close implements net.Conn
+func (c *controlConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.server.removeControlConn(c)
+	})
+	return c.Conn.Close()
 }
 
 // GetTLSConfig returns the TLS configuration for this server
@@ -319,6 +474,10 @@ func (s *Server) verifyTLSConnection(state tls.ConnectionState) error {
 
 func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext, loginMethod string) (*Connection, error) {
 	connectionID := fmt.Sprintf("%v_%v_%v", common.ProtocolFTP, s.ID, cc.ID())
+	if common.IsDraining() {
+		logger.Info(logSender, connectionID, "cannot login user %#v, the server is shutting down", user.Username)
+		return nil, common.ErrShuttingDown
+	}
 	if !filepath.IsAbs(user.HomeDir) {
 		logger.Warn(logSender, connectionID, "user %#v has an invalid home dir: %#v. Home dir must be an absolute path, login not allowed",
 			user.Username, user.HomeDir)
@@ -357,6 +516,8 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		BaseConnection: common.NewBaseConnection(fmt.Sprintf("%v_%v", s.ID, cc.ID()), common.ProtocolFTP,
 			cc.LocalAddr().String(), remoteAddr, user),
 		clientContext: cc,
+		mlsxFacts:     s.mlsxFacts,
+		siteCommands:  s.siteCommands,
 	}
 	err = common.Connections.Swap(connection)
 	if err != nil {
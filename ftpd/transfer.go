@@ -44,6 +44,10 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter,
 
 // Read reads the contents to downloads.
 func (t *transfer) Read(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&t.AbortTransfer) == 1 {
+		t.TransferError(common.ErrTransferAborted)
+		return 0, common.ErrTransferAborted
+	}
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.reader.Read(p)
@@ -59,6 +63,10 @@ func (t *transfer) Read(p []byte) (n int, err error) {
 
 // Write writes the uploaded contents.
 func (t *transfer) Write(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&t.AbortTransfer) == 1 {
+		t.TransferError(common.ErrTransferAborted)
+		return 0, common.ErrTransferAborted
+	}
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.writer.Write(p)
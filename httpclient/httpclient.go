@@ -1,6 +1,7 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 
 	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 )
 
@@ -165,7 +167,7 @@ func (c *Config) loadCertificates(configDir string) error {
 func GetHTTPClient() *http.Client {
 	return &http.Client{
 		Timeout:   time.Duration(httpConfig.Timeout * float64(time.Second)),
-		Transport: httpConfig.customTransport,
+		Transport: tracing.NewTransport(httpConfig.customTransport),
 	}
 }
 
@@ -175,6 +177,7 @@ func GetRetraybleHTTPClient() *retryablehttp.Client {
 	client := retryablehttp.NewClient()
 	client.HTTPClient.Timeout = time.Duration(httpConfig.Timeout * float64(time.Second))
 	client.HTTPClient.Transport.(*http.Transport).TLSClientConfig = httpConfig.tlsConfig
+	client.HTTPClient.Transport = tracing.NewTransport(client.HTTPClient.Transport)
 	client.Logger = &logger.LeveledLogger{Sender: "RetryableHTTPClient"}
 	client.RetryWaitMin = time.Duration(httpConfig.RetryWaitMin) * time.Second
 	client.RetryWaitMax = time.Duration(httpConfig.RetryWaitMax) * time.Second
@@ -198,7 +201,12 @@ func Get(url string) (*http.Response, error) {
 
 // Post issues a POST to the specified URL
 func Post(url string, contentType string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(http.MethodPost, url, body)
+	return PostWithContext(context.Background(), url, contentType, body)
+}
+
+// PostWithContext issues a POST to the specified URL using the given context
+func PostWithContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -225,10 +233,17 @@ func RetryableGet(url string) (*http.Response, error) {
 
 // RetryablePost issues a POST to the specified URL using the retryable client
 func RetryablePost(url string, contentType string, body io.Reader) (*http.Response, error) {
+	return RetryablePostWithContext(context.Background(), url, contentType, body)
+}
+
+// RetryablePostWithContext issues a POST to the specified URL using the retryable client
+// and the given context
+func RetryablePostWithContext(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
 	req, err := retryablehttp.NewRequest(http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", contentType)
 	addHeadersToRetryableReq(req, url)
 	client := GetRetraybleHTTPClient()
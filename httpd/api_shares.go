@@ -11,6 +11,7 @@ import (
 	"github.com/go-chi/render"
 	"github.com/rs/xid"
 
+	"github.com/drakkan/sftpgo/v2/audit"
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/util"
@@ -243,6 +244,34 @@ func uploadFilesToShare(w http.ResponseWriter, r *http.Request) {
 }
 
 func checkPublicShare(w http.ResponseWriter, r *http.Request, shareShope dataprovider.ShareScope,
+) (dataprovider.Share, *Connection, error) {
+	share, connection, err := validatePublicShare(w, r, shareShope)
+	if audit.IsEnabled() {
+		ev := audit.Event{
+			Event:    audit.EventShareAccess,
+			Username: share.Username,
+			IP:       util.GetIPFromRemoteAddress(r.RemoteAddr),
+			Protocol: common.ProtocolHTTPShare,
+			Action:   getShareScopeForAudit(shareShope),
+			ShareID:  getURLParam(r, "id"),
+		}
+		if connection != nil {
+			ev.ConnectionID = connection.GetID()
+		}
+		ev.SetError(err)
+		audit.Log(ev)
+	}
+	return share, connection, err
+}
+
+func getShareScopeForAudit(scope dataprovider.ShareScope) string {
+	if scope == dataprovider.ShareScopeRead {
+		return "read"
+	}
+	return "write"
+}
+
+func validatePublicShare(w http.ResponseWriter, r *http.Request, shareShope dataprovider.ShareScope,
 ) (dataprovider.Share, *Connection, error) {
 	shareID := getURLParam(r, "id")
 	share, err := dataprovider.ShareExists(shareID, "")
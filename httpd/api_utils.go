@@ -3,6 +3,7 @@ package httpd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -54,6 +55,11 @@ type userProfile struct {
 	PublicKeys []string `json:"public_keys,omitempty"`
 }
 
+type connectionBandwidth struct {
+	UploadBandwidth   int64 `json:"upload_bandwidth"`
+	DownloadBandwidth int64 `json:"download_bandwidth"`
+}
+
 func sendAPIResponse(w http.ResponseWriter, r *http.Request, err error, message string, code int) {
 	var errorString string
 	if _, ok := err.(*util.RecordNotFoundError); ok {
@@ -149,6 +155,88 @@ func handleCloseConnection(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func handleAbortTransfer(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connectionID := getURLParam(r, "connectionID")
+	if connectionID == "" {
+		sendAPIResponse(w, r, nil, "connectionID is mandatory", http.StatusBadRequest)
+		return
+	}
+	transferID, err := strconv.ParseUint(getURLParam(r, "transferID"), 10, 64)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Invalid transferID", http.StatusBadRequest)
+		return
+	}
+	if err := common.Connections.SignalTransferAbort(connectionID, transferID); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Transfer abort requested", http.StatusOK)
+}
+
+func handleSetConnectionBandwidth(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	connectionID := getURLParam(r, "connectionID")
+	if connectionID == "" {
+		sendAPIResponse(w, r, nil, "connectionID is mandatory", http.StatusBadRequest)
+		return
+	}
+	var limits connectionBandwidth
+	if err := render.DecodeJSON(r.Body, &limits); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err := common.Connections.SetBandwidth(connectionID, limits.UploadBandwidth, limits.DownloadBandwidth)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Bandwidth limits updated", http.StatusOK)
+}
+
+// streamConnections sends the active connections as server-sent events.
+// The stream is closed before the server write timeout expires, clients
+// such as the browsers EventSource reconnect automatically
+func streamConnections(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendAPIResponse(w, r, nil, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(connectionsStreamInterval)
+	defer ticker.Stop()
+	streamTimeout := time.NewTimer(connectionsStreamMaxDuration)
+	defer streamTimeout.Stop()
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", connectionsStreamRetry.Milliseconds()); err != nil {
+		return
+	}
+	for {
+		data, err := json.Marshal(common.Connections.GetStats())
+		if err != nil {
+			logger.Warn(logSender, "", "unable to marshal active connections: %v", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: connections\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streamTimeout.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func getSearchFilters(w http.ResponseWriter, r *http.Request) (int, int, string, error) {
 	var err error
 	limit := 100
@@ -440,17 +528,32 @@ func parseRangeRequest(bytesRange string, size int64) (int64, int64, error) {
 }
 
 func updateLoginMetrics(user *dataprovider.User, ip string, err error) {
-	metric.AddLoginAttempt(dataprovider.LoginMethodPassword)
+	updateLoginMetricsWithMethod(user, ip, dataprovider.LoginMethodPassword, err)
+}
+
+func updateLoginMetricsWithMethod(user *dataprovider.User, ip, loginMethod string, err error) {
+	metric.AddLoginAttempt(loginMethod)
 	if err != nil && err != common.ErrInternalFailure && err != common.ErrNoCredentials {
-		logger.ConnectionFailedLog(user.Username, ip, dataprovider.LoginMethodPassword, common.ProtocolHTTP, err.Error())
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolHTTP, err.Error())
 		event := common.HostEventLoginFailed
 		if _, ok := err.(*util.RecordNotFoundError); ok {
 			event = common.HostEventUserNotFound
 		}
 		common.AddDefenderEvent(ip, event)
 	}
-	metric.AddLoginResult(dataprovider.LoginMethodPassword, err)
-	dataprovider.ExecutePostLoginHook(user, dataprovider.LoginMethodPassword, ip, common.ProtocolHTTP, err)
+	metric.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolHTTP, err)
+}
+
+// getHTTPClientLoginMethod returns the TLS certificate login method if the request
+// has a client certificate matching the given user, otherwise the password login method
+func getHTTPClientLoginMethod(user *dataprovider.User, r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && user.IsTLSUsernameVerificationEnabled() {
+		if err := user.CheckTLSCertificate(r.TLS.PeerCertificates[0]); err == nil {
+			return dataprovider.LoginMethodTLSCertificate
+		}
+	}
+	return dataprovider.LoginMethodPassword
 }
 
 func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID string) error {
@@ -458,9 +561,10 @@ func checkHTTPClientUser(user *dataprovider.User, r *http.Request, connectionID
 		logger.Info(logSender, connectionID, "cannot login user %#v, protocol HTTP is not allowed", user.Username)
 		return fmt.Errorf("protocol HTTP is not allowed for user %#v", user.Username)
 	}
-	if !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, nil) {
-		logger.Info(logSender, connectionID, "cannot login user %#v, password login method is not allowed", user.Username)
-		return fmt.Errorf("login method password is not allowed for user %#v", user.Username)
+	loginMethod := getHTTPClientLoginMethod(user, r)
+	if !user.IsLoginMethodAllowed(loginMethod, nil) {
+		logger.Info(logSender, connectionID, "cannot login user %#v, %v login method is not allowed", user.Username, loginMethod)
+		return fmt.Errorf("login method %v is not allowed for user %#v", loginMethod, user.Username)
 	}
 	if user.MaxSessions > 0 {
 		activeSessions := common.Connections.GetActiveSessions(user.Username)
@@ -130,7 +130,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		return nil, err
 	}
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 
@@ -157,7 +157,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		err = fs.Rename(p, filePath)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -166,7 +166,7 @@ func (c *Connection) getFileWriter(name string) (io.WriteCloser, error) {
 		}
 	}
 
-	return c.handleUploadFile(fs, p, filePath, name, false, stat.Size())
+	return c.handleUploadFile(fs, p, filePath, name, !vfs.IsQuotaTracked(stat), vfs.GetQuotaSize(stat))
 }
 
 func (c *Connection) handleUploadFile(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool, fileSize int64) (io.WriteCloser, error) {
@@ -4,20 +4,25 @@
 package httpd
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
+	"github.com/go-chi/render"
 	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
@@ -26,6 +31,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/mfa"
 	"github.com/drakkan/sftpgo/v2/sftpd"
 	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
 	"github.com/drakkan/sftpgo/v2/webdavd"
 )
 
@@ -84,6 +90,8 @@ const (
 	fsEventsPath                          = "/api/v2/events/fs"
 	providerEventsPath                    = "/api/v2/events/provider"
 	sharesPath                            = "/api/v2/shares"
+	dedupStatsPath                        = "/api/v2/dedup/stats"
+	configReloadPath                      = "/api/v2/config/reload"
 	healthzPath                           = "/healthz"
 	webRootPathDefault                    = "/"
 	webBasePathDefault                    = "/web"
@@ -155,9 +163,18 @@ const (
 	mTimeHeader          = "X-SFTPGO-MTIME"
 )
 
+const (
+	connectionsStreamInterval = 2 * time.Second
+	connectionsStreamRetry    = time.Second
+	// must be lower than the server write timeout
+	connectionsStreamMaxDuration = 50 * time.Second
+)
+
 var (
 	backupsPath                    string
 	certMgr                        *common.CertManager
+	servers                        common.ServerGroup
+	runningServer                  runningServerConfig
 	cleanupTicker                  *time.Ticker
 	cleanupDone                    chan bool
 	invalidatedJWTTokens           sync.Map
@@ -238,6 +255,31 @@ type WebClientIntegration struct {
 	URL string `json:"url" mapstructure:"url"`
 }
 
+// runningServerConfig stores the configuration used to start the server, it
+// is required to start the new bindings on configuration reload
+type runningServerConfig struct {
+	sync.RWMutex
+	config          *Conf
+	staticFilesPath string
+	openAPIPath     string
+}
+
+func (r *runningServerConfig) set(config *Conf, staticFilesPath, openAPIPath string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+	r.staticFilesPath = staticFilesPath
+	r.openAPIPath = openAPIPath
+}
+
+func (r *runningServerConfig) get() (*Conf, string, string) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.config, r.staticFilesPath, r.openAPIPath
+}
+
 // Binding defines the configuration for a network listener
 type Binding struct {
 	// The address to listen on. A blank value means listen on all available network interfaces.
@@ -281,7 +323,33 @@ type Binding struct {
 	// Enabling web client integrations you can render or modify the files with the specified
 	// extensions using an external tool.
 	WebClientIntegrations []WebClientIntegration `json:"web_client_integrations" mapstructure:"web_client_integrations"`
-	allowHeadersFrom      []func(net.IP) bool
+	// TLS certificate attribute to use as username for web client users logging in
+	// using a client certificate.
+	// Supported values: "CommonName", "SANEmail", "SANURI", "SerialNumber".
+	// Empty means "CommonName"
+	TLSUsername      string `json:"tls_username" mapstructure:"tls_username"`
+	allowHeadersFrom []func(net.IP) bool
+}
+
+func (b *Binding) isMutualTLSEnabled() bool {
+	return b.ClientAuthType == 1
+}
+
+func (b *Binding) getTLSUsername() sdk.TLSUsername {
+	if b.TLSUsername == "" {
+		return sdk.TLSUsernameCN
+	}
+	return sdk.TLSUsername(b.TLSUsername)
+}
+
+func (b *Binding) checkTLSUsername() error {
+	switch sdk.TLSUsername(b.TLSUsername) {
+	case "", sdk.TLSUsernameCN, dataprovider.TLSUsernameSANEmail, dataprovider.TLSUsernameSANURI,
+		dataprovider.TLSUsernameSerialNumber:
+		return nil
+	default:
+		return fmt.Errorf("unsupported tls_username %#v", b.TLSUsername)
+	}
 }
 
 func (b *Binding) checkWebClientIntegrations() {
@@ -294,6 +362,18 @@ func (b *Binding) checkWebClientIntegrations() {
 	b.WebClientIntegrations = integrations
 }
 
+// prepare validates the binding and populates the fields required to start it
+func (b *Binding) prepare() error {
+	if err := b.parseAllowedProxy(); err != nil {
+		return err
+	}
+	if err := b.checkTLSUsername(); err != nil {
+		return err
+	}
+	b.checkWebClientIntegrations()
+	return nil
+}
+
 func (b *Binding) parseAllowedProxy() error {
 	allowedFuncs, err := util.ParseAllowedIPAndRanges(b.ProxyAllowed)
 	if err != nil {
@@ -351,6 +431,7 @@ type ServicesStatus struct {
 	DataProvider dataprovider.ProviderStatus `json:"data_provider"`
 	Defender     defenderStatus              `json:"defender"`
 	MFA          mfa.ServiceStatus           `json:"mfa"`
+	SFTPBackends []vfs.SFTPEndpointStatus    `json:"sftp_backends"`
 }
 
 // CorsConfig defines the CORS configuration
@@ -464,6 +545,7 @@ func (c *Conf) Initialize(configDir string) error {
 	if err := c.checkRequiredDirs(staticFilesPath, templatesPath); err != nil {
 		return err
 	}
+	certMgr = nil
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
 	if c.isWebAdminEnabled() {
@@ -496,27 +578,76 @@ func (c *Conf) Initialize(configDir string) error {
 
 	csrfTokenAuth = jwtauth.New(jwa.HS256.String(), getSigningKey(c.SigningPassphrase), nil)
 
-	exitChannel := make(chan error, 1)
+	servers.Reset()
+	runningServer.set(c, staticFilesPath, openAPIPath)
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
 		}
-		if err := binding.parseAllowedProxy(); err != nil {
+		if err := binding.prepare(); err != nil {
 			return err
 		}
-		binding.checkWebClientIntegrations()
-
-		go func(b Binding) {
-			server := newHttpdServer(b, staticFilesPath, c.SigningPassphrase, c.Cors, openAPIPath)
-
-			exitChannel <- server.listenAndServe()
-		}(binding)
+		servers.GoBinding(c.getBindingStarter(binding, staticFilesPath, openAPIPath))
 	}
 
 	maxUploadFileSize = c.MaxUploadFileSize
 	startCleanupTicker(tokenDuration / 2)
-	return <-exitChannel
+	return servers.Wait()
+}
+
+// ReloadBindings stops the removed bindings and starts the added ones, the
+// other bindings are not affected and the in-flight requests are completed.
+// The other configuration settings must be unchanged
+func ReloadBindings(ctx context.Context, bindings []Binding) error {
+	c, staticFilesPath, openAPIPath := runningServer.get()
+	if c == nil {
+		return errors.New("the HTTP server is not running")
+	}
+	var removed, added []common.BindingStarter
+	for _, binding := range c.Bindings {
+		if binding.IsValid() && !isBindingInList(binding, bindings) {
+			if err := binding.prepare(); err != nil {
+				return err
+			}
+			removed = append(removed, c.getBindingStarter(binding, staticFilesPath, openAPIPath))
+		}
+	}
+	for _, binding := range bindings {
+		if binding.IsValid() && !isBindingInList(binding, c.Bindings) {
+			if err := binding.prepare(); err != nil {
+				return err
+			}
+			added = append(added, c.getBindingStarter(binding, staticFilesPath, openAPIPath))
+		}
+	}
+	if err := servers.ReplaceBindings(ctx, removed, added); err != nil {
+		return err
+	}
+	conf := *c
+	conf.Bindings = bindings
+	runningServer.set(&conf, staticFilesPath, openAPIPath)
+	return nil
+}
+
+func isBindingInList(binding Binding, bindings []Binding) bool {
+	for _, b := range bindings {
+		if common.IsSameBinding(b, binding) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conf) getBindingStarter(binding Binding, staticFilesPath, openAPIPath string) common.BindingStarter {
+	return common.BindingStarter{
+		Key: binding.GetAddress(),
+		Start: func(b *common.ServerBinding) error {
+			server := newHttpdServer(binding, staticFilesPath, c.SigningPassphrase, c.Cors, openAPIPath)
+
+			return server.listenAndServe(b)
+		},
+	}
 }
 
 func isWebRequest(r *http.Request) bool {
@@ -535,6 +666,13 @@ func ReloadCertificateMgr() error {
 	return nil
 }
 
+// Stop closes the HTTP server listeners, the in-flight requests are completed
+// before closing the connections. The server can be initialized again after Stop returns
+func Stop(ctx context.Context) error {
+	stopCleanupTicker()
+	return servers.Stop(ctx)
+}
+
 func getConfigPath(name, configDir string) string {
 	if !util.IsFileInputValid(name) {
 		return ""
@@ -552,13 +690,35 @@ func getServicesStatus() ServicesStatus {
 		WebDAV:       webdavd.GetStatus(),
 		DataProvider: dataprovider.GetProviderStatus(),
 		Defender: defenderStatus{
-			IsActive: common.Config.DefenderConfig.Enabled,
+			IsActive: common.GetConfig().DefenderConfig.Enabled,
 		},
-		MFA: mfa.GetStatus(),
+		MFA:          mfa.GetStatus(),
+		SFTPBackends: vfs.GetSFTPEndpointsStatus(),
 	}
 	return status
 }
 
+// getHealthz returns the health status. This endpoint is not authenticated
+// so the detailed errors, addresses and commands are never exposed
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	if !verbose {
+		if common.IsDraining() {
+			render.Status(r, http.StatusServiceUnavailable)
+			render.PlainText(w, r, common.HealthStatusDraining)
+			return
+		}
+		render.PlainText(w, r, "ok")
+		return
+	}
+	status := common.GetHealthStatus()
+	status.HideDetails()
+	if !status.IsHealthy() {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, status)
+}
+
 func fileServer(r chi.Router, path string, root http.FileSystem) {
 	if path != "/" && path[len(path)-1] != '/' {
 		r.Get(path, http.RedirectHandler(path+"/", http.StatusMovedPermanently).ServeHTTP)
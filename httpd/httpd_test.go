@@ -2,6 +2,7 @@ package httpd_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -115,6 +116,7 @@ const (
 	fsEventsPath                    = "/api/v2/events/fs"
 	providerEventsPath              = "/api/v2/events/provider"
 	sharesPath                      = "/api/v2/shares"
+	configReloadPath                = "/api/v2/config/reload"
 	healthzPath                     = "/healthz"
 	webBasePath                     = "/web"
 	webBasePathAdmin                = "/web/admin"
@@ -1492,6 +1494,13 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	u.Filters.TLSUsername = "not a supported attribute"
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
+	u.Filters.TLSUsername = dataprovider.TLSUsernameFingerprint
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.TLSCertFingerprints = []string{"not a fingerprint"}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.TLSCertFingerprints = nil
 	u.Filters.TLSUsername = ""
 	u.Filters.WebClient = []string{"not a valid web client options"}
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
@@ -1747,6 +1756,59 @@ func TestMetadataAPI(t *testing.T) {
 	checkResponseCode(t, http.StatusNotFound, rr)
 }
 
+func TestConfigReloadAPI(t *testing.T) {
+	// the configuration reload is handled by the service
+	_, err := httpdtest.StartConfigReload(http.StatusForbidden)
+	assert.NoError(t, err)
+
+	reloadDone := make(chan struct{})
+	common.SetConfigReloadHandler(func() common.ConfigReloadResult {
+		<-reloadDone
+		return common.ConfigReloadResult{
+			Restarted: []string{"ftpd"},
+		}
+	})
+	defer common.SetConfigReloadHandler(nil)
+
+	_, err = httpdtest.StartConfigReload(http.StatusAccepted)
+	assert.NoError(t, err)
+	result, _, err := httpdtest.GetConfigReloadResult(http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, common.ConfigReloadStatusInProgress, result.Status)
+	_, err = httpdtest.StartConfigReload(http.StatusConflict)
+	assert.NoError(t, err)
+	close(reloadDone)
+	assert.Eventually(t, func() bool {
+		result, _, err := httpdtest.GetConfigReloadResult(http.StatusOK)
+		return err == nil && result.Status == common.ConfigReloadStatusSuccess
+	}, 1*time.Second, 50*time.Millisecond)
+	result, _, err = httpdtest.GetConfigReloadResult(http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ftpd"}, result.Restarted)
+	assert.Empty(t, result.Errors)
+	assert.GreaterOrEqual(t, result.EndTime, result.StartTime)
+
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Permissions = []string{dataprovider.PermAdminViewServerStatus}
+	admin, _, err = httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPITokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, configReloadPath, nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, _ = http.NewRequest(http.MethodPost, configReloadPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestRetentionAPI(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -2078,7 +2140,8 @@ func TestUpdateUser(t *testing.T) {
 	user.Filters.DeniedIP = []string{"192.168.3.0/24", "192.168.4.0/24"}
 	user.Filters.DeniedLoginMethods = []string{dataprovider.LoginMethodPassword}
 	user.Filters.DeniedProtocols = []string{common.ProtocolWebDAV}
-	user.Filters.TLSUsername = sdk.TLSUsernameNone
+	user.Filters.TLSUsername = dataprovider.TLSUsernameFingerprint
+	user.Filters.TLSCertFingerprints = []string{"347360abcd7cb220f6c12ea144c3457c165f8e4f1acf58e74894f79a59a88ddd"}
 	user.Filters.Hooks.ExternalAuthDisabled = true
 	user.Filters.Hooks.PreLoginDisabled = true
 	user.Filters.Hooks.CheckPasswordDisabled = false
@@ -4845,6 +4908,101 @@ func TestLoaddataMode(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBackupDiff(t *testing.T) {
+	folderName := "backup_diff_folder"
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       folderName,
+		MappedPath: filepath.Join(os.TempDir(), folderName),
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			Name:       folderName,
+			MappedPath: folder.MappedPath,
+		},
+		VirtualPath: "/vdir",
+		QuotaSize:   -1,
+		QuotaFiles:  -1,
+	})
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	filter := dataprovider.BackupFilter{
+		Types: []string{dataprovider.BackupObjectUsers, dataprovider.BackupObjectFolders},
+		Names: []string{user.Username, "backup_diff_*"},
+	}
+	assert.NoError(t, filter.Validate())
+	backup, err := dataprovider.DumpData()
+	assert.NoError(t, err)
+	filter.Apply(&backup)
+	if assert.Len(t, backup.Users, 1) {
+		assert.Equal(t, user.Username, backup.Users[0].Username)
+	}
+	if assert.Len(t, backup.Folders, 1) {
+		assert.Equal(t, folderName, backup.Folders[0].Name)
+	}
+	assert.Len(t, backup.Admins, 0)
+	assert.Len(t, backup.APIKeys, 0)
+	assert.Len(t, backup.Shares, 0)
+	// quota and login changes are ignored
+	err = dataprovider.UpdateVirtualFolderQuota(&folder, 1, 100, false)
+	assert.NoError(t, err)
+	dataprovider.UpdateLastLogin(&user)
+	diff, err := dataprovider.GetBackupDiff(&backup, &filter, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, diff, 0)
+
+	newUser := backup.Users[0]
+	newUser.Username = "backup_diff_user"
+	backup.Users = append(backup.Users, newUser)
+	backup.Users[0].MaxSessions = 10
+	diff, err = dataprovider.GetBackupDiff(&backup, &filter, 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []dataprovider.BackupDiffEntry{
+		{Type: dataprovider.BackupObjectUsers, Name: user.Username, Action: dataprovider.BackupDiffUpdate},
+		{Type: dataprovider.BackupObjectUsers, Name: newUser.Username, Action: dataprovider.BackupDiffCreate},
+	}, diff)
+	diff, err = dataprovider.GetBackupDiff(&backup, &filter, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []dataprovider.BackupDiffEntry{
+		{Type: dataprovider.BackupObjectUsers, Name: user.Username, Action: dataprovider.BackupDiffSkip},
+		{Type: dataprovider.BackupObjectUsers, Name: newUser.Username, Action: dataprovider.BackupDiffCreate},
+	}, diff)
+	changes := dataprovider.GetBackupDiffObjects(&backup, diff, dataprovider.BackupDiffCreate)
+	if assert.Len(t, changes.Users, 1) {
+		assert.Equal(t, newUser.Username, changes.Users[0].Username)
+	}
+	assert.Len(t, changes.Folders, 0)
+	// the user to create is excluded by the filter
+	filter.Names = []string{user.Username}
+	backup.Folders = nil
+	diff, err = dataprovider.GetBackupDiff(&backup, &filter, 0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []dataprovider.BackupDiffEntry{
+		{Type: dataprovider.BackupObjectUsers, Name: user.Username, Action: dataprovider.BackupDiffUpdate},
+	}, diff)
+	filter.Names = nil
+	diff, err = dataprovider.GetBackupDiff(&backup, &filter, 0, true)
+	assert.NoError(t, err)
+	assert.Contains(t, diff, dataprovider.BackupDiffEntry{
+		Type:   dataprovider.BackupObjectFolders,
+		Name:   folderName,
+		Action: dataprovider.BackupDiffDelete,
+	})
+
+	filter.Types = []string{"invalid"}
+	assert.Error(t, filter.Validate())
+	filter.Types = nil
+	filter.Names = []string{"["}
+	assert.Error(t, filter.Validate())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestRateLimiter(t *testing.T) {
 	oldConfig := config.GetCommonConfig()
 
@@ -7953,6 +8111,26 @@ func TestHealthCheck(t *testing.T) {
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
 	assert.Equal(t, "ok", rr.Body.String())
+
+	req, _ = http.NewRequest(http.MethodGet, "/healthz?verbose=1", nil)
+	rr = executeRequest(req)
+	// other test cases start bindings that cannot listen, the overall status could be an error
+	assert.Contains(t, []int{http.StatusOK, http.StatusServiceUnavailable}, rr.Code)
+	var status common.HealthStatus
+	err := json.Unmarshal(rr.Body.Bytes(), &status)
+	assert.NoError(t, err)
+	assert.Equal(t, common.HealthStatusOK, status.DataProvider.Status)
+	assert.NotEmpty(t, status.DataProvider.Driver)
+	assert.Equal(t, common.HealthStatusOK, status.KMS.Status)
+	assert.Greater(t, len(status.Bindings), 0)
+	for _, b := range status.Bindings {
+		// details are not exposed
+		assert.Empty(t, b.Address)
+		assert.Empty(t, b.Error)
+	}
+	for _, p := range status.Plugins {
+		assert.Empty(t, p.Cmd)
+	}
 }
 
 func TestGetWebRootMock(t *testing.T) {
@@ -14715,6 +14893,9 @@ func TestWebUserSFTPFsMock(t *testing.T) {
 	form.Set("sftp_prefix", user.FsConfig.SFTPConfig.Prefix)
 	form.Set("sftp_disable_concurrent_reads", "true")
 	form.Set("sftp_buffer_size", strconv.FormatInt(user.FsConfig.SFTPConfig.BufferSize, 10))
+	form.Set("sftp_endpoints_policy", "0")
+	form.Set("sftp_max_connections", "0")
+	form.Set("sftp_max_sessions_per_connection", "5")
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, path.Join(webUserPath, user.Username), &b)
 	setJWTCookieForReq(req, webToken)
@@ -14745,6 +14926,7 @@ func TestWebUserSFTPFsMock(t *testing.T) {
 	assert.Len(t, updateUser.FsConfig.SFTPConfig.Fingerprints, 1)
 	assert.Equal(t, user.FsConfig.SFTPConfig.BufferSize, updateUser.FsConfig.SFTPConfig.BufferSize)
 	assert.Contains(t, updateUser.FsConfig.SFTPConfig.Fingerprints, sftpPkeyFingerprint)
+	assert.Equal(t, 5, updateUser.FsConfig.SFTPConfig.MaxSessionsPerConnection)
 	// now check that a redacted credentials are not saved
 	form.Set("sftp_password", redactedSecret+" ")
 	form.Set("sftp_private_key", redactedSecret)
@@ -15737,6 +15919,64 @@ func TestWebConnectionsMock(t *testing.T) {
 	setCSRFHeaderForReq(req, csrfToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(webConnectionsPath, "id", "transfers", "1"), nil)
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(webConnectionsPath, "id", "transfers", "a"), nil)
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, _ = http.NewRequest(http.MethodPut, path.Join(webConnectionsPath, "id", "bandwidth"),
+		bytes.NewBuffer([]byte(`{"upload_bandwidth":10,"download_bandwidth":20}`)))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, _ = http.NewRequest(http.MethodPut, path.Join(webConnectionsPath, "id", "bandwidth"),
+		bytes.NewBuffer([]byte(`invalid json`)))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+}
+
+func TestConnectionsStreamMock(t *testing.T) {
+	user := getTestUser()
+	c := common.NewBaseConnection("streamID", common.ProtocolSFTP, "", "", user)
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	common.Connections.Add(fakeConn)
+	defer common.Connections.Remove(c.GetID())
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, activeConnectionsPath+"/events", nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "event: connections\ndata: [")
+	assert.Contains(t, rr.Body.String(), c.GetID())
+
+	webToken, err := getJWTWebTokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	ctx, cancelWeb := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelWeb()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, webConnectionsPath+"/events", nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), c.GetID())
 }
 
 func TestGetWebStatusMock(t *testing.T) {
@@ -1002,6 +1002,29 @@ func TestUpdateContextFromCookie(t *testing.T) {
 	server.updateContextFromCookie(req.WithContext(ctx))
 }
 
+func TestDrainingRequests(t *testing.T) {
+	server := httpdServer{}
+	req, _ := http.NewRequest(http.MethodPost, userTokenPath, nil)
+	assert.True(t, server.isNewLoginRequest(req))
+	req, _ = http.NewRequest(http.MethodGet, healthzPath, nil)
+	assert.False(t, server.isNewLoginRequest(req))
+	req, _ = http.NewRequest(http.MethodGet, webStaticFilesPath+"/css/style.css", nil)
+	assert.False(t, server.isNewLoginRequest(req))
+	req, _ = http.NewRequest(http.MethodGet, userFilesPath, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	assert.False(t, server.isNewLoginRequest(req))
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	req.AddCookie(&http.Cookie{Name: "jwt", Value: "token"})
+	assert.False(t, server.isNewLoginRequest(req))
+
+	rr := httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, userTokenPath, nil)
+	server.sendServiceUnavailableResponse(rr, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, common.DrainRetryAfter, rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), common.ErrShuttingDown.Error())
+}
+
 func TestCookieExpiration(t *testing.T) {
 	server := httpdServer{
 		tokenAuth: jwtauth.New(jwa.HS256.String(), util.GenerateRandomBytes(32), nil),
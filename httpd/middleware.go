@@ -367,7 +367,7 @@ func authenticateUserWithAPIKey(username, keyID string, tokenAuth *jwtauth.JWTAu
 		updateLoginMetrics(&dataprovider.User{BaseUser: sdk.BaseUser{Username: username}}, ipAddr, err)
 		return err
 	}
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
 		return err
 	}
 	user, err := dataprovider.UserExists(username)
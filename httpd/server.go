@@ -66,7 +66,7 @@ func newHttpdServer(b Binding, staticFilesPath, signingPassphrase string, cors C
 	}
 }
 
-func (s *httpdServer) listenAndServe() error {
+func (s *httpdServer) listenAndServe(b *common.ServerBinding) error {
 	s.initializeRouter()
 	httpServer := &http.Server{
 		Handler:           s.router,
@@ -76,8 +76,15 @@ func (s *httpdServer) listenAndServe() error {
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 16, // 64KB
 		ErrorLog:          log.New(&logger.StdLoggerWrapper{Sender: logSender}, "", 0),
+		BaseContext: func(_ net.Listener) context.Context {
+			// called when the listener is ready to accept connections
+			common.SetBindingStatus(common.ProtocolHTTP, s.binding.GetAddress(), nil)
+			return context.Background()
+		},
 	}
+	isTLS := false
 	if certMgr != nil && s.binding.EnableHTTPS {
+		isTLS = true
 		config := &tls.Config{
 			GetCertificate:           certMgr.GetCertificateFunc(),
 			MinVersion:               tls.VersionTLS12,
@@ -88,14 +95,22 @@ func (s *httpdServer) listenAndServe() error {
 		logger.Debug(logSender, "", "configured TLS cipher suites for binding %#v: %v", s.binding.GetAddress(),
 			config.CipherSuites)
 		httpServer.TLSConfig = config
-		if s.binding.ClientAuthType == 1 {
+		if s.binding.isMutualTLSEnabled() {
 			httpServer.TLSConfig.ClientCAs = certMgr.GetRootCAs()
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
-		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
 	}
-	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+	if err := b.AddHTTPServer(httpServer); err != nil {
+		return nil
+	}
+	err := util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, isTLS, logSender)
+	if b.IsStopped() {
+		common.RemoveBindingStatus(common.ProtocolHTTP, s.binding.GetAddress())
+		return nil
+	}
+	common.SetBindingStatus(common.ProtocolHTTP, s.binding.GetAddress(), err)
+	return err
 }
 
 func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
@@ -155,9 +170,58 @@ func (s *httpdServer) handleClientWebLogin(w http.ResponseWriter, r *http.Reques
 		http.Redirect(w, r, webAdminSetupPath, http.StatusFound)
 		return
 	}
+	if s.binding.isMutualTLSEnabled() && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if s.handleWebClientTLSCertLogin(w, r, r.TLS.PeerCertificates[0]) {
+			return
+		}
+	}
 	s.renderClientLoginPage(w, "")
 }
 
+// handleWebClientTLSCertLogin tries to login the user identified by the given
+// TLS client certificate. It returns false, without writing a response, if the
+// certificate does not identify a user allowed to login using a certificate
+func (s *httpdServer) handleWebClientTLSCertLogin(w http.ResponseWriter, r *http.Request, tlsCert *x509.Certificate) bool {
+	ipAddr := util.GetIPFromRemoteAddress(r.RemoteAddr)
+	username := dataprovider.GetUsernameFromTLSCertificate(tlsCert, s.binding.getTLSUsername())
+	if username == "" {
+		return false
+	}
+	user, err := dataprovider.CheckUserBeforeTLSAuth(username, ipAddr, common.ProtocolHTTP, tlsCert)
+	if err != nil || !user.IsTLSUsernameVerificationEnabled() ||
+		!user.IsLoginMethodAllowed(dataprovider.LoginMethodTLSCertificate, nil) {
+		logger.Debug(logSender, "", "TLS certificate login not allowed for user %#v, fallback to password login", username)
+		return false
+	}
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+		s.renderClientLoginPage(w, fmt.Sprintf("access denied by post connect hook: %v", err))
+		return true
+	}
+	user, err = dataprovider.CheckUserAndTLSCert(username, ipAddr, common.ProtocolHTTP, tlsCert)
+	if err != nil {
+		updateLoginMetricsWithMethod(&user, ipAddr, dataprovider.LoginMethodTLSCertificate, err)
+		s.renderClientLoginPage(w, dataprovider.ErrInvalidCredentials.Error())
+		return true
+	}
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, xid.New().String())
+	if err := checkHTTPClientUser(&user, r, connectionID); err != nil {
+		updateLoginMetricsWithMethod(&user, ipAddr, dataprovider.LoginMethodTLSCertificate, err)
+		s.renderClientLoginPage(w, err.Error())
+		return true
+	}
+
+	defer user.CloseFs() //nolint:errcheck
+	err = user.CheckFsRoot(connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateLoginMetricsWithMethod(&user, ipAddr, dataprovider.LoginMethodTLSCertificate, common.ErrInternalFailure)
+		s.renderClientLoginPage(w, err.Error())
+		return true
+	}
+	s.loginUser(w, r, &user, connectionID, ipAddr, false, s.renderClientLoginPage)
+	return true
+}
+
 func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBodySize)
 
@@ -179,7 +243,7 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
 		s.renderClientLoginPage(w, fmt.Sprintf("access denied by post connect hook: %v", err))
 		return
 	}
@@ -581,7 +645,7 @@ func (s *httpdServer) loginUser(
 	err := c.createAndSetCookie(w, r, s.tokenAuth, audience)
 	if err != nil {
 		logger.Warn(logSender, connectionID, "unable to set user login cookie %v", err)
-		updateLoginMetrics(user, ipAddr, common.ErrInternalFailure)
+		updateLoginMetricsWithMethod(user, ipAddr, getHTTPClientLoginMethod(user, r), common.ErrInternalFailure)
 		errorFunc(w, err.Error())
 		return
 	}
@@ -592,7 +656,7 @@ func (s *httpdServer) loginUser(
 		http.Redirect(w, r, webClientTwoFactorPath, http.StatusFound)
 		return
 	}
-	updateLoginMetrics(user, ipAddr, err)
+	updateLoginMetricsWithMethod(user, ipAddr, getHTTPClientLoginMethod(user, r), err)
 	dataprovider.UpdateLastLogin(user)
 	http.Redirect(w, r, webClientFilesPath, http.StatusFound)
 }
@@ -655,7 +719,7 @@ func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
@@ -892,6 +956,11 @@ func (s *httpdServer) checkConnection(next http.Handler) http.Handler {
 		common.Connections.AddClientConnection(ipAddr)
 		defer common.Connections.RemoveClientConnection(ipAddr)
 
+		if common.IsDraining() && s.isNewLoginRequest(r) {
+			logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "request refused, the server is shutting down")
+			s.sendServiceUnavailableResponse(w, r)
+			return
+		}
 		if !common.Connections.IsNewConnectionAllowed(ipAddr) {
 			logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused, configured limit reached")
 			s.sendForbiddenResponse(w, r, "configured connections limit reached")
@@ -928,6 +997,37 @@ func (s *httpdServer) sendTooManyRequestResponse(w http.ResponseWriter, r *http.
 	sendAPIResponse(w, r, err, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 }
 
+// isNewLoginRequest returns true if the request does not belong to an
+// authenticated session. The health check and the static files are excluded
+func (s *httpdServer) isNewLoginRequest(r *http.Request) bool {
+	if r.URL.Path == healthzPath || !s.isStaticFileURL(r) {
+		return false
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	if _, err := r.Cookie("jwt"); err == nil {
+		return false
+	}
+	return true
+}
+
+func (s *httpdServer) sendServiceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", common.DrainRetryAfter)
+	if (s.enableWebAdmin || s.enableWebClient) && isWebRequest(r) {
+		if s.enableWebClient && (isWebClientRequest(r) || !s.enableWebAdmin) {
+			renderClientMessagePage(w, r, http.StatusText(http.StatusServiceUnavailable), "",
+				http.StatusServiceUnavailable, common.ErrShuttingDown, "")
+			return
+		}
+		renderMessagePage(w, r, http.StatusText(http.StatusServiceUnavailable), "", http.StatusServiceUnavailable,
+			common.ErrShuttingDown, "")
+		return
+	}
+	sendAPIResponse(w, r, common.ErrShuttingDown, http.StatusText(http.StatusServiceUnavailable),
+		http.StatusServiceUnavailable)
+}
+
 func (s *httpdServer) sendForbiddenResponse(w http.ResponseWriter, r *http.Request, message string) {
 	if (s.enableWebAdmin || s.enableWebClient) && isWebRequest(r) {
 		r = s.updateContextFromCookie(r)
@@ -999,9 +1099,7 @@ func (s *httpdServer) initializeRouter() {
 		sendAPIResponse(w, r, nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	}))
 
-	s.router.Get(healthzPath, func(w http.ResponseWriter, r *http.Request) {
-		render.PlainText(w, r, "ok")
-	})
+	s.router.Get(healthzPath, getHealthz)
 
 	// share API exposed to external users
 	s.router.Get(sharesPath+"/{id}", downloadFromShare)
@@ -1050,8 +1148,14 @@ func (s *httpdServer) initializeRouter() {
 				render.JSON(w, r, common.Connections.GetStats())
 			})
 
+		router.With(checkPerm(dataprovider.PermAdminViewConnections)).
+			Get(activeConnectionsPath+"/events", streamConnections)
 		router.With(checkPerm(dataprovider.PermAdminCloseConnections)).
 			Delete(activeConnectionsPath+"/{connectionID}", handleCloseConnection)
+		router.With(checkPerm(dataprovider.PermAdminCloseConnections)).
+			Delete(activeConnectionsPath+"/{connectionID}/transfers/{transferID}", handleAbortTransfer)
+		router.With(checkPerm(dataprovider.PermAdminCloseConnections)).
+			Put(activeConnectionsPath+"/{connectionID}/bandwidth", handleSetConnectionBandwidth)
 		router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotaScanPath, getUsersQuotaScans)
 		router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Get(quotasBasePath+"/users/scans", getUsersQuotaScans)
 		router.With(checkPerm(dataprovider.PermAdminQuotaScans)).Post(quotaScanPath, startUserQuotaScanCompat)
@@ -1093,6 +1197,9 @@ func (s *httpdServer) initializeRouter() {
 		router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).Get(retentionChecksPath, getRetentionChecks)
 		router.With(checkPerm(dataprovider.PermAdminRetentionChecks)).Post(retentionBasePath+"/{username}/check",
 			startRetentionCheck)
+		router.With(checkPerm(dataprovider.PermAdminViewServerStatus)).Get(dedupStatsPath, getDedupStats)
+		router.With(checkPerm(dataprovider.PermAdminViewServerStatus)).Get(configReloadPath, getConfigReloadStatus)
+		router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(configReloadPath, startConfigReload)
 		router.With(checkPerm(dataprovider.PermAdminMetadataChecks)).Get(metadataChecksPath, getMetadataChecks)
 		router.With(checkPerm(dataprovider.PermAdminMetadataChecks)).Post(metadataBasePath+"/{username}/check",
 			startMetadataCheck)
@@ -1334,6 +1441,8 @@ func (s *httpdServer) initializeRouter() {
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Post(webUserPath+"/{username}", handleWebUpdateUserPost)
 			router.With(checkPerm(dataprovider.PermAdminViewConnections), s.refreshCookie).
 				Get(webConnectionsPath, handleWebGetConnections)
+			router.With(checkPerm(dataprovider.PermAdminViewConnections)).
+				Get(webConnectionsPath+"/events", streamConnections)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers), s.refreshCookie).
 				Get(webFoldersPath, handleWebGetFolders)
 			router.With(checkPerm(dataprovider.PermAdminAddUsers), s.refreshCookie).
@@ -1354,6 +1463,10 @@ func (s *httpdServer) initializeRouter() {
 				Delete(webAdminPath+"/{username}", deleteAdmin)
 			router.With(checkPerm(dataprovider.PermAdminCloseConnections), verifyCSRFHeader).
 				Delete(webConnectionsPath+"/{connectionID}", handleCloseConnection)
+			router.With(checkPerm(dataprovider.PermAdminCloseConnections), verifyCSRFHeader).
+				Delete(webConnectionsPath+"/{connectionID}/transfers/{transferID}", handleAbortTransfer)
+			router.With(checkPerm(dataprovider.PermAdminCloseConnections), verifyCSRFHeader).
+				Put(webConnectionsPath+"/{connectionID}/bandwidth", handleSetConnectionBandwidth)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers), s.refreshCookie).
 				Get(webFolderPath+"/{name}", handleWebUpdateFolderGet)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Post(webFolderPath+"/{name}",
@@ -404,7 +404,7 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		DefenderTitle:      pageDefenderTitle,
 		Version:            version.GetAsString(),
 		LoggedAdmin:        getAdminFromToken(r),
-		HasDefender:        common.Config.DefenderConfig.Enabled,
+		HasDefender:        common.GetConfig().DefenderConfig.Enabled,
 		CSRFToken:          csrfToken,
 	}
 }
@@ -969,6 +969,19 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	config.Prefix = r.Form.Get("sftp_prefix")
 	config.DisableCouncurrentReads = len(r.Form.Get("sftp_disable_concurrent_reads")) > 0
 	config.BufferSize, err = strconv.ParseInt(r.Form.Get("sftp_buffer_size"), 10, 64)
+	if err != nil {
+		return config, err
+	}
+	config.Endpoints = getSliceFromDelimitedValues(r.Form.Get("sftp_endpoints"), "\n")
+	config.EndpointsPolicy, err = strconv.Atoi(r.Form.Get("sftp_endpoints_policy"))
+	if err != nil {
+		return config, err
+	}
+	config.MaxConnections, err = strconv.Atoi(r.Form.Get("sftp_max_connections"))
+	if err != nil {
+		return config, err
+	}
+	config.MaxSessionsPerConnection, err = strconv.Atoi(r.Form.Get("sftp_max_sessions_per_connection"))
 	return config, err
 }
 
@@ -1022,9 +1035,39 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 		}
 		fs.SFTPConfig = config
 	}
+	compression, err := getCompressionConfig(r)
+	if err != nil {
+		return fs, err
+	}
+	fs.Compression = compression
+	fs.Encryption = getEncryptionConfig(r)
 	return fs, nil
 }
 
+func getEncryptionConfig(r *http.Request) vfs.EncryptionConfig {
+	config := vfs.EncryptionConfig{}
+	config.Enabled = len(r.Form.Get("encryption_enabled")) > 0
+	if config.Enabled {
+		config.Passphrase = getSecretFromFormField(r, "encryption_passphrase")
+	}
+	return config
+}
+
+func getCompressionConfig(r *http.Request) (vfs.CompressionConfig, error) {
+	var err error
+	config := vfs.CompressionConfig{}
+	config.Enabled = len(r.Form.Get("compression_enabled")) > 0
+	if !config.Enabled {
+		return config, nil
+	}
+	config.Level, err = strconv.Atoi(r.Form.Get("compression_level"))
+	if err != nil {
+		return config, err
+	}
+	config.PhysicalQuota = len(r.Form.Get("compression_physical_quota")) > 0
+	return config, nil
+}
+
 func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	var admin dataprovider.Admin
 	err := r.ParseForm()
@@ -1061,6 +1104,7 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 
 	folder.MappedPath = replacePlaceholders(folder.MappedPath, replacements)
 	folder.Description = replacePlaceholders(folder.Description, replacements)
+	folder.LowerFolder = replacePlaceholders(folder.LowerFolder, replacements)
 	switch folder.FsConfig.Provider {
 	case sdk.CryptedFilesystemProvider:
 		folder.FsConfig.CryptConfig = getCryptFsFromTemplate(folder.FsConfig.CryptConfig, replacements)
@@ -1073,6 +1117,9 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 	case sdk.SFTPFilesystemProvider:
 		folder.FsConfig.SFTPConfig = getSFTPFsFromTemplate(folder.FsConfig.SFTPConfig, replacements)
 	}
+	if folder.FsConfig.Encryption.Enabled {
+		folder.FsConfig.Encryption.CryptFsConfig = getCryptFsFromTemplate(folder.FsConfig.Encryption.CryptFsConfig, replacements)
+	}
 
 	return folder
 }
@@ -1157,6 +1204,9 @@ func getUserFromTemplate(user dataprovider.User, template userTemplateFields) da
 	case sdk.SFTPFilesystemProvider:
 		user.FsConfig.SFTPConfig = getSFTPFsFromTemplate(user.FsConfig.SFTPConfig, replacements)
 	}
+	if user.FsConfig.Encryption.Enabled {
+		user.FsConfig.Encryption.CryptFsConfig = getCryptFsFromTemplate(user.FsConfig.Encryption.CryptFsConfig, replacements)
+	}
 
 	return user
 }
@@ -1238,7 +1288,10 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 			Description:       r.Form.Get("description"),
 		},
 		Filters: dataprovider.UserFilters{
-			BaseUserFilters: filters,
+			BaseUserFilters:     filters,
+			TLSCertFingerprints: getSliceFromDelimitedValues(r.Form.Get("tls_cert_fingerprints"), ","),
+			SSHCertPrincipals:   getSliceFromDelimitedValues(r.Form.Get("ssh_cert_principals"), ","),
+			MetricsLabel:        r.Form.Get("metrics_label"),
 		},
 		VirtualFolders: getVirtualFoldersFromPostFields(r),
 		FsConfig:       fsConfig,
@@ -1645,6 +1698,7 @@ func handleWebTemplateFolderPost(w http.ResponseWriter, r *http.Request) {
 
 	templateFolder.MappedPath = r.Form.Get("mapped_path")
 	templateFolder.Description = r.Form.Get("description")
+	templateFolder.LowerFolder = r.Form.Get("lower_folder")
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		renderMessagePage(w, r, "Error parsing folders fields", "", http.StatusBadRequest, err, "")
@@ -1850,7 +1904,7 @@ func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
 	}
 	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
 		user.FsConfig.AzBlobConfig.SASURL, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
-		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey)
+		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.Encryption.Passphrase)
 
 	err = dataprovider.UpdateUser(&updatedUser, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err == nil {
@@ -1904,6 +1958,7 @@ func handleWebAddFolderPost(w http.ResponseWriter, r *http.Request) {
 	folder.MappedPath = r.Form.Get("mapped_path")
 	folder.Name = r.Form.Get("name")
 	folder.Description = r.Form.Get("description")
+	folder.LowerFolder = r.Form.Get("lower_folder")
 	fsConfig, err := getFsConfigFromPostFields(r)
 	if err != nil {
 		renderFolderPage(w, r, folder, folderPageModeAdd, err.Error())
@@ -1968,6 +2023,7 @@ func handleWebUpdateFolderPost(w http.ResponseWriter, r *http.Request) {
 	updatedFolder := &vfs.BaseVirtualFolder{
 		MappedPath:  r.Form.Get("mapped_path"),
 		Description: r.Form.Get("description"),
+		LowerFolder: r.Form.Get("lower_folder"),
 	}
 	updatedFolder.ID = folder.ID
 	updatedFolder.Name = folder.Name
@@ -1975,7 +2031,7 @@ func handleWebUpdateFolderPost(w http.ResponseWriter, r *http.Request) {
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
 	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
 		folder.FsConfig.AzBlobConfig.SASURL, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
-		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey)
+		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.Encryption.Passphrase)
 
 	err = dataprovider.UpdateFolder(updatedFolder, folder.Users, claims.Username, util.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err != nil {
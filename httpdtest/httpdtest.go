@@ -47,6 +47,7 @@ const (
 	apiKeysPath           = "/api/v2/apikeys"
 	retentionBasePath     = "/api/v2/retention/users"
 	retentionChecksPath   = "/api/v2/retention/users/checks"
+	configReloadPath      = "/api/v2/config/reload"
 )
 
 const (
@@ -571,6 +572,36 @@ func StartRetentionCheck(username string, retention []common.FolderRetention, ex
 	return body, checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// GetConfigReloadResult returns the result of the last configuration reload
+func GetConfigReloadResult(expectedStatusCode int) (common.ConfigReloadResult, []byte, error) {
+	var result common.ConfigReloadResult
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(configReloadPath), nil, "", getDefaultToken())
+	if err != nil {
+		return result, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &result)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return result, body, err
+}
+
+// StartConfigReload starts a configuration reload
+func StartConfigReload(expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(configReloadPath), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetConnections returns status and stats for active SFTP/SCP connections
 func GetConnections(expectedStatusCode int) ([]common.ConnectionStatus, []byte, error) {
 	var connections []common.ConnectionStatus
@@ -603,6 +634,38 @@ func CloseConnection(connectionID string, expectedStatusCode int) ([]byte, error
 	return body, err
 }
 
+// AbortTransfer aborts the transfer identified by transferID for the specified connection
+func AbortTransfer(connectionID string, transferID uint64, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodDelete, buildURLRelativeToBase(activeConnectionsPath, connectionID,
+		"transfers", strconv.FormatUint(transferID, 10)), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	body, _ = getResponseBody(resp)
+	return body, err
+}
+
+// SetConnectionBandwidth sets the upload and download bandwidth limits for the specified connection
+func SetConnectionBandwidth(connectionID string, upload, download int64, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	asJSON, _ := json.Marshal(map[string]int64{
+		"upload_bandwidth":   upload,
+		"download_bandwidth": download,
+	})
+	resp, err := sendHTTPRequest(http.MethodPut, buildURLRelativeToBase(activeConnectionsPath, connectionID, "bandwidth"),
+		bytes.NewBuffer(asJSON), "application/json", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	body, _ = getResponseBody(resp)
+	return body, err
+}
+
 // AddFolder adds a new folder and checks the received HTTP Status code against expectedStatusCode
 func AddFolder(folder vfs.BaseVirtualFolder, expectedStatusCode int) (vfs.BaseVirtualFolder, []byte, error) {
 	var newFolder vfs.BaseVirtualFolder
@@ -1325,6 +1388,23 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	if expected.SFTPConfig.BufferSize != actual.SFTPConfig.BufferSize {
 		return errors.New("SFTPFs buffer_size mismatch")
 	}
+	if expected.SFTPConfig.EndpointsPolicy != actual.SFTPConfig.EndpointsPolicy {
+		return errors.New("SFTPFs endpoints_policy mismatch")
+	}
+	if expected.SFTPConfig.MaxConnections != actual.SFTPConfig.MaxConnections {
+		return errors.New("SFTPFs max_connections mismatch")
+	}
+	if expected.SFTPConfig.MaxSessionsPerConnection != actual.SFTPConfig.MaxSessionsPerConnection {
+		return errors.New("SFTPFs max_sessions_per_connection mismatch")
+	}
+	if len(expected.SFTPConfig.Endpoints) != len(actual.SFTPConfig.Endpoints) {
+		return errors.New("SFTPFs endpoints mismatch")
+	}
+	for idx, value := range actual.SFTPConfig.Endpoints {
+		if value != expected.SFTPConfig.Endpoints[idx] {
+			return errors.New("SFTPFs endpoints mismatch")
+		}
+	}
 	if err := checkEncryptedSecret(expected.SFTPConfig.Password, actual.SFTPConfig.Password); err != nil {
 		return fmt.Errorf("SFTPFs password mismatch: %v", err)
 	}
@@ -1461,6 +1541,9 @@ func compareUserFilterSubStructs(expected *dataprovider.User, actual *dataprovid
 	if expected.Filters.DisableFsChecks != actual.Filters.DisableFsChecks {
 		return errors.New("disable_fs_checks mismatch")
 	}
+	if expected.Filters.MetricsLabel != actual.Filters.MetricsLabel {
+		return errors.New("metrics label mismatch")
+	}
 	return nil
 }
 
@@ -1483,6 +1566,22 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 	if expected.Filters.TLSUsername != actual.Filters.TLSUsername {
 		return errors.New("TLSUsername mismatch")
 	}
+	if len(expected.Filters.TLSCertFingerprints) != len(actual.Filters.TLSCertFingerprints) {
+		return errors.New("TLS certificate fingerprints mismatch")
+	}
+	for _, fp := range expected.Filters.TLSCertFingerprints {
+		if !util.IsStringInSlice(fp, actual.Filters.TLSCertFingerprints) {
+			return errors.New("TLS certificate fingerprints content mismatch")
+		}
+	}
+	if len(expected.Filters.SSHCertPrincipals) != len(actual.Filters.SSHCertPrincipals) {
+		return errors.New("SSH certificate principals mismatch")
+	}
+	for _, p := range expected.Filters.SSHCertPrincipals {
+		if !util.IsStringInSlice(p, actual.Filters.SSHCertPrincipals) {
+			return errors.New("SSH certificate principals content mismatch")
+		}
+	}
 	if len(expected.Filters.WebClient) != len(actual.Filters.WebClient) {
 		return errors.New("WebClient filter mismatch")
 	}
@@ -4,6 +4,7 @@ package kms
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -112,6 +113,35 @@ func (c *Configuration) Initialize() error {
 	return nil
 }
 
+// Status defines the KMS status
+type Status struct {
+	// URL scheme for the configured secret provider
+	Scheme   string `json:"scheme"`
+	IsActive bool   `json:"is_active"`
+	Error    string `json:"error,omitempty"`
+}
+
+// GetStatus returns the KMS status. The KMS is not active if no secret
+// provider is registered for the configured URL, in this case the local
+// provider is used as fallback and the secrets encrypted using the
+// configured one cannot be decrypted
+func GetStatus() Status {
+	status := Status{
+		Scheme: config.Secrets.URL,
+	}
+	if idx := strings.Index(status.Scheme, "://"); idx >= 0 {
+		status.Scheme = status.Scheme[:idx]
+	}
+	for k := range secretProviders {
+		if strings.HasPrefix(config.Secrets.URL, k) {
+			status.IsActive = true
+			return status
+		}
+	}
+	status.Error = fmt.Sprintf("no secret provider registered for scheme %#v", status.Scheme)
+	return status
+}
+
 func (c *Configuration) newSecret(status sdkkms.SecretStatus, payload, key, data string) *Secret {
 	base := BaseSecret{
 		Status:         status,
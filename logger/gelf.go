@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	gelfVersion        = "1.1"
+	gelfChunkSize      = 8192
+	gelfChunkHeaderLen = 12
+	gelfMaxChunks      = 128
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GELFWriter sends each written message to a Graylog server using the GELF
+// format. The written messages must be JSON objects, such as the ones
+// generated by zerolog: the "message" field is used as short message and
+// the other fields are sent as additional fields.
+// Supported networks are "udp", "tcp" and "tls". UDP messages exceeding
+// the chunk size are chunked, TCP messages are null byte delimited.
+// The connection is established on first write and it is reestablished
+// if a write fails
+type GELFWriter struct {
+	mu       sync.Mutex
+	network  string
+	hostname string
+	conn     *remoteConn
+}
+
+// NewGELFWriter returns a GELFWriter for the given parameters.
+// tlsConfig is used for the "tls" network only, if nil the system
+// root CAs are used to verify the server certificate
+func NewGELFWriter(network, address string, tlsConfig *tls.Config) (*GELFWriter, error) {
+	switch network {
+	case remoteNetworkUDP, remoteNetworkTCP, remoteNetworkTLS:
+	default:
+		return nil, fmt.Errorf("unsupported GELF network %#v", network)
+	}
+	if address == "" {
+		return nil, errors.New("the GELF address is mandatory")
+	}
+	return &GELFWriter{
+		network:  network,
+		hostname: getHostname(),
+		conn:     newRemoteConn(network, address, tlsConfig),
+	}, nil
+}
+
+// Write implements the io.Writer interface
+func (w *GELFWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements the zerolog.LevelWriter interface, the GELF
+// level is the syslog severity for the given level
+func (w *GELFWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg, err := w.format(level, p)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.network == remoteNetworkUDP {
+		if err := w.writeChunked(msg); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	msg = append(msg, 0)
+	if err := w.conn.write(msg); err != nil {
+		if err = w.conn.write(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying connection, if any
+func (w *GELFWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn.close()
+}
+
+func (w *GELFWriter) format(level zerolog.Level, p []byte) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return nil, fmt.Errorf("unable to parse the log message as JSON: %w", err)
+	}
+	msg := map[string]interface{}{
+		"version":   gelfVersion,
+		"host":      w.hostname,
+		"timestamp": float64(time.Now().UnixNano()) / float64(time.Second),
+		"level":     getSyslogSeverity(level),
+	}
+	shortMessage := ""
+	for k, v := range fields {
+		switch k {
+		case zerolog.MessageFieldName:
+			shortMessage = fmt.Sprintf("%v", v)
+		case zerolog.LevelFieldName, zerolog.TimestampFieldName:
+		default:
+			if k == "id" {
+				// "_id" is reserved
+				k = "field_id"
+			}
+			switch val := v.(type) {
+			case string, float64:
+				msg["_"+k] = val
+			default:
+				msg["_"+k] = fmt.Sprintf("%v", val)
+			}
+		}
+	}
+	if shortMessage == "" {
+		// transfer and command logs have no message
+		if sender, ok := fields["sender"].(string); ok && sender != "" {
+			shortMessage = sender
+		} else {
+			shortMessage = syslogNilValue
+		}
+	}
+	msg["short_message"] = shortMessage
+	return json.Marshal(msg)
+}
+
+func (w *GELFWriter) writeChunked(msg []byte) error {
+	if len(msg) <= gelfChunkSize {
+		return w.conn.write(msg)
+	}
+	dataSize := gelfChunkSize - gelfChunkHeaderLen
+	numChunks := (len(msg) + dataSize - 1) / dataSize
+	if numChunks > gelfMaxChunks {
+		return fmt.Errorf("GELF message too large: %v bytes", len(msg))
+	}
+	msgID := make([]byte, 8)
+	if _, err := rand.Read(msgID); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, gelfChunkSize)
+	for i := 0; i < numChunks; i++ {
+		end := (i + 1) * dataSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk = chunk[:0]
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, msgID...)
+		chunk = append(chunk, byte(i), byte(numChunks))
+		chunk = append(chunk, msg[i*dataSize:end]...)
+		if err := w.conn.write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
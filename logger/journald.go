@@ -10,6 +10,7 @@ import (
 
 // InitJournalDLogger configures the logger to write to journald
 func InitJournalDLogger(level zerolog.Level) {
-	logger = zerolog.New(journald.NewJournalDWriter()).Level(level)
+	logWriter = journald.NewJournalDWriter()
+	logger = zerolog.New(logWriter).Level(level)
 	consoleLogger = zerolog.Nop()
 }
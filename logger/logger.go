@@ -11,6 +11,7 @@ package logger
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -167,28 +168,30 @@ func InitLogger(logFilePath string, logMaxSize int, logMaxBackups int, logMaxAge
 			Compress:   logCompress,
 			LocalTime:  !logUTCTime,
 		}
-		logger = zerolog.New(rollingLogger)
+		logWriter = rollingLogger
 		EnableConsoleLogger(level)
 	} else {
-		logger = zerolog.New(&logSyncWrapper{
+		logWriter = &logSyncWrapper{
 			output: os.Stdout,
-		})
+		}
 		consoleLogger = zerolog.Nop()
 	}
-	logger = logger.Level(level)
+	logger = zerolog.New(logWriter).Level(level)
 }
 
 // InitStdErrLogger configures the logger to write to stderr
 func InitStdErrLogger(level zerolog.Level) {
-	logger = zerolog.New(&logSyncWrapper{
+	logWriter = &logSyncWrapper{
 		output: os.Stderr,
-	}).Level(level)
+	}
+	logger = zerolog.New(logWriter).Level(level)
 	consoleLogger = zerolog.Nop()
 }
 
 // DisableLogger disable the main logger.
 // ConsoleLogger will not be affected
 func DisableLogger() {
+	logWriter = io.Discard
 	logger = zerolog.Nop()
 	rollingLogger = nil
 }
@@ -0,0 +1,342 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Supported remote log types
+const (
+	RemoteTypeSyslog = "syslog"
+	RemoteTypeGELF   = "gelf"
+)
+
+const (
+	remoteNetworkUDP  = "udp"
+	remoteNetworkTCP  = "tcp"
+	remoteNetworkTLS  = "tls"
+	remoteNetworkUnix = "unix"
+
+	remoteDialTimeout     = 10 * time.Second
+	remoteWriteTimeout    = 10 * time.Second
+	remoteMinRetryDelay   = 500 * time.Millisecond
+	remoteMaxRetryDelay   = 30 * time.Second
+	remoteCloseTimeout    = 5 * time.Second
+	defaultRemoteBufSize  = 1000
+	remoteDroppedInterval = time.Minute
+)
+
+var (
+	logWriter    io.Writer = os.Stdout
+	remoteWriter *bufferedWriter
+)
+
+// RemoteConfig defines the configuration to ship the logs to a remote endpoint
+type RemoteConfig struct {
+	// Remote endpoint type: "syslog" or "gelf". Empty means disabled
+	Type string
+	// Network to use: "udp", "tcp" or "tls". "unix" is supported for syslog only
+	Network string
+	// Address of the remote endpoint, for example "logs.example.com:514"
+	Address string
+	// Path to a PEM file containing the CA certificates used to verify the
+	// server certificate for the "tls" network. If empty the system root CAs
+	// are used
+	TLSCAFile string
+	// Maximum number of log messages to buffer while the remote endpoint is
+	// not reachable. New messages are dropped if the buffer is full
+	BufferSize int
+}
+
+// IsEnabled returns true if the remote logging is enabled
+func (c *RemoteConfig) IsEnabled() bool {
+	return c.Type != ""
+}
+
+func (c *RemoteConfig) getTLSConfig() (*tls.Config, error) {
+	if c.Network != remoteNetworkTLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLSCAFile != "" {
+		caCerts, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the remote log CA file: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("unable to parse the remote log CA file %#v", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return tlsConfig, nil
+}
+
+func (c *RemoteConfig) getWriter() (remoteLevelWriter, error) {
+	tlsConfig, err := c.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch c.Type {
+	case RemoteTypeSyslog:
+		return NewSyslogWriter(c.Network, c.Address, "daemon", syslogAppName, tlsConfig)
+	case RemoteTypeGELF:
+		return NewGELFWriter(c.Network, c.Address, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported remote log type %#v", c.Type)
+	}
+}
+
+// EnableRemoteLogger sends the logs to the configured remote endpoint too.
+// It must be called after the logger initialization, the log level is the
+// same used for the local logs. The logs are sent asynchronously and they
+// are buffered while the remote endpoint is not reachable
+func EnableRemoteLogger(c RemoteConfig, level zerolog.Level) error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	w, err := c.getWriter()
+	if err != nil {
+		return err
+	}
+	bufSize := c.BufferSize
+	if bufSize <= 0 {
+		bufSize = defaultRemoteBufSize
+	}
+	CloseRemoteLogger()
+	remoteWriter = newBufferedWriter(w, bufSize, "remote log")
+	logger = zerolog.New(zerolog.MultiLevelWriter(logWriter, remoteWriter)).Level(level)
+	return nil
+}
+
+// CloseRemoteLogger flushes the buffered log messages and stops sending the
+// logs to the remote endpoint
+func CloseRemoteLogger() {
+	if remoteWriter == nil {
+		return
+	}
+	logger = zerolog.New(logWriter).Level(logger.GetLevel())
+	remoteWriter.Close() //nolint:errcheck
+	remoteWriter = nil
+}
+
+type remoteLevelWriter interface {
+	zerolog.LevelWriter
+	io.Closer
+}
+
+// levelWriter adapts an io.WriteCloser to the remoteLevelWriter interface, the level is ignored
+type levelWriter struct {
+	io.WriteCloser
+}
+
+func (w levelWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	return w.Write(p)
+}
+
+type remoteMessage struct {
+	level zerolog.Level
+	data  []byte
+}
+
+// bufferedWriter sends the log messages to a remote writer from a separate
+// goroutine, so logging never blocks on network issues. Failed messages are
+// retried, with an increasing delay, until they are sent or the writer is closed
+type bufferedWriter struct {
+	name    string
+	writer  remoteLevelWriter
+	queue   chan remoteMessage
+	done    chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// NewBufferedWriter returns a writer that queues up to size messages and writes
+// them to w from a separate goroutine, so the callers never block on slow or
+// unavailable outputs. Failed writes are retried, new messages are dropped
+// if the queue is full. name is used to report the dropped messages
+func NewBufferedWriter(w io.WriteCloser, size int, name string) io.WriteCloser {
+	lw, ok := w.(remoteLevelWriter)
+	if !ok {
+		lw = levelWriter{w}
+	}
+	if size <= 0 {
+		size = defaultRemoteBufSize
+	}
+	return newBufferedWriter(lw, size, name)
+}
+
+func newBufferedWriter(w remoteLevelWriter, size int, name string) *bufferedWriter {
+	b := &bufferedWriter{
+		name:   name,
+		writer: w,
+		queue:  make(chan remoteMessage, size),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Write implements the io.Writer interface
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	return b.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements the zerolog.LevelWriter interface.
+// The message is queued and it is dropped if the queue is full
+func (b *bufferedWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+
+	select {
+	case <-b.done:
+		return 0, fmt.Errorf("%v writer closed", b.name)
+	default:
+	}
+	select {
+	case b.queue <- remoteMessage{level: level, data: data}:
+	default:
+		atomic.AddUint64(&b.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Close sends the queued messages, waiting up to 5 seconds, and closes the
+// remote writer
+func (b *bufferedWriter) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	select {
+	case <-b.closed:
+	case <-time.After(remoteCloseTimeout):
+	}
+	return b.writer.Close()
+}
+
+func (b *bufferedWriter) run() {
+	defer close(b.closed)
+
+	lastDroppedReport := time.Now()
+	for {
+		select {
+		case msg := <-b.queue:
+			if !b.send(msg) {
+				return
+			}
+		case <-b.done:
+			for {
+				select {
+				case msg := <-b.queue:
+					if _, err := b.writer.WriteLevel(msg.level, msg.data); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+		if time.Since(lastDroppedReport) > remoteDroppedInterval {
+			lastDroppedReport = time.Now()
+			if dropped := atomic.SwapUint64(&b.dropped, 0); dropped > 0 {
+				WarnToConsole("%v messages dropped, the %v buffer is full", dropped, b.name)
+			}
+		}
+	}
+}
+
+// send sends the given message retrying on errors. It returns false if
+// the writer is closed before the message can be sent
+func (b *bufferedWriter) send(msg remoteMessage) bool {
+	delay := remoteMinRetryDelay
+	for {
+		_, err := b.writer.WriteLevel(msg.level, msg.data)
+		if err == nil {
+			return true
+		}
+		select {
+		case <-b.done:
+			return false
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > remoteMaxRetryDelay {
+			delay = remoteMaxRetryDelay
+		}
+	}
+}
+
+// remoteConn is a lazily established connection to a remote endpoint,
+// it is closed on write errors and reestablished on the next write.
+// It is not safe for concurrent use
+type remoteConn struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	conn      net.Conn
+}
+
+func newRemoteConn(network, address string, tlsConfig *tls.Config) *remoteConn {
+	return &remoteConn{
+		network:   network,
+		address:   address,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (c *remoteConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: remoteDialTimeout}
+	switch c.network {
+	case remoteNetworkTLS:
+		tlsConfig := c.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		return tls.DialWithDialer(dialer, "tcp", c.address, tlsConfig)
+	case remoteNetworkUnix:
+		return dialer.Dial("unixgram", c.address)
+	default:
+		return dialer.Dial(c.network, c.address)
+	}
+}
+
+func (c *remoteConn) write(data []byte) error {
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout)); err != nil {
+		c.close() //nolint:errcheck
+		return err
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.close() //nolint:errcheck
+		return err
+	}
+	return nil
+}
+
+func (c *remoteConn) close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
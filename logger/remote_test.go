@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	c := RemoteConfig{}
+	assert.NoError(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	assert.Nil(t, remoteWriter)
+
+	c.Type = "invalid"
+	assert.Error(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	c.Type = RemoteTypeGELF
+	c.Network = remoteNetworkUnix
+	c.Address = "/dev/log"
+	assert.Error(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	c.Network = remoteNetworkUDP
+	c.Address = ""
+	assert.Error(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	c.Type = RemoteTypeSyslog
+	c.Network = remoteNetworkTLS
+	c.Address = "127.0.0.1:6514"
+	c.TLSCAFile = filepath.Join(os.TempDir(), "missing_ca.pem")
+	assert.Error(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	err := os.WriteFile(c.TLSCAFile, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	defer os.Remove(c.TLSCAFile)
+	assert.Error(t, EnableRemoteLogger(c, zerolog.DebugLevel))
+	assert.Nil(t, remoteWriter)
+}
+
+func TestGELFUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w, err := NewGELFWriter(remoteNetworkUDP, conn.LocalAddr().String(), nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	_, err = w.WriteLevel(zerolog.WarnLevel, []byte(`{"level":"warn","time":"2022-05-25T09:11:37.123","sender":"test","id":1,"message":"a message"}`))
+	require.NoError(t, err)
+	buf := make([]byte, 65535)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := make(map[string]interface{})
+	err = json.Unmarshal(buf[:n], &msg)
+	require.NoError(t, err)
+	assert.Equal(t, gelfVersion, msg["version"])
+	assert.Equal(t, "a message", msg["short_message"])
+	assert.Equal(t, float64(4), msg["level"])
+	assert.Equal(t, "test", msg["_sender"])
+	assert.Equal(t, float64(1), msg["_field_id"])
+	assert.NotContains(t, msg, "_level")
+	assert.NotContains(t, msg, "_time")
+
+	_, err = w.Write([]byte("not JSON"))
+	assert.Error(t, err)
+	// a large message must be chunked
+	large := strings.Repeat("a", 3*gelfChunkSize)
+	_, err = w.Write([]byte(`{"sender":"large","file_path":"` + large + `"}`))
+	require.NoError(t, err)
+	var chunks [][]byte
+	for i := 0; i < 4; i++ {
+		n, _, err = conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, gelfChunkSize)
+		assert.Equal(t, gelfChunkMagic, buf[:2])
+		assert.Equal(t, byte(i), buf[10])
+		assert.Equal(t, byte(4), buf[11])
+		chunks = append(chunks, append([]byte(nil), buf[gelfChunkHeaderLen:n]...))
+	}
+	msg = make(map[string]interface{})
+	err = json.Unmarshal(bytes.Join(chunks, nil), &msg)
+	require.NoError(t, err)
+	assert.Equal(t, "large", msg["short_message"])
+	assert.Equal(t, large, msg["_file_path"])
+}
+
+func TestGELFTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	messages := make(chan []byte, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			msg, err := reader.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+
+	w, err := NewGELFWriter(remoteNetworkTCP, listener.Addr().String(), nil)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(`{"level":"info","sender":"test","message":"tcp message"}`))
+	require.NoError(t, err)
+	select {
+	case msg := <-messages:
+		assert.Equal(t, byte(0), msg[len(msg)-1])
+		assert.Contains(t, string(msg), `"short_message":"tcp message"`)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "GELF message not received")
+	}
+	assert.NoError(t, w.Close())
+}
+
+func TestRemoteLoggerRetry(t *testing.T) {
+	// reserve a free port and close the listener, the endpoint is initially unreachable
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	err = listener.Close()
+	require.NoError(t, err)
+
+	logWriter = io.Discard
+	err = EnableRemoteLogger(RemoteConfig{
+		Type:       RemoteTypeSyslog,
+		Network:    remoteNetworkTCP,
+		Address:    address,
+		BufferSize: 10,
+	}, zerolog.DebugLevel)
+	require.NoError(t, err)
+	defer CloseRemoteLogger()
+
+	Warn("test_sender", "", "message while the endpoint is down")
+
+	time.Sleep(100 * time.Millisecond)
+	listener, err = net.Listen("tcp", address)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	_, err = reader.ReadString(' ')
+	require.NoError(t, err)
+	msg, err := reader.ReadString('}')
+	require.NoError(t, err)
+	// daemon facility, warning severity
+	assert.True(t, strings.HasPrefix(msg, "<28>1 "), msg)
+	assert.Contains(t, msg, "message while the endpoint is down")
+}
+
+func TestBufferedWriterDrop(t *testing.T) {
+	w, err := NewGELFWriter(remoteNetworkTCP, "127.0.0.1:1", nil)
+	require.NoError(t, err)
+	b := newBufferedWriter(w, 1, "remote log")
+	for i := 0; i < 10; i++ {
+		_, err = b.Write([]byte(`{"message":"test"}`))
+		assert.NoError(t, err)
+	}
+	assert.Greater(t, atomic.LoadUint64(&b.dropped), uint64(0))
+	assert.NoError(t, b.Close())
+	_, err = b.Write([]byte(`{"message":"test"}`))
+	assert.Error(t, err)
+}
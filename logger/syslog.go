@@ -0,0 +1,157 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	syslogSeverityInfo = 6
+	syslogNilValue     = "-"
+	syslogAppName      = "sftpgo"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// SyslogWriter sends each written message to a syslog server using the
+// RFC 5424 format. Supported networks are "udp", "tcp", "tls" and "unix",
+// for TCP and TLS the messages are framed using the octet counting method
+// defined in RFC 6587, "unix" means a datagram socket such as "/dev/log".
+// The connection is established on first write and it is reestablished
+// if a write fails
+type SyslogWriter struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+	procID   string
+	conn     *remoteConn
+}
+
+// NewSyslogWriter returns a SyslogWriter for the given parameters.
+// tlsConfig is used for the "tls" network only, if nil the system
+// root CAs are used to verify the server certificate
+func NewSyslogWriter(network, address, facility, appName string, tlsConfig *tls.Config) (*SyslogWriter, error) {
+	switch network {
+	case remoteNetworkUDP, remoteNetworkTCP, remoteNetworkTLS, remoteNetworkUnix:
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %#v", network)
+	}
+	if address == "" {
+		return nil, errors.New("the syslog address is mandatory")
+	}
+	facilityCode, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unsupported syslog facility %#v", facility)
+	}
+	if appName == "" {
+		appName = syslogAppName
+	}
+	return &SyslogWriter{
+		network:  network,
+		address:  address,
+		facility: facilityCode,
+		appName:  appName,
+		hostname: getHostname(),
+		procID:   strconv.Itoa(os.Getpid()),
+		conn:     newRemoteConn(network, address, tlsConfig),
+	}, nil
+}
+
+// Write implements the io.Writer interface, p is sent as a single message
+// with informational severity
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements the zerolog.LevelWriter interface, the syslog
+// severity is based on the given level
+func (w *SyslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	msg := w.format(getSyslogSeverity(level), bytes.TrimRight(p, "\n"))
+	if w.network == remoteNetworkTCP || w.network == remoteNetworkTLS {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.conn.write(msg); err != nil {
+		// the connection could be broken, retry once with a new connection
+		if err = w.conn.write(msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying connection, if any
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.conn.close()
+}
+
+func (w *SyslogWriter) format(severity int, msg []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s %s ", w.facility*8+severity,
+		time.Now().UTC().Format(time.RFC3339Nano), w.hostname, w.appName, w.procID, syslogNilValue, syslogNilValue)
+	b.Write(msg)
+	return b.Bytes()
+}
+
+func getSyslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.DebugLevel, zerolog.TraceLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 1
+	default:
+		return syslogSeverityInfo
+	}
+}
+
+func getHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return syslogNilValue
+	}
+	return hostname
+}
@@ -0,0 +1,62 @@
+package metric
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Supported sources for the user label
+const (
+	UserLabelUsername     = "username"
+	UserLabelMetricsLabel = "metrics_label"
+)
+
+// The reserved values for the user label use characters not allowed in usernames,
+// usernames or metrics labels matching them are reported as labelValueOther
+const (
+	// labelValueOther is used for the values exceeding the configured limit or not allowed
+	labelValueOther = "<other>"
+	// labelValueNone is used for users without a metrics label
+	labelValueNone = "<none>"
+)
+
+// Config defines the configuration for the metrics labeled by protocol and user.
+// These metrics are disabled by default, the number of time series grows with the
+// number of distinct users so the distinct label values can be limited
+type Config struct {
+	// Set to true to enable the metrics labeled by protocol and user
+	LabeledMetrics bool `json:"labeled_metrics" mapstructure:"labeled_metrics"`
+	// Source for the user label:
+	// - "username", the username is used
+	// - "metrics_label", the metrics label defined for each user is used.
+	//   Users without a metrics label are reported as "<none>"
+	UserLabel string `json:"user_label" mapstructure:"user_label"`
+	// Maximum number of distinct user label values. Once reached, new values are
+	// reported as "<other>"
+	MaxUserLabelValues int `json:"max_user_label_values" mapstructure:"max_user_label_values"`
+	// If not empty, only these user label values are reported, any other value is
+	// reported as "<other>"
+	AllowedUserLabelValues []string `json:"allowed_user_label_values" mapstructure:"allowed_user_label_values"`
+}
+
+func (c *Config) validate() error {
+	if !c.LabeledMetrics {
+		return nil
+	}
+	if c.UserLabel != UserLabelUsername && c.UserLabel != UserLabelMetricsLabel {
+		return fmt.Errorf("invalid user label %#v, supported values: %#v, %#v", c.UserLabel, UserLabelUsername,
+			UserLabelMetricsLabel)
+	}
+	if c.MaxUserLabelValues <= 0 {
+		return fmt.Errorf("invalid max user label values %v, it must be greater than 0", c.MaxUserLabelValues)
+	}
+	var allowed []string
+	for _, v := range c.AllowedUserLabelValues {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			allowed = append(allowed, v)
+		}
+	}
+	c.AllowedUserLabelValues = allowed
+	return nil
+}
@@ -0,0 +1,197 @@
+//go:build !nometrics
+// +build !nometrics
+
+package metric
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	userLabels = userLabelResolver{}
+
+	userLabelNames = []string{"protocol", "user"}
+
+	// userUploads is the metric that reports the successful uploads by protocol and user
+	userUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_uploads_total",
+		Help: "The total number of successful uploads by protocol and user",
+	}, userLabelNames)
+
+	// userDownloads is the metric that reports the successful downloads by protocol and user
+	userDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_downloads_total",
+		Help: "The total number of successful downloads by protocol and user",
+	}, userLabelNames)
+
+	// userUploadErrors is the metric that reports the upload errors by protocol and user
+	userUploadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_upload_errors_total",
+		Help: "The total number of upload errors by protocol and user",
+	}, userLabelNames)
+
+	// userDownloadErrors is the metric that reports the download errors by protocol and user
+	userDownloadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_download_errors_total",
+		Help: "The total number of download errors by protocol and user",
+	}, userLabelNames)
+
+	// userUploadSize is the metric that reports the uploads size as bytes by protocol and user
+	userUploadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_upload_size",
+		Help: "The total upload size as bytes by protocol and user, partial uploads are included",
+	}, userLabelNames)
+
+	// userDownloadSize is the metric that reports the downloads size as bytes by protocol and user
+	userDownloadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_download_size",
+		Help: "The total download size as bytes by protocol and user, partial downloads are included",
+	}, userLabelNames)
+
+	// userActiveSessions is the metric that reports the active sessions by protocol and user
+	userActiveSessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_user_active_sessions",
+		Help: "Number of active sessions by protocol and user",
+	}, userLabelNames)
+
+	// fsRequestDuration is the metric that reports the latency of the storage backend requests
+	fsRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_fs_request_duration_seconds",
+		Help:    "Duration of the storage backend requests, uploads and downloads are included",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"backend", "operation", "status"})
+)
+
+// userLabelResolver maps usernames or metrics labels to the values used for the
+// user label, the number of distinct values is limited
+type userLabelResolver struct {
+	sync.RWMutex
+	config  Config
+	allowed map[string]bool
+	values  map[string]bool
+}
+
+func (r *userLabelResolver) setConfig(c Config) {
+	r.Lock()
+	defer r.Unlock()
+
+	// the time series for the values already seen still exist after a
+	// reload, so they count against the limit unless the label source changes
+	if r.values == nil || c.UserLabel != r.config.UserLabel {
+		r.values = make(map[string]bool)
+	}
+	r.config = c
+	r.allowed = make(map[string]bool)
+	for _, v := range c.AllowedUserLabelValues {
+		r.allowed[v] = true
+	}
+}
+
+// get returns the value to use for the user label and false if labeled metrics are disabled
+func (r *userLabelResolver) get(username, metricsLabel string) (string, bool) {
+	r.RLock()
+	if !r.config.LabeledMetrics {
+		r.RUnlock()
+		return "", false
+	}
+	value := username
+	if r.config.UserLabel == UserLabelMetricsLabel {
+		value = metricsLabel
+	}
+	if value == labelValueOther || value == labelValueNone {
+		// a real value cannot be reported using a reserved one
+		r.RUnlock()
+		return labelValueOther, true
+	}
+	if value == "" {
+		value = labelValueNone
+	}
+	if len(r.allowed) > 0 && !r.allowed[value] {
+		r.RUnlock()
+		return labelValueOther, true
+	}
+	if r.values[value] {
+		r.RUnlock()
+		return value, true
+	}
+	r.RUnlock()
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.values[value] {
+		return value, true
+	}
+	if len(r.values) >= r.config.MaxUserLabelValues {
+		return labelValueOther, true
+	}
+	r.values[value] = true
+	return value, true
+}
+
+// Initialize configures the metrics labeled by protocol and user
+func Initialize(c Config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	userLabels.setConfig(c)
+	return nil
+}
+
+// UserTransferCompleted updates the metrics labeled by protocol and user after an upload or a download.
+// userLabel is the value returned by UserSessionStarted for the connection
+func UserTransferCompleted(protocol, userLabel string, bytesSent, bytesReceived int64, transferKind int, err error) {
+	if userLabel == "" {
+		return
+	}
+	if transferKind == 0 {
+		// upload
+		if err == nil {
+			userUploads.WithLabelValues(protocol, userLabel).Inc()
+		} else {
+			userUploadErrors.WithLabelValues(protocol, userLabel).Inc()
+		}
+		userUploadSize.WithLabelValues(protocol, userLabel).Add(float64(bytesReceived))
+	} else {
+		// download
+		if err == nil {
+			userDownloads.WithLabelValues(protocol, userLabel).Inc()
+		} else {
+			userDownloadErrors.WithLabelValues(protocol, userLabel).Inc()
+		}
+		userDownloadSize.WithLabelValues(protocol, userLabel).Add(float64(bytesSent))
+	}
+}
+
+// UserSessionStarted increments the active sessions for the given protocol and user.
+// It returns the value used for the user label or an empty string if the labeled
+// metrics are disabled. This value must be used for the session lifetime so the
+// metrics remain consistent even if the configuration is reloaded
+func UserSessionStarted(protocol, username, metricsLabel string) string {
+	userLabel, ok := userLabels.get(username, metricsLabel)
+	if !ok {
+		return ""
+	}
+	userActiveSessions.WithLabelValues(protocol, userLabel).Inc()
+	return userLabel
+}
+
+// UserSessionEnded decrements the active sessions for the given protocol and user label
+func UserSessionEnded(protocol, userLabel string) {
+	if userLabel != "" {
+		userActiveSessions.WithLabelValues(protocol, userLabel).Dec()
+	}
+}
+
+// FsRequestCompleted updates the latency metric for a storage backend request
+func FsRequestCompleted(backend, operation string, elapsed time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	fsRequestDuration.WithLabelValues(backend, operation, status).Observe(elapsed.Seconds())
+}
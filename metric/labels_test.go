@@ -0,0 +1,162 @@
+//go:build !nometrics
+// +build !nometrics
+
+package metric
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabeledMetricsConfig(t *testing.T) {
+	c := Config{}
+	assert.NoError(t, Initialize(c))
+	_, ok := userLabels.get("user", "")
+	assert.False(t, ok)
+
+	c.LabeledMetrics = true
+	c.UserLabel = "unknown"
+	c.MaxUserLabelValues = 10
+	assert.Error(t, Initialize(c))
+	c.UserLabel = UserLabelUsername
+	c.MaxUserLabelValues = 0
+	assert.Error(t, Initialize(c))
+	c.MaxUserLabelValues = 10
+	c.AllowedUserLabelValues = []string{" a ", "", "b"}
+	err := c.validate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, c.AllowedUserLabelValues)
+
+	assert.NoError(t, Initialize(Config{}))
+}
+
+func TestUserLabelResolver(t *testing.T) {
+	err := Initialize(Config{
+		LabeledMetrics:     true,
+		UserLabel:          UserLabelUsername,
+		MaxUserLabelValues: 2,
+	})
+	assert.NoError(t, err)
+	defer Initialize(Config{}) //nolint:errcheck
+
+	value, ok := userLabels.get("user1", "label")
+	assert.True(t, ok)
+	assert.Equal(t, "user1", value)
+	value, _ = userLabels.get("user2", "")
+	assert.Equal(t, "user2", value)
+	value, _ = userLabels.get("user3", "")
+	assert.Equal(t, labelValueOther, value)
+	// already seen values are still reported
+	value, _ = userLabels.get("user1", "")
+	assert.Equal(t, "user1", value)
+
+	err = Initialize(Config{
+		LabeledMetrics:         true,
+		UserLabel:              UserLabelMetricsLabel,
+		MaxUserLabelValues:     10,
+		AllowedUserLabelValues: []string{"tenant1", labelValueNone},
+	})
+	assert.NoError(t, err)
+	value, _ = userLabels.get("user1", "tenant1")
+	assert.Equal(t, "tenant1", value)
+	value, _ = userLabels.get("user2", "tenant2")
+	assert.Equal(t, labelValueOther, value)
+	value, _ = userLabels.get("user3", "")
+	assert.Equal(t, labelValueNone, value)
+	// real values cannot collide with the reserved ones
+	value, _ = userLabels.get("user4", labelValueNone)
+	assert.Equal(t, labelValueOther, value)
+	value, _ = userLabels.get("user5", labelValueOther)
+	assert.Equal(t, labelValueOther, value)
+
+	err = Initialize(Config{
+		LabeledMetrics:     true,
+		UserLabel:          UserLabelUsername,
+		MaxUserLabelValues: 10,
+	})
+	assert.NoError(t, err)
+	value, _ = userLabels.get(labelValueNone, "")
+	assert.Equal(t, labelValueOther, value)
+	value, _ = userLabels.get(labelValueOther, "")
+	assert.Equal(t, labelValueOther, value)
+}
+
+func TestUserLabelResolverReload(t *testing.T) {
+	c := Config{
+		LabeledMetrics:     true,
+		UserLabel:          UserLabelUsername,
+		MaxUserLabelValues: 1,
+	}
+	assert.NoError(t, Initialize(c))
+	defer Initialize(Config{}) //nolint:errcheck
+
+	value, _ := userLabels.get("user1", "")
+	assert.Equal(t, "user1", value)
+	// the values already seen still count against the limit after a reload
+	assert.NoError(t, Initialize(c))
+	value, _ = userLabels.get("user2", "")
+	assert.Equal(t, labelValueOther, value)
+	value, _ = userLabels.get("user1", "")
+	assert.Equal(t, "user1", value)
+	// changing the label source resets the seen values
+	c.UserLabel = UserLabelMetricsLabel
+	assert.NoError(t, Initialize(c))
+	value, _ = userLabels.get("user2", "label")
+	assert.Equal(t, "label", value)
+}
+
+func TestUserMetrics(t *testing.T) {
+	err := Initialize(Config{
+		LabeledMetrics:     true,
+		UserLabel:          UserLabelUsername,
+		MaxUserLabelValues: 10,
+	})
+	assert.NoError(t, err)
+	defer Initialize(Config{}) //nolint:errcheck
+
+	protocol := "SFTP"
+	username := "test_user_metrics"
+	userLabel := UserSessionStarted(protocol, username, "")
+	assert.Equal(t, username, userLabel)
+	assert.Equal(t, float64(1), testutil.ToFloat64(userActiveSessions.WithLabelValues(protocol, username)))
+	// the session ends using the label resolved when it started even if the configuration changes
+	err = Initialize(Config{
+		LabeledMetrics:     true,
+		UserLabel:          UserLabelMetricsLabel,
+		MaxUserLabelValues: 10,
+	})
+	assert.NoError(t, err)
+	UserSessionEnded(protocol, userLabel)
+	assert.Equal(t, float64(0), testutil.ToFloat64(userActiveSessions.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(userActiveSessions.WithLabelValues(protocol, labelValueNone)))
+
+	UserTransferCompleted(protocol, userLabel, 0, 100, 0, nil)
+	UserTransferCompleted(protocol, userLabel, 0, 50, 0, errors.New("upload error"))
+	UserTransferCompleted(protocol, userLabel, 200, 0, 1, nil)
+	UserTransferCompleted(protocol, userLabel, 10, 0, 1, errors.New("download error"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userUploads.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userUploadErrors.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(150), testutil.ToFloat64(userUploadSize.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userDownloads.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userDownloadErrors.WithLabelValues(protocol, username)))
+	assert.Equal(t, float64(210), testutil.ToFloat64(userDownloadSize.WithLabelValues(protocol, username)))
+
+	// labeled metrics disabled
+	assert.NoError(t, Initialize(Config{}))
+	userLabel = UserSessionStarted(protocol, username, "")
+	assert.Empty(t, userLabel)
+	assert.Equal(t, float64(0), testutil.ToFloat64(userActiveSessions.WithLabelValues(protocol, username)))
+	UserSessionEnded(protocol, userLabel)
+	UserTransferCompleted(protocol, userLabel, 0, 100, 0, nil)
+	assert.Equal(t, float64(1), testutil.ToFloat64(userUploads.WithLabelValues(protocol, username)))
+}
+
+func TestFsRequestCompleted(t *testing.T) {
+	FsRequestCompleted("s3", "head", 10*time.Millisecond, nil)
+	FsRequestCompleted("s3", "head", 20*time.Millisecond, errors.New("head error"))
+	assert.Equal(t, 2, testutil.CollectAndCount(fsRequestDuration))
+}
@@ -4,6 +4,8 @@
 package metric
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/v2/version"
@@ -49,6 +51,30 @@ func GCSDeleteObjectCompleted(err error) {}
 // GCSHeadBucketCompleted updates metrics after a GCS head bucket request terminates
 func GCSHeadBucketCompleted(err error) {}
 
+// S3HeadObjectCompleted updates metrics after a S3 head object request terminates
+func S3HeadObjectCompleted(err error) {}
+
+// GCSHeadObjectCompleted updates metrics after a GCS head object request terminates
+func GCSHeadObjectCompleted(err error) {}
+
+// AZTransferCompleted updates metrics after a Azure upload or a download
+func AZTransferCompleted(bytes int64, transferKind int, err error) {}
+
+// AZListObjectsCompleted updates metrics after a Azure list objects request terminates
+func AZListObjectsCompleted(err error) {}
+
+// AZCopyObjectCompleted updates metrics after a Azure copy object request terminates
+func AZCopyObjectCompleted(err error) {}
+
+// AZDeleteObjectCompleted updates metrics after a Azure delete object request terminates
+func AZDeleteObjectCompleted(err error) {}
+
+// AZHeadObjectCompleted updates metrics after a Azure head object request terminates
+func AZHeadObjectCompleted(err error) {}
+
+// AZHeadContainerCompleted updates metrics after a Azure head container request terminates
+func AZHeadContainerCompleted(err error) {}
+
 // SSHCommandCompleted update metrics after an SSH command terminates
 func SSHCommandCompleted(err error) {}
 
@@ -70,3 +96,23 @@ func HTTPRequestServed(status int) {}
 
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(size int) {}
+
+// Initialize configures the metrics labeled by protocol and user
+func Initialize(c Config) error {
+	return c.validate()
+}
+
+// UserTransferCompleted updates the metrics labeled by protocol and user after an upload or a download
+func UserTransferCompleted(protocol, userLabel string, bytesSent, bytesReceived int64, transferKind int, err error) {
+}
+
+// UserSessionStarted increments the active sessions for the given protocol and user
+func UserSessionStarted(protocol, username, metricsLabel string) string {
+	return ""
+}
+
+// UserSessionEnded decrements the active sessions for the given protocol and user label
+func UserSessionEnded(protocol, userLabel string) {}
+
+// FsRequestCompleted updates the latency metric for a storage backend request
+func FsRequestCompleted(backend, operation string, elapsed time.Duration, err error) {}
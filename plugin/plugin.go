@@ -2,9 +2,15 @@
 package plugin
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -205,6 +211,50 @@ func (m *Manager) validateConfigs() error {
 	return nil
 }
 
+// ValidateConfigs checks the plugins configuration without starting them.
+// The plugin executables must exist and match the configured checksum, if any
+func ValidateConfigs(configs []Config) error {
+	m := Manager{Configs: configs}
+	if err := m.validateConfigs(); err != nil {
+		return err
+	}
+	for idx, config := range configs {
+		switch config.Type {
+		case notifier.PluginName, kmsplugin.PluginName, auth.PluginName, eventsearcher.PluginName, metadata.PluginName:
+		default:
+			return fmt.Errorf("plugin %d: unsupported plugin type: %#v", idx, config.Type)
+		}
+		if err := config.checkCmd(); err != nil {
+			return fmt.Errorf("plugin %d, type %#v: %w", idx, config.Type, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) checkCmd() error {
+	cmdPath, err := exec.LookPath(c.Cmd)
+	if err != nil {
+		return fmt.Errorf("invalid command %#v: %w", c.Cmd, err)
+	}
+	if c.SHA256Sum == "" {
+		return nil
+	}
+	f, err := os.Open(cmdPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, c.SHA256Sum) {
+		return fmt.Errorf("checksum mismatch for command %#v, expected: %v, actual: %v", cmdPath, c.SHA256Sum, sum)
+	}
+	return nil
+}
+
 // HasNotifiers returns true if there is at least a notifier plugin
 func (m *Manager) HasNotifiers() bool {
 	return m.hasNotifiers
@@ -462,6 +512,53 @@ func (m *Manager) checkUserAndKeyboardInteractive(username, ip, protocol string,
 	return plugin.checkUserAndKeyboardInteractive(username, ip, protocol, userAsJSON)
 }
 
+// Status defines the status of a plugin
+type Status struct {
+	Type     string `json:"type"`
+	Cmd      string `json:"cmd"`
+	IsActive bool   `json:"is_active"`
+}
+
+// GetStatus returns the status of the running plugins.
+// A crashed plugin is not active until it is restarted
+func (m *Manager) GetStatus() []Status {
+	var result []Status
+
+	m.notifLock.RLock()
+	for _, n := range m.notifiers {
+		result = append(result, Status{Type: n.config.Type, Cmd: n.config.Cmd, IsActive: !n.exited()})
+	}
+	m.notifLock.RUnlock()
+
+	m.kmsLock.RLock()
+	for _, k := range m.kms {
+		result = append(result, Status{Type: k.config.Type, Cmd: k.config.Cmd, IsActive: !k.exited()})
+	}
+	m.kmsLock.RUnlock()
+
+	m.authLock.RLock()
+	for _, a := range m.auths {
+		result = append(result, Status{Type: a.config.Type, Cmd: a.config.Cmd, IsActive: !a.exited()})
+	}
+	m.authLock.RUnlock()
+
+	if m.hasSearcher {
+		m.searcherLock.RLock()
+		result = append(result, Status{Type: m.searcher.config.Type, Cmd: m.searcher.config.Cmd,
+			IsActive: !m.searcher.exited()})
+		m.searcherLock.RUnlock()
+	}
+
+	if m.hasMetadater {
+		m.metadaterLock.RLock()
+		result = append(result, Status{Type: m.metadater.config.Type, Cmd: m.metadater.config.Cmd,
+			IsActive: !m.metadater.exited()})
+		m.metadaterLock.RUnlock()
+	}
+
+	return result
+}
+
 func (m *Manager) checkCrashedPlugins() {
 	m.notifLock.RLock()
 	for idx, n := range m.notifiers {
@@ -0,0 +1,596 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/config"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/ftpd"
+	"github.com/drakkan/sftpgo/v2/httpclient"
+	"github.com/drakkan/sftpgo/v2/httpd"
+	"github.com/drakkan/sftpgo/v2/kms"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/metric"
+	"github.com/drakkan/sftpgo/v2/mfa"
+	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/sftpd"
+	"github.com/drakkan/sftpgo/v2/smtp"
+	"github.com/drakkan/sftpgo/v2/telemetry"
+	"github.com/drakkan/sftpgo/v2/tracing"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/webdavd"
+)
+
+// names for the servers managed by the service
+const (
+	serverSFTPD     = "sftpd"
+	serverFTPD      = "ftpd"
+	serverWebDAVD   = "webdavd"
+	serverHTTPD     = "httpd"
+	serverTelemetry = "telemetry"
+)
+
+const (
+	// time to wait for a restarted server to fail, a server still running after
+	// this time is considered successfully started
+	serverStartCheckTime = 2 * time.Second
+	serverStopTimeout    = 30 * time.Second
+)
+
+var (
+	managedServers  = []string{serverSFTPD, serverHTTPD, serverFTPD, serverWebDAVD, serverTelemetry}
+	serverProtocols = map[string]string{
+		serverSFTPD:   common.ProtocolSSH,
+		serverFTPD:    common.ProtocolFTP,
+		serverWebDAVD: common.ProtocolWebDAV,
+		serverHTTPD:   common.ProtocolHTTP,
+	}
+	serverNames = map[string]string{
+		serverSFTPD:     "SFTP",
+		serverFTPD:      "FTP",
+		serverWebDAVD:   "WebDAV",
+		serverHTTPD:     "HTTP",
+		serverTelemetry: "telemetry",
+	}
+)
+
+// managedServer tracks a server started by the service, so it can be stopped
+// and started again when its configuration changes
+type managedServer struct {
+	sync.Mutex
+	name     string
+	done     chan struct{}
+	err      error
+	exited   bool
+	stopping bool
+	// a failure while checking a restarted server is handled by the reload
+	checking bool
+}
+
+// configSnapshot contains the configuration sections that can change on reload
+type configSnapshot struct {
+	Common       common.Configuration
+	SFTPD        sftpd.Configuration
+	FTPD         ftpd.Configuration
+	WebDAVD      webdavd.Configuration
+	HTTPD        httpd.Conf
+	Telemetry    telemetry.Conf
+	ProviderConf dataprovider.Config
+	HTTPConfig   httpclient.Config
+	KMSConfig    kms.Configuration
+	MFAConfig    mfa.Config
+	Plugins      []plugin.Config
+	SMTPConfig   smtp.Config
+}
+
+func getConfigSnapshot() configSnapshot {
+	return configSnapshot{
+		Common:       config.GetCommonConfig(),
+		SFTPD:        config.GetSFTPDConfig(),
+		FTPD:         config.GetFTPDConfig(),
+		WebDAVD:      config.GetWebDAVDConfig(),
+		HTTPD:        config.GetHTTPDConfig(),
+		Telemetry:    config.GetTelemetryConfig(),
+		ProviderConf: config.GetProviderConf(),
+		HTTPConfig:   config.GetHTTPConfig(),
+		KMSConfig:    config.GetKMSConfig(),
+		MFAConfig:    config.GetMFAConfig(),
+		Plugins:      config.GetPluginsConfig(),
+		SMTPConfig:   config.GetSMTPConfig(),
+	}
+}
+
+// isConfigChanged compares the exported fields, the unexported ones are
+// populated while initializing the services
+func isConfigChanged(oldConf, newConf interface{}) bool {
+	oldJSON, err := json.Marshal(oldConf)
+	if err != nil {
+		return true
+	}
+	newJSON, err := json.Marshal(newConf)
+	if err != nil {
+		return true
+	}
+	return !bytes.Equal(oldJSON, newJSON)
+}
+
+// getTelemetryServerConfig returns the telemetry configuration without the
+// settings that do not require a server restart
+func getTelemetryServerConfig(c telemetry.Conf) telemetry.Conf {
+	c.Metrics = metric.Config{}
+	c.Tracing = tracing.Config{}
+	return c
+}
+
+func (s *Service) getServer(name string) *managedServer {
+	s.serversMu.Lock()
+	defer s.serversMu.Unlock()
+
+	if s.servers == nil {
+		s.servers = make(map[string]*managedServer)
+	}
+	srv, ok := s.servers[name]
+	if !ok {
+		srv = &managedServer{name: name}
+		s.servers[name] = srv
+	}
+	return srv
+}
+
+// getServerInitializer returns true if the server must be started using the
+// current configuration and the function to start it
+func (s *Service) getServerInitializer(name string) (bool, func() error) {
+	switch name {
+	case serverSFTPD:
+		c := config.GetSFTPDConfig()
+		return c.ShouldBind(), func() error {
+			redactedConf := c
+			redactedConf.KeyboardInteractiveHook = util.GetRedactedURL(c.KeyboardInteractiveHook)
+			logger.Info(logSender, "", "initializing SFTP server with config %+v", redactedConf)
+			return c.Initialize(s.ConfigDir)
+		}
+	case serverFTPD:
+		c := config.GetFTPDConfig()
+		return c.ShouldBind(), func() error {
+			return c.Initialize(s.ConfigDir)
+		}
+	case serverWebDAVD:
+		c := config.GetWebDAVDConfig()
+		return c.ShouldBind(), func() error {
+			return c.Initialize(s.ConfigDir)
+		}
+	case serverHTTPD:
+		c := config.GetHTTPDConfig()
+		return c.ShouldBind(), func() error {
+			return c.Initialize(s.ConfigDir)
+		}
+	case serverTelemetry:
+		c := config.GetTelemetryConfig()
+		return c.ShouldBind(), func() error {
+			return c.Initialize(s.ConfigDir)
+		}
+	default:
+		return false, nil
+	}
+}
+
+func getServerStopper(name string) func(context.Context) error {
+	switch name {
+	case serverSFTPD:
+		return sftpd.Stop
+	case serverFTPD:
+		return ftpd.Stop
+	case serverWebDAVD:
+		return webdavd.Stop
+	case serverHTTPD:
+		return httpd.Stop
+	default:
+		return telemetry.Stop
+	}
+}
+
+// startServer starts the specified server in a new goroutine. If the server
+// exits and it was not stopped by a configuration reload the service is stopped
+func (s *Service) startServer(name string, start func() error, checking bool) *managedServer {
+	srv := s.getServer(name)
+	srv.Lock()
+	srv.done = make(chan struct{})
+	srv.err = nil
+	srv.exited = false
+	srv.stopping = false
+	srv.checking = checking
+	done := srv.done
+	srv.Unlock()
+
+	go func() {
+		err := start()
+
+		srv.Lock()
+		srv.err = err
+		srv.exited = true
+		handledByReload := srv.stopping || srv.checking
+		srv.Unlock()
+		close(done)
+
+		if handledByReload {
+			return
+		}
+		if err != nil {
+			logger.Error(logSender, "", "could not start %v server: %v", serverNames[name], err)
+			logger.ErrorToConsole("could not start %v server: %v", serverNames[name], err)
+			s.Error = err
+		}
+		s.Shutdown <- true
+	}()
+	return srv
+}
+
+// stopServer stops the specified server. If the server already exited, for
+// example because a binding failed, the bindings still running are stopped
+func (s *Service) stopServer(name string) error {
+	srv := s.getServer(name)
+	srv.Lock()
+	if srv.done == nil {
+		srv.Unlock()
+		return nil
+	}
+	srv.stopping = true
+	done := srv.done
+	srv.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverStopTimeout)
+	defer cancel()
+
+	if err := getServerStopper(name)(ctx); err != nil {
+		return fmt.Errorf("unable to stop the %v server: %w", serverNames[name], err)
+	}
+	select {
+	case <-done:
+		if protocol, ok := serverProtocols[name]; ok {
+			common.RemoveBindingsStatus(protocol)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("unable to stop the %v server: %w", serverNames[name], ctx.Err())
+	}
+}
+
+// restartServer starts the server using the current configuration and checks
+// that it does not fail immediately, for example because a port is in use
+func (s *Service) restartServer(name string) error {
+	shouldBind, start := s.getServerInitializer(name)
+	if !shouldBind {
+		return nil
+	}
+	srv := s.startServer(name, start, true)
+
+	select {
+	case <-srv.done:
+	case <-time.After(serverStartCheckTime):
+	}
+
+	srv.Lock()
+	defer srv.Unlock()
+
+	srv.checking = false
+	if srv.exited {
+		if srv.err == nil {
+			return fmt.Errorf("the %v server exited", serverNames[name])
+		}
+		return srv.err
+	}
+	return nil
+}
+
+// isServerRunning returns true if the specified server was started and did not exit
+func (s *Service) isServerRunning(name string) bool {
+	srv := s.getServer(name)
+	srv.Lock()
+	defer srv.Unlock()
+
+	return srv.done != nil && !srv.exited && !srv.stopping
+}
+
+// getBindingsReloader returns a function to reload the bindings of the specified
+// server if only the bindings changed and the new configuration has at least a
+// valid binding, nil otherwise
+func getBindingsReloader(name string, oldConf, newConf *configSnapshot) func(context.Context) error {
+	switch name {
+	case serverSFTPD:
+		oldServerConf, newServerConf := oldConf.SFTPD, newConf.SFTPD
+		oldServerConf.Bindings, newServerConf.Bindings = nil, nil
+		if !newConf.SFTPD.ShouldBind() || isConfigChanged(oldServerConf, newServerConf) {
+			return nil
+		}
+		return func(ctx context.Context) error {
+			return sftpd.ReloadBindings(ctx, newConf.SFTPD.Bindings)
+		}
+	case serverFTPD:
+		oldServerConf, newServerConf := oldConf.FTPD, newConf.FTPD
+		oldServerConf.Bindings, newServerConf.Bindings = nil, nil
+		if !newConf.FTPD.ShouldBind() || isConfigChanged(oldServerConf, newServerConf) {
+			return nil
+		}
+		return func(ctx context.Context) error {
+			return ftpd.ReloadBindings(ctx, newConf.FTPD.Bindings)
+		}
+	case serverWebDAVD:
+		oldServerConf, newServerConf := oldConf.WebDAVD, newConf.WebDAVD
+		oldServerConf.Bindings, newServerConf.Bindings = nil, nil
+		if !newConf.WebDAVD.ShouldBind() || isConfigChanged(oldServerConf, newServerConf) {
+			return nil
+		}
+		return func(ctx context.Context) error {
+			return webdavd.ReloadBindings(ctx, newConf.WebDAVD.Bindings)
+		}
+	case serverHTTPD:
+		oldServerConf, newServerConf := oldConf.HTTPD, newConf.HTTPD
+		oldServerConf.Bindings, newServerConf.Bindings = nil, nil
+		if !newConf.HTTPD.ShouldBind() || isConfigChanged(oldServerConf, newServerConf) {
+			return nil
+		}
+		return func(ctx context.Context) error {
+			return httpd.ReloadBindings(ctx, newConf.HTTPD.Bindings)
+		}
+	default:
+		return nil
+	}
+}
+
+// restoreServerConfig sets the previous configuration for the specified server
+func restoreServerConfig(name string, oldConf *configSnapshot) {
+	switch name {
+	case serverSFTPD:
+		config.SetSFTPDConfig(oldConf.SFTPD)
+	case serverFTPD:
+		config.SetFTPDConfig(oldConf.FTPD)
+	case serverWebDAVD:
+		config.SetWebDAVDConfig(oldConf.WebDAVD)
+	case serverHTTPD:
+		config.SetHTTPDConfig(oldConf.HTTPD)
+	case serverTelemetry:
+		newConf := config.GetTelemetryConfig()
+		oldServerConf := oldConf.Telemetry
+		oldServerConf.Metrics = newConf.Metrics
+		oldServerConf.Tracing = newConf.Tracing
+		config.SetTelemetryConfig(oldServerConf)
+	}
+}
+
+// reloadBindings restarts only the changed bindings of a running server, the
+// other bindings are not affected. It returns false if a full restart is required
+func (s *Service) reloadBindings(name string, oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) bool {
+	reloader := getBindingsReloader(name, oldConf, newConf)
+	if reloader == nil || !s.isServerRunning(name) {
+		return false
+	}
+	logger.Info(logSender, "", "restarting the changed %v server bindings", serverNames[name])
+	ctx, cancel := context.WithTimeout(context.Background(), serverStopTimeout)
+	defer cancel()
+
+	if err := reloader(ctx); err != nil {
+		logger.Warn(logSender, "", "unable to apply the new %v server bindings: %v", serverNames[name], err)
+		result.AddError(fmt.Errorf("unable to apply the new %v server bindings, the previous ones are restored: %w",
+			serverNames[name], err))
+		restoreServerConfig(name, oldConf)
+		return true
+	}
+	result.Restarted = append(result.Restarted, name)
+	return true
+}
+
+func (s *Service) reloadServer(name string, oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) {
+	if s.reloadBindings(name, oldConf, newConf, result) {
+		return
+	}
+	logger.Info(logSender, "", "restarting %v server, the configuration changed", serverNames[name])
+	if err := s.stopServer(name); err != nil {
+		logger.Warn(logSender, "", "%v", err)
+		result.AddError(err)
+		return
+	}
+	err := s.restartServer(name)
+	if err == nil {
+		result.Restarted = append(result.Restarted, name)
+		return
+	}
+	logger.Warn(logSender, "", "unable to restart %v server with the new configuration: %v", serverNames[name], err)
+	result.AddError(fmt.Errorf("unable to restart %v server with the new configuration, the previous one is restored: %w",
+		serverNames[name], err))
+	// some bindings could be running
+	if err := s.stopServer(name); err != nil {
+		logger.Warn(logSender, "", "%v", err)
+	}
+	restoreServerConfig(name, oldConf)
+	if err := s.restartServer(name); err != nil {
+		logger.Error(logSender, "", "unable to restart %v server with the previous configuration: %v", serverNames[name], err)
+		result.AddError(fmt.Errorf("unable to restart %v server with the previous configuration: %w", serverNames[name], err))
+	}
+}
+
+func (s *Service) isServerChanged(name string, oldConf, newConf *configSnapshot) bool {
+	switch name {
+	case serverSFTPD:
+		return isConfigChanged(oldConf.SFTPD, newConf.SFTPD)
+	case serverFTPD:
+		return isConfigChanged(oldConf.FTPD, newConf.FTPD)
+	case serverWebDAVD:
+		return isConfigChanged(oldConf.WebDAVD, newConf.WebDAVD)
+	case serverHTTPD:
+		return isConfigChanged(oldConf.HTTPD, newConf.HTTPD)
+	case serverTelemetry:
+		return isConfigChanged(getTelemetryServerConfig(oldConf.Telemetry), getTelemetryServerConfig(newConf.Telemetry))
+	default:
+		return false
+	}
+}
+
+func (s *Service) reloadCommonConfig(oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) {
+	if !isConfigChanged(oldConf.Common, newConf.Common) {
+		return
+	}
+	if err := common.Reload(newConf.Common); err != nil {
+		logger.Warn(logSender, "", "unable to apply the new common configuration: %v", err)
+		result.AddError(fmt.Errorf("unable to apply the new common configuration, the previous one is preserved: %w", err))
+		config.SetCommonConfig(oldConf.Common)
+		return
+	}
+	result.Reloaded = append(result.Reloaded, "common")
+}
+
+func (s *Service) reloadSMTPConfig(oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) {
+	if !isConfigChanged(oldConf.SMTPConfig, newConf.SMTPConfig) {
+		return
+	}
+	if err := newConf.SMTPConfig.Initialize(s.ConfigDir); err != nil {
+		logger.Warn(logSender, "", "unable to apply the new SMTP configuration: %v", err)
+		result.AddError(fmt.Errorf("unable to apply the new SMTP configuration, the previous one is restored: %w", err))
+		if err := oldConf.SMTPConfig.Initialize(s.ConfigDir); err != nil {
+			logger.Error(logSender, "", "unable to restore the previous SMTP configuration: %v", err)
+		}
+		return
+	}
+	result.Reloaded = append(result.Reloaded, "smtp")
+}
+
+func (s *Service) reloadHTTPClientConfig(oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) {
+	if !isConfigChanged(oldConf.HTTPConfig, newConf.HTTPConfig) {
+		return
+	}
+	if err := newConf.HTTPConfig.Initialize(s.ConfigDir); err != nil {
+		logger.Warn(logSender, "", "unable to apply the new HTTP client configuration: %v", err)
+		result.AddError(fmt.Errorf("unable to apply the new HTTP client configuration, the previous one is restored: %w", err))
+		if err := oldConf.HTTPConfig.Initialize(s.ConfigDir); err != nil {
+			logger.Error(logSender, "", "unable to restore the previous HTTP client configuration: %v", err)
+		}
+		return
+	}
+	result.Reloaded = append(result.Reloaded, "http")
+}
+
+func (s *Service) reloadObservabilityConfig(oldConf, newConf *configSnapshot, result *common.ConfigReloadResult) {
+	if isConfigChanged(oldConf.Telemetry.Metrics, newConf.Telemetry.Metrics) {
+		if err := metric.Initialize(newConf.Telemetry.Metrics); err != nil {
+			logger.Warn(logSender, "", "unable to apply the new metrics configuration: %v", err)
+			result.AddError(fmt.Errorf("unable to apply the new metrics configuration: %w", err))
+		} else {
+			result.Reloaded = append(result.Reloaded, "telemetry.metrics")
+		}
+	}
+	if isConfigChanged(oldConf.Telemetry.Tracing, newConf.Telemetry.Tracing) {
+		if err := tracing.Initialize(newConf.Telemetry.Tracing); err != nil {
+			logger.Warn(logSender, "", "unable to apply the new tracing configuration: %v", err)
+			result.AddError(fmt.Errorf("unable to apply the new tracing configuration: %w", err))
+		} else {
+			result.Reloaded = append(result.Reloaded, "telemetry.tracing")
+		}
+	}
+}
+
+func getSectionsRequiringRestart(oldConf, newConf *configSnapshot) []string {
+	var sections []string
+
+	if isConfigChanged(oldConf.ProviderConf, newConf.ProviderConf) {
+		sections = append(sections, "data_provider")
+	}
+	if isConfigChanged(oldConf.KMSConfig, newConf.KMSConfig) {
+		sections = append(sections, "kms")
+	}
+	if isConfigChanged(oldConf.MFAConfig, newConf.MFAConfig) {
+		sections = append(sections, "mfa")
+	}
+	if isConfigChanged(oldConf.Plugins, newConf.Plugins) {
+		sections = append(sections, "plugins")
+	}
+	return sections
+}
+
+// reloadConfig reloads the configuration file. The protocol servers whose
+// configuration changed are restarted, only the changed bindings are restarted
+// if the other settings are unchanged. The established connections are not
+// closed, the other servers keep running. Certificates, host keys and the
+// defender lists are reloaded for all the servers
+func (s *Service) reloadConfig() common.ConfigReloadResult {
+	logger.Debug(logSender, "", "Received reload request")
+	var result common.ConfigReloadResult
+
+	if s.PortableMode != 1 {
+		oldConf := getConfigSnapshot()
+		if err := config.ReloadConfig(s.ConfigDir, s.ConfigFile); err != nil {
+			logger.Warn(logSender, "", "unable to reload the configuration, the current one is preserved: %v", err)
+			result.AddError(fmt.Errorf("unable to reload the configuration, the current one is preserved: %w", err))
+		} else {
+			newConf := getConfigSnapshot()
+			s.reloadCommonConfig(&oldConf, &newConf, &result)
+			s.reloadSMTPConfig(&oldConf, &newConf, &result)
+			s.reloadHTTPClientConfig(&oldConf, &newConf, &result)
+			s.reloadObservabilityConfig(&oldConf, &newConf, &result)
+			result.RestartRequired = getSectionsRequiringRestart(&oldConf, &newConf)
+			if len(result.RestartRequired) > 0 {
+				logger.Warn(logSender, "", "configuration sections %v changed, they will be applied after a restart",
+					result.RestartRequired)
+			}
+			for _, name := range managedServers {
+				if s.isServerChanged(name, &oldConf, &newConf) {
+					s.reloadServer(name, &oldConf, &newConf, &result)
+				}
+			}
+		}
+	}
+
+	reloadServices(&result)
+	logger.Info(logSender, "", "configuration reload completed, reloaded: %v, restarted servers: %v, restart required: %v, "+
+		"errors: %v", result.Reloaded, result.Restarted, result.RestartRequired, result.Errors)
+	return result
+}
+
+// reloadServices reloads certificates, host keys, lists and the configuration
+// that the running services read from files
+func reloadServices(result *common.ConfigReloadResult) {
+	err := dataprovider.ReloadConfig()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
+		result.AddError(fmt.Errorf("error reloading dataprovider configuration: %w", err))
+	}
+	err = httpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading cert manager: %v", err)
+		result.AddError(fmt.Errorf("error reloading HTTPD cert manager: %w", err))
+	}
+	err = ftpd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
+		result.AddError(fmt.Errorf("error reloading FTPD cert manager: %w", err))
+	}
+	err = webdavd.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
+		result.AddError(fmt.Errorf("error reloading WebDAV cert manager: %w", err))
+	}
+	err = telemetry.ReloadCertificateMgr()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
+		result.AddError(fmt.Errorf("error reloading telemetry cert manager: %w", err))
+	}
+	err = common.ReloadDefender()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
+		result.AddError(fmt.Errorf("error reloading defender's lists: %w", err))
+	}
+	err = sftpd.ReloadRevokedUserCerts()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading revoked user certs: %v", err)
+		result.AddError(fmt.Errorf("error reloading revoked user certs: %w", err))
+	}
+	err = sftpd.ReloadHostKeys()
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading SFTPD host keys: %v", err)
+		result.AddError(fmt.Errorf("error reloading SFTPD host keys: %w", err))
+	}
+}
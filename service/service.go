@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -14,7 +16,9 @@ import (
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/httpd"
 	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/plugin"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/version"
 )
@@ -40,15 +44,18 @@ type Service struct {
 	LogCompress       bool
 	LogVerbose        bool
 	LogUTCTime        bool
+	LogRemote         logger.RemoteConfig
 	LoadDataClean     bool
 	LoadDataFrom      string
 	LoadDataMode      int
 	LoadDataQuotaScan int
 	Shutdown          chan bool
 	Error             error
+	serversMu         sync.Mutex
+	servers           map[string]*managedServer
 }
 
-func (s *Service) initLogger() {
+func (s *Service) initLogger() error {
 	logLevel := zerolog.DebugLevel
 	if !s.LogVerbose {
 		logLevel = zerolog.InfoLevel
@@ -63,14 +70,25 @@ func (s *Service) initLogger() {
 			logger.DisableLogger()
 		}
 	}
+	if s.LogRemote.TLSCAFile != "" && !filepath.IsAbs(s.LogRemote.TLSCAFile) {
+		s.LogRemote.TLSCAFile = filepath.Join(s.ConfigDir, s.LogRemote.TLSCAFile)
+	}
+	if err := logger.EnableRemoteLogger(s.LogRemote, logLevel); err != nil {
+		logger.ErrorToConsole("unable to enable remote logging: %v", err)
+		return err
+	}
+	return nil
 }
 
 // Start initializes the service
 func (s *Service) Start() error {
-	s.initLogger()
+	if err := s.initLogger(); err != nil {
+		return err
+	}
 	logger.Info(logSender, "", "starting SFTPGo %v, config dir: %v, config file: %v, log max size: %v log max backups: %v "+
-		"log max age: %v log verbose: %v, log compress: %v, log utc time: %v, load data from: %#v", version.GetAsString(), s.ConfigDir, s.ConfigFile,
-		s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose, s.LogCompress, s.LogUTCTime, s.LoadDataFrom)
+		"log max age: %v log verbose: %v, log compress: %v, log utc time: %v, remote log type: %#v, load data from: %#v",
+		version.GetAsString(), s.ConfigDir, s.ConfigFile, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose,
+		s.LogCompress, s.LogUTCTime, s.LogRemote.Type, s.LoadDataFrom)
 	// in portable mode we don't read configuration from file
 	if s.PortableMode != 1 {
 		err := config.LoadConfig(s.ConfigDir, s.ConfigFile)
@@ -86,7 +104,19 @@ func (s *Service) Start() error {
 		return errors.New(infoString)
 	}
 
-	err := common.Initialize(config.GetCommonConfig())
+	err := tracing.Initialize(config.GetTelemetryConfig().Tracing)
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize tracing: %v", err)
+		logger.ErrorToConsole("unable to initialize tracing: %v", err)
+		os.Exit(1)
+	}
+	err = metric.Initialize(config.GetTelemetryConfig().Metrics)
+	if err != nil {
+		logger.Error(logSender, "", "unable to initialize metrics: %v", err)
+		logger.ErrorToConsole("unable to initialize metrics: %v", err)
+		os.Exit(1)
+	}
+	err = common.Initialize(config.GetCommonConfig())
 	if err != nil {
 		logger.Error(logSender, "", "%v", err)
 		logger.ErrorToConsole("%v", err)
@@ -151,87 +181,37 @@ func (s *Service) Start() error {
 		return err
 	}
 
+	common.SetConfigReloadHandler(s.reloadConfig)
 	s.startServices()
-	go common.Config.ExecuteStartupHook() //nolint:errcheck
+	go common.GetConfig().ExecuteStartupHook() //nolint:errcheck
 
 	return nil
 }
 
 func (s *Service) startServices() {
-	sftpdConf := config.GetSFTPDConfig()
-	ftpdConf := config.GetFTPDConfig()
-	httpdConf := config.GetHTTPDConfig()
-	webDavDConf := config.GetWebDAVDConfig()
-	telemetryConf := config.GetTelemetryConfig()
-
-	if sftpdConf.ShouldBind() {
-		go func() {
-			redactedConf := sftpdConf
-			redactedConf.KeyboardInteractiveHook = util.GetRedactedURL(sftpdConf.KeyboardInteractiveHook)
-			logger.Info(logSender, "", "initializing SFTP server with config %+v", redactedConf)
-			if err := sftpdConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start SFTP server: %v", err)
-				logger.ErrorToConsole("could not start SFTP server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
-		}()
-	} else {
-		logger.Info(logSender, "", "SFTP server not started, disabled in config file")
-	}
-
-	if httpdConf.ShouldBind() {
-		go func() {
-			if err := httpdConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start HTTP server: %v", err)
-				logger.ErrorToConsole("could not start HTTP server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
-		}()
-	} else {
-		logger.Info(logSender, "", "HTTP server not started, disabled in config file")
-		if s.PortableMode != 1 {
-			logger.InfoToConsole("HTTP server not started, disabled in config file")
+	for _, name := range managedServers {
+		shouldBind, start := s.getServerInitializer(name)
+		if shouldBind {
+			s.startServer(name, start, false)
+			continue
 		}
-	}
-	if ftpdConf.ShouldBind() {
-		go func() {
-			if err := ftpdConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start FTP server: %v", err)
-				logger.ErrorToConsole("could not start FTP server: %v", err)
-				s.Error = err
+		switch name {
+		case serverSFTPD:
+			logger.Info(logSender, "", "SFTP server not started, disabled in config file")
+		case serverHTTPD:
+			logger.Info(logSender, "", "HTTP server not started, disabled in config file")
+			if s.PortableMode != 1 {
+				logger.InfoToConsole("HTTP server not started, disabled in config file")
 			}
-			s.Shutdown <- true
-		}()
-	} else {
-		logger.Info(logSender, "", "FTP server not started, disabled in config file")
-	}
-	if webDavDConf.ShouldBind() {
-		go func() {
-			if err := webDavDConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start WebDAV server: %v", err)
-				logger.ErrorToConsole("could not start WebDAV server: %v", err)
-				s.Error = err
+		case serverFTPD:
+			logger.Info(logSender, "", "FTP server not started, disabled in config file")
+		case serverWebDAVD:
+			logger.Info(logSender, "", "WebDAV server not started, disabled in config file")
+		case serverTelemetry:
+			logger.Info(logSender, "", "telemetry server not started, disabled in config file")
+			if s.PortableMode != 1 {
+				logger.InfoToConsole("telemetry server not started, disabled in config file")
 			}
-			s.Shutdown <- true
-		}()
-	} else {
-		logger.Info(logSender, "", "WebDAV server not started, disabled in config file")
-	}
-	if telemetryConf.ShouldBind() {
-		go func() {
-			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
-				logger.Error(logSender, "", "could not start telemetry server: %v", err)
-				logger.ErrorToConsole("could not start telemetry server: %v", err)
-				s.Error = err
-			}
-			s.Shutdown <- true
-		}()
-	} else {
-		logger.Info(logSender, "", "telemetry server not started, disabled in config file")
-		if s.PortableMode != 1 {
-			logger.InfoToConsole("telemetry server not started, disabled in config file")
 		}
 	}
 }
@@ -246,10 +226,24 @@ func (s *Service) Wait() {
 
 // Stop terminates the service unblocking the Wait method
 func (s *Service) Stop() {
+	drainConnections()
 	close(s.Shutdown)
 	logger.Debug(logSender, "", "Service stopped")
 }
 
+// drainConnections refuses new logins and waits for the active transfers
+// up to the configured graceful shutdown timeout
+func drainConnections() {
+	common.DrainConnections(time.Duration(common.GetConfig().GracefulShutdownTimeout) * time.Second)
+}
+
+func exitProcess() {
+	plugin.Handler.Cleanup()
+	tracing.Shutdown()
+	logger.CloseRemoteLogger()
+	os.Exit(0)
+}
+
 func (s *Service) loadInitialData() error {
 	if s.LoadDataFrom == "" {
 		return nil
@@ -12,13 +12,9 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/drakkan/sftpgo/v2/common"
-	"github.com/drakkan/sftpgo/v2/dataprovider"
-	"github.com/drakkan/sftpgo/v2/ftpd"
-	"github.com/drakkan/sftpgo/v2/httpd"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/plugin"
-	"github.com/drakkan/sftpgo/v2/telemetry"
-	"github.com/drakkan/sftpgo/v2/webdavd"
+	"github.com/drakkan/sftpgo/v2/tracing"
 )
 
 const (
@@ -110,36 +106,16 @@ loop:
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
 			logger.Debug(logSender, "", "Received service stop request")
-			changes <- svc.Status{State: svc.StopPending}
+			changes <- svc.Status{State: svc.StopPending, WaitHint: getDrainWaitHint()}
 			wasStopped <- true
 			s.Service.Stop()
 			plugin.Handler.Cleanup()
+			tracing.Shutdown()
+			logger.CloseRemoteLogger()
 			break loop
 		case svc.ParamChange:
-			logger.Debug(logSender, "", "Received reload request")
-			err := dataprovider.ReloadConfig()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
-			}
-			err = httpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading cert manager: %v", err)
-			}
-			err = ftpd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
-			}
-			err = webdavd.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
-			}
-			err = telemetry.ReloadCertificateMgr()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
-			}
-			err = common.ReloadDefender()
-			if err != nil {
-				logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
+			if err := common.RequestConfigReload(); err != nil {
+				logger.Warn(logSender, "", "unable to reload the configuration: %v", err)
 			}
 		case rotateLogCmd:
 			logger.Debug(logSender, "", "Received log file rotation request")
@@ -373,3 +349,8 @@ func (s *WindowsService) Status() (Status, error) {
 func (s *WindowsService) getExePath() (string, error) {
 	return os.Executable()
 }
+
+// getDrainWaitHint returns the max time, in milliseconds, that drainConnections could take
+func getDrainWaitHint() uint32 {
+	return uint32(common.GetConfig().GracefulShutdownTimeout*1000) + uint32(2*common.DrainAbortTimeout/time.Millisecond)
+}
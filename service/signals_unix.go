@@ -9,13 +9,7 @@ import (
 	"syscall"
 
 	"github.com/drakkan/sftpgo/v2/common"
-	"github.com/drakkan/sftpgo/v2/dataprovider"
-	"github.com/drakkan/sftpgo/v2/ftpd"
-	"github.com/drakkan/sftpgo/v2/httpd"
 	"github.com/drakkan/sftpgo/v2/logger"
-	"github.com/drakkan/sftpgo/v2/plugin"
-	"github.com/drakkan/sftpgo/v2/telemetry"
-	"github.com/drakkan/sftpgo/v2/webdavd"
 )
 
 func registerSignals() {
@@ -36,30 +30,8 @@ func registerSignals() {
 }
 
 func handleSIGHUP() {
-	logger.Debug(logSender, "", "Received reload request")
-	err := dataprovider.ReloadConfig()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
-	}
-	err = httpd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading cert manager: %v", err)
-	}
-	err = ftpd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading FTPD cert manager: %v", err)
-	}
-	err = webdavd.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading WebDAV cert manager: %v", err)
-	}
-	err = telemetry.ReloadCertificateMgr()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading telemetry cert manager: %v", err)
-	}
-	err = common.ReloadDefender()
-	if err != nil {
-		logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
+	if err := common.RequestConfigReload(); err != nil {
+		logger.Warn(logSender, "", "unable to reload the configuration: %v", err)
 	}
 }
 
@@ -73,6 +45,12 @@ func handleSIGUSR1() {
 
 func handleInterrupt() {
 	logger.Debug(logSender, "", "Received interrupt request")
-	plugin.Handler.Cleanup()
-	os.Exit(0)
+	if common.IsDraining() {
+		logger.Info(logSender, "", "interrupt received while draining, exiting immediately")
+		exitProcess()
+	}
+	go func() {
+		drainConnections()
+		exitProcess()
+	}()
 }
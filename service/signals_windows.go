@@ -4,8 +4,8 @@ import (
 	"os"
 	"os/signal"
 
+	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/logger"
-	"github.com/drakkan/sftpgo/v2/plugin"
 )
 
 func registerSignals() {
@@ -14,8 +14,14 @@ func registerSignals() {
 	go func() {
 		for range c {
 			logger.Debug(logSender, "", "Received interrupt request")
-			plugin.Handler.Cleanup()
-			os.Exit(0)
+			if common.IsDraining() {
+				logger.Info(logSender, "", "interrupt received while draining, exiting immediately")
+				exitProcess()
+			}
+			go func() {
+				drainConnections()
+				exitProcess()
+			}()
 		}
 	}()
 }
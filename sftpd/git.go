@@ -0,0 +1,800 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/version"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+const (
+	gitReceivePackCmdName = "git-receive-pack"
+	gitUploadPackCmdName  = "git-upload-pack"
+	gitMaxPktLength       = 65520
+	gitSideBandMaxLength  = 1000
+	gitSideBandData       = 1
+	gitSideBandError      = 3
+)
+
+var (
+	gitZeroID                = strings.Repeat("0", 40)
+	errGitProtocol           = errors.New("git protocol error")
+	errGitNotARepository     = errors.New("not a git repository")
+	errGitShallowUnsupported = errors.New("shallow clones are not supported")
+)
+
+// gitCommands defines the git commands implemented in-process
+var gitCommands = []string{gitReceivePackCmdName, gitUploadPackCmdName}
+
+// gitRefUpdate is a reference update requested by the client
+type gitRefUpdate struct {
+	oldID string
+	newID string
+	name  string
+	err   string
+}
+
+// gitCommand implements the server side of the git smart protocol, version 0,
+// on top of the user's filesystems
+type gitCommand struct {
+	sshCommand
+	repoPath   string
+	repo       *gitRepository
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	advertised bool
+	sideBand   int
+}
+
+func (c *gitCommand) handle() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handle git command: %#v stack strace: %v", r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+	}()
+	common.Connections.Add(c.connection)
+	defer common.Connections.Remove(c.connection.GetID())
+
+	c.connection.UpdateLastActivity()
+	c.connection.Log(logger.LevelDebug, "handle git command %#v, args: %v user: %v", c.command, c.args,
+		c.connection.User.Username)
+	c.reader = bufio.NewReaderSize(c.connection.channel, 65536)
+	c.writer = bufio.NewWriterSize(c.connection.channel, 65536)
+	err = c.run()
+	if err != nil {
+		c.sendError(err)
+	}
+	c.sendExitStatus(err)
+	return err
+}
+
+func (c *gitCommand) run() error {
+	if len(c.args) != 1 {
+		return fmt.Errorf("usage: %v <repository path>", c.command)
+	}
+	c.repoPath = c.getDestPath()
+	if c.repoPath != "/" {
+		c.repoPath = strings.TrimSuffix(c.repoPath, "/")
+	}
+	perm := dataprovider.PermGitRead
+	if c.command == gitReceivePackCmdName {
+		perm = dataprovider.PermGitPush
+	}
+	if !c.connection.User.HasPerm(perm, c.repoPath) {
+		return common.ErrPermissionDenied
+	}
+	if c.connection.User.HasVirtualFoldersInside(c.repoPath) {
+		c.connection.Log(logger.LevelDebug, "git repository %#v contains virtual folders", c.repoPath)
+		return common.ErrOpUnsupported
+	}
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(c.repoPath)
+	if err != nil {
+		return err
+	}
+	c.repo = newGitRepository(fs, fsPath, c.connection.User.GetUID(), c.connection.User.GetGID())
+	defer c.repo.close()
+
+	if c.command == gitReceivePackCmdName {
+		return c.handleReceivePack()
+	}
+	return c.handleUploadPack()
+}
+
+// sendError reports an error to the client, how the error is sent depends
+// on the protocol phase
+func (c *gitCommand) sendError(err error) {
+	msg := err.Error()
+	if errors.Is(err, errGitNotFound) {
+		msg = "object not found"
+	}
+	switch {
+	case !c.advertised:
+		c.writePacket(fmt.Sprintf("ERR %v: %v\n", c.repoPath, msg)) //nolint:errcheck
+	case c.sideBand > 0:
+		c.writeSideBand(gitSideBandError, []byte(fmt.Sprintf("%v: %v\n", c.command, msg))) //nolint:errcheck
+	default:
+		if channel, ok := c.connection.channel.(ssh.Channel); ok {
+			channel.Stderr().Write([]byte(fmt.Sprintf("%v: %v\n", c.command, msg))) //nolint:errcheck
+		}
+	}
+	c.writer.Flush() //nolint:errcheck
+}
+
+func (c *gitCommand) writePacket(data string) error {
+	if len(data)+4 > gitMaxPktLength {
+		return fmt.Errorf("%w: packet too large", errGitProtocol)
+	}
+	_, err := c.writer.WriteString(fmt.Sprintf("%04x%v", len(data)+4, data))
+	return err
+}
+
+func (c *gitCommand) writeFlush() error {
+	if _, err := c.writer.WriteString("0000"); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+func (c *gitCommand) writeSideBand(band byte, data []byte) error {
+	// c.sideBand is the max packet length, the band byte and the length header are included
+	maxLength := c.sideBand - 5
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLength {
+			n = maxLength
+		}
+		if _, err := c.writer.WriteString(fmt.Sprintf("%04x", n+5)); err != nil {
+			return err
+		}
+		if err := c.writer.WriteByte(band); err != nil {
+			return err
+		}
+		if _, err := c.writer.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// readPacket reads a pkt-line, a nil slice is returned for a flush packet
+func (c *gitCommand) readPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid packet length %#v", errGitProtocol, string(header))
+	}
+	c.connection.UpdateLastActivity()
+	if length == 0 {
+		return nil, nil
+	}
+	if length < 4 {
+		return nil, fmt.Errorf("%w: invalid packet length %v", errGitProtocol, length)
+	}
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+func (c *gitCommand) getAgent() string {
+	return "agent=sftpgo/" + version.Get().Version
+}
+
+// advertiseRefs sends the references to the client, the capabilities are
+// sent after the first one
+func (c *gitCommand) advertiseRefs(refs map[string]string, lines []string, capabilities string) error {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%v %v", refs[name], name))
+		if c.command == gitUploadPackCmdName && strings.HasPrefix(name, "refs/tags/") {
+			if peeled := c.peelTag(refs[name]); peeled != "" {
+				lines = append(lines, fmt.Sprintf("%v %v^{}", peeled, name))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("%v capabilities^{}", gitZeroID))
+	}
+	lines[0] += "\x00" + capabilities
+	for _, line := range lines {
+		if err := c.writePacket(line + "\n"); err != nil {
+			return err
+		}
+	}
+	c.advertised = true
+	return c.writeFlush()
+}
+
+// peelTag returns the object pointed by an annotated tag or an empty string
+// if id is not an annotated tag
+func (c *gitCommand) peelTag(id string) string {
+	peeled := ""
+	for i := 0; i < 10; i++ {
+		objectType, data, err := c.repo.readObject(id)
+		if err != nil || objectType != gitObjectTag {
+			return peeled
+		}
+		id = parseGitTagObject(data)
+		if !isGitObjectID(id) {
+			return ""
+		}
+		peeled = id
+	}
+	return peeled
+}
+
+func (c *gitCommand) handleUploadPack() error {
+	if !c.repo.exists() {
+		return errGitNotARepository
+	}
+	unlock := c.repo.lockRefs()
+	refs, err := c.repo.getRefs()
+	unlock()
+	if err != nil {
+		return err
+	}
+	var lines []string
+	capabilities := "side-band side-band-64k"
+	if head := c.repo.getHead(); refs[head] != "" {
+		lines = append(lines, fmt.Sprintf("%v HEAD", refs[head]))
+		capabilities += " symref=HEAD:" + head
+	}
+	capabilities += " " + c.getAgent()
+	if err := c.advertiseRefs(refs, lines, capabilities); err != nil {
+		return err
+	}
+	allowed := make(map[string]bool)
+	for name, id := range refs {
+		allowed[id] = true
+		if strings.HasPrefix(name, "refs/tags/") {
+			if peeled := c.peelTag(id); peeled != "" {
+				allowed[peeled] = true
+			}
+		}
+	}
+	wants, err := c.readWants(allowed)
+	if err != nil || len(wants) == 0 {
+		return err
+	}
+	haves, err := c.negotiate()
+	if err != nil {
+		return err
+	}
+	ids, err := c.getObjectsToSend(wants, haves)
+	if err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "git upload pack, wants: %v, common objects: %v, objects to send: %v",
+		len(wants), len(haves), len(ids))
+	return c.sendPack(ids)
+}
+
+func (c *gitCommand) readWants(allowed map[string]bool) ([]string, error) {
+	var wants []string
+	for {
+		pkt, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if pkt == nil {
+			return wants, nil
+		}
+		line := string(pkt)
+		if !strings.HasPrefix(line, "want ") {
+			if strings.HasPrefix(line, "shallow ") || strings.HasPrefix(line, "deepen") {
+				return nil, errGitShallowUnsupported
+			}
+			return nil, fmt.Errorf("%w: unexpected line %#v", errGitProtocol, line)
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "want "))
+		if len(fields) == 0 || !allowed[fields[0]] {
+			return nil, errors.New("upload-pack: not our ref")
+		}
+		if len(wants) == 0 {
+			for _, capability := range fields[1:] {
+				switch capability {
+				case "side-band-64k":
+					c.sideBand = gitMaxPktLength
+				case "side-band":
+					if c.sideBand == 0 {
+						c.sideBand = gitSideBandMaxLength
+					}
+				}
+			}
+		}
+		wants = append(wants, fields[0])
+	}
+}
+
+// negotiate reads the objects the client already has, multi_ack is not
+// supported so only the first common object is acknowledged
+func (c *gitCommand) negotiate() ([]string, error) {
+	var haves []string
+	for {
+		pkt, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		line := string(pkt)
+		switch {
+		case pkt == nil:
+			if len(haves) == 0 {
+				if err := c.writePacket("NAK\n"); err != nil {
+					return nil, err
+				}
+			}
+			if err := c.writer.Flush(); err != nil {
+				return nil, err
+			}
+		case line == "done":
+			if len(haves) == 0 {
+				if err := c.writePacket("NAK\n"); err != nil {
+					return nil, err
+				}
+			}
+			return haves, c.writer.Flush()
+		case strings.HasPrefix(line, "have "):
+			id := strings.TrimPrefix(line, "have ")
+			if isGitObjectID(id) && c.repo.hasObject(id) {
+				if len(haves) == 0 {
+					if err := c.writePacket(fmt.Sprintf("ACK %v\n", id)); err != nil {
+						return nil, err
+					}
+				}
+				haves = append(haves, id)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected line %#v", errGitProtocol, line)
+		}
+	}
+}
+
+// getObjectsToSend returns the objects reachable from wants and not
+// reachable from the common commits
+func (c *gitCommand) getObjectsToSend(wants, haves []string) ([]string, error) {
+	excluded := make(map[string]bool)
+	if err := c.excludeCommonObjects(haves, excluded); err != nil {
+		return nil, err
+	}
+	var result []string
+	stack := append([]string(nil), wants...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if excluded[id] {
+			continue
+		}
+		excluded[id] = true
+		result = append(result, id)
+		objectType, data, err := c.repo.readObject(id)
+		if err != nil {
+			return nil, err
+		}
+		switch objectType {
+		case gitObjectCommit:
+			tree, parents := parseGitCommitObject(data)
+			stack = append(stack, parents...)
+			stack = append(stack, tree)
+		case gitObjectTree:
+			trees, blobs, err := parseGitTreeObject(data)
+			if err != nil {
+				return nil, err
+			}
+			for _, blob := range blobs {
+				if !excluded[blob] {
+					excluded[blob] = true
+					result = append(result, blob)
+				}
+			}
+			stack = append(stack, trees...)
+		case gitObjectTag:
+			stack = append(stack, parseGitTagObject(data))
+		}
+		c.connection.UpdateLastActivity()
+	}
+	return result, nil
+}
+
+// excludeCommonObjects marks as excluded the commits reachable from the common
+// objects and the trees for the common commits
+func (c *gitCommand) excludeCommonObjects(haves []string, excluded map[string]bool) error {
+	var trees []string
+	stack := append([]string(nil), haves...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if excluded[id] {
+			continue
+		}
+		excluded[id] = true
+		objectType, data, err := c.repo.readObject(id)
+		if err != nil {
+			return err
+		}
+		if objectType == gitObjectCommit {
+			tree, parents := parseGitCommitObject(data)
+			if util.IsStringInSlice(id, haves) {
+				trees = append(trees, tree)
+			}
+			stack = append(stack, parents...)
+		}
+	}
+	for len(trees) > 0 {
+		id := trees[len(trees)-1]
+		trees = trees[:len(trees)-1]
+		if excluded[id] {
+			continue
+		}
+		excluded[id] = true
+		_, data, err := c.repo.readObject(id)
+		if err != nil {
+			return err
+		}
+		subTrees, blobs, err := parseGitTreeObject(data)
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			excluded[blob] = true
+		}
+		trees = append(trees, subTrees...)
+	}
+	return nil
+}
+
+type gitSideBandWriter struct {
+	c *gitCommand
+}
+
+func (w *gitSideBandWriter) Write(p []byte) (int, error) {
+	if err := w.c.writeSideBand(gitSideBandData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *gitCommand) sendPack(ids []string) error {
+	var w io.Writer = c.writer
+	if c.sideBand > 0 {
+		w = &gitSideBandWriter{c: c}
+	}
+	readObject := func(id string) (int, []byte, error) {
+		c.connection.UpdateLastActivity()
+		return c.repo.readObject(id)
+	}
+	if err := writeGitPack(w, ids, readObject); err != nil {
+		return err
+	}
+	if c.sideBand > 0 {
+		return c.writeFlush()
+	}
+	return c.writer.Flush()
+}
+
+func (c *gitCommand) handleReceivePack() error {
+	needsInit := false
+	if !c.repo.exists() {
+		if !c.repo.isEmptyDir() {
+			return errGitNotARepository
+		}
+		needsInit = true
+	}
+	unlock := c.repo.lockRefs()
+	refs, err := c.repo.getRefs()
+	unlock()
+	if err != nil {
+		return err
+	}
+	capabilities := "report-status delete-refs ofs-delta no-thin " + c.getAgent()
+	if err := c.advertiseRefs(refs, nil, capabilities); err != nil {
+		return err
+	}
+	updates, reportStatus, err := c.readRefUpdates()
+	if err != nil || len(updates) == 0 {
+		return err
+	}
+	unpackErr := c.receivePack(updates, needsInit)
+	if unpackErr == nil {
+		unpackErr = c.updateRefs(updates)
+	}
+	if reportStatus {
+		if err := c.sendReport(updates, unpackErr); err != nil {
+			return err
+		}
+	}
+	if unpackErr != nil {
+		return unpackErr
+	}
+	for _, update := range updates {
+		if update.err != "" {
+			return fmt.Errorf("unable to update ref %#v: %v", update.name, update.err)
+		}
+	}
+	return nil
+}
+
+func (c *gitCommand) readRefUpdates() ([]*gitRefUpdate, bool, error) {
+	var updates []*gitRefUpdate
+	reportStatus := false
+	for {
+		pkt, err := c.readPacket()
+		if err != nil {
+			return nil, false, err
+		}
+		if pkt == nil {
+			return updates, reportStatus, nil
+		}
+		line := string(pkt)
+		if idx := strings.IndexByte(line, 0); idx >= 0 {
+			reportStatus = util.IsStringInSlice("report-status", strings.Fields(line[idx+1:]))
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !isGitObjectID(fields[0]) || !isGitObjectID(fields[1]) {
+			return nil, false, fmt.Errorf("%w: invalid command %#v", errGitProtocol, line)
+		}
+		updates = append(updates, &gitRefUpdate{
+			oldID: fields[0],
+			newID: fields[1],
+			name:  fields[2],
+		})
+	}
+}
+
+// receivePack reads the pack sent by the client, if any, and stores it inside the repository
+func (c *gitCommand) receivePack(updates []*gitRefUpdate, needsInit bool) error {
+	hasPack := false
+	for _, update := range updates {
+		if update.newID != gitZeroID {
+			hasPack = true
+		}
+	}
+	if !hasPack {
+		return nil
+	}
+	quotaResult := c.connection.HasSpace(true, false, path.Join(c.repoPath, "objects", "pack", "pack"))
+	if !quotaResult.HasSpace {
+		return common.ErrQuotaExceeded
+	}
+	maxSize, err := c.connection.GetMaxWriteSize(quotaResult, false, 0, c.repo.fs.IsUploadResumeSupported())
+	if err != nil {
+		return err
+	}
+	pack, err := receiveGitPack(c.reader, vfs.GetTempPath(), maxSize, c.repo.readObjectByID)
+	if err != nil {
+		return err
+	}
+	defer pack.close()
+
+	if needsInit {
+		if err := c.repo.init(); err != nil {
+			return err
+		}
+		c.connection.Log(logger.LevelInfo, "git repository %#v initialized", c.repoPath)
+	}
+	if len(pack.entries) == 0 {
+		return nil
+	}
+	size, err := c.repo.storePack(pack)
+	if err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "git pack stored, objects: %v, size: %v", len(pack.entries), size)
+	c.updateQuota(c.repoPath, 2, size)
+	return nil
+}
+
+// updateRefs applies the requested updates, the failed ones have the err field set.
+// The references could be changed by a concurrent push after they were advertised,
+// so they are read again while holding the repository lock and each update is
+// applied only if the reference still has the value the client expects
+func (c *gitCommand) updateRefs(updates []*gitRefUpdate) error {
+	unlock := c.repo.lockRefs()
+	defer unlock()
+
+	refs, err := c.repo.getRefs()
+	if err != nil {
+		return err
+	}
+	numFiles := 0
+	for _, update := range updates {
+		current := refs[update.name]
+		if current == "" {
+			current = gitZeroID
+		}
+		switch {
+		case !isGitRefNameValid(update.name):
+			update.err = "funny refname"
+		case update.oldID != current:
+			update.err = "stale info"
+		case update.newID == gitZeroID:
+			if current == gitZeroID {
+				update.err = "no such ref"
+				continue
+			}
+			removed, err := c.repo.deleteRef(update.name)
+			if err != nil {
+				update.err = c.connection.GetFsError(c.repo.fs, err).Error()
+				continue
+			}
+			if removed {
+				numFiles--
+			}
+			delete(refs, update.name)
+		case !c.repo.hasObject(update.newID):
+			update.err = "missing necessary objects"
+		default:
+			isNew, err := c.repo.updateRef(update.name, update.newID)
+			if err != nil {
+				update.err = c.connection.GetFsError(c.repo.fs, err).Error()
+				continue
+			}
+			if isNew {
+				numFiles++
+			}
+			refs[update.name] = update.newID
+		}
+	}
+	if numFiles != 0 {
+		c.updateQuota(c.repoPath, numFiles, int64(numFiles*(len(gitZeroID)+1)))
+	}
+	return c.updateHead(updates, refs)
+}
+
+// updateHead makes HEAD point to a pushed branch if the current target does not exist
+func (c *gitCommand) updateHead(updates []*gitRefUpdate, refs map[string]string) error {
+	head := c.repo.getHead()
+	if head != "" && refs[head] != "" {
+		return nil
+	}
+	candidates := []string{"refs/heads/main", "refs/heads/master"}
+	for _, update := range updates {
+		if update.err == "" && update.newID != gitZeroID && strings.HasPrefix(update.name, "refs/heads/") {
+			candidates = append(candidates, update.name)
+		}
+	}
+	for _, name := range candidates {
+		if refs[name] != "" {
+			if name == head {
+				return nil
+			}
+			c.connection.Log(logger.LevelDebug, "setting HEAD for git repository %#v to %#v", c.repoPath, name)
+			return c.repo.setHead(name)
+		}
+	}
+	return nil
+}
+
+func (c *gitCommand) sendReport(updates []*gitRefUpdate, unpackErr error) error {
+	if unpackErr != nil {
+		if err := c.writePacket(fmt.Sprintf("unpack %v\n", c.getErrorMessage(unpackErr))); err != nil {
+			return err
+		}
+	} else if err := c.writePacket("unpack ok\n"); err != nil {
+		return err
+	}
+	for _, update := range updates {
+		var line string
+		switch {
+		case unpackErr != nil:
+			line = fmt.Sprintf("ng %v unpacker error\n", update.name)
+		case update.err != "":
+			line = fmt.Sprintf("ng %v %v\n", update.name, update.err)
+		default:
+			line = fmt.Sprintf("ok %v\n", update.name)
+		}
+		if err := c.writePacket(line); err != nil {
+			return err
+		}
+	}
+	return c.writeFlush()
+}
+
+func (c *gitCommand) getErrorMessage(err error) string {
+	if errors.Is(err, errGitNotFound) {
+		return "missing necessary objects"
+	}
+	return strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// isGitRefNameValid returns true if name is a valid, fully qualified, reference name
+func isGitRefNameValid(name string) bool {
+	if !strings.HasPrefix(name, "refs/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") ||
+		strings.HasSuffix(name, ".") {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") {
+			return false
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseGitCommitObject returns the tree and the parents for a commit
+func parseGitCommitObject(data []byte) (string, []string) {
+	var tree string
+	var parents []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "tree ") {
+			tree = strings.TrimPrefix(line, "tree ")
+		} else if strings.HasPrefix(line, "parent ") {
+			parents = append(parents, strings.TrimPrefix(line, "parent "))
+		}
+	}
+	return tree, parents
+}
+
+// parseGitTagObject returns the object an annotated tag points to
+func parseGitTagObject(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "object ") {
+			return strings.TrimPrefix(line, "object ")
+		}
+	}
+	return ""
+}
+
+// parseGitTreeObject returns the sub trees and the blobs for a tree,
+// submodules are skipped
+func parseGitTreeObject(data []byte) ([]string, []string, error) {
+	var trees, blobs []string
+	for len(data) > 0 {
+		spaceIdx := bytes.IndexByte(data, ' ')
+		nullIdx := bytes.IndexByte(data, 0)
+		if spaceIdx <= 0 || nullIdx < spaceIdx || len(data) < nullIdx+1+gitIDLength {
+			return nil, nil, fmt.Errorf("%w: invalid tree object", errGitInvalidPack)
+		}
+		mode := string(data[:spaceIdx])
+		id := hex.EncodeToString(data[nullIdx+1 : nullIdx+1+gitIDLength])
+		data = data[nullIdx+1+gitIDLength:]
+		switch mode {
+		case "40000":
+			trees = append(trees, id)
+		case "160000":
+		default:
+			blobs = append(blobs, id)
+		}
+	}
+	return trees, blobs, nil
+}
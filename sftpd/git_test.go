@@ -0,0 +1,441 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+// gitTestPackBuilder builds packs for testing, deltas included
+type gitTestPackBuilder struct {
+	buf   bytes.Buffer
+	count uint32
+}
+
+func (b *gitTestPackBuilder) add(objectType int, size int64, prefix, data []byte) int64 {
+	offset := int64(b.buf.Len()) + 12
+	writeGitPackEntryHeader(&b.buf, objectType, size) //nolint:errcheck
+	b.buf.Write(prefix)
+	zw := zlib.NewWriter(&b.buf)
+	zw.Write(data) //nolint:errcheck
+	zw.Close()
+	b.count++
+	return offset
+}
+
+func (b *gitTestPackBuilder) addObject(objectType int, data []byte) int64 {
+	return b.add(objectType, int64(len(data)), nil, data)
+}
+
+func (b *gitTestPackBuilder) addOfsDelta(entryOffset, baseOffset int64, delta []byte) int64 {
+	n := entryOffset - baseOffset
+	encoded := []byte{byte(n & 0x7f)}
+	n >>= 7
+	for n > 0 {
+		n--
+		encoded = append([]byte{byte(0x80 | (n & 0x7f))}, encoded...)
+		n >>= 7
+	}
+	return b.add(gitObjectOfsDelta, int64(len(delta)), encoded, delta)
+}
+
+func (b *gitTestPackBuilder) addRefDelta(baseID string, delta []byte) int64 {
+	rawID, _ := hex.DecodeString(baseID)
+	return b.add(gitObjectRefDelta, int64(len(delta)), rawID, delta)
+}
+
+func (b *gitTestPackBuilder) nextOffset() int64 {
+	return int64(b.buf.Len()) + 12
+}
+
+func (b *gitTestPackBuilder) bytes() []byte {
+	var pack bytes.Buffer
+	pack.Write(gitPackSignature)
+	binary.Write(&pack, binary.BigEndian, uint32(2)) //nolint:errcheck
+	binary.Write(&pack, binary.BigEndian, b.count)   //nolint:errcheck
+	pack.Write(b.buf.Bytes())
+	sum := sha1.Sum(pack.Bytes()) //nolint:gosec
+	pack.Write(sum[:])
+	return pack.Bytes()
+}
+
+// getGitTestDelta returns a delta that copies the first copySize bytes of
+// base and then appends insert
+func getGitTestDelta(base []byte, copySize int, insert string) []byte {
+	delta := []byte{byte(len(base)), byte(copySize + len(insert)), 0x80 | 0x10, byte(copySize)}
+	delta = append(delta, byte(len(insert)))
+	return append(delta, insert...)
+}
+
+func getGitTestLookup(objects map[string][]byte) func(id []byte) (int, []byte, error) {
+	return func(id []byte) (int, []byte, error) {
+		if data, ok := objects[hex.EncodeToString(id)]; ok {
+			return gitObjectBlob, data, nil
+		}
+		return 0, nil, errGitNotFound
+	}
+}
+
+func TestGitApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	result, err := applyGitDelta(base, getGitTestDelta(base, 6, "git"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello git", string(result))
+	// a zero size copy means 0x10000 bytes
+	_, err = applyGitDelta(base, []byte{11, 5, 0x80})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta([]byte("hello"), getGitTestDelta(base, 6, "git"))
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta(base, []byte{11, 5, 0x80 | 0x01 | 0x10, 10, 5})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta(base, []byte{11, 1, 0})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta(base, []byte{11, 3, 5, 'a'})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta(base, []byte{11, 3, 1, 'a'})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = applyGitDelta(base, []byte{0x80})
+	assert.ErrorIs(t, err, errGitInvalidPack)
+}
+
+func TestGitPackRoundTrip(t *testing.T) {
+	objects := map[string][]byte{
+		getGitObjectID(gitObjectBlob, []byte("first")):                     []byte("first"),
+		getGitObjectID(gitObjectBlob, bytes.Repeat([]byte("second"), 1e4)): bytes.Repeat([]byte("second"), 1e4),
+		getGitObjectID(gitObjectBlob, nil):                                 nil,
+	}
+	var ids []string
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	var buf bytes.Buffer
+	err := writeGitPack(&buf, ids, func(id string) (int, []byte, error) {
+		return gitObjectBlob, objects[id], nil
+	})
+	require.NoError(t, err)
+	packData := buf.Bytes()
+
+	pack, err := receiveGitPack(bufio.NewReader(bytes.NewReader(packData)), t.TempDir(), 0, getGitTestLookup(nil))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(packData)), pack.size)
+	assert.Equal(t, packData[len(packData)-gitIDLength:], pack.checksum)
+	require.Len(t, pack.entries, len(ids))
+	for idx, entry := range pack.entries {
+		assert.Equal(t, ids[idx], hex.EncodeToString(entry.id))
+	}
+	index, err := parseGitPackIndex(marshalGitPackIndex(pack.entries, pack.checksum))
+	require.NoError(t, err)
+	assert.Equal(t, len(ids), index.count())
+	reader := newGitPackReader(pack.file, getGitTestLookup(nil))
+	for id, data := range objects {
+		rawID, _ := hex.DecodeString(id)
+		offset, ok := index.find(rawID)
+		if assert.True(t, ok) {
+			objectType, content, err := reader.readObjectAt(offset)
+			assert.NoError(t, err)
+			assert.Equal(t, gitObjectBlob, objectType)
+			assert.Equal(t, len(data), len(content))
+		}
+	}
+	_, ok := index.find(make([]byte, gitIDLength))
+	assert.False(t, ok)
+	pack.close()
+	assert.NoFileExists(t, pack.file.Name())
+
+	_, err = receiveGitPack(bufio.NewReader(bytes.NewReader(packData)), t.TempDir(), 100, getGitTestLookup(nil))
+	assert.ErrorIs(t, err, errGitPackTooLarge)
+	corrupted := append([]byte(nil), packData...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, err = receiveGitPack(bufio.NewReader(bytes.NewReader(corrupted)), t.TempDir(), 0, getGitTestLookup(nil))
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	corrupted = append([]byte(nil), packData...)
+	corrupted[0] = 'X'
+	_, err = receiveGitPack(bufio.NewReader(bytes.NewReader(corrupted)), t.TempDir(), 0, getGitTestLookup(nil))
+	assert.ErrorIs(t, err, errGitInvalidPack)
+	_, err = parseGitPackIndex(packData)
+	assert.Error(t, err)
+}
+
+func TestGitPackDeltas(t *testing.T) {
+	base := []byte("base object content")
+	baseID := getGitObjectID(gitObjectBlob, base)
+	external := []byte("external object")
+	externalID := getGitObjectID(gitObjectBlob, external)
+
+	builder := &gitTestPackBuilder{}
+	baseOffset := builder.addObject(gitObjectBlob, base)
+	ofsDelta := getGitTestDelta(base, 4, " ofs")
+	ofsID := getGitObjectID(gitObjectBlob, []byte("base ofs"))
+	// the REF_DELTA base is a deltified object stored later in the pack
+	refDelta := getGitTestDelta([]byte("base ofs"), 4, " ref")
+	builder.addRefDelta(ofsID, refDelta)
+	builder.addOfsDelta(builder.nextOffset(), baseOffset, ofsDelta)
+	// thin pack, the base is not inside the pack
+	builder.addRefDelta(externalID, getGitTestDelta(external, 8, " thin"))
+	packData := builder.bytes()
+
+	pack, err := receiveGitPack(bufio.NewReader(bytes.NewReader(packData)), t.TempDir(), 0,
+		getGitTestLookup(map[string][]byte{externalID: external}))
+	require.NoError(t, err)
+	defer pack.close()
+
+	expected := []string{baseID, getGitObjectID(gitObjectBlob, []byte("base ref")), ofsID,
+		getGitObjectID(gitObjectBlob, []byte("external thin"))}
+	require.Len(t, pack.entries, len(expected))
+	for idx, entry := range pack.entries {
+		assert.Equal(t, expected[idx], hex.EncodeToString(entry.id))
+		assert.Equal(t, gitObjectBlob, entry.objectType)
+	}
+	// the external base is missing
+	_, err = receiveGitPack(bufio.NewReader(bytes.NewReader(packData)), t.TempDir(), 0, getGitTestLookup(nil))
+	assert.ErrorIs(t, err, errGitInvalidPack)
+}
+
+func TestGitRepository(t *testing.T) {
+	rootDir := t.TempDir()
+	fs := vfs.NewOsFs("", rootDir, "")
+	repo := newGitRepository(fs, filepath.Join(rootDir, "repo"), -1, -1)
+	defer repo.close()
+
+	assert.False(t, repo.exists())
+	assert.True(t, repo.isEmptyDir())
+	require.NoError(t, repo.init())
+	assert.True(t, repo.exists())
+	assert.False(t, repo.isEmptyDir())
+	assert.Equal(t, gitDefaultHead, repo.getHead())
+
+	blob := []byte("blob content")
+	blobID := getGitObjectID(gitObjectBlob, blob)
+	assert.False(t, repo.hasObject(blobID))
+	var buf bytes.Buffer
+	err := writeGitPack(&buf, []string{blobID}, func(id string) (int, []byte, error) {
+		return gitObjectBlob, blob, nil
+	})
+	require.NoError(t, err)
+	pack, err := receiveGitPack(bufio.NewReader(&buf), "", 0, repo.readObjectByID)
+	require.NoError(t, err)
+	size, err := repo.storePack(pack)
+	pack.close()
+	require.NoError(t, err)
+	assert.Greater(t, size, pack.size)
+	assert.True(t, repo.hasObject(blobID))
+	// a new repository instance must load the stored pack
+	repo1 := newGitRepository(fs, repo.fsPath, -1, -1)
+	objectType, data, err := repo1.readObject(blobID)
+	assert.NoError(t, err)
+	assert.Equal(t, gitObjectBlob, objectType)
+	assert.Equal(t, blob, data)
+	repo1.close()
+
+	// loose object
+	loose := []byte("loose content")
+	looseID := getGitObjectID(gitObjectBlob, loose)
+	var looseData bytes.Buffer
+	zw := zlib.NewWriter(&looseData)
+	fmt.Fprintf(zw, "blob %d\x00%s", len(loose), loose)
+	zw.Close()
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.fsPath, "objects", looseID[:2]), os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.fsPath, "objects", looseID[:2], looseID[2:]), looseData.Bytes(),
+		os.ModePerm))
+	assert.True(t, repo.hasObject(looseID))
+	objectType, data, err = repo.readObject(looseID)
+	assert.NoError(t, err)
+	assert.Equal(t, gitObjectBlob, objectType)
+	assert.Equal(t, loose, data)
+	_, _, err = repo.readObject(getGitObjectID(gitObjectBlob, []byte("missing")))
+	assert.True(t, errors.Is(err, errGitNotFound))
+	_, _, err = repo.readObject("invalid")
+	assert.Error(t, err)
+
+	// refs, loose and packed
+	packedRefs := fmt.Sprintf("# pack-refs with: peeled fully-peeled sorted\n%v refs/heads/packed\n%v refs/tags/v1\n^%v\n",
+		blobID, looseID, blobID)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.fsPath, "packed-refs"), []byte(packedRefs), os.ModePerm))
+	isNew, err := repo.updateRef("refs/heads/feature/one", blobID)
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	isNew, err = repo.updateRef("refs/heads/packed", looseID)
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+	isNew, err = repo.updateRef("refs/heads/packed", blobID)
+	assert.NoError(t, err)
+	assert.False(t, isNew)
+	refs, err := repo.getRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"refs/heads/feature/one": blobID,
+		"refs/heads/packed":      blobID,
+		"refs/tags/v1":           looseID,
+	}, refs)
+	removed, err := repo.deleteRef("refs/tags/v1")
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	removed, err = repo.deleteRef("refs/heads/packed")
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	refs, err = repo.getRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"refs/heads/feature/one": blobID}, refs)
+	packedData, err := os.ReadFile(filepath.Join(repo.fsPath, "packed-refs"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(packedData), "^")
+
+	require.NoError(t, repo.setHead("refs/heads/feature/one"))
+	assert.Equal(t, "refs/heads/feature/one", repo.getHead())
+}
+
+func TestGitRefLocks(t *testing.T) {
+	unlock := gitRefLocks.lock("key1")
+	acquired := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		unlock := gitRefLocks.lock("key1")
+		acquired <- true
+		unlock()
+		close(done)
+	}()
+	// other repositories are not blocked
+	unlockOther := gitRefLocks.lock("key2")
+	unlockOther()
+	select {
+	case <-acquired:
+		t.Fatal("the lock for the repository was acquired twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+	<-done
+	gitRefLocks.Lock()
+	assert.Len(t, gitRefLocks.locks, 0)
+	gitRefLocks.Unlock()
+}
+
+func TestGitConcurrentRefUpdates(t *testing.T) {
+	rootDir := t.TempDir()
+	fs := vfs.NewOsFs("", rootDir, "")
+	repo := newGitRepository(fs, filepath.Join(rootDir, "repo"), -1, -1)
+	defer repo.close()
+	require.NoError(t, repo.init())
+
+	blobs := map[string][]byte{}
+	var ids []string
+	for _, content := range []string{"first", "second", "third"} {
+		id := getGitObjectID(gitObjectBlob, []byte(content))
+		blobs[id] = []byte(content)
+		ids = append(ids, id)
+	}
+	var buf bytes.Buffer
+	err := writeGitPack(&buf, ids, func(id string) (int, []byte, error) {
+		return gitObjectBlob, blobs[id], nil
+	})
+	require.NoError(t, err)
+	pack, err := receiveGitPack(bufio.NewReader(&buf), "", 0, repo.readObjectByID)
+	require.NoError(t, err)
+	_, err = repo.storePack(pack)
+	pack.close()
+	require.NoError(t, err)
+	_, err = repo.updateRef(gitDefaultHead, ids[0])
+	require.NoError(t, err)
+
+	// two pushes based on the same advertised value, only one of them can succeed
+	var wg sync.WaitGroup
+	var updates [][]*gitRefUpdate
+	for _, newID := range ids[1:] {
+		cmdUpdates := []*gitRefUpdate{{oldID: ids[0], newID: newID, name: gitDefaultHead}}
+		updates = append(updates, cmdUpdates)
+		cmd := &gitCommand{
+			sshCommand: sshCommand{
+				connection: &Connection{
+					BaseConnection: common.NewBaseConnection("", common.ProtocolSSH, "", "", dataprovider.User{}),
+				},
+			},
+			repoPath: "/repo",
+			repo:     newGitRepository(fs, repo.fsPath, -1, -1),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cmd.repo.close()
+
+			assert.NoError(t, cmd.updateRefs(cmdUpdates))
+		}()
+	}
+	wg.Wait()
+
+	var winner string
+	for _, cmdUpdates := range updates {
+		switch cmdUpdates[0].err {
+		case "":
+			assert.Empty(t, winner)
+			winner = cmdUpdates[0].newID
+		default:
+			assert.Equal(t, "stale info", cmdUpdates[0].err)
+		}
+	}
+	refs, err := repo.getRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{gitDefaultHead: winner}, refs)
+}
+
+func TestGitRefNames(t *testing.T) {
+	for _, name := range []string{"refs/heads/main", "refs/tags/v1.0", "refs/heads/feature/a-b_c"} {
+		assert.True(t, isGitRefNameValid(name), name)
+	}
+	for _, name := range []string{"HEAD", "refs/heads/", "refs/heads/a..b", "refs/heads/.hidden", "refs/heads/a.lock",
+		"refs/heads/a b", "refs/heads/a~1", "refs/heads//a", "refs/heads/a@{1}", "refs/heads/a:b", "refs/heads/a.",
+		"refs/heads/a\x01"} {
+		assert.False(t, isGitRefNameValid(name), name)
+	}
+}
+
+func TestGitParseObjects(t *testing.T) {
+	blobID := getGitObjectID(gitObjectBlob, []byte("test"))
+	treeID := getGitObjectID(gitObjectTree, nil)
+	parentID := getGitObjectID(gitObjectCommit, []byte("parent"))
+	commit := fmt.Sprintf("tree %v\nparent %v\nparent %v\nauthor a <a@b> 0 +0000\n\nparent %v\n", treeID, parentID,
+		treeID, blobID)
+	tree, parents := parseGitCommitObject([]byte(commit))
+	assert.Equal(t, treeID, tree)
+	assert.Equal(t, []string{parentID, treeID}, parents)
+
+	tag := fmt.Sprintf("object %v\ntype commit\ntag v1\n\nobject message\n", parentID)
+	assert.Equal(t, parentID, parseGitTagObject([]byte(tag)))
+	assert.Empty(t, parseGitTagObject([]byte("type commit\n")))
+
+	rawID := func(id string) []byte {
+		b, _ := hex.DecodeString(id)
+		return b
+	}
+	var treeData bytes.Buffer
+	treeData.WriteString("100644 file\x00")
+	treeData.Write(rawID(blobID))
+	treeData.WriteString("40000 dir\x00")
+	treeData.Write(rawID(treeID))
+	treeData.WriteString("160000 submodule\x00")
+	treeData.Write(rawID(parentID))
+	treeData.WriteString("120000 link\x00")
+	treeData.Write(rawID(parentID))
+	trees, blobs, err := parseGitTreeObject(treeData.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{treeID}, trees)
+	assert.Equal(t, []string{blobID, parentID}, blobs)
+	_, _, err = parseGitTreeObject(treeData.Bytes()[:30])
+	assert.ErrorIs(t, err, errGitInvalidPack)
+}
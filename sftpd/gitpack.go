@@ -0,0 +1,722 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1" //nolint:gosec
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// git object types as stored inside pack files
+const (
+	gitObjectCommit   = 1
+	gitObjectTree     = 2
+	gitObjectBlob     = 3
+	gitObjectTag      = 4
+	gitObjectOfsDelta = 6
+	gitObjectRefDelta = 7
+)
+
+const (
+	gitIDLength = 20
+	// maximum size for a single object, bigger objects are rejected
+	gitMaxObjectSize = 1 << 31
+	// resolved delta bases are cached up to this size
+	gitDeltaCacheSize = 32 * 1024 * 1024
+	// maximum delta chain length, git uses 50 by default
+	gitMaxDeltaDepth = 4096
+)
+
+var (
+	errGitInvalidPack = errors.New("invalid pack file")
+	errGitNotFound    = errors.New("object not found")
+	gitPackSignature  = []byte("PACK")
+	gitIndexSignature = []byte{0xff, 't', 'O', 'c'}
+)
+
+func getGitObjectTypeName(objectType int) string {
+	switch objectType {
+	case gitObjectCommit:
+		return "commit"
+	case gitObjectTree:
+		return "tree"
+	case gitObjectBlob:
+		return "blob"
+	case gitObjectTag:
+		return "tag"
+	default:
+		return ""
+	}
+}
+
+func getGitObjectType(name string) int {
+	switch name {
+	case "commit":
+		return gitObjectCommit
+	case "tree":
+		return gitObjectTree
+	case "blob":
+		return gitObjectBlob
+	case "tag":
+		return gitObjectTag
+	default:
+		return 0
+	}
+}
+
+func newGitObjectHasher(objectType int, size int64) hash.Hash {
+	h := sha1.New() //nolint:gosec
+	fmt.Fprintf(h, "%s %d\x00", getGitObjectTypeName(objectType), size)
+	return h
+}
+
+func getGitObjectID(objectType int, data []byte) string {
+	h := newGitObjectHasher(objectType, int64(len(data)))
+	h.Write(data) //nolint:errcheck
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isGitObjectID(id string) bool {
+	if len(id) != 2*gitIDLength {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// readGitPackEntryHeader reads the type and the inflated size for a pack entry
+func readGitPackEntryHeader(r io.ByteReader) (int, int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	objectType := int(b>>4) & 7
+	size := int64(b & 0x0f)
+	shift := uint(4)
+	for b&0x80 != 0 {
+		if shift > 56 {
+			return 0, 0, errGitInvalidPack
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		size |= int64(b&0x7f) << shift
+		shift += 7
+	}
+	return objectType, size, nil
+}
+
+func writeGitPackEntryHeader(w io.Writer, objectType int, size int64) error {
+	var buf [16]byte
+	b := byte(objectType<<4) | byte(size&0x0f)
+	size >>= 4
+	n := 0
+	for size > 0 {
+		buf[n] = b | 0x80
+		n++
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	buf[n] = b
+	n++
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// readGitOfsDeltaOffset reads the negative offset for an OFS_DELTA base object
+func readGitOfsDeltaOffset(r io.ByteReader) (int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		if offset > 1<<55 {
+			return 0, errGitInvalidPack
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		offset = ((offset + 1) << 7) | int64(b&0x7f)
+	}
+	return offset, nil
+}
+
+func readGitDeltaSize(delta []byte, pos int) (int64, int, error) {
+	var size int64
+	shift := uint(0)
+	for {
+		if pos >= len(delta) || shift > 56 {
+			return 0, pos, errGitInvalidPack
+		}
+		b := delta[pos]
+		pos++
+		size |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, pos, nil
+		}
+	}
+}
+
+// applyGitDelta rebuilds an object from its base and the delta instructions
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	baseSize, pos, err := readGitDeltaSize(delta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != int64(len(base)) {
+		return nil, fmt.Errorf("%w: delta base size mismatch", errGitInvalidPack)
+	}
+	resultSize, pos, err := readGitDeltaSize(delta, pos)
+	if err != nil {
+		return nil, err
+	}
+	if resultSize > gitMaxObjectSize {
+		return nil, fmt.Errorf("%w: delta result too large", errGitInvalidPack)
+	}
+	result := make([]byte, 0, resultSize)
+	for pos < len(delta) {
+		op := delta[pos]
+		pos++
+		if op&0x80 != 0 {
+			var offset, size int64
+			for i := uint(0); i < 4; i++ {
+				if op&(1<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, errGitInvalidPack
+					}
+					offset |= int64(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					if pos >= len(delta) {
+						return nil, errGitInvalidPack
+					}
+					size |= int64(delta[pos]) << (8 * i)
+					pos++
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > int64(len(base)) {
+				return nil, fmt.Errorf("%w: delta copy out of bounds", errGitInvalidPack)
+			}
+			result = append(result, base[offset:offset+size]...)
+			continue
+		}
+		if op == 0 {
+			return nil, fmt.Errorf("%w: invalid delta opcode", errGitInvalidPack)
+		}
+		if pos+int(op) > len(delta) {
+			return nil, fmt.Errorf("%w: delta insert out of bounds", errGitInvalidPack)
+		}
+		result = append(result, delta[pos:pos+int(op)]...)
+		pos += int(op)
+	}
+	if int64(len(result)) != resultSize {
+		return nil, fmt.Errorf("%w: delta result size mismatch", errGitInvalidPack)
+	}
+	return result, nil
+}
+
+func inflateGitData(r io.Reader, size int64) ([]byte, error) {
+	if size > gitMaxObjectSize {
+		return nil, fmt.Errorf("%w: object too large", errGitInvalidPack)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := copyGitData(buf, r, size); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyGitData inflates the zlib compressed data and writes it to w, the
+// compressed stream must end after size bytes
+func copyGitData(w io.Writer, r io.Reader, size int64) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if _, err := io.CopyN(w, zr, size); err != nil {
+		return err
+	}
+	// this way the zlib checksum is read and verified
+	var extra [1]byte
+	if n, err := zr.Read(extra[:]); n != 0 || err != io.EOF {
+		return fmt.Errorf("%w: object size mismatch", errGitInvalidPack)
+	}
+	return nil
+}
+
+// gitPackIndex is a version 2 pack index
+type gitPackIndex struct {
+	fanout  [256]uint32
+	ids     []byte
+	offsets []int64
+}
+
+func parseGitPackIndex(data []byte) (*gitPackIndex, error) {
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], gitIndexSignature) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, errors.New("unsupported pack index version")
+	}
+	idx := &gitPackIndex{}
+	pos := 8
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+	count := int(idx.fanout[255])
+	if len(data) < pos+count*(gitIDLength+8)+2*gitIDLength {
+		return nil, errors.New("truncated pack index")
+	}
+	idx.ids = data[pos : pos+count*gitIDLength]
+	pos += count * gitIDLength
+	// skip the CRC32 values
+	pos += count * 4
+	smallOffsets := data[pos : pos+count*4]
+	pos += count * 4
+	largeOffsets := data[pos:]
+	idx.offsets = make([]int64, count)
+	for i := range idx.offsets {
+		offset := binary.BigEndian.Uint32(smallOffsets[i*4:])
+		if offset&0x80000000 == 0 {
+			idx.offsets[i] = int64(offset)
+			continue
+		}
+		largeIdx := int(offset&0x7fffffff) * 8
+		if largeIdx+8 > len(largeOffsets) {
+			return nil, errors.New("invalid large offset in pack index")
+		}
+		idx.offsets[i] = int64(binary.BigEndian.Uint64(largeOffsets[largeIdx:]))
+	}
+	return idx, nil
+}
+
+func (idx *gitPackIndex) count() int {
+	return len(idx.offsets)
+}
+
+func (idx *gitPackIndex) getID(i int) []byte {
+	return idx.ids[i*gitIDLength : (i+1)*gitIDLength]
+}
+
+func (idx *gitPackIndex) find(id []byte) (int64, bool) {
+	low := 0
+	if id[0] > 0 {
+		low = int(idx.fanout[id[0]-1])
+	}
+	high := int(idx.fanout[id[0]])
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(idx.getID(low+i), id) >= 0
+	})
+	if i < high && bytes.Equal(idx.getID(i), id) {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+// gitPackEntry describes an object stored inside a pack
+type gitPackEntry struct {
+	id         []byte
+	objectType int
+	offset     int64
+	crc        uint32
+	// delta base, if any
+	baseOffset int64
+	baseID     []byte
+}
+
+// marshalGitPackIndex returns a version 2 index for the specified entries
+func marshalGitPackIndex(entries []*gitPackEntry, packChecksum []byte) []byte {
+	sorted := make([]*gitPackEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].id, sorted[j].id) < 0
+	})
+	var buf bytes.Buffer
+	buf.Write(gitIndexSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(2)) //nolint:errcheck
+	var fanout [256]uint32
+	for _, entry := range sorted {
+		fanout[entry.id[0]]++
+	}
+	total := uint32(0)
+	for i := range fanout {
+		total += fanout[i]
+		binary.Write(&buf, binary.BigEndian, total) //nolint:errcheck
+	}
+	for _, entry := range sorted {
+		buf.Write(entry.id)
+	}
+	for _, entry := range sorted {
+		binary.Write(&buf, binary.BigEndian, entry.crc) //nolint:errcheck
+	}
+	var largeOffsets []int64
+	for _, entry := range sorted {
+		if entry.offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(entry.offset)) //nolint:errcheck
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(largeOffsets))|0x80000000) //nolint:errcheck
+		largeOffsets = append(largeOffsets, entry.offset)
+	}
+	for _, offset := range largeOffsets {
+		binary.Write(&buf, binary.BigEndian, uint64(offset)) //nolint:errcheck
+	}
+	buf.Write(packChecksum)
+	sum := sha1.Sum(buf.Bytes()) //nolint:gosec
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+type gitCachedObject struct {
+	objectType int
+	data       []byte
+}
+
+// gitPackReader reads objects from a pack file, the delta bases are cached
+type gitPackReader struct {
+	reader    io.ReaderAt
+	cache     map[int64]gitCachedObject
+	cacheSize int
+	// resolveRef returns the object for a REF_DELTA base
+	resolveRef func(id []byte) (int, []byte, error)
+}
+
+func newGitPackReader(reader io.ReaderAt, resolveRef func(id []byte) (int, []byte, error)) *gitPackReader {
+	return &gitPackReader{
+		reader:     reader,
+		cache:      make(map[int64]gitCachedObject),
+		resolveRef: resolveRef,
+	}
+}
+
+func (p *gitPackReader) addToCache(offset int64, objectType int, data []byte) {
+	if len(data) > gitDeltaCacheSize/4 {
+		return
+	}
+	if p.cacheSize+len(data) > gitDeltaCacheSize {
+		p.cache = make(map[int64]gitCachedObject)
+		p.cacheSize = 0
+	}
+	p.cache[offset] = gitCachedObject{objectType: objectType, data: data}
+	p.cacheSize += len(data)
+}
+
+// readObjectAt returns the type and the content for the object stored at the specified offset
+func (p *gitPackReader) readObjectAt(offset int64) (int, []byte, error) {
+	return p.readObject(offset, 0)
+}
+
+func (p *gitPackReader) readObject(offset int64, depth int) (int, []byte, error) {
+	if obj, ok := p.cache[offset]; ok {
+		return obj.objectType, obj.data, nil
+	}
+	if depth > gitMaxDeltaDepth {
+		return 0, nil, fmt.Errorf("%w: delta chain too long", errGitInvalidPack)
+	}
+	r := bufio.NewReader(io.NewSectionReader(p.reader, offset, 1<<62))
+	objectType, size, err := readGitPackEntryHeader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var baseType int
+	var base []byte
+	switch objectType {
+	case gitObjectCommit, gitObjectTree, gitObjectBlob, gitObjectTag:
+		data, err := inflateGitData(r, size)
+		return objectType, data, err
+	case gitObjectOfsDelta:
+		relOffset, err := readGitOfsDeltaOffset(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if relOffset <= 0 || relOffset > offset {
+			return 0, nil, fmt.Errorf("%w: invalid delta base offset", errGitInvalidPack)
+		}
+		baseType, base, err = p.readObject(offset-relOffset, depth+1)
+		if err != nil {
+			return 0, nil, err
+		}
+		p.addToCache(offset-relOffset, baseType, base)
+	case gitObjectRefDelta:
+		baseID := make([]byte, gitIDLength)
+		if _, err := io.ReadFull(r, baseID); err != nil {
+			return 0, nil, err
+		}
+		baseType, base, err = p.resolveRef(baseID)
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("%w: unknown object type %v", errGitInvalidPack, objectType)
+	}
+	delta, err := inflateGitData(r, size)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := applyGitDelta(base, delta)
+	return baseType, data, err
+}
+
+// gitPackStream reads a pack from the client. The read data is written to a
+// local file and hashed, the bytes consumed are counted exactly since zlib
+// uses the io.ByteReader interface
+type gitPackStream struct {
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	hasher  hash.Hash
+	crc     hash.Hash32
+	offset  int64
+	maxSize int64
+}
+
+func (s *gitPackStream) consumed(data []byte) error {
+	s.offset += int64(len(data))
+	if s.maxSize > 0 && s.offset > s.maxSize {
+		return errGitPackTooLarge
+	}
+	s.hasher.Write(data) //nolint:errcheck
+	s.crc.Write(data)    //nolint:errcheck
+	_, err := s.writer.Write(data)
+	return err
+}
+
+func (s *gitPackStream) ReadByte() (byte, error) {
+	b, err := s.reader.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	return b, s.consumed([]byte{b})
+}
+
+func (s *gitPackStream) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if n > 0 {
+		if errConsumed := s.consumed(p[:n]); errConsumed != nil {
+			return n, errConsumed
+		}
+	}
+	return n, err
+}
+
+var errGitPackTooLarge = errors.New("pack file too large, quota exceeded")
+
+// gitReceivedPack is a pack received from the client and stored in a local file
+type gitReceivedPack struct {
+	file     *os.File
+	size     int64
+	checksum []byte
+	entries  []*gitPackEntry
+}
+
+func (p *gitReceivedPack) close() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// receiveGitPack reads a pack from the client, stores it in a temporary file
+// and computes the id for any object. lookup is used to resolve the REF_DELTA
+// bases not included in the pack
+func receiveGitPack(r *bufio.Reader, tempDir string, maxSize int64,
+	lookup func(id []byte) (int, []byte, error),
+) (*gitReceivedPack, error) {
+	file, err := os.CreateTemp(tempDir, "sftpgo-git-*.pack")
+	if err != nil {
+		return nil, err
+	}
+	pack := &gitReceivedPack{file: file}
+	if err := pack.read(r, maxSize); err != nil {
+		pack.close()
+		return nil, err
+	}
+	if err := pack.resolveIDs(lookup); err != nil {
+		pack.close()
+		return nil, err
+	}
+	return pack, nil
+}
+
+func (p *gitReceivedPack) read(r *bufio.Reader, maxSize int64) error {
+	stream := &gitPackStream{
+		reader:  r,
+		writer:  bufio.NewWriter(p.file),
+		hasher:  sha1.New(), //nolint:gosec
+		crc:     crc32.NewIEEE(),
+		maxSize: maxSize,
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(stream, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:4], gitPackSignature) {
+		return fmt.Errorf("%w: bad signature", errGitInvalidPack)
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		return fmt.Errorf("%w: unsupported version %v", errGitInvalidPack, version)
+	}
+	count := binary.BigEndian.Uint32(header[8:12])
+	for i := uint32(0); i < count; i++ {
+		entry, err := p.readEntry(stream)
+		if err != nil {
+			return err
+		}
+		p.entries = append(p.entries, entry)
+	}
+	checksum := stream.hasher.Sum(nil)
+	trailer := make([]byte, gitIDLength)
+	if _, err := io.ReadFull(r, trailer); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer, checksum) {
+		return fmt.Errorf("%w: checksum mismatch", errGitInvalidPack)
+	}
+	if _, err := stream.writer.Write(trailer); err != nil {
+		return err
+	}
+	p.checksum = checksum
+	p.size = stream.offset + gitIDLength
+	return stream.writer.Flush()
+}
+
+func (p *gitReceivedPack) readEntry(stream *gitPackStream) (*gitPackEntry, error) {
+	stream.crc.Reset()
+	entry := &gitPackEntry{
+		offset: stream.offset,
+	}
+	objectType, size, err := readGitPackEntryHeader(stream)
+	if err != nil {
+		return nil, err
+	}
+	entry.objectType = objectType
+	switch objectType {
+	case gitObjectCommit, gitObjectTree, gitObjectBlob, gitObjectTag:
+		h := newGitObjectHasher(objectType, size)
+		if err := copyGitData(h, stream, size); err != nil {
+			return nil, err
+		}
+		entry.id = h.Sum(nil)
+	case gitObjectOfsDelta:
+		relOffset, err := readGitOfsDeltaOffset(stream)
+		if err != nil {
+			return nil, err
+		}
+		if relOffset <= 0 || relOffset > entry.offset {
+			return nil, fmt.Errorf("%w: invalid delta base offset", errGitInvalidPack)
+		}
+		entry.baseOffset = entry.offset - relOffset
+		if err := copyGitData(io.Discard, stream, size); err != nil {
+			return nil, err
+		}
+	case gitObjectRefDelta:
+		entry.baseID = make([]byte, gitIDLength)
+		if _, err := io.ReadFull(stream, entry.baseID); err != nil {
+			return nil, err
+		}
+		if err := copyGitData(io.Discard, stream, size); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown object type %v", errGitInvalidPack, objectType)
+	}
+	entry.crc = stream.crc.Sum32()
+	return entry, nil
+}
+
+// resolveIDs computes the ids for the deltified objects
+func (p *gitReceivedPack) resolveIDs(lookup func(id []byte) (int, []byte, error)) error {
+	byID := make(map[string]int64)
+	for _, entry := range p.entries {
+		if entry.id != nil {
+			byID[string(entry.id)] = entry.offset
+		}
+	}
+	var reader *gitPackReader
+	reader = newGitPackReader(p.file, func(id []byte) (int, []byte, error) {
+		if offset, ok := byID[string(id)]; ok {
+			return reader.readObjectAt(offset)
+		}
+		return lookup(id)
+	})
+	// REF_DELTA bases could be deltified objects later in the pack, we loop
+	// until all the ids are resolved
+	for {
+		unresolved := 0
+		progress := false
+		for _, entry := range p.entries {
+			if entry.id != nil {
+				continue
+			}
+			if entry.baseID != nil {
+				if _, ok := byID[string(entry.baseID)]; !ok {
+					if _, _, err := lookup(entry.baseID); err != nil {
+						unresolved++
+						continue
+					}
+				}
+			}
+			objectType, data, err := reader.readObjectAt(entry.offset)
+			if err != nil {
+				return err
+			}
+			entry.id, _ = hex.DecodeString(getGitObjectID(objectType, data))
+			entry.objectType = objectType
+			byID[string(entry.id)] = entry.offset
+			progress = true
+		}
+		if unresolved == 0 {
+			return nil
+		}
+		if !progress {
+			return fmt.Errorf("%w: %v unresolved deltas", errGitInvalidPack, unresolved)
+		}
+	}
+}
+
+// writeGitPack writes a pack with the specified objects, deltas are never used
+func writeGitPack(w io.Writer, ids []string, readObject func(id string) (int, []byte, error)) error {
+	hasher := sha1.New() //nolint:gosec
+	mw := io.MultiWriter(w, hasher)
+	header := make([]byte, 12)
+	copy(header, gitPackSignature)
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(ids)))
+	if _, err := mw.Write(header); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		objectType, data, err := readObject(id)
+		if err != nil {
+			return err
+		}
+		if err := writeGitPackEntryHeader(mw, objectType, int64(len(data))); err != nil {
+			return err
+		}
+		zw := zlib.NewWriter(mw)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(hasher.Sum(nil))
+	return err
+}
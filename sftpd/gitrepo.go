@@ -0,0 +1,488 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+const gitDefaultHead = "refs/heads/main"
+
+// gitRefLocks serializes the references updates for each repository.
+// Repositories are keyed by storage and filesystem path, so users sharing
+// a virtual folder use the same lock
+var gitRefLocks = gitRefLocksRegistry{
+	locks: make(map[string]*gitRefLock),
+}
+
+type gitRefLocksRegistry struct {
+	sync.Mutex
+	locks map[string]*gitRefLock
+}
+
+type gitRefLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock acquires the lock for the specified key and returns the function to release it
+func (r *gitRefLocksRegistry) lock(key string) func() {
+	r.Lock()
+	l, ok := r.locks[key]
+	if !ok {
+		l = &gitRefLock{}
+		r.locks[key] = l
+	}
+	l.users++
+	r.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		r.Lock()
+		defer r.Unlock()
+
+		l.users--
+		if l.users == 0 {
+			delete(r.locks, key)
+		}
+	}
+}
+
+// gitRepository allows to read and write a bare git repository stored on
+// any supported filesystem
+type gitRepository struct {
+	fs          vfs.Fs
+	fsPath      string
+	uid         int
+	gid         int
+	packs       []*gitRepositoryPack
+	packsLoaded bool
+}
+
+// gitRepositoryPack is a pack file, the pack is opened on first use
+type gitRepositoryPack struct {
+	name   string
+	index  *gitPackIndex
+	reader *gitPackReader
+	closer io.Closer
+}
+
+func newGitRepository(fs vfs.Fs, fsPath string, uid, gid int) *gitRepository {
+	return &gitRepository{
+		fs:     fs,
+		fsPath: fsPath,
+		uid:    uid,
+		gid:    gid,
+	}
+}
+
+// lockRefs prevents concurrent changes to the references and returns
+// the function to call to release the lock
+func (r *gitRepository) lockRefs() func() {
+	return gitRefLocks.lock(fmt.Sprintf("%v:%v", vfs.GetStorageID(r.fs), r.fsPath))
+}
+
+func (r *gitRepository) join(name string) string {
+	return r.fs.Join(r.fsPath, name)
+}
+
+// exists returns true if the repository path contains a bare repository
+func (r *gitRepository) exists() bool {
+	if _, err := r.fs.Stat(r.join("HEAD")); err != nil {
+		return false
+	}
+	info, err := r.fs.Stat(r.join("objects"))
+	return err == nil && info.IsDir()
+}
+
+// isEmptyDir returns true if the repository path does not exist or is an empty directory
+func (r *gitRepository) isEmptyDir() bool {
+	info, err := r.fs.Stat(r.fsPath)
+	if err != nil {
+		return r.fs.IsNotExist(err)
+	}
+	if !info.IsDir() {
+		return false
+	}
+	files, err := r.fs.ReadDir(r.fsPath)
+	return err == nil && len(files) == 0
+}
+
+func (r *gitRepository) init() error {
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags"} {
+		if err := r.createDir(dir); err != nil {
+			return err
+		}
+	}
+	if err := r.writeFile("config", []byte("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = true\n")); err != nil {
+		return err
+	}
+	return r.writeFile("HEAD", []byte(fmt.Sprintf("ref: %v\n", gitDefaultHead)))
+}
+
+// createDir creates the named directory, if missing. MkdirAll only creates
+// the missing parent directories for some filesystems
+func (r *gitRepository) createDir(name string) error {
+	fsPath := r.join(name)
+	if info, err := r.fs.Stat(fsPath); err == nil && info.IsDir() {
+		return nil
+	}
+	if err := r.fs.MkdirAll(fsPath, r.uid, r.gid); err != nil {
+		return err
+	}
+	if info, err := r.fs.Stat(fsPath); err == nil && info.IsDir() {
+		return nil
+	}
+	if err := r.fs.Mkdir(fsPath); err != nil {
+		return err
+	}
+	vfs.SetPathPermissions(r.fs, fsPath, r.uid, r.gid)
+	return nil
+}
+
+func (r *gitRepository) close() {
+	for _, p := range r.packs {
+		if p.closer != nil {
+			p.closer.Close()
+			p.closer = nil
+		}
+	}
+}
+
+func (r *gitRepository) openFile(name string) (io.ReadCloser, io.ReaderAt, error) {
+	f, reader, _, err := r.fs.Open(r.join(name), 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f != nil {
+		return f, f, nil
+	}
+	return reader, reader, nil
+}
+
+func (r *gitRepository) readFile(name string) ([]byte, error) {
+	reader, _, err := r.openFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (r *gitRepository) writeFile(name string, data []byte) error {
+	return r.writeFileFrom(name, bytes.NewReader(data))
+}
+
+func (r *gitRepository) writeFileFrom(name string, src io.Reader) error {
+	fsPath := r.join(name)
+	f, w, cancelFn, err := r.fs.Create(fsPath, 0)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		_, err = io.Copy(f, src)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+	} else {
+		_, err = io.Copy(w, src)
+		if err != nil && cancelFn != nil {
+			cancelFn()
+		}
+		if errClose := w.Close(); err == nil {
+			err = errClose
+		}
+	}
+	if err == nil {
+		vfs.SetPathPermissions(r.fs, fsPath, r.uid, r.gid)
+	}
+	return err
+}
+
+// getRefs returns the references, the loose ones take precedence over the packed ones
+func (r *gitRepository) getRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	data, err := r.readFile("packed-refs")
+	if err != nil && !r.fs.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && isGitObjectID(fields[0]) {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	if err := r.readLooseRefs("refs", refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (r *gitRepository) readLooseRefs(name string, refs map[string]string) error {
+	files, err := r.fs.ReadDir(r.join(name))
+	if err != nil {
+		if r.fs.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, info := range files {
+		refName := path.Join(name, info.Name())
+		if info.IsDir() {
+			if err := r.readLooseRefs(refName, refs); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := r.readFile(refName)
+		if err != nil {
+			return err
+		}
+		// symbolic references are ignored
+		if value := strings.TrimSpace(string(data)); isGitObjectID(value) {
+			refs[refName] = value
+		}
+	}
+	return nil
+}
+
+// getHead returns the reference pointed by HEAD, if any
+func (r *gitRepository) getHead() string {
+	data, err := r.readFile("HEAD")
+	if err != nil {
+		return ""
+	}
+	value := strings.TrimSpace(string(data))
+	if strings.HasPrefix(value, "ref: ") {
+		return strings.TrimPrefix(value, "ref: ")
+	}
+	return ""
+}
+
+func (r *gitRepository) setHead(refName string) error {
+	return r.writeFile("HEAD", []byte(fmt.Sprintf("ref: %v\n", refName)))
+}
+
+// updateRef writes a loose reference and returns true if a new file was created
+func (r *gitRepository) updateRef(refName, id string) (bool, error) {
+	_, err := r.fs.Stat(r.join(refName))
+	isNew := err != nil
+	if err := r.createDir(path.Dir(refName)); err != nil {
+		return false, err
+	}
+	return isNew, r.writeFile(refName, []byte(id+"\n"))
+}
+
+// deleteRef removes a reference and returns true if a loose reference file was removed
+func (r *gitRepository) deleteRef(refName string) (bool, error) {
+	removed := false
+	err := r.fs.Remove(r.join(refName), false)
+	if err == nil {
+		removed = true
+	} else if !r.fs.IsNotExist(err) {
+		return false, err
+	}
+	data, err := r.readFile("packed-refs")
+	if err != nil {
+		if r.fs.IsNotExist(err) {
+			return removed, nil
+		}
+		return removed, err
+	}
+	var lines []string
+	found := false
+	skipPeeled := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if skipPeeled && strings.HasPrefix(line, "^") {
+			continue
+		}
+		skipPeeled = false
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == refName {
+			found = true
+			skipPeeled = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !found {
+		return removed, nil
+	}
+	return removed, r.writeFile("packed-refs", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+func (r *gitRepository) loadPacks() error {
+	if r.packsLoaded {
+		return nil
+	}
+	files, err := r.fs.ReadDir(r.join("objects/pack"))
+	if err != nil && !r.fs.IsNotExist(err) {
+		return err
+	}
+	var names []string
+	for _, info := range files {
+		if strings.HasSuffix(info.Name(), ".idx") {
+			names = append(names, strings.TrimSuffix(info.Name(), ".idx"))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := r.readFile(path.Join("objects/pack", name+".idx"))
+		if err != nil {
+			return err
+		}
+		index, err := parseGitPackIndex(data)
+		if err != nil {
+			return fmt.Errorf("unable to load pack index %#v: %w", name, err)
+		}
+		r.packs = append(r.packs, &gitRepositoryPack{
+			name:  name,
+			index: index,
+		})
+	}
+	r.packsLoaded = true
+	return nil
+}
+
+func (r *gitRepository) getPackReader(p *gitRepositoryPack) (*gitPackReader, error) {
+	if p.reader != nil {
+		return p.reader, nil
+	}
+	closer, readerAt, err := r.openFile(path.Join("objects/pack", p.name+".pack"))
+	if err != nil {
+		return nil, err
+	}
+	p.closer = closer
+	p.reader = newGitPackReader(readerAt, r.readObjectByID)
+	return p.reader, nil
+}
+
+func (r *gitRepository) findPackedObject(id []byte) (*gitRepositoryPack, int64, error) {
+	if err := r.loadPacks(); err != nil {
+		return nil, 0, err
+	}
+	for _, p := range r.packs {
+		if offset, ok := p.index.find(id); ok {
+			return p, offset, nil
+		}
+	}
+	return nil, 0, nil
+}
+
+// hasObject returns true if the object with the specified id exists
+func (r *gitRepository) hasObject(id string) bool {
+	rawID, err := hex.DecodeString(id)
+	if err != nil || len(rawID) != gitIDLength {
+		return false
+	}
+	p, _, err := r.findPackedObject(rawID)
+	if err != nil {
+		return false
+	}
+	if p != nil {
+		return true
+	}
+	_, err = r.fs.Stat(r.join(path.Join("objects", id[:2], id[2:])))
+	return err == nil
+}
+
+// readObject returns the type and the content for the object with the specified id
+func (r *gitRepository) readObject(id string) (int, []byte, error) {
+	rawID, err := hex.DecodeString(id)
+	if err != nil || len(rawID) != gitIDLength {
+		return 0, nil, fmt.Errorf("invalid object id %#v", id)
+	}
+	return r.readObjectByID(rawID)
+}
+
+func (r *gitRepository) readObjectByID(id []byte) (int, []byte, error) {
+	p, offset, err := r.findPackedObject(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if p != nil {
+		reader, err := r.getPackReader(p)
+		if err != nil {
+			return 0, nil, err
+		}
+		return reader.readObjectAt(offset)
+	}
+	return r.readLooseObject(hex.EncodeToString(id))
+}
+
+func (r *gitRepository) readLooseObject(id string) (int, []byte, error) {
+	data, err := r.readFile(path.Join("objects", id[:2], id[2:]))
+	if err != nil {
+		if r.fs.IsNotExist(err) {
+			return 0, nil, fmt.Errorf("%w: %v", errGitNotFound, id)
+		}
+		return 0, nil, err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	idx := bytes.IndexByte(content, 0)
+	if idx < 0 {
+		return 0, nil, fmt.Errorf("invalid loose object %v", id)
+	}
+	header := strings.SplitN(string(content[:idx]), " ", 2)
+	if len(header) != 2 {
+		return 0, nil, fmt.Errorf("invalid loose object %v", id)
+	}
+	objectType := getGitObjectType(header[0])
+	size, err := strconv.Atoi(header[1])
+	if err != nil || objectType == 0 || size != len(content)-idx-1 {
+		return 0, nil, fmt.Errorf("invalid loose object %v", id)
+	}
+	return objectType, content[idx+1:], nil
+}
+
+// storePack stores a received pack and its index, it returns the stored size
+func (r *gitRepository) storePack(pack *gitReceivedPack) (int64, error) {
+	if err := r.loadPacks(); err != nil {
+		return 0, err
+	}
+	name := "pack-" + hex.EncodeToString(pack.checksum)
+	if err := r.createDir("objects/pack"); err != nil {
+		return 0, err
+	}
+	if err := r.writeFileFrom(path.Join("objects/pack", name+".pack"), io.NewSectionReader(pack.file, 0, pack.size)); err != nil {
+		return 0, err
+	}
+	indexData := marshalGitPackIndex(pack.entries, pack.checksum)
+	// the pack is visible to git once the index is written
+	if err := r.writeFile(path.Join("objects/pack", name+".idx"), indexData); err != nil {
+		return 0, err
+	}
+	index, err := parseGitPackIndex(indexData)
+	if err != nil {
+		return 0, err
+	}
+	r.packs = append(r.packs, &gitRepositoryPack{
+		name:  name,
+		index: index,
+	})
+	return pack.size + int64(len(indexData)), nil
+}
@@ -115,7 +115,7 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 	}
 
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 
@@ -154,7 +154,8 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
-	return c.handleSFTPUploadToExistingFile(fs, request.Pflags(), p, filePath, stat.Size(), request.Filepath, errForRead)
+	return c.handleSFTPUploadToExistingFile(fs, request.Pflags(), p, filePath, vfs.GetQuotaSize(stat),
+		!vfs.IsQuotaTracked(stat), request.Filepath, errForRead)
 }
 
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
@@ -183,6 +184,10 @@ func (c *Connection) Filecmd(request *sftp.Request) error {
 		if err := c.CreateSymlink(request.Filepath, request.Target); err != nil {
 			return err
 		}
+	case "Link":
+		if err := c.CreateHardlink(request.Filepath, request.Target); err != nil {
+			return err
+		}
 	case "Remove":
 		return c.handleSFTPRemove(request)
 	default:
@@ -370,10 +375,12 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, resolvedPath, filePath
 	return t, nil
 }
 
+// isNewFile is true if the existing file is not included in the quota, for
+// example a file existing only inside the lower layer of an overlay folder
 func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileOpenFlags, resolvedPath, filePath string,
-	fileSize int64, requestPath string, errForRead error) (sftp.WriterAtReaderAt, error) {
+	fileSize int64, isNewFile bool, requestPath string, errForRead error) (sftp.WriterAtReaderAt, error) {
 	var err error
-	quotaResult := c.HasSpace(false, false, requestPath)
+	quotaResult := c.HasSpace(isNewFile, false, requestPath)
 	if !quotaResult.HasSpace {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, c.GetQuotaExceededError()
@@ -385,6 +392,11 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	// for upload resumes OpenSSH sets the APPEND flag while WinSCP does not set it,
 	// so we suppose this is an upload resume if the TRUNCATE flag is not set
 	isResume := !isTruncate
+	// resuming a file not included in the quota copies it to the writable layer
+	if isNewFile && isResume && !c.HasSpaceForCopyUp(fs, resolvedPath, requestPath) {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, c.GetQuotaExceededError()
+	}
 	// if there is a size limit the remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before.
 	// For Cloud FS GetMaxWriteSize will return unsupported operation
@@ -399,7 +411,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -442,7 +454,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
-		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, fs)
+		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, isNewFile, fs)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
 
 	return t, nil
@@ -0,0 +1,209 @@
+package sftpd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+var hostKeysMgr *hostKeysManager
+
+// hostKeysManager loads the host keys and certificates for each binding
+// and allows to reload them without restarting the service
+type hostKeysManager struct {
+	sync.RWMutex
+	configDir        string
+	hostKeys         []string
+	hostCertificates []string
+	bindings         []Binding
+	// signers keyed by binding address
+	signers map[string][]ssh.Signer
+}
+
+func newHostKeysManager(configDir string, hostKeys, hostCertificates []string, bindings []Binding) *hostKeysManager {
+	return &hostKeysManager{
+		configDir:        configDir,
+		hostKeys:         hostKeys,
+		hostCertificates: hostCertificates,
+		bindings:         bindings,
+		signers:          make(map[string][]ssh.Signer),
+	}
+}
+
+// Reload reads the host keys and certificates again. The previous ones are
+// kept if an error is detected
+func (m *hostKeysManager) Reload() error {
+	signers := make(map[string][]ssh.Signer)
+	var status []HostKey
+	var fingerprints []string
+	loaded := make(map[string]bool)
+
+	addHostKeys := func(bindingAddress string, keys, certs []string) error {
+		bindingSigners, hostKeys, err := loadHostKeys(keys, certs, m.configDir)
+		if err != nil {
+			return err
+		}
+		if len(bindingSigners) == 0 && bindingAddress != "" {
+			return fmt.Errorf("no host keys configured for binding %#v", bindingAddress)
+		}
+		signers[bindingAddress] = bindingSigners
+		for _, k := range hostKeys {
+			if !loaded[k.Path+k.Certificate] {
+				loaded[k.Path+k.Certificate] = true
+				status = append(status, k)
+			}
+			fingerprints = append(fingerprints, k.Fingerprint)
+		}
+		return nil
+	}
+
+	// the global host keys are used for bindings without their own ones
+	if err := addHostKeys("", m.hostKeys, m.hostCertificates); err != nil {
+		return err
+	}
+	for _, binding := range m.bindings {
+		if !binding.hasCustomHostKeys() {
+			continue
+		}
+		keys, certs := binding.getHostKeysAndCertificates(m.hostKeys, m.hostCertificates)
+		if err := addHostKeys(binding.GetAddress(), keys, certs); err != nil {
+			return fmt.Errorf("unable to load host keys for binding %#v: %w", binding.GetAddress(), err)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.signers = signers
+	serviceStatus.HostKeys = status
+	vfs.SetSFTPFingerprints(util.RemoveDuplicates(fingerprints))
+	return nil
+}
+
+func (m *hostKeysManager) getSigners(bindingAddress string) []ssh.Signer {
+	m.RLock()
+	defer m.RUnlock()
+
+	if signers, ok := m.signers[bindingAddress]; ok {
+		return signers
+	}
+	return m.signers[""]
+}
+
+// getServerConfig returns a copy of the given server configuration
+// with the current host keys for the specified binding
+func (m *hostKeysManager) getServerConfig(baseConfig *ssh.ServerConfig, bindingAddress string) *ssh.ServerConfig {
+	serverConfig := *baseConfig
+	for _, signer := range m.getSigners(bindingAddress) {
+		serverConfig.AddHostKey(signer)
+	}
+	return &serverConfig
+}
+
+// ReloadHostKeys reloads the host keys and certificates
+func ReloadHostKeys() error {
+	if hostKeysMgr != nil {
+		return hostKeysMgr.Reload()
+	}
+	return nil
+}
+
+func getHostCertificatePath(name, configDir string) (string, error) {
+	if !util.IsFileInputValid(name) {
+		return "", fmt.Errorf("invalid host certificate %#v", name)
+	}
+	if !filepath.IsAbs(name) {
+		return filepath.Join(configDir, name), nil
+	}
+	return name, nil
+}
+
+func loadHostKeys(keys, certificates []string, configDir string) ([]ssh.Signer, []HostKey, error) {
+	var signers []ssh.Signer
+	var hostKeys []HostKey
+
+	for _, hostKey := range keys {
+		if !util.IsFileInputValid(hostKey) {
+			logger.Warn(logSender, "", "unable to load invalid host key %#v", hostKey)
+			logger.WarnToConsole("unable to load invalid host key %#v", hostKey)
+			continue
+		}
+		if !filepath.IsAbs(hostKey) {
+			hostKey = filepath.Join(configDir, hostKey)
+		}
+		logger.Info(logSender, "", "Loading private host key %#v", hostKey)
+
+		privateBytes, err := os.ReadFile(hostKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		private, err := ssh.ParsePrivateKey(privateBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		k := HostKey{
+			Path:        hostKey,
+			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
+		}
+		hostKeys = append(hostKeys, k)
+		signers = append(signers, private)
+		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
+			private.PublicKey().Type(), k.Fingerprint)
+	}
+
+	var certSigners []ssh.Signer
+	for _, certPath := range certificates {
+		certPath, err := getHostCertificatePath(certPath, configDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		certBytes, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse host certificate %#v: %w", certPath, err)
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			return nil, nil, fmt.Errorf("%#v is not a host certificate", certPath)
+		}
+		certSigner, err := getHostCertSigner(cert, signers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to use host certificate %#v: %w", certPath, err)
+		}
+		if cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore) {
+			logger.Warn(logSender, "", "host certificate %#v is expired", certPath)
+			logger.WarnToConsole("host certificate %#v is expired", certPath)
+		}
+		certSigners = append(certSigners, certSigner)
+		for idx := range hostKeys {
+			if hostKeys[idx].Fingerprint == ssh.FingerprintSHA256(cert.Key) {
+				hostKeys[idx].Certificate = certPath
+			}
+		}
+		logger.Info(logSender, "", "Host certificate %#v loaded, key ID %#v, principals %v, CA fingerprint %#v",
+			certPath, cert.KeyId, cert.ValidPrincipals, ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+
+	return append(signers, certSigners...), hostKeys, nil
+}
+
+func getHostCertSigner(cert *ssh.Certificate, signers []ssh.Signer) (ssh.Signer, error) {
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal()) {
+			return ssh.NewCertSigner(cert, signer)
+		}
+	}
+	return nil, fmt.Errorf("no host key matches the certificate key %v", ssh.FingerprintSHA256(cert.Key))
+}
@@ -2,6 +2,9 @@ package sftpd
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -293,11 +296,11 @@ func TestUploadFiles(t *testing.T) {
 	var flags sftp.FileOpenFlags
 	flags.Write = true
 	flags.Trunc = true
-	_, err := c.handleSFTPUploadToExistingFile(fs, flags, "missing_path", "other_missing_path", 0, "/missing_path", nil)
+	_, err := c.handleSFTPUploadToExistingFile(fs, flags, "missing_path", "other_missing_path", 0, false, "/missing_path", nil)
 	assert.Error(t, err, "upload to existing file must fail if one or both paths are invalid")
 
 	common.Config.UploadMode = common.UploadModeStandard
-	_, err = c.handleSFTPUploadToExistingFile(fs, flags, "missing_path", "other_missing_path", 0, "/missing_path", nil)
+	_, err = c.handleSFTPUploadToExistingFile(fs, flags, "missing_path", "other_missing_path", 0, false, "/missing_path", nil)
 	assert.Error(t, err, "upload to existing file must fail if one or both paths are invalid")
 
 	missingFile := "missing/relative/file.txt"
@@ -313,7 +316,7 @@ func TestUploadFiles(t *testing.T) {
 	err = f.Close()
 	assert.NoError(t, err)
 
-	tr, err := c.handleSFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, f.Name(), nil)
+	tr, err := c.handleSFTPUploadToExistingFile(fs, flags, f.Name(), f.Name(), 123, false, f.Name(), nil)
 	if assert.NoError(t, err) {
 		transfer := tr.(*transfer)
 		transfers := c.GetTransfers()
@@ -492,7 +495,7 @@ func TestSSHCommandErrors(t *testing.T) {
 	assert.Error(t, err, "ssh command must fail, we are requesting an invalid path")
 
 	cmd = sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: &connection,
 		args:       []string{"/../../testrepo"},
 	}
@@ -624,15 +627,15 @@ func TestCommandsWithExtensionsFilter(t *testing.T) {
 	assert.EqualError(t, err, common.ErrPermissionDenied.Error())
 
 	cmd = sshCommand{
-		command:    "rsync",
+		command:    "git-upload-archive",
 		connection: connection,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+		args:       []string{"/"},
 	}
 	_, err = cmd.getSystemCommand()
 	assert.EqualError(t, err, errUnsupportedConfig.Error())
 
 	cmd = sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: connection,
 		args:       []string{"/subdir"},
 	}
@@ -640,7 +643,7 @@ func TestCommandsWithExtensionsFilter(t *testing.T) {
 	assert.EqualError(t, err, errUnsupportedConfig.Error())
 
 	cmd = sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: connection,
 		args:       []string{"/subdir/dir"},
 	}
@@ -648,7 +651,7 @@ func TestCommandsWithExtensionsFilter(t *testing.T) {
 	assert.EqualError(t, err, errUnsupportedConfig.Error())
 
 	cmd = sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: connection,
 		args:       []string{"/adir/subdir"},
 	}
@@ -778,7 +781,7 @@ func TestGitVirtualFolders(t *testing.T) {
 		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
 	}
 	cmd := sshCommand{
-		command:    "git-receive-pack",
+		command:    "git-upload-archive",
 		connection: conn,
 		args:       []string{"/vdir"},
 	}
@@ -814,52 +817,49 @@ func TestGitVirtualFolders(t *testing.T) {
 }
 
 func TestRsyncOptions(t *testing.T) {
-	permissions := make(map[string][]string)
-	permissions["/"] = []string{dataprovider.PermAny}
-	user := dataprovider.User{
-		BaseUser: sdk.BaseUser{
-			Permissions: permissions,
-			HomeDir:     os.TempDir(),
-		},
-	}
-	conn := &Connection{
-		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
-	}
-	sshCmd := sshCommand{
-		command:    "rsync",
-		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
-	}
-	cmd, err := sshCmd.getSystemCommand()
-	assert.NoError(t, err)
-	assert.True(t, util.IsStringInSlice("--safe-links", cmd.cmd.Args),
-		"--safe-links must be added if the user has the create symlinks permission")
-
-	permissions["/"] = []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
-		dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete, dataprovider.PermRename}
-	user.Permissions = permissions
-
-	conn = &Connection{
-		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
-	}
-	sshCmd = sshCommand{
-		command:    "rsync",
-		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+	opts, err := parseRsyncOptions([]string{"--server", "-vlogDtpre.iLsfxC", "--delete", "--numeric-ids",
+		"--checksum-seed=10", "--modify-window=2", ".", "dest/"})
+	assert.NoError(t, err)
+	assert.False(t, opts.sender)
+	assert.True(t, opts.recursive)
+	assert.True(t, opts.preserveLinks)
+	assert.True(t, opts.preservePerms)
+	assert.True(t, opts.preserveTimes)
+	assert.True(t, opts.preserveUID)
+	assert.True(t, opts.preserveGID)
+	assert.True(t, opts.preserveDevices)
+	assert.False(t, opts.alwaysChecksum)
+	assert.True(t, opts.deleteMode)
+	assert.False(t, opts.deleteExcluded)
+	assert.True(t, opts.numericIDs)
+	assert.True(t, opts.receiverWantsFilterList())
+	assert.Equal(t, int32(10), opts.checksumSeed)
+	assert.Equal(t, int64(2), opts.modifyWindow)
+	assert.Equal(t, []string{".", "dest/"}, opts.paths)
+
+	opts, err = parseRsyncOptions([]string{"--server", "--sender", "-tce.LsfxC", "--delete-excluded", ".", "-file"})
+	assert.NoError(t, err)
+	assert.True(t, opts.sender)
+	assert.True(t, opts.alwaysChecksum)
+	assert.True(t, opts.deleteExcluded)
+	assert.False(t, opts.receiverWantsFilterList())
+	assert.Equal(t, []string{".", "-file"}, opts.paths)
+
+	opts, err = parseRsyncOptions([]string{"--server"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"."}, opts.paths)
+
+	_, err = parseRsyncOptions([]string{"-vlogDtprze.iLsfxC", ".", "/"})
+	assert.Error(t, err)
+	_, err = parseRsyncOptions([]string{"--server", "-vlogDtpre.iLsfxC", "--daemon", ".", "/"})
+	assert.Error(t, err)
+	for _, option := range []string{"-z", "-H", "-A", "-X", "-b", "--remove-source-files", "--checksum-seed=a",
+		"--modify-window=b"} {
+		_, err = parseRsyncOptions([]string{"--server", option, ".", "/"})
+		assert.Error(t, err, "option %v must be rejected", option)
 	}
-	cmd, err = sshCmd.getSystemCommand()
-	assert.NoError(t, err)
-	assert.True(t, util.IsStringInSlice("--munge-links", cmd.cmd.Args),
-		"--munge-links must be added if the user has the create symlinks permission")
-
-	sshCmd.connection.User.VirtualFolders = append(sshCmd.connection.User.VirtualFolders, vfs.VirtualFolder{
-		BaseVirtualFolder: vfs.BaseVirtualFolder{
-			MappedPath: os.TempDir(),
-		},
-		VirtualPath: "/vdir",
-	})
-	_, err = sshCmd.getSystemCommand()
-	assert.EqualError(t, err, errUnsupportedConfig.Error())
+	_, err = parseRsyncOptions([]string{"--server", "--sender", "-R", ".", "/"})
+	assert.Error(t, err)
 }
 
 func TestSystemCommandSizeForPath(t *testing.T) {
@@ -877,9 +877,9 @@ func TestSystemCommandSizeForPath(t *testing.T) {
 		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
 	}
 	sshCmd := sshCommand{
-		command:    "rsync",
+		command:    "git-upload-archive",
 		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+		args:       []string{"/"},
 	}
 	_, _, err = sshCmd.getSizeForPath(fs, "missing path")
 	assert.NoError(t, err)
@@ -1017,9 +1017,9 @@ func TestCommandGetFsError(t *testing.T) {
 		BaseConnection: common.NewBaseConnection("", common.ProtocolSFTP, "", "", user),
 	}
 	sshCmd := sshCommand{
-		command:    "rsync",
+		command:    "git-upload-archive",
 		connection: conn,
-		args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "/"},
+		args:       []string{"/"},
 	}
 	_, err := sshCmd.getSystemCommand()
 	assert.Error(t, err)
@@ -1858,16 +1858,15 @@ func TestSupportedSecurityOptions(t *testing.T) {
 
 func TestLoadHostKeys(t *testing.T) {
 	configDir := ".."
-	serverConfig := &ssh.ServerConfig{}
 	c := Configuration{}
 	c.HostKeys = []string{".", "missing file"}
-	err := c.checkAndLoadHostKeys(configDir, serverConfig)
+	err := c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	testfile := filepath.Join(os.TempDir(), "invalidkey")
 	err = os.WriteFile(testfile, []byte("some bytes"), os.ModePerm)
 	assert.NoError(t, err)
 	c.HostKeys = []string{testfile}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	err = os.Remove(testfile)
 	assert.NoError(t, err)
@@ -1879,7 +1878,7 @@ func TestLoadHostKeys(t *testing.T) {
 	ed25519KeyName := filepath.Join(keysDir, defaultPrivateEd25519KeyName)
 	nonDefaultKeyName := filepath.Join(keysDir, "akey")
 	c.HostKeys = []string{nonDefaultKeyName, rsaKeyName, ecdsaKeyName, ed25519KeyName}
-	err = c.checkAndLoadHostKeys(configDir, serverConfig)
+	err = c.checkAndLoadHostKeys(configDir)
 	assert.Error(t, err)
 	assert.FileExists(t, rsaKeyName)
 	assert.FileExists(t, ecdsaKeyName)
@@ -1895,16 +1894,16 @@ func TestLoadHostKeys(t *testing.T) {
 		err = os.Chmod(keysDir, 0551)
 		assert.NoError(t, err)
 		c.HostKeys = nil
-		err = c.checkAndLoadHostKeys(keysDir, serverConfig)
+		err = c.checkAndLoadHostKeys(keysDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{rsaKeyName, ecdsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ecdsaKeyName, rsaKeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		c.HostKeys = []string{ed25519KeyName}
-		err = c.checkAndLoadHostKeys(configDir, serverConfig)
+		err = c.checkAndLoadHostKeys(configDir)
 		assert.Error(t, err)
 		err = os.Chmod(keysDir, 0755)
 		assert.NoError(t, err)
@@ -2015,14 +2014,25 @@ func TestListernerAcceptErrors(t *testing.T) {
 	errFake := errors.New("a fake error")
 	listener := newFakeListener(errFake)
 	c := Configuration{}
-	err := c.serve(listener, nil)
+	serve := func(listener net.Listener) error {
+		var group common.ServerGroup
+		group.Reset()
+		group.GoBinding(common.BindingStarter{
+			Key: "test",
+			Start: func(b *common.ServerBinding) error {
+				return c.serve(listener, b, Binding{}, nil)
+			},
+		})
+		return group.Wait()
+	}
+	err := serve(listener)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
 
 	errNetFake := &fakeNetError{error: errFake}
 	listener = newFakeListener(errNetFake)
-	err = c.serve(listener, nil)
+	err = serve(listener)
 	require.EqualError(t, err, errFake.Error())
 	err = listener.Close()
 	require.NoError(t, err)
@@ -2101,3 +2111,335 @@ func TestFolderPrefix(t *testing.T) {
 	c.checkFolderPrefix()
 	assert.Empty(t, c.FolderPrefix)
 }
+
+func TestRevokedUserCerts(t *testing.T) {
+	caKey, err := generateTestSigner()
+	require.NoError(t, err)
+	userKey, err := generateTestSigner()
+	require.NoError(t, err)
+	otherKey, err := generateTestSigner()
+	require.NoError(t, err)
+	newCert := func(serial uint64, keyID string) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             userKey.PublicKey(),
+			Serial:          serial,
+			CertType:        ssh.UserCert,
+			KeyId:           keyID,
+			ValidPrincipals: []string{"team"},
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		require.NoError(t, cert.SignCert(rand.Reader, caKey))
+		return cert
+	}
+
+	var certSection bytes.Buffer
+	writeKRLString(&certSection, caKey.PublicKey().Marshal())
+	writeKRLString(&certSection, nil)
+	var serials bytes.Buffer
+	writeKRLUint64(&serials, 10)
+	writeKRLUint64(&serials, 12)
+	writeKRLSection(&certSection, krlSectionCertSerialList, serials.Bytes())
+	var serialRange bytes.Buffer
+	writeKRLUint64(&serialRange, 100)
+	writeKRLUint64(&serialRange, 200)
+	writeKRLSection(&certSection, krlSectionCertSerialRange, serialRange.Bytes())
+	var bitmap bytes.Buffer
+	writeKRLUint64(&bitmap, 1000)
+	writeKRLString(&bitmap, []byte{0x05}) // 1000 and 1002
+	writeKRLSection(&certSection, krlSectionCertSerialBmap, bitmap.Bytes())
+	var keyIDs bytes.Buffer
+	writeKRLString(&keyIDs, []byte("revoked-id"))
+	writeKRLSection(&certSection, krlSectionCertKeyID, keyIDs.Bytes())
+	var explicitKeys bytes.Buffer
+	writeKRLString(&explicitKeys, otherKey.PublicKey().Marshal())
+
+	var krl bytes.Buffer
+	krl.WriteString(krlMagic)
+	binary.Write(&krl, binary.BigEndian, uint32(krlFormatVersion)) //nolint:errcheck
+	writeKRLUint64(&krl, 1)
+	writeKRLUint64(&krl, uint64(time.Now().Unix()))
+	writeKRLUint64(&krl, 0)
+	writeKRLString(&krl, nil)
+	writeKRLString(&krl, []byte("comment"))
+	writeKRLSection(&krl, krlSectionCertificates, certSection.Bytes())
+	writeKRLSection(&krl, krlSectionExplicitKey, explicitKeys.Bytes())
+
+	krlPath := filepath.Join(os.TempDir(), "revoked.krl")
+	err = os.WriteFile(krlPath, krl.Bytes(), os.ModePerm)
+	require.NoError(t, err)
+	c := Configuration{
+		RevokedUserCertsFile: krlPath,
+	}
+	err = c.initializeCertChecker("")
+	require.NoError(t, err)
+	for _, serial := range []uint64{10, 12, 100, 150, 200, 1000, 1002} {
+		assert.True(t, revokedCertManager.isRevoked(newCert(serial, "")), "serial %v must be revoked", serial)
+	}
+	for _, serial := range []uint64{0, 11, 99, 201, 1001, 1003} {
+		assert.False(t, revokedCertManager.isRevoked(newCert(serial, "")), "serial %v must not be revoked", serial)
+	}
+	assert.True(t, revokedCertManager.isRevoked(newCert(0, "revoked-id")))
+	assert.True(t, revokedCertManager.isRevoked(otherKey.PublicKey()))
+	assert.False(t, revokedCertManager.isRevoked(userKey.PublicKey()))
+	// certificates signed by a revoked key are revoked, the revoked serials only apply to the configured CA
+	cert := newCert(0, "")
+	cert.Serial = 10
+	require.NoError(t, cert.SignCert(rand.Reader, otherKey))
+	assert.True(t, revokedCertManager.isRevoked(cert))
+	anotherCA, err := generateTestSigner()
+	require.NoError(t, err)
+	require.NoError(t, cert.SignCert(rand.Reader, anotherCA))
+	assert.False(t, revokedCertManager.isRevoked(cert))
+
+	// JSON format, the list is replaced on reload
+	jsonContent := fmt.Sprintf(`["%v"]`, ssh.FingerprintSHA256(userKey.PublicKey()))
+	err = os.WriteFile(krlPath, []byte(jsonContent), os.ModePerm)
+	require.NoError(t, err)
+	err = ReloadRevokedUserCerts()
+	require.NoError(t, err)
+	assert.True(t, revokedCertManager.isRevoked(userKey.PublicKey()))
+	assert.True(t, revokedCertManager.isRevoked(newCert(1, "")))
+	assert.False(t, revokedCertManager.isRevoked(otherKey.PublicKey()))
+	// a failed reload keeps the previous list
+	err = os.WriteFile(krlPath, []byte(`["MD5:invalid"]`), os.ModePerm)
+	require.NoError(t, err)
+	err = ReloadRevokedUserCerts()
+	assert.Error(t, err)
+	assert.True(t, revokedCertManager.isRevoked(userKey.PublicKey()))
+	err = os.WriteFile(krlPath, append([]byte(krlMagic), 0, 0, 0, 2), os.ModePerm)
+	require.NoError(t, err)
+	err = ReloadRevokedUserCerts()
+	assert.Error(t, err)
+	err = os.WriteFile(krlPath, krl.Bytes()[:len(krl.Bytes())-3], os.ModePerm)
+	require.NoError(t, err)
+	err = ReloadRevokedUserCerts()
+	assert.Error(t, err)
+
+	err = os.Remove(krlPath)
+	assert.NoError(t, err)
+	err = c.initializeCertChecker("")
+	assert.Error(t, err)
+	c.RevokedUserCertsFile = "."
+	err = c.initializeCertChecker("")
+	assert.Error(t, err)
+	c.RevokedUserCertsFile = ""
+	err = c.initializeCertChecker("")
+	assert.NoError(t, err)
+	assert.False(t, revokedCertManager.isRevoked(userKey.PublicKey()))
+}
+
+func TestForceCommand(t *testing.T) {
+	sftpPayload := ssh.Marshal(&sshSubsystemExecMsg{Command: "sftp"})
+	execPayload := ssh.Marshal(&sshSubsystemExecMsg{Command: "md5sum"})
+
+	reqType, payload := getForcedRequest("exec", execPayload, "")
+	assert.Equal(t, "exec", reqType)
+	assert.Equal(t, execPayload, payload)
+	reqType, payload = getForcedRequest("pty-req", nil, "sha256sum")
+	assert.Equal(t, "pty-req", reqType)
+	assert.Nil(t, payload)
+
+	for _, command := range []string{"internal-sftp", "/usr/lib/openssh/sftp-server"} {
+		reqType, payload = getForcedRequest("exec", execPayload, command)
+		assert.Equal(t, "subsystem", reqType)
+		assert.Equal(t, sftpPayload, payload)
+		reqType, payload = getForcedRequest("subsystem", sftpPayload, command)
+		assert.Equal(t, "subsystem", reqType)
+		assert.Equal(t, sftpPayload, payload)
+	}
+
+	reqType, payload = getForcedRequest("subsystem", sftpPayload, "sha256sum /file")
+	assert.Equal(t, "exec", reqType)
+	assert.Equal(t, ssh.Marshal(&sshSubsystemExecMsg{Command: "sha256sum /file"}), payload)
+	reqType, payload = getForcedRequest("exec", execPayload, "sha256sum /file")
+	assert.Equal(t, "exec", reqType)
+	assert.Equal(t, ssh.Marshal(&sshSubsystemExecMsg{Command: "sha256sum /file"}), payload)
+
+	sshPerm := &ssh.Permissions{
+		Extensions: map[string]string{
+			"sftpgo_user": "{}",
+		},
+	}
+	mergeCertPermissions(sshPerm, nil)
+	assert.Nil(t, sshPerm.CriticalOptions)
+	mergeCertPermissions(sshPerm, &ssh.Permissions{
+		CriticalOptions: map[string]string{
+			forceCommandCriticalOption: internalSFTPCommand,
+		},
+		Extensions: map[string]string{
+			"permit-pty": "",
+		},
+	})
+	assert.Equal(t, internalSFTPCommand, sshPerm.CriticalOptions[forceCommandCriticalOption])
+	assert.Len(t, sshPerm.Extensions, 2)
+}
+
+func generateTestSigner() (ssh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+func writeKRLUint64(b *bytes.Buffer, v uint64) {
+	binary.Write(b, binary.BigEndian, v) //nolint:errcheck
+}
+
+func writeKRLString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s))) //nolint:errcheck
+	b.Write(s)
+}
+
+func writeKRLSection(b *bytes.Buffer, sectionType byte, data []byte) {
+	b.WriteByte(sectionType)
+	writeKRLString(b, data)
+}
+
+func TestBindingHostKeysAndCertificates(t *testing.T) {
+	keysDir := filepath.Join(os.TempDir(), "hostkeys")
+	err := os.MkdirAll(keysDir, os.ModePerm)
+	require.NoError(t, err)
+	defer os.RemoveAll(keysDir)
+
+	hostCA, err := generateTestSigner()
+	require.NoError(t, err)
+	writeHostCert := func(keyName string, principals []string) string {
+		pubBytes, err := os.ReadFile(filepath.Join(keysDir, keyName+".pub"))
+		require.NoError(t, err)
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes)
+		require.NoError(t, err)
+		cert := &ssh.Certificate{
+			Key:             pubKey,
+			CertType:        ssh.HostCert,
+			KeyId:           keyName,
+			ValidPrincipals: principals,
+			ValidBefore:     ssh.CertTimeInfinity,
+		}
+		require.NoError(t, cert.SignCert(rand.Reader, hostCA))
+		certName := keyName + "-cert.pub"
+		err = os.WriteFile(filepath.Join(keysDir, certName), ssh.MarshalAuthorizedKey(cert), 0600)
+		require.NoError(t, err)
+		return certName
+	}
+	for _, name := range []string{"global_key", "brand1_key", "brand2_key"} {
+		err = util.GenerateEd25519Keys(filepath.Join(keysDir, name))
+		require.NoError(t, err)
+	}
+	brand1Cert := writeHostCert("brand1_key", []string{"sftp.brand1.example"})
+	brand2Cert := writeHostCert("brand2_key", []string{"sftp.brand2.example"})
+
+	c := Configuration{
+		HostKeys: []string{"global_key"},
+		Bindings: []Binding{
+			{
+				Port: 2022,
+			},
+			{
+				Address:          "127.0.0.1",
+				Port:             2022,
+				HostKeys:         []string{"brand1_key"},
+				HostCertificates: []string{brand1Cert},
+			},
+			{
+				Address:          "127.0.0.2",
+				Port:             2022,
+				HostKeys:         []string{"brand2_key"},
+				HostCertificates: []string{brand2Cert},
+			},
+		},
+	}
+	err = c.checkAndLoadHostKeys(keysDir)
+	require.NoError(t, err)
+	assert.Len(t, serviceStatus.HostKeys, 3)
+	assert.Len(t, hostKeysMgr.getSigners(c.Bindings[0].GetAddress()), 1)
+	assert.Len(t, hostKeysMgr.getSigners(c.Bindings[1].GetAddress()), 2)
+	assert.Len(t, hostKeysMgr.getSigners(c.Bindings[2].GetAddress()), 2)
+	assert.Len(t, hostKeysMgr.getSigners("unknown:2022"), 1)
+
+	checkHostCert := func(bindingAddress, hostname string) error {
+		serverConfig := hostKeysMgr.getServerConfig(&ssh.ServerConfig{NoClientAuth: true}, bindingAddress)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer serverConn.Close()
+			ssh.NewServerConn(serverConn, serverConfig) //nolint:errcheck
+		}()
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer clientConn.Close()
+		certChecker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+				return bytes.Equal(auth.Marshal(), hostCA.PublicKey().Marshal())
+			},
+		}
+		clientConfig := &ssh.ClientConfig{
+			User:              "user",
+			HostKeyCallback:   certChecker.CheckHostKey,
+			HostKeyAlgorithms: []string{ssh.CertAlgoED25519v01},
+			Timeout:           5 * time.Second,
+		}
+		conn, _, _, err := ssh.NewClientConn(clientConn, hostname+":22", clientConfig)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.NoError(t, checkHostCert(c.Bindings[1].GetAddress(), "sftp.brand1.example"))
+	assert.NoError(t, checkHostCert(c.Bindings[2].GetAddress(), "sftp.brand2.example"))
+	assert.Error(t, checkHostCert(c.Bindings[1].GetAddress(), "sftp.brand2.example"))
+	assert.Error(t, checkHostCert(c.Bindings[0].GetAddress(), "sftp.brand1.example"))
+
+	// the certificates are reloaded, if a reload fails the previous keys are kept
+	brand1Cert = writeHostCert("brand1_key", []string{"sftp.brand1.example", "sftp.brand1.test"})
+	err = ReloadHostKeys()
+	assert.NoError(t, err)
+	assert.NoError(t, checkHostCert(c.Bindings[1].GetAddress(), "sftp.brand1.test"))
+	err = os.WriteFile(filepath.Join(keysDir, brand1Cert), []byte("invalid"), 0600)
+	assert.NoError(t, err)
+	err = ReloadHostKeys()
+	assert.Error(t, err)
+	assert.NoError(t, checkHostCert(c.Bindings[1].GetAddress(), "sftp.brand1.test"))
+	// a certificate must match a host key
+	c.Bindings[1].HostCertificates = []string{brand2Cert}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.Error(t, err)
+	// user certificates are not allowed
+	userKey, err := generateTestSigner()
+	require.NoError(t, err)
+	userCert := &ssh.Certificate{
+		Key:         userKey.PublicKey(),
+		CertType:    ssh.UserCert,
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	require.NoError(t, userCert.SignCert(rand.Reader, hostCA))
+	err = os.WriteFile(filepath.Join(keysDir, "user-cert.pub"), ssh.MarshalAuthorizedKey(userCert), 0600)
+	assert.NoError(t, err)
+	c.Bindings[1].HostCertificates = []string{"user-cert.pub"}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.Error(t, err)
+	c.Bindings[1].HostCertificates = []string{"missing-cert.pub"}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.Error(t, err)
+	c.Bindings[1].HostCertificates = []string{"."}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.Error(t, err)
+	c.Bindings[1].HostCertificates = nil
+	c.Bindings[1].HostKeys = []string{"."}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.Error(t, err)
+	// a binding with certificates only uses the global host keys
+	c.Bindings[1].HostKeys = nil
+	c.Bindings[1].HostCertificates = []string{brand2Cert}
+	c.HostKeys = []string{"global_key", "brand2_key"}
+	err = c.checkAndLoadHostKeys(keysDir)
+	assert.NoError(t, err)
+	assert.Len(t, hostKeysMgr.getSigners(c.Bindings[0].GetAddress()), 2)
+	assert.Len(t, hostKeysMgr.getSigners(c.Bindings[1].GetAddress()), 3)
+	assert.NoError(t, checkHostCert(c.Bindings[1].GetAddress(), "sftp.brand2.example"))
+}
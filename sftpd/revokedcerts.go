@@ -0,0 +1,406 @@
+package sftpd
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+// OpenSSH KRL format, see PROTOCOL.krl in the OpenSSH sources
+const (
+	krlMagic                  = "SSHKRL\n\x00"
+	krlFormatVersion          = 1
+	krlSectionCertificates    = 1
+	krlSectionExplicitKey     = 2
+	krlSectionFingerprintSHA1 = 3
+	krlSectionSignature       = 4
+	krlSectionFingerprintSHA2 = 5
+	krlSectionCertSerialList  = 0x20
+	krlSectionCertSerialRange = 0x21
+	krlSectionCertSerialBmap  = 0x22
+	krlSectionCertKeyID       = 0x23
+)
+
+var revokedCertManager revokedCertsChecker
+
+type serialRange struct {
+	min uint64
+	max uint64
+}
+
+// krlCertSection defines the revoked certificates for a given CA,
+// an empty CA key means any CA
+type krlCertSection struct {
+	caKey   []byte
+	serials map[uint64]bool
+	ranges  []serialRange
+	keyIDs  map[string]bool
+}
+
+func (s *krlCertSection) isRevoked(cert *ssh.Certificate) bool {
+	if len(s.caKey) > 0 && !bytes.Equal(s.caKey, cert.SignatureKey.Marshal()) {
+		return false
+	}
+	if s.keyIDs[cert.KeyId] {
+		return true
+	}
+	// zero serial numbers are ignored, they are the default if the CA does not specify one
+	if cert.Serial == 0 {
+		return false
+	}
+	if s.serials[cert.Serial] {
+		return true
+	}
+	for _, r := range s.ranges {
+		if cert.Serial >= r.min && cert.Serial <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// revokedKeys defines the parsed revocation list. Plain keys are stored as
+// marshaled key blobs and as SHA-1/SHA-256 hashes of the blobs
+type revokedKeys struct {
+	keys        map[string]bool
+	sha1Hashes  map[string]bool
+	sha256Print map[string]bool
+	certs       []*krlCertSection
+}
+
+func newRevokedKeys() *revokedKeys {
+	return &revokedKeys{
+		keys:        make(map[string]bool),
+		sha1Hashes:  make(map[string]bool),
+		sha256Print: make(map[string]bool),
+	}
+}
+
+func (r *revokedKeys) isKeyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	if r.keys[string(blob)] {
+		return true
+	}
+	sha1Hash := sha1.Sum(blob)
+	if r.sha1Hashes[string(sha1Hash[:])] {
+		return true
+	}
+	return r.sha256Print[ssh.FingerprintSHA256(key)]
+}
+
+func (r *revokedKeys) isRevoked(key ssh.PublicKey) bool {
+	if r.isKeyRevoked(key) {
+		return true
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return false
+	}
+	if r.isKeyRevoked(cert.Key) || r.isKeyRevoked(cert.SignatureKey) {
+		return true
+	}
+	for _, s := range r.certs {
+		if s.isRevoked(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+type revokedCertsChecker struct {
+	sync.RWMutex
+	filePath string
+	revoked  *revokedKeys
+}
+
+func (c *revokedCertsChecker) setFilePath(filePath string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.filePath = filePath
+	c.revoked = nil
+}
+
+func (c *revokedCertsChecker) load() error {
+	c.RLock()
+	filePath := c.filePath
+	c.RUnlock()
+
+	if filePath == "" {
+		return nil
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to read revoked user certs file %#v: %w", filePath, err)
+	}
+	var revoked *revokedKeys
+	if bytes.HasPrefix(content, []byte(krlMagic)) {
+		revoked, err = parseKRL(content)
+	} else {
+		revoked, err = parseRevokedKeysJSON(content)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse revoked user certs file %#v: %w", filePath, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.revoked = revoked
+	logger.Info(logSender, "", "revoked user certs file %#v loaded, keys: %v, fingerprints: %v, CA sections: %v",
+		filePath, len(revoked.keys), len(revoked.sha1Hashes)+len(revoked.sha256Print), len(revoked.certs))
+	return nil
+}
+
+func (c *revokedCertsChecker) isRevoked(key ssh.PublicKey) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.revoked == nil {
+		return false
+	}
+	return c.revoked.isRevoked(key)
+}
+
+// ReloadRevokedUserCerts reloads the revoked user certificates file, if any
+func ReloadRevokedUserCerts() error {
+	return revokedCertManager.load()
+}
+
+// parseRevokedKeysJSON parses a JSON list of SHA-256 fingerprints in the
+// same format displayed by "ssh-keygen -l", for example
+// ["SHA256:j5HnKhLUTsH9GFy5ZbUjbFwNj2K6DKnR+4Jl3crmqe8"]
+func parseRevokedKeysJSON(content []byte) (*revokedKeys, error) {
+	var fingerprints []string
+	if err := json.Unmarshal(content, &fingerprints); err != nil {
+		return nil, err
+	}
+	revoked := newRevokedKeys()
+	for _, fp := range fingerprints {
+		fp = strings.TrimSpace(fp)
+		if !strings.HasPrefix(fp, "SHA256:") {
+			return nil, fmt.Errorf("invalid fingerprint %#v, only SHA256 fingerprints are supported", fp)
+		}
+		revoked.sha256Print[fp] = true
+	}
+	return revoked, nil
+}
+
+type krlReader struct {
+	data []byte
+}
+
+func (r *krlReader) isEmpty() bool {
+	return len(r.data) == 0
+}
+
+func (r *krlReader) readByte() (byte, error) {
+	if len(r.data) < 1 {
+		return 0, errors.New("unexpected end of data")
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b, nil
+}
+
+func (r *krlReader) readUint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errors.New("unexpected end of data")
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *krlReader) readUint64() (uint64, error) {
+	if len(r.data) < 8 {
+		return 0, errors.New("unexpected end of data")
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v, nil
+}
+
+func (r *krlReader) readString() ([]byte, error) {
+	length, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(r.data)) < length {
+		return nil, errors.New("unexpected end of data")
+	}
+	s := r.data[:length]
+	r.data = r.data[length:]
+	return s, nil
+}
+
+func parseKRL(content []byte) (*revokedKeys, error) {
+	r := &krlReader{data: content[len(krlMagic):]}
+	version, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if version != krlFormatVersion {
+		return nil, fmt.Errorf("unsupported KRL format version %v", version)
+	}
+	// krl_version, generated_date, flags
+	for i := 0; i < 3; i++ {
+		if _, err := r.readUint64(); err != nil {
+			return nil, err
+		}
+	}
+	// reserved, comment
+	for i := 0; i < 2; i++ {
+		if _, err := r.readString(); err != nil {
+			return nil, err
+		}
+	}
+	revoked := newRevokedKeys()
+	for !r.isEmpty() {
+		sectionType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		sectionData, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		section := &krlReader{data: sectionData}
+		switch sectionType {
+		case krlSectionCertificates:
+			certSection, err := parseKRLCertSection(section)
+			if err != nil {
+				return nil, err
+			}
+			revoked.certs = append(revoked.certs, certSection)
+		case krlSectionExplicitKey, krlSectionFingerprintSHA1, krlSectionFingerprintSHA2:
+			for !section.isEmpty() {
+				blob, err := section.readString()
+				if err != nil {
+					return nil, err
+				}
+				switch sectionType {
+				case krlSectionExplicitKey:
+					revoked.keys[string(blob)] = true
+				case krlSectionFingerprintSHA1:
+					revoked.sha1Hashes[string(blob)] = true
+				default:
+					if len(blob) != sha256.Size {
+						return nil, fmt.Errorf("invalid SHA256 fingerprint length %v", len(blob))
+					}
+					revoked.sha256Print[getSHA256FingerprintFromHash(blob)] = true
+				}
+			}
+		case krlSectionSignature:
+			// signatures are not verified, the file is trusted as the other configuration files
+			return revoked, nil
+		default:
+			return nil, fmt.Errorf("unsupported KRL section type %v", sectionType)
+		}
+	}
+	return revoked, nil
+}
+
+func parseKRLCertSection(r *krlReader) (*krlCertSection, error) {
+	caKey, err := r.readString()
+	if err != nil {
+		return nil, err
+	}
+	if len(caKey) > 0 {
+		if _, err := ssh.ParsePublicKey(caKey); err != nil {
+			return nil, fmt.Errorf("invalid CA key: %w", err)
+		}
+	}
+	// reserved
+	if _, err := r.readString(); err != nil {
+		return nil, err
+	}
+	s := &krlCertSection{
+		caKey:   caKey,
+		serials: make(map[uint64]bool),
+		keyIDs:  make(map[string]bool),
+	}
+	for !r.isEmpty() {
+		subsectionType, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		subsection := &krlReader{data: data}
+		switch subsectionType {
+		case krlSectionCertSerialList:
+			for !subsection.isEmpty() {
+				serial, err := subsection.readUint64()
+				if err != nil {
+					return nil, err
+				}
+				s.serials[serial] = true
+			}
+		case krlSectionCertSerialRange:
+			min, err := subsection.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			max, err := subsection.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			s.ranges = append(s.ranges, serialRange{min: min, max: max})
+		case krlSectionCertSerialBmap:
+			if err := parseKRLSerialBitmap(subsection, s); err != nil {
+				return nil, err
+			}
+		case krlSectionCertKeyID:
+			for !subsection.isEmpty() {
+				keyID, err := subsection.readString()
+				if err != nil {
+					return nil, err
+				}
+				s.keyIDs[string(keyID)] = true
+			}
+		default:
+			return nil, fmt.Errorf("unsupported KRL certificate section type %v", subsectionType)
+		}
+	}
+	return s, nil
+}
+
+func parseKRLSerialBitmap(r *krlReader, s *krlCertSection) error {
+	offset, err := r.readUint64()
+	if err != nil {
+		return err
+	}
+	// the bitmap is an SSH mpint, a positive number is all we need
+	bitmap, err := r.readString()
+	if err != nil {
+		return err
+	}
+	bits := new(big.Int).SetBytes(bitmap)
+	for i := 0; i < bits.BitLen(); i++ {
+		if bits.Bit(i) == 1 {
+			s.serials[offset+uint64(i)] = true
+		}
+	}
+	return nil
+}
+
+func getSHA256FingerprintFromHash(hash []byte) string {
+	// same format as ssh.FingerprintSHA256
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(hash)
+}
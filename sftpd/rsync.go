@@ -0,0 +1,1327 @@
+package sftpd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+const rsyncCmdName = "rsync"
+
+// rsync exit codes
+const (
+	rsyncExitProtocol    = 2
+	rsyncExitUnsupported = 4
+	rsyncExitStreamIO    = 12
+	rsyncExitPartial     = 23
+)
+
+// rsyncExitError is an error with the exit code to return to the rsync client
+type rsyncExitError struct {
+	code uint32
+	err  error
+}
+
+func (e *rsyncExitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rsyncExitError) Unwrap() error {
+	return e.err
+}
+
+// rsyncOptions defines the options sent by the client to the rsync server
+type rsyncOptions struct {
+	sender          bool
+	recursive       bool
+	dirs            bool
+	preserveLinks   bool
+	preservePerms   bool
+	preserveTimes   bool
+	preserveUID     bool
+	preserveGID     bool
+	preserveDevices bool
+	alwaysChecksum  bool
+	dryRun          bool
+	update          bool
+	ignoreTimes     bool
+	sizeOnly        bool
+	ignoreExisting  bool
+	existingOnly    bool
+	deleteMode      bool
+	deleteExcluded  bool
+	pruneEmptyDirs  bool
+	numericIDs      bool
+	omitDirTimes    bool
+	relativePaths   bool
+	protectArgs     bool
+	checksumSeed    int32
+	modifyWindow    int64
+	// the first path is the base directory, the following ones are the source
+	// paths for the sender or the destination path for the receiver
+	paths []string
+}
+
+// receiverWantsFilterList returns true if the client sends the filter list
+// to the receiver, the sender always receives it
+func (o *rsyncOptions) receiverWantsFilterList() bool {
+	return o.pruneEmptyDirs || (o.deleteMode && !o.deleteExcluded)
+}
+
+func (o *rsyncOptions) parseShortOptions(options string) error {
+	for _, ch := range options {
+		switch ch {
+		case 'e':
+			// the remaining characters are the client capabilities
+			return nil
+		case 'r':
+			o.recursive = true
+		case 'd':
+			o.dirs = true
+		case 'l':
+			o.preserveLinks = true
+		case 'p':
+			o.preservePerms = true
+		case 't':
+			o.preserveTimes = true
+		case 'o':
+			o.preserveUID = true
+		case 'g':
+			o.preserveGID = true
+		case 'D':
+			o.preserveDevices = true
+		case 'c':
+			o.alwaysChecksum = true
+		case 'n':
+			o.dryRun = true
+		case 'u':
+			o.update = true
+		case 'I':
+			o.ignoreTimes = true
+		case 'm':
+			o.pruneEmptyDirs = true
+		case 'O':
+			o.omitDirTimes = true
+		case 'R':
+			o.relativePaths = true
+		case 's':
+			o.protectArgs = true
+		case 'v', 'q', 'W', 'x', 'L', 'k', 'K', 'E', 'S', 'i', 'y', 'J':
+			// these options don't change anything for us
+		default:
+			return fmt.Errorf("option -%c is not supported", ch)
+		}
+	}
+	return nil
+}
+
+func (o *rsyncOptions) parseLongOption(option string) error {
+	name := option
+	value := ""
+	if idx := strings.Index(option, "="); idx >= 0 {
+		name = option[:idx]
+		value = option[idx+1:]
+	}
+	switch name {
+	case "--server":
+	case "--sender":
+		o.sender = true
+	case "--delete", "--del", "--delete-before", "--delete-during", "--delete-delay", "--delete-after":
+		o.deleteMode = true
+	case "--delete-excluded":
+		o.deleteMode = true
+		o.deleteExcluded = true
+	case "--numeric-ids":
+		o.numericIDs = true
+	case "--size-only":
+		o.sizeOnly = true
+	case "--ignore-existing":
+		o.ignoreExisting = true
+	case "--existing", "--ignore-non-existing":
+		o.existingOnly = true
+	case "--checksum-seed":
+		seed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid checksum seed %#v", value)
+		}
+		o.checksumSeed = int32(seed)
+	case "--modify-window":
+		window, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid modify window %#v", value)
+		}
+		o.modifyWindow = window
+	case "--safe-links", "--munge-links", "--partial", "--partial-dir", "--inplace", "--timeout", "--delay-updates",
+		"--force", "--no-implied-dirs", "--log-format", "--out-format", "--ignore-errors", "--whole-file",
+		"--one-file-system":
+		// these options don't change anything for us
+	default:
+		return fmt.Errorf("option %v is not supported", name)
+	}
+	return nil
+}
+
+func (o *rsyncOptions) validate() error {
+	if o.sender && o.relativePaths {
+		return errors.New("relative paths are not supported for downloads")
+	}
+	if len(o.paths) == 0 {
+		o.paths = []string{"."}
+	}
+	return nil
+}
+
+func parseRsyncOptions(args []string) (*rsyncOptions, error) {
+	if len(args) == 0 || args[0] != "--server" {
+		return nil, errors.New("only server mode is supported")
+	}
+	opts := &rsyncOptions{}
+	for _, arg := range args {
+		if len(opts.paths) > 0 || !strings.HasPrefix(arg, "-") {
+			opts.paths = append(opts.paths, arg)
+			continue
+		}
+		var err error
+		if strings.HasPrefix(arg, "--") {
+			err = opts.parseLongOption(arg)
+		} else {
+			err = opts.parseShortOptions(arg[1:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, opts.validate()
+}
+
+// rsyncFilterRule is a simplified rsync include/exclude rule.
+// Only "*", "?" and character classes wildcards are supported
+type rsyncFilterRule struct {
+	pattern  string
+	include  bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseRsyncFilterRule(rule string) rsyncFilterRule {
+	r := rsyncFilterRule{}
+	if strings.HasPrefix(rule, "+ ") {
+		r.include = true
+		rule = rule[2:]
+	} else {
+		rule = strings.TrimPrefix(rule, "- ")
+	}
+	if strings.HasSuffix(rule, "/") {
+		r.dirOnly = true
+		rule = strings.TrimSuffix(rule, "/")
+	}
+	if strings.HasPrefix(rule, "/") {
+		r.anchored = true
+		rule = strings.TrimPrefix(rule, "/")
+	}
+	r.pattern = rule
+	return r
+}
+
+func (r *rsyncFilterRule) matches(name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		matched, _ := path.Match(r.pattern, name)
+		return matched
+	}
+	if !strings.Contains(r.pattern, "/") {
+		matched, _ := path.Match(r.pattern, path.Base(name))
+		return matched
+	}
+	// a pattern with a slash is matched against the final components of the name
+	for {
+		if matched, _ := path.Match(r.pattern, name); matched {
+			return true
+		}
+		idx := strings.Index(name, "/")
+		if idx < 0 {
+			return false
+		}
+		name = name[idx+1:]
+	}
+}
+
+// rsyncTransferReader allows to read a download transfer sequentially
+type rsyncTransferReader struct {
+	transfer *transfer
+	offset   int64
+	err      error
+}
+
+func (r *rsyncTransferReader) Read(p []byte) (int, error) {
+	n, err := r.transfer.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// rsyncCommand implements the rsync protocol, as server, on top of the
+// SFTPGo virtual filesystem. It doesn't require the rsync binary
+type rsyncCommand struct {
+	sshCommand
+	opts      *rsyncOptions
+	conn      *rsyncConn
+	seed      int32
+	filters   []rsyncFilterRule
+	destDir   string
+	hasErrors int32
+}
+
+func (c *rsyncCommand) handle() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handle rsync command: %#v stack strace: %v", r, string(debug.Stack()))
+			err = common.ErrGenericFailure
+		}
+	}()
+	common.Connections.Add(c.connection)
+	defer common.Connections.Remove(c.connection.GetID())
+
+	c.connection.Log(logger.LevelDebug, "handle rsync command, args: %v user: %v", c.args, c.connection.User.Username)
+	c.conn = newRsyncConn(c.connection.channel, c.connection.channel)
+	err = c.run()
+	c.sendExitStatus(err)
+	return err
+}
+
+func (c *rsyncCommand) run() error {
+	opts, err := parseRsyncOptions(c.args)
+	if err == nil && opts.protectArgs {
+		// the real arguments are sent over the connection, the first one is the program name
+		var args []string
+		args, err = c.conn.readProtectedArgs()
+		if err != nil {
+			return &rsyncExitError{code: rsyncExitStreamIO, err: err}
+		}
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			args = args[1:]
+		}
+		c.args = args
+		opts, err = parseRsyncOptions(args)
+	}
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unsupported rsync command, args: %v, err: %v", c.args, err)
+		return c.sendSetupError(&rsyncExitError{code: rsyncExitUnsupported, err: err})
+	}
+	c.opts = opts
+	if err := c.setupProtocol(); err != nil {
+		return err
+	}
+	if opts.sender {
+		err = c.handleSender()
+	} else {
+		err = c.handleReceiver()
+	}
+	if err != nil {
+		return c.getFatalError(err)
+	}
+	if atomic.LoadInt32(&c.hasErrors) == 1 {
+		return &rsyncExitError{code: rsyncExitPartial, err: errors.New("some files could not be transferred")}
+	}
+	return nil
+}
+
+func (c *rsyncCommand) setupProtocol() error {
+	if err := c.conn.writeInt(rsyncProtocolVersion); err != nil {
+		return err
+	}
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	remoteVersion, err := c.conn.readInt()
+	if err != nil {
+		return &rsyncExitError{code: rsyncExitStreamIO, err: err}
+	}
+	if remoteVersion < rsyncProtocolVersion {
+		err := fmt.Errorf("protocol version %v is not supported, at least version %v is required", remoteVersion,
+			rsyncProtocolVersion)
+		return c.sendSetupError(&rsyncExitError{code: rsyncExitProtocol, err: err})
+	}
+	c.seed = c.opts.checksumSeed
+	if c.seed == 0 {
+		c.seed = int32(time.Now().Unix() ^ int64(os.Getpid()<<6))
+	}
+	if err := c.conn.writeInt(c.seed); err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "rsync protocol setup done, remote version: %v, sender: %v",
+		remoteVersion, c.opts.sender)
+	return c.conn.startMultiplex()
+}
+
+// sendSetupError sends an error before the output is multiplexed, the client
+// displays what we write to stderr
+func (c *rsyncCommand) sendSetupError(err error) error {
+	if channel, ok := c.connection.channel.(ssh.Channel); ok {
+		channel.Stderr().Write([]byte(fmt.Sprintf("rsync: %v\n", err))) //nolint:errcheck
+	}
+	return err
+}
+
+func (c *rsyncCommand) getFatalError(err error) error {
+	var exitErr *rsyncExitError
+	if errors.As(err, &exitErr) {
+		return err
+	}
+	code := uint32(rsyncExitStreamIO)
+	if errors.Is(err, errRsyncProtocol) {
+		code = rsyncExitProtocol
+	}
+	c.conn.writeMessage(rsyncMsgError, fmt.Sprintf("rsync: %v\n", err)) //nolint:errcheck
+	return &rsyncExitError{code: code, err: err}
+}
+
+// reportError sends a non fatal error to the client, the transfer continues
+// but the exit code will be not zero
+func (c *rsyncCommand) reportError(format string, v ...interface{}) {
+	atomic.StoreInt32(&c.hasErrors, 1)
+	msg := fmt.Sprintf(format, v...)
+	c.connection.Log(logger.LevelInfo, "rsync error: %v", msg)
+	c.conn.writeMessage(rsyncMsgError, fmt.Sprintf("rsync: %v\n", msg)) //nolint:errcheck
+}
+
+func (c *rsyncCommand) reportInfo(format string, v ...interface{}) {
+	c.conn.writeMessage(rsyncMsgInfo, fmt.Sprintf(format, v...)+"\n") //nolint:errcheck
+}
+
+func (c *rsyncCommand) readFilterList() error {
+	for {
+		length, err := c.conn.readInt()
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
+		if length < 0 || length > 4096 {
+			return fmt.Errorf("%w: invalid filter rule length %v", errRsyncProtocol, length)
+		}
+		rule, err := c.conn.readString(int(length))
+		if err != nil {
+			return err
+		}
+		if rule == "!" {
+			c.filters = nil
+			continue
+		}
+		c.filters = append(c.filters, parseRsyncFilterRule(rule))
+	}
+}
+
+func (c *rsyncCommand) isExcluded(name string, isDir bool) bool {
+	for idx := range c.filters {
+		if c.filters[idx].matches(name, isDir) {
+			return !c.filters[idx].include
+		}
+	}
+	return false
+}
+
+func (c *rsyncCommand) getVirtualPath(name string) string {
+	return util.CleanPath(path.Join(c.opts.paths[0], name))
+}
+
+func (c *rsyncCommand) handleSender() error {
+	if err := c.readFilterList(); err != nil {
+		return err
+	}
+	entries, ioError := c.getSenderFileList()
+	writer := &rsyncFileListWriter{
+		conn: c.conn,
+		opts: c.opts,
+	}
+	totalSize := int64(0)
+	for _, entry := range entries {
+		if err := writer.writeEntry(entry); err != nil {
+			return err
+		}
+		if entry.isRegular() {
+			totalSize += entry.size
+		}
+	}
+	if err := writer.finish(ioError); err != nil {
+		return err
+	}
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	c.connection.Log(logger.LevelDebug, "rsync file list sent, entries: %v, total size: %v", len(entries), totalSize)
+	if err := c.sendFiles(entries); err != nil {
+		return err
+	}
+	bytesRead, bytesWritten := c.conn.getStats()
+	for _, v := range []int64{bytesRead, bytesWritten, totalSize} {
+		if err := c.conn.writeLongint(v); err != nil {
+			return err
+		}
+	}
+	if err := c.conn.flush(); err != nil {
+		return err
+	}
+	goodbye, err := c.conn.readInt()
+	if err != nil {
+		return err
+	}
+	if goodbye != rsyncNdxDone {
+		return fmt.Errorf("%w: invalid final message %v", errRsyncProtocol, goodbye)
+	}
+	return nil
+}
+
+func (c *rsyncCommand) getSenderFileList() ([]*rsyncFileEntry, bool) {
+	var entries []*rsyncFileEntry
+	ioError := false
+	names := make(map[string]bool)
+	addEntry := func(entry *rsyncFileEntry) {
+		if !names[entry.name] {
+			names[entry.name] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	sources := c.opts.paths[1:]
+	if len(sources) == 0 {
+		sources = []string{"."}
+	}
+	for _, source := range sources {
+		if err := c.addSenderPath(source, addEntry); err != nil {
+			ioError = true
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, ioError
+}
+
+func (c *rsyncCommand) addSenderPath(source string, addEntry func(*rsyncFileEntry)) error {
+	virtualPath := c.getVirtualPath(source)
+	info, err := c.connection.DoStat(virtualPath, 0)
+	if err != nil {
+		c.reportError("link_stat %#v failed: %v", source, err)
+		return err
+	}
+	if info.Mode().IsRegular() {
+		addEntry(c.getSenderFileEntry(path.Base(virtualPath), virtualPath, info))
+		return nil
+	}
+	if !info.IsDir() {
+		c.reportInfo("skipping non-regular file %#v", source)
+		return nil
+	}
+	if !c.opts.recursive && !c.opts.dirs {
+		c.reportInfo("skipping directory %v", source)
+		return nil
+	}
+	// with a trailing slash the directory contents are sent instead of the directory itself
+	name := "."
+	if virtualPath != "/" && !strings.HasSuffix(source, "/") && path.Base(source) != "." {
+		name = path.Base(virtualPath)
+	}
+	entry := c.getSenderFileEntry(name, virtualPath, info)
+	entry.flags = rsyncXmitTopDir
+	addEntry(entry)
+	if c.opts.recursive || name == "." {
+		return c.walkSenderDir(virtualPath, name, addEntry)
+	}
+	return nil
+}
+
+func (c *rsyncCommand) walkSenderDir(virtualPath, name string, addEntry func(*rsyncFileEntry)) error {
+	c.connection.UpdateLastActivity()
+
+	files, err := c.connection.ListDir(virtualPath)
+	if err != nil {
+		c.reportError("opendir %#v failed: %v", virtualPath, err)
+		return err
+	}
+	var walkErr error
+	for _, info := range files {
+		childPath := path.Join(virtualPath, info.Name())
+		childName := info.Name()
+		if name != "." {
+			childName = path.Join(name, info.Name())
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// symlinks are followed, symlinks to directories are skipped to avoid loops
+			info, err = c.connection.DoStat(childPath, 0)
+			if err != nil {
+				c.reportError("symlink %#v has no referent: %v", childName, err)
+				walkErr = err
+				continue
+			}
+			if info.IsDir() {
+				c.reportInfo("skipping symlink to directory %#v", childName)
+				continue
+			}
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		if c.isExcluded(childName, info.IsDir()) || (!info.IsDir() && !c.connection.User.IsFileAllowed(childPath)) {
+			continue
+		}
+		addEntry(c.getSenderFileEntry(childName, childPath, info))
+		if info.IsDir() && c.opts.recursive {
+			if err := c.walkSenderDir(childPath, childName, addEntry); err != nil {
+				walkErr = err
+			}
+		}
+	}
+	return walkErr
+}
+
+func (c *rsyncCommand) getSenderFileEntry(name, virtualPath string, info os.FileInfo) *rsyncFileEntry {
+	entry := &rsyncFileEntry{
+		name:        name,
+		modTime:     info.ModTime().Unix(),
+		mode:        getRsyncFileMode(info),
+		virtualPath: virtualPath,
+	}
+	if info.Mode().IsRegular() {
+		entry.size = info.Size()
+		if c.opts.alwaysChecksum {
+			entry.checksum = c.getFileChecksum(virtualPath)
+		}
+	}
+	return entry
+}
+
+// getFileChecksum returns the checksum used for the rsync --checksum option,
+// nil is returned if the checksum cannot be computed
+func (c *rsyncCommand) getFileChecksum(virtualPath string) []byte {
+	if !c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return nil
+	}
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil
+	}
+	hash, err := c.computeHashForFile(fs, md4.New(), fsPath)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to compute checksum for %#v: %v", virtualPath, err)
+		return nil
+	}
+	checksum, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil
+	}
+	return checksum
+}
+
+func (c *rsyncCommand) sendFiles(entries []*rsyncFileEntry) error {
+	phase := 0
+	for {
+		ndx, err := c.conn.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == rsyncNdxDone {
+			// the client sends a redo phase for the files with checksum errors
+			phase++
+			if phase > 1 {
+				break
+			}
+			if err := c.conn.writeInt(rsyncNdxDone); err != nil {
+				return err
+			}
+			if err := c.conn.flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(entries) || !entries[ndx].isRegular() {
+			return fmt.Errorf("%w: invalid file index %v", errRsyncProtocol, ndx)
+		}
+		head, err := c.conn.readSumHead()
+		if err != nil {
+			return err
+		}
+		sums, err := c.conn.readBlockSums(head, entries[ndx].size)
+		if err != nil {
+			return err
+		}
+		if err := c.sendFile(ndx, entries[ndx], head, sums); err != nil {
+			return err
+		}
+		if err := c.conn.flush(); err != nil {
+			return err
+		}
+	}
+	return c.conn.writeInt(rsyncNdxDone)
+}
+
+// sendFile sends the requested file, only the errors writing to the client are returned
+func (c *rsyncCommand) sendFile(ndx int32, entry *rsyncFileEntry, head rsyncSumHead, sums []rsyncBlockSum) error {
+	c.connection.UpdateLastActivity()
+
+	t, err := c.openDownload(entry.virtualPath)
+	if err != nil {
+		c.reportError("send_files failed to open %#v: %v", entry.name, err)
+		return nil
+	}
+	if err := c.conn.writeInt(ndx); err != nil {
+		t.TransferError(err)
+		t.Close()
+		return err
+	}
+	if err := c.conn.writeSumHead(head); err != nil {
+		t.TransferError(err)
+		t.Close()
+		return err
+	}
+	reader := &rsyncTransferReader{transfer: t}
+	sender := newRsyncTokenSender(c.conn, head, sums, c.seed, reader)
+	checksum, err := sender.send()
+	if err != nil {
+		t.TransferError(err)
+		t.Close()
+		if reader.err == nil {
+			return err
+		}
+		// a bad checksum tells the client that the file is not valid
+		c.reportError("read errors mapping %#v: %v", entry.name, reader.err)
+		if err := c.conn.writeInt(0); err != nil {
+			return err
+		}
+		return c.conn.write(make([]byte, rsyncSumLength))
+	}
+	if err := c.conn.write(checksum); err != nil {
+		t.TransferError(err)
+		t.Close()
+		return err
+	}
+	if err := t.Close(); err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to close download transfer for %#v: %v", entry.virtualPath, err)
+	}
+	return nil
+}
+
+func (c *rsyncCommand) openDownload(virtualPath string) (*transfer, error) {
+	fs, p, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		c.connection.Log(logger.LevelWarn, "error downloading file: %#v, permission denied", virtualPath)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	if !c.connection.User.IsFileAllowed(virtualPath) {
+		c.connection.Log(logger.LevelWarn, "reading file %#v is not allowed", virtualPath)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	if err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreDownload, p, virtualPath, 0, 0); err != nil {
+		c.connection.Log(logger.LevelDebug, "download for file %#v denied by pre action: %v", virtualPath, err)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	file, r, cancelFn, err := fs.Open(p, 0)
+	if err != nil {
+		c.connection.Log(logger.LevelError, "could not open file %#v for reading: %v", p, err)
+		return nil, c.connection.GetFsError(fs, err)
+	}
+	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, p, p, virtualPath,
+		common.TransferDownload, 0, 0, 0, false, fs)
+	return newTransfer(baseTransfer, nil, r, nil), nil
+}
+
+func (c *rsyncCommand) handleReceiver() error {
+	if c.opts.receiverWantsFilterList() {
+		if err := c.readFilterList(); err != nil {
+			return err
+		}
+	}
+	entries, ioError, err := c.conn.readFileList(c.opts)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	for _, entry := range entries {
+		if !isRsyncNameValid(entry.name) {
+			return fmt.Errorf("%w: invalid file name %#v", errRsyncProtocol, entry.name)
+		}
+	}
+	c.connection.Log(logger.LevelDebug, "rsync file list received, entries: %v", len(entries))
+	paths, err := c.getReceiverPaths(entries)
+	if err != nil {
+		c.reportError("%v", err)
+		return &rsyncExitError{code: rsyncExitPartial, err: err}
+	}
+
+	errGenerator := make(chan error, 1)
+	go func() {
+		errGenerator <- c.generateFiles(entries, paths)
+	}()
+	if err := c.receiveFiles(entries, paths); err != nil {
+		return err
+	}
+	if err := <-errGenerator; err != nil {
+		return err
+	}
+	if c.opts.deleteMode && !c.opts.dryRun {
+		if ioError {
+			c.reportError("IO error encountered on the client side, skipping file deletion")
+		} else {
+			c.deleteExtraneousFiles(entries, paths)
+		}
+	}
+	// directory attributes are set at the end, the transfer modifies them
+	for idx, entry := range entries {
+		if entry.isDir() {
+			c.setAttributes(entry, paths[idx], nil)
+		}
+	}
+	if err := c.conn.writeInt(rsyncNdxDone); err != nil {
+		return err
+	}
+	return c.conn.flush()
+}
+
+// getReceiverPaths returns the virtual paths for the received file list
+func (c *rsyncCommand) getReceiverPaths(entries []*rsyncFileEntry) ([]string, error) {
+	paths := make([]string, len(entries))
+	destination := "."
+	if len(c.opts.paths) > 1 {
+		destination = c.opts.paths[1]
+	}
+	destPath := c.getVirtualPath(destination)
+
+	info, err := c.connection.DoStat(destPath, 0)
+	if err == nil && !info.IsDir() {
+		if len(entries) > 1 {
+			return nil, errors.New("destination must be a directory when copying more than 1 file")
+		}
+		// we are writing a single file
+		paths[0] = destPath
+		return paths, nil
+	}
+	if err != nil {
+		if !c.connection.IsNotExistError(err) {
+			return nil, fmt.Errorf("unable to stat destination %#v: %w", destination, err)
+		}
+		if len(entries) == 1 && !entries[0].isDir() && !strings.HasSuffix(destination, "/") {
+			paths[0] = destPath
+			return paths, nil
+		}
+		if !c.opts.dryRun {
+			if err := c.connection.CreateDir(destPath); err != nil {
+				return nil, fmt.Errorf("mkdir %#v failed: %w", destination, err)
+			}
+		}
+	}
+	c.destDir = destPath
+	for idx, entry := range entries {
+		paths[idx] = path.Join(destPath, entry.name)
+	}
+	return paths, nil
+}
+
+func isRsyncNameValid(name string) bool {
+	if name == "." {
+		return true
+	}
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// generateFiles checks the received file list and requests the files to transfer.
+// The directories are created here
+func (c *rsyncCommand) generateFiles(entries []*rsyncFileEntry, paths []string) (err error) {
+	defer func() {
+		if err != nil {
+			// unblock the receiver, it could wait for files that will be never requested
+			c.connection.channel.Close()
+		}
+	}()
+
+	for idx, entry := range entries {
+		c.connection.UpdateLastActivity()
+
+		switch {
+		case entry.isDir():
+			c.generateDir(paths[idx])
+		case entry.isSymlink() && c.opts.preserveLinks:
+			c.generateSymlink(entry, paths[idx])
+		case entry.isRegular():
+			if !c.isTransferNeeded(entry, paths[idx]) || c.opts.dryRun {
+				continue
+			}
+			if err := c.conn.writeInt(int32(idx)); err != nil {
+				return err
+			}
+			// we never send block checksums, the client will send the whole file
+			if err := c.conn.writeSumHead(rsyncSumHead{}); err != nil {
+				return err
+			}
+			if err := c.conn.flush(); err != nil {
+				return err
+			}
+		default:
+			c.reportInfo("skipping non-regular file %#v", entry.name)
+		}
+	}
+	// the end of the transfer phase and of the redo phase, we never request files again
+	for i := 0; i < 2; i++ {
+		if err := c.conn.writeInt(rsyncNdxDone); err != nil {
+			return err
+		}
+	}
+	return c.conn.flush()
+}
+
+func (c *rsyncCommand) generateDir(virtualPath string) {
+	info, err := c.connection.DoStat(virtualPath, 1)
+	if err == nil {
+		if !info.IsDir() {
+			c.reportError("cannot create directory %#v: a file with the same name exists", virtualPath)
+		}
+		return
+	}
+	if !c.connection.IsNotExistError(err) {
+		c.reportError("unable to stat %#v: %v", virtualPath, err)
+		return
+	}
+	if c.opts.dryRun {
+		return
+	}
+	if err := c.connection.CreateDir(virtualPath); err != nil {
+		c.reportError("mkdir %#v failed: %v", virtualPath, err)
+	}
+}
+
+// generateSymlink creates symlinks inside the transfer directory,
+// symlinks pointing outside it are ignored as with the --safe-links option
+func (c *rsyncCommand) generateSymlink(entry *rsyncFileEntry, virtualPath string) {
+	target := path.Join(path.Dir(virtualPath), entry.linkTarget)
+	if c.destDir == "" || path.IsAbs(entry.linkTarget) ||
+		(target != c.destDir && !strings.HasPrefix(target, strings.TrimSuffix(c.destDir, "/")+"/")) {
+		c.reportInfo("ignoring unsafe symlink %#v -> %#v", entry.name, entry.linkTarget)
+		return
+	}
+	if _, err := c.connection.DoStat(virtualPath, 1); err == nil {
+		// existing symlinks are not updated
+		return
+	}
+	if c.opts.dryRun {
+		return
+	}
+	if err := c.connection.CreateSymlink(target, virtualPath); err != nil {
+		c.reportError("symlink %#v -> %#v failed: %v", entry.name, entry.linkTarget, err)
+	}
+}
+
+func (c *rsyncCommand) isTransferNeeded(entry *rsyncFileEntry, virtualPath string) bool {
+	info, err := c.connection.DoStat(virtualPath, 1)
+	if err != nil {
+		if !c.connection.IsNotExistError(err) {
+			c.reportError("unable to stat %#v: %v", entry.name, err)
+			return false
+		}
+		if c.opts.existingOnly {
+			return false
+		}
+		if !c.connection.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) ||
+			!c.connection.User.IsFileAllowed(virtualPath) {
+			c.reportError("unable to upload %#v: %v", entry.name, c.connection.GetPermissionDeniedError())
+			return false
+		}
+		return true
+	}
+	if info.IsDir() {
+		c.reportError("cannot overwrite directory %#v with a file", entry.name)
+		return false
+	}
+	if c.opts.ignoreExisting {
+		return false
+	}
+	if c.opts.update && info.ModTime().Unix() > entry.modTime {
+		return false
+	}
+	if c.isUnchanged(entry, virtualPath, info) {
+		c.setAttributes(entry, virtualPath, info)
+		return false
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermOverwrite, virtualPath) ||
+		!c.connection.User.IsFileAllowed(virtualPath) {
+		c.reportError("unable to overwrite %#v: %v", entry.name, c.connection.GetPermissionDeniedError())
+		return false
+	}
+	return true
+}
+
+// isUnchanged implements the rsync quick check
+func (c *rsyncCommand) isUnchanged(entry *rsyncFileEntry, virtualPath string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || info.Size() != entry.size {
+		return false
+	}
+	if c.opts.alwaysChecksum {
+		return bytes.Equal(c.getFileChecksum(virtualPath), entry.checksum)
+	}
+	if c.opts.sizeOnly {
+		return true
+	}
+	if c.opts.ignoreTimes {
+		return false
+	}
+	diff := info.ModTime().Unix() - entry.modTime
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= c.opts.modifyWindow
+}
+
+// setAttributes sets the modification time and the permissions, if requested.
+// Missing permissions and unsupported operations are silently ignored, the
+// file was transferred anyway
+func (c *rsyncCommand) setAttributes(entry *rsyncFileEntry, virtualPath string, info os.FileInfo) {
+	if c.opts.dryRun || virtualPath == "/" || c.connection.User.IsVirtualFolder(virtualPath) {
+		return
+	}
+	if c.opts.preserveTimes && (!entry.isDir() || !c.opts.omitDirTimes) {
+		if info == nil || info.ModTime().Unix() != entry.modTime {
+			mtime := time.Unix(entry.modTime, 0)
+			c.setStat(entry, virtualPath, &common.StatAttributes{
+				Flags: common.StatAttrTimes,
+				Atime: mtime,
+				Mtime: mtime,
+			})
+		}
+	}
+	if c.opts.preservePerms {
+		mode := os.FileMode(entry.mode) & os.ModePerm
+		if info == nil || info.Mode().Perm() != mode {
+			c.setStat(entry, virtualPath, &common.StatAttributes{
+				Flags: common.StatAttrPerms,
+				Mode:  mode,
+			})
+		}
+	}
+}
+
+func (c *rsyncCommand) setStat(entry *rsyncFileEntry, virtualPath string, attributes *common.StatAttributes) {
+	if err := c.connection.SetStat(virtualPath, attributes); err != nil {
+		if errors.Is(err, common.ErrPermissionDenied) || errors.Is(err, common.ErrOpUnsupported) {
+			c.connection.Log(logger.LevelDebug, "unable to set attributes for %#v: %v", virtualPath, err)
+			return
+		}
+		c.reportError("failed to set attributes for %#v: %v", entry.name, err)
+	}
+}
+
+func (c *rsyncCommand) receiveFiles(entries []*rsyncFileEntry, paths []string) error {
+	phase := 0
+	for {
+		ndx, err := c.conn.readInt()
+		if err != nil {
+			return err
+		}
+		if ndx == rsyncNdxDone {
+			phase++
+			if phase > 1 {
+				return nil
+			}
+			continue
+		}
+		if ndx < 0 || int(ndx) >= len(entries) || !entries[ndx].isRegular() {
+			return fmt.Errorf("%w: invalid file index %v", errRsyncProtocol, ndx)
+		}
+		if err := c.receiveFile(entries[ndx], paths[ndx]); err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile stores the received file data, only the errors reading from the
+// client are returned
+func (c *rsyncCommand) receiveFile(entry *rsyncFileEntry, virtualPath string) error {
+	c.connection.UpdateLastActivity()
+
+	head, err := c.conn.readSumHead()
+	if err != nil {
+		return err
+	}
+	if head.count != 0 {
+		return fmt.Errorf("%w: unexpected block checksums for %#v", errRsyncProtocol, entry.name)
+	}
+	t, err := c.openUpload(virtualPath)
+	if err != nil {
+		c.reportError("unable to upload %#v: %v", entry.name, err)
+		return c.discardFileData()
+	}
+	errWrite, err := c.readFileData(t, entry)
+	if err != nil {
+		t.TransferError(err)
+		t.Close()
+		return err
+	}
+	errClose := t.Close()
+	if errWrite == nil {
+		errWrite = errClose
+	}
+	if errWrite != nil {
+		c.reportError("unable to upload %#v: %v", entry.name, errWrite)
+		return nil
+	}
+	c.setAttributes(entry, virtualPath, nil)
+	return nil
+}
+
+// readFileData writes the received data to the transfer, the first returned
+// error is the write error, if any, the second one is the error reading from the client
+func (c *rsyncCommand) readFileData(t *transfer, entry *rsyncFileEntry) (error, error) {
+	hasher := newRsyncFileHasher(c.seed)
+	buf := make([]byte, rsyncChunkSize)
+	offset := int64(0)
+	var errWrite error
+	for {
+		token, err := c.conn.readInt()
+		if err != nil {
+			return errWrite, err
+		}
+		if token == 0 {
+			break
+		}
+		if token < 0 {
+			return errWrite, fmt.Errorf("%w: unexpected block reference for %#v", errRsyncProtocol, entry.name)
+		}
+		for remaining := int(token); remaining > 0; {
+			n := remaining
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if err := c.conn.readFull(buf[:n]); err != nil {
+				return errWrite, err
+			}
+			hasher.Write(buf[:n]) //nolint:errcheck
+			if errWrite == nil {
+				_, errWrite = t.WriteAt(buf[:n], offset)
+			}
+			offset += int64(n)
+			remaining -= n
+		}
+	}
+	checksum := make([]byte, rsyncSumLength)
+	if err := c.conn.readFull(checksum); err != nil {
+		return errWrite, err
+	}
+	if errWrite == nil && !bytes.Equal(checksum, hasher.Sum(nil)) {
+		errWrite = errors.New("checksum mismatch")
+		t.TransferError(errWrite)
+	}
+	return errWrite, nil
+}
+
+// discardFileData reads and ignores the data for a file we are unable to store
+func (c *rsyncCommand) discardFileData() error {
+	for {
+		token, err := c.conn.readInt()
+		if err != nil {
+			return err
+		}
+		if token == 0 {
+			break
+		}
+		if token < 0 {
+			return fmt.Errorf("%w: unexpected block reference", errRsyncProtocol)
+		}
+		if _, err := io.CopyN(io.Discard, c.conn.reader, int64(token)); err != nil {
+			return err
+		}
+	}
+	_, err := io.CopyN(io.Discard, c.conn.reader, rsyncSumLength)
+	return err
+}
+
+func (c *rsyncCommand) openUpload(virtualPath string) (*transfer, error) {
+	fs, p, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	if !c.connection.User.IsFileAllowed(virtualPath) {
+		c.connection.Log(logger.LevelWarn, "writing file %#v is not allowed", virtualPath)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	filePath := p
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+	stat, statErr := fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
+		if !c.connection.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+			return nil, c.connection.GetPermissionDeniedError()
+		}
+		return c.createUploadTransfer(fs, p, filePath, virtualPath, true, 0)
+	}
+	if statErr != nil {
+		c.connection.Log(logger.LevelError, "error performing file stat %#v: %v", p, statErr)
+		return nil, c.connection.GetFsError(fs, statErr)
+	}
+	if stat.IsDir() {
+		return nil, fmt.Errorf("attempted to open a directory for writing: %#v", virtualPath)
+	}
+	if !c.connection.User.HasPerm(dataprovider.PermOverwrite, virtualPath) {
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+		if err := fs.Rename(p, filePath); err != nil {
+			c.connection.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %v",
+				p, filePath, err)
+			return nil, c.connection.GetFsError(fs, err)
+		}
+	}
+	return c.createUploadTransfer(fs, p, filePath, virtualPath, !vfs.IsQuotaTracked(stat), vfs.GetQuotaSize(stat))
+}
+
+func (c *rsyncCommand) createUploadTransfer(fs vfs.Fs, resolvedPath, filePath, requestPath string, isNewFile bool,
+	fileSize int64,
+) (*transfer, error) {
+	quotaResult := c.connection.HasSpace(isNewFile, false, requestPath)
+	if !quotaResult.HasSpace {
+		return nil, c.connection.GetQuotaExceededError()
+	}
+	err := common.ExecutePreAction(c.connection.BaseConnection, common.OperationPreUpload, resolvedPath, requestPath,
+		fileSize, os.O_TRUNC)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "upload for file %#v denied by pre action: %v", requestPath, err)
+		return nil, c.connection.GetPermissionDeniedError()
+	}
+	maxWriteSize, _ := c.connection.GetMaxWriteSize(quotaResult, false, fileSize, fs.IsUploadResumeSupported())
+
+	file, w, cancelFn, err := fs.Create(filePath, 0)
+	if err != nil {
+		c.connection.Log(logger.LevelError, "error creating file %#v: %v", resolvedPath, err)
+		return nil, c.connection.GetFsError(fs, err)
+	}
+	initialSize := int64(0)
+	if !isNewFile {
+		if vfs.IsLocalOrSFTPFs(fs) {
+			c.updateQuota(requestPath, 0, -fileSize)
+		} else {
+			initialSize = fileSize
+		}
+		if maxWriteSize > 0 {
+			maxWriteSize += fileSize
+		}
+	}
+	vfs.SetPathPermissions(fs, filePath, c.connection.User.GetUID(), c.connection.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, filePath,
+		requestPath, common.TransferUpload, 0, initialSize, maxWriteSize, isNewFile, fs)
+	return newTransfer(baseTransfer, w, nil, nil), nil
+}
+
+// deleteExtraneousFiles removes the files not present in the received file
+// list from the transferred directories
+func (c *rsyncCommand) deleteExtraneousFiles(entries []*rsyncFileEntry, paths []string) {
+	received := make(map[string]bool)
+	for _, p := range paths {
+		received[p] = true
+	}
+	for idx, entry := range entries {
+		if !entry.isDir() || (!c.opts.recursive && entry.flags&rsyncXmitTopDir == 0) {
+			continue
+		}
+		files, err := c.connection.ListDir(paths[idx])
+		if err != nil {
+			c.reportError("unable to list %#v for deletion: %v", entry.name, err)
+			continue
+		}
+		for _, info := range files {
+			childPath := path.Join(paths[idx], info.Name())
+			if received[childPath] || c.connection.User.IsVirtualFolder(childPath) {
+				continue
+			}
+			childName := info.Name()
+			if entry.name != "." {
+				childName = path.Join(entry.name, info.Name())
+			}
+			if !c.opts.deleteExcluded && c.isExcluded(childName, info.IsDir()) {
+				continue
+			}
+			if err := c.removePath(childPath, info); err != nil {
+				c.reportError("delete %#v failed: %v", childName, err)
+			}
+		}
+	}
+}
+
+func (c *rsyncCommand) removePath(virtualPath string, info os.FileInfo) error {
+	c.connection.UpdateLastActivity()
+
+	if info.IsDir() {
+		files, err := c.connection.ListDir(virtualPath)
+		if err != nil {
+			return err
+		}
+		for _, child := range files {
+			if err := c.removePath(path.Join(virtualPath, child.Name()), child); err != nil {
+				return err
+			}
+		}
+		return c.connection.RemoveDir(virtualPath)
+	}
+	fs, fsPath, err := c.connection.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	return c.connection.RemoveFile(fs, fsPath, virtualPath, info)
+}
+
+func getRsyncFileMode(info os.FileInfo) uint32 {
+	mode := uint32(info.Mode().Perm())
+	if info.Mode()&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if info.Mode()&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if info.Mode()&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	if info.IsDir() {
+		if mode == 0 {
+			mode = 0755
+		}
+		return mode | rsyncModeDir
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	return mode | rsyncModeRegular
+}
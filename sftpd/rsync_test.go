@@ -0,0 +1,593 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sftpgo/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/common"
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+)
+
+// rsyncTestChannel is the server side of an in memory rsync session
+type rsyncTestChannel struct {
+	reader     *io.PipeReader
+	writer     *io.PipeWriter
+	stderr     bytes.Buffer
+	exitStatus chan uint32
+}
+
+func (c *rsyncTestChannel) Read(data []byte) (int, error) {
+	return c.reader.Read(data)
+}
+
+func (c *rsyncTestChannel) Write(data []byte) (int, error) {
+	return c.writer.Write(data)
+}
+
+func (c *rsyncTestChannel) Close() error {
+	c.reader.Close()
+	return c.writer.Close()
+}
+
+func (c *rsyncTestChannel) CloseWrite() error {
+	return c.writer.Close()
+}
+
+func (c *rsyncTestChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if name == "exit-status" {
+		var msg sshSubsystemExitStatus
+		if err := ssh.Unmarshal(payload, &msg); err != nil {
+			return false, err
+		}
+		c.exitStatus <- msg.Status
+	}
+	return true, nil
+}
+
+func (c *rsyncTestChannel) Stderr() io.ReadWriter {
+	return &c.stderr
+}
+
+// rsyncTestDemuxer reads the multiplexed server output, the data are buffered
+// so the server is never blocked while the client writes
+type rsyncTestDemuxer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	data     bytes.Buffer
+	messages []string
+	err      error
+}
+
+func newRsyncTestDemuxer(r io.Reader) *rsyncTestDemuxer {
+	d := &rsyncTestDemuxer{}
+	d.cond = sync.NewCond(&d.mu)
+	go func() {
+		var header [4]byte
+		for {
+			_, err := io.ReadFull(r, header[:])
+			var payload []byte
+			if err == nil {
+				value := binary.LittleEndian.Uint32(header[:])
+				payload = make([]byte, value&0xffffff)
+				_, err = io.ReadFull(r, payload)
+				if err == nil && int(value>>24)-rsyncMplexBase != rsyncMsgData {
+					d.mu.Lock()
+					d.messages = append(d.messages, string(payload))
+					d.mu.Unlock()
+					continue
+				}
+			}
+			d.mu.Lock()
+			d.data.Write(payload)
+			d.err = err
+			d.cond.Broadcast()
+			d.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return d
+}
+
+func (d *rsyncTestDemuxer) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for d.data.Len() == 0 && d.err == nil {
+		d.cond.Wait()
+	}
+	if d.data.Len() > 0 {
+		return d.data.Read(p)
+	}
+	return 0, d.err
+}
+
+func (d *rsyncTestDemuxer) getMessages() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.messages...)
+}
+
+// rsyncTestClient implements the client side of the protocol reusing the
+// server encoding functions
+type rsyncTestClient struct {
+	channel *rsyncTestChannel
+	raw     *bufio.Reader
+	writer  *io.PipeWriter
+	demuxer *rsyncTestDemuxer
+	conn    *rsyncConn
+	seed    int32
+	errCmd  chan error
+}
+
+func newRsyncTestClient(t *testing.T, user dataprovider.User, args []string) *rsyncTestClient {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	channel := &rsyncTestChannel{
+		reader:     serverReader,
+		writer:     serverWriter,
+		exitStatus: make(chan uint32, 1),
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid(), common.ProtocolSSH, "", "", user),
+		channel:        channel,
+	}
+	cmd := rsyncCommand{
+		sshCommand: sshCommand{
+			command:    rsyncCmdName,
+			connection: connection,
+			args:       args,
+		},
+	}
+	client := &rsyncTestClient{
+		channel: channel,
+		raw:     bufio.NewReader(clientReader),
+		writer:  clientWriter,
+		errCmd:  make(chan error, 1),
+	}
+	go func() {
+		client.errCmd <- cmd.handle()
+	}()
+	t.Cleanup(func() {
+		clientWriter.Close()
+		clientReader.Close()
+	})
+	return client
+}
+
+func xid() string {
+	return "rsync" + time.Now().Format("150405.000000000")
+}
+
+func (c *rsyncTestClient) handshake(t *testing.T, version int32) {
+	var buf [4]byte
+	_, err := io.ReadFull(c.raw, buf[:])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(rsyncProtocolVersion), binary.LittleEndian.Uint32(buf[:]))
+	binary.LittleEndian.PutUint32(buf[:], uint32(version))
+	_, err = c.writer.Write(buf[:])
+	require.NoError(t, err)
+	if version < rsyncProtocolVersion {
+		return
+	}
+	_, err = io.ReadFull(c.raw, buf[:])
+	require.NoError(t, err)
+	c.seed = int32(binary.LittleEndian.Uint32(buf[:]))
+	c.demuxer = newRsyncTestDemuxer(c.raw)
+	c.conn = newRsyncConn(c.demuxer, c.writer)
+}
+
+func (c *rsyncTestClient) sendFilters(t *testing.T, rules ...string) {
+	for _, rule := range rules {
+		require.NoError(t, c.conn.writeInt(int32(len(rule))))
+		require.NoError(t, c.conn.write([]byte(rule)))
+	}
+	require.NoError(t, c.conn.writeInt(0))
+	require.NoError(t, c.conn.flush())
+}
+
+func (c *rsyncTestClient) sendFileList(t *testing.T, opts *rsyncOptions, entries []*rsyncFileEntry) {
+	writer := &rsyncFileListWriter{
+		conn: c.conn,
+		opts: opts,
+	}
+	for _, entry := range entries {
+		require.NoError(t, writer.writeEntry(entry))
+	}
+	require.NoError(t, writer.finish(false))
+	require.NoError(t, c.conn.flush())
+}
+
+// sendFiles acts as an rsync sender, contents maps the file list indexes to the file data
+func (c *rsyncTestClient) sendFiles(t *testing.T, contents map[int32][]byte) []int32 {
+	var requested []int32
+	phase := 0
+	for {
+		ndx, err := c.conn.readInt()
+		require.NoError(t, err)
+		if ndx == rsyncNdxDone {
+			require.NoError(t, c.conn.writeInt(rsyncNdxDone))
+			require.NoError(t, c.conn.flush())
+			phase++
+			if phase > 1 {
+				break
+			}
+			continue
+		}
+		requested = append(requested, ndx)
+		head, err := c.conn.readSumHead()
+		require.NoError(t, err)
+		_, err = c.conn.readBlockSums(head, int64(len(contents[ndx])))
+		require.NoError(t, err)
+		require.NoError(t, c.conn.writeInt(ndx))
+		require.NoError(t, c.conn.writeSumHead(head))
+		sender := newRsyncTokenSender(c.conn, head, nil, c.seed, bytes.NewReader(contents[ndx]))
+		checksum, err := sender.send()
+		require.NoError(t, err)
+		require.NoError(t, c.conn.write(checksum))
+		require.NoError(t, c.conn.flush())
+	}
+	goodbye, err := c.conn.readInt()
+	require.NoError(t, err)
+	assert.Equal(t, int32(rsyncNdxDone), goodbye)
+	return requested
+}
+
+// receiveFile requests a file and rebuilds it using the optional basis data
+func (c *rsyncTestClient) receiveFile(t *testing.T, ndx int32, basis []byte, blockLength int) []byte {
+	head := rsyncSumHead{}
+	var sums []rsyncBlockSum
+	if len(basis) > 0 {
+		head.blockLength = int32(blockLength)
+		head.checksumLength = rsyncSumLength
+		head.remainder = int32(len(basis) % blockLength)
+		head.count = int32((len(basis) + blockLength - 1) / blockLength)
+		for idx := 0; idx < int(head.count); idx++ {
+			block := basis[idx*blockLength:]
+			if len(block) > blockLength {
+				block = block[:blockLength]
+			}
+			sums = append(sums, rsyncBlockSum{
+				rolling: combineRsyncRollingChecksum(getRsyncRollingChecksum(block)),
+				strong:  getRsyncBlockChecksum(block, c.seed),
+			})
+		}
+	}
+	require.NoError(t, c.conn.writeInt(ndx))
+	require.NoError(t, c.conn.writeSumHead(head))
+	for _, sum := range sums {
+		require.NoError(t, c.conn.writeInt(int32(sum.rolling)))
+		require.NoError(t, c.conn.write(sum.strong))
+	}
+	require.NoError(t, c.conn.flush())
+
+	n, err := c.conn.readInt()
+	require.NoError(t, err)
+	require.Equal(t, ndx, n)
+	receivedHead, err := c.conn.readSumHead()
+	require.NoError(t, err)
+	require.Equal(t, head, receivedHead)
+	var data []byte
+	for {
+		token, err := c.conn.readInt()
+		require.NoError(t, err)
+		if token == 0 {
+			break
+		}
+		if token > 0 {
+			literal := make([]byte, token)
+			require.NoError(t, c.conn.readFull(literal))
+			data = append(data, literal...)
+			continue
+		}
+		idx := int(-(token + 1))
+		require.Less(t, idx, int(head.count))
+		data = append(data, basis[idx*blockLength:idx*blockLength+head.getBlockLength(idx)]...)
+	}
+	checksum := make([]byte, rsyncSumLength)
+	require.NoError(t, c.conn.readFull(checksum))
+	hasher := newRsyncFileHasher(c.seed)
+	hasher.Write(data) //nolint:errcheck
+	assert.Equal(t, hasher.Sum(nil), checksum)
+	return data
+}
+
+func (c *rsyncTestClient) finishDownload(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		require.NoError(t, c.conn.writeInt(rsyncNdxDone))
+		require.NoError(t, c.conn.flush())
+		ndx, err := c.conn.readInt()
+		require.NoError(t, err)
+		require.Equal(t, int32(rsyncNdxDone), ndx)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.conn.readLongint()
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.conn.writeInt(rsyncNdxDone))
+	require.NoError(t, c.conn.flush())
+}
+
+func (c *rsyncTestClient) wait(t *testing.T) (uint32, error) {
+	select {
+	case status := <-c.channel.exitStatus:
+		return status, <-c.errCmd
+	case <-time.After(10 * time.Second):
+		t.Fatal("rsync command timed out")
+	}
+	return 0, nil
+}
+
+func getRsyncTestUser(t *testing.T) dataprovider.User {
+	return dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username:    "rsync_test_user",
+			HomeDir:     t.TempDir(),
+			Permissions: map[string][]string{"/": {dataprovider.PermAny}},
+		},
+	}
+}
+
+func TestRsyncUpload(t *testing.T) {
+	user := getRsyncTestUser(t)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	fileContent := make([]byte, 100000)
+	_, err := rand.Read(fileContent)
+	require.NoError(t, err)
+	smallContent := []byte("small file content")
+
+	err = os.MkdirAll(filepath.Join(user.HomeDir, "dest", "src", "sub"), os.ModePerm)
+	require.NoError(t, err)
+	unchangedPath := filepath.Join(user.HomeDir, "dest", "src", "unchanged")
+	err = os.WriteFile(unchangedPath, smallContent, 0644)
+	require.NoError(t, err)
+	err = os.Chtimes(unchangedPath, modTime, modTime)
+	require.NoError(t, err)
+	extraPath := filepath.Join(user.HomeDir, "dest", "src", "sub", "extra")
+	err = os.WriteFile(extraPath, smallContent, 0644)
+	require.NoError(t, err)
+	keptPath := filepath.Join(user.HomeDir, "dest", "src", "kept.tmp")
+	err = os.WriteFile(keptPath, smallContent, 0644)
+	require.NoError(t, err)
+
+	opts := &rsyncOptions{
+		recursive:       true,
+		preserveLinks:   true,
+		preservePerms:   true,
+		preserveTimes:   true,
+		preserveUID:     true,
+		preserveGID:     true,
+		preserveDevices: true,
+		deleteMode:      true,
+	}
+	entries := []*rsyncFileEntry{
+		{name: "src", mode: rsyncModeDir | 0755, modTime: modTime.Unix()},
+		{name: "src/file", mode: rsyncModeRegular | 0600, modTime: modTime.Unix(), size: int64(len(fileContent))},
+		{name: "src/link", mode: rsyncModeSymlink | 0777, modTime: modTime.Unix(), linkTarget: "file"},
+		{name: "src/sub", mode: rsyncModeDir | 0755, modTime: modTime.Unix()},
+		{name: "src/unchanged", mode: rsyncModeRegular | 0644, modTime: modTime.Unix(), size: int64(len(smallContent))},
+		{name: "src/unsafe", mode: rsyncModeSymlink | 0777, modTime: modTime.Unix(), linkTarget: "../../../etc"},
+	}
+	client := newRsyncTestClient(t, user, []string{"--server", "-vlogDtpre.iLsfxC", "--delete", ".", "dest"})
+	client.handshake(t, 30)
+	client.sendFilters(t, "- *.tmp")
+	client.sendFileList(t, opts, entries)
+	requested := client.sendFiles(t, map[int32][]byte{1: fileContent})
+	assert.Equal(t, []int32{1}, requested)
+	status, err := client.wait(t)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), status)
+
+	filePath := filepath.Join(user.HomeDir, "dest", "src", "file")
+	data, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, fileContent, data)
+	info, err := os.Stat(filePath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, modTime.Unix(), info.ModTime().Unix())
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	target, err := os.Readlink(filepath.Join(user.HomeDir, "dest", "src", "link"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, filePath, target)
+	}
+	_, err = os.Lstat(filepath.Join(user.HomeDir, "dest", "src", "unsafe"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoFileExists(t, extraPath)
+	assert.FileExists(t, keptPath)
+	assert.Contains(t, client.demuxer.getMessages(), "ignoring unsafe symlink \"src/unsafe\" -> \"../../../etc\"\n")
+}
+
+func TestRsyncUploadErrors(t *testing.T) {
+	user := getRsyncTestUser(t)
+	user.Permissions["/ro"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	err := os.MkdirAll(filepath.Join(user.HomeDir, "ro"), os.ModePerm)
+	require.NoError(t, err)
+
+	opts := &rsyncOptions{
+		recursive: true,
+	}
+	entries := []*rsyncFileEntry{
+		{name: "file", mode: rsyncModeRegular | 0644, size: 10},
+	}
+	client := newRsyncTestClient(t, user, []string{"--server", "-re.iLsfxC", ".", "ro/"})
+	client.handshake(t, rsyncProtocolVersion)
+	client.sendFileList(t, opts, entries)
+	requested := client.sendFiles(t, nil)
+	assert.Len(t, requested, 0)
+	status, err := client.wait(t)
+	assert.Error(t, err)
+	assert.Equal(t, uint32(rsyncExitPartial), status)
+	assert.NoFileExists(t, filepath.Join(user.HomeDir, "ro", "file"))
+
+	entries = []*rsyncFileEntry{
+		{name: "../file", mode: rsyncModeRegular | 0644, size: 10},
+	}
+	client = newRsyncTestClient(t, user, []string{"--server", "-re.iLsfxC", ".", "dir"})
+	client.handshake(t, rsyncProtocolVersion)
+	client.sendFileList(t, opts, entries)
+	status, err = client.wait(t)
+	assert.ErrorIs(t, err, errRsyncProtocol)
+	assert.Equal(t, uint32(rsyncExitProtocol), status)
+}
+
+func TestRsyncDownload(t *testing.T) {
+	user := getRsyncTestUser(t)
+	fileContent := make([]byte, 150000)
+	_, err := rand.Read(fileContent)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(user.HomeDir, "src", "sub"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.HomeDir, "src", "file"), fileContent, 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.HomeDir, "src", "sub", "file1"), []byte("content"), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.HomeDir, "src", "sub", "file.tmp"), []byte("content"), 0644)
+	require.NoError(t, err)
+
+	opts := &rsyncOptions{
+		recursive:      true,
+		preserveTimes:  true,
+		preservePerms:  true,
+		alwaysChecksum: true,
+	}
+	client := newRsyncTestClient(t, user, []string{"--server", "--sender", "-rtpce.iLsfxC", ".", "src/"})
+	client.handshake(t, rsyncProtocolVersion)
+	client.sendFilters(t, "- *.tmp")
+	entries, ioError, err := client.conn.readFileList(opts)
+	require.NoError(t, err)
+	assert.False(t, ioError)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	require.Equal(t, []string{".", "file", "sub", "sub/file1"}, names)
+	assert.Equal(t, int64(len(fileContent)), entries[1].size)
+	assert.Len(t, entries[1].checksum, rsyncSumLength)
+	// whole file
+	data := client.receiveFile(t, 1, nil, 0)
+	assert.Equal(t, fileContent, data)
+	// delta transfer using a modified basis file
+	basis := append([]byte(nil), fileContent...)
+	copy(basis[70000:], []byte("modified data"))
+	basis = basis[:120000]
+	data = client.receiveFile(t, 1, basis, 700)
+	assert.Equal(t, fileContent, data)
+	data = client.receiveFile(t, 3, nil, 0)
+	assert.Equal(t, []byte("content"), data)
+	client.finishDownload(t)
+	status, err := client.wait(t)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), status)
+}
+
+func TestRsyncProtocolErrors(t *testing.T) {
+	user := getRsyncTestUser(t)
+	client := newRsyncTestClient(t, user, []string{"--server", "-vlogDtpre.iLsfxC", ".", "dest"})
+	client.handshake(t, 26)
+	status, err := client.wait(t)
+	assert.Error(t, err)
+	assert.Equal(t, uint32(rsyncExitProtocol), status)
+	assert.Contains(t, client.channel.stderr.String(), "protocol version 26 is not supported")
+
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(xid(), common.ProtocolSSH, "", "", user),
+		channel: &MockChannel{
+			Buffer:       bytes.NewBuffer(nil),
+			StdErrBuffer: bytes.NewBuffer(nil),
+		},
+	}
+	cmd := rsyncCommand{
+		sshCommand: sshCommand{
+			command:    rsyncCmdName,
+			connection: connection,
+			args:       []string{"--server", "-vlogDtprze.iLsfxC", ".", "dest"},
+		},
+	}
+	err = cmd.handle()
+	var exitErr *rsyncExitError
+	if assert.True(t, errors.As(err, &exitErr)) {
+		assert.Equal(t, uint32(rsyncExitUnsupported), exitErr.code)
+	}
+	assert.Contains(t, connection.channel.(*MockChannel).StdErrBuffer.String(), "option -z is not supported")
+}
+
+func TestRsyncFilterRules(t *testing.T) {
+	c := rsyncCommand{}
+	for _, rule := range []string{"+ keep.tmp", "- *.tmp", "- /top", "cache/", "- a/b"} {
+		c.filters = append(c.filters, parseRsyncFilterRule(rule))
+	}
+	assert.False(t, c.isExcluded("dir/keep.tmp", false))
+	assert.True(t, c.isExcluded("dir/file.tmp", false))
+	assert.True(t, c.isExcluded("top", true))
+	assert.False(t, c.isExcluded("dir/top", true))
+	assert.True(t, c.isExcluded("dir/cache", true))
+	assert.False(t, c.isExcluded("dir/cache", false))
+	assert.True(t, c.isExcluded("x/a/b", false))
+	assert.False(t, c.isExcluded("x/a/c", false))
+
+	assert.True(t, isRsyncNameValid("."))
+	assert.True(t, isRsyncNameValid("a/b"))
+	assert.False(t, isRsyncNameValid(""))
+	assert.False(t, isRsyncNameValid("/a"))
+	assert.False(t, isRsyncNameValid("a/../b"))
+	assert.False(t, isRsyncNameValid("a//b"))
+}
+
+func TestRsyncChecksums(t *testing.T) {
+	s1, s2 := getRsyncRollingChecksum([]byte{1, 2, 0xff})
+	assert.Equal(t, uint32(2), s1)
+	assert.Equal(t, uint32(6), s2)
+	assert.Equal(t, uint32(6<<16|2), combineRsyncRollingChecksum(s1, s2))
+	assert.NotEqual(t, getRsyncBlockChecksum([]byte("data"), 0), getRsyncBlockChecksum([]byte("data"), 1))
+	assert.Len(t, getRsyncBlockChecksum([]byte("data"), 0), rsyncSumLength)
+}
+
+func TestRsyncBlockSumsLimits(t *testing.T) {
+	var b bytes.Buffer
+	w := newRsyncConn(nil, &b)
+	head := rsyncSumHead{
+		count:          rsyncMaxBlockSums + 1,
+		blockLength:    700,
+		checksumLength: 2,
+	}
+	require.NoError(t, w.writeSumHead(head))
+	require.NoError(t, w.flush())
+	_, err := newRsyncConn(&b, nil).readSumHead()
+	assert.ErrorIs(t, err, errRsyncProtocol)
+	// the client basis file is larger than the file to send
+	b.Reset()
+	head.count = 5
+	require.NoError(t, w.writeSumHead(head))
+	for idx := 0; idx < int(head.count); idx++ {
+		require.NoError(t, w.writeInt(int32(idx)))
+		require.NoError(t, w.write([]byte{byte(idx), 0}))
+	}
+	require.NoError(t, w.flush())
+	r := newRsyncConn(&b, nil)
+	receivedHead, err := r.readSumHead()
+	require.NoError(t, err)
+	sums, err := r.readBlockSums(receivedHead, 1000)
+	require.NoError(t, err)
+	if assert.Len(t, sums, 3) {
+		assert.Equal(t, uint32(2), sums[2].rolling)
+		assert.Equal(t, []byte{2, 0}, sums[2].strong)
+	}
+	assert.Equal(t, 0, b.Len())
+}
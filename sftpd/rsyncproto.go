@@ -0,0 +1,860 @@
+package sftpd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"sync"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck
+)
+
+// rsync protocol version 27 is the last one without incremental recursion,
+// checksum negotiation and varint encoding, all the rsync 3.x clients are able
+// to use it
+const (
+	rsyncProtocolVersion = 27
+	rsyncChunkSize       = 32 * 1024
+	rsyncSumLength       = 16
+	rsyncMaxBlockSize    = 1 << 29
+	rsyncMaxBlockSums    = 1 << 22
+	rsyncMplexBase       = 7
+	rsyncMaxFrameSize    = 0xFFFFFF
+	rsyncNdxDone         = -1
+)
+
+// multiplexed message codes
+const (
+	rsyncMsgData    = 0
+	rsyncMsgInfo    = 2
+	rsyncMsgError   = 3
+	rsyncMsgWarning = 4
+)
+
+// file list flags for protocol < 28
+const (
+	rsyncXmitTopDir        = 1 << 0
+	rsyncXmitSameMode      = 1 << 1
+	rsyncXmitSameRdevPre28 = 1 << 2
+	rsyncXmitSameUID       = 1 << 3
+	rsyncXmitSameGID       = 1 << 4
+	rsyncXmitSameName      = 1 << 5
+	rsyncXmitLongName      = 1 << 6
+	rsyncXmitSameTime      = 1 << 7
+)
+
+// unix file type bits as sent on the wire
+const (
+	rsyncModeTypeMask = 0170000
+	rsyncModeDir      = 0040000
+	rsyncModeRegular  = 0100000
+	rsyncModeSymlink  = 0120000
+	rsyncModeCharDev  = 0020000
+	rsyncModeBlockDev = 0060000
+	rsyncModeFIFO     = 0010000
+	rsyncModeSocket   = 0140000
+)
+
+var errRsyncProtocol = errors.New("rsync protocol error")
+
+type rsyncFileEntry struct {
+	name       string
+	size       int64
+	modTime    int64
+	mode       uint32
+	linkTarget string
+	checksum   []byte
+	flags      int
+	// virtual path, set on the sender side
+	virtualPath string
+}
+
+func (e *rsyncFileEntry) isDir() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeDir
+}
+
+func (e *rsyncFileEntry) isRegular() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeRegular
+}
+
+func (e *rsyncFileEntry) isSymlink() bool {
+	return e.mode&rsyncModeTypeMask == rsyncModeSymlink
+}
+
+func (e *rsyncFileEntry) hasRdev() bool {
+	switch e.mode & rsyncModeTypeMask {
+	case rsyncModeCharDev, rsyncModeBlockDev, rsyncModeFIFO, rsyncModeSocket:
+		return true
+	default:
+		return false
+	}
+}
+
+type rsyncSumHead struct {
+	count          int32
+	blockLength    int32
+	checksumLength int32
+	remainder      int32
+}
+
+func (h *rsyncSumHead) getBlockLength(idx int) int {
+	if idx == int(h.count)-1 && h.remainder != 0 {
+		return int(h.remainder)
+	}
+	return int(h.blockLength)
+}
+
+type rsyncBlockSum struct {
+	rolling uint32
+	strong  []byte
+}
+
+// rsyncConn implements the rsync wire encoding. The output is multiplexed once
+// the protocol setup is done while the input from the client is never multiplexed
+// for the protocol versions we support
+type rsyncConn struct {
+	reader *bufio.Reader
+	writer io.Writer
+	// the mutex protects the write buffer, the messages can be sent concurrently
+	mu           sync.Mutex
+	buf          []byte
+	multiplex    bool
+	bytesRead    int64
+	bytesWritten int64
+}
+
+func newRsyncConn(r io.Reader, w io.Writer) *rsyncConn {
+	return &rsyncConn{
+		reader: bufio.NewReaderSize(r, rsyncChunkSize),
+		writer: w,
+	}
+}
+
+func (c *rsyncConn) startMultiplex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.flushLocked(); err != nil {
+		return err
+	}
+	c.multiplex = true
+	return nil
+}
+
+func (c *rsyncConn) readFull(buf []byte) error {
+	n, err := io.ReadFull(c.reader, buf)
+	c.bytesRead += int64(n)
+	return err
+}
+
+func (c *rsyncConn) readByte() (byte, error) {
+	b, err := c.reader.ReadByte()
+	if err == nil {
+		c.bytesRead++
+	}
+	return b, err
+}
+
+func (c *rsyncConn) readInt() (int32, error) {
+	var buf [4]byte
+	if err := c.readFull(buf[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(buf[:])), nil
+}
+
+func (c *rsyncConn) readLongint() (int64, error) {
+	v, err := c.readInt()
+	if err != nil || v != -1 {
+		return int64(v), err
+	}
+	var buf [8]byte
+	if err := c.readFull(buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func (c *rsyncConn) readString(length int) (string, error) {
+	buf := make([]byte, length)
+	if err := c.readFull(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readProtectedArgs reads the null terminated arguments sent by clients using
+// the --protect-args option, the list ends with an empty string
+func (c *rsyncConn) readProtectedArgs() ([]string, error) {
+	var args []string
+	for {
+		arg, err := c.reader.ReadString(0)
+		if err != nil {
+			return nil, err
+		}
+		c.bytesRead += int64(len(arg))
+		arg = arg[:len(arg)-1]
+		if arg == "" {
+			return args, nil
+		}
+		args = append(args, arg)
+	}
+}
+
+func (c *rsyncConn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf = append(c.buf, data...)
+	if len(c.buf) >= rsyncChunkSize {
+		return c.flushLocked()
+	}
+	return nil
+}
+
+func (c *rsyncConn) writeByte(b byte) error {
+	return c.write([]byte{b})
+}
+
+func (c *rsyncConn) writeInt(v int32) error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(v))
+	return c.write(buf[:])
+}
+
+func (c *rsyncConn) writeLongint(v int64) error {
+	if v >= 0 && v <= math.MaxInt32 {
+		return c.writeInt(int32(v))
+	}
+	var buf [12]byte
+	binary.LittleEndian.PutUint32(buf[:4], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(v))
+	return c.write(buf[:])
+}
+
+func (c *rsyncConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flushLocked()
+}
+
+func (c *rsyncConn) flushLocked() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.multiplex {
+		err = c.writeFrame(rsyncMsgData, c.buf)
+	} else {
+		err = c.writeRaw(c.buf)
+	}
+	c.buf = c.buf[:0]
+	return err
+}
+
+func (c *rsyncConn) writeRaw(data []byte) error {
+	n, err := c.writer.Write(data)
+	c.bytesWritten += int64(n)
+	return err
+}
+
+func (c *rsyncConn) writeFrame(code int, data []byte) error {
+	for len(data) > 0 {
+		size := len(data)
+		if size > rsyncMaxFrameSize {
+			size = rsyncMaxFrameSize
+		}
+		frame := make([]byte, 4, 4+size)
+		binary.LittleEndian.PutUint32(frame, uint32((rsyncMplexBase+code)<<24|size))
+		frame = append(frame, data[:size]...)
+		if err := c.writeRaw(frame); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// writeMessage sends an out of band message, the client will display it.
+// It requires a multiplexed connection
+func (c *rsyncConn) writeMessage(code int, msg string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.flushLocked(); err != nil {
+		return err
+	}
+	return c.writeFrame(code, []byte(msg))
+}
+
+func (c *rsyncConn) getStats() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytesRead, c.bytesWritten
+}
+
+func (c *rsyncConn) readSumHead() (rsyncSumHead, error) {
+	var head rsyncSumHead
+	var values [4]int32
+	for idx := range values {
+		v, err := c.readInt()
+		if err != nil {
+			return head, err
+		}
+		values[idx] = v
+	}
+	head.count = values[0]
+	head.blockLength = values[1]
+	head.checksumLength = values[2]
+	head.remainder = values[3]
+	if head.count < 0 || head.count > rsyncMaxBlockSums || head.blockLength < 0 || head.blockLength > rsyncMaxBlockSize ||
+		head.checksumLength < 0 || head.checksumLength > rsyncSumLength ||
+		head.remainder < 0 || head.remainder > head.blockLength {
+		return head, fmt.Errorf("%w: invalid checksum header %+v", errRsyncProtocol, head)
+	}
+	if head.count > 0 && head.blockLength == 0 {
+		return head, fmt.Errorf("%w: invalid block length", errRsyncProtocol)
+	}
+	return head, nil
+}
+
+func (c *rsyncConn) writeSumHead(head rsyncSumHead) error {
+	for _, v := range []int32{head.count, head.blockLength, head.checksumLength, head.remainder} {
+		if err := c.writeInt(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBlockSums reads the block checksums sent by the client for its basis file.
+// Only the checksums that can match a block of the file to send, whose size is
+// fileSize, are kept, the remaining ones are read and discarded
+func (c *rsyncConn) readBlockSums(head rsyncSumHead, fileSize int64) ([]rsyncBlockSum, error) {
+	var maxSums int64
+	if head.blockLength > 0 {
+		maxSums = (fileSize+int64(head.blockLength)-1)/int64(head.blockLength) + 1
+	}
+	var sums []rsyncBlockSum
+	for i := int32(0); i < head.count; i++ {
+		rolling, err := c.readInt()
+		if err != nil {
+			return nil, err
+		}
+		strong := make([]byte, head.checksumLength)
+		if err := c.readFull(strong); err != nil {
+			return nil, err
+		}
+		if int64(len(sums)) >= maxSums {
+			continue
+		}
+		sums = append(sums, rsyncBlockSum{
+			rolling: uint32(rolling),
+			strong:  strong,
+		})
+	}
+	return sums, nil
+}
+
+// rsyncFileListWriter sends file list entries, each entry is compressed using
+// the values of the previous one
+type rsyncFileListWriter struct {
+	conn     *rsyncConn
+	opts     *rsyncOptions
+	lastName string
+	lastMode uint32
+	lastTime int64
+}
+
+func (w *rsyncFileListWriter) writeEntry(entry *rsyncFileEntry) error {
+	flags := entry.flags & rsyncXmitTopDir
+	if entry.mode == w.lastMode {
+		flags |= rsyncXmitSameMode
+	}
+	if entry.modTime == w.lastTime {
+		flags |= rsyncXmitSameTime
+	}
+	// we always send zero as uid and gid
+	flags |= rsyncXmitSameUID | rsyncXmitSameGID
+	l1 := 0
+	for l1 < len(entry.name) && l1 < len(w.lastName) && l1 < 255 && entry.name[l1] == w.lastName[l1] {
+		l1++
+	}
+	suffix := entry.name[l1:]
+	if l1 > 0 {
+		flags |= rsyncXmitSameName
+	}
+	if len(suffix) > 255 {
+		flags |= rsyncXmitLongName
+	}
+	// the flags are never zero since the uid and gid flags are always set,
+	// a zero byte would terminate the file list
+	if err := w.conn.writeByte(byte(flags)); err != nil {
+		return err
+	}
+	if flags&rsyncXmitSameName != 0 {
+		if err := w.conn.writeByte(byte(l1)); err != nil {
+			return err
+		}
+	}
+	if flags&rsyncXmitLongName != 0 {
+		if err := w.conn.writeInt(int32(len(suffix))); err != nil {
+			return err
+		}
+	} else {
+		if err := w.conn.writeByte(byte(len(suffix))); err != nil {
+			return err
+		}
+	}
+	if err := w.conn.write([]byte(suffix)); err != nil {
+		return err
+	}
+	if err := w.conn.writeLongint(entry.size); err != nil {
+		return err
+	}
+	if flags&rsyncXmitSameTime == 0 {
+		if err := w.conn.writeInt(int32(entry.modTime)); err != nil {
+			return err
+		}
+	}
+	if flags&rsyncXmitSameMode == 0 {
+		if err := w.conn.writeInt(int32(entry.mode)); err != nil {
+			return err
+		}
+	}
+	if w.opts.preserveLinks && entry.isSymlink() {
+		if err := w.conn.writeInt(int32(len(entry.linkTarget))); err != nil {
+			return err
+		}
+		if err := w.conn.write([]byte(entry.linkTarget)); err != nil {
+			return err
+		}
+	}
+	if w.opts.alwaysChecksum {
+		checksum := entry.checksum
+		if len(checksum) != rsyncSumLength {
+			checksum = make([]byte, rsyncSumLength)
+		}
+		if err := w.conn.write(checksum); err != nil {
+			return err
+		}
+	}
+	w.lastName = entry.name
+	w.lastMode = entry.mode
+	w.lastTime = entry.modTime
+	return nil
+}
+
+// finish terminates the file list and sends the empty user and group lists
+// and the I/O error flag
+func (w *rsyncFileListWriter) finish(ioError bool) error {
+	if err := w.conn.writeByte(0); err != nil {
+		return err
+	}
+	if w.opts.preserveUID && !w.opts.numericIDs {
+		if err := w.conn.writeInt(0); err != nil {
+			return err
+		}
+	}
+	if w.opts.preserveGID && !w.opts.numericIDs {
+		if err := w.conn.writeInt(0); err != nil {
+			return err
+		}
+	}
+	var ioErrorFlag int32
+	if ioError {
+		ioErrorFlag = 1
+	}
+	return w.conn.writeInt(ioErrorFlag)
+}
+
+// readFileList reads the file list sent by the client and returns the entries
+// and the I/O error flag
+func (c *rsyncConn) readFileList(opts *rsyncOptions) ([]*rsyncFileEntry, bool, error) {
+	var entries []*rsyncFileEntry
+	var last rsyncFileEntry
+
+	for {
+		flags, err := c.readByte()
+		if err != nil {
+			return nil, false, err
+		}
+		if flags == 0 {
+			break
+		}
+		entry, err := c.readFileEntry(int(flags), &last, opts)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, entry)
+		last = *entry
+	}
+	if opts.preserveUID && !opts.numericIDs {
+		if err := c.readIDList(); err != nil {
+			return nil, false, err
+		}
+	}
+	if opts.preserveGID && !opts.numericIDs {
+		if err := c.readIDList(); err != nil {
+			return nil, false, err
+		}
+	}
+	ioError, err := c.readInt()
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, ioError != 0, nil
+}
+
+func (c *rsyncConn) readFileEntry(flags int, last *rsyncFileEntry, opts *rsyncOptions) (*rsyncFileEntry, error) {
+	entry := &rsyncFileEntry{
+		flags:   flags,
+		modTime: last.modTime,
+		mode:    last.mode,
+	}
+	l1 := 0
+	if flags&rsyncXmitSameName != 0 {
+		b, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		l1 = int(b)
+	}
+	var l2 int
+	if flags&rsyncXmitLongName != 0 {
+		v, err := c.readInt()
+		if err != nil {
+			return nil, err
+		}
+		l2 = int(v)
+	} else {
+		b, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		l2 = int(b)
+	}
+	if l1 > len(last.name) || l2 < 0 || l1+l2 > 4096 {
+		return nil, fmt.Errorf("%w: invalid file name length", errRsyncProtocol)
+	}
+	suffix, err := c.readString(l2)
+	if err != nil {
+		return nil, err
+	}
+	entry.name = last.name[:l1] + suffix
+	if entry.size, err = c.readLongint(); err != nil {
+		return nil, err
+	}
+	if entry.size < 0 {
+		return nil, fmt.Errorf("%w: invalid size for file %#v", errRsyncProtocol, entry.name)
+	}
+	if flags&rsyncXmitSameTime == 0 {
+		v, err := c.readInt()
+		if err != nil {
+			return nil, err
+		}
+		entry.modTime = int64(v)
+	}
+	if flags&rsyncXmitSameMode == 0 {
+		v, err := c.readInt()
+		if err != nil {
+			return nil, err
+		}
+		entry.mode = uint32(v)
+	}
+	if err := c.readEntryAttributes(entry, flags, opts); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// readEntryAttributes reads and discards the user, group and devices attributes
+// and reads the symlink target and the checksum, if any
+func (c *rsyncConn) readEntryAttributes(entry *rsyncFileEntry, flags int, opts *rsyncOptions) error {
+	if opts.preserveUID && flags&rsyncXmitSameUID == 0 {
+		if _, err := c.readInt(); err != nil {
+			return err
+		}
+	}
+	if opts.preserveGID && flags&rsyncXmitSameGID == 0 {
+		if _, err := c.readInt(); err != nil {
+			return err
+		}
+	}
+	if opts.preserveDevices && entry.hasRdev() && flags&rsyncXmitSameRdevPre28 == 0 {
+		if _, err := c.readInt(); err != nil {
+			return err
+		}
+	}
+	if opts.preserveLinks && entry.isSymlink() {
+		length, err := c.readInt()
+		if err != nil {
+			return err
+		}
+		if length < 0 || length > 4096 {
+			return fmt.Errorf("%w: invalid symlink length", errRsyncProtocol)
+		}
+		if entry.linkTarget, err = c.readString(int(length)); err != nil {
+			return err
+		}
+	}
+	if opts.alwaysChecksum {
+		entry.checksum = make([]byte, rsyncSumLength)
+		if err := c.readFull(entry.checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *rsyncConn) readIDList() error {
+	for {
+		id, err := c.readInt()
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return nil
+		}
+		length, err := c.readByte()
+		if err != nil {
+			return err
+		}
+		if _, err := c.readString(int(length)); err != nil {
+			return err
+		}
+	}
+}
+
+// getRsyncRollingChecksum returns the rsync weak checksum, the bytes are signed
+// as in the C implementation
+func getRsyncRollingChecksum(data []byte) (uint32, uint32) {
+	var s1, s2 uint32
+	for _, b := range data {
+		s1 += uint32(int32(int8(b)))
+		s2 += s1
+	}
+	return s1, s2
+}
+
+func combineRsyncRollingChecksum(s1, s2 uint32) uint32 {
+	return (s1 & 0xffff) | (s2 << 16)
+}
+
+// getRsyncBlockChecksum returns the strong checksum for a block, the seed is
+// appended to the data if not zero
+func getRsyncBlockChecksum(data []byte, seed int32) []byte {
+	h := md4.New()
+	h.Write(data) //nolint:errcheck
+	if seed != 0 {
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(seed))
+		h.Write(buf[:]) //nolint:errcheck
+	}
+	return h.Sum(nil)
+}
+
+// newRsyncFileHasher returns the hasher for the whole file checksum sent after
+// the file data, the seed is prepended to the data
+func newRsyncFileHasher(seed int32) hash.Hash {
+	h := md4.New()
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(seed))
+	h.Write(buf[:]) //nolint:errcheck
+	return h
+}
+
+// rsyncTokenSender sends the file data matching the block checksums received
+// from the client. Unmatched data are sent as literal data
+type rsyncTokenSender struct {
+	conn   *rsyncConn
+	head   rsyncSumHead
+	sums   []rsyncBlockSum
+	seed   int32
+	hasher hash.Hash
+	table  map[uint32][]int
+	reader io.Reader
+	buf    []byte
+	eof    bool
+}
+
+func newRsyncTokenSender(conn *rsyncConn, head rsyncSumHead, sums []rsyncBlockSum, seed int32, reader io.Reader) *rsyncTokenSender {
+	s := &rsyncTokenSender{
+		conn:   conn,
+		head:   head,
+		sums:   sums,
+		seed:   seed,
+		hasher: newRsyncFileHasher(seed),
+		table:  make(map[uint32][]int),
+		reader: reader,
+	}
+	for idx, sum := range sums {
+		s.table[sum.rolling] = append(s.table[sum.rolling], idx)
+	}
+	return s
+}
+
+// send transfers the file and returns the file checksum
+func (s *rsyncTokenSender) send() ([]byte, error) {
+	var err error
+	if len(s.sums) == 0 {
+		err = s.sendWholeFile()
+	} else {
+		err = s.sendDelta()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.conn.writeInt(0); err != nil {
+		return nil, err
+	}
+	return s.hasher.Sum(nil), nil
+}
+
+func (s *rsyncTokenSender) sendWholeFile() error {
+	buf := make([]byte, rsyncChunkSize)
+	for {
+		n, err := io.ReadFull(s.reader, buf)
+		if n > 0 {
+			if errLiteral := s.sendLiteral(buf[:n]); errLiteral != nil {
+				return errLiteral
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *rsyncTokenSender) sendLiteral(data []byte) error {
+	s.hasher.Write(data) //nolint:errcheck
+	for len(data) > 0 {
+		size := len(data)
+		if size > rsyncChunkSize {
+			size = rsyncChunkSize
+		}
+		if err := s.conn.writeInt(int32(size)); err != nil {
+			return err
+		}
+		if err := s.conn.write(data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// fill makes sure that at least the requested bytes are available after the
+// offset, if possible
+func (s *rsyncTokenSender) fill(offset, size int) error {
+	for len(s.buf)-offset < size && !s.eof {
+		readSize := size + rsyncChunkSize
+		if cap(s.buf)-len(s.buf) < readSize {
+			newBuf := make([]byte, len(s.buf), len(s.buf)+readSize)
+			copy(newBuf, s.buf)
+			s.buf = newBuf
+		}
+		n, err := s.reader.Read(s.buf[len(s.buf) : len(s.buf)+readSize])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard removes the bytes before the given offset from the buffer
+func (s *rsyncTokenSender) discard(offset int) {
+	n := copy(s.buf, s.buf[offset:])
+	s.buf = s.buf[:n]
+}
+
+func (s *rsyncTokenSender) findMatch(data []byte, rolling uint32) int {
+	indexes, ok := s.table[rolling]
+	if !ok {
+		return -1
+	}
+	var strong []byte
+	for _, idx := range indexes {
+		if s.head.getBlockLength(idx) != len(data) {
+			continue
+		}
+		if strong == nil {
+			strong = getRsyncBlockChecksum(data, s.seed)
+		}
+		if bytes.Equal(strong[:len(s.sums[idx].strong)], s.sums[idx].strong) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (s *rsyncTokenSender) sendDelta() error {
+	blockLength := int(s.head.blockLength)
+	// start is the beginning of the pending literal data, offset the beginning of the window
+	start, offset := 0, 0
+	if err := s.fill(0, blockLength+1); err != nil {
+		return err
+	}
+	size := len(s.buf)
+	if size > blockLength {
+		size = blockLength
+	}
+	s1, s2 := getRsyncRollingChecksum(s.buf[:size])
+	for size > 0 {
+		if idx := s.findMatch(s.buf[offset:offset+size], combineRsyncRollingChecksum(s1, s2)); idx >= 0 {
+			if err := s.sendLiteral(s.buf[start:offset]); err != nil {
+				return err
+			}
+			s.hasher.Write(s.buf[offset : offset+size]) //nolint:errcheck
+			if err := s.conn.writeInt(-int32(idx + 1)); err != nil {
+				return err
+			}
+			s.discard(offset + size)
+			start, offset = 0, 0
+			if err := s.fill(0, blockLength+1); err != nil {
+				return err
+			}
+			size = len(s.buf)
+			if size > blockLength {
+				size = blockLength
+			}
+			s1, s2 = getRsyncRollingChecksum(s.buf[:size])
+			continue
+		}
+		if offset-start >= rsyncChunkSize {
+			if err := s.sendLiteral(s.buf[start:offset]); err != nil {
+				return err
+			}
+			s.discard(offset)
+			start, offset = 0, 0
+		}
+		if err := s.fill(offset, size+1); err != nil {
+			return err
+		}
+		first := uint32(int32(int8(s.buf[offset])))
+		s1 -= first
+		s2 -= uint32(size) * first
+		if offset+size < len(s.buf) {
+			next := uint32(int32(int8(s.buf[offset+size])))
+			s1 += next
+			s2 += s1
+		} else {
+			size--
+		}
+		offset++
+	}
+	return s.sendLiteral(s.buf[start:])
+}
@@ -2,8 +2,10 @@ package sftpd
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,6 +14,7 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -22,7 +25,6 @@ import (
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/util"
-	"github.com/drakkan/sftpgo/v2/vfs"
 )
 
 const (
@@ -30,10 +32,15 @@ const (
 	defaultPrivateECDSAKeyName   = "id_ecdsa"
 	defaultPrivateEd25519KeyName = "id_ed25519"
 	sourceAddressCriticalOption  = "source-address"
+	forceCommandCriticalOption   = "force-command"
+	internalSFTPCommand          = "internal-sftp"
 )
 
 var (
-	sftpExtensions    = []string{"statvfs@openssh.com"}
+	sftpExtensions = []string{
+		"statvfs@openssh.com", "posix-rename@openssh.com", "hardlink@openssh.com",
+		"fsync@openssh.com", "copy-data", "check-file",
+	}
 	supportedKexAlgos = []string{
 		"curve25519-sha256@libssh.org",
 		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
@@ -52,6 +59,8 @@ var (
 		"hmac-sha2-512-etm@openssh.com", "hmac-sha2-512",
 		"hmac-sha1", "hmac-sha1-96",
 	}
+	// certificate permissions for the first step of multi-step authentications, keyed by remote address
+	partialAuthCertPerms sync.Map
 )
 
 // Binding defines the configuration for a network listener
@@ -62,6 +71,28 @@ type Binding struct {
 	Port int `json:"port" mapstructure:"port"`
 	// Apply the proxy configuration, if any, for this binding
 	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+	// HostKeys define the private host keys for this binding. If empty the global host keys are used.
+	// Each host key can be defined as a path relative to the configuration directory or an absolute one
+	HostKeys []string `json:"host_keys" mapstructure:"host_keys"`
+	// HostCertificates define the OpenSSH host certificates for this binding. Each certificate must
+	// match one of the host keys. If empty the global host certificates are used, unless the binding
+	// defines its own host keys
+	HostCertificates []string `json:"host_certificates" mapstructure:"host_certificates"`
+}
+
+func (b *Binding) hasCustomHostKeys() bool {
+	return len(b.HostKeys) > 0 || len(b.HostCertificates) > 0
+}
+
+// getHostKeysAndCertificates returns the host keys and certificates to use for this binding
+func (b *Binding) getHostKeysAndCertificates(hostKeys, hostCertificates []string) ([]string, []string) {
+	if len(b.HostKeys) > 0 {
+		return b.HostKeys, b.HostCertificates
+	}
+	if len(b.HostCertificates) > 0 {
+		return hostKeys, b.HostCertificates
+	}
+	return hostKeys, hostCertificates
 }
 
 // GetAddress returns the binding address
@@ -76,7 +107,7 @@ func (b *Binding) IsValid() bool {
 
 // HasProxy returns true if the proxy protocol is active for this binding
 func (b *Binding) HasProxy() bool {
-	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
+	return b.ApplyProxyConfig && common.GetConfig().ProxyProtocol > 0
 }
 
 // Configuration for the SFTP server
@@ -96,6 +127,12 @@ type Configuration struct {
 	// If empty or missing, the daemon will search or try to generate "id_rsa" and "id_ecdsa" host keys
 	// inside the configuration directory.
 	HostKeys []string `json:"host_keys" mapstructure:"host_keys"`
+	// HostCertificates defines the OpenSSH host certificates, for example "id_rsa-cert.pub", signed
+	// by a host CA trusted by your clients. Each certificate must match one of the host keys.
+	// The paths can be absolute or relative to the configuration directory.
+	// Host keys and certificates can be reloaded on demand sending a "SIGHUP" signal on Unix based
+	// systems and a "paramchange" request to the running service on Windows.
+	HostCertificates []string `json:"host_certificates" mapstructure:"host_certificates"`
 	// KexAlgorithms specifies the available KEX (Key Exchange) algorithms in
 	// preference order.
 	KexAlgorithms []string `json:"kex_algorithms" mapstructure:"kex_algorithms"`
@@ -108,6 +145,12 @@ type Configuration struct {
 	// that are trusted to sign user certificates for authentication.
 	// The paths can be absolute or relative to the configuration directory
 	TrustedUserCAKeys []string `json:"trusted_user_ca_keys" mapstructure:"trusted_user_ca_keys"`
+	// RevokedUserCertsFile defines the path to a file containing the revoked user keys and certificates.
+	// It can be an OpenSSH key revocation list (KRL), as generated by "ssh-keygen -k", or a JSON list of
+	// SHA256 fingerprints. The path can be absolute or relative to the configuration directory.
+	// The file can be reloaded on demand sending a "SIGHUP" signal on Unix based systems and a
+	// "paramchange" request to the running service on Windows.
+	RevokedUserCertsFile string `json:"revoked_user_certs_file" mapstructure:"revoked_user_certs_file"`
 	// LoginBannerFile the contents of the specified file, if any, are sent to
 	// the remote user before authentication is allowed.
 	LoginBannerFile string `json:"login_banner_file" mapstructure:"login_banner_file"`
@@ -218,7 +261,7 @@ func (c *Configuration) Initialize(configDir string) error {
 		return common.ErrNoBinding
 	}
 
-	if err := c.checkAndLoadHostKeys(configDir, serverConfig); err != nil {
+	if err := c.checkAndLoadHostKeys(configDir); err != nil {
 		serviceStatus.HostKeys = nil
 		return err
 	}
@@ -237,46 +280,119 @@ func (c *Configuration) Initialize(configDir string) error {
 	c.checkSSHCommands()
 	c.checkFolderPrefix()
 
-	exitChannel := make(chan error, 1)
 	serviceStatus.Bindings = nil
+	servers.Reset()
+	runningServer.set(c, serverConfig)
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
 		}
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+		servers.GoBinding(c.getBindingStarter(binding, serverConfig))
+	}
+
+	serviceStatus.IsActive = true
+	serviceStatus.SSHCommands = c.EnabledSSHCommands
+
+	return servers.Wait()
+}
 
-		go func(binding Binding) {
+// ReloadBindings stops the removed bindings and starts the added ones, the
+// other bindings and the established connections are not affected. The
+// other configuration settings must be unchanged
+func ReloadBindings(ctx context.Context, bindings []Binding) error {
+	c, serverConfig := runningServer.get()
+	if c == nil {
+		return errors.New("the SFTP server is not running")
+	}
+	var removed, added []common.BindingStarter
+	var newBindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() && !isBindingInList(binding, bindings) {
+			removed = append(removed, c.getBindingStarter(binding, serverConfig))
+		}
+	}
+	for _, binding := range bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		newBindings = append(newBindings, binding)
+		if !isBindingInList(binding, c.Bindings) {
+			added = append(added, c.getBindingStarter(binding, serverConfig))
+		}
+	}
+	// the added bindings could define their own host keys
+	mgr := newHostKeysManager(hostKeysMgr.configDir, c.HostKeys, c.HostCertificates, newBindings)
+	if err := mgr.Reload(); err != nil {
+		return err
+	}
+	oldMgr := hostKeysMgr
+	hostKeysMgr = mgr
+	if err := servers.ReplaceBindings(ctx, removed, added); err != nil {
+		hostKeysMgr = oldMgr
+		if reloadErr := oldMgr.Reload(); reloadErr != nil {
+			logger.Warn(logSender, "", "unable to reload the previous host keys: %v", reloadErr)
+		}
+		return err
+	}
+	conf := *c
+	conf.Bindings = bindings
+	runningServer.set(&conf, serverConfig)
+	serviceStatus.Bindings = newBindings
+	return nil
+}
+
+func isBindingInList(binding Binding, bindings []Binding) bool {
+	for _, b := range bindings {
+		if common.IsSameBinding(b, binding) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Configuration) getBindingStarter(binding Binding, serverConfig *ssh.ServerConfig) common.BindingStarter {
+	return common.BindingStarter{
+		Key: binding.GetAddress(),
+		Start: func(b *common.ServerBinding) error {
 			addr := binding.GetAddress()
 			util.CheckTCP4Port(binding.Port)
 			listener, err := net.Listen("tcp", addr)
 			if err != nil {
 				logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
-				exitChannel <- err
-				return
+				common.SetBindingStatus(common.ProtocolSSH, addr, err)
+				return err
+			}
+			if err := b.AddListener(listener); err != nil {
+				return nil
 			}
 
-			if binding.ApplyProxyConfig && common.Config.ProxyProtocol > 0 {
-				proxyListener, err := common.Config.GetProxyListener(listener)
+			if binding.ApplyProxyConfig && common.GetConfig().ProxyProtocol > 0 {
+				proxyListener, err := common.GetConfig().GetProxyListener(listener)
 				if err != nil {
 					logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
-					exitChannel <- err
-					return
+					common.SetBindingStatus(common.ProtocolSSH, addr, err)
+					return err
 				}
 				listener = proxyListener
 			}
 
-			exitChannel <- c.serve(listener, serverConfig)
-		}(binding)
+			common.SetBindingStatus(common.ProtocolSSH, addr, nil)
+			err = c.serve(listener, b, binding, serverConfig)
+			if b.IsStopped() {
+				common.RemoveBindingStatus(common.ProtocolSSH, addr)
+			} else {
+				common.SetBindingStatus(common.ProtocolSSH, addr, err)
+			}
+			return err
+		},
 	}
-
-	serviceStatus.IsActive = true
-	serviceStatus.SSHCommands = c.EnabledSSHCommands
-
-	return <-exitChannel
 }
 
-func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) serve(listener net.Listener, b *common.ServerBinding, binding Binding,
+	serverConfig *ssh.ServerConfig,
+) error {
 	logger.Info(logSender, "", "server listener registered, address: %v", listener.Addr().String())
 	var tempDelay time.Duration // how long to sleep on accept failure
 
@@ -296,11 +412,15 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 				time.Sleep(tempDelay)
 				continue
 			}
+			if b.IsStopped() {
+				logger.Info(logSender, "", "server listener closed, address: %v", listener.Addr().String())
+				return nil
+			}
 			logger.Warn(logSender, "", "unrecoverable accept error: %v", err)
 			return err
 		}
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		go c.AcceptInboundConnection(conn, hostKeysMgr.getServerConfig(serverConfig, binding.GetAddress()))
 	}
 }
 
@@ -398,12 +518,22 @@ func canAcceptConnection(ip string) bool {
 	if err != nil {
 		return false
 	}
-	if err := common.Config.ExecutePostConnectHook(ip, common.ProtocolSSH); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ip, common.ProtocolSSH); err != nil {
 		return false
 	}
 	return true
 }
 
+// refuseConnection closes a new connection while the server is shutting down.
+// RFC 4253 allows the server to send other lines of data before the version
+// string, OpenSSH clients display them if the connection is closed
+func refuseConnection(conn net.Conn) {
+	logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, the server is shutting down")
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))      //nolint:errcheck
+	conn.Write([]byte(common.ErrShuttingDown.Error() + "\r\n")) //nolint:errcheck
+	conn.Close()
+}
+
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
 func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
 	defer func() {
@@ -415,7 +545,12 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	common.Connections.AddClientConnection(ipAddr)
 	defer common.Connections.RemoveClientConnection(ipAddr)
+	defer partialAuthCertPerms.Delete(conn.RemoteAddr().String())
 
+	if common.IsDraining() {
+		refuseConnection(conn)
+		return
+	}
 	if !canAcceptConnection(ipAddr) {
 		conn.Close()
 		return
@@ -441,6 +576,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	json.Unmarshal([]byte(sconn.Permissions.Extensions["sftpgo_user"]), &user) //nolint:errcheck
 
 	loginType := sconn.Permissions.Extensions["sftpgo_login_method"]
+	forceCommand := sconn.Permissions.CriticalOptions[forceCommandCriticalOption]
 	connectionID := hex.EncodeToString(sconn.SessionID())
 
 	if err = user.CheckFsRoot(connectionID); err != nil {
@@ -454,6 +590,10 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 	logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID,
 		"User %#v logged in with %#v, from ip %#v, client version %#v", user.Username, loginType,
 		ipAddr, string(sconn.ClientVersion()))
+	if forceCommand != "" {
+		logger.Log(logger.LevelInfo, common.ProtocolSSH, connectionID, "the certificate forces the command %#v",
+			forceCommand)
+	}
 	dataprovider.UpdateLastLogin(&user)
 
 	sshConnection := common.NewSSHConnection(connectionID, conn)
@@ -488,10 +628,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 			for req := range in {
 				ok := false
 				connID := fmt.Sprintf("%v_%v", connectionID, counter)
+				reqType, payload := getForcedRequest(req.Type, req.Payload, forceCommand)
 
-				switch req.Type {
+				switch reqType {
 				case "subsystem":
-					if string(req.Payload[4:]) == "sftp" {
+					if len(payload) > 4 && string(payload[4:]) == "sftp" {
 						ok = true
 						connection := Connection{
 							BaseConnection: common.NewBaseConnection(connID, common.ProtocolSFTP, conn.LocalAddr().String(),
@@ -515,7 +656,7 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						channel:       channel,
 						folderPrefix:  c.FolderPrefix,
 					}
-					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+					ok = processSSHCommand(payload, &connection, c.EnabledSSHCommands)
 				}
 				if req.WantReply {
 					req.Reply(ok, nil) //nolint:errcheck
@@ -591,11 +732,56 @@ func checkAuthError(ip string, err error) {
 	}
 }
 
+func getPartialAuthCertPermissions(conn ssh.ConnMetadata) *ssh.Permissions {
+	if len(conn.PartialSuccessMethods()) == 0 {
+		return nil
+	}
+	if val, ok := partialAuthCertPerms.LoadAndDelete(conn.RemoteAddr().String()); ok {
+		return val.(*ssh.Permissions)
+	}
+	return nil
+}
+
+// mergeCertPermissions merges SSH user certificate permissions with our ones.
+// We only set Extensions, so CriticalOptions are always the ones from the certificate
+func mergeCertPermissions(sshPerm, certPerm *ssh.Permissions) {
+	if certPerm == nil {
+		return
+	}
+	sshPerm.CriticalOptions = certPerm.CriticalOptions
+	for k, v := range certPerm.Extensions {
+		sshPerm.Extensions[k] = v
+	}
+}
+
+// isSFTPForceCommand returns true if the given force-command critical option
+// restricts the session to SFTP only
+func isSFTPForceCommand(command string) bool {
+	return command == internalSFTPCommand || path.Base(command) == "sftp-server"
+}
+
+// getForcedRequest returns the request type and payload to handle for a session request
+// honoring the force-command critical option, if any. Like OpenSSH, exec and subsystem
+// requests are replaced with the forced command and "internal-sftp" starts the SFTP subsystem
+func getForcedRequest(reqType string, payload []byte, forceCommand string) (string, []byte) {
+	if forceCommand == "" || (reqType != "exec" && reqType != "subsystem") {
+		return reqType, payload
+	}
+	if isSFTPForceCommand(forceCommand) {
+		return "subsystem", ssh.Marshal(&sshSubsystemExecMsg{Command: "sftp"})
+	}
+	return "exec", ssh.Marshal(&sshSubsystemExecMsg{Command: forceCommand})
+}
+
 func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.ConnMetadata) (*ssh.Permissions, error) {
 	connectionID := ""
 	if conn != nil {
 		connectionID = hex.EncodeToString(conn.SessionID())
 	}
+	if common.IsDraining() {
+		logger.Info(logSender, connectionID, "cannot login user %#v, the server is shutting down", user.Username)
+		return nil, common.ErrShuttingDown
+	}
 	if !filepath.IsAbs(user.HomeDir) {
 		logger.Warn(logSender, connectionID, "user %#v has an invalid home dir: %#v. Home dir must be an absolute path, login not allowed",
 			user.Username, user.HomeDir)
@@ -747,48 +933,21 @@ func (c *Configuration) checkHostKeyAutoGeneration(configDir string) error {
 }
 
 // If no host keys are defined we try to use or generate the default ones.
-func (c *Configuration) checkAndLoadHostKeys(configDir string, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) checkAndLoadHostKeys(configDir string) error {
 	if err := c.checkHostKeyAutoGeneration(configDir); err != nil {
 		return err
 	}
-	serviceStatus.HostKeys = nil
-	for _, hostKey := range c.HostKeys {
-		if !util.IsFileInputValid(hostKey) {
-			logger.Warn(logSender, "", "unable to load invalid host key %#v", hostKey)
-			logger.WarnToConsole("unable to load invalid host key %#v", hostKey)
-			continue
-		}
-		if !filepath.IsAbs(hostKey) {
-			hostKey = filepath.Join(configDir, hostKey)
-		}
-		logger.Info(logSender, "", "Loading private host key %#v", hostKey)
-
-		privateBytes, err := os.ReadFile(hostKey)
-		if err != nil {
-			return err
-		}
-
-		private, err := ssh.ParsePrivateKey(privateBytes)
-		if err != nil {
-			return err
-		}
-		k := HostKey{
-			Path:        hostKey,
-			Fingerprint: ssh.FingerprintSHA256(private.PublicKey()),
+	var bindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			bindings = append(bindings, binding)
 		}
-		serviceStatus.HostKeys = append(serviceStatus.HostKeys, k)
-		logger.Info(logSender, "", "Host key %#v loaded, type %#v, fingerprint %#v", hostKey,
-			private.PublicKey().Type(), k.Fingerprint)
-
-		// Add private key to the server configuration.
-		serverConfig.AddHostKey(private)
 	}
-	var fp []string
-	for idx := range serviceStatus.HostKeys {
-		h := &serviceStatus.HostKeys[idx]
-		fp = append(fp, h.Fingerprint)
+	mgr := newHostKeysManager(configDir, c.HostKeys, c.HostCertificates, bindings)
+	if err := mgr.Reload(); err != nil {
+		return err
 	}
-	vfs.SetSFTPFingerprints(fp)
+	hostKeysMgr = mgr
 	return nil
 }
 
@@ -816,9 +975,13 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 		}
 		c.parsedUserCAKeys = append(c.parsedUserCAKeys, parsedKey)
 	}
+	if err := c.loadRevokedUserCertsFile(configDir); err != nil {
+		return err
+	}
 	c.certChecker = &ssh.CertChecker{
 		SupportedCriticalOptions: []string{
 			sourceAddressCriticalOption,
+			forceCommandCriticalOption,
 		},
 		IsUserAuthority: func(k ssh.PublicKey) bool {
 			for _, key := range c.parsedUserCAKeys {
@@ -832,6 +995,23 @@ func (c *Configuration) initializeCertChecker(configDir string) error {
 	return nil
 }
 
+func (c *Configuration) loadRevokedUserCertsFile(configDir string) error {
+	revokedCertsFile := c.RevokedUserCertsFile
+	if revokedCertsFile != "" && !util.IsFileInputValid(revokedCertsFile) {
+		return fmt.Errorf("invalid revoked user certs file: %#v", revokedCertsFile)
+	}
+	if revokedCertsFile != "" && !filepath.IsAbs(revokedCertsFile) {
+		revokedCertsFile = filepath.Join(configDir, revokedCertsFile)
+	}
+	revokedCertManager.setFilePath(revokedCertsFile)
+	if err := revokedCertManager.load(); err != nil {
+		logger.Warn(logSender, "", "%v", err)
+		logger.WarnToConsole("%v", err)
+		return err
+	}
+	return nil
+}
+
 func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
 	var err error
 	var user dataprovider.User
@@ -842,6 +1022,12 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 	connectionID := hex.EncodeToString(conn.SessionID())
 	method := dataprovider.SSHLoginMethodPublicKey
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if revokedCertManager.isRevoked(pubKey) {
+		err = fmt.Errorf("ssh: public key %v is revoked", ssh.FingerprintSHA256(pubKey))
+		user.Username = conn.User()
+		updateLoginMetrics(&user, ipAddr, method, err)
+		return nil, err
+	}
 	cert, ok := pubKey.(*ssh.Certificate)
 	if ok {
 		if cert.CertType != ssh.UserCert {
@@ -856,28 +1042,31 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
-		if err := c.certChecker.CheckCert(conn.User(), cert); err != nil {
+		// the principals are checked against the user's mapping patterns after loading the user,
+		// here we validate everything else
+		principal := conn.User()
+		if len(cert.ValidPrincipals) > 0 && !util.IsStringInSlice(principal, cert.ValidPrincipals) {
+			principal = cert.ValidPrincipals[0]
+		}
+		if err := c.certChecker.CheckCert(principal, cert); err != nil {
 			user.Username = conn.User()
 			updateLoginMetrics(&user, ipAddr, method, err)
 			return nil, err
 		}
 		certPerm = &cert.Permissions
 	}
-	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH); err == nil {
+	if user, keyID, err = dataprovider.CheckUserAndPubKey(conn.User(), pubKey.Marshal(), ipAddr, common.ProtocolSSH,
+		certPerm != nil); err == nil {
 		if user.IsPartialAuth(method) {
 			logger.Debug(logSender, connectionID, "user %#v authenticated with partial success", conn.User())
+			if certPerm != nil {
+				partialAuthCertPerms.Store(conn.RemoteAddr().String(), certPerm)
+			}
 			return certPerm, ssh.ErrPartialSuccess
 		}
 		sshPerm, err = loginUser(&user, method, keyID, conn)
-		if err == nil && certPerm != nil {
-			// if we have a SSH user cert we need to merge certificate permissions with our ones
-			// we only set Extensions, so CriticalOptions are always the ones from the certificate
-			sshPerm.CriticalOptions = certPerm.CriticalOptions
-			if certPerm.Extensions != nil {
-				for k, v := range certPerm.Extensions {
-					sshPerm.Extensions[k] = v
-				}
-			}
+		if err == nil {
+			mergeCertPermissions(sshPerm, certPerm)
 		}
 	}
 	user.Username = conn.User()
@@ -897,6 +1086,9 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 	ipAddr := util.GetIPFromRemoteAddress(conn.RemoteAddr().String())
 	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
+		if err == nil {
+			mergeCertPermissions(sshPerm, getPartialAuthCertPermissions(conn))
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
@@ -916,6 +1108,9 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
 		ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
+		if err == nil {
+			mergeCertPermissions(sshPerm, getPartialAuthCertPermissions(conn))
+		}
 	}
 	user.Username = conn.User()
 	updateLoginMetrics(&user, ipAddr, method, err)
@@ -4,8 +4,14 @@
 package sftpd
 
 import (
+	"context"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/common"
 )
 
 const (
@@ -18,10 +24,35 @@ var (
 		"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync", "sftpgo-copy", "sftpgo-remove"}
 	defaultSSHCommands = []string{"md5sum", "sha1sum", "cd", "pwd", "scp"}
 	sshHashCommands    = []string{"md5sum", "sha1sum", "sha256sum", "sha384sum", "sha512sum"}
-	systemCommands     = []string{"git-receive-pack", "git-upload-pack", "git-upload-archive", "rsync"}
+	systemCommands     = []string{"git-upload-archive"}
 	serviceStatus      ServiceStatus
+	servers            common.ServerGroup
+	runningServer      runningServerConfig
 )
 
+// runningServerConfig stores the configuration used to start the server, it
+// is required to start the new bindings on configuration reload
+type runningServerConfig struct {
+	sync.RWMutex
+	config       *Configuration
+	serverConfig *ssh.ServerConfig
+}
+
+func (r *runningServerConfig) set(config *Configuration, serverConfig *ssh.ServerConfig) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+	r.serverConfig = serverConfig
+}
+
+func (r *runningServerConfig) get() (*Configuration, *ssh.ServerConfig) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.config, r.serverConfig
+}
+
 type sshSubsystemExitStatus struct {
 	Status uint32
 }
@@ -34,6 +65,7 @@ type sshSubsystemExecMsg struct {
 type HostKey struct {
 	Path        string `json:"path"`
 	Fingerprint string `json:"fingerprint"`
+	Certificate string `json:"certificate,omitempty"`
 }
 
 // ServiceStatus defines the service status
@@ -54,6 +86,12 @@ func GetStatus() ServiceStatus {
 	return serviceStatus
 }
 
+// Stop closes the SFTP server listeners, the established connections are not closed.
+// The server can be initialized again after Stop returns
+func Stop(ctx context.Context) error {
+	return servers.Stop(ctx)
+}
+
 // GetDefaultSSHCommands returns the SSH commands enabled as default
 func GetDefaultSSHCommands() []string {
 	result := make([]string, len(defaultSSHCommands))
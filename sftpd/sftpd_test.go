@@ -533,6 +533,82 @@ func TestBasicSFTPFsHandling(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSFTPFsPoolAndFailover(t *testing.T) {
+	usePubKey := false
+	baseUser, _, err := httpdtest.AddUser(getTestUser(usePubKey), http.StatusCreated)
+	assert.NoError(t, err)
+	unavailableEndpoint := "127.0.0.1:4"
+	u := getTestSFTPUser(usePubKey)
+	u.FsConfig.SFTPConfig.Endpoint = unavailableEndpoint
+	u.FsConfig.SFTPConfig.Endpoints = []string{sftpServerAddr, "invalid endpoint"}
+	u.FsConfig.SFTPConfig.MaxConnections = 1
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.SFTPConfig.Endpoints = []string{sftpServerAddr, unavailableEndpoint}
+	u.FsConfig.SFTPConfig.EndpointsPolicy = 2
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.SFTPConfig.EndpointsPolicy = vfs.SFTPEndpointsPolicyFailover
+	u.FsConfig.SFTPConfig.MaxSessionsPerConnection = -1
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.SFTPConfig.MaxSessionsPerConnection = 0
+	u.FsConfig.SFTPConfig.Endpoints = []string{sftpServerAddr}
+	user, resp, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err, string(resp))
+	assert.NoError(t, err)
+	conn1, client1, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn1.Close()
+		defer client1.Close()
+		conn2, client2, err := getSftpClient(user, usePubKey)
+		if assert.NoError(t, err) {
+			defer conn2.Close()
+			defer client2.Close()
+
+			err = checkBasicSFTP(client1)
+			assert.NoError(t, err)
+			err = checkBasicSFTP(client2)
+			assert.NoError(t, err)
+
+			status, _, err := httpdtest.GetStatus(http.StatusOK)
+			assert.NoError(t, err)
+			found := 0
+			for _, backend := range status.SFTPBackends {
+				switch backend.Endpoint {
+				case sftpServerAddr:
+					found++
+					assert.True(t, backend.IsHealthy)
+					assert.Equal(t, 1, backend.Connections)
+					assert.GreaterOrEqual(t, backend.Sessions, 2)
+				case unavailableEndpoint:
+					found++
+					assert.False(t, backend.IsHealthy)
+					assert.NotEmpty(t, backend.Error)
+					assert.Equal(t, 0, backend.Connections)
+				}
+			}
+			assert.Equal(t, 2, found)
+		}
+	}
+	vfs.CloseSFTPConnectionPools()
+	assert.Eventually(t, func() bool {
+		for _, backend := range vfs.GetSFTPEndpointsStatus() {
+			if backend.Connections > 0 {
+				return false
+			}
+		}
+		return true
+	}, 1*time.Second, 50*time.Millisecond)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(baseUser, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(baseUser.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestFolderPrefix(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -1181,7 +1257,9 @@ func TestLink(t *testing.T) {
 		err = client.Symlink(testFileName, testFileName+".link")
 		assert.Error(t, err, "creating a symlink to an existing one must fail")
 		err = client.Link(testFileName, testFileName+".hlink")
-		assert.Error(t, err, "hard link is not supported and must fail")
+		assert.NoError(t, err)
+		err = client.Remove(testFileName + ".hlink")
+		assert.NoError(t, err)
 		err = client.Remove(testFileName + ".link")
 		assert.NoError(t, err)
 		err = client.Remove(testFileName)
@@ -1754,6 +1832,60 @@ func TestLoginUserCert(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoginUserCertPrincipalMapping(t *testing.T) {
+	u := getTestUser(false)
+	u.Username = "team_member"
+	u.Filters.SSHCertPrincipals = []string{"other", "test_user_*"}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Len(t, user.PublicKeys, 0)
+	// the certificate is not listed within the user's public keys but a principal matches
+	signer, err := getSignerForUserCert([]byte(testCertValid))
+	assert.NoError(t, err)
+	conn, client, err := getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		assert.NoError(t, checkBasicSFTP(client))
+	}
+	// certificates from untrusted CAs and with invalid source addresses are still refused
+	for _, cert := range []string{testCertUntrustedCA, testCertOtherSourceAddress, testCertExpired} {
+		signer, err = getSignerForUserCert([]byte(cert))
+		assert.NoError(t, err)
+		conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+		if !assert.Error(t, err) {
+			client.Close()
+			conn.Close()
+		}
+	}
+	// the plain key is not allowed
+	conn, client, err = getSftpClient(user, true)
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// no matching principal
+	user.Filters.SSHCertPrincipals = []string{"test_user"}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	signer, err = getSignerForUserCert([]byte(testCertValid))
+	assert.NoError(t, err)
+	conn, client, err = getCustomAuthSftpClient(user, []ssh.AuthMethod{ssh.PublicKeys(signer)}, "")
+	if !assert.Error(t, err) {
+		client.Close()
+		conn.Close()
+	}
+	// invalid pattern
+	user.Filters.SSHCertPrincipals = []string{"test_user_["}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestMultiStepLoginKeyAndPwd(t *testing.T) {
 	u := getTestUser(true)
 	u.Password = defaultPassword
@@ -3697,9 +3829,16 @@ func TestSupportedExtensions(t *testing.T) {
 		assert.Equal(t, "2", v)
 		assert.True(t, ok)
 		_, ok = client.HasExtension("hardlink@openssh.com")
-		assert.False(t, ok)
+		assert.True(t, ok)
 		_, ok = client.HasExtension("posix-rename@openssh.com")
-		assert.False(t, ok)
+		assert.True(t, ok)
+		_, ok = client.HasExtension("fsync@openssh.com")
+		assert.True(t, ok)
+		_, ok = client.HasExtension("copy-data")
+		assert.True(t, ok)
+		v, ok = client.HasExtension("check-file")
+		assert.Equal(t, "md5,sha1,sha224,sha256,sha384,sha512", v)
+		assert.True(t, ok)
 	}
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
@@ -4151,6 +4290,64 @@ func TestBandwidthAndConnections(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestAbortTransferAndChangeBandwidth(t *testing.T) {
+	usePubKey := true
+	testFileSize := int64(524288)
+	u := getTestUser(usePubKey)
+	u.UploadBandwidth = 60
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		c := sftpUploadNonBlocking(testFilePath, testFileName, testFileSize, client)
+		waitForActiveTransfers(t)
+		time.Sleep(100 * time.Millisecond)
+
+		stats, _, err := httpdtest.GetConnections(http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, stats, 1) && assert.Len(t, stats[0].Transfers, 1) {
+			connectionID := stats[0].ConnectionID
+			transferID := stats[0].Transfers[0].ID
+			assert.Equal(t, u.UploadBandwidth, stats[0].UploadBandwidth)
+
+			_, err = httpdtest.SetConnectionBandwidth(connectionID, 30, 10, http.StatusOK)
+			assert.NoError(t, err)
+			_, err = httpdtest.SetConnectionBandwidth(connectionID, -1, 10, http.StatusBadRequest)
+			assert.NoError(t, err)
+			_, err = httpdtest.SetConnectionBandwidth("missing", 30, 10, http.StatusNotFound)
+			assert.NoError(t, err)
+			stats, _, err = httpdtest.GetConnections(http.StatusOK)
+			assert.NoError(t, err)
+			if assert.Len(t, stats, 1) {
+				assert.Equal(t, int64(30), stats[0].UploadBandwidth)
+				assert.Equal(t, int64(10), stats[0].DownloadBandwidth)
+			}
+
+			_, err = httpdtest.AbortTransfer(connectionID, transferID+1, http.StatusNotFound)
+			assert.NoError(t, err)
+			_, err = httpdtest.AbortTransfer(connectionID, transferID, http.StatusOK)
+			assert.NoError(t, err)
+			err = <-c
+			assert.Error(t, err, "transfer aborted while uploading: the upload must fail")
+			// the connection must remain open
+			_, err = client.Getwd()
+			assert.NoError(t, err)
+			assert.Len(t, common.Connections.GetStats(), 1)
+		}
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestPatternsFilters(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -7753,6 +7950,180 @@ func TestStatVFS(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHardlinkAndPosixRename(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 3
+	u.Permissions["/sub"] = []string{dataprovider.PermListItems, dataprovider.PermUpload}
+	u.Permissions["/nodownload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload,
+		dataprovider.PermCreateSymlinks, dataprovider.PermDelete}
+	u.Permissions["/noupload"] = []string{dataprovider.PermListItems, dataprovider.PermDownload,
+		dataprovider.PermCreateSymlinks}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		_, ok := client.HasExtension("hardlink@openssh.com")
+		assert.True(t, ok)
+		_, ok = client.HasExtension("posix-rename@openssh.com")
+		assert.True(t, ok)
+
+		testFileSize := int64(65535)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		err = client.Mkdir("sub")
+		assert.NoError(t, err)
+
+		err = client.Link(testFileName, testFileName+".link")
+		assert.NoError(t, err)
+		info, err := client.Stat(testFileName + ".link")
+		if assert.NoError(t, err) {
+			assert.Equal(t, testFileSize, info.Size())
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+		// hard links to directories are not supported
+		err = client.Link("sub", "sub.link")
+		assert.Error(t, err)
+		// no permission to create links inside /sub
+		err = client.Link(testFileName, path.Join("sub", testFileName))
+		assert.Error(t, err)
+		// a hard link requires the same permissions as a copy
+		err = client.Mkdir("nodownload")
+		assert.NoError(t, err)
+		err = client.Mkdir("noupload")
+		assert.NoError(t, err)
+		err = client.Link(testFileName, path.Join("noupload", testFileName))
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Link(testFileName, path.Join("nodownload", testFileName))
+		assert.NoError(t, err)
+		err = client.Link(path.Join("nodownload", testFileName), testFileName+".link1")
+		assert.ErrorIs(t, err, os.ErrPermission)
+		err = client.Remove(path.Join("nodownload", testFileName))
+		assert.NoError(t, err)
+		err = client.Link(testFileName, testFileName+".link1")
+		assert.NoError(t, err)
+		// quota exceeded
+		err = client.Link(testFileName, testFileName+".link2")
+		assert.Error(t, err)
+
+		err = client.PosixRename(testFileName+".link1", testFileName+".renamed")
+		assert.NoError(t, err)
+		_, err = client.Stat(testFileName + ".link1")
+		assert.Error(t, err)
+		_, err = client.Stat(testFileName + ".renamed")
+		assert.NoError(t, err)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, user.UsedQuotaFiles)
+		assert.Equal(t, 3*testFileSize, user.UsedQuotaSize)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestCopyDataCheckFileAndFsync(t *testing.T) {
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	u.QuotaFiles = 100
+	u.Permissions["/nodownload"] = []string{dataprovider.PermListItems, dataprovider.PermUpload,
+		dataprovider.PermCreateDirs}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user, usePubKey)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		testFileSize := int64(65535)
+		testFilePath := filepath.Join(homeBasePath, testFileName)
+		err = createTestFile(testFilePath, testFileSize)
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, testFileName, testFileSize, client)
+		assert.NoError(t, err)
+		expectedHash, err := computeHashForFile(sha256.New(), testFilePath)
+		assert.NoError(t, err)
+
+		algo, hashes, err := client.CheckFile(testFileName, "unknown,sha256,md5", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256", algo)
+		assert.Equal(t, expectedHash, fmt.Sprintf("%x", hashes))
+		algo, hashes, err = client.CheckFile(testFileName, "md5", 1024, 1024, 256)
+		assert.NoError(t, err)
+		assert.Equal(t, "md5", algo)
+		assert.Len(t, hashes, 4*16)
+		_, _, err = client.CheckFile(testFileName, "md5", 0, 0, 100)
+		assert.Error(t, err)
+		_, _, err = client.CheckFile(testFileName, "crc32", 0, 0, 0)
+		assert.Error(t, err)
+		_, _, err = client.CheckFile("missing", "sha256", 0, 0, 0)
+		assert.Error(t, err)
+
+		src, err := client.Open(testFileName)
+		if assert.NoError(t, err) {
+			algo, hashes, err = src.CheckFile("sha256", 0, 0, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, "sha256", algo)
+			assert.Equal(t, expectedHash, fmt.Sprintf("%x", hashes))
+
+			dst, err := client.Create(testFileName + ".copy")
+			if assert.NoError(t, err) {
+				err = src.CopyData(0, 0, dst, 0)
+				assert.NoError(t, err)
+				// the handle is not open for writing
+				err = dst.CopyData(0, 0, src, 0)
+				assert.Error(t, err)
+				// overlapping ranges on the same handle
+				err = dst.CopyData(0, 100, dst, 50)
+				assert.Error(t, err)
+				err = dst.Sync()
+				assert.NoError(t, err)
+				err = dst.Close()
+				assert.NoError(t, err)
+			}
+			err = src.Close()
+			assert.NoError(t, err)
+		}
+		algo, hashes, err = client.CheckFile(testFileName+".copy", "sha256", 0, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256", algo)
+		assert.Equal(t, expectedHash, fmt.Sprintf("%x", hashes))
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, 2*testFileSize, user.UsedQuotaSize)
+		// check-file requires the download permission
+		err = client.Mkdir("nodownload")
+		assert.NoError(t, err)
+		err = sftpUploadFile(testFilePath, path.Join("nodownload", testFileName), testFileSize, client)
+		assert.NoError(t, err)
+		_, _, err = client.CheckFile(path.Join("nodownload", testFileName), "sha256", 0, 0, 0)
+		assert.ErrorIs(t, err, os.ErrPermission)
+
+		err = os.Remove(testFilePath)
+		assert.NoError(t, err)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestStatVFSCloudBackend(t *testing.T) {
 	usePubKey := true
 	u := getTestUser(usePubKey)
@@ -8740,6 +9111,140 @@ func TestGitErrors(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGitPushNewRepo(t *testing.T) {
+	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
+		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.FsConfig.Provider = sdk.CryptedFilesystemProvider
+	u.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret(defaultPassword)
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	repoName := "newrepo"
+	localPath := filepath.Join(homeBasePath, repoName)
+	clonePath := filepath.Join(homeBasePath, "clonedrepo")
+	remoteURL := fmt.Sprintf("ssh://%v@127.0.0.1:2022/%v", user.Username, repoName)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(localPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	err = os.MkdirAll(localPath, os.ModePerm)
+	assert.NoError(t, err)
+	out, err := runGitCommand(localPath, "init")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = addFileToGitRepo(localPath, 65535)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(localPath, "tag", "-a", "v1.0", "-m", "release")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(localPath, "remote", "add", "origin", remoteURL)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	// the repository is created on first push
+	out, err = runGitCommand(localPath, "push", "origin", "HEAD:refs/heads/develop", "--tags")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	// the data are encrypted, system git cannot read this repository
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), repoName, "HEAD"))
+
+	out, err = runGitCommand(homeBasePath, "clone", remoteURL, clonePath)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(clonePath, "rev-parse", "--abbrev-ref", "HEAD")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	assert.Equal(t, "develop", strings.TrimSpace(string(out)))
+	out, err = runGitCommand(clonePath, "fsck", "--strict")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(clonePath, "tag", "-l")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	assert.Equal(t, "v1.0", strings.TrimSpace(string(out)))
+	// incremental push and fetch
+	out, err = addFileToGitRepo(localPath, 131072)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(localPath, "push", "origin", "HEAD:refs/heads/develop", "HEAD:refs/heads/feature")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(clonePath, "pull")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(clonePath, "fsck", "--strict")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	localHead, err := runGitCommand(localPath, "rev-parse", "HEAD")
+	assert.NoError(t, err)
+	cloneHead, err := runGitCommand(clonePath, "rev-parse", "HEAD")
+	assert.NoError(t, err)
+	assert.Equal(t, string(localHead), string(cloneHead))
+	// delete a branch
+	out, err = runGitCommand(localPath, "push", "origin", "--delete", "feature")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = runGitCommand(localPath, "ls-remote", "origin")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	assert.NotContains(t, string(out), "refs/heads/feature")
+	assert.Contains(t, string(out), "refs/heads/develop")
+	assert.Contains(t, string(out), "refs/tags/v1.0^{}")
+	// non fast-forward updates are accepted, the stale ones are rejected
+	out, err = runGitCommand(localPath, "push", "origin", "HEAD~1:refs/heads/develop", "--force-with-lease=develop:"+
+		strings.Repeat("1", 40))
+	assert.Error(t, err, "stale push must fail, out: %v", string(out))
+	out, err = runGitCommand(localPath, "push", "--force", "origin", "HEAD~1:refs/heads/develop")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	// pushing to a non empty directory that is not a repository must fail
+	out, err = runGitCommand(localPath, "push", fmt.Sprintf("ssh://%v@127.0.0.1:2022/", user.Username), "HEAD:refs/heads/main")
+	assert.Error(t, err, "push to a non repository must fail, out: %v", string(out))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(localPath)
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+}
+
+func TestGitPermissions(t *testing.T) {
+	if len(gitPath) == 0 || len(sshPath) == 0 || runtime.GOOS == osWindows {
+		t.Skip("git and/or ssh command not found or OS is windows, unable to execute this test")
+	}
+	usePubKey := true
+	u := getTestUser(usePubKey)
+	u.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	u.Permissions["/readonly"] = []string{dataprovider.PermGitRead}
+	u.Permissions["/readonly/pushrepo"] = []string{dataprovider.PermGitRead, dataprovider.PermGitPush}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	repoName := "testrepo"
+	clonePath := filepath.Join(homeBasePath, repoName)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+	for _, dir := range []string{repoName, "readonly/" + repoName, "readonly/pushrepo"} {
+		out, err := initGitRepo(filepath.Join(user.GetHomeDir(), dir))
+		assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	}
+	// git_read is required to clone
+	out, err := cloneGitRepo(homeBasePath, "/"+repoName, user.Username)
+	assert.Error(t, err, "clone without git_read permission must fail, out: %v", string(out))
+	assert.Contains(t, string(out), common.ErrPermissionDenied.Error())
+
+	out, err = cloneGitRepo(homeBasePath, "/readonly/"+repoName, user.Username)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	out, err = addFileToGitRepo(clonePath, 128)
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+	// git_push is required to push
+	out, err = runGitCommand(clonePath, "push", "origin", "HEAD:refs/heads/main")
+	assert.Error(t, err, "push without git_push permission must fail, out: %v", string(out))
+	assert.Contains(t, string(out), common.ErrPermissionDenied.Error())
+	out, err = runGitCommand(clonePath, "push", fmt.Sprintf("ssh://%v@127.0.0.1:2022/readonly/pushrepo", user.Username),
+		"HEAD:refs/heads/main")
+	assert.NoError(t, err, "unexpected error, out: %v", string(out))
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(clonePath)
+	assert.NoError(t, err)
+}
+
 // Start SCP tests
 func TestSCPBasicHandling(t *testing.T) {
 	if len(scpPath) == 0 {
@@ -10214,6 +10719,14 @@ func cloneGitRepo(basePath, remotePath, username string) ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+func runGitCommand(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command(gitPath, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("GIT_SSH=%v", gitWrapPath))
+	return cmd.CombinedOutput()
+}
+
 func addFileToGitRepo(repoPath string, fileSize int64) ([]byte, error) {
 	path := filepath.Join(repoPath, "test")
 	err := createTestFile(path, fileSize)
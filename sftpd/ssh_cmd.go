@@ -70,6 +70,28 @@ func processSSHCommand(payload []byte, connection *Connection, enabledSSHCommand
 				go scpCommand.handle() //nolint:errcheck
 				return true
 			}
+			if name == rsyncCmdName {
+				connection.SetProtocol(common.ProtocolSSH)
+				rsyncCommand := rsyncCommand{
+					sshCommand: sshCommand{
+						command:    name,
+						connection: connection,
+						args:       args},
+				}
+				go rsyncCommand.handle() //nolint:errcheck
+				return true
+			}
+			if util.IsStringInSlice(name, gitCommands) {
+				connection.SetProtocol(common.ProtocolSSH)
+				gitCommand := gitCommand{
+					sshCommand: sshCommand{
+						command:    name,
+						connection: connection,
+						args:       args},
+				}
+				go gitCommand.handle() //nolint:errcheck
+				return true
+			}
 			if name != scpCmdName {
 				connection.SetProtocol(common.ProtocolSSH)
 				sshCommand := sshCommand{
@@ -485,23 +507,6 @@ func (c *sshCommand) getSystemCommand() (systemCommand, error) {
 	if err := c.isSystemCommandAllowed(); err != nil {
 		return command, errUnsupportedConfig
 	}
-	if c.command == "rsync" {
-		// we cannot avoid that rsync creates symlinks so if the user has the permission
-		// to create symlinks we add the option --safe-links to the received rsync command if
-		// it is not already set. This should prevent to create symlinks that point outside
-		// the home dir.
-		// If the user cannot create symlinks we add the option --munge-links, if it is not
-		// already set. This should make symlinks unusable (but manually recoverable)
-		if c.connection.User.HasPerm(dataprovider.PermCreateSymlinks, c.getDestPath()) {
-			if !util.IsStringInSlice("--safe-links", args) {
-				args = append([]string{"--safe-links"}, args...)
-			}
-		} else {
-			if !util.IsStringInSlice("--munge-links", args) {
-				args = append([]string{"--munge-links"}, args...)
-			}
-		}
-	}
 	c.connection.Log(logger.LevelDebug, "new system command %#v, with args: %+v fs path %#v quota check path %#v",
 		c.command, args, fsPath, quotaPath)
 	cmd := exec.Command(c.command, args...)
@@ -727,6 +732,10 @@ func (c *sshCommand) sendExitStatus(err error) {
 	}
 	if err != nil {
 		status = uint32(1)
+		var exitErr *rsyncExitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.code
+		}
 		c.connection.Log(logger.LevelError, "command failed: %#v args: %v user: %v err: %v",
 			c.command, c.args, c.connection.User.Username, err)
 	}
@@ -753,6 +762,7 @@ func (c *sshCommand) sendExitStatus(err error) {
 		}
 		common.ExecuteActionNotification(c.connection.BaseConnection, common.OperationSSHCmd, cmdPath, vCmdPath, targetPath,
 			vTargetPath, c.command, 0, err)
+		c.connection.AuditFsCommand(c.command, vCmdPath, vTargetPath, 0, err)
 		if err == nil {
 			logger.CommandLog(sshCommandLogSender, cmdPath, targetPath, c.connection.User.Username, "", c.connection.ID,
 				common.ProtocolSSH, -1, -1, "", "", c.connection.command, -1, c.connection.GetLocalAddress(),
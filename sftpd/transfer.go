@@ -6,9 +6,9 @@ import (
 	"sync/atomic"
 
 	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
 
 	"github.com/drakkan/sftpgo/v2/common"
-	"github.com/drakkan/sftpgo/v2/metric"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
 
@@ -90,6 +90,10 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter,
 // ReadAt reads len(p) bytes from the File to download starting at byte offset off and updates the bytes sent.
 // It handles download bandwidth throttling too
 func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
+	if atomic.LoadInt32(&t.AbortTransfer) == 1 {
+		t.TransferError(common.ErrTransferAborted)
+		return 0, common.ErrTransferAborted
+	}
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.readerAt.ReadAt(p, off)
@@ -108,6 +112,10 @@ func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 // WriteAt writes len(p) bytes to the uploaded file starting at byte offset off and updates the bytes received.
 // It handles upload bandwidth throttling too
 func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
+	if atomic.LoadInt32(&t.AbortTransfer) == 1 {
+		t.TransferError(common.ErrTransferAborted)
+		return 0, common.ErrTransferAborted
+	}
 	t.Connection.UpdateLastActivity()
 	if off < t.MinWriteOffset {
 		err := fmt.Errorf("invalid write offset: %v minimum valid value: %v", off, t.MinWriteOffset)
@@ -129,6 +137,15 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 	return
 }
 
+// Sync commits the file contents to stable storage.
+// It is used for fsync@openssh.com requests and it is supported for local files only
+func (t *transfer) Sync() error {
+	if f, ok := t.File.(interface{ Sync() error }); ok {
+		return t.Connection.GetFsError(t.Fs, f.Sync())
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
 // Close it is called when the transfer is completed.
 // It closes the underlying file, logs the transfer info, updates the user quota (for uploads)
 // and executes any defined action.
@@ -189,6 +206,10 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 	isDownload := t.GetType() == common.TransferDownload
 	buf := make([]byte, 32768)
 	for {
+		if atomic.LoadInt32(&t.AbortTransfer) == 1 {
+			err = common.ErrTransferAborted
+			break
+		}
 		t.Connection.UpdateLastActivity()
 		nr, er := src.Read(buf)
 		if nr > 0 {
@@ -224,7 +245,7 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 	}
 	t.ErrTransfer = err
 	if written > 0 || err != nil {
-		metric.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.GetType(), t.ErrTransfer)
+		t.UpdateMetrics()
 	}
 	return written, err
 }
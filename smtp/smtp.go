@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"html/template"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	mail "github.com/xhit/go-simple-mail/v2"
@@ -38,6 +39,7 @@ var (
 	smtpServer     *mail.SMTPServer
 	from           string
 	emailTemplates = make(map[string]*template.Template)
+	lastSendError  atomic.Value
 )
 
 // IsEnabled returns true if an SMTP server is configured
@@ -45,6 +47,31 @@ func IsEnabled() bool {
 	return smtpServer != nil
 }
 
+// Status defines the SMTP status
+type Status struct {
+	IsActive bool   `json:"is_active"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	// Error for the last email sent, empty if the email was successfully sent
+	LastSendError string `json:"last_send_error,omitempty"`
+}
+
+// GetStatus returns the SMTP status
+func GetStatus() Status {
+	if smtpServer == nil {
+		return Status{}
+	}
+	status := Status{
+		IsActive: true,
+		Host:     smtpServer.Host,
+		Port:     smtpServer.Port,
+	}
+	if err, ok := lastSendError.Load().(string); ok {
+		status.LastSendError = err
+	}
+	return status
+}
+
 // Config defines the SMTP configuration to use to send emails
 type Config struct {
 	// Location of SMTP email server. Leavy empty to disable email sending capabilities
@@ -78,6 +105,7 @@ type Config struct {
 // Initialize initialized and validates the SMTP configuration
 func (c *Config) Initialize(configDir string) error {
 	smtpServer = nil
+	lastSendError.Store("")
 	if c.Host == "" {
 		logger.Debug(logSender, "", "configuration disabled, email capabilities will not be available")
 		return nil
@@ -173,6 +201,16 @@ func RenderPasswordResetTemplate(buf *bytes.Buffer, data interface{}) error {
 
 // SendEmail tries to send an email using the specified parameters.
 func SendEmail(to, subject, body string, contentType EmailContentType) error {
+	err := sendEmail(to, subject, body, contentType)
+	if err != nil {
+		lastSendError.Store(err.Error())
+	} else {
+		lastSendError.Store("")
+	}
+	return err
+}
+
+func sendEmail(to, subject, body string, contentType EmailContentType) error {
 	if smtpServer == nil {
 		return errors.New("smtp: not configured")
 	}
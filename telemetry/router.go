@@ -2,6 +2,7 @@ package telemetry
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -19,9 +20,7 @@ func initializeRouter(enableProfiler bool) {
 	router.Use(middleware.Recoverer)
 
 	router.Group(func(r chi.Router) {
-		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
-			render.PlainText(w, r, "ok")
-		})
+		r.Get("/healthz", getHealthz)
 	})
 
 	router.Group(func(router chi.Router) {
@@ -36,6 +35,29 @@ func initializeRouter(enableProfiler bool) {
 	})
 }
 
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	if !verbose {
+		if common.IsDraining() {
+			render.Status(r, http.StatusServiceUnavailable)
+			render.PlainText(w, r, common.HealthStatusDraining)
+			return
+		}
+		render.PlainText(w, r, "ok")
+		return
+	}
+	if !validateCredentials(r) {
+		w.Header().Set(common.HTTPAuthenticationHeader, "Basic realm=\"SFTPGo telemetry\"")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	status := common.GetHealthStatus()
+	if !status.IsHealthy() {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, status)
+}
+
 func checkAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !validateCredentials(r) {
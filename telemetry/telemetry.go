@@ -1,10 +1,12 @@
 // Package telemetry provides telemetry information for SFTPGo, such as:
 //		- health information (for health checks)
 //		- metrics
+//		- tracing
 // 		- profiling information
 package telemetry
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
 	"net/http"
@@ -16,6 +18,8 @@ import (
 
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/metric"
+	"github.com/drakkan/sftpgo/v2/tracing"
 	"github.com/drakkan/sftpgo/v2/util"
 )
 
@@ -29,6 +33,7 @@ var (
 	router   *chi.Mux
 	httpAuth common.HTTPAuthProvider
 	certMgr  *common.CertManager
+	servers  common.ServerGroup
 )
 
 // Conf telemetry server configuration.
@@ -63,6 +68,11 @@ type Conf struct {
 	// any invalid name will be silently ignored.
 	// The order matters, the ciphers listed first will be the preferred ones.
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// Metrics defines the configuration for the metrics labeled by protocol and user
+	Metrics metric.Config `json:"metrics" mapstructure:"metrics"`
+	// Tracing defines the configuration to export OpenTelemetry traces to an OTLP collector.
+	// Tracing does not require the telemetry HTTP server
+	Tracing tracing.Config `json:"tracing" mapstructure:"tracing"`
 }
 
 // ShouldBind returns true if there service must be started
@@ -85,6 +95,7 @@ func (c Conf) Initialize(configDir string) error {
 	if err != nil {
 		return err
 	}
+	certMgr = nil
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
 	initializeRouter(c.EnableProfiler)
@@ -111,9 +122,22 @@ func (c Conf) Initialize(configDir string) error {
 		}
 		logger.Debug(logSender, "", "configured TLS cipher suites: %v", config.CipherSuites)
 		httpServer.TLSConfig = config
-		return util.HTTPListenAndServe(httpServer, c.BindAddress, c.BindPort, true, logSender)
 	}
-	return util.HTTPListenAndServe(httpServer, c.BindAddress, c.BindPort, false, logSender)
+	servers.Reset()
+	if err := servers.AddHTTPServer(httpServer); err != nil {
+		return nil
+	}
+	err = util.HTTPListenAndServe(httpServer, c.BindAddress, c.BindPort, httpServer.TLSConfig != nil, logSender)
+	if servers.IsStopped() {
+		return nil
+	}
+	return err
+}
+
+// Stop closes the telemetry server listener, the in-flight requests are completed
+// before closing the connections. The server can be initialized again after Stop returns
+func Stop(ctx context.Context) error {
+	return servers.Stop(ctx)
 }
 
 // ReloadCertificateMgr reloads the certificate manager
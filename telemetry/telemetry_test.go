@@ -119,6 +119,12 @@ func TestRouter(t *testing.T) {
 	testServer.Config.Handler.ServeHTTP(rr, req)
 	require.Equal(t, http.StatusOK, rr.Code)
 	require.Equal(t, "ok", rr.Body.String())
+	// the verbose health status requires authentication
+	req, err = http.NewRequest(http.MethodGet, "/healthz?verbose=1", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	testServer.Config.Handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
 
 	req, err = http.NewRequest(http.MethodGet, "/metrics", nil)
 	require.NoError(t, err)
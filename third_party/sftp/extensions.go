@@ -0,0 +1,275 @@
+package sftp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	copyDataBufferSize    = 32768
+	checkFileMinBlockSize = 256
+	// leave room for the reply header
+	checkFileMaxReplySize = maxMsgLength - 1024
+)
+
+// checkFileAlgorithms defines the supported check-file algorithms in order of preference
+var checkFileAlgorithms = []string{"md5", "sha1", "sha224", "sha256", "sha384", "sha512"}
+
+func newCheckFileHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha224":
+		return sha256.New224()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// checkFile hashes the requested range using the first supported algorithm
+// from the client list
+func checkFile(r io.ReaderAt, p *sshFxpExtendedPacketCheckFile) responsePacket {
+	var algorithm string
+	var h hash.Hash
+	for _, name := range strings.Split(p.Algorithms, ",") {
+		algorithm = strings.TrimSpace(name)
+		if h = newCheckFileHash(algorithm); h != nil {
+			break
+		}
+	}
+	if h == nil {
+		return statusFromError(p.ID, ErrSSHFxOpUnsupported)
+	}
+	if p.BlockSize != 0 && p.BlockSize < checkFileMinBlockSize {
+		return statusFromError(p.ID, errors.New("invalid block size"))
+	}
+	if p.StartOffset > math.MaxInt64 || p.Length > math.MaxInt64 {
+		return statusFromError(p.ID, errors.New("invalid offset or length"))
+	}
+
+	offset := int64(p.StartOffset)
+	remaining := int64(p.Length)
+	blockSize := int64(p.BlockSize)
+	var blockLen int64
+	var hashes []byte
+	buf := make([]byte, copyDataBufferSize)
+
+	for p.Length == 0 || remaining > 0 {
+		size := int64(len(buf))
+		if p.Length > 0 && remaining < size {
+			size = remaining
+		}
+		if blockSize > 0 && blockSize-blockLen < size {
+			size = blockSize - blockLen
+		}
+		n, err := r.ReadAt(buf[:size], offset)
+		if n > 0 {
+			h.Write(buf[:n]) //nolint:errcheck
+			offset += int64(n)
+			remaining -= int64(n)
+			blockLen += int64(n)
+			if blockLen == blockSize {
+				if len(hashes)+h.Size() > checkFileMaxReplySize {
+					return statusFromError(p.ID, errors.New("too many blocks to hash"))
+				}
+				hashes = h.Sum(hashes)
+				h.Reset()
+				blockLen = 0
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return statusFromError(p.ID, err)
+		}
+		if n == 0 {
+			return statusFromError(p.ID, io.ErrNoProgress)
+		}
+	}
+	if blockLen > 0 || len(hashes) == 0 {
+		hashes = h.Sum(hashes)
+	}
+
+	return &sshFxpCheckFileReply{
+		ID:        p.ID,
+		Algorithm: algorithm,
+		Hashes:    hashes,
+	}
+}
+
+// copyData copies the requested range from r to w, a zero length means
+// copy until EOF
+func copyData(r io.ReaderAt, w io.WriterAt, p *sshFxpExtendedPacketCopyData) error {
+	if p.ReadOffset > math.MaxInt64 || p.ReadLength > math.MaxInt64 || p.WriteOffset > math.MaxInt64 {
+		return errors.New("invalid offset or length")
+	}
+	if p.ReadHandle == p.WriteHandle {
+		// a zero length range is unbounded
+		if p.ReadLength == 0 || (p.ReadOffset < p.WriteOffset+p.ReadLength && p.WriteOffset < p.ReadOffset+p.ReadLength) {
+			return errors.New("overlapping read and write ranges")
+		}
+	}
+
+	readOffset := int64(p.ReadOffset)
+	writeOffset := int64(p.WriteOffset)
+	remaining := int64(p.ReadLength)
+	buf := make([]byte, copyDataBufferSize)
+
+	for p.ReadLength == 0 || remaining > 0 {
+		size := int64(len(buf))
+		if p.ReadLength > 0 && remaining < size {
+			size = remaining
+		}
+		n, err := r.ReadAt(buf[:size], readOffset)
+		if n > 0 {
+			if _, errWrite := w.WriteAt(buf[:n], writeOffset); errWrite != nil {
+				return errWrite
+			}
+			readOffset += int64(n)
+			writeOffset += int64(n)
+			remaining -= int64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrNoProgress
+		}
+	}
+	return nil
+}
+
+func (p *sshFxpExtendedPacketFsync) respond(svr *Server) responsePacket {
+	f, ok := svr.getHandle(p.Handle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	return statusFromError(p.ID, f.Sync())
+}
+
+func (p *sshFxpExtendedPacketCopyData) respond(svr *Server) responsePacket {
+	rf, ok := svr.getHandle(p.ReadHandle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	wf, ok := svr.getHandle(p.WriteHandle)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	return statusFromError(p.ID, copyData(rf, wf, p))
+}
+
+func (p *sshFxpExtendedPacketCheckFile) respond(svr *Server) responsePacket {
+	if p.ExtendedRequest == "check-file-name" {
+		f, err := os.Open(toLocalPath(p.Target))
+		if err != nil {
+			return statusFromError(p.ID, err)
+		}
+		defer f.Close()
+
+		return checkFile(f, p)
+	}
+	f, ok := svr.getHandle(p.Target)
+	if !ok {
+		return statusFromError(p.ID, EBADF)
+	}
+	return checkFile(f, p)
+}
+
+func (rs *RequestServer) getHandleReaderAt(handle string) (io.ReaderAt, error) {
+	request, ok := rs.getRequest(handle)
+	if !ok {
+		return nil, EBADF
+	}
+	rd, _, rw := request.getAllReaderWriters()
+	if rd != nil {
+		return rd, nil
+	}
+	if rw != nil {
+		return rw, nil
+	}
+	return nil, errors.New("handle not open for reading")
+}
+
+func (rs *RequestServer) getHandleWriterAt(handle string) (io.WriterAt, error) {
+	request, ok := rs.getRequest(handle)
+	if !ok {
+		return nil, EBADF
+	}
+	_, wr, rw := request.getAllReaderWriters()
+	if wr != nil {
+		return wr, nil
+	}
+	if rw != nil {
+		return rw, nil
+	}
+	return nil, errors.New("handle not open for writing")
+}
+
+func (rs *RequestServer) fsync(p *sshFxpExtendedPacketFsync) error {
+	request, ok := rs.getRequest(p.Handle)
+	if !ok {
+		return EBADF
+	}
+	rd, wr, rw := request.getAllReaderWriters()
+	for _, f := range []interface{}{wr, rw, rd} {
+		if syncer, ok := f.(Syncer); ok {
+			return syncer.Sync()
+		}
+	}
+	return ErrSSHFxOpUnsupported
+}
+
+func (rs *RequestServer) copyData(p *sshFxpExtendedPacketCopyData) error {
+	rd, err := rs.getHandleReaderAt(p.ReadHandle)
+	if err != nil {
+		return err
+	}
+	wr, err := rs.getHandleWriterAt(p.WriteHandle)
+	if err != nil {
+		return err
+	}
+	return copyData(rd, wr, p)
+}
+
+func (rs *RequestServer) checkFile(ctx context.Context, p *sshFxpExtendedPacketCheckFile) responsePacket {
+	if p.ExtendedRequest == "check-file-name" {
+		request := NewRequest("Get", p.Target)
+		request.ctx, request.cancelCtx = context.WithCancel(ctx)
+		rd, err := rs.Handlers.FileGet.Fileread(request)
+		if err != nil {
+			request.cancelCtx()
+			return statusFromError(p.ID, wrapPathError(request.Filepath, err))
+		}
+		request.setReaderAt(rd)
+		defer request.close() //nolint:errcheck
+
+		return checkFile(rd, p)
+	}
+	rd, err := rs.getHandleReaderAt(p.Target)
+	if err != nil {
+		return statusFromError(p.ID, err)
+	}
+	return checkFile(rd, p)
+}
@@ -0,0 +1,80 @@
+// Package tracing provides optional OpenTelemetry tracing for SFTPGo.
+// Spans are exported to an OTLP collector using the HTTP protocol.
+package tracing
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	logSender = "tracing"
+	// DefaultURLPath is the default path used to send traces to the OTLP collector
+	DefaultURLPath = "/v1/traces"
+	// DefaultServiceName is the default service name reported in traces
+	DefaultServiceName = "sftpgo"
+)
+
+// Config defines the tracing configuration
+type Config struct {
+	// OTLP collector endpoint as host:port, for example "127.0.0.1:4318".
+	// Empty means tracing disabled
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	// URL path used to send traces. Default: "/v1/traces"
+	URLPath string `json:"url_path" mapstructure:"url_path"`
+	// Set to true to send traces using plain HTTP instead of HTTPS
+	Insecure bool `json:"insecure" mapstructure:"insecure"`
+	// Additional HTTP headers to send to the collector, for example for authentication
+	Headers map[string]string `json:"headers" mapstructure:"headers"`
+	// Service name reported in traces. Default: "sftpgo"
+	ServiceName string `json:"service_name" mapstructure:"service_name"`
+	// Fraction of root traces to sample, between 0 and 1. Default: 1, all traces are sampled.
+	// Child spans follow the sampling decision of their parent
+	SamplingRatio float64 `json:"sampling_ratio" mapstructure:"sampling_ratio"`
+}
+
+// IsEnabled returns true if an OTLP endpoint is configured
+func (c *Config) IsEnabled() bool {
+	return c.Endpoint != ""
+}
+
+func (c *Config) validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+	if c.SamplingRatio < 0 || c.SamplingRatio > 1 {
+		return fmt.Errorf("invalid tracing sampling ratio %v, it must be between 0 and 1", c.SamplingRatio)
+	}
+	if c.URLPath == "" {
+		c.URLPath = DefaultURLPath
+	}
+	if c.URLPath[0] != '/' {
+		return errors.New("invalid tracing URL path, it must start with \"/\"")
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = DefaultServiceName
+	}
+	return nil
+}
+
+// Attribute defines a key/value pair to add to a span.
+// Value can be a string, an int64 or a bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns an attribute with a string value
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int64 returns an attribute with an integer value
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns an attribute with a boolean value
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
@@ -0,0 +1,329 @@
+//go:build !notracing
+// +build !notracing
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/version"
+)
+
+const instrumentationName = "github.com/drakkan/sftpgo/v2"
+
+var (
+	mu       sync.RWMutex
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+
+	connSpans = connectionSpans{
+		spans: make(map[string]trace.Span),
+	}
+)
+
+func init() {
+	version.AddFeature("+tracing")
+}
+
+// Initialize configures the OTLP exporter and enables tracing.
+// Tracing is disabled if no endpoint is configured
+func Initialize(c Config) error {
+	Shutdown()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if !c.IsEnabled() {
+		logger.Debug(logSender, "", "tracing disabled, no OTLP endpoint configured")
+		return nil
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(c.Endpoint),
+		otlptracehttp.WithURLPath(c.URLPath),
+	}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(c.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("unable to create the OTLP trace exporter: %w", err)
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", c.ServiceName),
+		attribute.String("service.version", version.Get().Version),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SamplingRatio))),
+	)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn(logSender, "", "tracing error: %v", err)
+	}))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mu.Lock()
+	provider = tp
+	tracer = tp.Tracer(instrumentationName)
+	mu.Unlock()
+
+	logger.Info(logSender, "", "tracing enabled, OTLP endpoint %#v, URL path %#v, insecure %v, sampling ratio %v",
+		c.Endpoint, c.URLPath, c.Insecure, c.SamplingRatio)
+	return nil
+}
+
+// Shutdown flushes the pending spans and disables tracing
+func Shutdown() {
+	mu.Lock()
+	tp := provider
+	provider = nil
+	tracer = nil
+	mu.Unlock()
+
+	connSpans.clear()
+
+	if tp == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := tp.Shutdown(ctx); err != nil {
+		logger.Warn(logSender, "", "unable to flush pending spans: %v", err)
+		return
+	}
+	logger.Debug(logSender, "", "tracing stopped")
+}
+
+// IsEnabled returns true if tracing is enabled
+func IsEnabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return tracer != nil
+}
+
+func getTracer() trace.Tracer {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return tracer
+}
+
+// Span wraps an OpenTelemetry span.
+// A nil Span is valid and all its methods are no-op
+type Span struct {
+	span trace.Span
+}
+
+// StartSpan starts a new span as child of the span included in ctx, if any.
+// If tracing is disabled the returned span is nil and ctx is returned unchanged
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return startSpan(ctx, name, trace.SpanKindInternal, attrs...)
+}
+
+func startSpan(ctx context.Context, name string, kind trace.SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	t := getTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := t.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(convertAttributes(attrs)...))
+	return ctx, &Span{span: span}
+}
+
+// SetAttributes adds the specified attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(convertAttributes(attrs)...)
+}
+
+// End completes the span, a non nil error is recorded and sets an error status
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	endSpan(s.span, err)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func convertAttributes(attrs []Attribute) []attribute.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	result := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			result = append(result, attribute.String(a.Key, v))
+		case int64:
+			result = append(result, attribute.Int64(a.Key, v))
+		case int:
+			result = append(result, attribute.Int(a.Key, v))
+		case bool:
+			result = append(result, attribute.Bool(a.Key, v))
+		default:
+			result = append(result, attribute.String(a.Key, fmt.Sprintf("%v", v)))
+		}
+	}
+	return result
+}
+
+// connectionSpans keeps the root span for each active connection, so spans
+// created in lower layers, such as vfs, can be linked to the connection
+type connectionSpans struct {
+	sync.RWMutex
+	spans map[string]trace.Span
+}
+
+func (c *connectionSpans) add(id string, span trace.Span) {
+	c.Lock()
+	defer c.Unlock()
+
+	if old, ok := c.spans[id]; ok {
+		old.End()
+	}
+	c.spans[id] = span
+}
+
+func (c *connectionSpans) get(id string) (trace.Span, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	span, ok := c.spans[id]
+	return span, ok
+}
+
+func (c *connectionSpans) remove(id string) (trace.Span, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	span, ok := c.spans[id]
+	if ok {
+		delete(c.spans, id)
+	}
+	return span, ok
+}
+
+func (c *connectionSpans) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	for id, span := range c.spans {
+		span.End()
+		delete(c.spans, id)
+	}
+}
+
+// StartConnectionSpan starts the root span for the connection with the given id
+func StartConnectionSpan(connectionID, name string, attrs ...Attribute) {
+	_, span := startSpan(context.Background(), name, trace.SpanKindServer, attrs...)
+	if span == nil {
+		return
+	}
+	connSpans.add(connectionID, span.span)
+}
+
+// SetConnectionAttributes adds the specified attributes to the connection span
+func SetConnectionAttributes(connectionID string, attrs ...Attribute) {
+	if span, ok := connSpans.get(connectionID); ok {
+		span.SetAttributes(convertAttributes(attrs)...)
+	}
+}
+
+// EndConnectionSpan completes the span for the connection with the given id
+func EndConnectionSpan(connectionID string, err error) {
+	if span, ok := connSpans.remove(connectionID); ok {
+		endSpan(span, err)
+	}
+}
+
+// GetConnectionContext returns a context that includes the span for the
+// connection with the given id, if any
+func GetConnectionContext(connectionID string) context.Context {
+	ctx := context.Background()
+	if connectionID == "" {
+		return ctx
+	}
+	if span, ok := connSpans.get(connectionID); ok {
+		return trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport wraps the given transport, a client span is created for each
+// request and the trace context is propagated to the remote server
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := startSpan(req.Context(), "HTTP "+req.Method, trace.SpanKindClient,
+		String("http.method", req.Method), String("http.url", redactURL(req.URL)))
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return resp, err
+	}
+	span.SetAttributes(Int64("http.status_code", int64(resp.StatusCode)))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.End(fmt.Errorf("unexpected status code: %v", resp.StatusCode))
+	} else {
+		span.End(nil)
+	}
+	return resp, nil
+}
+
+// redactURL removes credentials and query parameters, they could contain secrets
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.ForceQuery = false
+	redacted.Fragment = ""
+	return redacted.String()
+}
@@ -0,0 +1,69 @@
+//go:build notracing
+// +build notracing
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/drakkan/sftpgo/v2/version"
+)
+
+func init() {
+	version.AddFeature("-tracing")
+}
+
+// Initialize returns an error if tracing is configured, this build does not support it
+func Initialize(c Config) error {
+	if c.IsEnabled() {
+		return errors.New("tracing disabled at build time")
+	}
+	return nil
+}
+
+// Shutdown flushes the pending spans and disables tracing
+func Shutdown() {}
+
+// IsEnabled returns true if tracing is enabled
+func IsEnabled() bool {
+	return false
+}
+
+// Span wraps an OpenTelemetry span.
+// A nil Span is valid and all its methods are no-op
+type Span struct{}
+
+// StartSpan starts a new span as child of the span included in ctx, if any.
+// If tracing is disabled the returned span is nil and ctx is returned unchanged
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return ctx, nil
+}
+
+// SetAttributes adds the specified attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {}
+
+// End completes the span, a non nil error is recorded and sets an error status
+func (s *Span) End(err error) {}
+
+// StartConnectionSpan starts the root span for the connection with the given id
+func StartConnectionSpan(connectionID, name string, attrs ...Attribute) {}
+
+// SetConnectionAttributes adds the specified attributes to the connection span
+func SetConnectionAttributes(connectionID string, attrs ...Attribute) {}
+
+// EndConnectionSpan completes the span for the connection with the given id
+func EndConnectionSpan(connectionID string, err error) {}
+
+// GetConnectionContext returns a context that includes the span for the
+// connection with the given id, if any
+func GetConnectionContext(connectionID string) context.Context {
+	return context.Background()
+}
+
+// NewTransport wraps the given transport, a client span is created for each
+// request and the trace context is propagated to the remote server
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	return base
+}
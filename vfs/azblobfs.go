@@ -210,6 +210,7 @@ func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReader
 		return nil, nil, nil, err
 	}
 	blobBlockURL := fs.containerURL.NewBlockBlobURL(name)
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "download", name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	blobDownloadResponse, err := blobBlockURL.Download(ctx, offset, azblob.CountToEnd, azblob.BlobAccessConditions{}, false,
 		azblob.ClientProvidedKeyOptions{})
@@ -217,6 +218,7 @@ func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReader
 		r.Close()
 		w.Close()
 		cancelFn()
+		op.end(err)
 		return nil, nil, nil, err
 	}
 	body := blobDownloadResponse.Body(azblob.RetryReaderOptions{
@@ -231,6 +233,7 @@ func (fs *AzureBlobFs) Open(name string, offset int64) (File, *pipeat.PipeReader
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
 		metric.AZTransferCompleted(n, 1, err)
+		op.endTransfer(n, err)
 	}()
 
 	return nil, r, cancelFn, nil
@@ -257,6 +260,7 @@ func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(),
 		headers.ContentType = contentType
 	}
 
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "upload", name)
 	go func() {
 		defer cancelFn()
 
@@ -274,6 +278,7 @@ func (fs *AzureBlobFs) Create(name string, flag int) (File, *PipeWriter, func(),
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v", name, r.GetReadedBytes(), err)
 		metric.AZTransferCompleted(r.GetReadedBytes(), 0, err)
+		op.endTransfer(r.GetReadedBytes(), err)
 	}()
 
 	return nil, p, cancelFn, nil
@@ -307,12 +312,15 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 	md := azblob.Metadata{}
 	mac := azblob.ModifiedAccessConditions{}
 	bac := azblob.BlobAccessConditions{}
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "copy", source)
+	op.setTarget(target)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	resp, err := dstBlobURL.StartCopyFromURL(ctx, srcURL, md, mac, bac, azblob.AccessTierType(fs.config.AccessTier), nil)
 	if err != nil {
 		metric.AZCopyObjectCompleted(err)
+		op.end(err)
 		return err
 	}
 	copyStatus := resp.CopyStatus()
@@ -327,6 +335,7 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 			nErrors++
 			if ctx.Err() != nil || nErrors == 3 {
 				metric.AZCopyObjectCompleted(err)
+				op.end(err)
 				return err
 			}
 		} else {
@@ -336,9 +345,11 @@ func (fs *AzureBlobFs) Rename(source, target string) error {
 	if copyStatus != azblob.CopyStatusSuccess {
 		err := fmt.Errorf("copy failed with status: %s", copyStatus)
 		metric.AZCopyObjectCompleted(err)
+		op.end(err)
 		return err
 	}
 	metric.AZCopyObjectCompleted(nil)
+	op.end(nil)
 	if plugin.Handler.HasMetadater() {
 		if !fi.IsDir() {
 			err = plugin.Handler.SetModificationTime(fs.getStorageID(), ensureAbsPath(target),
@@ -364,11 +375,13 @@ func (fs *AzureBlobFs) Remove(name string, isDir bool) error {
 		}
 	}
 	blobBlockURL := fs.containerURL.NewBlockBlobURL(name)
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "delete", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	_, err := blobBlockURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
 	metric.AZDeleteObjectCompleted(err)
+	op.end(err)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %v", name, errMetadata)
@@ -454,6 +467,7 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 	prefixes := make(map[string]bool)
 
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "list", dirname)
 	for marker := (azblob.Marker{}); marker.NotDone(); {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
@@ -470,6 +484,7 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 		})
 		if err != nil {
 			metric.AZListObjectsCompleted(err)
+			op.end(err)
 			return nil, err
 		}
 		marker = listBlob.NextMarker
@@ -513,6 +528,7 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	metric.AZListObjectsCompleted(nil)
+	op.end(nil)
 	return result, nil
 }
 
@@ -788,12 +804,14 @@ func (fs *AzureBlobFs) ResolvePath(virtualPath string) (string, error) {
 }
 
 func (fs *AzureBlobFs) headObject(name string) (*azblob.BlobGetPropertiesResponse, error) {
+	op := startFsOperation(fs.connectionID, traceBackendAzBlob, "head", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
 	blobBlockURL := fs.containerURL.NewBlockBlobURL(name)
 	response, err := blobBlockURL.GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
 	metric.AZHeadObjectCompleted(err)
+	op.end(err)
 	return response, err
 }
 
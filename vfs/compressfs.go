@@ -0,0 +1,552 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+const (
+	// compressFsName is the name for the Fs implementation with compression support
+	compressFsName = "compressfs"
+	// each chunk is compressed as an independent zstd frame so we can seek
+	// within a compressed file decompressing only the needed frames
+	compressChunkSize    = 1048576
+	compressIndexEntry   = 4
+	compressTrailerSize  = 24 // 8 (uncompressed size) + 4 (chunk size) + 4 (frames) + 8 (magic)
+	compressTrailerMagic = "SFTPGOZ1"
+	// maximum number of entries for the uncompressed sizes cache
+	compressSizeCacheMaxEntries = 10000
+)
+
+var (
+	errNotCompressed   = errors.New("the file is not compressed")
+	compressSizesCache = compressedSizesCache{
+		entries: make(map[string]compressedSizesCacheEntry),
+	}
+)
+
+// CompressedFs is a Fs implementation that wraps another Fs and compresses
+// the uploaded files using zstd.
+// Compressed files are stored as a sequence of independent zstd frames, one
+// for each chunk of uncompressed data, followed by a frame index and a trailer
+// with the uncompressed size. Files without a valid trailer, for example files
+// uploaded before enabling compression, are served as is
+type CompressedFs struct {
+	Fs
+	localTempDir string
+	mountPath    string
+	config       CompressionConfig
+}
+
+// NewCompressedFs returns a CompressedFs object wrapping the specified Fs
+func NewCompressedFs(fs Fs, mountPath string, config CompressionConfig) (Fs, error) {
+	if err := config.Validate(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	compressedFs := &CompressedFs{
+		Fs:        fs,
+		mountPath: mountPath,
+		config:    config,
+	}
+	if tempPath != "" {
+		compressedFs.localTempDir = tempPath
+	} else {
+		compressedFs.localTempDir = filepath.Clean(os.TempDir())
+	}
+	return compressedFs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *CompressedFs) Name() string {
+	return fmt.Sprintf("%v %v", compressFsName, fs.Fs.Name())
+}
+
+func (fs *CompressedFs) getStorageID() string {
+	return GetStorageID(fs.Fs)
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *CompressedFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *CompressedFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(name, info), nil
+}
+
+// Open opens the named file for reading
+func (fs *CompressedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	trailer, index, err := fs.readIndex(name, info.Size())
+	if err != nil {
+		if errors.Is(err, errNotCompressed) {
+			return fs.Fs.Open(name, offset)
+		}
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if offset >= trailer.size {
+		go func() {
+			w.CloseWithError(nil) //nolint:errcheck
+			fsLog(fs, logger.LevelDebug, "zero bytes download completed, path: %#v", name)
+		}()
+		return nil, r, nil, nil
+	}
+	frame := offset / trailer.chunkSize
+	var frameOffset int64
+	for _, frameSize := range index[:frame] {
+		frameOffset += frameSize
+	}
+	f, src, cancelFn, err := fs.Fs.Open(name, frameOffset)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, nil, err
+	}
+	var reader io.ReadCloser
+	if f != nil {
+		reader = newSectionFileReader(f, frameOffset, info.Size()-frameOffset)
+	} else {
+		reader = src
+	}
+
+	go func() {
+		skip := offset - frame*trailer.chunkSize
+		n, err := fs.decompress(w, reader, index[frame:], skip)
+		if err != nil && cancelFn != nil {
+			cancelFn()
+		}
+		reader.Close()
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *CompressedFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if flag&os.O_APPEND != 0 {
+		fsLog(fs, logger.LevelWarn, "appending to compressed files is not supported, path: %#v", name)
+		return nil, nil, nil, ErrVfsUnsupported
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(fs.getEncoderLevel()), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, dst, cancelFn, err := fs.Fs.Create(name, flag)
+	if err != nil {
+		encoder.Close()
+		return nil, nil, nil, err
+	}
+	var writer io.WriteCloser
+	if f != nil {
+		writer = f
+	} else {
+		writer = dst
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		encoder.Close()
+		if cancelFn != nil {
+			cancelFn()
+		}
+		writer.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		n, err := fs.compress(writer, r, encoder)
+		encoder.Close()
+		if err != nil && cancelFn != nil {
+			cancelFn()
+		}
+		errClose := writer.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Truncate changes the size of the named file
+func (*CompressedFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *CompressedFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	list, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(list))
+	for _, info := range list {
+		result = append(result, fs.convertFileInfo(fs.Join(dirname, info.Name()), info))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns false, compressed files cannot be resumed
+func (*CompressedFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *CompressedFs) ScanRootDirContents() (int, int64, error) {
+	if fs.config.PhysicalQuota {
+		return fs.Fs.ScanRootDirContents()
+	}
+	root := fs.mountPath
+	if root == "" {
+		root = "/"
+	}
+	rootPath, err := fs.Fs.ResolvePath(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.GetDirSize(rootPath)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *CompressedFs) GetDirSize(dirname string) (int, int64, error) {
+	if fs.config.PhysicalQuota {
+		return fs.Fs.GetDirSize(dirname)
+	}
+	numFiles := 0
+	size := int64(0)
+	isDir, err := IsDirectory(fs.Fs, dirname)
+	if err == nil && isDir {
+		err = fs.Fs.Walk(dirname, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() {
+				size += fs.convertFileInfo(walkedPath, info).Size()
+				numFiles++
+			}
+			return err
+		})
+	}
+	return numFiles, size, err
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *CompressedFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.Fs.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.convertFileInfo(walkedPath, info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type
+func (fs *CompressedFs) GetMimeType(name string) (string, error) {
+	_, r, cancelFn, err := fs.Open(name, 0)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if cancelFn != nil {
+		cancelFn()
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func (fs *CompressedFs) getEncoderLevel() zstd.EncoderLevel {
+	if fs.config.Level == 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevel(fs.config.Level)
+}
+
+func (fs *CompressedFs) compress(w io.Writer, r io.Reader, encoder *zstd.Encoder) (int64, error) {
+	var readed int64
+	var frameSizes []int64
+	var compressed []byte
+	buf := make([]byte, compressChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			readed += int64(n)
+			compressed = encoder.EncodeAll(buf[:n], compressed[:0])
+			if _, errWrite := w.Write(compressed); errWrite != nil {
+				return readed, errWrite
+			}
+			frameSizes = append(frameSizes, int64(len(compressed)))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return readed, err
+		}
+	}
+	trailer := compressedFileTrailer{
+		size:      readed,
+		chunkSize: compressChunkSize,
+		frames:    int64(len(frameSizes)),
+	}
+	_, err := w.Write(trailer.getIndexAndTrailer(frameSizes))
+	return readed, err
+}
+
+func (fs *CompressedFs) decompress(w io.Writer, r io.Reader, frameSizes []int64, skip int64) (int64, error) {
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return 0, err
+	}
+	defer decoder.Close()
+
+	var written int64
+	var buf, decompressed []byte
+	for _, frameSize := range frameSizes {
+		if int64(cap(buf)) < frameSize {
+			buf = make([]byte, frameSize)
+		}
+		buf = buf[:frameSize]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return written, err
+		}
+		decompressed, err = decoder.DecodeAll(buf, decompressed[:0])
+		if err != nil {
+			return written, err
+		}
+		data := decompressed
+		if skip > 0 {
+			if skip >= int64(len(data)) {
+				return written, fmt.Errorf("invalid compressed frame, size %v, skip %v", len(data), skip)
+			}
+			data = data[skip:]
+			skip = 0
+		}
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// readIndex returns the trailer and the sizes of the compressed frames
+func (fs *CompressedFs) readIndex(name string, size int64) (compressedFileTrailer, []int64, error) {
+	var trailer compressedFileTrailer
+	if size < compressTrailerSize {
+		return trailer, nil, errNotCompressed
+	}
+	data, err := fs.readTail(name, size, compressTrailerSize)
+	if err != nil {
+		return trailer, nil, err
+	}
+	if err := trailer.Load(data, size); err != nil {
+		return trailer, nil, err
+	}
+	indexSize := trailer.frames * compressIndexEntry
+	if indexSize == 0 {
+		return trailer, nil, nil
+	}
+	data, err = fs.readTail(name, size, indexSize+compressTrailerSize)
+	if err != nil {
+		return trailer, nil, err
+	}
+	index := make([]int64, 0, trailer.frames)
+	for i := int64(0); i < trailer.frames; i++ {
+		index = append(index, int64(binary.BigEndian.Uint32(data[i*compressIndexEntry:])))
+	}
+	return trailer, index, nil
+}
+
+// readTail reads the last length bytes of the named file
+func (fs *CompressedFs) readTail(name string, size, length int64) ([]byte, error) {
+	return readFileRange(fs.Fs, name, size-length, length)
+}
+
+// convertFileInfo returns a FileInfo with the uncompressed size
+func (fs *CompressedFs) convertFileInfo(name string, info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	physicalSize := info.Size()
+	if physicalSize < compressTrailerSize {
+		return info
+	}
+	size, err := fs.getUncompressedSize(name, info)
+	if err != nil {
+		return info
+	}
+	quotaSize := size
+	if fs.config.PhysicalQuota {
+		quotaSize = physicalSize
+	}
+	return &sizedFileInfo{
+		FileInfo:  info,
+		size:      size,
+		quotaSize: quotaSize,
+	}
+}
+
+// getUncompressedSize returns the size stored in the trailer of the named file.
+// The sizes are cached, so listing a directory again does not require to read
+// the trailers again
+func (fs *CompressedFs) getUncompressedSize(name string, info os.FileInfo) (int64, error) {
+	key := fs.getStorageID() + "\x00" + name
+	if size, ok := compressSizesCache.get(key, info); ok {
+		if size < 0 {
+			return 0, errNotCompressed
+		}
+		return size, nil
+	}
+	data, err := fs.readTail(name, info.Size(), compressTrailerSize)
+	if err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to read the trailer for file %#v: %v", name, err)
+		return 0, err
+	}
+	var trailer compressedFileTrailer
+	if err := trailer.Load(data, info.Size()); err != nil {
+		compressSizesCache.add(key, info, -1)
+		return 0, err
+	}
+	compressSizesCache.add(key, info, trailer.size)
+	return trailer.size, nil
+}
+
+type compressedFileTrailer struct {
+	size      int64
+	chunkSize int64
+	frames    int64
+}
+
+func (t *compressedFileTrailer) getIndexAndTrailer(frameSizes []int64) []byte {
+	buf := make([]byte, len(frameSizes)*compressIndexEntry+compressTrailerSize)
+	for idx, frameSize := range frameSizes {
+		binary.BigEndian.PutUint32(buf[idx*compressIndexEntry:], uint32(frameSize))
+	}
+	trailer := buf[len(frameSizes)*compressIndexEntry:]
+	binary.BigEndian.PutUint64(trailer, uint64(t.size))
+	binary.BigEndian.PutUint32(trailer[8:], uint32(t.chunkSize))
+	binary.BigEndian.PutUint32(trailer[12:], uint32(t.frames))
+	copy(trailer[16:], compressTrailerMagic)
+	return buf
+}
+
+// Load parses the trailer, fileSize is the size of the stored file
+func (t *compressedFileTrailer) Load(data []byte, fileSize int64) error {
+	if len(data) != compressTrailerSize || !bytes.Equal(data[16:], []byte(compressTrailerMagic)) {
+		return errNotCompressed
+	}
+	t.size = int64(binary.BigEndian.Uint64(data))
+	t.chunkSize = int64(binary.BigEndian.Uint32(data[8:]))
+	t.frames = int64(binary.BigEndian.Uint32(data[12:]))
+	if t.size < 0 || t.chunkSize <= 0 || t.frames*compressIndexEntry+compressTrailerSize > fileSize ||
+		t.frames != (t.size+t.chunkSize-1)/t.chunkSize {
+		return errNotCompressed
+	}
+	return nil
+}
+
+// sizedFileInfo is a FileInfo reporting the size of the decoded contents
+type sizedFileInfo struct {
+	os.FileInfo
+	size      int64
+	quotaSize int64
+}
+
+// Size returns the size of the decoded contents
+func (fi *sizedFileInfo) Size() int64 {
+	return fi.size
+}
+
+// QuotaSize returns the size to use for quota calculation
+func (fi *sizedFileInfo) QuotaSize() int64 {
+	return fi.quotaSize
+}
+
+// Checksum returns the checksum of the stored contents, if available
+func (fi *sizedFileInfo) Checksum() string {
+	return GetChecksum(fi.FileInfo)
+}
+
+type compressedSizesCacheEntry struct {
+	physicalSize int64
+	modTime      time.Time
+	size         int64
+}
+
+// compressedSizesCache caches the uncompressed sizes read from the trailers.
+// An entry is used only if the stored file has the same size and modification
+// time, a negative size means that the file is not compressed
+type compressedSizesCache struct {
+	sync.RWMutex
+	entries map[string]compressedSizesCacheEntry
+}
+
+func (c *compressedSizesCache) get(key string, info os.FileInfo) (int64, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.physicalSize != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		return 0, false
+	}
+	return entry.size, true
+}
+
+func (c *compressedSizesCache) add(key string, info os.FileInfo, size int64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.entries) >= compressSizeCacheMaxEntries {
+		c.entries = make(map[string]compressedSizesCacheEntry)
+	}
+	c.entries[key] = compressedSizesCacheEntry{
+		physicalSize: info.Size(),
+		modTime:      info.ModTime(),
+		size:         size,
+	}
+}
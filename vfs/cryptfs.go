@@ -322,7 +322,7 @@ type encryptedFileHeader struct {
 	nonce   []byte
 }
 
-func (h *encryptedFileHeader) Store(f *os.File) error {
+func (h *encryptedFileHeader) Store(f io.Writer) error {
 	buf := make([]byte, 0, headerV10Size)
 	buf = append(buf, version10)
 	buf = append(buf, h.nonce...)
@@ -330,7 +330,7 @@ func (h *encryptedFileHeader) Store(f *os.File) error {
 	return err
 }
 
-func (h *encryptedFileHeader) Load(f *os.File) error {
+func (h *encryptedFileHeader) Load(f io.Reader) error {
 	header := make([]byte, 1+nonceV10Size)
 	_, err := io.ReadFull(f, header)
 	if err != nil {
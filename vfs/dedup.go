@@ -0,0 +1,302 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+const (
+	dedupContentsDir = "contents"
+	dedupTempDir     = "tmp"
+)
+
+var (
+	dedupStorePath string
+	dedupMinSize   int64
+	// ErrDedupSkipped is returned if a file is not eligible for deduplication
+	ErrDedupSkipped = errors.New("deduplication skipped")
+)
+
+// DedupResult defines the result of a deduplication
+type DedupResult struct {
+	// SHA256 hash for the deduplicated content
+	Hash string
+	// Size of the deduplicated content
+	Size int64
+	// Number of files sharing this content
+	References int
+	// true if the file was replaced with a link to an already stored content.
+	// false if the file content was added to the content store
+	Linked bool
+}
+
+// SetDedupConfig sets the content store path and the minimum size for
+// deduplicated files. An empty store path disables deduplication
+func SetDedupConfig(storePath string, minSize int64) error {
+	if storePath == "" {
+		dedupStorePath = ""
+		dedupMinSize = 0
+		return nil
+	}
+	if !isDedupSupported() {
+		return errors.New("deduplication is not supported on this platform")
+	}
+	if !filepath.IsAbs(storePath) {
+		return fmt.Errorf("invalid deduplication store path %#v, it must be an absolute path", storePath)
+	}
+	for _, dir := range []string{dedupContentsDir, dedupTempDir} {
+		if err := os.MkdirAll(filepath.Join(storePath, dir), 0700); err != nil {
+			return fmt.Errorf("unable to create deduplication store dir: %w", err)
+		}
+	}
+	dedupStorePath = storePath
+	dedupMinSize = minSize
+	return nil
+}
+
+// IsDedupEnabled returns true if the content deduplication is enabled
+func IsDedupEnabled() bool {
+	return dedupStorePath != ""
+}
+
+// WalkDedupContents calls walkFn for each content inside the store.
+// The number of hard links for each content is passed to walkFn, a content
+// linked once is only referenced by the store and can be safely removed
+func WalkDedupContents(walkFn func(hash string, size int64, links uint64) error) error {
+	if !IsDedupEnabled() {
+		return nil
+	}
+	return filepath.Walk(filepath.Join(dedupStorePath, dedupContentsDir), func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return walkFn(info.Name(), info.Size(), getFileLinks(info))
+	})
+}
+
+// RemoveDedupContent removes the content with the specified hash from the store.
+// The content is not removed if it is still referenced. It returns true if the
+// content was removed
+func RemoveDedupContent(hash string) (bool, error) {
+	contentPath, err := getDedupContentPath(hash)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Lstat(contentPath)
+	if err != nil {
+		return false, err
+	}
+	if getFileLinks(info) > 1 {
+		return false, nil
+	}
+	if err := os.Remove(contentPath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Deduplicate adds the specified file to the content store. If a file with
+// the same content is already stored, the specified file is replaced with an
+// hard link to the stored one. Files sharing the same content also share
+// permissions and modification time, so a file is not linked if its
+// permissions differ from the stored ones or, if preserveTimes is true, if its
+// modification time differs. Any change that could modify the shared content
+// or its metadata will store a private copy of the file first.
+// ErrDedupSkipped is returned for files not eligible for deduplication
+func (fs *OsFs) Deduplicate(name string, preserveTimes bool) (DedupResult, error) {
+	var result DedupResult
+	if !IsDedupEnabled() || fs.name != osFsName {
+		return result, ErrDedupSkipped
+	}
+	info, err := os.Lstat(name)
+	if err != nil {
+		return result, err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 || info.Size() < dedupMinSize {
+		return result, ErrDedupSkipped
+	}
+	if getFileLinks(info) != 1 {
+		// already deduplicated or hard linked outside SFTPGo
+		return result, ErrDedupSkipped
+	}
+	hash, err := getFileHash(name)
+	if err != nil {
+		return result, err
+	}
+	// the file could be modified while we compute its hash from another connection
+	current, err := os.Lstat(name)
+	if err != nil {
+		return result, err
+	}
+	if !os.SameFile(info, current) || info.Size() != current.Size() || !info.ModTime().Equal(current.ModTime()) {
+		fsLog(fs, logger.LevelDebug, "file %#v changed while computing its hash, deduplication skipped", name)
+		return result, ErrDedupSkipped
+	}
+	result.Hash = hash
+	result.Size = info.Size()
+	contentPath, err := getDedupContentPath(hash)
+	if err != nil {
+		return result, err
+	}
+	if err := os.MkdirAll(filepath.Dir(contentPath), 0700); err != nil {
+		return result, err
+	}
+	err = os.Link(name, contentPath)
+	if err == nil {
+		result.References = 1
+		fsLog(fs, logger.LevelDebug, "file %#v added to the content store, hash %v", name, hash)
+		return result, nil
+	}
+	if !os.IsExist(err) {
+		return result, fs.getDedupError(name, err)
+	}
+	stored, err := os.Lstat(contentPath)
+	if err != nil {
+		return result, err
+	}
+	if stored.Size() != result.Size {
+		return result, fmt.Errorf("stored content %#v has size %v, expected %v", hash, stored.Size(), result.Size)
+	}
+	if stored.Mode() != info.Mode() || (preserveTimes && !stored.ModTime().Equal(info.ModTime())) {
+		fsLog(fs, logger.LevelDebug, "file %#v has different metadata than the stored content %v, deduplication skipped",
+			name, hash)
+		return result, ErrDedupSkipped
+	}
+	tempPath := getDedupTempPath()
+	if err := os.Link(contentPath, tempPath); err != nil {
+		return result, fs.getDedupError(name, err)
+	}
+	if err := os.Rename(tempPath, name); err != nil {
+		os.Remove(tempPath)
+		return result, err
+	}
+	result.Linked = true
+	if info, err := os.Lstat(contentPath); err == nil {
+		result.References = int(getFileLinks(info)) - 1
+	}
+	fsLog(fs, logger.LevelDebug, "file %#v replaced with a link to the stored content %v, references: %v",
+		name, hash, result.References)
+	return result, nil
+}
+
+func (fs *OsFs) getDedupError(name string, err error) error {
+	if isCrossDeviceError(err) {
+		fsLog(fs, logger.LevelWarn, "unable to deduplicate %#v, the content store is on a different device", name)
+		return ErrDedupSkipped
+	}
+	return err
+}
+
+// breakDedupLink replaces a deduplicated file with a private copy so the
+// shared content is not modified. If keepContent is false the file is
+// simply removed
+func (fs *OsFs) breakDedupLink(name string, keepContent bool) error {
+	if !IsDedupEnabled() {
+		return nil
+	}
+	info, err := os.Lstat(name)
+	if err != nil {
+		// let the caller handle this error
+		return nil
+	}
+	if !info.Mode().IsRegular() || getFileLinks(info) <= 1 {
+		return nil
+	}
+	if !isDedupContent(name, info) {
+		// hard linked by the user or outside SFTPGo
+		return nil
+	}
+	if !keepContent {
+		fsLog(fs, logger.LevelDebug, "remove deduplicated file %#v before overwriting it", name)
+		return os.Remove(name)
+	}
+	tempPath := getDedupTempPath()
+	if err := copyFileContent(name, tempPath, info); err != nil {
+		os.Remove(tempPath)
+		fsLog(fs, logger.LevelError, "unable to store a private copy of the deduplicated file %#v: %v", name, err)
+		return err
+	}
+	if err := os.Rename(tempPath, name); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	fsLog(fs, logger.LevelDebug, "private copy stored for the deduplicated file %#v", name)
+	return nil
+}
+
+// isDedupContent returns true if the specified file is linked to a content
+// inside the store
+func isDedupContent(name string, info os.FileInfo) bool {
+	hash, err := getFileHash(name)
+	if err != nil {
+		return false
+	}
+	contentPath, err := getDedupContentPath(hash)
+	if err != nil {
+		return false
+	}
+	stored, err := os.Lstat(contentPath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info, stored)
+}
+
+func copyFileContent(source, target string, info os.FileInfo) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+func getFileHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func getDedupContentPath(hash string) (string, error) {
+	if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("invalid content hash %#v", hash)
+	}
+	return filepath.Join(dedupStorePath, dedupContentsDir, hash[0:2], hash[2:4], hash), nil
+}
+
+func getDedupTempPath() string {
+	return filepath.Join(dedupStorePath, dedupTempDir, xid.New().String())
+}
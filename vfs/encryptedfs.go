@@ -0,0 +1,389 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/eikenb/pipeat"
+	"github.com/minio/sio"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+const (
+	// encryptedFsName is the name for the Fs implementation adding encryption to cloud and SFTP backends
+	encryptedFsName = "encryptedfs"
+	// sio DARE 2.0 packages: 16 bytes header, up to 64 KB payload, 16 bytes tag
+	sioPayloadSize = 65536
+	sioPackageSize = 16 + sioPayloadSize + 16
+)
+
+// EncryptedFs is a Fs implementation that wraps another Fs and encrypts
+// the stored files. The files are encrypted using the same format used for
+// the local encrypted filesystem, so encrypted files can be moved among
+// storage backends
+type EncryptedFs struct {
+	Fs
+	localTempDir string
+	mountPath    string
+	masterKey    []byte
+}
+
+// NewEncryptedFs returns an EncryptedFs object wrapping the specified Fs
+func NewEncryptedFs(fs Fs, mountPath string, config EncryptionConfig) (Fs, error) {
+	if err := config.Validate(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	if err := config.Passphrase.TryDecrypt(); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	encryptedFs := &EncryptedFs{
+		Fs:        fs,
+		mountPath: mountPath,
+		masterKey: []byte(config.Passphrase.GetPayload()),
+	}
+	if tempPath != "" {
+		encryptedFs.localTempDir = tempPath
+	} else {
+		encryptedFs.localTempDir = filepath.Clean(os.TempDir())
+	}
+	return encryptedFs, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *EncryptedFs) Name() string {
+	return fmt.Sprintf("%v %v", encryptedFsName, fs.Fs.Name())
+}
+
+func (fs *EncryptedFs) getStorageID() string {
+	return GetStorageID(fs.Fs)
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *EncryptedFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Stat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *EncryptedFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.Fs.Lstat(name)
+	if err != nil {
+		return info, err
+	}
+	return fs.convertFileInfo(info), nil
+}
+
+// Open opens the named file for reading.
+// Each sio package is encrypted using its sequence number, so we can start
+// decrypting from the package containing the requested offset.
+// If the offset is inside the first package the header is read from the
+// opened file, otherwise it is read using a ranged read
+func (fs *EncryptedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if offset >= info.Size() {
+		go func() {
+			w.CloseWithError(nil) //nolint:errcheck
+			fsLog(fs, logger.LevelDebug, "zero bytes download completed, path: %#v", name)
+		}()
+		return nil, r, nil, nil
+	}
+	sequenceNumber := offset / sioPayloadSize
+	var key [32]byte
+	readOffset := int64(0)
+	if sequenceNumber > 0 {
+		key, err = fs.getEncryptionKey(name)
+		if err != nil {
+			r.Close()
+			w.Close()
+			return nil, nil, nil, err
+		}
+		readOffset = headerV10Size + sequenceNumber*sioPackageSize
+	}
+	f, src, cancelFn, err := fs.Fs.Open(name, readOffset)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, nil, err
+	}
+	var reader io.ReadCloser
+	if f != nil {
+		reader = newSectionFileReader(f, readOffset, (1<<63)-1-readOffset)
+	} else {
+		reader = src
+	}
+
+	go func() {
+		var err error
+		if sequenceNumber == 0 {
+			key, err = fs.readEncryptionKey(reader)
+			if err != nil {
+				if cancelFn != nil {
+					cancelFn()
+				}
+				reader.Close()
+				w.CloseWithError(err) //nolint:errcheck
+				fsLog(fs, logger.LevelDebug, "unable to read the header for path %#v: %v", name, err)
+				return
+			}
+		}
+		config := fs.getSIOConfig(key)
+		config.SequenceNumber = uint32(sequenceNumber)
+		skip := offset - sequenceNumber*sioPayloadSize
+		var n int64
+		if skip == 0 {
+			n, err = sio.Decrypt(w, reader, config)
+		} else {
+			var decReader io.Reader
+			decReader, err = sio.DecryptReader(reader, config)
+			if err == nil {
+				_, err = io.CopyN(io.Discard, decReader, skip)
+				if err == nil {
+					n, err = io.Copy(w, decReader)
+				}
+			}
+		}
+		if err != nil && cancelFn != nil {
+			cancelFn()
+		}
+		reader.Close()
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *EncryptedFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if flag&os.O_APPEND != 0 {
+		fsLog(fs, logger.LevelWarn, "appending to encrypted files is not supported, path: %#v", name)
+		return nil, nil, nil, ErrVfsUnsupported
+	}
+	header := encryptedFileHeader{
+		version: version10,
+		nonce:   make([]byte, nonceV10Size),
+	}
+	if _, err := io.ReadFull(rand.Reader, header.nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := fs.deriveKey(header.nonce)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, dst, cancelFn, err := fs.Fs.Create(name, flag)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var writer io.WriteCloser
+	if f != nil {
+		writer = f
+	} else {
+		writer = dst
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		writer.Close()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		var n int64
+		err := header.Store(writer)
+		if err == nil {
+			n, err = sio.Encrypt(writer, r, fs.getSIOConfig(key))
+		}
+		if err != nil && cancelFn != nil {
+			cancelFn()
+		}
+		errClose := writer.Close()
+		if err == nil && errClose != nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v", name, n, err)
+	}()
+
+	return nil, p, cancelFn, nil
+}
+
+// Truncate changes the size of the named file
+func (*EncryptedFs) Truncate(name string, size int64) error {
+	return ErrVfsUnsupported
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *EncryptedFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	list, err := fs.Fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]os.FileInfo, 0, len(list))
+	for _, info := range list {
+		result = append(result, fs.convertFileInfo(info))
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns false sio does not support random access writes
+func (*EncryptedFs) IsUploadResumeSupported() bool {
+	return false
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their decrypted size
+func (fs *EncryptedFs) ScanRootDirContents() (int, int64, error) {
+	root := fs.mountPath
+	if root == "" {
+		root = "/"
+	}
+	rootPath, err := fs.Fs.ResolvePath(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fs.walkDirSize(rootPath)
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *EncryptedFs) GetDirSize(dirname string) (int, int64, error) {
+	if fs.Fs.HasVirtualFolders() {
+		// not supported for object storages
+		return fs.Fs.GetDirSize(dirname)
+	}
+	isDir, err := IsDirectory(fs.Fs, dirname)
+	if err != nil || !isDir {
+		return 0, 0, err
+	}
+	return fs.walkDirSize(dirname)
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *EncryptedFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.Fs.Walk(root, func(walkedPath string, info os.FileInfo, err error) error {
+		if err == nil && info != nil {
+			info = fs.convertFileInfo(info)
+		}
+		return walkFn(walkedPath, info, err)
+	})
+}
+
+// GetMimeType returns the content type
+func (fs *EncryptedFs) GetMimeType(name string) (string, error) {
+	_, r, cancelFn, err := fs.Open(name, 0)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if cancelFn != nil {
+		cancelFn()
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func (fs *EncryptedFs) walkDirSize(dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	err := fs.Walk(dirname, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info != nil && info.Mode().IsRegular() {
+			size += info.Size()
+			numFiles++
+		}
+		return nil
+	})
+	return numFiles, size, err
+}
+
+func (fs *EncryptedFs) getSIOConfig(key [32]byte) sio.Config {
+	return sio.Config{
+		MinVersion: sio.Version20,
+		MaxVersion: sio.Version20,
+		Key:        key[:],
+	}
+}
+
+func (fs *EncryptedFs) deriveKey(nonce []byte) ([32]byte, error) {
+	var key [32]byte
+	kdf := hkdf.New(sha256.New, fs.masterKey, nonce, nil)
+	_, err := io.ReadFull(kdf, key[:])
+	return key, err
+}
+
+// getEncryptionKey reads the header for the named file using a ranged read
+// and returns the derived encryption key
+func (fs *EncryptedFs) getEncryptionKey(name string) ([32]byte, error) {
+	data, err := readFileRange(fs.Fs, name, 0, headerV10Size)
+	if err != nil {
+		var key [32]byte
+		return key, err
+	}
+	return fs.readEncryptionKey(bytes.NewReader(data))
+}
+
+// readEncryptionKey reads the header from the specified reader and returns
+// the derived encryption key
+func (fs *EncryptedFs) readEncryptionKey(r io.Reader) ([32]byte, error) {
+	var key [32]byte
+	header := encryptedFileHeader{}
+	if err := header.Load(r); err != nil {
+		return key, err
+	}
+	return fs.deriveKey(header.nonce)
+}
+
+// convertFileInfo returns a FileInfo with the decrypted size
+func (fs *EncryptedFs) convertFileInfo(info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+	size := info.Size()
+	if size >= headerV10Size {
+		size -= headerV10Size
+		decryptedSize, err := sio.DecryptedSize(uint64(size))
+		if err == nil {
+			size = int64(decryptedSize)
+		}
+	} else {
+		size = 0
+	}
+	return &sizedFileInfo{
+		FileInfo:  info,
+		size:      size,
+		quotaSize: size,
+	}
+}
@@ -0,0 +1,134 @@
+package vfs
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eikenb/pipeat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/v2/kms"
+)
+
+func TestEncryptedFs(t *testing.T) {
+	rootDir := filepath.Join(os.TempDir(), "encrypted_root")
+	err := os.MkdirAll(rootDir, os.ModePerm)
+	require.NoError(t, err)
+	_, err = NewEncryptedFs(NewOsFs("", rootDir, ""), "", EncryptionConfig{Enabled: true})
+	assert.Error(t, err)
+	config := EncryptionConfig{
+		CryptFsConfig: CryptFsConfig{
+			Passphrase: kms.NewPlainSecret("encryption passphrase"),
+		},
+		Enabled: true,
+	}
+	baseFs := &openCounterFs{Fs: NewOsFs("", rootDir, "")}
+	fs, err := NewEncryptedFs(baseFs, "", config)
+	require.NoError(t, err)
+	assert.False(t, IsLocalOsFs(fs))
+	assert.False(t, IsCryptOsFs(fs))
+	assert.False(t, fs.IsUploadResumeSupported())
+
+	content := make([]byte, 200000)
+	_, err = rand.Read(content)
+	require.NoError(t, err)
+	fsPath := filepath.Join(rootDir, "file.bin")
+	_, w, _, err := fs.Create(fsPath, 0)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	assert.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+
+	physicalInfo, err := os.Stat(fsPath)
+	require.NoError(t, err)
+	assert.Greater(t, physicalInfo.Size(), int64(len(content)))
+	info, err := fs.Stat(fsPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), info.Size())
+	assert.Equal(t, info.Size(), GetQuotaSize(info))
+	contents, err := fs.ReadDir(rootDir)
+	require.NoError(t, err)
+	require.Len(t, contents, 1)
+	assert.Equal(t, int64(len(content)), contents[0].Size())
+	numFiles, size, err := fs.ScanRootDirContents()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(len(content)), size)
+	numFiles, size, err = fs.GetDirSize(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, numFiles)
+	assert.Equal(t, int64(len(content)), size)
+
+	for _, offset := range []int64{0, 100, 65536, 65536 + 10, 131072 + 1, int64(len(content)) - 1, int64(len(content))} {
+		baseFs.opens = 0
+		_, r, _, err := fs.Open(fsPath, offset)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, content[offset:], data, "offset %v", offset)
+		err = r.Close()
+		assert.NoError(t, err)
+		// the header is read from the opened file if the offset is inside the first package
+		switch {
+		case offset >= int64(len(content)):
+			assert.Equal(t, 0, baseFs.opens)
+		case offset < sioPayloadSize:
+			assert.Equal(t, 1, baseFs.opens, "offset %v", offset)
+		default:
+			assert.Equal(t, 2, baseFs.opens, "offset %v", offset)
+		}
+	}
+	// files encrypted by the local crypt filesystem can be decrypted too
+	cryptFs, err := NewCryptFs("", rootDir, "", config.CryptFsConfig)
+	require.NoError(t, err)
+	_, r, _, err := cryptFs.Open(fsPath, 0)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+	err = r.Close()
+	assert.NoError(t, err)
+	err = cryptFs.Close()
+	assert.NoError(t, err)
+
+	_, _, _, err = fs.Create(fsPath, os.O_WRONLY|os.O_APPEND)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	err = fs.Truncate(fsPath, 0)
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+	// empty files
+	emptyPath := filepath.Join(rootDir, "empty")
+	_, w, _, err = fs.Create(emptyPath, 0)
+	require.NoError(t, err)
+	err = w.Close()
+	assert.NoError(t, err)
+	info, err = fs.Stat(emptyPath)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+	_, r, _, err = fs.Open(emptyPath, 0)
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Len(t, data, 0)
+	err = r.Close()
+	assert.NoError(t, err)
+	err = fs.Close()
+	assert.NoError(t, err)
+
+	err = os.RemoveAll(rootDir)
+	assert.NoError(t, err)
+}
+
+type openCounterFs struct {
+	Fs
+	opens int
+}
+
+func (fs *openCounterFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	fs.opens++
+	return fs.Fs.Open(name, offset)
+}
@@ -12,6 +12,7 @@ type FileInfo struct {
 	sizeInBytes int64
 	modTime     time.Time
 	mode        os.FileMode
+	checksum    string
 }
 
 // NewFileInfo creates file info.
@@ -53,6 +54,12 @@ func (fi *FileInfo) ModTime() time.Time {
 	return fi.modTime
 }
 
+// Checksum returns the content checksum reported by the storage backend,
+// for example the S3 ETag or the GCS MD5 hash. It is empty if not available
+func (fi *FileInfo) Checksum() string {
+	return fi.checksum
+}
+
 // IsDir provides the abbreviation for Mode().IsDir()
 func (fi *FileInfo) IsDir() bool {
 	return fi.mode&os.ModeDir != 0
@@ -25,6 +25,8 @@ type Filesystem struct {
 	AzBlobConfig   AzBlobFsConfig         `json:"azblobconfig,omitempty"`
 	CryptConfig    CryptFsConfig          `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig           `json:"sftpconfig,omitempty"`
+	Compression    CompressionConfig      `json:"compression,omitempty"`
+	Encryption     EncryptionConfig       `json:"encryption,omitempty"`
 }
 
 // SetEmptySecrets sets the secrets to empty
@@ -36,6 +38,7 @@ func (f *Filesystem) SetEmptySecrets() {
 	f.CryptConfig.Passphrase = kms.NewEmptySecret()
 	f.SFTPConfig.Password = kms.NewEmptySecret()
 	f.SFTPConfig.PrivateKey = kms.NewEmptySecret()
+	f.Encryption.Passphrase = kms.NewEmptySecret()
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -61,6 +64,9 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.SFTPConfig.PrivateKey == nil {
 		f.SFTPConfig.PrivateKey = kms.NewEmptySecret()
 	}
+	if f.Encryption.Passphrase == nil {
+		f.Encryption.Passphrase = kms.NewEmptySecret()
+	}
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -88,6 +94,9 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.SFTPConfig.PrivateKey != nil && f.SFTPConfig.PrivateKey.IsEmpty() {
 		f.SFTPConfig.PrivateKey = nil
 	}
+	if f.Encryption.Passphrase != nil && f.Encryption.Passphrase.IsEmpty() {
+		f.Encryption.Passphrase = nil
+	}
 }
 
 // IsEqual returns true if the fs is equal to other
@@ -95,6 +104,12 @@ func (f *Filesystem) IsEqual(other *Filesystem) bool {
 	if f.Provider != other.Provider {
 		return false
 	}
+	if !f.Compression.isEqual(&other.Compression) {
+		return false
+	}
+	if !f.Encryption.isEqual(&other.Encryption) {
+		return false
+	}
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
 		return f.S3Config.isEqual(&other.S3Config)
@@ -114,6 +129,12 @@ func (f *Filesystem) IsEqual(other *Filesystem) bool {
 // Validate verifies the FsConfig matching the configured provider and sets all other
 // Filesystem.*Config to their zero value if successful
 func (f *Filesystem) Validate(helper ValidatorHelper) error {
+	if err := f.validateCompression(); err != nil {
+		return err
+	}
+	if err := f.validateEncryption(helper); err != nil {
+		return err
+	}
 	switch f.Provider {
 	case sdk.S3FilesystemProvider:
 		if err := f.S3Config.Validate(); err != nil {
@@ -183,6 +204,42 @@ func (f *Filesystem) Validate(helper ValidatorHelper) error {
 	}
 }
 
+func (f *Filesystem) validateCompression() error {
+	if err := f.Compression.Validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate compression config: %v", err))
+	}
+	if f.Compression.Enabled {
+		switch f.Provider {
+		case sdk.LocalFilesystemProvider, sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider,
+			sdk.AzureBlobFilesystemProvider:
+		default:
+			return util.NewValidationError("compression is supported for local and cloud storage backends only")
+		}
+	}
+	return nil
+}
+
+func (f *Filesystem) validateEncryption(helper ValidatorHelper) error {
+	if !f.Encryption.Enabled {
+		f.Encryption = EncryptionConfig{}
+		return nil
+	}
+	switch f.Provider {
+	case sdk.S3FilesystemProvider, sdk.GCSFilesystemProvider, sdk.AzureBlobFilesystemProvider,
+		sdk.SFTPFilesystemProvider:
+	default:
+		return util.NewValidationError("encryption is supported for cloud and SFTP storage backends only, " +
+			"use the encrypted local filesystem for local storage")
+	}
+	if err := f.Encryption.Validate(); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not validate encryption config: %v", err))
+	}
+	if err := f.Encryption.EncryptCredentials(helper.GetEncryptionAdditionalData()); err != nil {
+		return util.NewValidationError(fmt.Sprintf("could not encrypt the encryption passphrase: %v", err))
+	}
+	return nil
+}
+
 // HasRedactedSecret returns true if configured the filesystem configuration has a redacted secret
 func (f *Filesystem) HasRedactedSecret() bool {
 	// TODO move vfs specific code into each *FsConfig struct
@@ -214,6 +271,9 @@ func (f *Filesystem) HasRedactedSecret() bool {
 			return true
 		}
 	}
+	if f.Encryption.Enabled && f.Encryption.Passphrase.IsRedacted() {
+		return true
+	}
 
 	return false
 }
@@ -232,6 +292,9 @@ func (f *Filesystem) HideConfidentialData() {
 	case sdk.SFTPFilesystemProvider:
 		f.SFTPConfig.HideConfidentialData()
 	}
+	if f.Encryption.Enabled {
+		f.Encryption.HideConfidentialData()
+	}
 }
 
 // GetACopy returns a filesystem copy
@@ -293,13 +356,27 @@ func (f *Filesystem) GetACopy() Filesystem {
 				DisableCouncurrentReads: f.SFTPConfig.DisableCouncurrentReads,
 				BufferSize:              f.SFTPConfig.BufferSize,
 			},
-			Password:   f.SFTPConfig.Password.Clone(),
-			PrivateKey: f.SFTPConfig.PrivateKey.Clone(),
+			Password:                 f.SFTPConfig.Password.Clone(),
+			PrivateKey:               f.SFTPConfig.PrivateKey.Clone(),
+			EndpointsPolicy:          f.SFTPConfig.EndpointsPolicy,
+			MaxConnections:           f.SFTPConfig.MaxConnections,
+			MaxSessionsPerConnection: f.SFTPConfig.MaxSessionsPerConnection,
+		},
+		Compression: f.Compression,
+		Encryption: EncryptionConfig{
+			CryptFsConfig: CryptFsConfig{
+				Passphrase: f.Encryption.Passphrase.Clone(),
+			},
+			Enabled: f.Encryption.Enabled,
 		},
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
 		copy(fs.SFTPConfig.Fingerprints, f.SFTPConfig.Fingerprints)
 	}
+	if len(f.SFTPConfig.Endpoints) > 0 {
+		fs.SFTPConfig.Endpoints = make([]string, len(f.SFTPConfig.Endpoints))
+		copy(fs.SFTPConfig.Endpoints, f.SFTPConfig.Endpoints)
+	}
 	return fs
 }
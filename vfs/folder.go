@@ -28,6 +28,9 @@ type BaseVirtualFolder struct {
 	Users []string `json:"users,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// Name of the virtual folder to use as read-only lower layer. If set, this
+	// folder is an overlay: its own filesystem is the writable upper layer
+	LowerFolder string `json:"lower_folder,omitempty"`
 }
 
 // GetEncryptionAdditionalData returns the additional data to use for AEAD
@@ -54,6 +57,7 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		LastQuotaUpdate: v.LastQuotaUpdate,
 		Users:           users,
 		FsConfig:        v.FsConfig.GetACopy(),
+		LowerFolder:     v.LowerFolder,
 	}
 }
 
@@ -155,6 +159,9 @@ func (v *BaseVirtualFolder) HasRedactedSecret() bool {
 			return true
 		}
 	}
+	if v.FsConfig.Encryption.Enabled && v.FsConfig.Encryption.Passphrase.IsRedacted() {
+		return true
+	}
 	return false
 }
 
@@ -175,6 +182,23 @@ type VirtualFolder struct {
 
 // GetFilesystem returns the filesystem for this folder
 func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []string) (Fs, error) {
+	fs, err := v.getStorageFs(connectionID, forbiddenSelfUsers)
+	if err != nil {
+		return fs, err
+	}
+	if v.FsConfig.Encryption.Enabled {
+		fs, err = NewEncryptedFs(fs, v.VirtualPath, v.FsConfig.Encryption)
+		if err != nil {
+			return fs, err
+		}
+	}
+	if !v.FsConfig.Compression.Enabled {
+		return fs, nil
+	}
+	return NewCompressedFs(fs, v.VirtualPath, v.FsConfig.Compression)
+}
+
+func (v *VirtualFolder) getStorageFs(connectionID string, forbiddenSelfUsers []string) (Fs, error) {
 	switch v.FsConfig.Provider {
 	case sdk.S3FilesystemProvider:
 		return NewS3Fs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.S3Config)
@@ -213,6 +237,14 @@ func (v *VirtualFolder) ScanQuota() (int, int64, error) {
 	}
 	defer fs.Close()
 
+	if v.LowerFolder != "" {
+		// the lower folder is read-only, only the upper layer is included in the quota
+		rootVirtualPath := v.VirtualPath
+		if rootVirtualPath == "" {
+			rootVirtualPath = "/"
+		}
+		return scanOverlayUpperLayer(fs, rootVirtualPath)
+	}
 	return fs.ScanRootDirContents()
 }
 
@@ -5,6 +5,7 @@ package vfs
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -32,7 +33,7 @@ import (
 )
 
 var (
-	gcsDefaultFieldsSelection = []string{"Name", "Size", "Deleted", "Updated", "ContentType"}
+	gcsDefaultFieldsSelection = []string{"Name", "Size", "Deleted", "Updated", "ContentType", "MD5"}
 )
 
 // GCSFs is a Fs implementation for Google Cloud Storage.
@@ -141,6 +142,7 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 	}
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	obj := bkt.Object(name)
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "download", name)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	objectReader, err := obj.NewRangeReader(ctx, offset, -1)
 	if err == nil && offset > 0 && objectReader.Attrs.ContentEncoding == "gzip" {
@@ -151,6 +153,7 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 		r.Close()
 		w.Close()
 		cancelFn()
+		op.end(err)
 		return nil, nil, nil, err
 	}
 	go func() {
@@ -160,6 +163,7 @@ func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fu
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
 		metric.GCSTransferCompleted(n, 1, err)
+		op.endTransfer(n, err)
 	}()
 	return nil, r, cancelFn, nil
 }
@@ -190,6 +194,7 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 	if fs.config.ACL != "" {
 		objectWriter.PredefinedACL = fs.config.ACL
 	}
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "upload", name)
 	go func() {
 		defer cancelFn()
 
@@ -203,6 +208,7 @@ func (fs *GCSFs) Create(name string, flag int) (File, *PipeWriter, func(), error
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, acl: %#v, readed bytes: %v, err: %v",
 			name, fs.config.ACL, n, err)
 		metric.GCSTransferCompleted(n, 0, err)
+		op.endTransfer(n, err)
 	}()
 	return nil, p, cancelFn, nil
 }
@@ -252,8 +258,11 @@ func (fs *GCSFs) Rename(source, target string) error {
 	if contentType != "" {
 		copier.ContentType = contentType
 	}
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "copy", source)
+	op.setTarget(target)
 	_, err = copier.Run(ctx)
 	metric.GCSCopyObjectCompleted(err)
+	op.end(err)
 	if err != nil {
 		return err
 	}
@@ -284,6 +293,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 			name += "/"
 		}
 	}
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "delete", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
@@ -293,6 +303,7 @@ func (fs *GCSFs) Remove(name string, isDir bool) error {
 		err = fs.svc.Bucket(fs.config.Bucket).Object(strings.TrimSuffix(name, "/")).Delete(ctx)
 	}
 	metric.GCSDeleteObjectCompleted(err)
+	op.end(err)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %v", name, errMetadata)
@@ -387,6 +398,7 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	prefixes := make(map[string]bool)
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "list", dirname)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
@@ -399,6 +411,7 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 		}
 		if err != nil {
 			metric.GCSListObjectsCompleted(err)
+			op.end(err)
 			return result, err
 		}
 		if attrs.Prefix != "" {
@@ -433,10 +446,15 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				modTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
-			result = append(result, NewFileInfo(name, isDir, attrs.Size, modTime, false))
+			info := NewFileInfo(name, isDir, attrs.Size, modTime, false)
+			if !isDir {
+				info.checksum = hex.EncodeToString(attrs.MD5)
+			}
+			result = append(result, info)
 		}
 	}
 	metric.GCSListObjectsCompleted(nil)
+	op.end(nil)
 	return result, nil
 }
 
@@ -713,7 +731,11 @@ func (fs *GCSFs) getObjectStat(name string) (string, os.FileInfo, error) {
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
-		info, err = updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, isDir, objSize, objectModTime, false))
+		fi := NewFileInfo(name, isDir, objSize, objectModTime, false)
+		if !isDir {
+			fi.checksum = hex.EncodeToString(attrs.MD5)
+		}
+		info, err = updateFileInfoModTime(fs.getStorageID(), name, fi)
 		return name, info, err
 	}
 	if !fs.IsNotExist(err) {
@@ -800,6 +822,7 @@ func (fs *GCSFs) getPrefix(name string) string {
 }
 
 func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
+	op := startFsOperation(fs.connectionID, traceBackendGCS, "head", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 
@@ -807,6 +830,7 @@ func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
 	obj := bkt.Object(name)
 	attrs, err := obj.Attrs(ctx)
 	metric.GCSHeadObjectCompleted(err)
+	op.end(err)
 	return attrs, err
 }
 
@@ -55,6 +55,11 @@ func (fs *OsFs) Name() string {
 	return fs.name
 }
 
+// paths are absolute for the local filesystem
+func (*OsFs) getStorageID() string {
+	return "file://"
+}
+
 // ConnectionID returns the SSH connection ID associated to this Fs implementation
 func (fs *OsFs) ConnectionID() string {
 	return fs.connectionID
@@ -87,7 +92,10 @@ func (*OsFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func()
 }
 
 // Create creates or opens the named file for writing
-func (*OsFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+func (fs *OsFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if err := fs.breakDedupLink(name, flag != 0 && flag&os.O_TRUNC == 0); err != nil {
+		return nil, nil, nil, err
+	}
 	var err error
 	var f *os.File
 	if flag == 0 {
@@ -140,6 +148,11 @@ func (*OsFs) Symlink(source, target string) error {
 	return os.Symlink(source, target)
 }
 
+// Link creates target as a hard link to the source file
+func (*OsFs) Link(source, target string) error {
+	return os.Link(source, target)
+}
+
 // Readlink returns the destination of the named symbolic link
 // as absolute virtual path
 func (fs *OsFs) Readlink(name string) (string, error) {
@@ -151,22 +164,34 @@ func (fs *OsFs) Readlink(name string) (string, error) {
 }
 
 // Chown changes the numeric uid and gid of the named file.
-func (*OsFs) Chown(name string, uid int, gid int) error {
+func (fs *OsFs) Chown(name string, uid int, gid int) error {
+	if err := fs.breakDedupLink(name, true); err != nil {
+		return err
+	}
 	return os.Chown(name, uid, gid)
 }
 
 // Chmod changes the mode of the named file to mode
-func (*OsFs) Chmod(name string, mode os.FileMode) error {
+func (fs *OsFs) Chmod(name string, mode os.FileMode) error {
+	if err := fs.breakDedupLink(name, true); err != nil {
+		return err
+	}
 	return os.Chmod(name, mode)
 }
 
 // Chtimes changes the access and modification times of the named file
-func (*OsFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+func (fs *OsFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	if err := fs.breakDedupLink(name, true); err != nil {
+		return err
+	}
 	return os.Chtimes(name, atime, mtime)
 }
 
 // Truncate changes the size of the named file
-func (*OsFs) Truncate(name string, size int64) error {
+func (fs *OsFs) Truncate(name string, size int64) error {
+	if err := fs.breakDedupLink(name, true); err != nil {
+		return err
+	}
 	return os.Truncate(name, size)
 }
 
@@ -0,0 +1,778 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+)
+
+const (
+	overlayFsName = "overlayfs"
+	// files inside the upper layer with this prefix hide the matching
+	// files or directories of the lower layer
+	overlayWhiteoutPrefix = ".wh."
+	// a directory inside the upper layer containing this file hides the
+	// contents of the matching directory of the lower layer
+	overlayOpaqueMarker = ".wh..wh..opq"
+)
+
+// OverlayFs is a Fs implementation that combines a read-only lower layer with
+// a writable upper layer.
+// Reads fall through to the lower layer if a path does not exist inside the
+// upper layer, writes always go to the upper layer: lower files are copied to
+// the upper layer before modifying them. Removed lower files and directories
+// are recorded as whiteouts inside the upper layer
+type OverlayFs struct {
+	upper     Fs
+	lower     Fs
+	mountPath string
+}
+
+// NewOverlayFs returns an OverlayFs object. The upper and lower filesystems
+// must be created using the same mount path
+func NewOverlayFs(upper, lower Fs, mountPath string) Fs {
+	return &OverlayFs{
+		upper:     upper,
+		lower:     lower,
+		mountPath: mountPath,
+	}
+}
+
+// Name returns the name for the Fs implementation
+func (fs *OverlayFs) Name() string {
+	return fmt.Sprintf("%v upper %#v lower %#v", overlayFsName, fs.upper.Name(), fs.lower.Name())
+}
+
+// the visible paths are resolved inside the upper layer
+func (fs *OverlayFs) getStorageID() string {
+	return GetStorageID(fs.upper)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *OverlayFs) ConnectionID() string {
+	return fs.upper.ConnectionID()
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *OverlayFs) Stat(name string) (os.FileInfo, error) {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := layer.Stat(layerPath)
+	if err != nil || layer == fs.upper {
+		return info, err
+	}
+	return &overlayLowerFileInfo{info}, nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *OverlayFs) Lstat(name string) (os.FileInfo, error) {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := layer.Lstat(layerPath)
+	if err != nil || layer == fs.upper {
+		return info, err
+	}
+	return &overlayLowerFileInfo{info}, nil
+}
+
+// Open opens the named file for reading
+func (fs *OverlayFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return layer.Open(layerPath, offset)
+}
+
+// Create creates or opens the named file for writing.
+// A file existing only inside the lower layer is copied to the upper layer
+// if it is not truncated
+func (fs *OverlayFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	if err := fs.checkWritablePath(name); err != nil {
+		return nil, nil, nil, err
+	}
+	if flag != 0 && flag&os.O_TRUNC == 0 {
+		if err := fs.copyUpIfNeeded(name); err != nil && !fs.IsNotExist(err) {
+			return nil, nil, nil, err
+		}
+	}
+	if err := fs.prepareUpperPath(name); err != nil {
+		return nil, nil, nil, err
+	}
+	return fs.upper.Create(name, flag)
+}
+
+// Rename renames (moves) source to target.
+// Renaming directories existing inside the lower layer is not supported
+func (fs *OverlayFs) Rename(source, target string) error {
+	if err := fs.checkWritablePath(target); err != nil {
+		return err
+	}
+	layer, layerPath, err := fs.resolve(source)
+	if err != nil {
+		return err
+	}
+	sourceVirtualPath := fs.upper.GetRelativePath(source)
+	targetVirtualPath := fs.upper.GetRelativePath(target)
+	info, err := layer.Lstat(layerPath)
+	if err != nil {
+		return err
+	}
+	sourceInLower := fs.existsInLower(sourceVirtualPath)
+	if info.IsDir() && sourceInLower && !fs.isOpaqueDir(sourceVirtualPath) {
+		fsLog(fs, logger.LevelDebug, "renaming directory %#v is not supported, it exists inside the lower layer", source)
+		return ErrVfsUnsupported
+	}
+	if layer == fs.lower {
+		if err := fs.copyUp(source, layerPath); err != nil {
+			return err
+		}
+	}
+	targetInLower := fs.existsInLower(targetVirtualPath)
+	if err := fs.prepareUpperPath(target); err != nil {
+		return err
+	}
+	if err := fs.upper.Rename(source, target); err != nil {
+		return err
+	}
+	if info.IsDir() && targetInLower {
+		if err := fs.createMarker(path.Join(targetVirtualPath, overlayOpaqueMarker)); err != nil {
+			return err
+		}
+	}
+	if sourceInLower {
+		return fs.createWhiteout(sourceVirtualPath)
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+// A whiteout is created if the named file or directory exists inside the lower layer
+func (fs *OverlayFs) Remove(name string, isDir bool) error {
+	layer, _, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		contents, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(contents) > 0 {
+			return fmt.Errorf("cannot remove non empty directory: %#v", name)
+		}
+	}
+	virtualPath := fs.upper.GetRelativePath(name)
+	inLower := fs.existsInLower(virtualPath)
+	if layer == fs.upper {
+		if isDir {
+			if err := fs.removeMarkers(name); err != nil {
+				return err
+			}
+		}
+		if err := fs.upper.Remove(name, isDir); err != nil {
+			return err
+		}
+	}
+	if inLower {
+		return fs.createWhiteout(virtualPath)
+	}
+	return nil
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *OverlayFs) Mkdir(name string) error {
+	if err := fs.checkWritablePath(name); err != nil {
+		return err
+	}
+	if _, _, err := fs.resolve(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	virtualPath := fs.upper.GetRelativePath(name)
+	isWhiteout := fs.hasWhiteout(virtualPath)
+	if err := fs.prepareUpperPath(name); err != nil {
+		return err
+	}
+	if err := fs.upper.Mkdir(name); err != nil {
+		return err
+	}
+	if isWhiteout {
+		// the directory was removed from the lower layer, we don't want to show its contents again
+		return fs.createMarker(path.Join(virtualPath, overlayOpaqueMarker))
+	}
+	return nil
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents,
+// and returns nil, or else returns an error.
+// If path is already a directory, MkdirAll does nothing and returns nil.
+func (fs *OverlayFs) MkdirAll(name string, uid int, gid int) error {
+	return fs.upper.MkdirAll(name, uid, gid)
+}
+
+// Symlink creates source as a symbolic link to target.
+func (fs *OverlayFs) Symlink(source, target string) error {
+	if err := fs.checkWritablePath(target); err != nil {
+		return err
+	}
+	if err := fs.prepareUpperPath(target); err != nil {
+		return err
+	}
+	return fs.upper.Symlink(source, target)
+}
+
+// Readlink returns the destination of the named symbolic link
+func (fs *OverlayFs) Readlink(name string) (string, error) {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	return layer.Readlink(layerPath)
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (fs *OverlayFs) Chown(name string, uid int, gid int) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.upper.Chown(name, uid, gid)
+}
+
+// Chmod changes the mode of the named file to mode.
+func (fs *OverlayFs) Chmod(name string, mode os.FileMode) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.upper.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *OverlayFs) Chtimes(name string, atime, mtime time.Time, isUploading bool) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.upper.Chtimes(name, atime, mtime, isUploading)
+}
+
+// Truncate changes the size of the named file.
+func (fs *OverlayFs) Truncate(name string, size int64) error {
+	if err := fs.copyUpIfNeeded(name); err != nil {
+		return err
+	}
+	return fs.upper.Truncate(name, size)
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries merging the upper and the lower layers
+func (fs *OverlayFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	virtualPath := fs.upper.GetRelativePath(dirname)
+	if isOverlayMarker(path.Base(virtualPath)) {
+		return nil, os.ErrNotExist
+	}
+	result := make([]os.FileInfo, 0, 100)
+	upperNames := make(map[string]bool)
+	whiteouts := make(map[string]bool)
+	isOpaque := false
+
+	upperList, upperErr := fs.upper.ReadDir(dirname)
+	if upperErr == nil {
+		for _, info := range upperList {
+			name := info.Name()
+			if name == overlayOpaqueMarker {
+				isOpaque = true
+				continue
+			}
+			if isOverlayMarker(name) {
+				whiteouts[strings.TrimPrefix(name, overlayWhiteoutPrefix)] = true
+				continue
+			}
+			upperNames[name] = true
+			result = append(result, info)
+		}
+	} else if !fs.upper.IsNotExist(upperErr) {
+		return nil, upperErr
+	}
+	if isOpaque || fs.isHiddenInLower(virtualPath) {
+		return result, upperErr
+	}
+	lowerPath, err := fs.lower.ResolvePath(virtualPath)
+	if err != nil {
+		return result, upperErr
+	}
+	lowerList, err := fs.lower.ReadDir(lowerPath)
+	if err != nil {
+		if upperErr != nil {
+			return nil, err
+		}
+		if !fs.lower.IsNotExist(err) {
+			fsLog(fs, logger.LevelWarn, "unable to read lower directory %#v: %v", lowerPath, err)
+		}
+		return result, nil
+	}
+	for _, info := range lowerList {
+		name := info.Name()
+		if upperNames[name] || whiteouts[name] {
+			continue
+		}
+		result = append(result, &overlayLowerFileInfo{info})
+	}
+	return result, nil
+}
+
+// IsUploadResumeSupported returns true if resuming uploads is supported
+func (fs *OverlayFs) IsUploadResumeSupported() bool {
+	return fs.upper.IsUploadResumeSupported()
+}
+
+// IsAtomicUploadSupported returns true if atomic upload is supported
+func (fs *OverlayFs) IsAtomicUploadSupported() bool {
+	return fs.upper.IsAtomicUploadSupported()
+}
+
+// CheckRootPath creates the upper root directory if it does not exists
+func (fs *OverlayFs) CheckRootPath(username string, uid int, gid int) bool {
+	return fs.upper.CheckRootPath(username, uid, gid)
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+// inside the upper layer
+func (fs *OverlayFs) ResolvePath(virtualPath string) (string, error) {
+	return fs.upper.ResolvePath(virtualPath)
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (fs *OverlayFs) IsNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, os.ErrNotExist) || fs.upper.IsNotExist(err) || fs.lower.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (fs *OverlayFs) IsPermission(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, os.ErrPermission) || fs.upper.IsPermission(err) || fs.lower.IsPermission(err)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (fs *OverlayFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported || fs.upper.IsNotSupported(err) || fs.lower.IsNotSupported(err)
+}
+
+// ScanRootDirContents returns the number of files and their size contained
+// inside the upper layer. Quota is only tracked for the upper layer
+func (fs *OverlayFs) ScanRootDirContents() (int, int64, error) {
+	return scanOverlayUpperLayer(fs.upper, fs.getRootVirtualPath())
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// inside the upper layer
+func (fs *OverlayFs) GetDirSize(dirname string) (int, int64, error) {
+	return getOverlayUpperDirSize(fs.upper, dirname)
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (fs *OverlayFs) GetAtomicUploadPath(name string) string {
+	return fs.upper.GetAtomicUploadPath(name)
+}
+
+// GetRelativePath returns the path for a file relative to the user's home dir.
+func (fs *OverlayFs) GetRelativePath(name string) string {
+	return fs.upper.GetRelativePath(name)
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. Both layers are walked
+func (fs *OverlayFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = fs.walk(root, info, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// Join joins any number of path elements into a single path
+func (fs *OverlayFs) Join(elem ...string) string {
+	return fs.upper.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*OverlayFs) HasVirtualFolders() bool {
+	return false
+}
+
+// GetMimeType returns the content type
+func (fs *OverlayFs) GetMimeType(name string) (string, error) {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	return layer.GetMimeType(layerPath)
+}
+
+// GetAvailableDiskSize return the available size for the specified path
+// inside the upper layer
+func (fs *OverlayFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	return fs.upper.GetAvailableDiskSize(dirName)
+}
+
+// CheckMetadata checks the metadata consistency for the upper layer
+func (fs *OverlayFs) CheckMetadata() error {
+	return fs.upper.CheckMetadata()
+}
+
+// Close closes both layers
+func (fs *OverlayFs) Close() error {
+	errUpper := fs.upper.Close()
+	errLower := fs.lower.Close()
+	if errUpper != nil {
+		return errUpper
+	}
+	return errLower
+}
+
+func (fs *OverlayFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	contents, err := fs.ReadDir(name)
+	err1 := walkFn(name, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range contents {
+		err = fs.walk(fs.Join(name, fi.Name()), fi, walkFn)
+		if err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the layer containing the named file and the path to use
+// within that layer
+func (fs *OverlayFs) resolve(name string) (Fs, string, error) {
+	virtualPath := fs.upper.GetRelativePath(name)
+	if isOverlayMarker(path.Base(virtualPath)) {
+		return nil, "", os.ErrNotExist
+	}
+	_, err := fs.upper.Lstat(name)
+	if err == nil {
+		return fs.upper, name, nil
+	}
+	if !fs.upper.IsNotExist(err) {
+		return nil, "", err
+	}
+	if fs.isHiddenInLower(virtualPath) {
+		return nil, "", err
+	}
+	lowerPath, errLower := fs.lower.ResolvePath(virtualPath)
+	if errLower != nil {
+		return nil, "", err
+	}
+	if _, errLower = fs.lower.Lstat(lowerPath); errLower != nil {
+		return nil, "", errLower
+	}
+	return fs.lower, lowerPath, nil
+}
+
+func (fs *OverlayFs) getRootVirtualPath() string {
+	if fs.mountPath != "" {
+		return fs.mountPath
+	}
+	return "/"
+}
+
+// isHiddenInLower returns true if the specified virtual path, or one of
+// its parent directories, has a whiteout or an opaque directory inside
+// the upper layer
+func (fs *OverlayFs) isHiddenInLower(virtualPath string) bool {
+	root := fs.getRootVirtualPath()
+	for p := virtualPath; p != root && p != "/" && p != "."; p = path.Dir(p) {
+		if fs.hasWhiteout(p) || fs.isOpaqueDir(path.Dir(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *OverlayFs) existsInLower(virtualPath string) bool {
+	if fs.isHiddenInLower(virtualPath) {
+		return false
+	}
+	lowerPath, err := fs.lower.ResolvePath(virtualPath)
+	if err != nil {
+		return false
+	}
+	_, err = fs.lower.Lstat(lowerPath)
+	return err == nil
+}
+
+func (fs *OverlayFs) hasWhiteout(virtualPath string) bool {
+	return fs.existsInUpper(path.Join(path.Dir(virtualPath), overlayWhiteoutPrefix+path.Base(virtualPath)))
+}
+
+func (fs *OverlayFs) isOpaqueDir(virtualPath string) bool {
+	return fs.existsInUpper(path.Join(virtualPath, overlayOpaqueMarker))
+}
+
+func (fs *OverlayFs) existsInUpper(virtualPath string) bool {
+	fsPath, err := fs.upper.ResolvePath(virtualPath)
+	if err != nil {
+		return false
+	}
+	_, err = fs.upper.Lstat(fsPath)
+	return err == nil
+}
+
+func (fs *OverlayFs) checkWritablePath(name string) error {
+	if isOverlayMarker(path.Base(fs.upper.GetRelativePath(name))) {
+		fsLog(fs, logger.LevelWarn, "file names starting with %#v are reserved, path: %#v", overlayWhiteoutPrefix, name)
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// prepareUpperPath creates the missing parent directories inside the upper layer
+// and removes the whiteout for the named file, if any
+func (fs *OverlayFs) prepareUpperPath(name string) error {
+	virtualPath := fs.upper.GetRelativePath(name)
+	if err := fs.ensureUpperDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	whiteout := path.Join(path.Dir(virtualPath), overlayWhiteoutPrefix+path.Base(virtualPath))
+	if !fs.existsInUpper(whiteout) {
+		return nil
+	}
+	whiteoutPath, err := fs.upper.ResolvePath(whiteout)
+	if err != nil {
+		return err
+	}
+	return fs.upper.Remove(whiteoutPath, false)
+}
+
+func (fs *OverlayFs) ensureUpperDir(virtualPath string) error {
+	if virtualPath == fs.getRootVirtualPath() || virtualPath == "/" || virtualPath == "." {
+		return nil
+	}
+	if fs.existsInUpper(virtualPath) {
+		return nil
+	}
+	if err := fs.ensureUpperDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	fsPath, err := fs.upper.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	err = fs.upper.Mkdir(fsPath)
+	fsLog(fs, logger.LevelDebug, "parent directory %#v created inside the upper layer, err: %v", fsPath, err)
+	return err
+}
+
+func (fs *OverlayFs) createMarker(virtualPath string) error {
+	fsPath, err := fs.upper.ResolvePath(virtualPath)
+	if err != nil {
+		return err
+	}
+	f, w, cancelFn, err := fs.upper.Create(fsPath, 0)
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		return f.Close()
+	}
+	err = w.Close()
+	if err != nil && cancelFn != nil {
+		cancelFn()
+	}
+	return err
+}
+
+func (fs *OverlayFs) createWhiteout(virtualPath string) error {
+	if err := fs.ensureUpperDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	err := fs.createMarker(path.Join(path.Dir(virtualPath), overlayWhiteoutPrefix+path.Base(virtualPath)))
+	fsLog(fs, logger.LevelDebug, "whiteout created for %#v, err: %v", virtualPath, err)
+	return err
+}
+
+func (fs *OverlayFs) removeMarkers(dirname string) error {
+	contents, err := fs.upper.ReadDir(dirname)
+	if err != nil {
+		return err
+	}
+	for _, info := range contents {
+		if isOverlayMarker(info.Name()) {
+			if err := fs.upper.Remove(fs.upper.Join(dirname, info.Name()), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fs *OverlayFs) copyUpIfNeeded(name string) error {
+	layer, layerPath, err := fs.resolve(name)
+	if err != nil {
+		return err
+	}
+	if layer == fs.upper {
+		return nil
+	}
+	return fs.copyUp(name, layerPath)
+}
+
+// copyUp copies a file or a directory, without its contents, from the lower
+// layer to the upper one
+func (fs *OverlayFs) copyUp(name, lowerPath string) error {
+	info, err := fs.lower.Lstat(lowerPath)
+	if err != nil {
+		return err
+	}
+	virtualPath := fs.upper.GetRelativePath(name)
+	if info.IsDir() {
+		return fs.ensureUpperDir(virtualPath)
+	}
+	if !info.Mode().IsRegular() {
+		fsLog(fs, logger.LevelDebug, "unable to copy %#v to the upper layer, unsupported mode %v", lowerPath, info.Mode())
+		return ErrVfsUnsupported
+	}
+	if err := fs.ensureUpperDir(path.Dir(virtualPath)); err != nil {
+		return err
+	}
+	if err := fs.copyFileToUpper(lowerPath, name); err != nil {
+		fsLog(fs, logger.LevelError, "unable to copy %#v to the upper layer: %v", lowerPath, err)
+		return err
+	}
+	if err := fs.upper.Chtimes(name, info.ModTime(), info.ModTime(), false); err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to preserve modification time for %#v: %v", name, err)
+	}
+	fsLog(fs, logger.LevelDebug, "file %#v copied to the upper layer, size: %v", lowerPath, info.Size())
+	return nil
+}
+
+func (fs *OverlayFs) copyFileToUpper(lowerPath, name string) error {
+	f, r, cancelRead, err := fs.lower.Open(lowerPath, 0)
+	if err != nil {
+		return err
+	}
+	var reader io.ReadCloser
+	if f != nil {
+		reader = f
+	} else {
+		reader = r
+	}
+	defer reader.Close()
+
+	w, pw, cancelWrite, err := fs.upper.Create(name, 0)
+	if err != nil {
+		if cancelRead != nil {
+			cancelRead()
+		}
+		return err
+	}
+	if w != nil {
+		_, err = io.Copy(w, reader)
+		errClose := w.Close()
+		if err == nil {
+			err = errClose
+		}
+	} else {
+		_, err = io.Copy(pw, reader)
+		if err != nil && cancelWrite != nil {
+			cancelWrite()
+		}
+		errClose := pw.Close()
+		if err == nil {
+			err = errClose
+		}
+	}
+	if err != nil && cancelRead != nil {
+		cancelRead()
+	}
+	return err
+}
+
+// overlayLowerFileInfo describes a file existing only inside the lower layer.
+// Quota is only tracked for the upper layer, so these files are not included
+type overlayLowerFileInfo struct {
+	os.FileInfo
+}
+
+// QuotaSize implements the interface used by GetQuotaSize
+func (*overlayLowerFileInfo) QuotaSize() int64 {
+	return 0
+}
+
+// QuotaTracked implements the interface used by IsQuotaTracked
+func (*overlayLowerFileInfo) QuotaTracked() bool {
+	return false
+}
+
+// Checksum implements the interface used by GetChecksum
+func (fi *overlayLowerFileInfo) Checksum() string {
+	return GetChecksum(fi.FileInfo)
+}
+
+// scanOverlayUpperLayer returns the number of files and their size contained
+// inside the specified upper layer, whiteouts and opaque markers are not included
+func scanOverlayUpperLayer(upper Fs, rootVirtualPath string) (int, int64, error) {
+	rootPath, err := upper.ResolvePath(rootVirtualPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return getOverlayUpperDirSize(upper, rootPath)
+}
+
+func getOverlayUpperDirSize(upper Fs, dirname string) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := IsDirectory(upper, dirname)
+	if err == nil && isDir {
+		err = upper.Walk(dirname, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info != nil && info.Mode().IsRegular() && !isOverlayMarker(info.Name()) {
+				size += GetQuotaSize(info)
+				numFiles++
+			}
+			return nil
+		})
+	}
+	return numFiles, size, err
+}
+
+func isOverlayMarker(name string) bool {
+	return strings.HasPrefix(name, overlayWhiteoutPrefix)
+}
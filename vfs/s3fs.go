@@ -147,7 +147,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 		// a "dir" has a trailing "/" so we cannot have a directory here
 		objSize := *obj.ContentLength
 		objectModTime := *obj.LastModified
-		return updateFileInfoModTime(fs.getStorageID(), name, NewFileInfo(name, false, objSize, objectModTime, false))
+		info := NewFileInfo(name, false, objSize, objectModTime, false)
+		info.checksum = strings.Trim(aws.StringValue(obj.ETag), `"`)
+		return updateFileInfoModTime(fs.getStorageID(), name, info)
 	}
 	if !fs.IsNotExist(err) {
 		return result, err
@@ -206,6 +208,7 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 		streamRange = aws.String(fmt.Sprintf("bytes=%v-", offset))
 	}
 
+	op := startFsOperation(fs.connectionID, traceBackendS3, "download", name)
 	go func() {
 		defer cancelFn()
 		n, err := downloader.DownloadWithContext(ctx, w, &s3.GetObjectInput{
@@ -219,6 +222,7 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
 		metric.S3TransferCompleted(n, 1, err)
+		op.endTransfer(n, err)
 	}()
 	return nil, r, cancelFn, nil
 }
@@ -232,6 +236,7 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 	p := NewPipeWriter(w)
 	ctx, cancelFn := context.WithCancel(context.Background())
 	uploader := s3manager.NewUploaderWithClient(fs.svc)
+	op := startFsOperation(fs.connectionID, traceBackendS3, "upload", name)
 	go func() {
 		defer cancelFn()
 		key := name
@@ -257,6 +262,7 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, acl: %#v, response: %v, readed bytes: %v, err: %+v",
 			name, fs.config.ACL, response, r.GetReadedBytes(), err)
 		metric.S3TransferCompleted(r.GetReadedBytes(), 0, err)
+		op.endTransfer(r.GetReadedBytes(), err)
 	}()
 	return nil, p, cancelFn, nil
 }
@@ -301,6 +307,8 @@ func (fs *S3Fs) Rename(source, target string) error {
 	} else {
 		contentType = mime.TypeByExtension(path.Ext(source))
 	}
+	op := startFsOperation(fs.connectionID, traceBackendS3, "copy", source)
+	op.setTarget(target)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	_, err = fs.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
@@ -313,6 +321,7 @@ func (fs *S3Fs) Rename(source, target string) error {
 	})
 	if err != nil {
 		metric.S3CopyObjectCompleted(err)
+		op.end(err)
 		return err
 	}
 	err = fs.svc.WaitUntilObjectExistsWithContext(ctx, &s3.HeadObjectInput{
@@ -320,6 +329,7 @@ func (fs *S3Fs) Rename(source, target string) error {
 		Key:    aws.String(target),
 	})
 	metric.S3CopyObjectCompleted(err)
+	op.end(err)
 	if err != nil {
 		return err
 	}
@@ -350,6 +360,7 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 			name += "/"
 		}
 	}
+	op := startFsOperation(fs.connectionID, traceBackendS3, "delete", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	_, err := fs.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
@@ -357,6 +368,7 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		Key:    aws.String(name),
 	})
 	metric.S3DeleteObjectCompleted(err)
+	op.end(err)
 	if plugin.Handler.HasMetadater() && err == nil && !isDir {
 		if errMetadata := plugin.Handler.RemoveMetadata(fs.getStorageID(), ensureAbsPath(name)); errMetadata != nil {
 			fsLog(fs, logger.LevelWarn, "unable to remove metadata for path %#v: %v", name, errMetadata)
@@ -450,6 +462,7 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 	prefixes := make(map[string]bool)
 
+	op := startFsOperation(fs.connectionID, traceBackendS3, "list", dirname)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	err = fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
@@ -485,11 +498,16 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			if t, ok := modTimes[name]; ok {
 				objectModTime = util.GetTimeFromMsecSinceEpoch(t)
 			}
-			result = append(result, NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false))
+			info := NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false)
+			if !info.IsDir() {
+				info.checksum = strings.Trim(aws.StringValue(fileObject.ETag), `"`)
+			}
+			result = append(result, info)
 		}
 		return true
 	})
 	metric.S3ListObjectsCompleted(err)
+	op.end(err)
 	return result, err
 }
 
@@ -764,6 +782,7 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 }
 
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
+	op := startFsOperation(fs.connectionID, traceBackendS3, "head", name)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	obj, err := fs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
@@ -771,6 +790,7 @@ func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 		Key:    aws.String(name),
 	})
 	metric.S3HeadObjectCompleted(err)
+	op.end(err)
 	return obj, err
 }
 
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eikenb/pipeat"
@@ -29,17 +30,43 @@ import (
 const (
 	// sftpFsName is the name for the SFTP Fs implementation
 	sftpFsName = "sftpfs"
+	// sftpDialTimeout is the timeout for connecting to an SFTP endpoint
+	sftpDialTimeout = 10 * time.Second
 )
 
+// Supported policies to select the endpoint to connect to
+const (
+	// the endpoints are tried in the configured order
+	SFTPEndpointsPolicyFailover = iota
+	// the first endpoint to try is selected in round-robin
+	SFTPEndpointsPolicyRoundRobin
+)
+
+var sftpEndpointsCounter uint32
+
 // ErrSFTPLoop defines the error to return if an SFTP loop is detected
 var ErrSFTPLoop = errors.New("SFTP loop or nested local SFTP folders detected")
 
 // SFTPFsConfig defines the configuration for SFTP based filesystem
 type SFTPFsConfig struct {
 	sdk.BaseSFTPFsConfig
-	Password               *kms.Secret `json:"password,omitempty"`
-	PrivateKey             *kms.Secret `json:"private_key,omitempty"`
-	forbiddenSelfUsernames []string    `json:"-"`
+	Password   *kms.Secret `json:"password,omitempty"`
+	PrivateKey *kms.Secret `json:"private_key,omitempty"`
+	// Additional endpoints, as host:port, to use if the main endpoint is not available
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Policy used to select the endpoint:
+	// 0 failover, the endpoints are tried in the configured order
+	// 1 round-robin, the first endpoint to try is rotated for each new connection
+	EndpointsPolicy int `json:"endpoints_policy,omitempty"`
+	// Maximum number of SSH connections, shared among the sessions using this
+	// configuration. Each session opens its own SFTP channel on a pooled
+	// connection. 0 means no pooling: a new SSH connection is opened for each session
+	MaxConnections int `json:"max_connections,omitempty"`
+	// Maximum number of SFTP sessions for each pooled SSH connection.
+	// If all the pooled connections reach this limit new sessions wait for a free one.
+	// 0 means the default: 10
+	MaxSessionsPerConnection int      `json:"max_sessions_per_connection,omitempty"`
+	forbiddenSelfUsernames   []string `json:"-"`
 }
 
 // HideConfidentialData hides confidential data
@@ -68,6 +95,20 @@ func (c *SFTPFsConfig) isEqual(other *SFTPFsConfig) bool {
 	if c.BufferSize != other.BufferSize {
 		return false
 	}
+	if c.EndpointsPolicy != other.EndpointsPolicy || c.MaxConnections != other.MaxConnections {
+		return false
+	}
+	if c.MaxSessionsPerConnection != other.MaxSessionsPerConnection {
+		return false
+	}
+	if len(c.Endpoints) != len(other.Endpoints) {
+		return false
+	}
+	for idx := range c.Endpoints {
+		if c.Endpoints[idx] != other.Endpoints[idx] {
+			return false
+		}
+	}
 	if len(c.Fingerprints) != len(other.Fingerprints) {
 		return false
 	}
@@ -103,6 +144,9 @@ func (c *SFTPFsConfig) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid endpoint: %v", err)
 	}
+	if err := c.validateEndpoints(); err != nil {
+		return err
+	}
 	if c.Username == "" {
 		return errors.New("username cannot be empty")
 	}
@@ -120,6 +164,45 @@ func (c *SFTPFsConfig) Validate() error {
 	return nil
 }
 
+func (c *SFTPFsConfig) validateEndpoints() error {
+	var endpoints []string
+	for _, endpoint := range c.Endpoints {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" || endpoint == c.Endpoint || util.IsStringInSlice(endpoint, endpoints) {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint %#v: %v", endpoint, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	c.Endpoints = endpoints
+	if c.EndpointsPolicy != SFTPEndpointsPolicyFailover && c.EndpointsPolicy != SFTPEndpointsPolicyRoundRobin {
+		return fmt.Errorf("invalid endpoints policy: %v", c.EndpointsPolicy)
+	}
+	if c.MaxConnections < 0 {
+		return errors.New("invalid max_connections, it cannot be negative")
+	}
+	if c.MaxSessionsPerConnection < 0 {
+		return errors.New("invalid max_sessions_per_connection, it cannot be negative")
+	}
+	return nil
+}
+
+func (c *SFTPFsConfig) getMaxSessionsPerConnection() int {
+	if c.MaxSessionsPerConnection > 0 {
+		return c.MaxSessionsPerConnection
+	}
+	return sftpDefaultMaxSessionsPerConn
+}
+
+// getEndpoints returns the configured endpoints, the main one first
+func (c *SFTPFsConfig) getEndpoints() []string {
+	endpoints := make([]string, 0, len(c.Endpoints)+1)
+	endpoints = append(endpoints, c.Endpoint)
+	return append(endpoints, c.Endpoints...)
+}
+
 func (c *SFTPFsConfig) validateCredentials() error {
 	if c.Password.IsEmpty() && c.PrivateKey.IsEmpty() {
 		return errors.New("credentials cannot be empty")
@@ -166,7 +249,9 @@ type SFTPFs struct {
 	config       *SFTPFsConfig
 	sshClient    *ssh.Client
 	sftpClient   *sftp.Client
-	err          chan error
+	// not nil if the SFTP session is opened on a pooled connection
+	lease *sftpPoolLease
+	err   chan error
 }
 
 // NewSFTPFs returns an SFTPFs object that allows to interact with an SFTP server
@@ -208,6 +293,10 @@ func (fs *SFTPFs) Name() string {
 	return fmt.Sprintf("%v %#v", sftpFsName, fs.config.Endpoint)
 }
 
+func (fs *SFTPFs) getStorageID() string {
+	return fmt.Sprintf("sftp://%v@%v", fs.config.Username, fs.config.Endpoint)
+}
+
 // ConnectionID returns the connection ID associated to this Fs implementation
 func (fs *SFTPFs) ConnectionID() string {
 	return fs.connectionID
@@ -234,7 +323,9 @@ func (fs *SFTPFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, f
 	if err := fs.checkConnection(); err != nil {
 		return nil, nil, nil, err
 	}
+	op := startFsOperation(fs.connectionID, traceBackendSFTP, "open", name)
 	f, err := fs.sftpClient.Open(name)
+	op.end(err)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -253,6 +344,7 @@ func (fs *SFTPFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, f
 		f.Close()
 		return nil, nil, nil, err
 	}
+	op = startFsOperation(fs.connectionID, traceBackendSFTP, "download", name)
 	go func() {
 		// if we enable buffering the client stalls
 		//br := bufio.NewReaderSize(f, int(fs.config.BufferSize)*1024*1024)
@@ -261,6 +353,7 @@ func (fs *SFTPFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, f
 		w.CloseWithError(err) //nolint:errcheck
 		f.Close()
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+		op.endTransfer(n, err)
 	}()
 
 	return nil, r, nil, nil
@@ -272,6 +365,7 @@ func (fs *SFTPFs) Create(name string, flag int) (File, *PipeWriter, func(), erro
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	op := startFsOperation(fs.connectionID, traceBackendSFTP, "create", name)
 	if fs.config.BufferSize == 0 {
 		var f File
 		if flag == 0 {
@@ -279,10 +373,12 @@ func (fs *SFTPFs) Create(name string, flag int) (File, *PipeWriter, func(), erro
 		} else {
 			f, err = fs.sftpClient.OpenFile(name, flag)
 		}
+		op.end(err)
 		return f, nil, nil, err
 	}
 	// buffering is enabled
 	f, err := fs.sftpClient.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	op.end(err)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -293,6 +389,7 @@ func (fs *SFTPFs) Create(name string, flag int) (File, *PipeWriter, func(), erro
 	}
 	p := NewPipeWriter(w)
 
+	op = startFsOperation(fs.connectionID, traceBackendSFTP, "upload", name)
 	go func() {
 		bw := bufio.NewWriterSize(f, int(fs.config.BufferSize)*1024*1024)
 		// we don't use io.Copy since bufio.Writer implements io.WriterTo and
@@ -314,6 +411,7 @@ func (fs *SFTPFs) Create(name string, flag int) (File, *PipeWriter, func(), erro
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, err: %v err truncate: %v",
 			name, n, err, errTruncate)
+		op.endTransfer(n, err)
 	}()
 
 	return nil, p, nil, nil
@@ -361,6 +459,17 @@ func (fs *SFTPFs) Symlink(source, target string) error {
 	return fs.sftpClient.Symlink(source, target)
 }
 
+// Link creates target as a hard link to the source file
+func (fs *SFTPFs) Link(source, target string) error {
+	if err := fs.checkConnection(); err != nil {
+		return err
+	}
+	if _, ok := fs.sftpClient.HasExtension("hardlink@openssh.com"); !ok {
+		return ErrVfsUnsupported
+	}
+	return fs.sftpClient.Link(source, target)
+}
+
 // Readlink returns the destination of the named symbolic link
 func (fs *SFTPFs) Readlink(name string) (string, error) {
 	if err := fs.checkConnection(); err != nil {
@@ -669,6 +778,10 @@ func (fs *SFTPFs) Close() error {
 	if fs.sftpClient != nil {
 		sftpErr = fs.sftpClient.Close()
 	}
+	if fs.lease != nil {
+		fs.lease.release()
+		return sftpErr
+	}
 	if fs.sshClient != nil {
 		sshErr = fs.sshClient.Close()
 	}
@@ -723,6 +836,48 @@ func (fs *SFTPFs) createConnection() error {
 	defer fs.Unlock()
 
 	var err error
+	if fs.config.MaxConnections > 0 {
+		fs.lease, err = sftpPools.acquire(fs)
+		if err != nil {
+			fs.err <- err
+			return err
+		}
+		fs.sshClient = fs.lease.conn.client
+		fs.sftpClient, err = fs.lease.newSFTPClient()
+		if err != nil {
+			fs.lease.release()
+			fs.err <- err
+			return err
+		}
+	} else {
+		fs.lease = nil
+		fs.sshClient, _, err = fs.dial()
+		if err != nil {
+			fs.err <- err
+			return err
+		}
+		fs.sftpClient, err = sftp.NewClient(fs.sshClient)
+		if err != nil {
+			fs.sshClient.Close()
+			fs.err <- err
+			return err
+		}
+	}
+	if fs.config.DisableCouncurrentReads {
+		fsLog(fs, logger.LevelDebug, "disabling concurrent reads")
+		opt := sftp.UseConcurrentReads(false)
+		opt(fs.sftpClient) //nolint:errcheck
+	}
+	if fs.config.BufferSize > 0 {
+		fsLog(fs, logger.LevelDebug, "enabling concurrent writes")
+		opt := sftp.UseConcurrentWrites(true)
+		opt(fs.sftpClient) //nolint:errcheck
+	}
+	go fs.wait(fs.sftpClient, fs.sshClient, fs.lease)
+	return nil
+}
+
+func (fs *SFTPFs) getClientConfig() (*ssh.ClientConfig, error) {
 	clientConfig := &ssh.ClientConfig{
 		User: fs.config.Username,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
@@ -746,55 +901,71 @@ func (fs *SFTPFs) createConnection() error {
 			return nil
 		},
 		ClientVersion: fmt.Sprintf("SSH-2.0-SFTPGo_%v", version.Get().Version),
+		Timeout:       sftpDialTimeout,
 	}
 	if fs.config.PrivateKey.GetPayload() != "" {
 		signer, err := ssh.ParsePrivateKey([]byte(fs.config.PrivateKey.GetPayload()))
 		if err != nil {
-			fs.err <- err
-			return err
+			return nil, err
 		}
 		clientConfig.Auth = append(clientConfig.Auth, ssh.PublicKeys(signer))
 	}
 	if fs.config.Password.GetPayload() != "" {
 		clientConfig.Auth = append(clientConfig.Auth, ssh.Password(fs.config.Password.GetPayload()))
 	}
-	fs.sshClient, err = ssh.Dial("tcp", fs.config.Endpoint, clientConfig)
-	if err != nil {
-		fs.err <- err
-		return err
-	}
-	fs.sftpClient, err = sftp.NewClient(fs.sshClient)
+	return clientConfig, nil
+}
+
+// dial connects to the configured endpoints, according to the configured policy,
+// and returns the first successful connection and the related endpoint
+func (fs *SFTPFs) dial() (*ssh.Client, string, error) {
+	clientConfig, err := fs.getClientConfig()
 	if err != nil {
-		fs.sshClient.Close()
-		fs.err <- err
-		return err
-	}
-	if fs.config.DisableCouncurrentReads {
-		fsLog(fs, logger.LevelDebug, "disabling concurrent reads")
-		opt := sftp.UseConcurrentReads(false)
-		opt(fs.sftpClient) //nolint:errcheck
-	}
-	if fs.config.BufferSize > 0 {
-		fsLog(fs, logger.LevelDebug, "enabling concurrent writes")
-		opt := sftp.UseConcurrentWrites(true)
-		opt(fs.sftpClient) //nolint:errcheck
+		return nil, "", err
+	}
+	endpoints := fs.config.getEndpoints()
+	if fs.config.EndpointsPolicy == SFTPEndpointsPolicyRoundRobin && len(endpoints) > 1 {
+		start := int(atomic.AddUint32(&sftpEndpointsCounter, 1) % uint32(len(endpoints)))
+		rotated := make([]string, 0, len(endpoints))
+		rotated = append(rotated, endpoints[start:]...)
+		endpoints = append(rotated, endpoints[:start]...)
+	}
+	for _, endpoint := range endpoints {
+		var client *ssh.Client
+		client, err = ssh.Dial("tcp", endpoint, clientConfig)
+		var netErr net.Error
+		if err == nil || errors.As(err, &netErr) {
+			// authentication and host key errors depend on the user configuration
+			// and not on the endpoint health
+			sftpPools.updateHealth(endpoint, err)
+		}
+		if err == nil {
+			return client, endpoint, nil
+		}
+		if errors.Is(err, ErrSFTPLoop) {
+			return nil, "", err
+		}
+		fsLog(fs, logger.LevelWarn, "unable to connect to endpoint %#v: %v", endpoint, err)
 	}
-	go fs.wait()
-	return nil
+	return nil, "", err
 }
 
-func (fs *SFTPFs) wait() {
+func (fs *SFTPFs) wait(sftpClient *sftp.Client, sshClient *ssh.Client, lease *sftpPoolLease) {
 	// we wait on the sftp client otherwise if the channel is closed but not the connection
 	// we don't detect the event.
-	fs.err <- fs.sftpClient.Wait()
+	fs.err <- sftpClient.Wait()
 	fsLog(fs, logger.LevelDebug, "sftp channel closed")
 
+	if lease != nil {
+		// the SSH connection is shared, we only release our session
+		lease.release()
+		return
+	}
+
 	fs.Lock()
 	defer fs.Unlock()
 
-	if fs.sshClient != nil {
-		fs.sshClient.Close()
-	}
+	sshClient.Close()
 }
 
 func (fs *SFTPFs) closed() error {
@@ -0,0 +1,446 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+)
+
+const (
+	sftpKeepAliveInterval = 30 * time.Second
+	sftpPoolIdleTimeout   = 5 * time.Minute
+	sftpHealthRetention   = 1 * time.Hour
+	// OpenSSH allows 10 sessions per connection by default, see MaxSessions in sshd_config
+	sftpDefaultMaxSessionsPerConn = 10
+	sftpPoolWaitTimeout           = 30 * time.Second
+)
+
+var (
+	errSFTPPoolClosed  = errors.New("the SFTP connection pool is closed")
+	errSFTPPoolTimeout = errors.New("timeout waiting for a free session in the SFTP connection pool")
+	sftpPools          = &sftpPoolsManager{
+		pools:     make(map[string]*sftpPool),
+		endpoints: make(map[string]*sftpEndpointHealth),
+	}
+)
+
+// SFTPEndpointStatus defines the health status for an SFTP backend endpoint
+type SFTPEndpointStatus struct {
+	Endpoint  string `json:"endpoint"`
+	IsHealthy bool   `json:"is_healthy"`
+	Error     string `json:"error,omitempty"`
+	// last check as unix timestamp in milliseconds
+	LastCheck int64 `json:"last_check"`
+	// number of pooled SSH connections to this endpoint
+	Connections int `json:"connections"`
+	// number of SFTP sessions using the pooled connections
+	Sessions int `json:"sessions"`
+}
+
+// GetSFTPEndpointsStatus returns the health status for the SFTP backend endpoints
+// used since the last hour or having pooled connections
+func GetSFTPEndpointsStatus() []SFTPEndpointStatus {
+	return sftpPools.getStatus()
+}
+
+// CloseSFTPConnectionPools closes all the pooled SFTP backend connections.
+// The sessions using them will reconnect on the next operation
+func CloseSFTPConnectionPools() {
+	sftpPools.Lock()
+	pools := sftpPools.pools
+	sftpPools.pools = make(map[string]*sftpPool)
+	sftpPools.Unlock()
+
+	for _, pool := range pools {
+		pool.close()
+	}
+}
+
+type sftpEndpointHealth struct {
+	err       string
+	lastCheck time.Time
+}
+
+type sftpPoolsManager struct {
+	sync.Mutex
+	pools     map[string]*sftpPool
+	endpoints map[string]*sftpEndpointHealth
+}
+
+func (m *sftpPoolsManager) updateHealth(endpoint string, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	health := &sftpEndpointHealth{
+		lastCheck: time.Now(),
+	}
+	if err != nil {
+		health.err = err.Error()
+	}
+	m.endpoints[endpoint] = health
+}
+
+// acquire returns a pooled connection for the given SFTPFs creating the pool if needed
+func (m *sftpPoolsManager) acquire(fs *SFTPFs) (*sftpPoolLease, error) {
+	key := fs.config.getPoolKey()
+	for {
+		m.Lock()
+		pool, ok := m.pools[key]
+		if !ok {
+			pool = &sftpPool{
+				key:         key,
+				maxConns:    fs.config.MaxConnections,
+				maxSessions: fs.config.getMaxSessionsPerConnection(),
+			}
+			m.pools[key] = pool
+			go pool.keepAlive()
+		}
+		m.Unlock()
+
+		lease, err := pool.acquire(fs)
+		if errors.Is(err, errSFTPPoolClosed) {
+			m.removePool(pool)
+			continue
+		}
+		return lease, err
+	}
+}
+
+func (m *sftpPoolsManager) removePool(pool *sftpPool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if p, ok := m.pools[pool.key]; ok && p == pool {
+		delete(m.pools, pool.key)
+	}
+}
+
+func (m *sftpPoolsManager) getStatus() []SFTPEndpointStatus {
+	m.Lock()
+	pools := make([]*sftpPool, 0, len(m.pools))
+	for _, pool := range m.pools {
+		pools = append(pools, pool)
+	}
+	m.Unlock()
+
+	status := make(map[string]*SFTPEndpointStatus)
+	for _, pool := range pools {
+		pool.Lock()
+		for _, conn := range pool.conns {
+			s, ok := status[conn.endpoint]
+			if !ok {
+				s = &SFTPEndpointStatus{
+					Endpoint: conn.endpoint,
+				}
+				status[conn.endpoint] = s
+			}
+			s.Connections++
+			s.Sessions += conn.sessions
+		}
+		pool.Unlock()
+	}
+
+	m.Lock()
+	for endpoint, health := range m.endpoints {
+		s, ok := status[endpoint]
+		if !ok {
+			if time.Since(health.lastCheck) > sftpHealthRetention {
+				delete(m.endpoints, endpoint)
+				continue
+			}
+			s = &SFTPEndpointStatus{
+				Endpoint: endpoint,
+			}
+			status[endpoint] = s
+		}
+		s.Error = health.err
+		s.LastCheck = util.GetTimeAsMsSinceEpoch(health.lastCheck)
+	}
+	m.Unlock()
+
+	result := make([]SFTPEndpointStatus, 0, len(status))
+	for _, s := range status {
+		s.IsHealthy = s.Error == ""
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Endpoint < result[j].Endpoint
+	})
+	return result
+}
+
+type sftpPoolConn struct {
+	client   *ssh.Client
+	endpoint string
+	sessions int
+	lastUsed time.Time
+}
+
+// sftpPool is a bounded pool of SSH connections shared among the
+// SFTPFs instances with the same configuration.
+// Each SFTPFs opens its own SFTP session on a pooled connection, up to
+// maxSessions for each connection
+type sftpPool struct {
+	sync.Mutex
+	key         string
+	maxConns    int
+	maxSessions int
+	conns       []*sftpPoolConn
+	closed      bool
+	// closed and set to nil when a session or a connection is released
+	available chan struct{}
+}
+
+func (p *sftpPool) acquire(fs *SFTPFs) (*sftpPoolLease, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	deadline := time.Now().Add(sftpPoolWaitTimeout)
+	for {
+		if p.closed {
+			return nil, errSFTPPoolClosed
+		}
+		conn, err := p.getConnection(fs)
+		if err != nil {
+			return nil, err
+		}
+		if conn != nil {
+			conn.sessions++
+			conn.lastUsed = time.Now()
+
+			return &sftpPoolLease{
+				pool: p,
+				conn: conn,
+			}, nil
+		}
+		fsLog(fs, logger.LevelDebug, "all the pooled connections have %v sessions, waiting for a free one", p.maxSessions)
+		if err := p.waitAvailable(deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getConnection returns the pooled connection with the fewest sessions, a new
+// connection is added if all the existing ones are in use and the pool is not full.
+// It returns nil if all the pooled connections have the maximum allowed sessions
+func (p *sftpPool) getConnection(fs *SFTPFs) (*sftpPoolConn, error) {
+	var conn *sftpPoolConn
+	for _, c := range p.conns {
+		if c.sessions >= p.maxSessions {
+			continue
+		}
+		if conn == nil || c.sessions < conn.sessions {
+			conn = c
+		}
+	}
+	if (conn == nil || conn.sessions > 0) && len(p.conns) < p.maxConns {
+		client, endpoint, err := fs.dial()
+		if err != nil {
+			if len(p.conns) == 0 {
+				return nil, err
+			}
+			fsLog(fs, logger.LevelWarn, "unable to add a new connection to the pool, reusing an existing one: %v", err)
+			return conn, nil
+		}
+		conn = &sftpPoolConn{
+			client:   client,
+			endpoint: endpoint,
+		}
+		p.conns = append(p.conns, conn)
+		go p.wait(conn)
+		fsLog(fs, logger.LevelDebug, "new pooled connection to endpoint %#v, pooled connections: %v",
+			endpoint, len(p.conns))
+	}
+	return conn, nil
+}
+
+// waitAvailable waits, with the pool locked, for a session or a connection to be released
+func (p *sftpPool) waitAvailable(deadline time.Time) error {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return errSFTPPoolTimeout
+	}
+	if p.available == nil {
+		p.available = make(chan struct{})
+	}
+	available := p.available
+	p.Unlock()
+	defer p.Lock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-available:
+		return nil
+	case <-timer.C:
+		return errSFTPPoolTimeout
+	}
+}
+
+// notifyAvailable wakes up the sessions waiting for a pooled connection,
+// the pool must be locked
+func (p *sftpPool) notifyAvailable() {
+	if p.available != nil {
+		close(p.available)
+		p.available = nil
+	}
+}
+
+func (p *sftpPool) release(conn *sftpPoolConn) {
+	p.Lock()
+	defer p.Unlock()
+
+	conn.sessions--
+	conn.lastUsed = time.Now()
+	p.notifyAvailable()
+}
+
+// wait removes the connection from the pool when it is closed
+func (p *sftpPool) wait(conn *sftpPoolConn) {
+	err := conn.client.Wait()
+	logger.Debug(sftpFsName, "", "pooled connection to endpoint %#v closed, err: %v", conn.endpoint, err)
+
+	p.Lock()
+	defer p.Unlock()
+
+	conns := make([]*sftpPoolConn, 0, len(p.conns))
+	for _, c := range p.conns {
+		if c != conn {
+			conns = append(conns, c)
+		}
+	}
+	p.conns = conns
+	p.notifyAvailable()
+}
+
+func (p *sftpPool) close() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.closed = true
+	for _, conn := range p.conns {
+		conn.client.Close()
+	}
+	p.conns = nil
+	p.notifyAvailable()
+}
+
+func (p *sftpPool) keepAlive() {
+	ticker := time.NewTicker(sftpKeepAliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if p.checkConnections() {
+			sftpPools.removePool(p)
+			return
+		}
+	}
+}
+
+// checkConnections sends a keep alive request on the pooled connections and
+// closes the idle ones. It returns true if the pool is empty and has been closed
+func (p *sftpPool) checkConnections() bool {
+	var toCheck []*sftpPoolConn
+
+	p.Lock()
+	for _, conn := range p.conns {
+		if conn.sessions <= 0 && time.Since(conn.lastUsed) > sftpPoolIdleTimeout {
+			logger.Debug(sftpFsName, "", "closing idle pooled connection to endpoint %#v", conn.endpoint)
+			conn.client.Close()
+			continue
+		}
+		toCheck = append(toCheck, conn)
+	}
+	p.conns = toCheck
+	if len(toCheck) == 0 {
+		p.closed = true
+		p.notifyAvailable()
+	}
+	p.Unlock()
+
+	for _, conn := range toCheck {
+		_, _, err := conn.client.SendRequest("keepalive@openssh.com", true, nil)
+		sftpPools.updateHealth(conn.endpoint, err)
+		if err != nil {
+			logger.Warn(sftpFsName, "", "keep alive failed for pooled connection to endpoint %#v: %v", conn.endpoint, err)
+			conn.client.Close()
+		}
+	}
+	return len(toCheck) == 0
+}
+
+// sftpPoolLease is an SFTP session on a pooled connection
+type sftpPoolLease struct {
+	pool    *sftpPool
+	conn    *sftpPoolConn
+	session *ssh.Session
+	once    sync.Once
+}
+
+// newSFTPClient opens a new SFTP session on the pooled connection
+func (l *sftpPoolLease) newSFTPClient() (*sftp.Client, error) {
+	session, err := l.conn.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	l.session = session
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+	pw, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	pr, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	return sftp.NewClientPipe(pr, pw)
+}
+
+func (l *sftpPoolLease) release() {
+	l.once.Do(func() {
+		if l.session != nil {
+			l.session.Close()
+		}
+		l.pool.release(l.conn)
+	})
+}
+
+func (c *SFTPFsConfig) getPoolKey() string {
+	var sb strings.Builder
+
+	sb.WriteString(c.Username)
+	sb.WriteString(fmt.Sprintf("|%d|%d|%d", c.EndpointsPolicy, c.MaxConnections, c.getMaxSessionsPerConnection()))
+	for _, endpoint := range c.getEndpoints() {
+		sb.WriteString("|")
+		sb.WriteString(endpoint)
+	}
+	for _, fp := range c.Fingerprints {
+		sb.WriteString("|")
+		sb.WriteString(fp)
+	}
+	for _, username := range c.forbiddenSelfUsernames {
+		sb.WriteString("|")
+		sb.WriteString(username)
+	}
+	sb.WriteString("|")
+	sb.WriteString(c.Password.GetPayload())
+	sb.WriteString("|")
+	sb.WriteString(c.PrivateKey.GetPayload())
+
+	h := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,65 @@
+package vfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSFTPPoolMaxSessions(t *testing.T) {
+	config := &SFTPFsConfig{}
+	assert.Equal(t, sftpDefaultMaxSessionsPerConn, config.getMaxSessionsPerConnection())
+	config.MaxSessionsPerConnection = 2
+	assert.Equal(t, 2, config.getMaxSessionsPerConnection())
+
+	fs := &SFTPFs{
+		connectionID: "id",
+		config:       config,
+	}
+	// the pool is full, so no new connection will be dialed
+	pool := &sftpPool{
+		maxConns:    1,
+		maxSessions: 2,
+		conns:       []*sftpPoolConn{{endpoint: "127.0.0.1:22"}},
+	}
+	lease1, err := pool.acquire(fs)
+	require.NoError(t, err)
+	lease2, err := pool.acquire(fs)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pool.conns[0].sessions)
+
+	acquired := make(chan *sftpPoolLease)
+	go func() {
+		lease, err := pool.acquire(fs)
+		assert.NoError(t, err)
+		acquired <- lease
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the session limit for the pooled connection was not respected")
+	case <-time.After(200 * time.Millisecond):
+	}
+	lease1.release()
+	lease3 := <-acquired
+	if assert.NotNil(t, lease3) {
+		assert.Equal(t, 2, pool.conns[0].sessions)
+		lease3.release()
+	}
+	lease2.release()
+	assert.Equal(t, 0, pool.conns[0].sessions)
+
+	pool.conns[0].sessions = 2
+	go func() {
+		_, err := pool.acquire(fs)
+		assert.ErrorIs(t, err, errSFTPPoolClosed)
+		acquired <- nil
+	}()
+	time.Sleep(100 * time.Millisecond)
+	pool.Lock()
+	pool.closed = true
+	pool.notifyAvailable()
+	pool.Unlock()
+	assert.Nil(t, <-acquired)
+}
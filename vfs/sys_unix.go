@@ -5,6 +5,8 @@ package vfs
 
 import (
 	"errors"
+	"os"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -12,3 +14,25 @@ import (
 func isCrossDeviceError(err error) bool {
 	return errors.Is(err, unix.EXDEV)
 }
+
+// getFileLinks returns the number of hard links for the given file info.
+// 0 is returned if the number of links cannot be determined
+func getFileLinks(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 0
+}
+
+// getFileOwner returns the numeric user and group ids that own the given file info.
+// The last return value is false if they cannot be determined
+func getFileOwner(info os.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
+
+func isDedupSupported() bool {
+	return true
+}
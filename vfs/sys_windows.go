@@ -2,6 +2,7 @@ package vfs
 
 import (
 	"errors"
+	"os"
 
 	"golang.org/x/sys/windows"
 )
@@ -9,3 +10,19 @@ import (
 func isCrossDeviceError(err error) bool {
 	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
 }
+
+// getFileLinks returns the number of hard links for the given file info.
+// Not implemented on Windows
+func getFileLinks(info os.FileInfo) uint64 {
+	return 0
+}
+
+// getFileOwner returns the numeric user and group ids that own the given file info.
+// Not implemented on Windows
+func getFileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+func isDedupSupported() bool {
+	return false
+}
@@ -0,0 +1,54 @@
+package vfs
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/v2/metric"
+	"github.com/drakkan/sftpgo/v2/tracing"
+)
+
+// backend names reported in spans and metrics
+const (
+	traceBackendS3     = "s3"
+	traceBackendGCS    = "gcs"
+	traceBackendAzBlob = "azblob"
+	traceBackendSFTP   = "sftp"
+)
+
+// fsOperation tracks a storage backend request, it reports a span as child
+// of the span of the connection that owns the filesystem, if any, and the
+// request latency
+type fsOperation struct {
+	span      *tracing.Span
+	backend   string
+	operation string
+	start     time.Time
+}
+
+func startFsOperation(connectionID, backend, operation, name string) *fsOperation {
+	_, span := tracing.StartSpan(tracing.GetConnectionContext(connectionID), backend+" "+operation,
+		tracing.String("sftpgo.fs.backend", backend),
+		tracing.String("sftpgo.fs.path", name))
+	return &fsOperation{
+		span:      span,
+		backend:   backend,
+		operation: operation,
+		start:     time.Now(),
+	}
+}
+
+// setTarget adds the target path for copy and rename operations
+func (o *fsOperation) setTarget(target string) {
+	o.span.SetAttributes(tracing.String("sftpgo.fs.target", target))
+}
+
+func (o *fsOperation) end(err error) {
+	metric.FsRequestCompleted(o.backend, o.operation, time.Since(o.start), err)
+	o.span.End(err)
+}
+
+// endTransfer completes an upload or a download
+func (o *fsOperation) endTransfer(size int64, err error) {
+	o.span.SetAttributes(tracing.Int64("sftpgo.fs.size", size))
+	o.end(err)
+}
@@ -108,6 +108,13 @@ type fsMetadataChecker interface {
 	getFileNamesInPrefix(fsPrefix string) (map[string]bool, error)
 }
 
+// FsLinker is a Fs that implements the Link method.
+// This interface is used to create hard links on the backends supporting them
+type FsLinker interface {
+	Fs
+	Link(source, target string) error
+}
+
 // File defines an interface representing a SFTPGo file
 type File interface {
 	io.Reader
@@ -541,6 +548,57 @@ func (c *CryptFsConfig) Validate() error {
 	return nil
 }
 
+// EncryptionConfig defines the configuration to store encrypted files on
+// cloud and SFTP storage backends. The files are encrypted the same way as
+// for the local encrypted filesystem
+type EncryptionConfig struct {
+	CryptFsConfig
+	// Set to true to encrypt the stored files
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+func (c *EncryptionConfig) isEqual(other *EncryptionConfig) bool {
+	if c.Enabled != other.Enabled {
+		return false
+	}
+	return c.CryptFsConfig.isEqual(&other.CryptFsConfig)
+}
+
+// CompressionConfig defines the configuration to store compressed files
+type CompressionConfig struct {
+	// Set to true to compress the uploaded files using zstd
+	Enabled bool `json:"enabled,omitempty"`
+	// Compression level: 1 fastest, 2 default, 3 better compression, 4 best compression.
+	// 0 means default
+	Level int `json:"level,omitempty"`
+	// Set to true to count the stored, compressed, size for quota. By default
+	// quota is counted using the uncompressed size
+	PhysicalQuota bool `json:"physical_quota,omitempty"`
+}
+
+func (c *CompressionConfig) isEqual(other *CompressionConfig) bool {
+	if c.Enabled != other.Enabled {
+		return false
+	}
+	if c.Level != other.Level {
+		return false
+	}
+	return c.PhysicalQuota == other.PhysicalQuota
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *CompressionConfig) Validate() error {
+	if !c.Enabled {
+		c.Level = 0
+		c.PhysicalQuota = false
+		return nil
+	}
+	if c.Level < 0 || c.Level > 4 {
+		return fmt.Errorf("invalid compression level: %v", c.Level)
+	}
+	return nil
+}
+
 // PipeWriter defines a wrapper for pipeat.PipeWriterAt.
 type PipeWriter struct {
 	writer *pipeat.PipeWriterAt
@@ -581,6 +639,54 @@ func (p *PipeWriter) Write(data []byte) (int, error) {
 	return p.writer.Write(data)
 }
 
+// GetQuotaSize returns the size to use for quota calculation for the specified
+// FileInfo. It differs from the file size for compressed files
+func GetQuotaSize(info os.FileInfo) int64 {
+	if fi, ok := info.(interface{ QuotaSize() int64 }); ok {
+		return fi.QuotaSize()
+	}
+	return info.Size()
+}
+
+// IsQuotaTracked returns false if the specified FileInfo describes a file not
+// included in the quota, for example a file existing only inside the read-only
+// lower layer of an OverlayFs. Changing such a file will add it to the quota
+func IsQuotaTracked(info os.FileInfo) bool {
+	if fi, ok := info.(interface{ QuotaTracked() bool }); ok {
+		return fi.QuotaTracked()
+	}
+	return true
+}
+
+// GetChecksum returns the content checksum reported by the storage backend for
+// the specified FileInfo, for example the S3 ETag. It is empty if not available
+func GetChecksum(info os.FileInfo) string {
+	if fi, ok := info.(interface{ Checksum() string }); ok {
+		return fi.Checksum()
+	}
+	return ""
+}
+
+// GetFileOwner returns the numeric user and group ids that own the file described
+// by the specified FileInfo. The last return value is false if the storage backend
+// does not report them
+func GetFileOwner(info os.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		return int(stat.UID), int(stat.GID), true
+	}
+	return getFileOwner(info)
+}
+
+// GetStorageID returns an identifier for the storage backing the specified Fs.
+// Paths are unique within a storage, so the identifier and a filesystem path
+// can be used to reference data related to a file, for example its WebDAV properties
+func GetStorageID(fs Fs) string {
+	if s, ok := fs.(interface{ getStorageID() string }); ok {
+		return s.getStorageID()
+	}
+	return fs.Name()
+}
+
 // IsDirectory checks if a path exists and is a directory
 func IsDirectory(fs Fs, path string) (bool, error) {
 	fileInfo, err := fs.Stat(path)
@@ -600,6 +706,16 @@ func IsCryptOsFs(fs Fs) bool {
 	return fs.Name() == cryptFsName
 }
 
+// IsCompressedFs returns true if fs is a filesystem with compression support
+func IsCompressedFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), compressFsName)
+}
+
+// IsOverlayFs returns true if fs is an overlay filesystem
+func IsOverlayFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), overlayFsName)
+}
+
 // IsSFTPFs returns true if fs is an SFTP filesystem
 func IsSFTPFs(fs Fs) bool {
 	return strings.HasPrefix(fs.Name(), sftpFsName)
@@ -758,6 +874,47 @@ func fsMetadataCheck(fs fsMetadataChecker, storageID, keyPrefix string) error {
 	}
 }
 
+// readFileRange reads length bytes of the named file starting from offset.
+// The read is stopped after the requested bytes for the backends returning
+// a stream
+func readFileRange(fs Fs, name string, offset, length int64) ([]byte, error) {
+	buf := make([]byte, length)
+	f, r, cancelFn, err := fs.Open(name, offset)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		defer f.Close()
+
+		_, err = f.ReadAt(buf, offset)
+		return buf, err
+	}
+	defer r.Close()
+
+	_, err = io.ReadFull(r, buf)
+	if cancelFn != nil {
+		cancelFn()
+	}
+	return buf, err
+}
+
+// sectionFileReader reads a section of a File and closes the File on Close
+type sectionFileReader struct {
+	*io.SectionReader
+	file File
+}
+
+func newSectionFileReader(f File, offset, n int64) *sectionFileReader {
+	return &sectionFileReader{
+		SectionReader: io.NewSectionReader(f, offset, n),
+		file:          f,
+	}
+}
+
+func (r *sectionFileReader) Close() error {
+	return r.file.Close()
+}
+
 func fsLog(fs Fs, level logger.LogLevel, format string, v ...interface{}) {
 	logger.Log(level, fs.Name(), fs.ConnectionID(), format, v...)
 }
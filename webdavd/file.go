@@ -2,11 +2,16 @@ package webdavd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"sync/atomic"
 	"time"
 
@@ -16,10 +21,14 @@ import (
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
 	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/vfs"
 )
 
-var errTransferAborted = errors.New("transfer aborted")
+var (
+	errTransferAborted   = errors.New("transfer aborted")
+	deadPropertiesLimits DeadPropertiesConfig
+)
 
 type webDavFile struct {
 	*common.BaseTransfer
@@ -79,6 +88,123 @@ func (fi *webDavFileInfo) ContentType(ctx context.Context) (string, error) {
 	return "", webdav.ErrNotImplemented
 }
 
+// ETag implements webdav.ETager interface
+func (fi *webDavFileInfo) ETag(ctx context.Context) (string, error) {
+	return getETag(fi.FileInfo), nil
+}
+
+// getETag returns a strong ETag derived from the file size, the modification
+// time and the checksum reported by the storage backend, if any
+func getETag(info os.FileInfo) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d|%d|%s", info.Size(), info.ModTime().UnixNano(), vfs.GetChecksum(info))
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// DeadProps implements webdav.DeadPropsHolder interface
+func (f *webDavFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props, err := dataprovider.GetWebDAVProperties(vfs.GetStorageID(f.Fs), getPropertiesPath(f.GetFsPath()))
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			f.Connection.Log(logger.LevelWarn, "unable to get dead properties for path %#v: %v", f.GetVirtualPath(), err)
+		}
+		return nil, nil
+	}
+	result := make(map[xml.Name]webdav.Property, len(props.Properties))
+	for _, p := range props.Properties {
+		name := xml.Name{Space: p.Space, Local: p.Local}
+		result[name] = webdav.Property{
+			XMLName:  name,
+			Lang:     p.Lang,
+			InnerXML: []byte(p.InnerXML),
+		}
+	}
+	return result, nil
+}
+
+// Patch implements webdav.DeadPropsHolder interface.
+// Setting dead properties requires the overwrite permission, the properties
+// for new uploads, for example the ones copied by the COPY method, are always allowed
+func (f *webDavFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	if f.GetType() != common.TransferUpload &&
+		!f.Connection.User.HasPerm(dataprovider.PermOverwrite, path.Dir(f.GetVirtualPath())) {
+		pstat.Status = http.StatusForbidden
+		return []webdav.Propstat{pstat}, nil
+	}
+
+	storage := vfs.GetStorageID(f.Fs)
+	fsPath := getPropertiesPath(f.GetFsPath())
+	props, err := dataprovider.GetWebDAVProperties(storage, fsPath)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); !ok {
+			return nil, err
+		}
+		props = dataprovider.WebDAVProperties{
+			Storage: storage,
+			Path:    fsPath,
+		}
+	}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			properties := make([]dataprovider.WebDAVProperty, 0, len(props.Properties)+1)
+			for _, existing := range props.Properties {
+				if existing.Space != p.XMLName.Space || existing.Local != p.XMLName.Local {
+					properties = append(properties, existing)
+				}
+			}
+			if !patch.Remove {
+				properties = append(properties, dataprovider.WebDAVProperty{
+					Space:    p.XMLName.Space,
+					Local:    p.XMLName.Local,
+					Lang:     p.Lang,
+					InnerXML: string(p.InnerXML),
+				})
+			}
+			props.Properties = properties
+		}
+	}
+	if !deadPropertiesLimits.isAllowed(props.Properties) {
+		f.Connection.Log(logger.LevelInfo, "denying dead properties for path %#v, count: %v, limits: %+v",
+			f.GetVirtualPath(), len(props.Properties), deadPropertiesLimits)
+		pstat.Status = http.StatusInsufficientStorage
+		return []webdav.Propstat{pstat}, nil
+	}
+	if err := dataprovider.SetWebDAVProperties(&props); err != nil {
+		f.Connection.Log(logger.LevelError, "unable to save dead properties for path %#v: %v", f.GetVirtualPath(), err)
+		return nil, err
+	}
+	return []webdav.Propstat{pstat}, nil
+}
+
+// isAllowed returns true if the specified dead properties are within the configured limits
+func (c *DeadPropertiesConfig) isAllowed(properties []dataprovider.WebDAVProperty) bool {
+	if c.MaxCount > 0 && len(properties) > c.MaxCount {
+		return false
+	}
+	if c.MaxSize > 0 {
+		size := 0
+		for _, p := range properties {
+			size += len(p.Space) + len(p.Local) + len(p.Lang) + len(p.InnerXML)
+		}
+		if size > c.MaxSize {
+			return false
+		}
+	}
+	return true
+}
+
+// getPropertiesPath returns the path to use to store the dead properties,
+// paths inside a storage always use forward slashes
+func getPropertiesPath(fsPath string) string {
+	return filepath.ToSlash(fsPath)
+}
+
 // Readdir reads directory entries from the handle
 func (f *webDavFile) Readdir(count int) ([]os.FileInfo, error) {
 	if !f.Connection.User.HasPerm(dataprovider.PermListItems, f.GetVirtualPath()) {
@@ -62,7 +62,13 @@ func (c *Connection) Mkdir(ctx context.Context, name string, perm os.FileMode) e
 	c.UpdateLastActivity()
 
 	name = util.CleanPath(name)
-	return c.CreateDir(name)
+	if err := c.CreateDir(name); err != nil {
+		return err
+	}
+	if fs, p, err := c.GetFsAndResolvedPath(name); err == nil {
+		c.clearDeadProperties(fs, p, name)
+	}
+	return nil
 }
 
 // Rename renames a file or a directory
@@ -72,7 +78,11 @@ func (c *Connection) Rename(ctx context.Context, oldName, newName string) error
 	oldName = util.CleanPath(oldName)
 	newName = util.CleanPath(newName)
 
-	return c.BaseConnection.Rename(oldName, newName)
+	if err := c.BaseConnection.Rename(oldName, newName); err != nil {
+		return err
+	}
+	c.renameDeadProperties(oldName, newName)
+	return nil
 }
 
 // Stat returns a FileInfo describing the named file/directory, or an error,
@@ -89,7 +99,16 @@ func (c *Connection) Stat(ctx context.Context, name string) (os.FileInfo, error)
 	if err != nil {
 		return nil, err
 	}
-	return fi, err
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return &webDavFileInfo{
+		FileInfo:    fi,
+		Fs:          fs,
+		virtualPath: name,
+		fsPath:      p,
+	}, nil
 }
 
 // RemoveAll removes path and any children it contains.
@@ -110,9 +129,45 @@ func (c *Connection) RemoveAll(ctx context.Context, name string) error {
 	}
 
 	if fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 {
-		return c.removeDirTree(fs, p, name)
+		err = c.removeDirTree(fs, p, name)
+	} else {
+		err = c.RemoveFile(fs, p, name, fi)
+	}
+	if err == nil {
+		c.clearDeadProperties(fs, p, name)
+	}
+	return err
+}
+
+// clearDeadProperties removes any dead property for a newly created file or
+// directory. Properties could be left behind if the previous file was removed
+// using a different protocol
+func (c *Connection) clearDeadProperties(fs vfs.Fs, fsPath, virtualPath string) {
+	if err := dataprovider.DeleteWebDAVProperties(vfs.GetStorageID(fs), getPropertiesPath(fsPath)); err != nil {
+		c.Log(logger.LevelWarn, "unable to delete dead properties for path %#v: %v", virtualPath, err)
+	}
+}
+
+// renameDeadProperties moves the dead properties after a successful rename.
+// Properties cannot be moved among different storages, in this case they are removed
+func (c *Connection) renameDeadProperties(oldName, newName string) {
+	fsSrc, fsSourcePath, err := c.GetFsAndResolvedPath(oldName)
+	if err != nil {
+		return
+	}
+	fsDst, fsTargetPath, err := c.GetFsAndResolvedPath(newName)
+	if err != nil {
+		return
+	}
+	storage := vfs.GetStorageID(fsSrc)
+	if storage == vfs.GetStorageID(fsDst) {
+		err = dataprovider.RenameWebDAVProperties(storage, getPropertiesPath(fsSourcePath), getPropertiesPath(fsTargetPath))
+	} else {
+		err = dataprovider.DeleteWebDAVProperties(storage, getPropertiesPath(fsSourcePath))
+	}
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to move dead properties from %#v to %#v: %v", oldName, newName, err)
 	}
-	return c.RemoveFile(fs, p, name, fi)
 }
 
 // OpenFile opens the named file with specified flag.
@@ -162,7 +217,7 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 	}
 
 	filePath := fsPath
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		filePath = fs.GetAtomicUploadPath(fsPath)
 	}
 
@@ -189,7 +244,7 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 		return nil, c.GetPermissionDeniedError()
 	}
 
-	return c.handleUploadToExistingFile(fs, fsPath, filePath, stat.Size(), virtualPath)
+	return c.handleUploadToExistingFile(fs, fsPath, filePath, vfs.GetQuotaSize(stat), !vfs.IsQuotaTracked(stat), virtualPath)
 }
 
 func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, requestPath string) (webdav.File, error) {
@@ -209,6 +264,7 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 	}
 
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+	c.clearDeadProperties(fs, resolvedPath, requestPath)
 
 	// we can get an error only for resume
 	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
@@ -219,10 +275,12 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
 
+// isNewFile is true if the existing file is not included in the quota, for
+// example a file existing only inside the lower layer of an overlay folder
 func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePath string, fileSize int64,
-	requestPath string) (webdav.File, error) {
+	isNewFile bool, requestPath string) (webdav.File, error) {
 	var err error
-	quotaResult := c.HasSpace(false, false, requestPath)
+	quotaResult := c.HasSpace(isNewFile, false, requestPath)
 	if !quotaResult.HasSpace {
 		c.Log(logger.LevelInfo, "denying file write due to quota limits")
 		return nil, common.ErrQuotaExceeded
@@ -237,7 +295,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 	// will return false in this case and we deny the upload before
 	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize, fs.IsUploadResumeSupported())
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.GetConfig().IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -269,7 +327,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, filePath, requestPath,
-		common.TransferUpload, 0, initialSize, maxWriteSize, false, fs)
+		common.TransferUpload, 0, initialSize, maxWriteSize, isNewFile, fs)
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -595,13 +596,13 @@ func TestFileAccessErrors(t *testing.T) {
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, os.ErrNotExist.Error())
 	}
-	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, path.Join("adir", missingPath))
+	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, false, path.Join("adir", missingPath))
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, os.ErrNotExist.Error())
 	}
 
 	fs = newMockOsFs(nil, false, fs.ConnectionID(), user.HomeDir, nil)
-	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, path.Join("adir", missingPath))
+	_, err = connection.handleUploadToExistingFile(fs, p, p, 0, false, path.Join("adir", missingPath))
 	if assert.Error(t, err) {
 		assert.EqualError(t, err, os.ErrNotExist.Error())
 	}
@@ -610,7 +611,7 @@ func TestFileAccessErrors(t *testing.T) {
 	assert.NoError(t, err)
 	err = f.Close()
 	assert.NoError(t, err)
-	davFile, err := connection.handleUploadToExistingFile(fs, f.Name(), f.Name(), 123, f.Name())
+	davFile, err := connection.handleUploadToExistingFile(fs, f.Name(), f.Name(), 123, false, f.Name())
 	if assert.NoError(t, err) {
 		transfer := davFile.(*webDavFile)
 		transfers := connection.GetTransfers()
@@ -964,12 +965,12 @@ func TestBasicUsersCache(t *testing.T) {
 
 	ipAddr := "127.0.0.1"
 
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.Error(t, err)
 
 	now := time.Now()
 	req.SetBasicAuth(username, password)
-	_, isCached, _, loginMethod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -979,14 +980,14 @@ func TestBasicUsersCache(t *testing.T) {
 		assert.False(t, cachedUser.IsExpired())
 		assert.True(t, cachedUser.Expiration.After(now.Add(time.Duration(c.Cache.Users.ExpirationTime)*time.Minute)))
 		// authenticate must return the cached user now
-		authUser, isCached, _, _, err := server.authenticate(req, ipAddr)
+		authUser, isCached, _, err := server.authenticate(req, ipAddr)
 		assert.NoError(t, err)
 		assert.True(t, isCached)
 		assert.Equal(t, cachedUser.User, authUser)
 	}
 	// a wrong password must fail
 	req.SetBasicAuth(username, "wrong")
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.EqualError(t, err, dataprovider.ErrInvalidCredentials.Error())
 	req.SetBasicAuth(username, password)
 
@@ -998,7 +999,7 @@ func TestBasicUsersCache(t *testing.T) {
 		assert.True(t, cachedUser.IsExpired())
 	}
 	// now authenticate should get the user from the data provider and update the cache
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1025,7 +1026,7 @@ func TestBasicUsersCache(t *testing.T) {
 	_, ok = dataprovider.GetCachedWebDAVUser(username)
 	assert.False(t, ok)
 
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1095,12 +1096,12 @@ func TestCachedUserWithFolders(t *testing.T) {
 
 	ipAddr := "127.0.0.1"
 
-	_, _, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
+	_, _, _, err = server.authenticate(req, ipAddr) //nolint:dogsled
 	assert.Error(t, err)
 
 	now := time.Now()
 	req.SetBasicAuth(username, password)
-	_, isCached, _, loginMethod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1110,7 +1111,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 		assert.False(t, cachedUser.IsExpired())
 		assert.True(t, cachedUser.Expiration.After(now.Add(time.Duration(c.Cache.Users.ExpirationTime)*time.Minute)))
 		// authenticate must return the cached user now
-		authUser, isCached, _, _, err := server.authenticate(req, ipAddr)
+		authUser, isCached, _, err := server.authenticate(req, ipAddr)
 		assert.NoError(t, err)
 		assert.True(t, isCached)
 		assert.Equal(t, cachedUser.User, authUser)
@@ -1122,7 +1123,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	err = dataprovider.UpdateFolder(&folder, folder.Users, "", "")
 	assert.NoError(t, err)
 
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.True(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1134,7 +1135,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	folder.MappedPath = filepath.Join(os.TempDir(), "anotherpath")
 	err = dataprovider.UpdateFolder(&folder, folder.Users, "", "")
 	assert.NoError(t, err)
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1146,7 +1147,7 @@ func TestCachedUserWithFolders(t *testing.T) {
 	err = dataprovider.DeleteFolder(folderName, "", "")
 	assert.NoError(t, err)
 	// removing a used folder should invalidate the cache
-	_, isCached, _, loginMethod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMethod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMethod)
@@ -1227,7 +1228,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err := server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err := server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1235,7 +1236,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user2.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user2.Username, password+"2")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1243,7 +1244,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user3.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user3.Username, password+"3")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1259,7 +1260,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user4.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user4.Username, password+"4")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1279,7 +1280,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1298,7 +1299,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user2.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user2.Username, password+"2")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1317,7 +1318,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user3.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user3.Username, password+"3")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1340,7 +1341,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user4.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user4.Username, password+"4")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1350,7 +1351,7 @@ func TestUsersCacheSizeAndExpiration(t *testing.T) {
 	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%v", user1.Username), nil)
 	assert.NoError(t, err)
 	req.SetBasicAuth(user1.Username, password+"1")
-	_, isCached, _, loginMehod, err = server.authenticate(req, ipAddr)
+	_, isCached, loginMehod, err = server.authenticate(req, ipAddr)
 	assert.NoError(t, err)
 	assert.False(t, isCached)
 	assert.Equal(t, dataprovider.LoginMethodPassword, loginMehod)
@@ -1399,7 +1400,6 @@ func TestUserCacheIsolation(t *testing.T) {
 		User:       user,
 		Expiration: time.Now().Add(24 * time.Hour),
 		Password:   password,
-		LockSystem: webdav.NewMemLS(),
 	}
 	cachedUser.User.FsConfig.S3Config.AccessSecret = kms.NewPlainSecret("test secret")
 	err = cachedUser.User.FsConfig.S3Config.AccessSecret.Encrypt()
@@ -1467,6 +1467,94 @@ func TestMimeCache(t *testing.T) {
 	assert.Equal(t, "", mtype)
 }
 
+func TestTLSUsernameMapping(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.org/user1")
+	assert.NoError(t, err)
+	tlsCert := &x509.Certificate{
+		Raw:            []byte("raw certificate"),
+		EmailAddresses: []string{"user1@example.org", "user2@example.org"},
+		URIs:           []*url.URL{uri},
+	}
+	tlsCert.Subject.CommonName = "user1"
+	tlsCert.Subject.SerialNumber = "1234"
+
+	user := dataprovider.User{
+		BaseUser: sdk.BaseUser{
+			Username: "user1",
+		},
+	}
+	err = user.CheckTLSCertificate(tlsCert)
+	assert.Error(t, err)
+	user.Filters.TLSUsername = sdk.TLSUsernameCN
+	assert.NoError(t, user.CheckTLSCertificate(tlsCert))
+	user.Username = "user2@example.org"
+	assert.Error(t, user.CheckTLSCertificate(tlsCert))
+	user.Filters.TLSUsername = dataprovider.TLSUsernameSANEmail
+	assert.NoError(t, user.CheckTLSCertificate(tlsCert))
+	user.Filters.TLSUsername = dataprovider.TLSUsernameSANURI
+	assert.Error(t, user.CheckTLSCertificate(tlsCert))
+	user.Username = uri.String()
+	assert.NoError(t, user.CheckTLSCertificate(tlsCert))
+	user.Filters.TLSUsername = dataprovider.TLSUsernameSerialNumber
+	assert.Error(t, user.CheckTLSCertificate(tlsCert))
+	user.Username = "1234"
+	assert.NoError(t, user.CheckTLSCertificate(tlsCert))
+	user.Filters.TLSUsername = dataprovider.TLSUsernameFingerprint
+	assert.Error(t, user.CheckTLSCertificate(tlsCert))
+	user.Filters.TLSCertFingerprints = []string{dataprovider.GetTLSCertificateFingerprint(tlsCert)}
+	assert.NoError(t, user.CheckTLSCertificate(tlsCert))
+
+	assert.Equal(t, "user1", dataprovider.GetUsernameFromTLSCertificate(tlsCert, sdk.TLSUsernameCN))
+	assert.Equal(t, "user1@example.org", dataprovider.GetUsernameFromTLSCertificate(tlsCert,
+		dataprovider.TLSUsernameSANEmail))
+	assert.Equal(t, uri.String(), dataprovider.GetUsernameFromTLSCertificate(tlsCert, dataprovider.TLSUsernameSANURI))
+	assert.Equal(t, "1234", dataprovider.GetUsernameFromTLSCertificate(tlsCert, dataprovider.TLSUsernameSerialNumber))
+	assert.Empty(t, dataprovider.GetUsernameFromTLSCertificate(tlsCert, dataprovider.TLSUsernameFingerprint))
+
+	server := webDavServer{
+		binding: Binding{
+			ClientAuthType: 1,
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(t, err)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{tlsCert},
+	}
+	username, password, loginMethod, crt, ok := server.getCredentialsAndLoginMethod(req)
+	assert.True(t, ok)
+	assert.Equal(t, "user1", username)
+	assert.Empty(t, password)
+	assert.Equal(t, dataprovider.LoginMethodTLSCertificate, loginMethod)
+	assert.Equal(t, tlsCert, crt)
+
+	server.binding.TLSUsername = string(dataprovider.TLSUsernameSANEmail)
+	username, _, loginMethod, _, ok = server.getCredentialsAndLoginMethod(req)
+	assert.True(t, ok)
+	assert.Equal(t, "user1@example.org", username)
+	assert.Equal(t, dataprovider.LoginMethodTLSCertificate, loginMethod)
+
+	req.SetBasicAuth("user3", "")
+	username, _, loginMethod, _, ok = server.getCredentialsAndLoginMethod(req)
+	assert.True(t, ok)
+	assert.Equal(t, "user3", username)
+	assert.Equal(t, dataprovider.LoginMethodTLSCertificate, loginMethod)
+
+	req.SetBasicAuth("user3", "pwd")
+	username, password, loginMethod, _, ok = server.getCredentialsAndLoginMethod(req)
+	assert.True(t, ok)
+	assert.Equal(t, "user3", username)
+	assert.Equal(t, "pwd", password)
+	assert.Equal(t, dataprovider.LoginMethodTLSCertificateAndPwd, loginMethod)
+
+	server.binding.TLSUsername = "Invalid"
+	assert.Error(t, server.binding.checkTLSUsername())
+	server.binding.TLSUsername = string(dataprovider.TLSUsernameFingerprint)
+	assert.Error(t, server.binding.checkTLSUsername())
+	server.binding.TLSUsername = ""
+	assert.NoError(t, server.binding.checkTLSUsername())
+}
+
 func TestVerifyTLSConnection(t *testing.T) {
 	oldCertMgr := certMgr
 
@@ -0,0 +1,444 @@
+package webdavd
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/v2/dataprovider"
+	"github.com/drakkan/sftpgo/v2/logger"
+	"github.com/drakkan/sftpgo/v2/util"
+	"github.com/drakkan/sftpgo/v2/vfs"
+)
+
+const locksCleanupInterval = 10 * time.Minute
+
+var (
+	locksCleanupTicker     *time.Ticker
+	locksCleanupTickerDone chan bool
+	activeLocks            = locksRegistry{
+		temporary: make(map[string]dataprovider.WebDAVLock),
+		held:      make(map[string]bool),
+	}
+)
+
+// locksRegistry tracks the locks that only make sense within this process:
+// the temporary locks the WebDAV handler takes for the duration of a single
+// request and the locks currently held by a request
+type locksRegistry struct {
+	sync.Mutex
+	temporary map[string]dataprovider.WebDAVLock
+	held      map[string]bool
+}
+
+// lockSystem implements webdav.LockSystem and webdav.LockDeleter.
+// Locks are keyed by the storage and the filesystem path so users sharing a
+// virtual folder see each other's locks. Locks explicitly requested using the
+// LOCK method are saved in the data provider while the temporary locks the
+// handler takes for other methods are kept in memory.
+// A new lockSystem is created for each request
+type lockSystem struct {
+	connection *Connection
+	persistent bool
+	// locks for each storage, used to avoid repeated queries while listing directories
+	locks map[string][]dataprovider.WebDAVLock
+}
+
+func newLockSystem(connection *Connection, persistent bool) *lockSystem {
+	return &lockSystem{
+		connection: connection,
+		persistent: persistent,
+		locks:      make(map[string][]dataprovider.WebDAVLock),
+	}
+}
+
+func (ls *lockSystem) resolve(name string) (string, string, error) {
+	fs, fsPath, err := ls.connection.GetFsAndResolvedPath(util.CleanPath(name))
+	if err != nil {
+		return "", "", err
+	}
+	return vfs.GetStorageID(fs), getPropertiesPath(fsPath), nil
+}
+
+// removeStaleLocks removes the stored locks for paths that no longer exist.
+// The WebDAV handler creates the locked resource if it is missing, so a lock for
+// a missing path means that the path was removed without using WebDAV.
+// The caller must hold the registry lock
+func (ls *lockSystem) removeStaleLocks(fs vfs.Fs, locks []dataprovider.WebDAVLock) []dataprovider.WebDAVLock {
+	var result []dataprovider.WebDAVLock
+	for _, lock := range locks {
+		if _, ok := activeLocks.temporary[lock.Token]; !ok {
+			if _, err := fs.Lstat(lock.Path); err != nil && fs.IsNotExist(err) {
+				ls.connection.Log(logger.LevelDebug, "removing WebDAV lock %#v for missing path %#v", lock.Token, lock.Path)
+				if err := dataprovider.DeleteWebDAVLock(lock.Token); err == nil {
+					continue
+				}
+			}
+		}
+		result = append(result, lock)
+	}
+	return result
+}
+
+// getLocks returns the not expired locks for the specified storage,
+// the caller must hold the registry lock if includeTemporary is true
+func (ls *lockSystem) getLocks(now time.Time, storage string, includeTemporary bool) ([]dataprovider.WebDAVLock, error) {
+	stored, err := dataprovider.GetWebDAVLocks(storage)
+	if err != nil {
+		return nil, err
+	}
+	var locks []dataprovider.WebDAVLock
+	for _, lock := range stored {
+		if !lock.IsExpired(now) {
+			locks = append(locks, lock)
+		}
+	}
+	if includeTemporary {
+		for _, lock := range activeLocks.temporary {
+			if lock.Storage == storage {
+				locks = append(locks, lock)
+			}
+		}
+	}
+	return locks, nil
+}
+
+// getLock returns the not expired lock with the specified token,
+// the caller must hold the registry lock
+func (ls *lockSystem) getLock(now time.Time, token string) (dataprovider.WebDAVLock, bool, error) {
+	if lock, ok := activeLocks.temporary[token]; ok {
+		return lock, false, nil
+	}
+	lock, err := dataprovider.GetWebDAVLock(token)
+	if err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return lock, true, webdav.ErrNoSuchLock
+		}
+		return lock, true, err
+	}
+	if lock.IsExpired(now) {
+		return lock, true, webdav.ErrNoSuchLock
+	}
+	return lock, true, nil
+}
+
+// Confirm confirms that the caller can claim all of the locks specified by
+// the given conditions, and that holding the union of all of those locks
+// gives exclusive access to all of the named resources
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	activeLocks.Lock()
+	defer activeLocks.Unlock()
+
+	var tokens []string
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		token, err := ls.lookup(now, name, conditions...)
+		if err != nil {
+			return nil, err
+		}
+		if !util.IsStringInSlice(token, tokens) {
+			tokens = append(tokens, token)
+		}
+	}
+	for _, token := range tokens {
+		activeLocks.held[token] = true
+	}
+
+	return func() {
+		activeLocks.Lock()
+		defer activeLocks.Unlock()
+
+		for _, token := range tokens {
+			delete(activeLocks.held, token)
+		}
+	}, nil
+}
+
+// lookup returns the token of the first lock, among the given conditions,
+// that is not held and applies to the specified name.
+// The caller must hold the registry lock
+func (ls *lockSystem) lookup(now time.Time, name string, conditions ...webdav.Condition) (string, error) {
+	storage, fsPath, err := ls.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range conditions {
+		if c.Token == "" || activeLocks.held[c.Token] {
+			continue
+		}
+		lock, _, err := ls.getLock(now, c.Token)
+		if err != nil {
+			if errors.Is(err, webdav.ErrNoSuchLock) {
+				continue
+			}
+			return "", err
+		}
+		if lock.Storage != storage || !ls.isOwner(&lock) {
+			continue
+		}
+		if lock.Path == fsPath || (!lock.ZeroDepth && isLockPathMatch(fsPath, lock.Path)) {
+			return c.Token, nil
+		}
+	}
+	return "", webdav.ErrConfirmationFailed
+}
+
+// Create creates a lock with the given depth, duration, owner and root
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	fs, resolvedPath, err := ls.connection.GetFsAndResolvedPath(util.CleanPath(details.Root))
+	if err != nil {
+		return "", err
+	}
+	storage := vfs.GetStorageID(fs)
+	fsPath := getPropertiesPath(resolvedPath)
+
+	activeLocks.Lock()
+	defer activeLocks.Unlock()
+
+	locks, err := ls.getLocks(now, storage, true)
+	if err != nil {
+		return "", err
+	}
+	if !canCreateLock(locks, fsPath, details.ZeroDepth) {
+		if !canCreateLock(ls.removeStaleLocks(fs, locks), fsPath, details.ZeroDepth) {
+			return "", webdav.ErrLocked
+		}
+	}
+	lock := dataprovider.WebDAVLock{
+		Token:     "opaquelocktoken:" + uuid.NewString(),
+		Storage:   storage,
+		Path:      fsPath,
+		Root:      details.Root,
+		Username:  ls.connection.User.Username,
+		Owner:     details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+		ExpiresAt: getLockExpiration(now, details.Duration),
+	}
+	if !ls.persistent {
+		lock.CreatedAt = util.GetTimeAsMsSinceEpoch(now)
+		activeLocks.temporary[lock.Token] = lock
+		return lock.Token, nil
+	}
+	if err := dataprovider.AddWebDAVLock(&lock); err != nil {
+		// the path could be locked from another instance in the meantime
+		locks, errLocks := ls.getLocks(now, storage, true)
+		if errLocks == nil && !canCreateLock(locks, fsPath, details.ZeroDepth) {
+			return "", webdav.ErrLocked
+		}
+		ls.connection.Log(logger.LevelError, "unable to save WebDAV lock for path %#v: %v", fsPath, err)
+		return "", err
+	}
+	ls.connection.Log(logger.LevelDebug, "WebDAV lock %#v created for path %#v, storage %#v", lock.Token, fsPath, storage)
+	return lock.Token, nil
+}
+
+// Refresh refreshes the lock with the given token
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	activeLocks.Lock()
+	defer activeLocks.Unlock()
+
+	lock, isPersistent, err := ls.getLock(now, token)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	if !ls.isOwner(&lock) {
+		ls.connection.Log(logger.LevelInfo, "refusing to refresh WebDAV lock %#v owned by user %#v", token, lock.Username)
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if activeLocks.held[token] {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	lock.ExpiresAt = getLockExpiration(now, duration)
+	if isPersistent {
+		if err := dataprovider.UpdateWebDAVLockExpiration(token, lock.ExpiresAt); err != nil {
+			return webdav.LockDetails{}, err
+		}
+	} else {
+		activeLocks.temporary[token] = lock
+	}
+	return webdav.LockDetails{
+		Root:      lock.Root,
+		Duration:  duration,
+		OwnerXML:  lock.Owner,
+		ZeroDepth: lock.ZeroDepth,
+	}, nil
+}
+
+// Unlock unlocks the lock with the given token
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	activeLocks.Lock()
+	defer activeLocks.Unlock()
+
+	lock, isPersistent, err := ls.getLock(now, token)
+	if err != nil {
+		return err
+	}
+	if !ls.isOwner(&lock) {
+		ls.connection.Log(logger.LevelInfo, "refusing to remove WebDAV lock %#v owned by user %#v", token, lock.Username)
+		return webdav.ErrForbidden
+	}
+	if activeLocks.held[token] {
+		return webdav.ErrLocked
+	}
+	if !isPersistent {
+		delete(activeLocks.temporary, token)
+		return nil
+	}
+	if err := dataprovider.DeleteWebDAVLock(token); err != nil {
+		if _, ok := err.(*util.RecordNotFoundError); ok {
+			return webdav.ErrNoSuchLock
+		}
+		return err
+	}
+	ls.connection.Log(logger.LevelDebug, "WebDAV lock %#v removed", token)
+	return nil
+}
+
+// GetByName returns the lock for the given name, if the name is not locked
+// directly the nearest ancestor with an infinite depth lock is returned.
+// The token is only returned to the lock owner, other users only see
+// that the resource is locked
+func (ls *lockSystem) GetByName(name string) (string, time.Time, webdav.LockDetails, error) {
+	name = util.CleanPath(name)
+	storage, fsPath, err := ls.resolve(name)
+	if err != nil {
+		return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	locks, ok := ls.locks[storage]
+	if !ok {
+		locks, err = ls.getLocks(time.Now(), storage, false)
+		if err != nil {
+			ls.connection.Log(logger.LevelError, "unable to get WebDAV locks for storage %#v: %v", storage, err)
+			return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+		}
+		ls.locks[storage] = locks
+	}
+	var found *dataprovider.WebDAVLock
+	for idx := range locks {
+		lock := &locks[idx]
+		if lock.Path == fsPath {
+			found = lock
+			break
+		}
+		if !lock.ZeroDepth && isLockPathMatch(fsPath, lock.Path) {
+			if found == nil || len(lock.Path) > len(found.Path) {
+				found = lock
+			}
+		}
+	}
+	if found == nil {
+		return "", time.Time{}, webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	// the lock root is reported as seen by the current user
+	root := name
+	if found.Path != fsPath {
+		root = util.CleanPath(strings.TrimSuffix(name, strings.TrimPrefix(fsPath, found.Path)))
+	}
+	details := webdav.LockDetails{
+		Root:      root,
+		Duration:  -1,
+		OwnerXML:  found.Owner,
+		ZeroDepth: found.ZeroDepth,
+	}
+	var expiration time.Time
+	if found.ExpiresAt > 0 {
+		expiration = util.GetTimeFromMsecSinceEpoch(found.ExpiresAt)
+		details.Duration = time.Until(expiration)
+	}
+	if !ls.isOwner(found) {
+		return "", expiration, details, nil
+	}
+	return found.Token, expiration, details, nil
+}
+
+// isOwner returns true if the lock was created by the connected user
+func (ls *lockSystem) isOwner(lock *dataprovider.WebDAVLock) bool {
+	return lock.Username == ls.connection.User.Username
+}
+
+// Delete removes all the stored locks rooted at name
+func (ls *lockSystem) Delete(now time.Time, name string) error {
+	storage, fsPath, err := ls.resolve(name)
+	if err != nil {
+		return err
+	}
+	return dataprovider.DeleteWebDAVLocks(storage, fsPath)
+}
+
+// canCreateLock returns false if the specified path is already locked, if it
+// is inside a path locked with infinite depth or if an infinite depth lock is
+// requested and a path inside it is locked
+func canCreateLock(locks []dataprovider.WebDAVLock, fsPath string, zeroDepth bool) bool {
+	for _, lock := range locks {
+		if lock.Path == fsPath {
+			return false
+		}
+		if !zeroDepth && isLockPathMatch(lock.Path, fsPath) {
+			return false
+		}
+		if !lock.ZeroDepth && isLockPathMatch(fsPath, lock.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// isLockPathMatch returns true if fsPath is equal to or inside the specified path
+func isLockPathMatch(fsPath, basePath string) bool {
+	return fsPath == basePath || strings.HasPrefix(fsPath, strings.TrimSuffix(basePath, "/")+"/")
+}
+
+func getLockExpiration(now time.Time, duration time.Duration) int64 {
+	if duration < 0 {
+		return 0
+	}
+	return util.GetTimeAsMsSinceEpoch(now.Add(duration))
+}
+
+func startLocksCleanupTicker(duration time.Duration) {
+	stopLocksCleanupTicker()
+	locksCleanupTicker = time.NewTicker(duration)
+	locksCleanupTickerDone = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-locksCleanupTickerDone:
+				return
+			case <-locksCleanupTicker.C:
+				cleanupExpiredLocks()
+			}
+		}
+	}()
+}
+
+func stopLocksCleanupTicker() {
+	if locksCleanupTicker != nil {
+		locksCleanupTicker.Stop()
+		locksCleanupTickerDone <- true
+		locksCleanupTicker = nil
+	}
+}
+
+func cleanupExpiredLocks() {
+	now := time.Now()
+	logger.Debug(logSender, "", "removing WebDAV locks expired before %v", now)
+	if err := dataprovider.CleanupWebDAVLocks(now); err != nil {
+		logger.Warn(logSender, "", "unable to remove expired WebDAV locks: %v", err)
+		return
+	}
+
+	activeLocks.Lock()
+	defer activeLocks.Unlock()
+
+	for token, lock := range activeLocks.temporary {
+		if lock.IsExpired(now) && !activeLocks.held[token] {
+			delete(activeLocks.temporary, token)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -31,7 +32,7 @@ type webDavServer struct {
 	binding Binding
 }
 
-func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
+func (s *webDavServer) listenAndServe(b *common.ServerBinding, compressor *middleware.Compressor) error {
 	handler := compressor.Handler(s)
 	httpServer := &http.Server{
 		ReadHeaderTimeout: 30 * time.Second,
@@ -40,6 +41,11 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 16, // 64KB
 		ErrorLog:          log.New(&logger.StdLoggerWrapper{Sender: logSender}, "", 0),
+		BaseContext: func(_ net.Listener) context.Context {
+			// called when the listener is ready to accept connections
+			common.SetBindingStatus(common.ProtocolWebDAV, s.binding.GetAddress(), nil)
+			return context.Background()
+		},
 	}
 	if s.config.Cors.Enabled {
 		c := cors.New(cors.Options{
@@ -55,7 +61,6 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 	}
 	httpServer.Handler = handler
 	if certMgr != nil && s.binding.EnableHTTPS {
-		serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
 		httpServer.TLSConfig = &tls.Config{
 			GetCertificate:           certMgr.GetCertificateFunc(),
 			MinVersion:               tls.VersionTLS12,
@@ -75,11 +80,19 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 				httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
-		return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+	} else {
+		s.binding.EnableHTTPS = false
+	}
+	if err := b.AddHTTPServer(httpServer); err != nil {
+		return nil
 	}
-	s.binding.EnableHTTPS = false
-	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
-	return util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+	err := util.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, s.binding.EnableHTTPS, logSender)
+	if b.IsStopped() {
+		common.RemoveBindingStatus(common.ProtocolWebDAV, s.binding.GetAddress())
+		return nil
+	}
+	common.SetBindingStatus(common.ProtocolWebDAV, s.binding.GetAddress(), err)
+	return err
 }
 
 func (s *webDavServer) verifyTLSConnection(state tls.ConnectionState) error {
@@ -145,6 +158,12 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	common.Connections.AddClientConnection(ipAddr)
 	defer common.Connections.RemoveClientConnection(ipAddr)
 
+	if common.IsDraining() {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "connection refused, the server is shutting down")
+		w.Header().Set("Retry-After", common.DrainRetryAfter)
+		http.Error(w, common.ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if !common.Connections.IsNewConnectionAllowed(ipAddr) {
 		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "connection refused, configured limit reached")
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusServiceUnavailable)
@@ -162,11 +181,11 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusTooManyRequests)
 		return
 	}
-	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolWebDAV); err != nil {
+	if err := common.GetConfig().ExecutePostConnectHook(ipAddr, common.ProtocolWebDAV); err != nil {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
-	user, isCached, lockSystem, loginMethod, err := s.authenticate(r, ipAddr)
+	user, isCached, loginMethod, err := s.authenticate(r, ipAddr)
 	if err != nil {
 		updateLoginMetrics(&user, ipAddr, loginMethod, err)
 		w.Header().Set("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
@@ -219,15 +238,98 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodPut {
+		name := strings.TrimPrefix(r.URL.Path, s.binding.Prefix)
+		if !checkPutPreconditions(ctx, r, connection, name) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			writeLog(r, http.StatusPreconditionFailed, nil)
+			return
+		}
+		w = &putResponseWriter{
+			ResponseWriter: w,
+			ctx:            ctx,
+			connection:     connection,
+			name:           name,
+		}
+	}
+
 	handler := webdav.Handler{
 		Prefix:     s.binding.Prefix,
 		FileSystem: connection,
-		LockSystem: lockSystem,
+		LockSystem: newLockSystem(connection, r.Method == "LOCK"),
 		Logger:     writeLog,
 	}
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
+// checkPutPreconditions evaluates the If-Match and If-None-Match headers
+// for the specified path, see RFC 7232 section 3
+func checkPutPreconditions(ctx context.Context, r *http.Request, connection *Connection, name string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+	etag := ""
+	info, err := connection.Stat(ctx, name)
+	if err == nil && !info.IsDir() {
+		etag = getETag(info)
+	}
+	if ifMatch != "" {
+		if etag == "" {
+			return false
+		}
+		if strings.TrimSpace(ifMatch) != "*" && !isETagInList(ifMatch, etag, false) {
+			return false
+		}
+	}
+	if ifNoneMatch != "" && etag != "" {
+		if strings.TrimSpace(ifNoneMatch) == "*" || isETagInList(ifNoneMatch, etag, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// isETagInList returns true if the etag matches an entity tag in the specified
+// list. Weak entity tags never match using the strong comparison
+func isETagInList(list, etag string, weak bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// putResponseWriter sets the ETag for the uploaded file. The WebDAV handler
+// sets the ETag before closing the file, so it could not match the stored file
+type putResponseWriter struct {
+	http.ResponseWriter
+	ctx        context.Context
+	connection *Connection
+	name       string
+}
+
+func (w *putResponseWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusCreated {
+		info, err := w.connection.Stat(w.ctx, w.name)
+		if err == nil {
+			w.Header().Set("ETag", getETag(info))
+		} else {
+			w.Header().Del("ETag")
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
 func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, string, string, *x509.Certificate, bool) {
 	var tlsCert *x509.Certificate
 	loginMethod := dataprovider.LoginMethodPassword
@@ -235,11 +337,15 @@ func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, st
 	if s.binding.isMutualTLSEnabled() && r.TLS != nil {
 		if len(r.TLS.PeerCertificates) > 0 {
 			tlsCert = r.TLS.PeerCertificates[0]
-			if ok {
+			if ok && password != "" {
 				loginMethod = dataprovider.LoginMethodTLSCertificateAndPwd
 			} else {
+				// a username without a password can be used to identify users whose
+				// certificates are not mapped to a username, for example by fingerprint
 				loginMethod = dataprovider.LoginMethodTLSCertificate
-				username = tlsCert.Subject.CommonName
+				if !ok || username == "" {
+					username = dataprovider.GetUsernameFromTLSCertificate(tlsCert, s.binding.getTLSUsername())
+				}
 				password = ""
 			}
 			ok = true
@@ -248,13 +354,13 @@ func (s *webDavServer) getCredentialsAndLoginMethod(r *http.Request) (string, st
 	return username, password, loginMethod, tlsCert, ok
 }
 
-func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.User, bool, webdav.LockSystem, string, error) {
+func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.User, bool, string, error) {
 	var user dataprovider.User
 	var err error
 	username, password, loginMethod, tlsCert, ok := s.getCredentialsAndLoginMethod(r)
 	if !ok {
 		user.Username = username
-		return user, false, nil, loginMethod, common.ErrNoCredentials
+		return user, false, loginMethod, common.ErrNoCredentials
 	}
 	cachedUser, ok := dataprovider.GetCachedWebDAVUser(username)
 	if ok {
@@ -267,10 +373,10 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 				loginMethod = dataprovider.LoginMethodPassword
 			}
 			if err := dataprovider.CheckCachedUserCredentials(cachedUser, password, loginMethod, common.ProtocolWebDAV, tlsCert); err == nil {
-				return cachedUser.User, true, cachedUser.LockSystem, loginMethod, nil
+				return cachedUser.User, true, loginMethod, nil
 			}
 			updateLoginMetrics(&cachedUser.User, ip, loginMethod, dataprovider.ErrInvalidCredentials)
-			return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
+			return user, false, loginMethod, dataprovider.ErrInvalidCredentials
 		}
 	}
 	user, loginMethod, err = dataprovider.CheckCompositeCredentials(username, password, ip, loginMethod,
@@ -278,19 +384,17 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ip, loginMethod, err)
-		return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
+		return user, false, loginMethod, dataprovider.ErrInvalidCredentials
 	}
-	lockSystem := webdav.NewMemLS()
 	cachedUser = &dataprovider.CachedUser{
-		User:       user,
-		Password:   password,
-		LockSystem: lockSystem,
+		User:     user,
+		Password: password,
 	}
 	if s.config.Cache.Users.ExpirationTime > 0 {
 		cachedUser.Expiration = time.Now().Add(time.Duration(s.config.Cache.Users.ExpirationTime) * time.Minute)
 	}
 	dataprovider.CacheWebDAVUser(cachedUser)
-	return user, false, lockSystem, loginMethod, nil
+	return user, false, loginMethod, nil
 }
 
 func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, loginMethod string) (string, error) {
@@ -2,11 +2,15 @@
 package webdavd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"path/filepath"
+	"sync"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sftpgo/sdk"
 
 	"github.com/drakkan/sftpgo/v2/common"
 	"github.com/drakkan/sftpgo/v2/dataprovider"
@@ -29,8 +33,33 @@ var (
 	//server *webDavServer
 	certMgr       *common.CertManager
 	serviceStatus ServiceStatus
+	servers       common.ServerGroup
+	runningServer runningServerConfig
 )
 
+// runningServerConfig stores the configuration used to start the server, it
+// is required to start the new bindings on configuration reload
+type runningServerConfig struct {
+	sync.RWMutex
+	config     *Configuration
+	compressor *middleware.Compressor
+}
+
+func (r *runningServerConfig) set(config *Configuration, compressor *middleware.Compressor) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+	r.compressor = compressor
+}
+
+func (r *runningServerConfig) get() (*Configuration, *middleware.Compressor) {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.config, r.compressor
+}
+
 // ServiceStatus defines the service status
 type ServiceStatus struct {
 	IsActive bool      `json:"is_active"`
@@ -60,6 +89,14 @@ type MimeCacheConfig struct {
 	MaxSize int  `json:"max_size" mapstructure:"max_size"`
 }
 
+// DeadPropertiesConfig defines the limits for the dead properties stored for each path
+type DeadPropertiesConfig struct {
+	// Maximum number of dead properties for each path. 0 means unlimited
+	MaxCount int `json:"max_count" mapstructure:"max_count"`
+	// Maximum size, in bytes, of the dead properties for each path. 0 means unlimited
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+}
+
 // Cache configuration
 type Cache struct {
 	Users     UsersCacheConfig `json:"users" mapstructure:"users"`
@@ -92,10 +129,25 @@ type Binding struct {
 	// root ("/") URI. If defined it must be an absolute URI.
 	Prefix string `json:"prefix" mapstructure:"prefix"`
 	// List of IP addresses and IP ranges allowed to set X-Forwarded-For/X-Real-IP headers.
-	ProxyAllowed     []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// TLS certificate attribute to use as username for clients authenticating
+	// using a certificate without providing a username.
+	// Supported values: "CommonName", "SANEmail", "SANURI", "SerialNumber".
+	// Empty means "CommonName"
+	TLSUsername      string `json:"tls_username" mapstructure:"tls_username"`
 	allowHeadersFrom []func(net.IP) bool
 }
 
+func (b *Binding) checkTLSUsername() error {
+	switch sdk.TLSUsername(b.TLSUsername) {
+	case "", sdk.TLSUsernameCN, dataprovider.TLSUsernameSANEmail, dataprovider.TLSUsernameSANURI,
+		dataprovider.TLSUsernameSerialNumber:
+		return nil
+	default:
+		return fmt.Errorf("unsupported tls_username %#v", b.TLSUsername)
+	}
+}
+
 func (b *Binding) parseAllowedProxy() error {
 	allowedFuncs, err := util.ParseAllowedIPAndRanges(b.ProxyAllowed)
 	if err != nil {
@@ -105,6 +157,13 @@ func (b *Binding) parseAllowedProxy() error {
 	return nil
 }
 
+func (b *Binding) getTLSUsername() sdk.TLSUsername {
+	if b.TLSUsername == "" {
+		return sdk.TLSUsernameCN
+	}
+	return sdk.TLSUsername(b.TLSUsername)
+}
+
 func (b *Binding) isMutualTLSEnabled() bool {
 	return b.ClientAuthType == 1 || b.ClientAuthType == 2
 }
@@ -119,6 +178,16 @@ func (b *Binding) IsValid() bool {
 	return b.Port > 0
 }
 
+// getStatus returns the binding to report in the service status, HTTPS is
+// disabled if no certificate is configured
+func (b *Binding) getStatus() Binding {
+	binding := *b
+	if certMgr == nil {
+		binding.EnableHTTPS = false
+	}
+	return binding
+}
+
 // Configuration defines the configuration for the WevDAV server
 type Configuration struct {
 	// Addresses and ports to bind to
@@ -138,6 +207,8 @@ type Configuration struct {
 	Cors CorsConfig `json:"cors" mapstructure:"cors"`
 	// Cache configuration
 	Cache Cache `json:"cache" mapstructure:"cache"`
+	// Limits for the dead properties set using PROPPATCH
+	DeadProperties DeadPropertiesConfig `json:"dead_properties" mapstructure:"dead_properties"`
 }
 
 // GetStatus returns the server status
@@ -145,6 +216,12 @@ func GetStatus() ServiceStatus {
 	return serviceStatus
 }
 
+// Stop closes the WebDAV server listeners, the in-flight requests are completed
+// before closing the connections. The server can be initialized again after Stop returns
+func Stop(ctx context.Context) error {
+	return servers.Stop(ctx)
+}
+
 // ShouldBind returns true if there is at least a valid binding
 func (c *Configuration) ShouldBind() bool {
 	for _, binding := range c.Bindings {
@@ -166,10 +243,12 @@ func (c *Configuration) Initialize(configDir string) error {
 	if !c.Cache.MimeTypes.Enabled {
 		mimeTypeCache.maxSize = 0
 	}
+	deadPropertiesLimits = c.DeadProperties
 	if !c.ShouldBind() {
 		return common.ErrNoBinding
 	}
 
+	certMgr = nil
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
 	if certificateFile != "" && certificateKeyFile != "" {
@@ -189,12 +268,14 @@ func (c *Configuration) Initialize(configDir string) error {
 	}
 	compressor := middleware.NewCompressor(5, "text/*")
 	dataprovider.InitializeWebDAVUserCache(c.Cache.Users.MaxSize)
+	startLocksCleanupTicker(locksCleanupInterval)
 
 	serviceStatus = ServiceStatus{
 		Bindings: nil,
 	}
 
-	exitChannel := make(chan error, 1)
+	servers.Reset()
+	runningServer.set(c, compressor)
 
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
@@ -203,19 +284,81 @@ func (c *Configuration) Initialize(configDir string) error {
 		if err := binding.parseAllowedProxy(); err != nil {
 			return err
 		}
+		if err := binding.checkTLSUsername(); err != nil {
+			return err
+		}
+		servers.GoBinding(c.getBindingStarter(binding, compressor))
+		serviceStatus.Bindings = append(serviceStatus.Bindings, binding.getStatus())
+	}
+
+	serviceStatus.IsActive = true
 
-		go func(binding Binding) {
+	return servers.Wait()
+}
+
+// ReloadBindings stops the removed bindings and starts the added ones, the
+// other bindings are not affected and the in-flight requests are completed.
+// The other configuration settings must be unchanged
+func ReloadBindings(ctx context.Context, bindings []Binding) error {
+	c, compressor := runningServer.get()
+	if c == nil {
+		return errors.New("the WebDAV server is not running")
+	}
+	var removed, added []common.BindingStarter
+	var statusBindings []Binding
+	for _, binding := range c.Bindings {
+		if binding.IsValid() && !isBindingInList(binding, bindings) {
+			if err := binding.parseAllowedProxy(); err != nil {
+				return err
+			}
+			removed = append(removed, c.getBindingStarter(binding, compressor))
+		}
+	}
+	for _, binding := range bindings {
+		if !binding.IsValid() {
+			continue
+		}
+		if err := binding.parseAllowedProxy(); err != nil {
+			return err
+		}
+		if err := binding.checkTLSUsername(); err != nil {
+			return err
+		}
+		statusBindings = append(statusBindings, binding.getStatus())
+		if !isBindingInList(binding, c.Bindings) {
+			added = append(added, c.getBindingStarter(binding, compressor))
+		}
+	}
+	if err := servers.ReplaceBindings(ctx, removed, added); err != nil {
+		return err
+	}
+	conf := *c
+	conf.Bindings = bindings
+	runningServer.set(&conf, compressor)
+	serviceStatus.Bindings = statusBindings
+	return nil
+}
+
+func isBindingInList(binding Binding, bindings []Binding) bool {
+	for _, b := range bindings {
+		if common.IsSameBinding(b, binding) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Configuration) getBindingStarter(binding Binding, compressor *middleware.Compressor) common.BindingStarter {
+	return common.BindingStarter{
+		Key: binding.GetAddress(),
+		Start: func(b *common.ServerBinding) error {
 			server := webDavServer{
 				config:  c,
 				binding: binding,
 			}
-			exitChannel <- server.listenAndServe(compressor)
-		}(binding)
+			return server.listenAndServe(b, compressor)
+		},
 	}
-
-	serviceStatus.IsActive = true
-
-	return <-exitChannel
 }
 
 // ReloadCertificateMgr reloads the certificate manager
@@ -26,6 +26,7 @@ import (
 	"github.com/sftpgo/sdk"
 	sdkkms "github.com/sftpgo/sdk/kms"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/studio-b12/gowebdav"
 
 	"github.com/drakkan/sftpgo/v2/common"
@@ -36,6 +37,7 @@ import (
 	"github.com/drakkan/sftpgo/v2/kms"
 	"github.com/drakkan/sftpgo/v2/logger"
 	"github.com/drakkan/sftpgo/v2/sftpd"
+	"github.com/drakkan/sftpgo/v2/util"
 	"github.com/drakkan/sftpgo/v2/vfs"
 	"github.com/drakkan/sftpgo/v2/webdavd"
 )
@@ -438,6 +440,7 @@ func TestInitialization(t *testing.T) {
 	assert.Error(t, err)
 
 	cfg.Cache = config.GetWebDAVDConfig().Cache
+	cfg.DeadProperties = config.GetWebDAVDConfig().DeadProperties
 	cfg.Bindings[0].Port = webDavServerPort
 	cfg.CertificateFile = certPath
 	cfg.CertificateKeyFile = keyPath
@@ -761,6 +764,178 @@ func TestRenameWithLock(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSharedFolderLocks(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "shared")
+	folderName := filepath.Base(mappedPath)
+	u1 := getTestUser()
+	u1.Username += "1"
+	u2 := getTestUser()
+	u2.Username += "2"
+	for _, u := range []*dataprovider.User{&u1, &u2} {
+		u.HomeDir = filepath.Join(homeBasePath, u.Username)
+		u.VirtualFolders = append(u.VirtualFolders, vfs.VirtualFolder{
+			BaseVirtualFolder: vfs.BaseVirtualFolder{
+				Name:       folderName,
+				MappedPath: mappedPath,
+			},
+			VirtualPath: "/vdir" + strings.TrimPrefix(u.Username, defaultUsername),
+			QuotaFiles:  -1,
+			QuotaSize:   -1,
+		})
+	}
+	user1, _, err := httpdtest.AddUser(u1, http.StatusCreated)
+	assert.NoError(t, err)
+	user2, _, err := httpdtest.AddUser(u2, http.StatusCreated)
+	assert.NoError(t, err)
+
+	client := getWebDavClient(user1, false, nil)
+	assert.NoError(t, checkBasicFunc(client))
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	err = uploadFile(testFilePath, path.Join("/vdir1", testFileName), testFileSize, client)
+	assert.NoError(t, err)
+
+	doRequest := func(method string, user dataprovider.User, name, lockToken string, body []byte) (int, []byte) {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%v%v", webDavServerAddr, name), bytes.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth(user.Username, defaultPassword)
+		if lockToken != "" {
+			req.Header.Set("If", fmt.Sprintf("(<%v>)", lockToken))
+		}
+		if method == "UNLOCK" {
+			req.Header.Set("Lock-Token", fmt.Sprintf("<%v>", lockToken))
+			req.Header.Del("If")
+		}
+		if method == "PROPFIND" {
+			req.Header.Set("Depth", "0")
+		}
+		resp, err := httpclient.GetHTTPClient().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		response, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, response
+	}
+
+	lockBody := `<?xml version="1.0" encoding="utf-8" ?><d:lockinfo xmlns:d="DAV:"><d:lockscope><d:exclusive/></d:lockscope><d:locktype><d:write/></d:locktype></d:lockinfo>`
+	status, response := doRequest("LOCK", user1, path.Join("/vdir1", testFileName), "", []byte(lockBody))
+	assert.Equal(t, http.StatusOK, status)
+	re := regexp.MustCompile(`<D:locktoken><D:href>(.*)</D:href>`)
+	matches := re.FindStringSubmatch(string(response))
+	require.Len(t, matches, 2)
+	lockToken := matches[1]
+	status, response = doRequest("PROPFIND", user1, path.Join("/vdir1", testFileName), "", nil)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, string(response), lockToken)
+	// the second user sees the lock from its own virtual path, without the token
+	status, response = doRequest("PROPFIND", user2, path.Join("/vdir2", testFileName), "", nil)
+	assert.Equal(t, http.StatusMultiStatus, status)
+	assert.Contains(t, string(response), "<D:activelock>")
+	assert.NotContains(t, string(response), lockToken)
+	assert.Contains(t, string(response), path.Join("/vdir2", testFileName))
+	// and cannot modify or lock the file without the lock token
+	status, _ = doRequest(http.MethodPut, user2, path.Join("/vdir2", testFileName), "", []byte("data"))
+	assert.Equal(t, http.StatusLocked, status)
+	status, _ = doRequest("LOCK", user2, path.Join("/vdir2", testFileName), "", []byte(lockBody))
+	assert.Equal(t, http.StatusLocked, status)
+	// locking the parent directory with infinite depth must fail too
+	status, _ = doRequest("LOCK", user2, "/vdir2", "", []byte(lockBody))
+	assert.Equal(t, http.StatusLocked, status)
+	// the lock is persisted, removing the cached users does not affect it
+	dataprovider.RemoveCachedWebDAVUser(user1.Username)
+	dataprovider.RemoveCachedWebDAVUser(user2.Username)
+	status, _ = doRequest(http.MethodPut, user2, path.Join("/vdir2", testFileName), "", []byte("data"))
+	assert.Equal(t, http.StatusLocked, status)
+	// the lock token cannot be used by a different user
+	status, _ = doRequest(http.MethodPut, user2, path.Join("/vdir2", testFileName), lockToken, []byte("data"))
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	status, _ = doRequest("LOCK", user2, path.Join("/vdir2", testFileName), lockToken, nil)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	status, _ = doRequest("UNLOCK", user2, path.Join("/vdir2", testFileName), lockToken, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = doRequest(http.MethodPut, user1, path.Join("/vdir1", testFileName), lockToken, []byte("data"))
+	assert.Equal(t, http.StatusCreated, status)
+	status, _ = doRequest("LOCK", user1, path.Join("/vdir1", testFileName), lockToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = doRequest("UNLOCK", user1, path.Join("/vdir1", testFileName), lockToken, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = doRequest("UNLOCK", user1, path.Join("/vdir1", testFileName), lockToken, nil)
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = doRequest(http.MethodPut, user2, path.Join("/vdir2", testFileName), "", []byte("data"))
+	assert.Equal(t, http.StatusCreated, status)
+
+	_, err = httpdtest.RemoveUser(user1, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user1.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user2, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user2.GetHomeDir())
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(vfs.BaseVirtualFolder{Name: folderName}, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+}
+
+func TestWebDAVLocksStorage(t *testing.T) {
+	storage := "s3://locks"
+	now := time.Now()
+	lock := dataprovider.WebDAVLock{
+		Token:     "opaquelocktoken:1",
+		Storage:   storage,
+		Path:      "dir/file",
+		Root:      "/dir/file",
+		Username:  "lockowner",
+		ExpiresAt: util.GetTimeAsMsSinceEpoch(now.Add(-1 * time.Minute)),
+	}
+	err := dataprovider.AddWebDAVLock(&lock)
+	assert.NoError(t, err)
+	// the lock is expired, so it can be replaced
+	lock.Token = "opaquelocktoken:2"
+	lock.ExpiresAt = 0
+	err = dataprovider.AddWebDAVLock(&lock)
+	assert.NoError(t, err)
+	_, err = dataprovider.GetWebDAVLock("opaquelocktoken:1")
+	assert.Error(t, err)
+	lock.Token = "opaquelocktoken:3"
+	err = dataprovider.AddWebDAVLock(&lock)
+	assert.Error(t, err)
+	lock.Token = "opaquelocktoken:4"
+	lock.Path = "dir1"
+	lock.ZeroDepth = true
+	lock.ExpiresAt = util.GetTimeAsMsSinceEpoch(now.Add(-1 * time.Minute))
+	err = dataprovider.AddWebDAVLock(&lock)
+	assert.NoError(t, err)
+	locks, err := dataprovider.GetWebDAVLocks(storage)
+	assert.NoError(t, err)
+	assert.Len(t, locks, 2)
+	err = dataprovider.CleanupWebDAVLocks(now)
+	assert.NoError(t, err)
+	locks, err = dataprovider.GetWebDAVLocks(storage)
+	assert.NoError(t, err)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, "opaquelocktoken:2", locks[0].Token)
+		assert.Equal(t, "/dir/file", locks[0].Root)
+		assert.Equal(t, "lockowner", locks[0].Username)
+		assert.False(t, locks[0].ZeroDepth)
+	}
+	expiresAt := util.GetTimeAsMsSinceEpoch(now.Add(time.Hour))
+	err = dataprovider.UpdateWebDAVLockExpiration("opaquelocktoken:2", expiresAt)
+	assert.NoError(t, err)
+	lock, err = dataprovider.GetWebDAVLock("opaquelocktoken:2")
+	assert.NoError(t, err)
+	assert.Equal(t, expiresAt, lock.ExpiresAt)
+	err = dataprovider.DeleteWebDAVLocks(storage, "dir")
+	assert.NoError(t, err)
+	_, err = dataprovider.GetWebDAVLock("opaquelocktoken:2")
+	assert.Error(t, err)
+}
+
 func TestPropPatch(t *testing.T) {
 	u := getTestUser()
 	u.Username = u.Username + "1"
@@ -809,6 +984,168 @@ func TestPropPatch(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDeadPropertiesAndETags(t *testing.T) {
+	u := getTestUser()
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	client := getWebDavClient(user, false, nil)
+	testFilePath := filepath.Join(homeBasePath, testFileName)
+	testFileSize := int64(65535)
+	err = createTestFile(testFilePath, testFileSize)
+	assert.NoError(t, err)
+	err = uploadFile(testFilePath, testFileName, testFileSize, client)
+	assert.NoError(t, err)
+
+	httpClient := httpclient.GetHTTPClient()
+	doRequest := func(method, name string, body []byte, headers map[string]string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%v/%v", webDavServerAddr, name), bytes.NewReader(body))
+		require.NoError(t, err)
+		req.SetBasicAuth(u.Username, u.Password)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		response, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, response
+	}
+	propFindBody := []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:prop><D:getetag/><Z:color/><Z:shape/></D:prop></D:propfind>`)
+	propPatchBody := []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:set><D:prop><Z:color>blue</Z:color><Z:shape>circle</Z:shape></D:prop></D:set></D:propertyupdate>`)
+	resp, _ := doRequest("PROPPATCH", testFileName, propPatchBody, nil)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	resp, response := doRequest("PROPFIND", testFileName, propFindBody, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(response), ">blue</")
+	assert.Contains(t, string(response), ">circle</")
+	re := regexp.MustCompile(`<D:getetag>(.*?)</D:getetag>`)
+	matches := re.FindStringSubmatch(string(response))
+	require.Len(t, matches, 2)
+	etag := strings.ReplaceAll(matches[1], "&#34;", `"`)
+	resp, _ = doRequest(http.MethodHead, testFileName, nil, nil)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	// remove a property
+	propPatchBody = []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:remove><D:prop><Z:shape/></D:prop></D:remove></D:propertyupdate>`)
+	resp, _ = doRequest("PROPPATCH", testFileName, propPatchBody, nil)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	props, err := dataprovider.GetWebDAVProperties("file://", filepath.ToSlash(filepath.Join(user.GetHomeDir(), testFileName)))
+	assert.NoError(t, err)
+	assert.Len(t, props.Properties, 1)
+	// conditional uploads
+	content := []byte("new content")
+	resp, _ = doRequest(http.MethodPut, testFileName, content, map[string]string{"If-Match": `"invalid"`})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName, content, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName, content, map[string]string{"If-None-Match": "W/" + etag})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName+"_new", content, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName+"_new", content, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName, content, map[string]string{"If-Match": "W/" + etag})
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = doRequest(http.MethodPut, testFileName, content, map[string]string{"If-Match": `"other", ` + etag})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	newETag := resp.Header.Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+	resp, _ = doRequest(http.MethodHead, testFileName, nil, nil)
+	assert.Equal(t, newETag, resp.Header.Get("ETag"))
+	// dead properties are preserved on overwrite and follow renames
+	resp, _ = doRequest("MOVE", testFileName, nil, map[string]string{
+		"Destination": fmt.Sprintf("http://%v/%v", webDavServerAddr, testFileName+"_renamed"),
+	})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, response = doRequest("PROPFIND", testFileName+"_renamed", propFindBody, map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(response), ">blue</")
+	assert.NotContains(t, string(response), ">circle</")
+	_, err = dataprovider.GetWebDAVProperties("file://", filepath.ToSlash(filepath.Join(user.GetHomeDir(), testFileName)))
+	assert.Error(t, err)
+	resp, _ = doRequest(http.MethodDelete, testFileName+"_renamed", nil, nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, err = dataprovider.GetWebDAVProperties("file://", filepath.ToSlash(filepath.Join(user.GetHomeDir(), testFileName+"_renamed")))
+	assert.Error(t, err)
+	// the number and the size of the dead properties are limited
+	var sb strings.Builder
+	for i := 0; i < 101; i++ {
+		sb.WriteString(fmt.Sprintf("<Z:prop%d>value</Z:prop%d>", i, i))
+	}
+	propPatchBody = []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:set><D:prop>` +
+		sb.String() + `</D:prop></D:set></D:propertyupdate>`)
+	resp, response = doRequest("PROPPATCH", testFileName+"_new", propPatchBody, nil)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(response), "507 Insufficient Storage")
+	propPatchBody = []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:set><D:prop><Z:color>` +
+		strings.Repeat("a", 65536) + `</Z:color></D:prop></D:set></D:propertyupdate>`)
+	resp, response = doRequest("PROPPATCH", testFileName+"_new", propPatchBody, nil)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(response), "507 Insufficient Storage")
+	_, err = dataprovider.GetWebDAVProperties("file://", filepath.ToSlash(filepath.Join(user.GetHomeDir(), testFileName+"_new")))
+	assert.Error(t, err)
+	// setting properties requires the overwrite permission
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload, dataprovider.PermUpload}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	propPatchBody = []byte(`<?xml version="1.0" encoding="utf-8" ?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:custom:"><D:set><D:prop><Z:color>red</Z:color></D:prop></D:set></D:propertyupdate>`)
+	resp, response = doRequest("PROPPATCH", testFileName+"_new", propPatchBody, nil)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, string(response), "403 Forbidden")
+
+	err = os.Remove(testFilePath)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestWebDAVPropertiesTree(t *testing.T) {
+	storage := "s3://bucket"
+	for _, p := range []string{"dir", "dir/file", "dir/sub/file", "dir1/file", "target/file"} {
+		err := dataprovider.SetWebDAVProperties(&dataprovider.WebDAVProperties{
+			Storage: storage,
+			Path:    p,
+			Properties: []dataprovider.WebDAVProperty{
+				{
+					Space:    "urn:custom:",
+					Local:    "name",
+					InnerXML: p,
+				},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	err := dataprovider.RenameWebDAVProperties(storage, "dir", "target")
+	assert.NoError(t, err)
+	for _, p := range []string{"dir", "dir/file", "dir/sub/file"} {
+		_, err = dataprovider.GetWebDAVProperties(storage, p)
+		assert.Error(t, err, p)
+	}
+	// the existing target properties are replaced
+	for p, value := range map[string]string{"target": "dir", "target/file": "dir/file", "target/sub/file": "dir/sub/file",
+		"dir1/file": "dir1/file"} {
+		props, err := dataprovider.GetWebDAVProperties(storage, p)
+		if assert.NoError(t, err, p) && assert.Len(t, props.Properties, 1) {
+			assert.Equal(t, value, props.Properties[0].InnerXML)
+		}
+	}
+	_, err = dataprovider.GetWebDAVProperties("s3://other", "target")
+	assert.Error(t, err)
+	err = dataprovider.DeleteWebDAVProperties(storage, "target")
+	assert.NoError(t, err)
+	_, err = dataprovider.GetWebDAVProperties(storage, "target/sub/file")
+	assert.Error(t, err)
+	err = dataprovider.SetWebDAVProperties(&dataprovider.WebDAVProperties{
+		Storage: storage,
+		Path:    "dir1/file",
+	})
+	assert.NoError(t, err)
+	_, err = dataprovider.GetWebDAVProperties(storage, "dir1/file")
+	assert.Error(t, err)
+}
+
 func TestLoginInvalidPwd(t *testing.T) {
 	u := getTestUser()
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)